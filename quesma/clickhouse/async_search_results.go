@@ -0,0 +1,67 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"quesma/end_user_errors"
+	"time"
+)
+
+// AsyncSearchResult is a result of an async search, as persisted in the async search results table
+type AsyncSearchResult struct {
+	Id           string
+	Added        time.Time
+	Response     []byte
+	IsCompressed bool
+	Error        string // "" <=> search succeeded
+}
+
+const asyncSearchResultsTableColumns = `"id" String, "added" DateTime64(3), "response" String, "is_compressed" Bool, "error" String`
+
+// CreateAsyncSearchResultsTable creates `table` for async search results, if it doesn't exist.
+// ClickHouse drops its rows `ttl` after they've been added.
+func (lm *LogManager) CreateAsyncSearchResultsTable(ctx context.Context, table string, ttl time.Duration) error {
+	createTable := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (%s) ENGINE = MergeTree ORDER BY "id" TTL toDateTime("added") + INTERVAL %d SECOND`,
+		table, asyncSearchResultsTableColumns, int64(ttl.Seconds()))
+	if _, err := lm.chDb.ExecContext(ctx, createTable); err != nil {
+		return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("creating async search results table '%s' failed", table)
+	}
+	return nil
+}
+
+func (lm *LogManager) StoreAsyncSearchResult(ctx context.Context, table string, result AsyncSearchResult) error {
+	insert := fmt.Sprintf(`INSERT INTO "%s" ("id", "added", "response", "is_compressed", "error") VALUES (?, ?, ?, ?, ?)`, table)
+	if _, err := lm.chDb.ExecContext(ctx, insert, result.Id, result.Added, string(result.Response), result.IsCompressed, result.Error); err != nil {
+		return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("insert into async search results table '%s' failed", table)
+	}
+	return nil
+}
+
+// LoadAsyncSearchResult returns the result of async search `id`, if it's been stored in `table` within last `ttl`.
+// TTL is applied by ClickHouse only when merging parts, so we filter expired rows ourselves.
+func (lm *LogManager) LoadAsyncSearchResult(ctx context.Context, table, id string, ttl time.Duration) (result AsyncSearchResult, found bool, err error) {
+	query := fmt.Sprintf(`SELECT "added", "response", "is_compressed", "error" FROM "%s" WHERE "id" = ? AND "added" >= ? ORDER BY "added" DESC LIMIT 1`, table)
+	var response string
+	err = lm.chDb.QueryRowContext(ctx, query, id, time.Now().Add(-ttl)).Scan(&result.Added, &response, &result.IsCompressed, &result.Error)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, false, nil
+	}
+	if err != nil {
+		return result, false, end_user_errors.GuessClickhouseErrorType(err).InternalDetails("reading from async search results table '%s' failed", table)
+	}
+	result.Id = id
+	result.Response = []byte(response)
+	return result, true, nil
+}
+
+func (lm *LogManager) DeleteAsyncSearchResult(ctx context.Context, table, id string) error {
+	deleteQuery := fmt.Sprintf(`DELETE FROM "%s" WHERE "id" = ?`, table)
+	if _, err := lm.chDb.ExecContext(ctx, deleteQuery, id); err != nil {
+		return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("deleting from async search results table '%s' failed", table)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package clickhouse
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"quesma/util"
+	"testing"
+	"time"
+)
+
+func TestAsyncSearchResultsTable(t *testing.T) {
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	defer db.Close()
+	lm := NewLogManagerEmpty()
+	lm.chDb = db
+	ctx := context.Background()
+	added := time.Now().Truncate(time.Millisecond)
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS "async_results" \("id" String, .*\) ENGINE = MergeTree ORDER BY "id" TTL toDateTime\("added"\) \+ INTERVAL 900 SECOND`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "async_results" \("id", "added", "response", "is_compressed", "error"\) VALUES`).
+		WithArgs("quesma_async_search_id_1", added, "response", true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`SELECT "added", "response", "is_compressed", "error" FROM "async_results" WHERE "id" = \? AND "added" >= \?`).
+		WithArgs("quesma_async_search_id_1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"added", "response", "is_compressed", "error"}).AddRow(added, "response", true, ""))
+	mock.ExpectQuery(`SELECT "added", "response", "is_compressed", "error" FROM "async_results"`).
+		WithArgs("quesma_async_search_id_2", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"added", "response", "is_compressed", "error"}))
+	mock.ExpectExec(`DELETE FROM "async_results" WHERE "id" = \?`).
+		WithArgs("quesma_async_search_id_1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, lm.CreateAsyncSearchResultsTable(ctx, "async_results", 15*time.Minute))
+	stored := AsyncSearchResult{Id: "quesma_async_search_id_1", Added: added, Response: []byte("response"), IsCompressed: true}
+	assert.NoError(t, lm.StoreAsyncSearchResult(ctx, "async_results", stored))
+
+	loaded, found, err := lm.LoadAsyncSearchResult(ctx, "async_results", "quesma_async_search_id_1", 15*time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, stored, loaded)
+
+	_, found, err = lm.LoadAsyncSearchResult(ctx, "async_results", "quesma_async_search_id_2", 15*time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, lm.DeleteAsyncSearchResult(ctx, "async_results", "quesma_async_search_id_1"))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("there were unfulfilled expections:", err)
+	}
+}
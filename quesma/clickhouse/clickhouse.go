@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"maps"
 	"math"
 	"quesma/concurrent"
 	"quesma/elasticsearch"
@@ -15,6 +16,7 @@ import (
 	"quesma/index"
 	"quesma/jsonprocessor"
 	"quesma/logger"
+	"quesma/model"
 	"quesma/plugins/registry"
 	"quesma/quesma/config"
 	"quesma/quesma/recovery"
@@ -23,6 +25,7 @@ import (
 	"quesma/util"
 	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -41,6 +44,8 @@ type (
 		schemaLoader   TableDiscovery
 		cfg            config.QuesmaConfiguration
 		phoneHomeAgent telemetry.PhoneHomeAgent
+		// addColumnsMutex serializes adding columns (both ALTER TABLE and tables' Cols) at ingest
+		addColumnsMutex sync.Mutex
 	}
 	TableMap  = concurrent.Map[string, *Table]
 	SchemaMap = map[string]interface{} // TODO remove
@@ -356,6 +361,10 @@ func buildCreateTableQueryNoOurFields(ctx context.Context, tableName string, jso
 		return "", err
 	}
 
+	if tableConfig.hasOthers || len(tableConfig.attributes) > 0 {
+		jsonData = withoutOthersFields(jsonData, cfg.IndexConfig[tableName])
+	}
+
 	columns := FieldsMapToCreateTableString("", jsonData, 1, tableConfig, nameFormatter) + Indexes(jsonData)
 
 	createTableCmd := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s"
@@ -419,6 +428,15 @@ func (lm *LogManager) BuildInsertJson(tableName string, data types.JSON, config
 		return "", err
 	}
 
+	// fields configured to always go to others/attributes, even if they have a column
+	forcedOthers := make(SchemaMap)
+	for fieldName, value := range m {
+		if lm.cfg.IndexConfig[tableName].IsOthersField(fieldName) {
+			forcedOthers[fieldName] = value
+			delete(m, fieldName)
+		}
+	}
+
 	t := lm.FindTable(tableName)
 	onlySchemaFields := RemoveTypeMismatchSchemaFields(m, t)
 	schemaFieldsJson, err := json.Marshal(onlySchemaFields)
@@ -428,6 +446,9 @@ func (lm *LogManager) BuildInsertJson(tableName string, data types.JSON, config
 	}
 
 	mDiff := DifferenceMap(m, t) // TODO change to DifferenceMap(m, t)
+	for fieldName, value := range forcedOthers {
+		mDiff[fieldName] = value
+	}
 
 	if len(mDiff) == 0 && string(schemaFieldsJson) == js { // no need to modify, just insert 'js'
 		return js, nil
@@ -495,21 +516,39 @@ func (lm *LogManager) GetOrCreateTableConfig(ctx context.Context, tableName stri
 }
 
 func (lm *LogManager) ProcessInsertQuery(ctx context.Context, tableName string, jsonData []types.JSON) error {
+	if deadLetter := lm.cfg.IndexConfig[tableName].DeadLetter; deadLetter != nil {
+		return lm.processInsertQueryWithDeadLetter(ctx, tableName, jsonData, *deadLetter)
+	}
+	return lm.processInsertQuery(ctx, tableName, jsonData)
+}
+
+func (lm *LogManager) processInsertQuery(ctx context.Context, tableName string, jsonData []types.JSON) error {
 
 	// this is pre ingest transformer
 	// here we transform the data before it's structure evaluation and insertion
 	//
 	transformer := &jsonprocessor.RewriteArrayOfObject{}
+	geoPointTransformer := &jsonprocessor.RewriteGeoPoints{Fields: lm.cfg.IndexConfig[tableName].GeoPointFields()}
 
 	var processed []types.JSON
+	var rejectionErr error
 	for _, jsonValue := range jsonData {
 		result, err := transformer.Transform(jsonValue)
 		if err != nil {
 			return fmt.Errorf("error while rewriting json: %v", err)
 		}
+		if result, err = geoPointTransformer.Transform(result); err != nil {
+			// like Elasticsearch, we reject only this document, not the whole batch (_bulk rejects them before)
+			logger.WarnWithCtx(ctx).Msgf("rejecting document of table '%s': %v", tableName, err)
+			rejectionErr = err
+			continue
+		}
 		processed = append(processed, result)
 	}
 	jsonData = processed
+	if len(jsonData) == 0 {
+		return rejectionErr
+	}
 
 	tableConfig, err := lm.GetOrCreateTableConfig(ctx, tableName, jsonData[0])
 	if err != nil {
@@ -531,6 +570,9 @@ func (lm *LogManager) Insert(ctx context.Context, tableName string, jsons []type
 		if err != nil {
 			return fmt.Errorf("error IngestTransformer: %v", err)
 		}
+		if err = lm.addColumnFields(ctx, tableName, preprocessedJson); err != nil {
+			return err
+		}
 		insertJson, err := lm.BuildInsertJson(tableName, preprocessedJson, config)
 		if err != nil {
 			return fmt.Errorf("error BuildInsertJson, tablename: '%s' json: '%s': %v", tableName, PrettyJson(insertJson), err)
@@ -555,6 +597,133 @@ func (lm *LogManager) Insert(ctx context.Context, tableName string, jsons []type
 	}
 }
 
+// DeleteDocuments deletes documents of `tableName`, whose `idField` is one of `ids` (lightweight delete)
+func (lm *LogManager) DeleteDocuments(ctx context.Context, tableName, idField string, ids []string) error {
+	return lm.deleteWhere(ctx, tableName, fmt.Sprintf(`%s IN %s`, model.QuoteIdentifier(idField), quotedIdList(ids)))
+}
+
+// LoadDocuments returns stored documents of `tableName`, whose `idField` is one of `ids`, by their id.
+// Documents are rebuilt from columns: flattened nested fields are nested back, attributes and others are unpacked.
+func (lm *LogManager) LoadDocuments(ctx context.Context, tableName, idField string, ids []string) (map[string]types.JSON, error) {
+	documents := make(map[string]types.JSON, len(ids))
+	table := lm.FindTable(tableName)
+	if table == nil {
+		return documents, nil
+	}
+	where := model.NewInfixExpr(model.NewColumnRef(idField), "IN", model.NewLiteral(quotedIdList(ids)))
+	query := &model.Query{SelectCommand: *model.NewSelectCommand([]model.Expr{model.NewWildcardExpr}, nil, nil,
+		model.NewTableRef(table.FullTableName()), where, 0, 0, false)}
+	rows, err := lm.ProcessQuery(ctx, table, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		document := table.documentFromRow(ctx, row)
+		if id := document[idField]; id != nil {
+			// id column may be numeric, while ids are always strings
+			documents[fmt.Sprint(id)] = document
+		}
+	}
+	return documents, nil
+}
+
+// FindDocumentIds returns which of `ids` are ids (`idField`) of documents stored in `tableName`
+func (lm *LogManager) FindDocumentIds(ctx context.Context, tableName, idField string, ids []string) (map[string]bool, error) {
+	found := make(map[string]bool, len(ids))
+	table := lm.FindTable(tableName)
+	if table == nil {
+		return found, nil
+	}
+	where := model.NewInfixExpr(model.NewColumnRef(idField), "IN", model.NewLiteral(quotedIdList(ids)))
+	query := &model.Query{SelectCommand: *model.NewSelectCommand([]model.Expr{model.NewColumnRef(idField)}, nil, nil,
+		model.NewTableRef(table.FullTableName()), where, 0, 0, false)}
+	rows, err := lm.ProcessQuery(ctx, table, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if len(row.Cols) > 0 {
+			if id := row.Cols[0].ExtractValue(ctx); id != nil {
+				found[fmt.Sprint(id)] = true
+			}
+		}
+	}
+	return found, nil
+}
+
+// quotedIdList returns `ids` as ClickHouse tuple of string literals, e.g. ('1', '2')
+func quotedIdList(ids []string) string {
+	quotedIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		quotedIds = append(quotedIds, "'"+strings.ReplaceAll(strings.ReplaceAll(id, `\`, `\\`), "'", `\'`)+"'")
+	}
+	return "(" + strings.Join(quotedIds, ", ") + ")"
+}
+
+// DeleteWhere deletes documents of `tableName` matching `whereClause` (lightweight delete). nil `whereClause` deletes all of them.
+func (lm *LogManager) DeleteWhere(ctx context.Context, tableName string, whereClause model.Expr) error {
+	if whereClause == nil {
+		return lm.deleteWhere(ctx, tableName, "true")
+	}
+	return lm.deleteWhere(ctx, tableName, model.AsString(whereClause))
+}
+
+func (lm *LogManager) deleteWhere(ctx context.Context, tableName, whereClause string) error {
+	deleteQuery := fmt.Sprintf(`DELETE FROM %s WHERE %s`, model.QuoteIdentifier(tableName), whereClause)
+	if _, err := lm.chDb.ExecContext(ctx, deleteQuery); err != nil {
+		return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("delete from table '%s' failed", tableName)
+	}
+	return nil
+}
+
+// addColumnFields creates columns for fields, which are configured to always have a dedicated column
+// (see `IndexConfiguration.ColumnFields`), but aren't in the table yet. Column type is inferred from the value in `data`.
+// Searches read tables' Cols without locking, so new columns go to a copy of the table, which replaces it in table definitions.
+func (lm *LogManager) addColumnFields(ctx context.Context, tableName string, data types.JSON) error {
+	indexConfig, found := lm.cfg.IndexConfig[tableName]
+	if !found || len(indexConfig.ColumnFields) == 0 {
+		return nil
+	}
+	lm.addColumnsMutex.Lock()
+	defer lm.addColumnsMutex.Unlock()
+	table := lm.FindTable(tableName)
+	if table == nil {
+		return nil
+	}
+	var updated *Table // copy of table with added columns, nil <=> none added yet
+	defer func() {
+		if updated != nil {
+			lm.schemaLoader.TableDefinitions().Store(updated.Name, updated)
+		}
+	}()
+	for _, fieldName := range indexConfig.ColumnFields {
+		value, present := data[fieldName]
+		if !present || value == nil {
+			continue // we can't infer the type yet
+		}
+		if _, exists := table.Cols[fieldName]; exists {
+			continue
+		}
+		column := newColumnFromValue(fieldName, value)
+		if column == nil {
+			logger.WarnWithCtx(ctx).Msgf("can't create column for field '%s' of table '%s', value: %v", fieldName, tableName, value)
+			continue
+		}
+		alterTable := fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN IF NOT EXISTS %s %s`, tableName, model.QuoteIdentifier(fieldName), column.Type.StringWithNullable())
+		if _, err := lm.chDb.ExecContext(ctx, alterTable); err != nil {
+			return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("adding column '%s' to table '%s' failed", fieldName, tableName)
+		}
+		logger.InfoWithCtx(ctx).Msgf("added column '%s' %s to table '%s'", fieldName, column.Type.StringWithNullable(), tableName)
+		if updated == nil {
+			tableCopy := *table
+			tableCopy.Cols = maps.Clone(table.Cols)
+			updated = &tableCopy
+		}
+		updated.Cols[fieldName] = column
+	}
+	return nil
+}
+
 func (lm *LogManager) FindTable(tableName string) (result *Table) {
 	tableNamePattern := index.TableNamePatternRegexp(tableName)
 	lm.schemaLoader.TableDefinitions().
@@ -597,6 +766,15 @@ func (lm *LogManager) Ping() error {
 	return lm.chDb.Ping()
 }
 
+// RenderOptions returns how SQL of queries is rendered, as configured (e.g. `identifierQuoting`)
+func (lm *LogManager) RenderOptions() model.RenderOptions {
+	return model.RenderOptions{QuoteIdentifiersWhenNeeded: lm.cfg.GetIdentifierQuoting() == config.IdentifierQuotingWhenNeeded}
+}
+
+func (lm *LogManager) PingContext(ctx context.Context) error {
+	return lm.chDb.PingContext(ctx)
+}
+
 func NewEmptyLogManager(cfg config.QuesmaConfiguration, chDb *sql.DB, phoneHomeAgent telemetry.PhoneHomeAgent, loader TableDiscovery) *LogManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &LogManager{ctx: ctx, cancel: cancel, chDb: chDb, schemaLoader: loader, cfg: cfg, phoneHomeAgent: phoneHomeAgent}
@@ -5,9 +5,13 @@ package clickhouse
 import (
 	"context"
 	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
 	"quesma/concurrent"
+	"quesma/model"
 	"quesma/quesma/config"
 	"quesma/quesma/types"
+	"quesma/util"
+	"regexp"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -933,3 +937,34 @@ func newTableMap(tables ...string) *TableMap {
 	}
 	return newMap
 }
+
+func TestProcessQueryRendersIdentifiersAsConfigured(t *testing.T) {
+	table := &Table{Name: "logs", Config: NewDefaultCHConfig(), Created: true, Cols: map[string]*Column{
+		"level":     {Name: "level", Type: NewBaseType("String")},
+		"host.name": {Name: "host.name", Type: NewBaseType("String")},
+	}}
+	query := &model.Query{SelectCommand: *model.NewSelectCommand([]model.Expr{model.NewColumnRef("level"), model.NewColumnRef("host.name")},
+		nil, nil, model.NewTableRef(`"logs"`), model.NewInfixExpr(model.NewColumnRef("level"), "=", model.NewLiteral("'error'")), 0, 0, false)}
+
+	for _, tt := range []struct {
+		identifierQuoting string
+		wantSql           string
+	}{
+		{"", `SELECT "level", "host.name" FROM "logs" WHERE "level"='error'`},
+		{config.IdentifierQuotingWhenNeeded, `SELECT level, "host.name" FROM "logs" WHERE level='error'`},
+	} {
+		t.Run(tt.identifierQuoting, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := NewLogManager(concurrent.NewMapWith("logs", table), config.QuesmaConfiguration{IdentifierQuoting: tt.identifierQuoting})
+			lm.chDb = db
+			mock.ExpectQuery(regexp.QuoteMeta(tt.wantSql)).WillReturnRows(sqlmock.NewRows([]string{"level", "host.name"}))
+
+			_, err := lm.ProcessQuery(context.Background(), table, query)
+			assert.NoError(t, err)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal("there were unfulfilled expections:", err)
+			}
+		})
+	}
+}
@@ -14,8 +14,19 @@ import (
 )
 
 func initDBConnection(c config.QuesmaConfiguration, tlsConfig *tls.Config) *sql.DB {
+	options := connectionOptions(c, tlsConfig)
+	return clickhouse.OpenDB(&options)
+}
 
+// connectionOptions returns options for connecting to ClickHouse with the configured protocol (native or HTTP)
+func connectionOptions(c config.QuesmaConfiguration, tlsConfig *tls.Config) clickhouse.Options {
 	options := clickhouse.Options{Addr: []string{c.ClickHouse.Url.Host}}
+	if c.ClickHouse.GetProtocol() == config.ClickHouseProtocolHttp {
+		options.Protocol = clickhouse.HTTP
+		options.HttpUrlPath = c.ClickHouse.Url.Path // e.g. if ClickHouse is behind a reverse proxy
+	} else {
+		options.Protocol = clickhouse.Native
+	}
 	if c.ClickHouse.User != "" || c.ClickHouse.Password != "" || c.ClickHouse.Database != "" {
 
 		options.Auth = clickhouse.Auth{
@@ -44,13 +55,17 @@ func initDBConnection(c config.QuesmaConfiguration, tlsConfig *tls.Config) *sql.
 
 	options.ClientInfo.Products = append(options.ClientInfo.Products, info)
 
-	return clickhouse.OpenDB(&options)
-
+	return options
 }
 
 func InitDBConnectionPool(c config.QuesmaConfiguration) *sql.DB {
 
-	db := initDBConnection(c, &tls.Config{})
+	tlsConfig := &tls.Config{}
+	if c.ClickHouse.GetProtocol() == config.ClickHouseProtocolHttp && c.ClickHouse.Url.Scheme == "http" {
+		// for HTTP the URL scheme tells us if TLS is used, so there's no need to guess
+		tlsConfig = nil
+	}
+	db := initDBConnection(c, tlsConfig)
 
 	err := db.Ping()
 	if err != nil {
@@ -62,7 +77,8 @@ func InitDBConnectionPool(c config.QuesmaConfiguration) *sql.DB {
 			logger.Warn().Err(err).Msg("Failed to connect to database with TLS. Retrying TLS, but with disabled chain and host verification.")
 			_ = db.Close()
 			db = initDBConnection(c, &tls.Config{InsecureSkipVerify: true})
-		} else if strings.Contains(err.Error(), "tls: first record does not look like a TLS handshake") {
+		} else if strings.Contains(err.Error(), "tls: first record does not look like a TLS handshake") ||
+			strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
 			_ = db.Close()
 			logger.Warn().Err(err).Msg("Failed to connect to database with TLS. Trying without TLS at all.")
 			db = initDBConnection(c, nil)
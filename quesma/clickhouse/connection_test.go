@@ -0,0 +1,43 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package clickhouse
+
+import (
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"quesma/quesma/config"
+	"testing"
+)
+
+func TestConnectionOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		protocol     string
+		wantProtocol clickhouse.Protocol
+		wantAddr     string
+		wantPath     string
+	}{
+		{"default protocol", "clickhouse://localhost:9000", "", clickhouse.Native, "localhost:9000", ""},
+		{"native protocol", "clickhouse://localhost:9440", config.ClickHouseProtocolNative, clickhouse.Native, "localhost:9440", ""},
+		{"http protocol", "http://localhost:8123", config.ClickHouseProtocolHttp, clickhouse.HTTP, "localhost:8123", ""},
+		{"http protocol with path", "https://proxy:443/clickhouse", config.ClickHouseProtocolHttp, clickhouse.HTTP, "proxy:443", "/clickhouse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chUrl, err := url.Parse(tt.url)
+			assert.NoError(t, err)
+			cfg := config.QuesmaConfiguration{ClickHouse: config.RelationalDbConfiguration{
+				Url: (*config.Url)(chUrl), User: "user", Password: "secret", Protocol: tt.protocol,
+			}}
+
+			options := connectionOptions(cfg, nil)
+			assert.Equal(t, tt.wantProtocol, options.Protocol)
+			assert.Equal(t, []string{tt.wantAddr}, options.Addr)
+			assert.Equal(t, tt.wantPath, options.HttpUrlPath)
+			assert.Equal(t, "user", options.Auth.Username)
+			assert.Equal(t, "secret", options.Auth.Password)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"os"
+	"quesma/end_user_errors"
+	"quesma/logger"
+	"quesma/quesma/config"
+	"quesma/quesma/types"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deadLetterRecord is a document we failed to insert, as written to the dead-letter sink
+type deadLetterRecord struct {
+	Timestamp string `json:"@timestamp"`
+	Index     string `json:"index"`
+	Document  string `json:"document"` // original document, as JSON
+	Error     string `json:"error"`
+}
+
+const deadLetterTableColumns = `"@timestamp" DateTime64(3), "index" String, "document" String, "error" String`
+
+// deadLetterFileMutex guards appending to dead-letter files, so records of concurrent inserts don't interleave
+var deadLetterFileMutex sync.Mutex
+
+// documentErrorCodes are codes of ClickHouse errors caused by a document itself: its values can't be parsed
+// as (or converted to) types of the columns. Any other error (e.g. lost connection or timeout) isn't about documents.
+var documentErrorCodes = map[int]bool{
+	6:   true, // CANNOT_PARSE_TEXT
+	26:  true, // CANNOT_PARSE_QUOTED_STRING
+	27:  true, // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+	38:  true, // CANNOT_PARSE_DATE
+	41:  true, // CANNOT_PARSE_DATETIME
+	53:  true, // TYPE_MISMATCH
+	70:  true, // CANNOT_CONVERT_TYPE
+	72:  true, // CANNOT_PARSE_NUMBER
+	117: true, // INCORRECT_DATA
+	131: true, // TOO_LARGE_STRING_SIZE
+	349: true, // CANNOT_INSERT_NULL_IN_ORDINARY_COLUMN
+	467: true, // CANNOT_PARSE_BOOL
+}
+
+var clickhouseErrorCodeRegexp = regexp.MustCompile(`code: (\d+)`)
+
+// isDocumentError returns true, if inserting failed because of a document, which can't be inserted at all
+func isDocumentError(err error) bool {
+	// like GuessClickhouseErrorType, we have only the message of the error (it may also be wrapped)
+	match := clickhouseErrorCodeRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return false
+	}
+	code, _ := strconv.Atoi(match[1])
+	return documentErrorCodes[code]
+}
+
+// processInsertQueryWithDeadLetter inserts documents like processInsertQuery. If that fails because of some documents,
+// we insert them one by one (trying each failing one 1 + `deadLetter.Retries` times) to find the documents, which can't
+// be inserted. They're written to the dead-letter sink with their errors, instead of being dropped.
+// Other errors (e.g. lost connection or timeout) are returned, as documents may be fine and inserted again later.
+func (lm *LogManager) processInsertQueryWithDeadLetter(ctx context.Context, tableName string, jsonData []types.JSON,
+	deadLetter config.DeadLetterConfiguration) error {
+	// inserting modifies documents, so we keep the originals to retry and dead-letter them
+	originals := make([]types.JSON, 0, len(jsonData))
+	for _, document := range jsonData {
+		originals = append(originals, document.Clone())
+	}
+
+	err := lm.processInsertQuery(ctx, tableName, jsonData)
+	if err == nil || !isDocumentError(err) {
+		return err
+	}
+	logger.WarnWithCtx(ctx).Msgf("inserting %d documents into table '%s' failed, inserting them one by one: %v", len(originals), tableName, err)
+
+	failed := make([]deadLetterRecord, 0)
+	for _, document := range originals {
+		// a single document has just been tried, in bigger batches we don't know which documents failed
+		insertErr, attempts := err, deadLetter.Retries
+		if len(originals) > 1 {
+			attempts++
+		}
+		for attempt := 0; attempt < attempts; attempt++ {
+			if insertErr = lm.processInsertQuery(ctx, tableName, []types.JSON{document.Clone()}); insertErr == nil || !isDocumentError(insertErr) {
+				break
+			}
+		}
+		if insertErr != nil && !isDocumentError(insertErr) {
+			return insertErr
+		}
+		if insertErr != nil {
+			documentJson, _ := document.Bytes()
+			failed = append(failed, deadLetterRecord{
+				Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+				Index:     tableName,
+				Document:  string(documentJson),
+				Error:     insertErr.Error(),
+			})
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if err = lm.writeDeadLetters(ctx, deadLetter, failed); err != nil {
+		return fmt.Errorf("writing %d failed documents of table '%s' to the dead-letter sink failed: %v", len(failed), tableName, err)
+	}
+	logger.WarnWithCtx(ctx).Msgf("%d documents couldn't be inserted into table '%s', they're written to the dead-letter sink (%s)",
+		len(failed), tableName, deadLetter)
+	return nil
+}
+
+func (lm *LogManager) writeDeadLetters(ctx context.Context, deadLetter config.DeadLetterConfiguration, records []deadLetterRecord) error {
+	lines := make([]string, 0, len(records))
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(line))
+	}
+
+	if deadLetter.Table == "" {
+		deadLetterFileMutex.Lock()
+		defer deadLetterFileMutex.Unlock()
+		file, err := os.OpenFile(deadLetter.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteString(strings.Join(lines, "\n") + "\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	createTable := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (%s) ENGINE = MergeTree ORDER BY "@timestamp"`, deadLetter.Table, deadLetterTableColumns)
+	if _, err := lm.chDb.ExecContext(ctx, createTable); err != nil {
+		return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("creating dead-letter table '%s' failed", deadLetter.Table)
+	}
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"date_time_input_format": "best_effort",
+	}))
+	insert := fmt.Sprintf(`INSERT INTO "%s" FORMAT JSONEachRow %s`, deadLetter.Table, strings.Join(lines, ", "))
+	if _, err := lm.chDb.ExecContext(ctx, insert); err != nil {
+		return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("insert into dead-letter table '%s' failed", deadLetter.Table)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"quesma/concurrent"
+	"quesma/quesma/config"
+	"quesma/quesma/types"
+	"quesma/util"
+	"strings"
+	"testing"
+)
+
+func newDeadLetterTestLogManager(t *testing.T, deadLetter config.DeadLetterConfiguration) (*LogManager, sqlmock.Sqlmock) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableName: {Name: tableName, Enabled: true, DeadLetter: &deadLetter},
+	}}
+	tables := concurrent.NewMapWith(tableName, &Table{
+		Name:   tableName,
+		Config: NewChTableConfigNoAttrs(),
+		Cols: map[string]*Column{
+			"message": {Name: "message", Type: NewBaseType("String")},
+			"count":   {Name: "count", Type: NewBaseType("Int64")},
+		},
+		Created: true,
+	})
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	t.Cleanup(func() { db.Close() })
+	lm := NewLogManagerEmpty()
+	lm.chDb = db
+	lm.cfg = cfg
+	lm.schemaLoader = newTableDiscoveryWith(cfg, nil, *tables)
+	return lm, mock
+}
+
+func TestDeadLetterTable(t *testing.T) {
+	lm, mock := newDeadLetterTestLogManager(t, config.DeadLetterConfiguration{Table: "dead_letter", Retries: 1})
+	insertError := errors.New("code: 27, message: Cannot parse input: expected Int64")
+
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow .*, `).WillReturnError(insertError)
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow .*good`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow .*bad`).WillReturnError(insertError) // first attempt
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow .*bad`).WillReturnError(insertError) // retry
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS "dead_letter"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "dead_letter" FORMAT JSONEachRow \{[^}]*"index":"test_table".*bad.*Cannot parse input[^}]*\}$`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := lm.ProcessInsertQuery(context.Background(), tableName, []types.JSON{
+		types.MustJSON(`{"message": "good", "count": 1}`),
+		types.MustJSON(`{"message": "bad", "count": "not a number"}`),
+	})
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("there were unfulfilled expections:", err)
+	}
+}
+
+func TestDeadLetterConnectionErrorIsReturned(t *testing.T) {
+	lm, mock := newDeadLetterTestLogManager(t, config.DeadLetterConfiguration{Table: "dead_letter", Retries: 1})
+
+	// documents aren't the problem, so they aren't inserted one by one, nor dead-lettered
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow .*, `).WillReturnError(errors.New("dial tcp 127.0.0.1:9000: connect: connection refused"))
+
+	err := lm.ProcessInsertQuery(context.Background(), tableName, []types.JSON{
+		types.MustJSON(`{"message": "first", "count": 1}`),
+		types.MustJSON(`{"message": "second", "count": 2}`),
+	})
+	assert.ErrorContains(t, err, "connection refused")
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("there were unfulfilled expections:", err)
+	}
+}
+
+func TestDeadLetterFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dead_letter.ndjson")
+	lm, mock := newDeadLetterTestLogManager(t, config.DeadLetterConfiguration{File: file})
+
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow`).WillReturnError(errors.New("code: 27, message: Cannot parse input"))
+
+	err := lm.ProcessInsertQuery(context.Background(), tableName, []types.JSON{types.MustJSON(`{"message": "bad", "count": "not a number"}`)})
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("there were unfulfilled expections:", err)
+	}
+
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 1) {
+		var record deadLetterRecord
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+		assert.Equal(t, tableName, record.Index)
+		assert.JSONEq(t, `{"message": "bad", "count": "not a number"}`, record.Document)
+		assert.Contains(t, record.Error, "Cannot parse input")
+	}
+}
@@ -326,3 +326,95 @@ func dateTime(name string) *Column {
 		Modifiers: "CODEC(DoubleDelta, LZ4)",
 	}
 }
+
+func TestInsertWithFieldPlacement(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableName: {Name: tableName, Enabled: true, ColumnFields: []string{"service.name"}, OthersFields: []string{"host.name"}},
+	}}
+	tables := concurrent.NewMapWith(tableName, &Table{
+		Name:   tableName,
+		Config: NewDefaultCHConfig(),
+		Cols: map[string]*Column{
+			"@timestamp": dateTime("@timestamp"),
+			"host.name":  genericString("host.name"),
+			"message":    lowCardinalityString("message"),
+		},
+		Created: true,
+	})
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	defer db.Close()
+	lm := NewLogManager(tables, cfg)
+	lm.chDb = db
+
+	// "service.name" is promoted to a new column, "host.name" goes to attributes even though it has a column,
+	// "severity" has no rule, so it goes to attributes by default
+	mock.ExpectExec(`ALTER TABLE "test_table" ADD COLUMN IF NOT EXISTS "service.name" Nullable\(String\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow {"attributes_string_key":\["host.name","severity"\],"attributes_string_value":\["hermes","debug"\],"@timestamp":"2024-01-27T16:11:19.94Z","message":"User password reset failed","service.name":"frontend"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	insertJson := `{"@timestamp":"2024-01-27T16:11:19.94Z","host.name":"hermes","message":"User password reset failed","service.name":"frontend","severity":"debug"}`
+	err := lm.ProcessInsertQuery(context.Background(), tableName, []types.JSON{types.MustJSON(insertJson)})
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("there were unfulfilled expections:", err)
+	}
+
+	table := lm.FindTable(tableName)
+	assert.Contains(t, table.Cols, "service.name")
+	assert.Equal(t, "Nullable(String)", table.Cols["service.name"].Type.StringWithNullable())
+}
+
+func TestInsertRejectsOnlyDocumentsWithInvalidGeoPoint(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableName: {Name: tableName, Enabled: true, TypeMappings: map[string]string{"location": "geo_point"}},
+	}}
+	tables := concurrent.NewMapWith(tableName, &Table{
+		Name:   tableName,
+		Config: NewChTableConfigNoAttrs(),
+		Cols: map[string]*Column{
+			"location::lat": {Name: "location::lat", Type: NewBaseType("Float64")},
+			"location::lon": {Name: "location::lon", Type: NewBaseType("Float64")},
+		},
+		Created: true,
+	})
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	defer db.Close()
+	lm := NewLogManager(tables, cfg)
+	lm.chDb = db
+
+	mock.ExpectExec(`INSERT INTO "test_table" FORMAT JSONEachRow {"location::lat":40,"location::lon":-70}$`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := lm.ProcessInsertQuery(context.Background(), tableName, []types.JSON{
+		types.MustJSON(`{"location": "40,-70"}`), types.MustJSON(`{"location": "not-a-geohash!"}`),
+	})
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("there were unfulfilled expections:", err)
+	}
+
+	// a single invalid document can't be inserted at all
+	err = lm.ProcessInsertQuery(context.Background(), tableName, []types.JSON{types.MustJSON(`{"location": "91,0"}`)})
+	assert.ErrorContains(t, err, "failed to parse field [location] of type [geo_point]")
+}
+
+func TestAddColumnFieldsQuotesIdentifier(t *testing.T) {
+	const fieldName = `service"; DROP TABLE x; --`
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableName: {Name: tableName, Enabled: true, ColumnFields: []string{fieldName}},
+	}}
+	tables := concurrent.NewMapWith(tableName, &Table{Name: tableName, Config: NewDefaultCHConfig(), Cols: map[string]*Column{}, Created: true})
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	defer db.Close()
+	lm := NewLogManager(tables, cfg)
+	lm.chDb = db
+
+	mock.ExpectExec(`ALTER TABLE "test_table" ADD COLUMN IF NOT EXISTS "service\\"; DROP TABLE x; --" Nullable\(String\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	tableBefore := lm.FindTable(tableName)
+	assert.NoError(t, lm.addColumnFields(context.Background(), tableName, types.JSON{fieldName: "frontend"}))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("there were unfulfilled expections:", err)
+	}
+	assert.Contains(t, lm.FindTable(tableName).Cols, fieldName)
+	// copy on write, Cols of the table read by searches in progress don't change
+	assert.NotContains(t, tableBefore.Cols, fieldName)
+}
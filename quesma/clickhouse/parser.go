@@ -5,6 +5,8 @@ package clickhouse
 import (
 	"fmt"
 	"quesma/plugins"
+	"quesma/quesma/config"
+	"quesma/quesma/types"
 	"quesma/util"
 	"slices"
 	"strings"
@@ -320,3 +322,33 @@ func sortedKeys(m SchemaMap) (keys []string) {
 	slices.Sort(keys)
 	return keys
 }
+
+// newColumnFromValue returns column for a single (non-nested) field, with type inferred from its JSON value,
+// just like in FieldsMapToCreateTableString. Returns nil for nested objects.
+func newColumnFromValue(name string, value any) *Column {
+	switch columnType := NewType(value).(type) {
+	case BaseType:
+		if !strings.Contains(columnType.Name, "DateTime") {
+			columnType.Nullable = true
+		}
+		return &Column{Name: name, Type: columnType}
+	case CompoundType:
+		return &Column{Name: name, Type: columnType}
+	default:
+		return nil
+	}
+}
+
+// withoutOthersFields returns copy of `m` without fields, which are configured to always go to the attributes/others map
+func withoutOthersFields(m types.JSON, indexConfig config.IndexConfiguration) types.JSON {
+	if len(indexConfig.OthersFields) == 0 {
+		return m
+	}
+	result := make(types.JSON, len(m))
+	for fieldName, value := range m {
+		if !indexConfig.IsOthersField(fieldName) {
+			result[fieldName] = value
+		}
+	}
+	return result
+}
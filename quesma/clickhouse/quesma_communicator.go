@@ -5,12 +5,15 @@ package clickhouse
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/shopspring/decimal"
 	"math/rand"
 	"quesma/end_user_errors"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/tracing"
 	"strings"
 	"time"
 )
@@ -45,8 +48,22 @@ func (lm *LogManager) GetAllColumns(table *Table, query *model.Query) []string {
 // sql statement that were already parsed and not string from which
 // we have to extract again different parts like where clause and columns to build a proper result
 func (lm *LogManager) ProcessQuery(ctx context.Context, table *Table, query *model.Query) ([]model.QueryResultRow, error) {
+	rows := make([]model.QueryResultRow, 0)
+	err := lm.ProcessQueryEach(ctx, table, query, func(row model.QueryResultRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// ProcessQueryEach is like ProcessQuery, but instead of returning all rows at once, it calls `onRow` for each row
+// as soon as it's read from the database. It stops at the first error returned by `onRow`.
+func (lm *LogManager) ProcessQueryEach(ctx context.Context, table *Table, query *model.Query, onRow func(row model.QueryResultRow) error) error {
 	if query.NoDBQuery {
-		return make([]model.QueryResultRow, 0), nil
+		return nil
 	}
 
 	table.applyTableSchema(query)
@@ -72,14 +89,10 @@ func (lm *LogManager) ProcessQuery(ctx context.Context, table *Table, query *mod
 
 	}
 
-	rows, err := executeQuery(ctx, lm, query.SelectCommand.String(), columns, rowToScan)
-
-	if err == nil {
-		for _, row := range rows {
-			row.Index = table.Name
-		}
-	}
-	return rows, err
+	return executeQuery(ctx, lm, query.SelectCommand.StringWithOptions(lm.RenderOptions()), querySettings(ctx, table, query), columns, rowToScan, func(row model.QueryResultRow) error {
+		row.Index = table.Name
+		return onRow(row)
+	})
 }
 
 var random = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -121,40 +134,149 @@ func (lm *LogManager) explainQuery(ctx context.Context, query string, elapsed ti
 	}
 }
 
-func executeQuery(ctx context.Context, lm *LogManager, queryAsString string, fields []string, rowToScan []interface{}) ([]model.QueryResultRow, error) {
+// querySettings returns ClickHouse settings (sent as query's SETTINGS), with which `query` is run on `table`
+func querySettings(ctx context.Context, table *Table, query *model.Query) clickhouse.Settings {
+	settings := groupByStrategySettings(query.GroupByStrategy)
+	if table.SequentialConsistency {
+		if settings == nil {
+			settings = make(clickhouse.Settings)
+		}
+		// read only after the replica has all writes acknowledged so far, so we don't return stale data right after ingest
+		settings["select_sequential_consistency"] = "1"
+	}
+	if table.QueryLogComment {
+		if logComment, ok := queryLogComment(ctx, table); ok {
+			if settings == nil {
+				settings = make(clickhouse.Settings)
+			}
+			settings["log_comment"] = logComment
+		}
+	}
+	return settings
+}
+
+// queryLogComment returns `log_comment` setting, which ClickHouse stores in `system.query_log`, to trace the query
+// back to the request: a JSON like {"index":"logs","path":"/logs/_search","request_id":"..."}.
+// False if there's no request id in `ctx`.
+func queryLogComment(ctx context.Context, table *Table) (string, bool) {
+	requestId, ok := ctx.Value(tracing.RequestIdCtxKey).(string)
+	if !ok {
+		return "", false
+	}
+	comment := map[string]string{"request_id": requestId, "index": table.Name}
+	if path, ok := ctx.Value(tracing.RequestPath).(string); ok {
+		comment["path"] = path
+	}
+	logComment, err := json.Marshal(comment)
+	if err != nil {
+		logger.ErrorWithCtx(ctx).Msgf("failed to marshal log_comment %v: %v", comment, err)
+		return "", false
+	}
+	return string(logComment), true
+}
+
+// groupByStrategySettings returns ClickHouse settings, which make it compute GROUP BY with `strategy`
+func groupByStrategySettings(strategy model.GroupByStrategy) clickhouse.Settings {
+	switch strategy {
+	case model.GroupByHash:
+		return clickhouse.Settings{"optimize_aggregation_in_order": "0"}
+	case model.GroupBySorted:
+		// ClickHouse falls back to hash aggregation, if GROUP BY keys aren't a prefix of the table's sorting key
+		return clickhouse.Settings{"optimize_aggregation_in_order": "1"}
+	}
+	return nil
+}
+
+func executeQuery(ctx context.Context, lm *LogManager, queryAsString string, querySettings clickhouse.Settings, fields []string, rowToScan []interface{}, onRow func(row model.QueryResultRow) error) error {
 	span := lm.phoneHomeAgent.ClickHouseQueryDuration().Begin()
 
+	ctx = readOnlyContext(ctx, querySettings)
+
+	rows, err := lm.Query(ctx, queryAsString)
+	if err != nil {
+		span.End(err)
+		return end_user_errors.GuessClickhouseErrorType(err).InternalDetails("clickhouse: query failed. err: %v, query: %v", err, queryAsString)
+	}
+
+	err = read(rows, fields, rowToScan, onRow)
+	elapsed := span.End(nil)
+	if err == nil {
+		if lm.shouldExplainQuery(elapsed) {
+			lm.explainQuery(ctx, queryAsString, elapsed)
+		}
+	}
+
+	return err
+}
+
+// readOnlyContext returns context, in which queries are run with `querySettings` and dropped privileges
+func readOnlyContext(ctx context.Context, querySettings clickhouse.Settings) context.Context {
 	// We drop privileges for the query
 	//
 	// https://clickhouse.com/docs/en/operations/settings/permissions-for-queries
 	//
+	// readonly=1 also forbids changing settings, so ClickHouse accepts `querySettings` only if Quesma user's
+	// settings profile declares them as `changeable_in_readonly` constraints, e.g.
+	// <constraints><optimize_aggregation_in_order><changeable_in_readonly/></optimize_aggregation_in_order></constraints>
+	// https://clickhouse.com/docs/en/operations/settings/constraints-on-settings
 
 	settings := make(clickhouse.Settings)
+	for name, value := range querySettings {
+		settings[name] = value
+	}
 	settings["readonly"] = "1"
 	settings["allow_ddl"] = "0"
 
-	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+	return clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+}
+
+// RawQueryColumn is a column of ProcessRawQuery's result
+type RawQueryColumn struct {
+	Name string
+	Type string // Clickhouse type, e.g. "UInt64" or "Nullable(String)"
+}
 
-	rows, err := lm.Query(ctx, queryAsString)
+// ProcessRawQuery runs a complete SQL query, not built by us (e.g. from the _sql API), so we don't know its columns
+// in advance. It's read-only, like all other queries. Returns result's columns and rows.
+func (lm *LogManager) ProcessRawQuery(ctx context.Context, query string) ([]RawQueryColumn, []model.QueryResultRow, error) {
+	span := lm.phoneHomeAgent.ClickHouseQueryDuration().Begin()
+
+	rows, err := lm.Query(readOnlyContext(ctx, nil), query)
 	if err != nil {
 		span.End(err)
-		return nil, end_user_errors.GuessClickhouseErrorType(err).InternalDetails("clickhouse: query failed. err: %v, query: %v", err, queryAsString)
+		return nil, nil, end_user_errors.GuessClickhouseErrorType(err).InternalDetails("clickhouse: query failed. err: %v, query: %v", err, query)
 	}
 
-	res, err := read(rows, fields, rowToScan)
-	elapsed := span.End(nil)
-	if err == nil {
-		if lm.shouldExplainQuery(elapsed) {
-			lm.explainQuery(ctx, queryAsString, elapsed)
-		}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		span.End(err)
+		return nil, nil, fmt.Errorf("clickhouse: reading column types failed: %v", err)
+	}
+	columns := make([]RawQueryColumn, 0, len(columnTypes))
+	columnNames := make([]string, 0, len(columnTypes))
+	for _, columnType := range columnTypes {
+		columns = append(columns, RawQueryColumn{Name: columnType.Name(), Type: columnType.DatabaseTypeName()})
+		columnNames = append(columnNames, columnType.Name())
 	}
 
-	return res, err
+	resultRows := make([]model.QueryResultRow, 0)
+	err = read(rows, columnNames, make([]interface{}, len(columnNames)), func(row model.QueryResultRow) error {
+		resultRows = append(resultRows, row)
+		return nil
+	})
+	span.End(err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return columns, resultRows, nil
 }
 
 // 'selectFields' are all values that we return from the query, both columns and non-schema fields,
 // like e.g. count(), or toInt8(boolField)
-func read(rows *sql.Rows, selectFields []string, rowToScan []interface{}) ([]model.QueryResultRow, error) {
+// Each row is passed to `onRow` right after it's scanned.
+func read(rows *sql.Rows, selectFields []string, rowToScan []interface{}, onRow func(row model.QueryResultRow) error) error {
+	defer rows.Close()
 
 	// read selected fields from the metadata
 
@@ -162,24 +284,73 @@ func read(rows *sql.Rows, selectFields []string, rowToScan []interface{}) ([]mod
 	for i := range rowToScan {
 		rowDb = append(rowDb, &rowToScan[i])
 	}
-	resultRows := make([]model.QueryResultRow, 0)
+	decimalScales := readDecimalScales(rows, len(rowToScan))
 	for rows.Next() {
 		err := rows.Scan(rowDb...)
 		if err != nil {
-			return nil, fmt.Errorf("clickhouse: scan failed: %v", err)
+			return fmt.Errorf("clickhouse: scan failed: %v", err)
 		}
 		resultRow := model.QueryResultRow{Cols: make([]model.QueryResultCol, len(selectFields))}
 		for i, field := range selectFields {
 			resultRow.Cols[i] = model.QueryResultCol{ColName: field, Value: rowToScan[i]}
+			if scale, isDecimal := decimalScales[i]; isDecimal {
+				resultRow.Cols[i].Value = formatDecimal(rowToScan[i], scale)
+			}
+		}
+		if err = onRow(resultRow); err != nil {
+			return err
 		}
-		resultRows = append(resultRows, resultRow)
 	}
 	if rows.Err() != nil {
-		return nil, fmt.Errorf("clickhouse: iterating over rows failed:  %v", rows.Err())
+		return fmt.Errorf("clickhouse: iterating over rows failed:  %v", rows.Err())
 	}
 	err := rows.Close()
 	if err != nil {
-		return nil, fmt.Errorf("clickhouse: closing rows failed: %v", err)
+		return fmt.Errorf("clickhouse: closing rows failed: %v", err)
+	}
+	return nil
+}
+
+// readDecimalScales returns scales of Decimal columns of `rows`, by column index
+func readDecimalScales(rows *sql.Rows, columnCount int) map[int]int {
+	decimalScales := make(map[int]int)
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return decimalScales
+	}
+	for i, columnType := range columnTypes[:min(len(columnTypes), columnCount)] {
+		typeName := columnType.DatabaseTypeName()
+		if isNullableType(typeName) {
+			typeName = strings.TrimSuffix(strings.TrimPrefix(typeName, "Nullable("), ")")
+		}
+		if scale, isDecimal := DecimalScale(typeName); isDecimal {
+			decimalScales[i] = scale
+		}
+	}
+	return decimalScales
+}
+
+// formatDecimal converts a Decimal value to json.Number with exactly `scale` digits after the decimal point
+// (e.g. 12.30, not 12.3), so float64 conversion neither rounds it, nor drops trailing zeros.
+func formatDecimal(value any, scale int) any {
+	var asDecimal decimal.Decimal
+	switch valueTyped := value.(type) {
+	case decimal.Decimal:
+		asDecimal = valueTyped
+	case *decimal.Decimal:
+		if valueTyped == nil {
+			return nil
+		}
+		asDecimal = *valueTyped
+	case string:
+		var err error
+		if asDecimal, err = decimal.NewFromString(valueTyped); err != nil {
+			return value
+		}
+	case float64:
+		asDecimal = decimal.NewFromFloat(valueTyped)
+	default:
+		return value
 	}
-	return resultRows, nil
+	return json.Number(asDecimal.StringFixed(int32(scale)))
 }
@@ -7,6 +7,8 @@ import (
 	"math"
 	"quesma/util"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -86,7 +88,7 @@ func (t BaseType) isBool() bool {
 }
 
 func (t BaseType) isString() bool {
-	return t.Name == "String"
+	return t.Name == "String" || t.Name == "LowCardinality(String)"
 }
 
 func (t BaseType) isNullable() bool { return t.Nullable }
@@ -196,6 +198,9 @@ func NewBaseType(clickHouseTypeName string) BaseType {
 type UnknownType struct{}
 
 func ResolveType(clickHouseTypeName string) reflect.Type {
+	if _, isDecimal := DecimalScale(clickHouseTypeName); isDecimal {
+		return reflect.TypeOf(float64(0))
+	}
 	switch clickHouseTypeName {
 	case "String", "LowCardinality(String)", "UUID":
 		return reflect.TypeOf("")
@@ -222,6 +227,28 @@ func ResolveType(clickHouseTypeName string) reflect.Type {
 	return nil
 }
 
+// decimalTypeRegexp matches Decimal(P, S), Decimal(P), Decimal, and Decimal32(S)/Decimal64(S)/Decimal128(S)/Decimal256(S)
+var decimalTypeRegexp = regexp.MustCompile(`^Decimal(32|64|128|256)?(?:\((\d+)(?:,\s*(\d+))?\))?$`)
+
+// DecimalScale returns scale (number of digits after the decimal point) of a Decimal type,
+// e.g. 2 for "Decimal(10, 2)" or "Decimal64(2)". isDecimal is false for other types.
+func DecimalScale(clickHouseTypeName string) (scale int, isDecimal bool) {
+	match := decimalTypeRegexp.FindStringSubmatch(clickHouseTypeName)
+	if match == nil {
+		return 0, false
+	}
+	bits, firstParam, secondParam := match[1], match[2], match[3]
+	switch {
+	case bits != "" && secondParam != "":
+		return 0, false // DecimalN has only 1 parameter: scale
+	case bits != "":
+		scale, _ = strconv.Atoi(firstParam)
+	case secondParam != "":
+		scale, _ = strconv.Atoi(secondParam)
+	}
+	return scale, true
+}
+
 // 'value': value of a field, from unmarshalled JSON
 func NewType(value any) Type {
 	isFloatInt := func(f float64) bool {
@@ -69,6 +69,11 @@ func Test_resolveColumn(t *testing.T) {
 			args: args{colName: "@timestamp", colType: "DateTime64"},
 			want: &Column{Name: "@timestamp", Type: BaseType{Name: "DateTime64", goType: reflect.TypeOf(time.Time{})}},
 		},
+		{
+			name: "Decimal(10, 2)",
+			args: args{colName: "price", colType: "Decimal(10, 2)"},
+			want: &Column{Name: "price", Type: BaseType{Name: "Decimal(10, 2)", goType: reflect.TypeOf(float64(0))}},
+		},
 		{
 			name: "Array(String)",
 			args: args{colName: "tags", colType: "Array(String)"},
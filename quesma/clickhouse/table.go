@@ -8,10 +8,15 @@ import (
 	"quesma/logger"
 	"quesma/model"
 	"quesma/quesma/config"
+	"quesma/quesma/types"
 	"quesma/util"
+	"reflect"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type Table struct {
@@ -27,8 +32,53 @@ type Table struct {
 	Comment          string // this human-readable comment
 	CreateTableQuery string
 	TimestampColumn  *string
+	MessageField     string   // primary log message field from config, "" if not configured
+	SeqNoFields      []string // monotonic key backing `_seq_no` from config, empty if not configured
+	VersionField     string   // last-modified field backing hits' `_version` from config, "" if not configured
+	// fields matched case-insensitively by term queries, from config
+	CaseInsensitiveFields []string
+	// max length of fields' values matched by term queries (Elasticsearch's `ignore_above`), from config
+	IgnoreAbove map[string]int
+	// query (JSON) used when a search has no `query`, from config, "" if not configured
+	DefaultQuery string
+	// true <=> searches without any filter are rejected, from config
+	RejectUnboundedScans bool
+	// true <=> searches read with `select_sequential_consistency`, from config (global or the index's)
+	SequentialConsistency bool
+	// true <=> searches send `log_comment` setting identifying the request, from config
+	QueryLogComment bool
+	// max `from + size` of searches, from config, 0 if not configured (then config.DefaultMaxResultWindow applies)
+	MaxResultWindow int
+	// default timeout of searches, from config, 0 if not configured
+	QueryTimeout time.Duration
+	// parent/child relations stored in the table, from config, nil if not configured
+	Join *config.JoinConfiguration
+	// true <=> random_sampler aggregations return also an error estimate of their doc_count, from config
+	SamplingErrorEstimate bool
+	// true <=> all string columns are fulltext fields, if none is configured explicitly, from config
+	FullTextStringFieldsByDefault bool
+	// true <=> hits have `_type: _doc` for old clients, from (global) config
+	LegacyTypes bool
 }
 
+// HasSamplingKey returns true <=> the table has SAMPLE BY key, so queries can read only a sample of it with SAMPLE.
+// We only know it for tables discovered in ClickHouse, from their (normalized by ClickHouse) CREATE TABLE query.
+func (t *Table) HasSamplingKey() bool {
+	return strings.Contains(t.CreateTableQuery, " SAMPLE BY ")
+}
+
+func (t *Table) IsCaseInsensitiveField(fieldName string) bool {
+	return slices.Contains(t.CaseInsensitiveFields, fieldName)
+}
+
+// IsIgnoredValue returns true <=> `value` of `fieldName` is longer than its `ignore_above`, so it's not searchable
+func (t *Table) IsIgnoredValue(fieldName string, value string) bool {
+	ignoreAbove, ok := t.IgnoreAbove[fieldName]
+	return ok && utf8.RuneCountInString(value) > ignoreAbove
+}
+
+// GetFulltextFields returns fields configured as fulltext ones. If there are none, and FullTextStringFieldsByDefault
+// is set, it's all String and LowCardinality(String) columns.
 func (t *Table) GetFulltextFields() []string {
 	var res = make([]string, 0)
 	for _, col := range t.Cols {
@@ -36,9 +86,26 @@ func (t *Table) GetFulltextFields() []string {
 			res = append(res, col.Name)
 		}
 	}
+	if len(res) == 0 && t.FullTextStringFieldsByDefault {
+		for _, col := range t.Cols {
+			if col.Type.isString() {
+				res = append(res, col.Name)
+			}
+		}
+		slices.Sort(res) // so the generated SQL is deterministic
+	}
 	return res
 }
 
+// GetDefaultSearchFields returns fields searched by fulltext queries without explicit fields.
+// It's the message field, if configured, all fulltext fields otherwise.
+func (t *Table) GetDefaultSearchFields() []string {
+	if t.MessageField != "" && t.Cols[t.MessageField] != nil {
+		return []string{t.MessageField}
+	}
+	return t.GetFulltextFields()
+}
+
 func (t *Table) createTableOurFieldsString() []string {
 	rows := make([]string, 0)
 	if t.Config.hasOthers {
@@ -177,6 +244,35 @@ func (t *Table) GetDateTimeType(ctx context.Context, fieldName string) DateTimeT
 	return Invalid
 }
 
+// GetDecimalScale returns scale of the column `fieldName`, if it's a Decimal. isDecimal is false otherwise.
+func (t *Table) GetDecimalScale(fieldName string) (scale int, isDecimal bool) {
+	if col, ok := t.Cols[fieldName]; ok {
+		return DecimalScale(col.Type.String())
+	}
+	return 0, false
+}
+
+// IsNumeric returns true <=> the column `fieldName` holds numbers: integers, floats or decimals
+func (t *Table) IsNumeric(fieldName string) bool {
+	if col, ok := t.Cols[fieldName]; ok {
+		typeName := strings.TrimPrefix(col.Type.String(), "Nullable(")
+		for _, prefix := range []string{"Int", "UInt", "Float", "Decimal"} {
+			if strings.HasPrefix(typeName, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsArray returns true <=> the column `fieldName` is Array(...), so a single row holds multiple values of it
+func (t *Table) IsArray(fieldName string) bool {
+	if col, ok := t.Cols[fieldName]; ok {
+		return col.isArray()
+	}
+	return false
+}
+
 // applyIndexConfig applies full text search and alias configuration to the table
 func (t *Table) applyIndexConfig(configuration config.QuesmaConfiguration) {
 	for _, c := range t.Cols {
@@ -195,8 +291,24 @@ func (t *Table) applyIndexConfig(configuration config.QuesmaConfiguration) {
 			t.aliases[alias.SourceFieldName] = alias.TargetFieldName
 		}
 	}
+	t.SequentialConsistency = configuration.SequentialConsistency
+	t.LegacyTypes = configuration.LegacyTypes
 	if v, ok := configuration.IndexConfig[t.Name]; ok {
+		t.SequentialConsistency = t.SequentialConsistency || v.SequentialConsistency
+		t.QueryLogComment = v.QueryLogComment
+		t.MaxResultWindow = v.MaxResultWindow
+		t.QueryTimeout = v.QueryTimeout
+		t.SamplingErrorEstimate = v.SamplingErrorEstimate
+		t.FullTextStringFieldsByDefault = v.FullTextStringFieldsByDefault
 		t.TimestampColumn = v.TimestampField
+		t.MessageField = v.MessageField
+		t.SeqNoFields = v.SeqNoFields
+		t.VersionField = v.VersionField
+		t.CaseInsensitiveFields = v.CaseInsensitiveFields
+		t.IgnoreAbove = v.IgnoreAbove
+		t.DefaultQuery = v.DefaultQuery
+		t.RejectUnboundedScans = v.RejectUnboundedScans
+		t.Join = v.Join
 	}
 
 }
@@ -258,3 +370,56 @@ func (t *Table) GetTimestampFieldName() (string, error) {
 		return "", fmt.Errorf("no timestamp field configured for table %s", t.Name)
 	}
 }
+
+// documentFromRow rebuilds the document, which was inserted as `row`: its non-null columns are the document's fields,
+// flattened nested fields are nested back, and non-schema fields are unpacked from attributes and others.
+func (t *Table) documentFromRow(ctx context.Context, row model.QueryResultRow) types.JSON {
+	attributeArrays := make(map[string]any)
+	for _, a := range t.Config.attributes {
+		attributeArrays[a.KeysArrayName] = nil
+		attributeArrays[a.ValuesArrayName] = nil
+	}
+	document := make(types.JSON, len(row.Cols))
+	for _, col := range row.Cols {
+		value := col.ExtractValue(ctx)
+		if value == nil {
+			continue
+		}
+		if _, isAttributeArray := attributeArrays[col.ColName]; isAttributeArray {
+			attributeArrays[col.ColName] = value
+			continue
+		}
+		if col.ColName == othersFieldName && t.Config.hasOthers {
+			if others, ok := value.(map[string]any); ok {
+				for fieldName, fieldValue := range others {
+					document[fieldName] = fieldValue
+				}
+			}
+			continue
+		}
+		nested := document
+		path := strings.Split(col.ColName, NestedSeparator)
+		for _, key := range path[:len(path)-1] {
+			inner, ok := nested[key].(map[string]any)
+			if !ok {
+				inner = make(map[string]any)
+				nested[key] = inner
+			}
+			nested = inner
+		}
+		nested[path[len(path)-1]] = value
+	}
+	for _, a := range t.Config.attributes {
+		keys, _ := attributeArrays[a.KeysArrayName].([]string)
+		values := reflect.ValueOf(attributeArrays[a.ValuesArrayName])
+		if values.Kind() != reflect.Slice {
+			continue
+		}
+		for i, key := range keys {
+			if i < values.Len() {
+				document[key] = values.Index(i).Interface()
+			}
+		}
+	}
+	return document
+}
@@ -3,8 +3,12 @@
 package clickhouse
 
 import (
+	"context"
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/stretchr/testify/assert"
 	"quesma/model"
+	"quesma/quesma/config"
+	"quesma/tracing"
 	"testing"
 )
 
@@ -57,3 +61,79 @@ func TestApplyWildCard(t *testing.T) {
 		})
 	}
 }
+
+func TestQuerySettingsSequentialConsistency(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"consistent": {Name: "consistent", Enabled: true, SequentialConsistency: true},
+		"default":    {Name: "default", Enabled: true},
+	}}
+	query := &model.Query{GroupByStrategy: model.GroupByHash}
+
+	table := &Table{Name: "default", Cols: map[string]*Column{}}
+	table.applyIndexConfig(cfg)
+	assert.NotContains(t, querySettings(context.Background(), table, query), "select_sequential_consistency")
+
+	table = &Table{Name: "consistent", Cols: map[string]*Column{}}
+	table.applyIndexConfig(cfg)
+	assert.Equal(t, clickhouse.Settings{"optimize_aggregation_in_order": "0", "select_sequential_consistency": "1"}, querySettings(context.Background(), table, query))
+
+	// enabled globally, for all indexes
+	cfg.SequentialConsistency = true
+	table = &Table{Name: "default", Cols: map[string]*Column{}}
+	table.applyIndexConfig(cfg)
+	assert.Equal(t, clickhouse.Settings{"select_sequential_consistency": "1"}, querySettings(context.Background(), table, &model.Query{}))
+}
+
+func TestQuerySettingsLogComment(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"commented": {Name: "commented", Enabled: true, QueryLogComment: true},
+		"default":   {Name: "default", Enabled: true},
+	}}
+	ctx := context.WithValue(context.Background(), tracing.RequestIdCtxKey, "7d1b1dd1-4a8f-4e4a-9f4e-1d1c3a0b2c3d")
+	ctx = context.WithValue(ctx, tracing.RequestPath, "/commented/_search")
+
+	table := &Table{Name: "default", Cols: map[string]*Column{}}
+	table.applyIndexConfig(cfg)
+	assert.NotContains(t, querySettings(ctx, table, &model.Query{}), "log_comment")
+
+	table = &Table{Name: "commented", Cols: map[string]*Column{}}
+	table.applyIndexConfig(cfg)
+	assert.Equal(t, clickhouse.Settings{
+		"log_comment": `{"index":"commented","path":"/commented/_search","request_id":"7d1b1dd1-4a8f-4e4a-9f4e-1d1c3a0b2c3d"}`,
+	}, querySettings(ctx, table, &model.Query{}))
+
+	// no request id, e.g. in internal queries
+	assert.NotContains(t, querySettings(context.Background(), table, &model.Query{}), "log_comment")
+}
+
+func TestGetFulltextFieldsStringFieldsByDefault(t *testing.T) {
+	newTable := func(name string) *Table {
+		return &Table{Name: name, Cols: map[string]*Column{
+			"message":    {Name: "message", Type: NewBaseType("String")},
+			"level":      {Name: "level", Type: NewBaseType("LowCardinality(String)")},
+			"host":       {Name: "host", Type: BaseType{Name: "String", Nullable: true}},
+			"bytes":      {Name: "bytes", Type: NewBaseType("Int64")},
+			"tags":       {Name: "tags", Type: CompoundType{Name: "Array", BaseType: NewBaseType("String")}},
+			"@timestamp": {Name: "@timestamp", Type: NewBaseType("DateTime64")},
+		}}
+	}
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"default":  {Name: "default", Enabled: true},
+		"strings":  {Name: "strings", Enabled: true, FullTextStringFieldsByDefault: true},
+		"explicit": {Name: "explicit", Enabled: true, FullTextStringFieldsByDefault: true, FullTextFields: []string{"message"}},
+	}}
+
+	table := newTable("default")
+	table.applyIndexConfig(cfg)
+	assert.Empty(t, table.GetFulltextFields())
+
+	table = newTable("strings")
+	table.applyIndexConfig(cfg)
+	assert.Equal(t, []string{"host", "level", "message"}, table.GetFulltextFields())
+	assert.Equal(t, []string{"host", "level", "message"}, table.GetDefaultSearchFields())
+
+	// explicitly configured fields are used instead
+	table = newTable("explicit")
+	table.applyIndexConfig(cfg)
+	assert.Equal(t, []string{"message"}, table.GetFulltextFields())
+}
@@ -11,14 +11,17 @@ type SchemaTypeAdapter struct {
 }
 
 func (c SchemaTypeAdapter) Convert(s string) (schema.Type, bool) {
-	for isArray(s) {
-		s = arrayType(s)
+	if isArray(s) {
+		elementType, found := c.Convert(arrayType(s))
+		return elementType.AsArray(), found
 	}
 	switch {
 	case strings.HasPrefix(s, "Unknown"):
 		return schema.TypeText, true // TODO
 	case strings.HasPrefix(s, "Tuple"):
 		return schema.TypeObject, true
+	case strings.HasPrefix(s, "Decimal"):
+		return schema.TypeFloat, true
 	}
 
 	switch s {
@@ -38,6 +41,8 @@ func (c SchemaTypeAdapter) Convert(s string) (schema.Type, bool) {
 		return schema.TypeDate, true
 	case "Point":
 		return schema.TypePoint, true
+	case "IPv4", "IPv6":
+		return schema.TypeIp, true
 	default:
 		return schema.TypeUnknown, false
 	}
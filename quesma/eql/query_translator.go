@@ -13,10 +13,14 @@ import (
 	"quesma/queryparser"
 	"quesma/queryparser/query_util"
 	"quesma/quesma/types"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// timestampField orders events. Elastic's EQL can change it with `timestamp_field` parameter, we don't support it yet.
+const timestampField = "@timestamp"
+
 // It implements quesma.IQueryTranslator for EQL queries.
 
 type ClickhouseEQLQueryTranslator struct {
@@ -35,6 +39,24 @@ func (cw *ClickhouseEQLQueryTranslator) MakeSearchResponse(queries []*model.Quer
 	query := queries[0]
 	ResultSet := ResultSets[0]
 
+	if sequenceQuery, isSequence := query.Type.(SequenceQuery); isSequence {
+		sequences := sequenceQuery.MakeSequences(ResultSet)
+		return &model.SearchResp{
+			Hits: model.SearchHits{
+				Total: &model.Total{
+					Value:    len(sequences),
+					Relation: "eq",
+				},
+				Sequences: sequences,
+			},
+			Shards: model.ResponseShards{
+				Total:      1,
+				Successful: 1,
+				Failed:     0,
+			},
+		}
+	}
+
 	// This shares a lot of code with the ClickhouseQueryTranslator
 	//
 	hits := make([]model.SearchHit, len(ResultSet))
@@ -74,6 +96,15 @@ func (cw *ClickhouseEQLQueryTranslator) MakeSearchResponse(queries []*model.Quer
 }
 
 func (cw *ClickhouseEQLQueryTranslator) ParseQuery(body types.JSON) ([]*model.Query, bool, error) {
+	if isSequenceQuery(body) {
+		query, err := cw.parseSequenceQuery(body)
+		if err != nil {
+			logger.ErrorWithCtx(cw.Ctx).Msgf("error parsing sequence query: %v", err)
+			return nil, false, err
+		}
+		return []*model.Query{query}, true, nil
+	}
+
 	simpleQuery, queryInfo, highlighter, err := cw.parseQuery(body)
 
 	if err != nil {
@@ -88,7 +119,7 @@ func (cw *ClickhouseEQLQueryTranslator) ParseQuery(body types.JSON) ([]*model.Qu
 	if simpleQuery.CanParse {
 		canParse = true
 		query = query_util.BuildHitsQuery(cw.Ctx, cw.Table.Name, "*", &simpleQuery, queryInfo.I2)
-		queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, query.SelectCommand.OrderByFieldNames(), true, false, false)
+		queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, query.SelectCommand.OrderByFieldNames(), true, true, false, false)
 		query.Type = &queryType
 		query.Highlighter = highlighter
 		query.SelectCommand.OrderBy = simpleQuery.OrderBy
@@ -99,6 +130,54 @@ func (cw *ClickhouseEQLQueryTranslator) ParseQuery(body types.JSON) ([]*model.Qu
 	return nil, false, err
 }
 
+// FIXME this is a naive translation.
+// It should use the table schema to translate field names
+func columnName(fieldName string) string {
+	return strings.ReplaceAll(fieldName, ".", "::")
+}
+
+func translateName(name *transform.Symbol) (*transform.Symbol, error) {
+	res := "\"" + columnName(name.Name) + "\"" // TODO proper escaping
+	return transform.NewSymbol(res), nil
+}
+
+// isSequenceQuery returns true <=> body's EQL query is a `sequence`
+func isSequenceQuery(queryAsMap types.JSON) bool {
+	eqlQuery, ok := queryAsMap["query"].(string)
+	if !ok {
+		return false
+	}
+	p := NewEQL()
+	ast, err := p.Parse(eqlQuery)
+	return err == nil && p.IsSequence(ast)
+}
+
+func (cw *ClickhouseEQLQueryTranslator) parseSequenceQuery(queryAsMap types.JSON) (*model.Query, error) {
+	trans := NewTransformer()
+	trans.FieldNameTranslator = translateName
+	sequence, err := trans.TransformSequence(queryAsMap["query"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	size := sequenceDefaultSize
+	if sizeRaw, ok := queryAsMap["size"].(float64); ok {
+		size = int(sizeRaw)
+	}
+	columns := make([]string, 0, len(cw.Table.Cols))
+	for _, col := range cw.Table.Cols {
+		columns = append(columns, col.Name)
+	}
+	sort.Strings(columns)
+	query, err := buildSequenceQuery(cw.Table.Name, columns, sequence, size)
+	if err != nil {
+		return nil, err
+	}
+	joinKeys, _ := sequence.JoinKeys() // error already checked in buildSequenceQuery
+	query.Type = NewSequenceQuery(cw.Ctx, cw.Table.Name, joinKeys)
+	return query, nil
+}
+
 func (cw *ClickhouseEQLQueryTranslator) parseQuery(queryAsMap types.JSON) (query model.SimpleQuery, searchQueryInfo model.SearchQueryInfo, highlighter model.Highlighter, err error) {
 
 	// no highlighting here
@@ -117,14 +196,6 @@ func (cw *ClickhouseEQLQueryTranslator) parseQuery(queryAsMap types.JSON) (query
 		return query, model.NewSearchQueryInfoNormal(), highlighter, nil
 	}
 
-	// FIXME this is a naive translation.
-	// It should use the table schema to translate field names
-	translateName := func(name *transform.Symbol) (*transform.Symbol, error) {
-		res := strings.ReplaceAll(name.Name, ".", "::")
-		res = "\"" + res + "\"" // TODO proper escaping
-		return transform.NewSymbol(res), nil
-	}
-
 	trans := NewTransformer()
 	trans.FieldNameTranslator = translateName
 
@@ -141,7 +212,7 @@ func (cw *ClickhouseEQLQueryTranslator) parseQuery(queryAsMap types.JSON) (query
 
 	query.WhereClause = model.NewLiteral(where) // @TODO that's to be fixed
 	query.CanParse = true
-	query.OrderBy = []model.OrderByExpr{model.NewSortColumn("@timestamp", model.DescOrder)}
+	query.OrderBy = []model.OrderByExpr{model.NewSortColumn(timestampField, model.DescOrder)}
 
 	return query, searchQueryInfo, highlighter, nil
 }
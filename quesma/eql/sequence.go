@@ -0,0 +1,121 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package eql
+
+import (
+	"fmt"
+	"quesma/eql/parser"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Sequence is an EQL `sequence` query, e.g.
+// `sequence by host.name with maxspan=5m [process where process.name == "cmd.exe"] [network where true]`
+type Sequence struct {
+	MaxSpan time.Duration // 0 <=> no limit
+	Steps   []SequenceStep
+}
+
+type SequenceStep struct {
+	Where string   // condition of the step, already translated to Clickhouse
+	By    []string // join keys (EQL field names). Common `by` of the whole sequence is included.
+}
+
+// JoinKeys returns join keys of the sequence, if they're the same for every step (we don't support different ones yet)
+func (s *Sequence) JoinKeys() ([]string, error) {
+	if len(s.Steps) == 0 {
+		return nil, nil
+	}
+	for _, step := range s.Steps[1:] {
+		if !slices.Equal(step.By, s.Steps[0].By) {
+			return nil, fmt.Errorf("sequence steps with different join keys are not supported: %v, %v", s.Steps[0].By, step.By)
+		}
+	}
+	return s.Steps[0].By, nil
+}
+
+func (s *EQL) IsSequence(ast parser.IQueryContext) bool {
+	return ast.SequenceQuery() != nil && len(ast.AllPipe()) == 0
+}
+
+// TransformSequence parses EQL `sequence` query and translates conditions of its steps to Clickhouse,
+// same way as TransformQuery does for simple queries.
+func (t *Transformer) TransformSequence(query string) (*Sequence, error) {
+	p := NewEQL()
+	ast, err := p.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsSequence(ast) {
+		return nil, fmt.Errorf("not a sequence query: '%s'", query)
+	}
+	sequenceAst := ast.SequenceQuery()
+
+	sequence := &Sequence{}
+	if interval := sequenceAst.Interval(); interval != nil {
+		if sequence.MaxSpan, err = parseMaxSpan(interval.GetText()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Grammar doesn't name `by` lists, so we tell them apart by position: the sequence's one is before the first step,
+	// a step's one - right after it.
+	steps := sequenceAst.AllSimpleQuery()
+	var sequenceBy []string
+	stepsBy := make([][]string, len(steps))
+	for _, fieldList := range sequenceAst.AllFieldList() {
+		fields := make([]string, 0, len(fieldList.AllField()))
+		for _, field := range fieldList.AllField() {
+			fields = append(fields, field.GetText())
+		}
+		stepIdx := -1
+		for i, step := range steps {
+			if step.GetStart().GetTokenIndex() < fieldList.GetStart().GetTokenIndex() {
+				stepIdx = i
+			}
+		}
+		if stepIdx == -1 {
+			sequenceBy = fields
+		} else {
+			stepsBy[stepIdx] = fields
+		}
+	}
+
+	for i, step := range steps {
+		where, _, err := t.transformParseTree(step)
+		if err != nil {
+			return nil, fmt.Errorf("sequence step %d: %w", i+1, err)
+		}
+		sequence.Steps = append(sequence.Steps, SequenceStep{
+			Where: where,
+			By:    append(slices.Clone(sequenceBy), stepsBy[i]...),
+		})
+	}
+	return sequence, nil
+}
+
+// parseMaxSpan parses EQL time span, e.g. 10s, 5m
+func parseMaxSpan(interval string) (time.Duration, error) {
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("invalid maxspan: '%s'", interval)
+	}
+	value, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid maxspan: '%s'", interval)
+	}
+	var unit time.Duration
+	switch interval[len(interval)-1] {
+	case 's':
+		unit = time.Second
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("invalid maxspan unit: '%s'", interval)
+	}
+	return time.Duration(value) * unit, nil
+}
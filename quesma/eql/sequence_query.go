@@ -0,0 +1,187 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package eql
+
+import (
+	"context"
+	"fmt"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/util"
+	"strconv"
+)
+
+const (
+	sequenceStepColumn              = "eql_sequence_step"
+	sequencePreviousStepColumn      = "eql_previous_step"
+	sequencePreviousTimestampColumn = "eql_previous_timestamp"
+	sequenceNextStepColumn          = "eql_next_step"
+	sequenceNextTimestampColumn     = "eql_next_timestamp"
+
+	sequenceDefaultSize = 10
+)
+
+// buildSequenceQuery translates a two-step sequence to a query returning events of matching sequences,
+// ordered so that every sequence is two consecutive rows: its first and second event.
+//
+// We number events by the step they match, and for each of them look at the previous and the next event
+// with the same join keys (window functions over events ordered by timestamp). A sequence is a 1st step event
+// directly followed by a 2nd step event (within maxspan). It's what Elastic returns: if there are more 1st step
+// events before the 2nd step one, only the most recent one starts the sequence.
+// Events are returned with `columns` of the table only, not with helper columns of the subqueries.
+func buildSequenceQuery(tableName string, columns []string, sequence *Sequence, size int) (*model.Query, error) {
+	if len(sequence.Steps) != 2 {
+		return nil, fmt.Errorf("only sequences with 2 steps are supported, got %d", len(sequence.Steps))
+	}
+	joinKeys, err := sequence.JoinKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := model.NewColumnRef(columnName(timestampField))
+	partitionBy := make([]model.Expr, 0, len(joinKeys))
+	for _, joinKey := range joinKeys {
+		partitionBy = append(partitionBy, model.NewColumnRef(columnName(joinKey)))
+	}
+	stepConditions := make([]model.Expr, 0, len(sequence.Steps))
+	stepNumbers := make([]model.Expr, 0, 2*len(sequence.Steps)+1)
+	for i, step := range sequence.Steps {
+		stepConditions = append(stepConditions, model.NewLiteral(step.Where))
+		stepNumbers = append(stepNumbers, model.NewLiteral(step.Where), model.NewLiteral(i+1))
+	}
+	stepNumbers = append(stepNumbers, model.NewLiteral(0))
+
+	events := model.NewSelectCommand(
+		[]model.Expr{model.NewWildcardExpr, model.NewAliasedExpr(model.NewFunction("multiIf", stepNumbers...), sequenceStepColumn)},
+		nil, nil, model.NewTableRef(tableName), model.Or(stepConditions), 0, 0, false)
+
+	step := model.NewColumnRef(sequenceStepColumn)
+	previousEvent := func(column model.Expr, direction model.OrderByDirection) model.Expr {
+		return model.NewWindowFunction("lagInFrame", []model.Expr{column}, partitionBy, model.NewOrderByExpr([]model.Expr{timestamp}, direction))
+	}
+	eventsWithNeighbours := model.NewSelectCommand(
+		[]model.Expr{
+			model.NewWildcardExpr,
+			model.NewAliasedExpr(previousEvent(step, model.AscOrder), sequencePreviousStepColumn),
+			model.NewAliasedExpr(previousEvent(timestamp, model.AscOrder), sequencePreviousTimestampColumn),
+			model.NewAliasedExpr(previousEvent(step, model.DescOrder), sequenceNextStepColumn),
+			model.NewAliasedExpr(previousEvent(timestamp, model.DescOrder), sequenceNextTimestampColumn),
+		},
+		nil, nil, *events, nil, 0, 0, false)
+
+	equals := func(column string, value int) model.Expr {
+		return model.NewInfixExpr(model.NewColumnRef(column), "=", model.NewLiteral(value))
+	}
+	withinMaxSpan := func(from, to model.Expr) model.Expr {
+		if sequence.MaxSpan == 0 {
+			return nil
+		}
+		maxSpan := model.NewFunction("toIntervalMillisecond", model.NewLiteral(sequence.MaxSpan.Milliseconds()))
+		return model.NewInfixExpr(to, "<=", model.NewInfixExpr(from, "+", maxSpan))
+	}
+	firstEvent := model.And([]model.Expr{equals(sequenceStepColumn, 1), equals(sequenceNextStepColumn, 2),
+		withinMaxSpan(timestamp, model.NewColumnRef(sequenceNextTimestampColumn))})
+	secondEvent := model.And([]model.Expr{equals(sequenceStepColumn, 2), equals(sequencePreviousStepColumn, 1),
+		withinMaxSpan(model.NewColumnRef(sequencePreviousTimestampColumn), timestamp)})
+
+	// order by sequence's start, then its join keys (sequences can start at the same time), then step
+	sequenceStart := model.NewFunction("if", equals(sequenceStepColumn, 1), timestamp, model.NewColumnRef(sequencePreviousTimestampColumn))
+	orderBy := []model.OrderByExpr{model.NewOrderByExpr([]model.Expr{sequenceStart}, model.AscOrder)}
+	for _, joinKey := range partitionBy {
+		orderBy = append(orderBy, model.NewOrderByExpr([]model.Expr{joinKey}, model.AscOrder))
+	}
+	orderBy = append(orderBy, model.NewOrderByExpr([]model.Expr{step}, model.AscOrder))
+
+	selectColumns := make([]model.Expr, 0, len(columns)+1)
+	selectColumns = append(selectColumns, step)
+	for _, column := range columns {
+		selectColumns = append(selectColumns, model.NewColumnRef(column))
+	}
+	query := &model.Query{
+		SelectCommand: *model.NewSelectCommand(selectColumns, nil, orderBy, *eventsWithNeighbours,
+			model.Or([]model.Expr{firstEvent, secondEvent}), len(sequence.Steps)*size, 0, false),
+		TableName: tableName,
+	}
+	return query, nil
+}
+
+// SequenceQuery is a type of query built by buildSequenceQuery
+type SequenceQuery struct {
+	ctx       context.Context
+	indexName string
+	joinKeys  []string // column names
+}
+
+func NewSequenceQuery(ctx context.Context, indexName string, joinKeys []string) SequenceQuery {
+	columns := make([]string, 0, len(joinKeys))
+	for _, joinKey := range joinKeys {
+		columns = append(columns, columnName(joinKey))
+	}
+	return SequenceQuery{ctx: ctx, indexName: indexName, joinKeys: columns}
+}
+
+func (query SequenceQuery) IsBucketAggregation() bool {
+	return false
+}
+
+func (query SequenceQuery) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	return []model.JsonMap{{"sequences": query.MakeSequences(rows)}}
+}
+
+// MakeSequences groups events (rows) into sequences: every sequence is a sequence of rows with 1st, 2nd, ..., step event
+func (query SequenceQuery) MakeSequences(rows []model.QueryResultRow) []model.EQLSequence {
+	sequences := make([]model.EQLSequence, 0)
+	var current *model.EQLSequence
+	for i, row := range rows {
+		step, event := query.makeEvent(row, strconv.Itoa(i+1))
+		switch {
+		case step == 1:
+			sequences = append(sequences, model.EQLSequence{JoinKeys: query.joinKeyValues(row), Events: []model.SearchHit{event}})
+			current = &sequences[len(sequences)-1]
+		case current != nil && step == len(current.Events)+1:
+			current.Events = append(current.Events, event)
+		default:
+			logger.WarnWithCtx(query.ctx).Msgf("unexpected event of step %d in sequence results, skipping: %v", step, row)
+		}
+	}
+	return sequences
+}
+
+func (query SequenceQuery) String() string {
+	return "sequence"
+}
+
+func (query SequenceQuery) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
+	return rowsFromDB
+}
+
+// makeEvent returns the step of the event in `row` and the event itself (without our helper column)
+func (query SequenceQuery) makeEvent(row model.QueryResultRow, id string) (step int, event model.SearchHit) {
+	source := model.QueryResultRow{Index: row.Index, Cols: make([]model.QueryResultCol, 0, len(row.Cols))}
+	for _, col := range row.Cols {
+		if col.ColName == sequenceStepColumn {
+			stepValue, _ := util.ExtractNumeric64Maybe(col.Value)
+			step = int(stepValue)
+			continue
+		}
+		source.Cols = append(source.Cols, col)
+	}
+	event = model.NewSearchHit(query.indexName)
+	event.ID = id
+	event.Source = []byte(source.String(query.ctx))
+	return step, event
+}
+
+func (query SequenceQuery) joinKeyValues(row model.QueryResultRow) []any {
+	values := make([]any, 0, len(query.joinKeys))
+	for _, joinKey := range query.joinKeys {
+		var value any
+		for _, col := range row.Cols {
+			if col.ColName == joinKey {
+				value = col.Value
+			}
+		}
+		values = append(values, value)
+	}
+	return values
+}
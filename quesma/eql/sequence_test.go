@@ -0,0 +1,124 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package eql
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/model"
+	"quesma/quesma/types"
+	"testing"
+	"time"
+)
+
+func TestTransformSequence(t *testing.T) {
+
+	tests := []struct {
+		eql              string
+		expectedSequence *Sequence
+	}{
+		{`sequence by host with maxspan=5m [process where process.name == "cmd.exe"] [network where true]`,
+			&Sequence{MaxSpan: 5 * time.Minute, Steps: []SequenceStep{
+				{Where: `((process.name = 'cmd.exe') AND (event.category = 'process'))`, By: []string{"host"}},
+				{Where: `(true AND (event.category = 'network'))`, By: []string{"host"}},
+			}}},
+
+		{`sequence [process where true] by host [network where true] by host`,
+			&Sequence{Steps: []SequenceStep{
+				{Where: `(true AND (event.category = 'process'))`, By: []string{"host"}},
+				{Where: `(true AND (event.category = 'network'))`, By: []string{"host"}},
+			}}},
+
+		{`sequence by host [process where true] by user [network where true] by user`,
+			&Sequence{Steps: []SequenceStep{
+				{Where: `(true AND (event.category = 'process'))`, By: []string{"host", "user"}},
+				{Where: `(true AND (event.category = 'network'))`, By: []string{"host", "user"}},
+			}}},
+
+		{`sequence with maxspan=2h [any where true] [any where true]`,
+			&Sequence{MaxSpan: 2 * time.Hour, Steps: []SequenceStep{
+				{Where: `true`},
+				{Where: `true`},
+			}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eql, func(t *testing.T) {
+			sequence, err := NewTransformer().TransformSequence(tt.eql)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSequence, sequence)
+		})
+	}
+}
+
+func TestSequenceQuery(t *testing.T) {
+	table := &clickhouse.Table{Name: "logs", Cols: map[string]*clickhouse.Column{
+		"@timestamp":      {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+		"event::category": {Name: "event::category", Type: clickhouse.NewBaseType("String")},
+		"host":            {Name: "host", Type: clickhouse.NewBaseType("String")},
+		"process::name":   {Name: "process::name", Type: clickhouse.NewBaseType("String")},
+	}}
+	cw := ClickhouseEQLQueryTranslator{Table: table, Ctx: context.Background()}
+
+	queries, canParse, err := cw.ParseQuery(types.JSON{
+		"query": `sequence by host [process where process.name == "cmd.exe"] [network where true]`,
+		"size":  float64(5),
+	})
+	assert.NoError(t, err)
+	assert.True(t, canParse)
+	assert.Len(t, queries, 1)
+	// only the step and columns of the table, not helper columns of subqueries, are selected
+	assert.Equal(t, `SELECT "eql_sequence_step", "@timestamp", "event::category", "host", "process::name" FROM (`+
+		`SELECT *, `+
+		`lagInFrame("eql_sequence_step") OVER (PARTITION BY "host" ORDER BY "@timestamp" ASC) AS "eql_previous_step", `+
+		`lagInFrame("@timestamp") OVER (PARTITION BY "host" ORDER BY "@timestamp" ASC) AS "eql_previous_timestamp", `+
+		`lagInFrame("eql_sequence_step") OVER (PARTITION BY "host" ORDER BY "@timestamp" DESC) AS "eql_next_step", `+
+		`lagInFrame("@timestamp") OVER (PARTITION BY "host" ORDER BY "@timestamp" DESC) AS "eql_next_timestamp" `+
+		`FROM (`+
+		`SELECT *, multiIf((("process::name" = 'cmd.exe') AND ("event::category" = 'process')),1,(true AND ("event::category" = 'network')),2,0) AS "eql_sequence_step" `+
+		`FROM logs `+
+		`WHERE ((("process::name" = 'cmd.exe') AND ("event::category" = 'process')) OR (true AND ("event::category" = 'network'))))) `+
+		`WHERE (("eql_sequence_step"=1 AND "eql_next_step"=2) OR ("eql_sequence_step"=2 AND "eql_previous_step"=1)) `+
+		`ORDER BY if("eql_sequence_step"=1,"@timestamp","eql_previous_timestamp") ASC, "host" ASC, "eql_sequence_step" ASC `+
+		`LIMIT 10`,
+		model.AsString(queries[0].SelectCommand))
+
+	_, _, err = cw.ParseQuery(types.JSON{"query": `sequence [process where true] [network where true] [file where true]`})
+	assert.Error(t, err)
+	_, _, err = cw.ParseQuery(types.JSON{"query": `sequence [process where true] by host [network where true] by user`})
+	assert.Error(t, err)
+}
+
+func TestSequenceResponse(t *testing.T) {
+	cw := ClickhouseEQLQueryTranslator{Table: &clickhouse.Table{Name: "logs"}, Ctx: context.Background()}
+	queries, _, err := cw.ParseQuery(types.JSON{"query": `sequence by host [process where true] [network where true]`})
+	assert.NoError(t, err)
+
+	event := func(step uint8, host, name string) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol(sequenceStepColumn, step),
+			model.NewQueryResultCol("host", host),
+			model.NewQueryResultCol("name", name),
+		}}
+	}
+	rows := []model.QueryResultRow{
+		event(1, "a", "process a"),
+		event(2, "a", "network a"),
+		event(1, "b", "process b"),
+		event(2, "b", "network b"),
+	}
+
+	response := cw.MakeSearchResponse(queries, [][]model.QueryResultRow{rows})
+	assert.Equal(t, 2, response.Hits.Total.Value)
+	assert.Empty(t, response.Hits.Events)
+	assert.Len(t, response.Hits.Sequences, 2)
+	for i, host := range []string{"a", "b"} {
+		sequence := response.Hits.Sequences[i]
+		assert.Equal(t, []any{host}, sequence.JoinKeys)
+		assert.Len(t, sequence.Events, 2)
+		assert.JSONEq(t, `{"host": "`+host+`", "name": "process `+host+`"}`, string(sequence.Events[0].Source))
+		assert.JSONEq(t, `{"host": "`+host+`", "name": "network `+host+`"}`, string(sequence.Events[1].Source))
+		assert.Equal(t, "logs", sequence.Events[0].Index)
+	}
+}
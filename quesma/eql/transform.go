@@ -4,6 +4,7 @@ package eql
 
 import (
 	"fmt"
+	"github.com/antlr4-go/antlr/v4"
 	"quesma/eql/transform"
 )
 
@@ -43,10 +44,16 @@ func (t *Transformer) TransformQuery(query string) (string, map[string]interface
 		return "", nil, fmt.Errorf("unsupported query type") // TODO proper error message
 	}
 
+	return t.transformParseTree(ast)
+}
+
+// transformParseTree does steps 2-5 of TransformQuery for an already parsed query, or its part (e.g. a step of a sequence)
+func (t *Transformer) transformParseTree(tree antlr.ParseTree) (string, map[string]interface{}, error) {
+
 	// 2. Convert EQL to Exp model
 	eql2ExpTransformer := transform.NewEQLParseTreeToExpTransformer()
 	var exp transform.Exp
-	exp = ast.Accept(eql2ExpTransformer).(transform.Exp)
+	exp = tree.Accept(eql2ExpTransformer).(transform.Exp)
 	if len(eql2ExpTransformer.Errors) > 0 {
 		return "", nil, fmt.Errorf("eql2exp conversion errors: count=%d, %v", len(eql2ExpTransformer.Errors), eql2ExpTransformer.Errors)
 	}
@@ -10,21 +10,26 @@ import (
 	"quesma/logger"
 	"quesma/quesma/config"
 	"strconv"
+	"time"
 )
 
+// elasticHealthCheckTimeout bounds each request to Elasticsearch, so a hanging one doesn't block the health check
+const elasticHealthCheckTimeout = 5 * time.Second
+
 type ElasticHealthChecker struct {
-	cfg config.QuesmaConfiguration
+	cfg        config.QuesmaConfiguration
+	httpClient *http.Client
 }
 
 func NewElasticHealthChecker(cfg config.QuesmaConfiguration) Checker {
-	return &ElasticHealthChecker{cfg: cfg}
+	return &ElasticHealthChecker{cfg: cfg, httpClient: &http.Client{Timeout: elasticHealthCheckTimeout}}
 }
 
 func (c *ElasticHealthChecker) checkIfElasticsearchDiskIsFull() (isFull bool, reason string) {
 	const catAllocationPath = "/_cat/allocation?format=json"
 	const maxDiskPercent = 90
 
-	resp, err := http.Get(c.cfg.Elasticsearch.Url.String() + catAllocationPath)
+	resp, err := c.httpClient.Get(c.cfg.Elasticsearch.Url.String() + catAllocationPath)
 	if err != nil {
 		return
 	}
@@ -62,7 +67,7 @@ func (c *ElasticHealthChecker) checkIfElasticsearchDiskIsFull() (isFull bool, re
 func (c *ElasticHealthChecker) CheckHealth() Status {
 	const elasticsearchHealthPath = "/_cluster/health/*"
 
-	resp, err := http.Get(c.cfg.Elasticsearch.Url.String() + elasticsearchHealthPath)
+	resp, err := c.httpClient.Get(c.cfg.Elasticsearch.Url.String() + elasticsearchHealthPath)
 	if err != nil {
 		return NewStatus("red", "Ping failed", err.Error())
 	}
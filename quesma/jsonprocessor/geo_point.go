@@ -0,0 +1,184 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package jsonprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TODO suffixes ::lat, ::lon are hardcoded for now
+	geoPointLatSuffix = "::lat"
+	geoPointLonSuffix = "::lon"
+
+	geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// RewriteGeoPoints rewrites values of geo_point `Fields` into their two coordinate columns: `field::lat` and `field::lon`.
+// Like in Elasticsearch, a value can be an object with "lat" and "lon", a "lat,lon" string, a geohash, or a [lon, lat] array.
+// Fields with dots are looked up both as they are, and in nested objects (e.g. `geo.location` in {"geo": {"location": ...}}).
+type RewriteGeoPoints struct {
+	Fields []string
+}
+
+func (t *RewriteGeoPoints) Transform(data map[string]interface{}) (map[string]interface{}, error) {
+	for _, field := range t.Fields {
+		value, found := takeField(data, field)
+		if !found || value == nil {
+			continue
+		}
+		lat, lon, err := parseGeoPoint(value)
+		if err != nil {
+			return nil, geoPointParsingError(field, err)
+		}
+		column := strings.ReplaceAll(field, ".", "::")
+		data[column+geoPointLatSuffix] = lat
+		data[column+geoPointLonSuffix] = lon
+	}
+	return data, nil
+}
+
+// Validate returns an error, if a value of any of `Fields` in `data` isn't a valid geo_point. `data` isn't modified.
+func (t *RewriteGeoPoints) Validate(data map[string]interface{}) error {
+	for _, field := range t.Fields {
+		value, found := lookupField(data, field)
+		if !found || value == nil {
+			continue
+		}
+		if _, _, err := parseGeoPoint(value); err != nil {
+			return geoPointParsingError(field, err)
+		}
+	}
+	return nil
+}
+
+// geoPointParsingError is worded like Elasticsearch's mapper_parsing_exception
+func geoPointParsingError(field string, err error) error {
+	return fmt.Errorf("failed to parse field [%s] of type [geo_point]: %v", field, err)
+}
+
+// lookupField returns the value of `field` in `data`, like takeField, but without removing it
+func lookupField(data map[string]interface{}, field string) (interface{}, bool) {
+	if value, found := data[field]; found {
+		return value, true
+	}
+	parent, child, isNested := strings.Cut(field, ".")
+	if !isNested {
+		return nil, false
+	}
+	nested, ok := data[parent].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupField(nested, child)
+}
+
+// takeField removes `field` from `data` and returns its value
+func takeField(data map[string]interface{}, field string) (interface{}, bool) {
+	if value, found := data[field]; found {
+		delete(data, field)
+		return value, true
+	}
+	parent, child, isNested := strings.Cut(field, ".")
+	if !isNested {
+		return nil, false
+	}
+	nested, ok := data[parent].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	value, found := takeField(nested, child)
+	if found && len(nested) == 0 {
+		delete(data, parent)
+	}
+	return value, found
+}
+
+func parseGeoPoint(value interface{}) (lat, lon float64, err error) {
+	switch point := value.(type) {
+	case map[string]interface{}:
+		if lat, err = parseCoordinate(point["lat"]); err != nil {
+			return 0, 0, fmt.Errorf("invalid lat: %v", err)
+		}
+		if lon, err = parseCoordinate(point["lon"]); err != nil {
+			return 0, 0, fmt.Errorf("invalid lon: %v", err)
+		}
+	case []interface{}:
+		// GeoJSON order
+		if len(point) != 2 {
+			return 0, 0, fmt.Errorf("geo_point array must be [lon, lat], got: %v", point)
+		}
+		if lon, err = parseCoordinate(point[0]); err != nil {
+			return 0, 0, fmt.Errorf("invalid lon: %v", err)
+		}
+		if lat, err = parseCoordinate(point[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid lat: %v", err)
+		}
+	case string:
+		if latStr, lonStr, isPair := strings.Cut(point, ","); isPair {
+			if lat, err = parseCoordinate(latStr); err != nil {
+				return 0, 0, fmt.Errorf("invalid lat: %v", err)
+			}
+			if lon, err = parseCoordinate(lonStr); err != nil {
+				return 0, 0, fmt.Errorf("invalid lon: %v", err)
+			}
+		} else if lat, lon, err = decodeGeohash(point); err != nil {
+			return 0, 0, err
+		}
+	default:
+		return 0, 0, fmt.Errorf("unsupported geo_point value: %v (type: %T)", value, value)
+	}
+	if lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("lat %v out of range [-90, 90]", lat)
+	}
+	if lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("lon %v out of range [-180, 180]", lon)
+	}
+	return lat, lon, nil
+}
+
+func parseCoordinate(value interface{}) (float64, error) {
+	switch coordinate := value.(type) {
+	case float64:
+		return coordinate, nil
+	case int:
+		return float64(coordinate), nil
+	case int64:
+		return float64(coordinate), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(coordinate), 64)
+	default:
+		return 0, fmt.Errorf("not a number: %v", value)
+	}
+}
+
+// decodeGeohash returns the center of `geohash` cell
+func decodeGeohash(geohash string) (lat, lon float64, err error) {
+	if geohash == "" {
+		return 0, 0, fmt.Errorf("empty geohash")
+	}
+	latRange, lonRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	isLonBit := true // bits interleave lon and lat, starting with lon
+	for _, char := range strings.ToLower(geohash) {
+		index := strings.IndexRune(geohashAlphabet, char)
+		if index < 0 {
+			return 0, 0, fmt.Errorf("invalid geohash: %s", geohash)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			coordinateRange := &latRange
+			if isLonBit {
+				coordinateRange = &lonRange
+			}
+			middle := (coordinateRange[0] + coordinateRange[1]) / 2
+			if index&(1<<bit) != 0 {
+				coordinateRange[0] = middle
+			} else {
+				coordinateRange[1] = middle
+			}
+			isLonBit = !isLonBit
+		}
+	}
+	return (latRange[0] + latRange[1]) / 2, (lonRange[0] + lonRange[1]) / 2, nil
+}
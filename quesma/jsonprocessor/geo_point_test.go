@@ -0,0 +1,80 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package jsonprocessor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRewriteGeoPoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		wantLat float64
+		wantLon float64
+	}{
+		{
+			name:    "object",
+			data:    map[string]interface{}{"location": map[string]interface{}{"lat": 40.0, "lon": -70.0}, "message": "m"},
+			wantLat: 40,
+			wantLon: -70,
+		},
+		{
+			name:    "object with string coordinates",
+			data:    map[string]interface{}{"location": map[string]interface{}{"lat": "40.5", "lon": "-70.25"}, "message": "m"},
+			wantLat: 40.5,
+			wantLon: -70.25,
+		},
+		{
+			name:    "lat,lon string",
+			data:    map[string]interface{}{"location": "40, -70", "message": "m"},
+			wantLat: 40,
+			wantLon: -70,
+		},
+		{
+			name:    "geohash",
+			data:    map[string]interface{}{"location": "drm3btev3e", "message": "m"},
+			wantLat: 41.12,
+			wantLon: -71.34,
+		},
+		{
+			name:    "[lon, lat] array",
+			data:    map[string]interface{}{"location": []interface{}{-70.0, 40.0}, "message": "m"},
+			wantLat: 40,
+			wantLon: -70,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transformer := &RewriteGeoPoints{Fields: []string{"location"}}
+			result, err := transformer.Transform(tt.data)
+			assert.NoError(t, err)
+			assert.Len(t, result, 3)
+			assert.NotContains(t, result, "location")
+			assert.Equal(t, "m", result["message"])
+			assert.InDelta(t, tt.wantLat, result["location::lat"], 0.01)
+			assert.InDelta(t, tt.wantLon, result["location::lon"], 0.01)
+		})
+	}
+}
+
+func TestRewriteGeoPointsNested(t *testing.T) {
+	transformer := &RewriteGeoPoints{Fields: []string{"geo.location", "missing"}}
+	result, err := transformer.Transform(map[string]interface{}{"geo": map[string]interface{}{"location": "40,-70"}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"geo::location::lat": 40.0, "geo::location::lon": -70.0}, result)
+}
+
+func TestRewriteGeoPointsInvalid(t *testing.T) {
+	for _, value := range []interface{}{"40,abc", "not-a-geohash!", "91,0", map[string]interface{}{"lat": 40.0}, []interface{}{1.0}, true} {
+		transformer := &RewriteGeoPoints{Fields: []string{"location"}}
+		data := map[string]interface{}{"location": value}
+		assert.Error(t, transformer.Validate(data), "value: %v", value)
+		assert.Equal(t, map[string]interface{}{"location": value}, data, "Validate mustn't modify data")
+		_, err := transformer.Transform(data)
+		assert.ErrorContains(t, err, "failed to parse field [location] of type [geo_point]", "value: %v", value)
+	}
+	assert.NoError(t, (&RewriteGeoPoints{Fields: []string{"geo.location"}}).Validate(
+		map[string]interface{}{"geo": map[string]interface{}{"location": "40,-70"}}))
+}
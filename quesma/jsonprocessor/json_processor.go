@@ -4,24 +4,81 @@ package jsonprocessor
 
 import (
 	"fmt"
+	"quesma/logger"
+	"quesma/quesma/config"
+	"slices"
 )
 
 func FlattenMap(data map[string]interface{}, nestedSeparator string) map[string]interface{} {
 	flattened := make(map[string]interface{})
+	flattenInto(data, "", nestedSeparator, func(key string, value interface{}) {
+		flattened[key] = value
+	})
+	return flattened
+}
 
-	for key, value := range data {
-		switch nested := value.(type) {
-		case map[string]interface{}:
-			nestedFlattened := FlattenMap(nested, nestedSeparator)
-			for nestedKey, nestedValue := range nestedFlattened {
-				flattened[fmt.Sprintf("%s%s%s", key, nestedSeparator, nestedKey)] = nestedValue
+// FlattenMapWithCollisionPolicy works like FlattenMap, but handles fields colliding after flattening
+// (e.g. `a.b` and `a: {b: ...}` with "." separator) according to `policy`: one of config.FlattenCollisionPolicy*.
+// Fields are processed in sorted order, so the result is deterministic.
+func FlattenMapWithCollisionPolicy(data map[string]interface{}, nestedSeparator, policy string) (map[string]interface{}, error) {
+	flattened := make(map[string]interface{})
+	collisions := make(map[string]int)
+	var err error
+	flattenInto(data, "", nestedSeparator, func(key string, value interface{}) {
+		existing, exists := flattened[key]
+		if !exists {
+			flattened[key] = value
+			return
+		}
+		collisions[key]++
+		logger.Warn().Msgf("field %s collides with another one after flattening, policy: %s", key, policy)
+		switch policy {
+		case config.FlattenCollisionPolicyMerge:
+			if collisions[key] == 1 {
+				flattened[key] = []interface{}{existing, value}
+			} else {
+				flattened[key] = append(existing.([]interface{}), value)
+			}
+		case config.FlattenCollisionPolicySuffix:
+			suffixedKey := fmt.Sprintf("%s_%d", key, collisions[key])
+			for _, taken := flattened[suffixedKey]; taken; _, taken = flattened[suffixedKey] {
+				collisions[key]++
+				suffixedKey = fmt.Sprintf("%s_%d", key, collisions[key])
+			}
+			flattened[suffixedKey] = value
+		case config.FlattenCollisionPolicyReject:
+			if err == nil {
+				err = fmt.Errorf("field %s occurs more than once after flattening", key)
 			}
 		default:
 			flattened[key] = value
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
+	return flattened, nil
+}
 
-	return flattened
+func flattenInto(data map[string]interface{}, prefix, nestedSeparator string, emit func(key string, value interface{})) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		fullKey := key
+		if prefix != "" {
+			fullKey = fmt.Sprintf("%s%s%s", prefix, nestedSeparator, key)
+		}
+		switch nested := data[key].(type) {
+		case map[string]interface{}:
+			flattenInto(nested, fullKey, nestedSeparator, emit)
+		default:
+			emit(fullKey, nested)
+		}
+	}
 }
 
 type RewriteArrayOfObject struct{}
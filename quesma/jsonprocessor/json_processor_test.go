@@ -5,6 +5,7 @@ package jsonprocessor
 import (
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"quesma/quesma/config"
 	"quesma/quesma/types"
 	"reflect"
 	"testing"
@@ -47,6 +48,50 @@ func TestFlattenMap(t *testing.T) {
 	}
 }
 
+func TestFlattenMapWithCollisionPolicy(t *testing.T) {
+	document := func() map[string]interface{} {
+		return map[string]interface{}{
+			"a::b": "flat",
+			"a":    map[string]interface{}{"b": "nested", "c": "other"},
+		}
+	}
+	tests := []struct {
+		policy  string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			policy: config.FlattenCollisionPolicyMerge,
+			want:   map[string]interface{}{"a::b": []interface{}{"nested", "flat"}, "a::c": "other"},
+		},
+		{
+			policy: config.FlattenCollisionPolicySuffix,
+			want:   map[string]interface{}{"a::b": "nested", "a::b_1": "flat", "a::c": "other"},
+		},
+		{
+			policy:  config.FlattenCollisionPolicyReject,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got, err := FlattenMapWithCollisionPolicy(document(), "::", tt.policy) // separator used at ingest
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("no collision", func(t *testing.T) {
+		got, err := FlattenMapWithCollisionPolicy(map[string]interface{}{"a": map[string]interface{}{"b": 1}, "c": 2}, "::", config.FlattenCollisionPolicyReject)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"a::b": 1, "c": 2}, got)
+	})
+}
+
 func TestRewriteArrayOfObject_Transform(t *testing.T) {
 
 	tests := []struct {
@@ -60,10 +60,10 @@ func main() {
 	defer logger.StdLogFile.Close()
 	defer logger.ErrLogFile.Close()
 
+	var asyncQueryTraceEvictor *quesma.AsyncQueryTraceLoggerEvictor
 	if asyncQueryTraceLogger != nil {
-		asyncQueryTraceEvictor := quesma.AsyncQueryTraceLoggerEvictor{AsyncQueryTrace: asyncQueryTraceLogger.AsyncQueryTrace}
+		asyncQueryTraceEvictor = &quesma.AsyncQueryTraceLoggerEvictor{AsyncQueryTrace: asyncQueryTraceLogger.AsyncQueryTrace}
 		asyncQueryTraceEvictor.Start()
-		defer asyncQueryTraceEvictor.Stop()
 	}
 
 	var connectionPool = clickhouse.InitDBConnectionPool(cfg)
@@ -91,6 +91,9 @@ func main() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
+	if asyncQueryTraceEvictor != nil {
+		asyncQueryTraceEvictor.Stop(ctx)
+	}
 	feature.NotSupportedLogger.Stop()
 	phoneHomeAgent.Stop(ctx)
 	lm.Stop()
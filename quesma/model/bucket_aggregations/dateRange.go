@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 	"time"
 )
 
@@ -85,9 +86,13 @@ func (query DateRange) IsBucketAggregation() bool {
 }
 
 func (query DateRange) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	if len(rows) == 0 {
+		// no documents, e.g. in an empty parent bucket. Bounds of intervals are computed by the DB, so we don't know them.
+		return []model.JsonMap{}
+	}
 	if len(rows) != 1 {
 		logger.ErrorWithCtx(query.ctx).Msgf("unexpected number of rows in date_range aggregation response, len: %d", len(rows))
-		return nil
+		return []model.JsonMap{}
 	}
 
 	response := make([]model.JsonMap, 0)
@@ -97,7 +102,7 @@ func (query DateRange) TranslateSqlResponseToJson(rows []model.QueryResultRow, l
 			"unexpected column nr in aggregation response, startIteration: %d, len(rows[0].Cols): %d",
 			startIteration, len(rows[0].Cols),
 		)
-		return nil
+		return []model.JsonMap{}
 	}
 	for intervalIdx, columnIdx := 0, startIteration; intervalIdx < len(query.Intervals); intervalIdx++ {
 		responseForInterval, nextColumnIdx := query.responseForInterval(&rows[0], intervalIdx, columnIdx)
@@ -114,7 +119,7 @@ func (query DateRange) String() string {
 func (query DateRange) responseForInterval(row *model.QueryResultRow, intervalIdx, columnIdx int) (
 	response model.JsonMap, nextColumnIdx int) {
 	response = model.JsonMap{
-		"doc_count": row.Cols[columnIdx].Value,
+		"doc_count": util.ExtractCount(row.Cols[columnIdx].Value),
 	}
 	columnIdx++
 
@@ -6,6 +6,7 @@ import (
 	"context"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 	"strconv"
 	"strings"
 	"time"
@@ -14,13 +15,76 @@ import (
 const DefaultMinDocCount = 1
 
 type DateHistogram struct {
-	ctx         context.Context
-	minDocCount int
-	Interval    string
+	ctx          context.Context
+	minDocCount  int
+	Interval     string
+	calendarUnit CalendarUnit // NoCalendarUnit <=> buckets have a fixed length, and keys are timestamps divided by it
 }
 
-func NewDateHistogram(ctx context.Context, minDocCount int, interval string) DateHistogram {
-	return DateHistogram{ctx, minDocCount, interval}
+// CalendarUnit is a unit of calendar_interval, whose buckets have varying length (e.g. months), or don't start
+// at multiples of their length (weeks start on Monday). Their keys are timestamps (in seconds) of buckets' starts.
+// Shorter calendar intervals (e.g. "1d") are the same as fixed ones (in UTC), so they don't have a CalendarUnit.
+type CalendarUnit int
+
+const (
+	NoCalendarUnit CalendarUnit = iota
+	CalendarWeek
+	CalendarMonth
+	CalendarQuarter
+	CalendarYear
+)
+
+// ParseCalendarUnit returns unit of `interval` (e.g. "month" or "1M"), or NoCalendarUnit for shorter intervals
+func ParseCalendarUnit(interval string) CalendarUnit {
+	switch interval {
+	case "week", "1w":
+		return CalendarWeek
+	case "month", "1M":
+		return CalendarMonth
+	case "quarter", "1q":
+		return CalendarQuarter
+	case "year", "1y":
+		return CalendarYear
+	default:
+		return NoCalendarUnit
+	}
+}
+
+// StartOf returns the start of `timestamp`'s bucket, e.g. toStartOfMonth(timestamp). It's a Date.
+func (unit CalendarUnit) StartOf(timestamp model.Expr) model.Expr {
+	switch unit {
+	case CalendarWeek:
+		const mondayFirstMode = 1
+		return model.NewFunction("toStartOfWeek", timestamp, model.NewLiteral(mondayFirstMode))
+	case CalendarMonth:
+		return model.NewFunction("toStartOfMonth", timestamp)
+	case CalendarQuarter:
+		return model.NewFunction("toStartOfQuarter", timestamp)
+	default:
+		return model.NewFunction("toStartOfYear", timestamp)
+	}
+}
+
+// next returns the start of the bucket after the one starting at `bucketStart`
+func (unit CalendarUnit) next(bucketStart time.Time) time.Time {
+	switch unit {
+	case CalendarWeek:
+		return bucketStart.AddDate(0, 0, 7)
+	case CalendarMonth:
+		return bucketStart.AddDate(0, 1, 0)
+	case CalendarQuarter:
+		return bucketStart.AddDate(0, 3, 0)
+	default:
+		return bucketStart.AddDate(1, 0, 0)
+	}
+}
+
+func NewDateHistogram(ctx context.Context, minDocCount int, interval string, isCalendarInterval bool) DateHistogram {
+	calendarUnit := NoCalendarUnit
+	if isCalendarInterval {
+		calendarUnit = ParseCalendarUnit(interval)
+	}
+	return DateHistogram{ctx, minDocCount, interval, calendarUnit}
 }
 
 func (query DateHistogram) IsBucketAggregation() bool {
@@ -34,19 +98,23 @@ func (query DateHistogram) TranslateSqlResponseToJson(rows []model.QueryResultRo
 				"%d, level: %d", len(rows[0].Cols), level,
 		)
 	}
-	var response []model.JsonMap
+	response := make([]model.JsonMap, 0, len(rows))
 	for _, row := range rows {
 		intervalInMilliseconds := query.IntervalAsDuration().Milliseconds()
 		var key int64
 		if keyValue, ok := row.Cols[len(row.Cols)-2].Value.(int64); ok { // used to be [level-1], but because some columns are duplicated, it doesn't work in 100% cases now
-			key = keyValue * intervalInMilliseconds
+			if query.calendarUnit != NoCalendarUnit {
+				key = keyValue * 1000 // start of the bucket, in seconds
+			} else {
+				key = keyValue * intervalInMilliseconds
+			}
 		} else {
 			logger.WarnWithCtx(query.ctx).Msgf("unexpected type of key value: %T, %+v, Should be int64", row.Cols[len(row.Cols)-2].Value, row.Cols[len(row.Cols)-2].Value)
 		}
 		intervalStart := time.UnixMilli(key).UTC().Format("2006-01-02T15:04:05.000")
 		response = append(response, model.JsonMap{
 			"key":           key,
-			"doc_count":     row.LastColValue(), // used to be [level], but because some columns are duplicated, it doesn't work in 100% cases now
+			"doc_count":     util.ExtractCount(row.LastColValue()), // used to be [level], but because some columns are duplicated, it doesn't work in 100% cases now
 			"key_as_string": intervalStart,
 		})
 	}
@@ -58,7 +126,11 @@ func (query DateHistogram) String() string {
 }
 
 // TODO implement this also for intervals longer than days ("d")
+// Calendar intervals have no fixed duration, so it's 0 for them.
 func (query DateHistogram) IntervalAsDuration() time.Duration {
+	if query.calendarUnit != NoCalendarUnit {
+		return time.Duration(0)
+	}
 	// time.ParseDuration doesn't accept > hours
 	if strings.HasSuffix(query.Interval, "d") {
 		daysNr, err := strconv.Atoi(strings.TrimSuffix(query.Interval, "d"))
@@ -79,6 +151,14 @@ func (query DateHistogram) getKey(row model.QueryResultRow) int64 {
 	return row.Cols[len(row.Cols)-2].Value.(int64)
 }
 
+// nextKey returns key of the bucket after the one with `key`
+func (query DateHistogram) nextKey(key int64) int64 {
+	if query.calendarUnit == NoCalendarUnit {
+		return key + 1
+	}
+	return query.calendarUnit.next(time.Unix(key, 0).UTC()).Unix()
+}
+
 // if minDocCount == 0, and we have buckets e.g. [key, value1], [key+10, value2], we need to insert [key+1, 0], [key+2, 0]...
 // CAUTION: a different kind of postprocessing is needed for minDocCount > 1, but I haven't seen any query with that yet, so not implementing it now.
 func (query DateHistogram) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
@@ -104,7 +184,7 @@ func (query DateHistogram) PostprocessResults(rowsFromDB []model.QueryResultRow)
 		}
 		lastKey := query.getKey(rowsFromDB[i-1])
 		currentKey := query.getKey(rowsFromDB[i])
-		for midKey := lastKey + 1; midKey < currentKey; midKey++ {
+		for midKey := query.nextKey(lastKey); midKey < currentKey; midKey = query.nextKey(midKey) {
 			midRow := rowsFromDB[i-1].Copy()
 			midRow.Cols[len(midRow.Cols)-2].Value = midKey
 			midRow.Cols[len(midRow.Cols)-1].Value = 0
@@ -3,9 +3,12 @@
 package bucket_aggregations
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"quesma/model"
 	"testing"
+	"time"
 )
 
 func TestTranslateSqlResponseToJson(t *testing.T) {
@@ -15,9 +18,65 @@ func TestTranslateSqlResponseToJson(t *testing.T) {
 	}
 	interval := "30s"
 	expectedResponse := []model.JsonMap{
-		{"key": int64(56962398) * 30_000, "doc_count": 8, "key_as_string": "2024-02-25T14:39:00.000"},
-		{"key": int64(56962370) * 30_000, "doc_count": 14, "key_as_string": "2024-02-25T14:25:00.000"},
+		{"key": int64(56962398) * 30_000, "doc_count": int64(8), "key_as_string": "2024-02-25T14:39:00.000"},
+		{"key": int64(56962370) * 30_000, "doc_count": int64(14), "key_as_string": "2024-02-25T14:25:00.000"},
 	}
 	response := DateHistogram{Interval: interval}.TranslateSqlResponseToJson(resultRows, 1)
 	assert.Equal(t, expectedResponse, response)
 }
+
+func TestTranslateSqlResponseToJsonLargeDocCount(t *testing.T) {
+	// e.g. a sum of counts, or a count after JSON round-tripping, comes as a float
+	resultRows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", int64(56962398)), model.NewQueryResultCol("doc_count", float64(12345678901234))}},
+	}
+	response := DateHistogram{Interval: "30s"}.TranslateSqlResponseToJson(resultRows, 1)
+	assert.Equal(t, int64(12345678901234), response[0]["doc_count"])
+
+	marshalled, err := json.Marshal(response)
+	assert.NoError(t, err)
+	assert.Contains(t, string(marshalled), `"doc_count":12345678901234,`)
+}
+
+func TestDateHistogramCalendarMonth(t *testing.T) {
+	// keys are starts of months (in seconds): 2015-01-01, 2015-02-01, 2015-04-01
+	resultRows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", int64(1420070400)), model.NewQueryResultCol("doc_count", 3)}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", int64(1422748800)), model.NewQueryResultCol("doc_count", 5)}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", int64(1427846400)), model.NewQueryResultCol("doc_count", 2)}},
+	}
+	dateHistogram := NewDateHistogram(context.Background(), 0, "1M", true)
+	assert.Equal(t, time.Duration(0), dateHistogram.IntervalAsDuration())
+
+	expectedResponse := []model.JsonMap{
+		{"key": int64(1420070400000), "doc_count": int64(3), "key_as_string": "2015-01-01T00:00:00.000"},
+		{"key": int64(1422748800000), "doc_count": int64(5), "key_as_string": "2015-02-01T00:00:00.000"},
+		{"key": int64(1425168000000), "doc_count": int64(0), "key_as_string": "2015-03-01T00:00:00.000"},
+		{"key": int64(1427846400000), "doc_count": int64(2), "key_as_string": "2015-04-01T00:00:00.000"},
+	}
+	response := dateHistogram.TranslateSqlResponseToJson(dateHistogram.PostprocessResults(resultRows), 1)
+	assert.Equal(t, expectedResponse, response)
+}
+
+func TestParseCalendarUnit(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     CalendarUnit
+	}{
+		{"1w", CalendarWeek},
+		{"week", CalendarWeek},
+		{"1M", CalendarMonth},
+		{"month", CalendarMonth},
+		{"1q", CalendarQuarter},
+		{"quarter", CalendarQuarter},
+		{"1y", CalendarYear},
+		{"year", CalendarYear},
+		{"1d", NoCalendarUnit},
+		{"1m", NoCalendarUnit}, // minute
+	}
+	for _, tt := range tests {
+		t.Run(tt.interval, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseCalendarUnit(tt.interval))
+		})
+	}
+}
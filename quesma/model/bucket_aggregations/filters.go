@@ -6,19 +6,27 @@ import (
 	"context"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 )
 
+// DefaultOtherBucketKey is the name of the bucket with documents not matching any filter, if `other_bucket_key` isn't specified
+const DefaultOtherBucketKey = "_other_"
+
 type Filters struct {
 	ctx     context.Context
-	Filters []Filter
+	Filters []Filter // in the order of buckets in the response. Other bucket (if requested) is the last one.
+	// Keyed == true: buckets are returned as an object (filter name -> bucket), false: as an array.
+	// Filters specified as an array in the request (anonymous) are always returned as an array, without keys.
+	Keyed     bool
+	Anonymous bool
 }
 
 func NewFiltersEmpty(ctx context.Context) Filters {
-	return Filters{ctx: ctx}
+	return Filters{ctx: ctx, Keyed: true}
 }
 
-func NewFilters(ctx context.Context, filters []Filter) Filters {
-	return Filters{ctx: ctx, Filters: filters}
+func NewFilters(ctx context.Context, filters []Filter, keyed, anonymous bool) Filters {
+	return Filters{ctx: ctx, Filters: filters, Keyed: keyed && !anonymous, Anonymous: anonymous}
 }
 
 type Filter struct {
@@ -30,12 +38,29 @@ func NewFilter(name string, sql model.SimpleQuery) Filter {
 	return Filter{Name: name, Sql: sql}
 }
 
+// NewOtherBucketFilter returns a filter matching documents, which don't match any of `filters`
+func NewOtherBucketFilter(name string, filters []Filter) Filter {
+	whereClauses := make([]model.Expr, 0, len(filters))
+	canParse := true
+	for _, filter := range filters {
+		if filter.Sql.WhereClause == nil { // matches all documents, so other bucket is always empty
+			return NewFilter(name, model.NewSimpleQuery(model.NewLiteral("false"), filter.Sql.CanParse))
+		}
+		whereClauses = append(whereClauses, filter.Sql.WhereClause)
+		canParse = canParse && filter.Sql.CanParse
+	}
+	if len(whereClauses) == 0 {
+		return NewFilter(name, model.NewSimpleQuery(nil, canParse))
+	}
+	return NewFilter(name, model.NewSimpleQuery(model.NewPrefixExpr("NOT", []model.Expr{model.Or(whereClauses)}), canParse))
+}
+
 func (query Filters) IsBucketAggregation() bool {
 	return true
 }
 
 func (query Filters) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	var value any = nil
+	var value any = int64(0) // no rows <=> no documents match the filter
 	if len(rows) > 0 {
 		if len(rows[0].Cols) > 0 {
 			value = rows[0].Cols[len(rows[0].Cols)-1].Value
@@ -44,7 +69,7 @@ func (query Filters) TranslateSqlResponseToJson(rows []model.QueryResultRow, lev
 		}
 	}
 	return []model.JsonMap{{
-		"doc_count": value,
+		"doc_count": util.ExtractCount(value),
 	}}
 }
 
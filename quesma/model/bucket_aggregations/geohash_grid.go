@@ -0,0 +1,49 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/util"
+)
+
+type GeohashGrid struct {
+	ctx context.Context
+}
+
+func NewGeohashGrid(ctx context.Context) GeohashGrid {
+	return GeohashGrid{ctx: ctx}
+}
+
+func (query GeohashGrid) IsBucketAggregation() bool {
+	return true
+}
+
+// TranslateSqlResponseToJson expects rows with (..., geohash cell, count) columns
+func (query GeohashGrid) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	if len(rows) > 0 && len(rows[0].Cols) < 2 {
+		logger.ErrorWithCtx(query.ctx).Msgf(
+			"unexpected number of columns in geohash_grid aggregation response, len(rows[0].Cols): "+
+				"%d, level: %d", len(rows[0].Cols), level,
+		)
+		return []model.JsonMap{}
+	}
+	response := make([]model.JsonMap, 0, len(rows))
+	for _, row := range rows {
+		response = append(response, model.JsonMap{
+			"key":       row.Cols[len(row.Cols)-2].Value,
+			"doc_count": util.ExtractCount(row.LastColValue()),
+		})
+	}
+	return response
+}
+
+func (query GeohashGrid) String() string {
+	return "geohash_grid"
+}
+
+func (query GeohashGrid) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
+	return rowsFromDB
+}
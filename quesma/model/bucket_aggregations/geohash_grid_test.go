@@ -0,0 +1,23 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"testing"
+)
+
+func TestGeohashGridTranslateSqlResponseToJson(t *testing.T) {
+	resultRows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("cell", "u17"), model.NewQueryResultCol("doc_count", uint64(8))}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("cell", "u09"), model.NewQueryResultCol("doc_count", uint64(3))}},
+	}
+	expectedResponse := []model.JsonMap{
+		{"key": "u17", "doc_count": int64(8)},
+		{"key": "u09", "doc_count": int64(3)},
+	}
+	response := NewGeohashGrid(context.Background()).TranslateSqlResponseToJson(resultRows, 1)
+	assert.Equal(t, expectedResponse, response)
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 	"strconv"
 )
 
@@ -28,7 +29,7 @@ func (query GeoTileGrid) TranslateSqlResponseToJson(rows []model.QueryResultRow,
 				"%d, level: %d", len(rows[0].Cols), level,
 		)
 	}
-	var response []model.JsonMap
+	response := make([]model.JsonMap, 0, len(rows))
 	for _, row := range rows {
 		zoom := int64(row.Cols[0].Value.(float64))
 		x := int64(row.Cols[1].Value.(float64))
@@ -36,7 +37,7 @@ func (query GeoTileGrid) TranslateSqlResponseToJson(rows []model.QueryResultRow,
 		key := strconv.FormatInt(zoom, 10) + "/" + strconv.FormatInt(x, 10) + "/" + strconv.FormatInt(y, 10)
 		response = append(response, model.JsonMap{
 			"key":       key,
-			"doc_count": row.LastColValue(),
+			"doc_count": util.ExtractCount(row.LastColValue()),
 		})
 	}
 	return response
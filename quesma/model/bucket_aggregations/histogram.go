@@ -4,19 +4,33 @@ package bucket_aggregations
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/quesma/errors"
 	"quesma/util"
 )
 
+// MaxBuckets is the max number of buckets of a histogram, like Elasticsearch's default `search.max_buckets`
+const MaxBuckets = 65536
+
 type Histogram struct {
-	ctx         context.Context
-	interval    float64
-	minDocCount int
+	ctx            context.Context
+	interval       float64
+	offset         float64 // keys are offset + k*interval
+	minDocCount    int
+	format         string           // format of keys' key_as_string, "" if keys have no key_as_string
+	extendedBounds *HistogramBounds // nil if there are no extended_bounds
+}
+
+// HistogramBounds are extended_bounds: with min_doc_count == 0, we return (empty) buckets from Min to Max, even if there's no data there
+type HistogramBounds struct {
+	Min, Max float64
 }
 
-func NewHistogram(ctx context.Context, interval float64, minDocCount int) Histogram {
-	return Histogram{ctx: ctx, interval: interval, minDocCount: minDocCount}
+func NewHistogram(ctx context.Context, interval, offset float64, minDocCount int, format string, extendedBounds *HistogramBounds) Histogram {
+	return Histogram{ctx: ctx, interval: interval, offset: offset, minDocCount: minDocCount, format: format, extendedBounds: extendedBounds}
 }
 
 func (query Histogram) IsBucketAggregation() bool {
@@ -30,12 +44,16 @@ func (query Histogram) TranslateSqlResponseToJson(rows []model.QueryResultRow, l
 				"%d, level: %d", len(rows[0].Cols), level,
 		)
 	}
-	var response []model.JsonMap
+	response := make([]model.JsonMap, 0, len(rows))
 	for _, row := range rows {
-		response = append(response, model.JsonMap{
+		bucket := model.JsonMap{
 			"key":       row.Cols[level-1].Value,
-			"doc_count": row.Cols[level].Value,
-		})
+			"doc_count": util.ExtractCount(row.Cols[level].Value),
+		}
+		if key, isNumeric := util.ExtractNumeric64Maybe(row.Cols[level-1].Value); isNumeric && query.format != "" {
+			bucket["key_as_string"] = formatNumber(key, query.format)
+		}
+		response = append(response, bucket)
 	}
 	return response
 }
@@ -46,20 +64,67 @@ func (query Histogram) String() string {
 
 // we're sure len(row.Cols) >= 2
 func (query Histogram) getKey(row model.QueryResultRow) float64 {
-	return row.Cols[len(row.Cols)-2].Value.(float64)
+	key, ok := util.ExtractNumeric64Maybe(row.Cols[len(row.Cols)-2].Value)
+	if !ok {
+		logger.WarnWithCtx(query.ctx).Msgf("unexpected type of histogram key: %T, value: %v", row.Cols[len(row.Cols)-2].Value, row.Cols[len(row.Cols)-2].Value)
+	}
+	return key
 }
 
-// if minDocCount == 0, and we have buckets e.g. [key, value1], [key+2*interval, value2], we need to insert [key+1*interval, 0]
-// CAUTION: a different kind of postprocessing is needed for minDocCount > 1, but I haven't seen any query with that yet, so not implementing it now.
+// bucketKey returns key of the bucket with `value`
+func (query Histogram) bucketKey(value float64) float64 {
+	return math.Floor((value-query.offset)/query.interval)*query.interval + query.offset
+}
+
+// emptyRow returns a row like `row`, but with bucket `key` and no documents
+func (query Histogram) emptyRow(row model.QueryResultRow, key float64) model.QueryResultRow {
+	emptyRow := row.Copy()
+	emptyRow.Cols[len(emptyRow.Cols)-2].Value = key
+	emptyRow.Cols[len(emptyRow.Cols)-1].Value = 0
+	return emptyRow
+}
+
+// PostprocessResults is TryPostprocessResults, which returns rows from DB as they are, if it fails
 func (query Histogram) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
-	if query.minDocCount != 0 || len(rowsFromDB) < 2 {
+	postprocessedRows, err := query.TryPostprocessResults(rowsFromDB)
+	if err != nil {
+		logger.WarnWithCtx(query.ctx).Msg(err.Error())
+		return rowsFromDB
+	}
+	return postprocessedRows
+}
+
+// TryPostprocessResults: if minDocCount == 0, and we have buckets e.g. [key, value1], [key+2*interval, value2], we need to insert [key+1*interval, 0].
+// We also add empty buckets before the first and after the last one to cover extended_bounds, if there are any
+// (but only if there's at least one row, as we copy it, so we don't know other columns of rows otherwise).
+// It fails, if there would be more than MaxBuckets buckets.
+// CAUTION: a different kind of postprocessing is needed for minDocCount > 1, but I haven't seen any query with that yet, so not implementing it now.
+func (query Histogram) TryPostprocessResults(rowsFromDB []model.QueryResultRow) ([]model.QueryResultRow, error) {
+	if query.minDocCount != 0 || len(rowsFromDB) == 0 || (len(rowsFromDB) < 2 && query.extendedBounds == nil) {
 		// we only add empty rows, when
 		// a) minDocCount == 0
-		// b) we have > 1 rows, with < 2 rows we can't add anything in between
-		return rowsFromDB
+		// b) we have > 1 rows (with < 2 rows we can't add anything in between), or we need to extend them to extended_bounds
+		return rowsFromDB, nil
 	}
 	postprocessedRows := make([]model.QueryResultRow, 0, len(rowsFromDB))
-	postprocessedRows = append(postprocessedRows, rowsFromDB[0])
+	appendRow := func(row model.QueryResultRow) error {
+		if len(postprocessedRows) >= MaxBuckets {
+			return tooManyBucketsError()
+		}
+		postprocessedRows = append(postprocessedRows, row)
+		return nil
+	}
+	if query.extendedBounds != nil {
+		firstKey := query.getKey(rowsFromDB[0])
+		for key := query.bucketKey(query.extendedBounds.Min); util.IsSmaller(key, firstKey); key += query.interval {
+			if err := appendRow(query.emptyRow(rowsFromDB[0], key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := appendRow(rowsFromDB[0]); err != nil {
+		return nil, err
+	}
 	for i := 1; i < len(rowsFromDB); i++ {
 		if len(rowsFromDB[i-1].Cols) < 2 || len(rowsFromDB[i].Cols) < 2 {
 			logger.ErrorWithCtx(query.ctx).Msgf(
@@ -72,12 +137,39 @@ func (query Histogram) PostprocessResults(rowsFromDB []model.QueryResultRow) []m
 		currentKey := query.getKey(rowsFromDB[i])
 		// we need to add rows in between
 		for midKey := lastKey + query.interval; util.IsSmaller(midKey, currentKey); midKey += query.interval {
-			midRow := rowsFromDB[i-1].Copy()
-			midRow.Cols[len(midRow.Cols)-2].Value = midKey
-			midRow.Cols[len(midRow.Cols)-1].Value = 0
-			postprocessedRows = append(postprocessedRows, midRow)
+			if err := appendRow(query.emptyRow(rowsFromDB[i-1], midKey)); err != nil {
+				return nil, err
+			}
+		}
+		if err := appendRow(rowsFromDB[i]); err != nil {
+			return nil, err
 		}
-		postprocessedRows = append(postprocessedRows, rowsFromDB[i])
 	}
-	return postprocessedRows
+	if query.extendedBounds != nil {
+		lastRow := rowsFromDB[len(rowsFromDB)-1]
+		maxKey := query.bucketKey(query.extendedBounds.Max)
+		for key := query.getKey(lastRow) + query.interval; !util.IsSmaller(maxKey, key); key += query.interval {
+			if err := appendRow(query.emptyRow(lastRow, key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return postprocessedRows, nil
+}
+
+// CheckExtendedBounds returns an error, if extended_bounds alone span more than MaxBuckets buckets,
+// so such requests are rejected before querying the database
+func (query Histogram) CheckExtendedBounds() error {
+	if query.extendedBounds == nil || query.minDocCount != 0 {
+		return nil
+	}
+	buckets := (query.bucketKey(query.extendedBounds.Max)-query.bucketKey(query.extendedBounds.Min))/query.interval + 1
+	if buckets > MaxBuckets {
+		return tooManyBucketsError()
+	}
+	return nil
+}
+
+func tooManyBucketsError() error {
+	return fmt.Errorf("%w: trying to create too many buckets. Must be less than or equal to: [%d]", quesma_errors.ErrTooManyBuckets(), MaxBuckets)
 }
@@ -0,0 +1,76 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"quesma/quesma/errors"
+	"testing"
+)
+
+func TestHistogramTranslateSqlResponseToJsonWithFormat(t *testing.T) {
+	resultRows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("bytes", 0.0), model.NewQueryResultCol("doc_count", uint64(5))}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("bytes", 2000.0), model.NewQueryResultCol("doc_count", uint64(3))}},
+	}
+	expectedResponse := []model.JsonMap{
+		{"key": 0.0, "key_as_string": "0.0B", "doc_count": int64(5)},
+		{"key": 2000.0, "key_as_string": "2.0KB", "doc_count": int64(3)},
+	}
+	response := NewHistogram(context.Background(), 2000, 0, 1, "0,0.0b", nil).TranslateSqlResponseToJson(resultRows, 1)
+	assert.Equal(t, expectedResponse, response)
+
+	// without format, there's no key_as_string
+	response = NewHistogram(context.Background(), 2000, 0, 1, "", nil).TranslateSqlResponseToJson(resultRows, 1)
+	assert.NotContains(t, response[0], "key_as_string")
+}
+
+func TestHistogramPostprocessResultsWithOffset(t *testing.T) {
+	row := func(key float64, count uint64) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{model.NewQueryResultCol("price", key), model.NewQueryResultCol("doc_count", count)}}
+	}
+	bucket := func(key float64, count int64) model.JsonMap {
+		return model.JsonMap{"key": key, "doc_count": count}
+	}
+	// interval 10, offset 5 => keys ..., -5, 5, 15, ...
+	rowsFromDB := []model.QueryResultRow{row(5, 2), row(35, 1)}
+
+	histogram := NewHistogram(context.Background(), 10, 5, 0, "", nil)
+	assert.Equal(t, []model.JsonMap{bucket(5, 2), bucket(15, 0), bucket(25, 0), bucket(35, 1)},
+		histogram.TranslateSqlResponseToJson(histogram.PostprocessResults(rowsFromDB), 1))
+
+	// extended_bounds are rounded down to keys
+	histogram = NewHistogram(context.Background(), 10, 5, 0, "", &HistogramBounds{Min: -10, Max: 50})
+	assert.Equal(t, []model.JsonMap{bucket(-15, 0), bucket(-5, 0), bucket(5, 2), bucket(15, 0), bucket(25, 0), bucket(35, 1), bucket(45, 0)},
+		histogram.TranslateSqlResponseToJson(histogram.PostprocessResults(rowsFromDB), 1))
+
+	// with min_doc_count > 0, we don't add anything
+	histogram = NewHistogram(context.Background(), 10, 5, 1, "", &HistogramBounds{Min: -10, Max: 50})
+	assert.Equal(t, rowsFromDB, histogram.PostprocessResults(rowsFromDB))
+}
+
+func TestHistogramPostprocessResultsTooManyBuckets(t *testing.T) {
+	row := func(key float64, count uint64) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{model.NewQueryResultCol("price", key), model.NewQueryResultCol("doc_count", count)}}
+	}
+	rowsFromDB := []model.QueryResultRow{row(0, 1), row(MaxBuckets, 1)}
+
+	histogram := NewHistogram(context.Background(), 1, 0, 0, "", nil)
+	_, err := histogram.TryPostprocessResults(rowsFromDB)
+	assert.ErrorIs(t, err, quesma_errors.ErrTooManyBuckets())
+	// PostprocessResults doesn't add anything then
+	assert.Equal(t, rowsFromDB, histogram.PostprocessResults(rowsFromDB))
+
+	histogram = NewHistogram(context.Background(), 2, 0, 0, "", nil)
+	postprocessed, err := histogram.TryPostprocessResults(rowsFromDB)
+	assert.NoError(t, err)
+	assert.Len(t, postprocessed, MaxBuckets/2+1)
+
+	// with min_doc_count > 0, there are no empty buckets
+	histogram = NewHistogram(context.Background(), 1, 0, 1, "", nil)
+	postprocessed, err = histogram.TryPostprocessResults(rowsFromDB)
+	assert.NoError(t, err)
+	assert.Equal(t, rowsFromDB, postprocessed)
+}
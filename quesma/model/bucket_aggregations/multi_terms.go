@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 	"strings"
 )
 
@@ -30,6 +31,7 @@ func (query MultiTerms) TranslateSqlResponseToJson(rows []model.QueryResultRow,
 			"unexpected number of columns in terms aggregation response, len: %d, expected (at least): %d, rows[0]: %v", len(rows[0].Cols), minimumExpectedColNr, rows[0])
 	}
 	const delimiter = '|' // between keys in key_as_string
+	response = make([]model.JsonMap, 0, len(rows))
 	for _, row := range rows {
 		startIndex := len(row.Cols) - query.fieldsNr - 1
 		if startIndex < 0 {
@@ -47,7 +49,7 @@ func (query MultiTerms) TranslateSqlResponseToJson(rows []model.QueryResultRow,
 			keyAsString.WriteString(fmt.Sprintf("%v", col.Value))
 		}
 
-		docCount := row.Cols[len(row.Cols)-1].Value
+		docCount := util.ExtractCount(row.Cols[len(row.Cols)-1].Value)
 		bucket := model.JsonMap{
 			"key":           keys,
 			"key_as_string": keyAsString.String(),
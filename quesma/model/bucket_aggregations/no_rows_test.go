@@ -0,0 +1,48 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"quesma/model"
+	"testing"
+)
+
+// TestTranslateSqlResponseToJsonNoRows checks, that with no documents (no rows) every bucket aggregation
+// returns well-formed empty buckets, like Elasticsearch, and not nil
+func TestTranslateSqlResponseToJsonNoRows(t *testing.T) {
+	ctx := context.Background()
+	intervals := []Interval{NewInterval(math.NaN(), 100), NewInterval(100, math.NaN())}
+	tests := []struct {
+		aggregation      model.QueryType
+		expectedResponse []model.JsonMap
+	}{
+		{NewTerms(ctx, false, ""), []model.JsonMap{}},
+		{NewTerms(ctx, true, ""), []model.JsonMap{}},
+		{NewRareTerms(ctx), []model.JsonMap{}},
+		{NewMultiTerms(ctx, 2), []model.JsonMap{}},
+		{NewHistogram(ctx, 10, 0, 1, "", nil), []model.JsonMap{}},
+		{NewDateHistogram(ctx, 1, "1h", false), []model.JsonMap{}},
+		{NewGeohashGrid(ctx), []model.JsonMap{}},
+		{NewGeoTileGrid(ctx), []model.JsonMap{}},
+		{NewDateRange(ctx, "@timestamp", "", []DateTimeInterval{NewDateTimeInterval("now-1d", UnboundedInterval)}, 2), []model.JsonMap{}},
+		{NewFiltersEmpty(ctx), []model.JsonMap{{"doc_count": int64(0)}}},
+		{NewRange(ctx, model.NewColumnRef("bytes"), intervals, false), []model.JsonMap{
+			{"key": "*-100.0", "to": 100.0, "doc_count": int64(0)},
+			{"key": "100.0-*", "from": 100.0, "doc_count": int64(0)},
+		}},
+		{NewRange(ctx, model.NewColumnRef("bytes"), intervals, true), []model.JsonMap{{
+			"*-100.0": model.JsonMap{"to": 100.0, "doc_count": int64(0)},
+			"100.0-*": model.JsonMap{"from": 100.0, "doc_count": int64(0)},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aggregation.String(), func(t *testing.T) {
+			response := tt.aggregation.TranslateSqlResponseToJson([]model.QueryResultRow{}, 1)
+			assert.NotNil(t, response)
+			assert.Equal(t, tt.expectedResponse, response)
+		})
+	}
+}
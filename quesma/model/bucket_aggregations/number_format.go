@@ -0,0 +1,102 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// formatNumber returns `key_as_string` of a numeric bucket with `value` key, for aggregation's `format`.
+// We support the most common patterns of Java's DecimalFormat (used by Elastic) and Numeral.js (used by Kibana):
+//   - decimal places: "0" -> 1235, "0.000" -> 1234.560, optional ones: "#.###" or "0.[000]" -> 1234.56 (for 1234.56)
+//   - grouping: "#,##0.0" or "0,0.0" -> 1,234.6 (for 1234.56)
+//   - percent: "0.0%" -> 12.3% (for 0.123)
+//   - bytes: "0,0.0b" -> 1.2KB (powers of 1000), "0.0ib" -> 1.2KiB (powers of 1024), "0.0 b" -> 1.2 KB (for 1234)
+//
+// Halves are rounded to even, like in DecimalFormat. Other formats aren't supported, and the value is returned as it is.
+func formatNumber(value float64, format string) string {
+	pattern, suffix := format, ""
+	switch {
+	case strings.HasSuffix(pattern, "%"):
+		pattern, suffix = strings.TrimSuffix(pattern, "%"), "%"
+		value *= 100
+	case strings.HasSuffix(pattern, "ib"):
+		pattern = strings.TrimSuffix(pattern, "ib")
+		value, suffix = scaleBytes(value, 1024, []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"})
+	case strings.HasSuffix(pattern, "b"):
+		pattern = strings.TrimSuffix(pattern, "b")
+		value, suffix = scaleBytes(value, 1000, []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"})
+	}
+	if trimmed := strings.TrimSuffix(pattern, " "); trimmed != pattern {
+		pattern, suffix = trimmed, " "+suffix
+	}
+
+	if pattern == "" || strings.Trim(pattern, "0#,.[]") != "" {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	integerPattern, fractionPattern, _ := strings.Cut(pattern, ".")
+	minDecimals, maxDecimals, optional := 0, 0, false
+	for _, char := range fractionPattern {
+		switch char {
+		case '[':
+			optional = true
+		case ']':
+			optional = false
+		case '0':
+			maxDecimals++
+			if !optional {
+				minDecimals++
+			}
+		case '#':
+			maxDecimals++
+		}
+	}
+
+	formatted := strconv.FormatFloat(value, 'f', maxDecimals, 64)
+	if maxDecimals > minDecimals {
+		integerPart, fractionPart, _ := strings.Cut(formatted, ".")
+		for len(fractionPart) > minDecimals && strings.HasSuffix(fractionPart, "0") {
+			fractionPart = fractionPart[:len(fractionPart)-1]
+		}
+		formatted = integerPart
+		if fractionPart != "" {
+			formatted += "." + fractionPart
+		}
+	}
+	if strings.Contains(integerPattern, ",") {
+		formatted = groupThousands(formatted)
+	}
+	return formatted + suffix
+}
+
+// scaleBytes returns `value` bytes in the biggest unit, in which it's at least 1, e.g. 1234 -> 1.234, "KB"
+func scaleBytes(value, base float64, units []string) (float64, string) {
+	unit := 0
+	for math.Abs(value) >= base && unit < len(units)-1 {
+		value /= base
+		unit++
+	}
+	return value, units[unit]
+}
+
+// groupThousands inserts commas between groups of thousands of the integer part, e.g. -1234567.8 -> -1,234,567.8
+func groupThousands(number string) string {
+	sign := ""
+	if strings.HasPrefix(number, "-") {
+		sign, number = "-", number[1:]
+	}
+	integerPart, fractionPart, hasFraction := strings.Cut(number, ".")
+	var grouped strings.Builder
+	for i, digit := range integerPart {
+		if i > 0 && (len(integerPart)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	if hasFraction {
+		return sign + grouped.String() + "." + fractionPart
+	}
+	return sign + grouped.String()
+}
@@ -0,0 +1,36 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		value  float64
+		format string
+		want   string
+	}{
+		{1234.56, "0", "1235"},
+		{1234.5, "0", "1234"},
+		{1234.56, "0.000", "1234.560"},
+		{1234.56, "#.###", "1234.56"},
+		{1234, "0.[00]", "1234"},
+		{1234567.5, "#,##0.0", "1,234,567.5"},
+		{-1234567, "0,0", "-1,234,567"},
+		{0.123, "0.0%", "12.3%"},
+		{1234, "0,0.0b", "1.2KB"},
+		{1536, "0.0ib", "1.5KiB"},
+		{3_500_000, "0.0 b", "3.5 MB"},
+		{512, "0b", "512B"},
+		{1234.5, "yyyy-MM-dd", "1234.5"}, // unsupported, value as it is
+		{1234.5, "", "1234.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatNumber(tt.value, tt.format))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"math"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/util"
+)
+
+// RandomSampler is a single bucket of documents, each of them sampled with `probability`. Its doc_count (and results of
+// its subaggregations) are scaled by 1/probability, so they estimate values over all documents.
+// Like filter, it's translated just as a count, not as a bucket aggregation.
+type RandomSampler struct {
+	ctx         context.Context
+	probability float64
+	seed        any // returned as it was requested, nil if it wasn't
+	// errorEstimate <=> the response has also `doc_count_error_estimate`: standard error of doc_count
+	errorEstimate bool
+}
+
+func NewRandomSampler(ctx context.Context, probability float64, seed any, errorEstimate bool) RandomSampler {
+	return RandomSampler{ctx: ctx, probability: probability, seed: seed, errorEstimate: errorEstimate}
+}
+
+func (query RandomSampler) IsBucketAggregation() bool {
+	return false
+}
+
+func (query RandomSampler) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	var docCount int64
+	if len(rows) > 0 && len(rows[0].Cols) > level {
+		docCount, _ = util.ExtractInt64Maybe(util.ExtractCount(rows[0].Cols[level].Value))
+	} else {
+		logger.WarnWithCtx(query.ctx).Msg("no rows returned for random_sampler aggregation")
+	}
+
+	response := model.JsonMap{"doc_count": docCount, "probability": query.probability}
+	if query.seed != nil {
+		response["seed"] = query.seed
+	}
+	if query.errorEstimate {
+		// doc_count is n/p for n sampled documents, and n is binomial, so its standard deviation is sqrt(n(1-p))/p
+		response["doc_count_error_estimate"] = math.Sqrt(float64(docCount) * (1 - query.probability) / query.probability)
+	}
+	return []model.JsonMap{response}
+}
+
+func (query RandomSampler) String() string {
+	return "random_sampler"
+}
+
+func (query RandomSampler) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
+	return rowsFromDB
+}
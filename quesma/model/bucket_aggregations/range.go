@@ -8,6 +8,7 @@ import (
 	"math"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 	"strconv"
 	"strings"
 )
@@ -123,28 +124,40 @@ func (query Range) IsBucketAggregation() bool {
 }
 
 func (query Range) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	if len(rows) != 1 {
+	counts := make([]any, len(query.Intervals))
+	switch len(rows) {
+	case 0:
+		// no documents (e.g. in an empty parent bucket), but all intervals are still returned, like in Elasticsearch
+		for i := range counts {
+			counts[i] = int64(0)
+		}
+	case 1:
+		startIteration := len(rows[0].Cols) - 1 - len(query.Intervals)
+		endIteration := len(rows[0].Cols) - 1
+		if startIteration >= endIteration || startIteration < 0 {
+			logger.ErrorWithCtx(query.ctx).Msgf(
+				"unexpected column nr in aggregation response, startIteration: %d, endIteration: %d", startIteration, endIteration)
+			return []model.JsonMap{}
+		}
+		for i, col := range rows[0].Cols[startIteration:endIteration] {
+			counts[i] = col.Value
+		}
+	default:
 		logger.ErrorWithCtx(query.ctx).Msgf("unexpected %d of rows in range aggregation response. Expected 1.", len(rows))
-		return nil
-	}
-	startIteration := len(rows[0].Cols) - 1 - len(query.Intervals)
-	endIteration := len(rows[0].Cols) - 1
-	if startIteration >= endIteration || startIteration < 0 {
-		logger.ErrorWithCtx(query.ctx).Msgf(
-			"unexpected column nr in aggregation response, startIteration: %d, endIteration: %d", startIteration, endIteration)
-		return nil
+		return []model.JsonMap{}
 	}
+
 	if query.Keyed {
 		var response = make(model.JsonMap)
-		for i, col := range rows[0].Cols[startIteration:endIteration] {
-			responseForInterval := query.responseForInterval(query.Intervals[i], col.Value)
+		for i, count := range counts {
+			responseForInterval := query.responseForInterval(query.Intervals[i], count)
 			response[query.Intervals[i].String()] = responseForInterval
 		}
 		return []model.JsonMap{response}
 	} else {
-		var response []model.JsonMap
-		for i, col := range rows[0].Cols[startIteration:endIteration] {
-			responseForInterval := query.responseForInterval(query.Intervals[i], col.Value)
+		response := make([]model.JsonMap, 0, len(counts))
+		for i, count := range counts {
+			responseForInterval := query.responseForInterval(query.Intervals[i], count)
 			responseForInterval["key"] = query.Intervals[i].String()
 			response = append(response, responseForInterval)
 		}
@@ -158,7 +171,7 @@ func (query Range) String() string {
 
 func (query Range) responseForInterval(interval Interval, value any) model.JsonMap {
 	response := model.JsonMap{
-		"doc_count": value,
+		"doc_count": util.ExtractCount(value),
 	}
 	if !interval.IsOpeningBoundInfinite() {
 		response["from"] = interval.Begin
@@ -0,0 +1,47 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/util"
+)
+
+// RareTerms is like terms, but returns only terms with at most `max_doc_count` documents.
+// Filtering is done in SQL (HAVING), so here we just translate the rows.
+type RareTerms struct {
+	ctx context.Context
+}
+
+func NewRareTerms(ctx context.Context) RareTerms {
+	return RareTerms{ctx: ctx}
+}
+
+func (query RareTerms) IsBucketAggregation() bool {
+	return true
+}
+
+func (query RareTerms) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	response := make([]model.JsonMap, 0, len(rows))
+	for _, row := range rows {
+		if len(row.Cols) < 2 {
+			logger.ErrorWithCtx(query.ctx).Msgf("unexpected number of columns in rare_terms aggregation response, row: %v", row)
+			continue
+		}
+		response = append(response, model.JsonMap{
+			"key":       row.Cols[len(row.Cols)-2].Value,
+			"doc_count": util.ExtractCount(row.Cols[len(row.Cols)-1].Value),
+		})
+	}
+	return response
+}
+
+func (query RareTerms) String() string {
+	return "rare_terms"
+}
+
+func (query RareTerms) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
+	return rowsFromDB
+}
@@ -6,42 +6,103 @@ import (
 	"context"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 )
 
 type Terms struct {
 	ctx         context.Context
 	significant bool // true <=> significant_terms, false <=> terms
+	// format of numeric keys' key_as_string, "" if keys have no key_as_string
+	format string
 }
 
-func NewTerms(ctx context.Context, significant bool) Terms {
-	return Terms{ctx: ctx, significant: significant}
+// Columns of a significant_terms row from the DB are: [parent group by fields..., term, background count,
+// background (superset) size, foreground (subset) size, foreground count, score]. Foreground means documents matching the query,
+// background - the whole table. PostprocessResults changes them to: [parent group by fields..., term, background count, score, foreground count].
+const (
+	significantTermsDBColumnsAfterTerm       = 5
+	significantTermsResponseColumnsAfterTerm = 3
+)
+
+// TotalDocCountColumnName is an alias of the last column of terms rows, if it's limited to `size` top terms:
+// count of documents with any term (over all groups, before LIMIT). It gives us sum_other_doc_count.
+const TotalDocCountColumnName = "total_doc_count"
+
+func NewTerms(ctx context.Context, significant bool, format string) Terms {
+	return Terms{ctx: ctx, significant: significant, format: format}
 }
 
 func (query Terms) IsBucketAggregation() bool {
 	return true
 }
 
+func (query Terms) IsSignificant() bool {
+	return query.significant
+}
+
 func (query Terms) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	var response []model.JsonMap
+	response := make([]model.JsonMap, 0, len(rows))
 	if len(rows) > 0 && len(rows[0].Cols) < 2 {
 		logger.ErrorWithCtx(query.ctx).Msgf(
 			"unexpected number of columns in terms aggregation response, len: %d, rows[0]: %v", len(rows[0].Cols), rows[0])
 	}
 	for _, row := range rows {
-		docCount := row.Cols[len(row.Cols)-1].Value
+		row = withoutTotalDocCount(row)
+		if query.significant {
+			if len(row.Cols) < significantTermsResponseColumnsAfterTerm+1 {
+				logger.ErrorWithCtx(query.ctx).Msgf("unexpected number of columns in significant_terms aggregation response, row: %v", row)
+				continue
+			}
+			termIdx := len(row.Cols) - significantTermsResponseColumnsAfterTerm - 1
+			response = append(response, model.JsonMap{
+				"key":       row.Cols[termIdx].Value,
+				"bg_count":  util.ExtractCount(row.Cols[termIdx+1].Value),
+				"score":     row.Cols[termIdx+2].Value,
+				"doc_count": util.ExtractCount(row.Cols[termIdx+3].Value),
+			})
+			continue
+		}
 		bucket := model.JsonMap{
 			"key":       row.Cols[len(row.Cols)-2].Value,
-			"doc_count": docCount,
+			"doc_count": util.ExtractCount(row.Cols[len(row.Cols)-1].Value),
 		}
-		if query.significant {
-			bucket["score"] = docCount
-			bucket["bg_count"] = docCount
+		if key, isNumeric := util.ExtractNumeric64Maybe(row.Cols[len(row.Cols)-2].Value); isNumeric && query.format != "" {
+			bucket["key_as_string"] = formatNumber(key, query.format)
 		}
 		response = append(response, bucket)
 	}
 	return response
 }
 
+// SumOtherDocCount returns how many documents have terms, which aren't in `rows` (because of the limit to `size` top terms)
+func (query Terms) SumOtherDocCount(rows []model.QueryResultRow) int64 {
+	if len(rows) == 0 || !HasTotalDocCount(rows[0]) {
+		return 0 // all terms are returned
+	}
+	totalDocCount, _ := util.ExtractInt64Maybe(rows[0].Cols[len(rows[0].Cols)-1].Value)
+	for _, row := range rows {
+		row = withoutTotalDocCount(row)
+		if len(row.Cols) == 0 {
+			continue
+		}
+		docCount, _ := util.ExtractInt64Maybe(row.Cols[len(row.Cols)-1].Value)
+		totalDocCount -= docCount
+	}
+	return max(totalDocCount, 0)
+}
+
+// HasTotalDocCount returns true <=> terms `row` ends with the total doc count column (see TotalDocCountColumnName)
+func HasTotalDocCount(row model.QueryResultRow) bool {
+	return len(row.Cols) > 0 && row.Cols[len(row.Cols)-1].ColName == TotalDocCountColumnName
+}
+
+func withoutTotalDocCount(row model.QueryResultRow) model.QueryResultRow {
+	if HasTotalDocCount(row) {
+		row.Cols = row.Cols[:len(row.Cols)-1]
+	}
+	return row
+}
+
 func (query Terms) String() string {
 	if !query.significant {
 		return "terms"
@@ -49,6 +110,29 @@ func (query Terms) String() string {
 	return "significant_terms"
 }
 
+// PostprocessResults of significant_terms leaves out terms, which don't occur in the foreground (we count over the whole table).
+// They're never in subaggregations' results (those are filtered by the query), so results stay aligned bucket by bucket.
+// The order (by score, if limited to `size` terms) is already the DB's.
 func (query Terms) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
-	return rowsFromDB
+	if !query.significant {
+		return rowsFromDB
+	}
+
+	rows := make([]model.QueryResultRow, 0, len(rowsFromDB))
+	for _, row := range rowsFromDB {
+		if len(row.Cols) < significantTermsDBColumnsAfterTerm+1 {
+			logger.ErrorWithCtx(query.ctx).Msgf("unexpected number of columns in significant_terms aggregation result, row: %v", row)
+			continue
+		}
+		termIdx := len(row.Cols) - significantTermsDBColumnsAfterTerm - 1
+		if foregroundCount, _ := util.ExtractNumeric64Maybe(row.Cols[termIdx+4].Value); foregroundCount == 0 {
+			continue
+		}
+		score, _ := util.ExtractNumeric64Maybe(row.Cols[termIdx+5].Value)
+		newCols := make([]model.QueryResultCol, 0, termIdx+significantTermsResponseColumnsAfterTerm+1)
+		newCols = append(newCols, row.Cols[:termIdx+2]...)
+		newCols = append(newCols, model.NewQueryResultCol("score", score), row.Cols[termIdx+4])
+		rows = append(rows, model.QueryResultRow{Index: row.Index, Cols: newCols})
+	}
+	return rows
 }
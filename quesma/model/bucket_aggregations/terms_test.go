@@ -0,0 +1,63 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"testing"
+)
+
+func TestSignificantTermsPostprocessResults(t *testing.T) {
+	// rows: [term, background count, superset size, subset size, foreground count, score]
+	row := func(term string, backgroundCount, foregroundCount int, score float64) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol("term", term),
+			model.NewQueryResultCol("bg_count", uint64(backgroundCount)),
+			model.NewQueryResultCol("superset_size", uint64(1000)),
+			model.NewQueryResultCol("subset_size", uint64(50)),
+			model.NewQueryResultCol("doc_count", uint64(foregroundCount)),
+			model.NewQueryResultCol("score", score),
+		}}
+	}
+	// "a" is frequent everywhere, "b" is rare in general but frequent in the foreground, "c" isn't in the foreground at all
+	rowsFromDB := []model.QueryResultRow{row("a", 800, 40, 0), row("c", 180, 0, 0), row("b", 20, 10, 1.8)}
+
+	terms := NewTerms(context.Background(), true, "")
+	response := terms.TranslateSqlResponseToJson(terms.PostprocessResults(rowsFromDB), 1)
+	assert.Len(t, response, 2)
+	// we keep the order from the DB, as results of subaggregations are merged with ours bucket by bucket
+	assert.Equal(t, "a", response[0]["key"])
+	assert.Equal(t, 0.0, response[0]["score"])
+	assert.Equal(t, "b", response[1]["key"])
+	assert.Equal(t, int64(20), response[1]["bg_count"])
+	assert.Equal(t, int64(10), response[1]["doc_count"])
+	assert.Equal(t, 1.8, response[1]["score"])
+}
+
+func TestTermsSumOtherDocCount(t *testing.T) {
+	row := func(term string, docCount, totalDocCount uint64) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol("term", term),
+			model.NewQueryResultCol("count()", docCount),
+			model.NewQueryResultCol(TotalDocCountColumnName, totalDocCount),
+		}}
+	}
+	terms := NewTerms(context.Background(), false, "")
+
+	// top 2 out of 4 terms with 10 documents
+	rows := []model.QueryResultRow{row("a", 4, 10), row("b", 3, 10)}
+	assert.Equal(t, int64(3), terms.SumOtherDocCount(rows))
+	response := terms.TranslateSqlResponseToJson(rows, 0)
+	assert.Equal(t, []model.JsonMap{{"key": "a", "doc_count": int64(4)}, {"key": "b", "doc_count": int64(3)}}, response)
+
+	// all terms returned
+	rows = []model.QueryResultRow{row("a", 4, 7), row("b", 3, 7)}
+	assert.Equal(t, int64(0), terms.SumOtherDocCount(rows))
+
+	// not limited, so there's no total doc count
+	rows = []model.QueryResultRow{{Cols: []model.QueryResultCol{model.NewQueryResultCol("term", "a"), model.NewQueryResultCol("count()", uint64(4))}}}
+	assert.Equal(t, int64(0), terms.SumOtherDocCount(rows))
+	assert.Equal(t, int64(0), terms.SumOtherDocCount(nil))
+}
@@ -0,0 +1,89 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"fmt"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/util"
+	"strings"
+)
+
+// variableWidthHistogramStatsColumnsNr is the number of columns after count(): min, max and avg of the field
+const variableWidthHistogramStatsColumnsNr = 3
+
+// VariableWidthHistogram is a histogram with (roughly) `buckets` buckets of similar populations. Elasticsearch clusters
+// the values, we instead split them at quantiles, so the bucket index of a value is the number of quantiles <= it.
+// Quantiles are computed over documents of the aggregation, separately in each bucket of parent aggregations.
+type VariableWidthHistogram struct {
+	ctx     context.Context
+	field   model.Expr
+	buckets int
+}
+
+// VariableWidthHistogramEdgesColumnName is an alias of the column with bucket edges (quantiles), see Edges
+const VariableWidthHistogramEdgesColumnName = "variable_width_histogram_edges"
+
+func NewVariableWidthHistogram(ctx context.Context, field model.Expr, buckets int) VariableWidthHistogram {
+	return VariableWidthHistogram{ctx: ctx, field: field, buckets: buckets}
+}
+
+func (query VariableWidthHistogram) IsBucketAggregation() bool {
+	return true
+}
+
+// Edges returns window function computing edges between buckets: quantiles(1/buckets, ..., (buckets-1)/buckets)(field)
+// over all rows of a parent bucket (`partitionBy`). It's computed in a subquery reading from the table,
+// so over exactly the same documents as the histogram itself.
+func (query VariableWidthHistogram) Edges(partitionBy []model.Expr) model.Expr {
+	levels := make([]string, 0, query.buckets-1)
+	for i := 1; i < query.buckets; i++ {
+		levels = append(levels, fmt.Sprintf("%f", float64(i)/float64(query.buckets)))
+	}
+	return model.NewWindowFunction(fmt.Sprintf("quantiles(%s)", strings.Join(levels, ", ")),
+		[]model.Expr{query.field}, partitionBy, model.OrderByExpr{})
+}
+
+// BucketIndex returns index (from 0 to buckets-1) of field's bucket, given `edges` computed by Edges:
+// arrayCount((edge) -> "edge" <= field, edges)
+func (query VariableWidthHistogram) BucketIndex(edges model.Expr) model.Expr {
+	isAboveEdge := model.NewLambdaExpr([]string{"edge"}, model.NewInfixExpr(model.NewColumnRef("edge"), "<=", query.field))
+	return model.NewFunction("arrayCount", isAboveEdge, edges)
+}
+
+// StatsColumns are selected after count(), they describe values in each bucket
+func (query VariableWidthHistogram) StatsColumns() []model.Expr {
+	return []model.Expr{
+		model.NewFunction("minOrNull", query.field),
+		model.NewFunction("maxOrNull", query.field),
+		model.NewFunction("avgOrNull", query.field),
+	}
+}
+
+func (query VariableWidthHistogram) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	response := make([]model.JsonMap, 0, len(rows))
+	for _, row := range rows {
+		if len(row.Cols) < variableWidthHistogramStatsColumnsNr+2 {
+			logger.ErrorWithCtx(query.ctx).Msgf("unexpected number of columns in variable_width_histogram aggregation response, row: %v", row)
+			continue
+		}
+		stats := row.Cols[len(row.Cols)-variableWidthHistogramStatsColumnsNr:]
+		response = append(response, model.JsonMap{
+			"min":       stats[0].Value,
+			"key":       stats[2].Value, // Elasticsearch's key is the bucket's centroid
+			"max":       stats[1].Value,
+			"doc_count": util.ExtractCount(row.Cols[len(row.Cols)-variableWidthHistogramStatsColumnsNr-1].Value),
+		})
+	}
+	return response
+}
+
+func (query VariableWidthHistogram) String() string {
+	return fmt.Sprintf("variable_width_histogram(buckets: %d)", query.buckets)
+}
+
+func (query VariableWidthHistogram) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
+	return rowsFromDB
+}
@@ -0,0 +1,37 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bucket_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"testing"
+)
+
+func TestVariableWidthHistogramTranslateSqlResponseToJson(t *testing.T) {
+	// skewed column: most values are small, so buckets of (roughly) equal populations get wider and wider
+	row := func(bucketIndex int64, count uint64, min, max, avg float64) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol("bucket", bucketIndex),
+			model.NewQueryResultCol("count()", count),
+			model.NewQueryResultCol("minOrNull", min),
+			model.NewQueryResultCol("maxOrNull", max),
+			model.NewQueryResultCol("avgOrNull", avg),
+		}}
+	}
+	resultRows := []model.QueryResultRow{
+		row(0, 251, 1, 2, 1.5),
+		row(1, 250, 3, 9, 5.5),
+		row(2, 249, 10, 120, 47.25),
+		row(3, 250, 121, 100000, 9120.5),
+	}
+	expectedResponse := []model.JsonMap{
+		{"min": 1.0, "key": 1.5, "max": 2.0, "doc_count": int64(251)},
+		{"min": 3.0, "key": 5.5, "max": 9.0, "doc_count": int64(250)},
+		{"min": 10.0, "key": 47.25, "max": 120.0, "doc_count": int64(249)},
+		{"min": 121.0, "key": 9120.5, "max": 100000.0, "doc_count": int64(250)},
+	}
+	histogram := NewVariableWidthHistogram(context.Background(), model.NewColumnRef("bytes"), 4)
+	assert.Equal(t, expectedResponse, histogram.TranslateSqlResponseToJson(resultRows, 1))
+}
@@ -142,6 +142,8 @@ func (s DistinctExpr) Accept(v ExprVisitor) interface{} {
 // TableRef is an explicit reference to a table in a query
 type TableRef struct {
 	Name string
+	// Sample > 0 <=> "SAMPLE Sample", ClickHouse reads only this fraction of rows, chosen by the table's SAMPLE BY key
+	Sample float64
 	// to be considered - alias (e.g. FROM tableName AS t)
 	// to be considered - database prefix (e.g. FROM databaseName.tableName)
 }
@@ -4,24 +4,50 @@ package model
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 )
 
-type renderer struct{}
+// RenderOptions tune how expressions are rendered to SQL. Zero value is the default rendering.
+type RenderOptions struct {
+	// QuoteIdentifiersWhenNeeded makes column names and aliases quoted only if they need it: with special characters
+	// (e.g. dots, spaces, `::`) or equal to ClickHouse keywords. Simple ones are left unquoted, for readability.
+	QuoteIdentifiersWhenNeeded bool
+}
+
+type renderer struct {
+	options RenderOptions
+}
 
 // AsString renders the given expression to string which can be used to build SQL query
 func AsString(expr Expr) string {
+	return AsStringWithOptions(expr, RenderOptions{})
+}
+
+// AsStringWithOptions is like AsString, but renders the expression according to `options`
+func AsStringWithOptions(expr Expr, options RenderOptions) string {
+	return (&renderer{options: options}).asString(expr)
+}
+
+func (v *renderer) asString(expr Expr) string {
 	if expr == nil {
 		return ""
 	}
-	return expr.Accept(&renderer{}).(string)
+	return expr.Accept(v).(string)
+}
+
+func (v *renderer) quoteIdentifier(name string) string {
+	if v.options.QuoteIdentifiersWhenNeeded && !identifierNeedsQuoting(name) {
+		return name
+	}
+	return QuoteIdentifier(name)
 }
 
 func (v *renderer) VisitColumnRef(e ColumnRef) interface{} {
 	name := strings.TrimSuffix(e.ColumnName, ".keyword")
 	name = strings.TrimSuffix(name, "::keyword") // Not sure if this is the best place to do this
-	return strconv.Quote(name)
+	return v.quoteIdentifier(name)
 }
 
 func (v *renderer) VisitPrefixExpr(e PrefixExpr) interface{} {
@@ -124,11 +150,14 @@ func (v *renderer) VisitDistinctExpr(e DistinctExpr) interface{} {
 }
 
 func (v *renderer) VisitTableRef(e TableRef) interface{} {
+	if e.Sample > 0 {
+		return e.Name + " SAMPLE " + strconv.FormatFloat(e.Sample, 'f', -1, 64)
+	}
 	return e.Name
 }
 
 func (v *renderer) VisitAliasedExpr(e AliasedExpr) interface{} {
-	return fmt.Sprintf("%s AS %s", e.Expr.Accept(v).(string), strconv.Quote(e.Alias))
+	return fmt.Sprintf("%s AS %s", e.Expr.Accept(v).(string), v.quoteIdentifier(e.Alias))
 }
 
 func (v *renderer) VisitSelectCommand(c SelectCommand) interface{} {
@@ -142,7 +171,7 @@ func (v *renderer) VisitSelectCommand(c SelectCommand) interface{} {
 	columns := make([]string, 0)
 
 	for _, col := range c.Columns {
-		columns = append(columns, AsString(col))
+		columns = append(columns, v.asString(col))
 	}
 
 	sb.WriteString(strings.Join(columns, ", "))
@@ -163,13 +192,16 @@ func (v *renderer) VisitSelectCommand(c SelectCommand) interface{} {
 	if c.SampleLimit > 0 {
 		sb.WriteString("(SELECT ")
 		innerColumn := make([]string, 0)
-		for _, col := range c.Columns {
-			if _, ok := col.(ColumnRef); ok {
-				innerColumn = append(innerColumn, AsString(col))
-			}
-			if aliased, ok := col.(AliasedExpr); ok {
-				if v, ok := aliased.Expr.(ColumnRef); ok {
-					innerColumn = append(innerColumn, AsString(v))
+		exprs := append(slices.Clone(c.Columns), c.GroupBy...)
+		for _, orderBy := range c.OrderBy {
+			exprs = append(exprs, orderBy.Exprs...)
+		}
+		for _, expr := range exprs {
+			// columns used by the outer query, also inside functions (e.g. subaggregations of sampler)
+			usedColumns, _ := expr.Accept(&usedColumns{}).([]ColumnRef)
+			for _, col := range usedColumns {
+				if colAsString := v.asString(col); !slices.Contains(innerColumn, colAsString) {
+					innerColumn = append(innerColumn, colAsString)
 				}
 			}
 		}
@@ -183,19 +215,31 @@ func (v *renderer) VisitSelectCommand(c SelectCommand) interface{} {
 	if c.FromClause != nil { // here we have to handle nested
 		if nestedCmd, isNested := c.FromClause.(SelectCommand); isNested {
 			sb.WriteString("(")
-			sb.WriteString(AsString(nestedCmd))
+			sb.WriteString(v.asString(nestedCmd))
 			sb.WriteString(")")
 		} else if nestedCmd, ok := c.FromClause.(*SelectCommand); ok {
 			sb.WriteString("(")
-			sb.WriteString(AsString(nestedCmd))
+			sb.WriteString(v.asString(nestedCmd))
 			sb.WriteString(")")
 		} else {
-			sb.WriteString(AsString(c.FromClause))
+			sb.WriteString(v.asString(c.FromClause))
 		}
 	}
+	if len(c.ArrayJoin) > 0 {
+		arrayJoin := make([]string, 0, len(c.ArrayJoin))
+		for _, expr := range c.ArrayJoin {
+			arrayJoin = append(arrayJoin, v.asString(expr))
+		}
+		sb.WriteString(" ARRAY JOIN ")
+		sb.WriteString(strings.Join(arrayJoin, ", "))
+	}
+	if c.PreWhere != nil {
+		sb.WriteString(" PREWHERE ")
+		sb.WriteString(v.asString(c.PreWhere))
+	}
 	if c.WhereClause != nil {
 		sb.WriteString(" WHERE ")
-		sb.WriteString(AsString(c.WhereClause))
+		sb.WriteString(v.asString(c.WhereClause))
 	}
 	if c.SampleLimit > 0 {
 		sb.WriteString(fmt.Sprintf(" LIMIT %d)", c.SampleLimit))
@@ -203,16 +247,20 @@ func (v *renderer) VisitSelectCommand(c SelectCommand) interface{} {
 
 	groupBy := make([]string, 0, len(c.GroupBy))
 	for _, col := range c.GroupBy {
-		groupBy = append(groupBy, AsString(col))
+		groupBy = append(groupBy, v.asString(col))
 	}
 	if len(groupBy) > 0 {
 		sb.WriteString(" GROUP BY ")
 		sb.WriteString(strings.Join(groupBy, ", "))
 	}
+	if c.Having != nil {
+		sb.WriteString(" HAVING ")
+		sb.WriteString(v.asString(c.Having))
+	}
 
 	orderBy := make([]string, 0, len(c.OrderBy))
 	for _, col := range c.OrderBy {
-		orderBy = append(orderBy, AsString(col))
+		orderBy = append(orderBy, v.asString(col))
 	}
 	if len(orderBy) > 0 {
 		sb.WriteString(" ORDER BY ")
@@ -229,20 +277,23 @@ func (v *renderer) VisitSelectCommand(c SelectCommand) interface{} {
 func (v *renderer) VisitWindowFunction(f WindowFunction) interface{} {
 	args := make([]string, 0)
 	for _, arg := range f.Args {
-		args = append(args, AsString(arg))
+		args = append(args, v.asString(arg))
 	}
 	partitionBy := make([]string, 0)
 	for _, col := range f.PartitionBy {
-		partitionBy = append(partitionBy, AsString(col))
+		partitionBy = append(partitionBy, v.asString(col))
 	}
 
 	var sb strings.Builder
-	stmtWithoutOrderBy := fmt.Sprintf("%s(%s) OVER (PARTITION BY %s", f.Name, strings.Join(args, ", "), strings.Join(partitionBy, ", "))
-	sb.WriteString(stmtWithoutOrderBy)
+	sb.WriteString(fmt.Sprintf("%s(%s) OVER (", f.Name, strings.Join(args, ", ")))
+	if len(partitionBy) != 0 {
+		sb.WriteString("PARTITION BY ")
+		sb.WriteString(strings.Join(partitionBy, ", "))
+	}
 
 	if len(f.OrderBy.Exprs) != 0 {
 		sb.WriteString(" ORDER BY ")
-		sb.WriteString(AsString(f.OrderBy))
+		sb.WriteString(v.asString(f.OrderBy))
 	}
 	sb.WriteString(")")
 	return sb.String()
@@ -257,5 +308,5 @@ func (v *renderer) VisitParenExpr(p ParenExpr) interface{} {
 }
 
 func (v *renderer) VisitLambdaExpr(l LambdaExpr) interface{} {
-	return fmt.Sprintf("(%s) -> %s", strings.Join(l.Args, ", "), AsString(l.Body))
+	return fmt.Sprintf("(%s) -> %s", strings.Join(l.Args, ", "), v.asString(l.Body))
 }
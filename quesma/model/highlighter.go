@@ -22,6 +22,9 @@ import (
 type Highlighter struct {
 	// Tokens is a map of field/column name to a set of tokens which should be highlighted.
 	Tokens map[string]Tokens
+	// Query is the parsed `highlight_query`, nil if there's none. If set, tokens are extracted from it,
+	// not from the search query, so we can highlight a different set of terms than we filter on.
+	Query Expr
 
 	PreTags  []string
 	PostTags []string
@@ -49,9 +52,14 @@ func (h *Highlighter) ShouldHighlight(columnName string) bool {
 }
 
 // SetTokensToHighlight takes a Select query and extracts tokens that should be highlighted.
+// If there's a highlight query, tokens are extracted from it instead.
 func (h *Highlighter) SetTokensToHighlight(selectCmd SelectCommand) {
 	highlighterVisitor := NewHighlighter()
-	selectCmd.Accept(highlighterVisitor)
+	if h.Query != nil {
+		h.Query.Accept(highlighterVisitor)
+	} else {
+		selectCmd.Accept(highlighterVisitor)
+	}
 	h.Tokens = highlighterVisitor.Tokens
 }
 
@@ -245,7 +253,17 @@ func (v *highlighter) VisitSelectCommand(c SelectCommand) interface{} {
 	if c.WhereClause != nil {
 		where = c.WhereClause.Accept(v).(Expr)
 	}
-	return *NewSelectCommand(columns, groupBy, orderBy, from, where, c.Limit, c.SampleLimit, c.IsDistinct)
+	selectCommand := *NewSelectCommand(columns, groupBy, orderBy, from, where, c.Limit, c.SampleLimit, c.IsDistinct)
+	if c.PreWhere != nil {
+		selectCommand.PreWhere = c.PreWhere.Accept(v).(Expr)
+	}
+	for _, expr := range c.ArrayJoin {
+		selectCommand.ArrayJoin = append(selectCommand.ArrayJoin, expr.Accept(v).(Expr))
+	}
+	if c.Having != nil {
+		selectCommand.Having = c.Having.Accept(v).(Expr)
+	}
+	return selectCommand
 }
 
 func (v *highlighter) VisitWindowFunction(f WindowFunction) interface{} {
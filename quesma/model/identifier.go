@@ -0,0 +1,66 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// clickhouseKeywords are (case-insensitive) keywords, which can't be used as unquoted identifiers without
+// changing the meaning of a query, or at least its readability
+var clickhouseKeywords = map[string]struct{}{
+	"ALL": {}, "AND": {}, "ANY": {}, "ARRAY": {}, "AS": {}, "ASC": {}, "BETWEEN": {}, "BY": {}, "CASE": {}, "CAST": {},
+	"COLLATE": {}, "CROSS": {}, "DATE": {}, "DESC": {}, "DISTINCT": {}, "ELSE": {}, "END": {}, "EXCEPT": {}, "EXISTS": {},
+	"FALSE": {}, "FINAL": {}, "FIRST": {}, "FORMAT": {}, "FROM": {}, "FULL": {}, "GLOBAL": {}, "GROUP": {}, "HAVING": {},
+	"ILIKE": {}, "IN": {}, "INF": {}, "INNER": {}, "INTERSECT": {}, "INTERVAL": {}, "INTO": {}, "IS": {}, "JOIN": {},
+	"LAST": {}, "LEFT": {}, "LIKE": {}, "LIMIT": {}, "NAN": {}, "NOT": {}, "NULL": {}, "NULLS": {}, "OFFSET": {}, "ON": {},
+	"OR": {}, "ORDER": {}, "OUTER": {}, "OVER": {}, "PARTITION": {}, "PREWHERE": {}, "QUALIFY": {}, "RIGHT": {},
+	"SAMPLE": {}, "SELECT": {}, "SETTINGS": {}, "THEN": {}, "TIMESTAMP": {}, "TOP": {}, "TOTALS": {}, "TRUE": {},
+	"UNION": {}, "USING": {}, "WHEN": {}, "WHERE": {}, "WINDOW": {}, "WITH": {},
+}
+
+// QuoteIdentifier returns `name` as an identifier (column name or alias) in generated SQL: in double quotes,
+// escaping what ClickHouse needs escaped. Other characters (also non-ASCII ones) are kept as they are.
+func QuoteIdentifier(name string) string {
+	var quoted strings.Builder
+	quoted.Grow(len(name) + 2)
+	quoted.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch char := name[i]; char {
+		case '"', '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(char)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		default:
+			if char < 0x20 || char == 0x7f {
+				quoted.WriteString(fmt.Sprintf(`\x%02x`, char))
+			} else {
+				quoted.WriteByte(char)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// identifierNeedsQuoting returns false <=> `name` is [a-zA-Z_][a-zA-Z0-9_]*, and not a keyword
+func identifierNeedsQuoting(name string) bool {
+	if name == "" {
+		return true
+	}
+	for i, char := range name {
+		isLetter := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || char == '_'
+		isDigit := char >= '0' && char <= '9'
+		if !isLetter && (i == 0 || !isDigit) {
+			return true
+		}
+	}
+	_, isKeyword := clickhouseKeywords[strings.ToUpper(name)]
+	return isKeyword
+}
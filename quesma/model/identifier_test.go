@@ -0,0 +1,52 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		name                    string
+		wantAlways, wantIfNeeds string
+	}{
+		{"message", `"message"`, `message`},
+		{"_id2", `"_id2"`, `_id2`},
+		{"order", `"order"`, `"order"`}, // keyword
+		{"Select", `"Select"`, `"Select"`},
+		{"host.name", `"host.name"`, `"host.name"`},
+		{"geo::lat", `"geo::lat"`, `"geo::lat"`},
+		{"first name", `"first name"`, `"first name"`},
+		{"2xx", `"2xx"`, `"2xx"`},
+		{`say "hi"`, `"say \"hi\""`, `"say \"hi\""`},
+		{`C:\path`, `"C:\\path"`, `"C:\\path"`},
+		{"tab\tnew\nline", `"tab\tnew\nline"`, `"tab\tnew\nline"`},
+		{"bell\a", `"bell\x07"`, `"bell\x07"`},
+		{"zażółć", `"zażółć"`, `"zażółć"`},
+		{"", `""`, `""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantAlways, QuoteIdentifier(tt.name))
+			assert.Equal(t, tt.wantAlways, AsString(NewColumnRef(tt.name)))
+			assert.Equal(t, tt.wantIfNeeds, AsStringWithOptions(NewColumnRef(tt.name), RenderOptions{QuoteIdentifiersWhenNeeded: true}))
+		})
+	}
+}
+
+func TestSelectCommandQuotesIdentifiersWhenNeeded(t *testing.T) {
+	where := And([]Expr{
+		NewInfixExpr(NewColumnRef("order"), "=", NewLiteral("'new'")),
+		NewInfixExpr(NewColumnRef("user name"), "=", NewLiteral("'jane'")),
+	})
+	selectCommand := NewSelectCommand(
+		[]Expr{NewColumnRef("status"), NewAliasedExpr(NewCountFunc(), "count"), NewAliasedExpr(NewColumnRef("host.name"), "host")},
+		[]Expr{NewColumnRef("status")}, nil, NewTableRef(`"logs"`), where, 0, 0, false)
+
+	assert.Equal(t, `SELECT "status", count() AS "count", "host.name" AS "host" FROM "logs" `+
+		`WHERE ("order"='new' AND "user name"='jane') GROUP BY "status"`, AsString(selectCommand))
+	assert.Equal(t, `SELECT status, count() AS count, "host.name" AS host FROM "logs" `+
+		`WHERE ("order"='new' AND "user name"='jane') GROUP BY status`, selectCommand.StringWithOptions(RenderOptions{QuoteIdentifiersWhenNeeded: true}))
+}
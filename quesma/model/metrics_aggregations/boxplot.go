@@ -0,0 +1,120 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package metrics_aggregations
+
+import (
+	"context"
+	"math"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/util"
+)
+
+type Boxplot struct {
+	ctx context.Context
+}
+
+func NewBoxplot(ctx context.Context) Boxplot {
+	return Boxplot{ctx: ctx}
+}
+
+// BoxplotQuartiles are the levels of `quantiles` we select, in this order, after minOrNull and maxOrNull
+const BoxplotQuartiles = "0.25, 0.5, 0.75"
+
+const boxplotSelectFieldsNr = 3 // min, max, quantiles (array of 3 quartiles)
+
+func (query Boxplot) IsBucketAggregation() bool {
+	return false
+}
+
+func (query Boxplot) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	if len(rows) == 0 {
+		logger.WarnWithCtx(query.ctx).Msg("no rows returned for boxplot aggregation")
+		return emptyBoxplotResult()
+	}
+	if len(rows) > 1 {
+		logger.WarnWithCtx(query.ctx).Msgf("more than one row returned for boxplot aggregation, using only first. rows[0]: %+v, rows[1]: %+v", rows[0], rows[1])
+	}
+	row := rows[0]
+	if len(row.Cols) < boxplotSelectFieldsNr {
+		logger.WarnWithCtx(query.ctx).Msgf("not enough fields in the response for boxplot aggregation. Expected at least %d, got %d. Got: %+v. Returning empty result.",
+			boxplotSelectFieldsNr, len(row.Cols), row)
+		return emptyBoxplotResult()
+	}
+
+	l := len(row.Cols)
+	minValue, okMin := util.ExtractNumeric64Maybe(row.Cols[l-3].Value)
+	maxValue, okMax := util.ExtractNumeric64Maybe(row.Cols[l-2].Value)
+	quartiles, okQuartiles := query.quartiles(row.Cols[l-1].Value)
+	if !okMin || !okMax || !okQuartiles {
+		// no documents (or only nulls) in the bucket
+		return emptyBoxplotResult()
+	}
+
+	lower, upper := boxplotWhiskers(minValue, maxValue, quartiles[0], quartiles[2])
+	return []model.JsonMap{{
+		"min":   minValue,
+		"max":   maxValue,
+		"q1":    quartiles[0],
+		"q2":    quartiles[1],
+		"q3":    quartiles[2],
+		"lower": lower,
+		"upper": upper,
+	}}
+}
+
+func (query Boxplot) String() string {
+	return "boxplot"
+}
+
+func (query Boxplot) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
+	return rowsFromDB
+}
+
+// quartiles returns q1, q2, q3 from the result of `quantiles(0.25, 0.5, 0.75)(field)`
+func (query Boxplot) quartiles(quantilesReturnedByClickhouse any) (quartiles [3]float64, ok bool) {
+	var values []any
+	switch quantilesTyped := quantilesReturnedByClickhouse.(type) {
+	case []float64:
+		for _, value := range quantilesTyped {
+			values = append(values, value)
+		}
+	case []any:
+		values = quantilesTyped
+	default:
+		logger.WarnWithCtx(query.ctx).Msgf("unexpected type of quantiles in boxplot aggregation: %T, value: %v", quantilesReturnedByClickhouse, quantilesReturnedByClickhouse)
+		return quartiles, false
+	}
+	if len(values) != len(quartiles) {
+		logger.WarnWithCtx(query.ctx).Msgf("unexpected number of quantiles in boxplot aggregation: %v", values)
+		return quartiles, false
+	}
+	for i, value := range values {
+		quartile, isNumber := util.ExtractNumeric64Maybe(value)
+		if !isNumber || math.IsNaN(quartile) {
+			return quartiles, false
+		}
+		quartiles[i] = quartile
+	}
+	return quartiles, true
+}
+
+// boxplotWhiskers returns whiskers' bounds: 1.5 IQR (q3 - q1) below q1 and above q3, but not beyond min/max of the data.
+// Elastic returns the most extreme data points within these bounds, we approximate them with the bounds themselves.
+func boxplotWhiskers(minValue, maxValue, q1, q3 float64) (lower, upper float64) {
+	iqr := q3 - q1
+	return math.Max(minValue, q1-1.5*iqr), math.Min(maxValue, q3+1.5*iqr)
+}
+
+// emptyBoxplotResult is what Elastic returns for a boxplot over no documents
+func emptyBoxplotResult() []model.JsonMap {
+	return []model.JsonMap{{
+		"min":   "Infinity",
+		"max":   "-Infinity",
+		"q1":    "NaN",
+		"q2":    "NaN",
+		"q3":    "NaN",
+		"lower": "NaN",
+		"upper": "NaN",
+	}}
+}
@@ -0,0 +1,52 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package metrics_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"strconv"
+	"testing"
+)
+
+func TestBoxplotTranslateSqlResponseToJson(t *testing.T) {
+	// quartiles of 1, 2, ..., 100 are 25.75, 50.5, 75.25, so IQR = 49.5 and whiskers can reach 25.75 - 74.25 and 75.25 + 74.25
+	quartiles := []float64{25.75, 50.5, 75.25}
+	tests := []struct {
+		min, max                 any
+		quantiles                any
+		wantedLower, wantedUpper any
+	}{
+		{1.0, 100.0, quartiles, 1.0, 100.0},                      // no outliers: whiskers at min and max
+		{1.0, 1000.0, quartiles, 1.0, 149.5},                     // outlier above
+		{-500.0, 100.0, []any{25.75, 50.5, 75.25}, -48.5, 100.0}, // outlier below
+		{int64(1), int64(1000), quartiles, 1.0, 149.5},           // integer field
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			rows := []model.QueryResultRow{{Cols: []model.QueryResultCol{
+				model.NewQueryResultCol("minOrNull(\"value\")", tt.min),
+				model.NewQueryResultCol("maxOrNull(\"value\")", tt.max),
+				model.NewQueryResultCol("quantiles(0.25, 0.5, 0.75)(\"value\")", tt.quantiles),
+			}}}
+			response := NewBoxplot(context.Background()).TranslateSqlResponseToJson(rows, 0)
+			assert.Len(t, response, 1)
+			assert.Equal(t, 25.75, response[0]["q1"])
+			assert.Equal(t, 50.5, response[0]["q2"])
+			assert.Equal(t, 75.25, response[0]["q3"])
+			assert.Equal(t, tt.wantedLower, response[0]["lower"])
+			assert.Equal(t, tt.wantedUpper, response[0]["upper"])
+		})
+	}
+}
+
+func TestBoxplotNoDocuments(t *testing.T) {
+	rows := []model.QueryResultRow{{Cols: []model.QueryResultCol{
+		model.NewQueryResultCol("minOrNull(\"value\")", nil),
+		model.NewQueryResultCol("maxOrNull(\"value\")", nil),
+		model.NewQueryResultCol("quantiles(0.25, 0.5, 0.75)(\"value\")", []any{nil, nil, nil}),
+	}}}
+	response := NewBoxplot(context.Background()).TranslateSqlResponseToJson(rows, 0)
+	assert.Equal(t, emptyBoxplotResult(), response)
+}
@@ -20,6 +20,10 @@ func (query Cardinality) IsBucketAggregation() bool {
 }
 
 func (query Cardinality) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	if len(rows) == 0 {
+		// no documents <=> no distinct values, Elasticsearch returns 0 here, not null
+		return []model.JsonMap{{"value": int64(0)}}
+	}
 	return metricsTranslateSqlResponseToJson(query.ctx, rows, level)
 }
 
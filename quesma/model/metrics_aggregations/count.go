@@ -6,6 +6,7 @@ import (
 	"context"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 )
 
 type Count struct {
@@ -21,12 +22,13 @@ func (query Count) IsBucketAggregation() bool {
 }
 
 func (query Count) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	var response []model.JsonMap
 	if len(rows) == 0 {
 		logger.WarnWithCtx(query.ctx).Msg("no rows returned for count aggregation")
+		return []model.JsonMap{{"doc_count": int64(0)}}
 	}
+	response := make([]model.JsonMap, 0, len(rows))
 	for _, row := range rows {
-		response = append(response, model.JsonMap{"doc_count": row.Cols[level].Value})
+		response = append(response, model.JsonMap{"doc_count": util.ExtractCount(row.Cols[level].Value)})
 	}
 	return response
 }
@@ -28,18 +28,14 @@ func (query ExtendedStats) IsBucketAggregation() bool {
 func (query ExtendedStats) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
 	if len(rows) == 0 {
 		logger.WarnWithCtx(query.ctx).Msg("no rows returned for stats aggregation")
-		return []model.JsonMap{{
-			"value": nil, // not completely sure if it's a good return value, but it looks fine to me. We should always get 1 row, not 0 anyway.
-		}}
+		return query.emptyResult()
 	}
 	if len(rows) > 1 {
 		logger.WarnWithCtx(query.ctx).Msgf("more than one row returned for stats aggregation, using only first. rows[0]: %+v, rows[1]: %+v", rows[0], rows[1])
 	}
 	if len(rows[0].Cols) < selectFieldsNr {
 		logger.WarnWithCtx(query.ctx).Msgf("not enough fields in the response for extended_stats aggregation. Expected at least %d, got %d. Got: %+v. Returning empty result.", selectFieldsNr, len(rows[0].Cols), rows[0])
-		return []model.JsonMap{{
-			"value": nil, // not completely sure if it's a good return value, but it looks fine to me. We should always get >= selectFieldsNr columns anyway.
-		}}
+		return query.emptyResult()
 	}
 
 	row := rows[0]
@@ -80,6 +76,32 @@ func (query ExtendedStats) TranslateSqlResponseToJson(rows []model.QueryResultRo
 	}}
 }
 
+// emptyResult returns extended stats of no documents, the same as Elasticsearch's
+func (query ExtendedStats) emptyResult() []model.JsonMap {
+	return []model.JsonMap{{
+		"count":                    int64(0),
+		"min":                      nil,
+		"max":                      nil,
+		"avg":                      nil,
+		"sum":                      0.0,
+		"sum_of_squares":           nil,
+		"variance":                 nil,
+		"variance_population":      nil,
+		"variance_sampling":        nil,
+		"std_deviation":            nil,
+		"std_deviation_population": nil,
+		"std_deviation_sampling":   nil,
+		"std_deviation_bounds": model.JsonMap{
+			"upper":            nil,
+			"lower":            nil,
+			"upper_population": nil,
+			"lower_population": nil,
+			"upper_sampling":   nil,
+			"lower_sampling":   nil,
+		},
+	}}
+}
+
 func (query ExtendedStats) String() string {
 	return fmt.Sprintf("extended_stats(sigma=%f)", query.sigma)
 }
@@ -4,6 +4,7 @@ package metrics_aggregations
 
 import (
 	"context"
+	"quesma/logger"
 	"quesma/model"
 )
 
@@ -20,6 +21,11 @@ func (query GeoCentroid) IsBucketAggregation() bool {
 }
 
 func (query GeoCentroid) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
+	if len(rows) == 0 || len(rows[0].Cols) < 6 {
+		logger.WarnWithCtx(query.ctx).Msgf("no rows or too few columns returned for geo_centroid aggregation, rows: %v", rows)
+		// no documents, no centroid (Elasticsearch also returns no location then)
+		return []model.JsonMap{{"count": int64(0)}}
+	}
 	location := model.JsonMap{
 		"lat": rows[0].Cols[3].Value,
 		"lon": rows[0].Cols[4].Value,
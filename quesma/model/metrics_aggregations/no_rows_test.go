@@ -0,0 +1,47 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package metrics_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/model"
+	"testing"
+)
+
+// TestTranslateSqlResponseToJsonNoRows checks, that with no documents (no rows) every metrics aggregation
+// returns a well-formed empty result, like Elasticsearch (e.g. `value: null`), and never panics
+func TestTranslateSqlResponseToJsonNoRows(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		aggregation      model.QueryType
+		expectedResponse model.JsonMap
+	}{
+		{NewAvg(ctx, clickhouse.Invalid), model.JsonMap{"value": nil}},
+		{NewMin(ctx, clickhouse.DateTime64), model.JsonMap{"value": nil}},
+		{NewMax(ctx, clickhouse.Invalid), model.JsonMap{"value": nil}},
+		{NewSum(ctx, clickhouse.Invalid), model.JsonMap{"value": nil}},
+		{NewCardinality(ctx), model.JsonMap{"value": int64(0)}},
+		{NewValueCount(ctx), model.JsonMap{"value": int64(0)}},
+		{NewCount(ctx), model.JsonMap{"doc_count": int64(0)}},
+		{NewStats(ctx), model.JsonMap{"count": int64(0), "min": nil, "max": nil, "avg": nil, "sum": 0.0}},
+		{NewExtendedStats(ctx, 2), NewExtendedStats(ctx, 2).emptyResult()[0]},
+		{NewGeoCentroid(ctx), model.JsonMap{"count": int64(0)}},
+		{NewQuantile(ctx, true, clickhouse.Invalid), model.JsonMap{"values": model.JsonMap{}}},
+		{NewQuantile(ctx, false, clickhouse.Invalid), model.JsonMap{"values": []model.JsonMap{}}},
+		{NewPercentileRanks(ctx, true), model.JsonMap{"values": model.JsonMap{}}},
+		{NewPercentileRanks(ctx, false), model.JsonMap{"values": []model.JsonMap{}}},
+		{NewBoxplot(ctx), emptyBoxplotResult()[0]},
+		{NewTopHits(ctx), model.JsonMap{"hits": []any{}}},
+		{NewTopMetrics(ctx, true), model.JsonMap{"top": []any{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aggregation.String(), func(t *testing.T) {
+			response := tt.aggregation.TranslateSqlResponseToJson([]model.QueryResultRow{}, 0)
+			if assert.Len(t, response, 1) {
+				assert.Equal(t, tt.expectedResponse, response[0])
+			}
+		})
+	}
+}
@@ -28,7 +28,10 @@ func (query PercentileRanks) IsBucketAggregation() bool {
 func (query PercentileRanks) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
 	if len(rows) == 0 {
 		logger.WarnWithCtx(query.ctx).Msg("no rows in percentile ranks response")
-		return make([]model.JsonMap, 0)
+		if query.Keyed {
+			return []model.JsonMap{{"values": model.JsonMap{}}}
+		}
+		return []model.JsonMap{{"values": []model.JsonMap{}}}
 	}
 	// I duplicate a lot of code in this if/else below,
 	// but I think it's worth it, as this function might get called a lot of times for a single query.
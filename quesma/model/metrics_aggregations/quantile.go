@@ -32,11 +32,8 @@ func (query Quantile) TranslateSqlResponseToJson(rows []model.QueryResultRow, le
 	valueMap := make(model.JsonMap)
 	valueAsStringMap := make(model.JsonMap)
 
-	if len(rows) == 0 {
-		return emptyPercentilesResult
-	}
-	if len(rows[0].Cols) == 0 {
-		return emptyPercentilesResult
+	if len(rows) == 0 || len(rows[0].Cols) == 0 {
+		return query.emptyResult()
 	}
 
 	for _, res := range rows[0].Cols {
@@ -148,9 +145,13 @@ func (query Quantile) processResult(colName string, percentileReturnedByClickhou
 	return percentile, percentileAsString, percentileIsNanOrInvalid
 }
 
-var emptyPercentilesResult = []model.JsonMap{{
-	"values": 0,
-}}
+// emptyResult returns percentiles of no documents: no values, in a format depending on `keyed`
+func (query Quantile) emptyResult() []model.JsonMap {
+	if query.keyed {
+		return []model.JsonMap{{"values": model.JsonMap{}}}
+	}
+	return []model.JsonMap{{"values": []model.JsonMap{}}}
+}
 
 func (query Quantile) PostprocessResults(rowsFromDB []model.QueryResultRow) []model.QueryResultRow {
 	return rowsFromDB
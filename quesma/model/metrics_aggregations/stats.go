@@ -25,7 +25,11 @@ func (query Stats) TranslateSqlResponseToJson(rows []model.QueryResultRow, level
 	if len(rows) == 0 {
 		logger.WarnWithCtx(query.ctx).Msg("no rows returned for stats aggregation")
 		return []model.JsonMap{{
-			"value": nil, // not completely sure if it's a good return value, but it looks fine to me. We should always get 1 row, not 0 anyway.
+			"count": int64(0),
+			"min":   nil,
+			"max":   nil,
+			"avg":   nil,
+			"sum":   0.0,
 		}}
 	}
 	if len(rows) > 1 {
@@ -24,7 +24,7 @@ func (query TopMetrics) IsBucketAggregation() bool {
 }
 
 func (query TopMetrics) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	var topElems []any
+	topElems := make([]any, 0, len(rows))
 	if len(rows) > 0 && level >= len(rows[0].Cols)-1 {
 		// values are [level, len(row.Cols) - 1]
 		logger.WarnWithCtx(query.ctx).Msgf(
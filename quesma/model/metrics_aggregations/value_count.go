@@ -6,6 +6,7 @@ import (
 	"context"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
 )
 
 type ValueCount struct {
@@ -21,11 +22,11 @@ func (query ValueCount) IsBucketAggregation() bool {
 }
 
 func (query ValueCount) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	var value any = nil
+	var value any = int64(0)
 	if len(rows) > 0 {
-		value = rows[0].Cols[level].Value
+		value = util.ExtractCount(rows[0].Cols[level].Value)
 	} else {
-		logger.WarnWithCtx(query.ctx).Msg("Nn rows returned for value_count aggregation")
+		logger.WarnWithCtx(query.ctx).Msg("no rows returned for value_count aggregation")
 	}
 	return []model.JsonMap{{
 		"value": value,
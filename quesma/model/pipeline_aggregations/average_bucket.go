@@ -53,32 +53,22 @@ func (query AverageBucket) calculateSingleAvgBucket(parentRows []model.QueryResu
 		return model.QueryResultRow{}
 	}
 
-	var resultValue float64
-	rowsCnt := 0
-	if _, firstRowValueIsFloat := util.ExtractFloat64Maybe(parentRows[0].LastColValue()); firstRowValueIsFloat {
-		sum := 0.0
-		for _, parentRow := range parentRows {
-			value, ok := util.ExtractFloat64Maybe(parentRow.LastColValue())
-			if ok {
-				sum += value
-				rowsCnt++
-			} else {
-				logger.WarnWithCtx(query.ctx).Msgf("could not convert value to float: %v, type: %T. Skipping", parentRow.LastColValue(), parentRow.LastColValue())
-			}
+	var resultValue any
+	sum, rowsCnt := 0.0, 0
+	for _, parentRow := range parentRows {
+		if parentRow.LastColValue() == nil {
+			continue // empty bucket, we skip it, like Elastic with its default gap_policy
 		}
-		resultValue = sum / float64(rowsCnt)
-	} else {
-		var sum int64
-		for _, parentRow := range parentRows {
-			value, ok := util.ExtractInt64Maybe(parentRow.LastColValue())
-			if ok {
-				sum += value
-				rowsCnt++
-			} else {
-				logger.WarnWithCtx(query.ctx).Msgf("could not convert value to int: %v, type: %T. Skipping", parentRow.LastColValue(), parentRow.LastColValue())
-			}
+		value, ok := util.ExtractNumeric64Maybe(parentRow.LastColValue())
+		if ok {
+			sum += value
+			rowsCnt++
+		} else {
+			logger.WarnWithCtx(query.ctx).Msgf("could not convert value to float: %v, type: %T. Skipping", parentRow.LastColValue(), parentRow.LastColValue())
 		}
-		resultValue = float64(sum) / float64(rowsCnt)
+	}
+	if rowsCnt > 0 {
+		resultValue = sum / float64(rowsCnt)
 	}
 
 	resultRow := parentRows[0].Copy()
@@ -36,9 +36,9 @@ func getKey(ctx context.Context, row model.QueryResultRow, query *model.Query) a
 func translateSqlResponseToJsonCommon(ctx context.Context, rows []model.QueryResultRow, aggregationName string) []model.JsonMap {
 	if len(rows) == 0 {
 		logger.WarnWithCtx(ctx).Msgf("no rows returned for %s aggregation", aggregationName)
-		return []model.JsonMap{{}}
+		return []model.JsonMap{{"value": nil}}
 	}
-	var response []model.JsonMap
+	response := make([]model.JsonMap, 0, len(rows))
 	for _, row := range rows {
 		response = append(response, model.JsonMap{"value": row.Cols[len(row.Cols)-1].Value})
 	}
@@ -5,7 +5,10 @@ package pipeline_aggregations
 import (
 	"context"
 	"fmt"
+	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
+	"time"
 )
 
 // Derivative is just Serial Diff, with lag = 1
@@ -16,11 +19,15 @@ type Derivative struct {
 	ctx     context.Context
 	Parent  string
 	IsCount bool
+	// Unit, if != 0, makes us also return "normalized_value": derivative per Unit (e.g. per second), not per bucket
+	Unit time.Duration
+	// BucketInterval is the interval of the parent date_histogram, needed for normalization
+	BucketInterval time.Duration
 }
 
-func NewDerivative(ctx context.Context, bucketsPath string) Derivative {
+func NewDerivative(ctx context.Context, bucketsPath string, unit time.Duration) Derivative {
 	isCount := bucketsPath == BucketsPathCount
-	return Derivative{ctx: ctx, Parent: bucketsPath, IsCount: isCount}
+	return Derivative{ctx: ctx, Parent: bucketsPath, IsCount: isCount, Unit: unit}
 }
 
 func (query Derivative) IsBucketAggregation() bool {
@@ -28,7 +35,18 @@ func (query Derivative) IsBucketAggregation() bool {
 }
 
 func (query Derivative) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	return translateSqlResponseToJsonCommon(query.ctx, rows, query.String())
+	response := translateSqlResponseToJsonCommon(query.ctx, rows, query.String())
+	if query.Unit == 0 || query.BucketInterval == 0 {
+		return response
+	}
+	for _, bucket := range response {
+		if value, ok := util.ExtractNumeric64Maybe(bucket["value"]); ok {
+			bucket["normalized_value"] = value * float64(query.Unit) / float64(query.BucketInterval)
+		} else if bucket["value"] != nil {
+			logger.WarnWithCtx(query.ctx).Msgf("can't normalize derivative value: %v, type: %T", bucket["value"], bucket["value"])
+		}
+	}
+	return response
 }
 
 func (query Derivative) CalculateResultWhenMissing(qwa *model.Query, parentRows []model.QueryResultRow) []model.QueryResultRow {
@@ -40,5 +58,8 @@ func (query Derivative) PostprocessResults(rowsFromDB []model.QueryResultRow) []
 }
 
 func (query Derivative) String() string {
+	if query.Unit != 0 {
+		return fmt.Sprintf("derivative(%s, unit: %v)", query.Parent, query.Unit)
+	}
 	return fmt.Sprintf("derivative(%s)", query.Parent)
 }
@@ -31,7 +31,7 @@ func (query MaxBucket) IsBucketAggregation() bool {
 func (query MaxBucket) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
 	if len(rows) == 0 {
 		logger.WarnWithCtx(query.ctx).Msg("no rows returned for max bucket aggregation")
-		return []model.JsonMap{nil}
+		return []model.JsonMap{{"value": nil, "keys": []any{}}}
 	}
 	if len(rows) > 1 {
 		logger.WarnWithCtx(query.ctx).Msg("more than one row returned for max bucket aggregation")
@@ -28,7 +28,7 @@ func (query MinBucket) IsBucketAggregation() bool {
 func (query MinBucket) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
 	if len(rows) == 0 {
 		logger.WarnWithCtx(query.ctx).Msg("no rows returned for min bucket aggregation")
-		return []model.JsonMap{nil}
+		return []model.JsonMap{{"value": nil, "keys": []any{}}}
 	}
 	if len(rows) > 1 {
 		logger.WarnWithCtx(query.ctx).Msg("more than one row returned for min bucket aggregation")
@@ -47,7 +47,7 @@ func (query MinBucket) CalculateResultWhenMissing(qwa *model.Query, parentRows [
 	}
 	qp := queryprocessor.NewQueryProcessor(query.ctx)
 	parentFieldsCnt := len(parentRows[0].Cols) - 2 // -2, because row is [parent_cols..., current_key, current_value]
-	// in calculateSingleAvgBucket we calculate avg all current_keys with the same parent_cols
+	// in calculateSingleMinBucket we calculate min of all current_keys with the same parent_cols
 	// so we need to split into buckets based on parent_cols
 	if parentFieldsCnt < 0 {
 		logger.WarnWithCtx(query.ctx).Msgf("parentFieldsCnt is less than 0: %d", parentFieldsCnt)
@@ -62,10 +62,27 @@ func (query MinBucket) CalculateResultWhenMissing(qwa *model.Query, parentRows [
 func (query MinBucket) calculateSingleMinBucket(qwa *model.Query, parentRows []model.QueryResultRow) model.QueryResultRow {
 	var resultValue any
 	var resultKeys []any
-	if firstRowValueFloat, firstRowValueIsFloat := util.ExtractFloat64Maybe(parentRows[0].LastColValue()); firstRowValueIsFloat {
+
+	firstNonNilIndex := -1
+	for i, row := range parentRows {
+		if row.LastColValue() != nil {
+			firstNonNilIndex = i
+			break
+		}
+	}
+	if firstNonNilIndex == -1 {
+		resultRow := parentRows[0].Copy()
+		resultRow.Cols[len(resultRow.Cols)-1].Value = model.JsonMap{
+			"value": resultValue,
+			"keys":  resultKeys,
+		}
+		return resultRow
+	}
+
+	if firstRowValueFloat, firstRowValueIsFloat := util.ExtractFloat64Maybe(parentRows[firstNonNilIndex].LastColValue()); firstRowValueIsFloat {
 		// find min
 		minValue := firstRowValueFloat
-		for _, row := range parentRows[1:] {
+		for _, row := range parentRows[firstNonNilIndex+1:] {
 			value, ok := util.ExtractFloat64Maybe(row.LastColValue())
 			if ok {
 				minValue = min(minValue, value)
@@ -75,15 +92,15 @@ func (query MinBucket) calculateSingleMinBucket(qwa *model.Query, parentRows []m
 		}
 		resultValue = minValue
 		// find keys with min value
-		for _, row := range parentRows {
+		for _, row := range parentRows[firstNonNilIndex:] {
 			if value, ok := util.ExtractFloat64Maybe(row.LastColValue()); ok && value == minValue {
 				resultKeys = append(resultKeys, getKey(query.ctx, row, qwa))
 			}
 		}
-	} else if firstRowValueInt, firstRowValueIsInt := util.ExtractInt64Maybe(parentRows[0].LastColValue()); firstRowValueIsInt {
+	} else if firstRowValueInt, firstRowValueIsInt := util.ExtractInt64Maybe(parentRows[firstNonNilIndex].LastColValue()); firstRowValueIsInt {
 		// find min
 		minValue := firstRowValueInt
-		for _, row := range parentRows[1:] {
+		for _, row := range parentRows[firstNonNilIndex+1:] {
 			value, ok := util.ExtractInt64Maybe(row.LastColValue())
 			if ok {
 				minValue = min(minValue, value)
@@ -93,11 +110,14 @@ func (query MinBucket) calculateSingleMinBucket(qwa *model.Query, parentRows []m
 		}
 		resultValue = minValue
 		// find keys with min value
-		for _, row := range parentRows {
+		for _, row := range parentRows[firstNonNilIndex:] {
 			if value, ok := util.ExtractInt64Maybe(row.LastColValue()); ok && value == minValue {
 				resultKeys = append(resultKeys, getKey(query.ctx, row, qwa))
 			}
 		}
+	} else {
+		logger.WarnWithCtx(query.ctx).Msgf("could not convert value to float or int: %v, type: %T. Returning nil.",
+			parentRows[firstNonNilIndex].LastColValue(), parentRows[firstNonNilIndex].LastColValue())
 	}
 
 	resultRow := parentRows[0].Copy()
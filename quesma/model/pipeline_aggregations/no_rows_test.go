@@ -0,0 +1,37 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package pipeline_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"testing"
+)
+
+// TestTranslateSqlResponseToJsonNoRows checks, that with no parent buckets (no rows) every pipeline aggregation
+// returns a well-formed empty result, like Elasticsearch, and not nil or an empty object
+func TestTranslateSqlResponseToJsonNoRows(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		aggregation      model.QueryType
+		expectedResponse model.JsonMap
+	}{
+		{NewAverageBucket(ctx, "histogram>_count"), model.JsonMap{"value": nil}},
+		{NewCumulativeSum(ctx, "_count"), model.JsonMap{"value": nil}},
+		{NewDerivative(ctx, "_count", 0), model.JsonMap{"value": nil}},
+		{NewSerialDiff(ctx, "_count", 1), model.JsonMap{"value": nil}},
+		{NewMaxBucket(ctx, "histogram>_count"), model.JsonMap{"value": nil, "keys": []any{}}},
+		{NewMinBucket(ctx, "histogram>_count"), model.JsonMap{"value": nil, "keys": []any{}}},
+		{NewSumBucket(ctx, "histogram>_count"), model.JsonMap{"value": 0.0}},
+		{NewBucketScript(ctx), model.JsonMap{"value": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aggregation.String(), func(t *testing.T) {
+			response := tt.aggregation.TranslateSqlResponseToJson([]model.QueryResultRow{}, 0)
+			if assert.Len(t, response, 1) {
+				assert.Equal(t, tt.expectedResponse, response[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package pipeline_aggregations
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"testing"
+)
+
+func TestSerialDiffLag(t *testing.T) {
+	// rows: [key, value]
+	var parentRows []model.QueryResultRow
+	for key, value := range []any{1.0, 4.0, nil, 9.0, 16.0, 25.0} {
+		parentRows = append(parentRows, model.QueryResultRow{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol("key", int64(key)),
+			model.NewQueryResultCol("value", value),
+		}})
+	}
+	values := func(rows []model.QueryResultRow) []any {
+		result := make([]any, 0, len(rows))
+		for _, row := range rows {
+			result = append(result, row.LastColValue())
+		}
+		return result
+	}
+
+	tests := []struct {
+		lag        int
+		wantValues []any
+	}{
+		{1, []any{nil, 3.0, nil, nil, 7.0, 9.0}},
+		{2, []any{nil, nil, nil, 5.0, nil, 16.0}},
+	}
+	for _, tt := range tests {
+		serialDiff := NewSerialDiff(context.Background(), "value", tt.lag)
+		resultRows := serialDiff.CalculateResultWhenMissing(nil, parentRows)
+		assert.Equal(t, tt.wantValues, values(resultRows), "lag: %d", tt.lag)
+		for i, row := range resultRows {
+			assert.Equal(t, int64(i), row.Cols[0].Value) // keys are kept
+		}
+	}
+}
@@ -26,11 +26,11 @@ func (query SumBucket) IsBucketAggregation() bool {
 
 func (query SumBucket) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
 	if len(rows) == 0 {
-		logger.WarnWithCtx(query.ctx).Msg("no rows returned for average bucket aggregation")
-		return []model.JsonMap{nil}
+		logger.WarnWithCtx(query.ctx).Msg("no rows returned for sum bucket aggregation")
+		return []model.JsonMap{{"value": 0.0}}
 	}
 	if len(rows) > 1 {
-		logger.WarnWithCtx(query.ctx).Msg("more than one row returned for average bucket aggregation")
+		logger.WarnWithCtx(query.ctx).Msg("more than one row returned for sum bucket aggregation")
 	}
 	if returnMap, ok := rows[0].LastColValue().(model.JsonMap); ok {
 		return []model.JsonMap{returnMap}
@@ -46,7 +46,7 @@ func (query SumBucket) CalculateResultWhenMissing(qwa *model.Query, parentRows [
 	}
 	qp := queryprocessor.NewQueryProcessor(query.ctx)
 	parentFieldsCnt := len(parentRows[0].Cols) - 2 // -2, because row is [parent_cols..., current_key, current_value]
-	// in calculateSingleAvgBucket we calculate avg all current_keys with the same parent_cols
+	// in calculateSingleSumBucket we calculate sum of all current_keys with the same parent_cols
 	// so we need to split into buckets based on parent_cols
 	if parentFieldsCnt < 0 {
 		logger.WarnWithCtx(query.ctx).Msgf("parentFieldsCnt is less than 0: %d", parentFieldsCnt)
@@ -9,8 +9,16 @@ import (
 const (
 	RowNumberColumnName = "row_number"
 	noLimit             = 0
+	// InnerHitsRowNumberColumnName/InnerHitsTotalColumnName/InnerHitsCollapseKeyColumnName are the position of a hit
+	// in its collapsed group (by inner_hits sort), the size of the group and its value of the collapse field
+	InnerHitsRowNumberColumnName   = "inner_hits_row_number"
+	InnerHitsTotalColumnName       = "inner_hits_total"
+	InnerHitsCollapseKeyColumnName = "inner_hits_collapse_key"
 )
 
+// InnerHitsComputedColumnNames are columns of collapsed hits with inner hits, computed in their subquery, not stored in a table
+var InnerHitsComputedColumnNames = []string{RowNumberColumnName, InnerHitsRowNumberColumnName, InnerHitsTotalColumnName}
+
 type (
 	Query struct {
 		SelectCommand SelectCommand // The representation of SELECT query
@@ -28,6 +36,12 @@ type (
 		// But it works for the test + our dashboards, so let's fix it later if necessary.
 		// NoMetadataField (nil) is a valid option and means no meta field in the response.
 		Metadata JsonMap
+
+		GroupByStrategy GroupByStrategy // how the database should compute GROUP BY, chosen from terms' execution_hint
+
+		// SampleProbability > 0 <=> the aggregation is in random_sampler, so it's computed over documents sampled with this
+		// probability, and its counts and sums are scaled by 1/SampleProbability
+		SampleProbability float64
 	}
 	QueryType interface {
 		// TranslateSqlResponseToJson 'level' - we want to translate [level:] (metrics aggr) or [level-1:] (bucket aggr) columns to JSON
@@ -42,8 +56,41 @@ type (
 		IsBucketAggregation() bool
 		String() string
 	}
+
+	// FalliblePostprocessing is implemented by query types, whose postprocessing fails for some results,
+	// e.g. histogram, which would need too many empty buckets. Then the whole search fails.
+	FalliblePostprocessing interface {
+		TryPostprocessResults(rowsFromDB []QueryResultRow) (ultimateRows []QueryResultRow, err error)
+	}
 )
 
+// PostprocessResults postprocesses `rowsFromDB` of a query of `queryType`, with TryPostprocessResults, if it implements FalliblePostprocessing
+func PostprocessResults(queryType QueryType, rowsFromDB []QueryResultRow) ([]QueryResultRow, error) {
+	if fallible, ok := queryType.(FalliblePostprocessing); ok {
+		return fallible.TryPostprocessResults(rowsFromDB)
+	}
+	return queryType.PostprocessResults(rowsFromDB), nil
+}
+
+// GroupByStrategy is only a performance hint, it never changes results of a query.
+type GroupByStrategy int
+
+const (
+	GroupByDefault GroupByStrategy = iota // let the database choose
+	GroupByHash                           // hash table over all rows, Elastic's execution_hint "map"
+	GroupBySorted                         // in order of table's sorting key (if possible), Elastic's execution_hint "global_ordinals"
+)
+
+func (s GroupByStrategy) String() string {
+	switch s {
+	case GroupByHash:
+		return "hash"
+	case GroupBySorted:
+		return "sorted"
+	}
+	return "default"
+}
+
 func NewSortColumn(field string, direction OrderByDirection) OrderByExpr {
 	return NewOrderByExpr([]Expr{NewColumnRef(field)}, direction)
 }
@@ -111,6 +158,10 @@ type Aggregator struct {
 	SplitOverHowManyFields int  // normally 0 or 1, currently only multi_terms have > 1, as we split over multiple fields on one level.
 	Keyed                  bool // determines how results are returned in response's JSON
 	Filters                bool // if true, this aggregator is a filters aggregator
+	// filters aggregator with Keyed == false only: names of filters (subaggregators), in the order of buckets in the response's array.
+	// If AnonymousFilters, buckets are returned without "key".
+	FilterNames      []string
+	AnonymousFilters bool
 }
 
 // NewAggregator (the only constructor) initializes Aggregator as "empty", so with SplitOverHowManyFields == 0, Keyed == false, Filters == false.
@@ -133,6 +184,7 @@ const (
 	DefaultSizeListQuery = 10 // we use LIMIT 10 in some simple list queries (SELECT ...)
 	TrackTotalHitsTrue   = -1
 	TrackTotalHitsFalse  = -2
+	StoredFieldsNone     = "_none_" // `stored_fields` value, which disables retrieval of all fields
 )
 
 func (queryType SearchQueryType) String() string {
@@ -147,8 +199,44 @@ type SearchQueryInfo struct {
 	FieldName      string
 	I1             int
 	I2             int
-	Size           int // how many hits to return
-	TrackTotalHits int // >= 0: we want this nr of total hits, TrackTotalHitsTrue: it was "true", TrackTotalHitsFalse: it was "false", in the request
+	Size           int    // how many hits to return
+	CollapseField  string // if not empty, only the top hit per distinct value of this field is returned
+	TrackTotalHits int    // >= 0: we want this nr of total hits, TrackTotalHitsTrue: it was "true", TrackTotalHitsFalse: it was "false", in the request
+	// CollapseInnerHits, if not nil, makes every collapsed hit have also top hits of its group ("collapse.inner_hits")
+	CollapseInnerHits *CollapseInnerHits
+	// StoredFields, if not nil, restricts fields of hits to these ones, and then there's no _source (unless SourceRequested)
+	StoredFields     []string
+	SourceRequested  bool // true <=> "_source": true was in the request
+	SourceDisabled   bool // true <=> "_source": false was in the request, hits have no _source then
+	VersionRequested bool // true <=> "version": true was in the request, hits have _version then
+	// SourceIncludes/SourceExcludes restrict fields in hits' _source (from "_source" as a field, a list of fields, or an includes/excludes object)
+	SourceIncludes []string
+	SourceExcludes []string
+	// DocValueFields are fields from "docvalue_fields" (with wildcards already expanded), returned in hits' fields
+	DocValueFields []DocValueField
+	// RuntimeFields are fields from "runtime_mappings", computed by the query. They're returned in hits' fields (not in _source).
+	RuntimeFields []RuntimeField
+	// SearchAfter, if not nil, is the condition from "search_after", selecting hits after the previous page
+	SearchAfter Expr
+}
+
+// CollapseInnerHits are "inner_hits" of "collapse": top `Size` hits (by `OrderBy`) of every collapsed group, returned under `Name`
+type CollapseInnerHits struct {
+	Name    string
+	Size    int
+	OrderBy []OrderByExpr
+}
+
+// RuntimeField is a field of "runtime_mappings": its script translated to the expression, which computes it
+type RuntimeField struct {
+	Name string
+	Expr Expr
+}
+
+// DocValueField is a single field requested in "docvalue_fields", with an optional format of its values (e.g. "epoch_millis")
+type DocValueField struct {
+	Field  string
+	Format string
 }
 
 func NewSearchQueryInfoNormal() SearchQueryInfo {
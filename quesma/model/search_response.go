@@ -18,10 +18,11 @@ type ResponseShardsFailure struct {
 }
 
 type ResponseShards struct {
-	Total      int `json:"total"`
-	Successful int `json:"successful"`
-	Failed     int `json:"failed"`
-	Skipped    int `json:"skipped"`
+	Total      int                     `json:"total"`
+	Successful int                     `json:"successful"`
+	Failed     int                     `json:"failed"`
+	Skipped    int                     `json:"skipped"`
+	Failures   []ResponseShardsFailure `json:"failures,omitempty"`
 }
 
 type SearchHit struct {
@@ -35,8 +36,15 @@ type SearchHit struct {
 	Version   int                 `json:"_version,omitempty"`
 	Highlight map[string][]string `json:"highlight,omitempty"`
 
-	Type string `json:"_type,omitempty"` // Deprecated field
-	Sort []any  `json:"sort,omitempty"`
+	Type    string   `json:"_type,omitempty"` // Deprecated field
+	Sort    []any    `json:"sort,omitempty"`
+	Ignored []string `json:"_ignored,omitempty"` // fields with values over their `ignore_above`
+
+	InnerHits map[string]InnerHits `json:"inner_hits,omitempty"` // top hits of the group of a collapsed hit, by name
+}
+
+type InnerHits struct {
+	Hits SearchHits `json:"hits"`
 }
 
 func NewSearchHit(index string) SearchHit {
@@ -44,10 +52,17 @@ func NewSearchHit(index string) SearchHit {
 }
 
 type SearchHits struct {
-	Total    *Total      `json:"total,omitempty"`
-	MaxScore *float32    `json:"max_score"`
-	Hits     []SearchHit `json:"hits"`
-	Events   []SearchHit `json:"events,omitempty"` // this one is used by EQL
+	Total     *Total        `json:"total,omitempty"`
+	MaxScore  *float32      `json:"max_score"`
+	Hits      []SearchHit   `json:"hits"`
+	Events    []SearchHit   `json:"events,omitempty"`    // this one is used by EQL
+	Sequences []EQLSequence `json:"sequences,omitempty"` // this one is used by EQL `sequence` queries
+}
+
+// EQLSequence is a single match of EQL `sequence` query: one event for each of its steps
+type EQLSequence struct {
+	JoinKeys []any       `json:"join_keys,omitempty"`
+	Events   []SearchHit `json:"events"`
 }
 
 type Total struct {
@@ -63,6 +78,7 @@ type SearchResp struct {
 	Hits              SearchHits     `json:"hits"`
 	Aggregations      JsonMap        `json:"aggregations,omitempty"`
 	ScrollID          *string        `json:"_scroll_id,omitempty"`
+	PitID             *string        `json:"pit_id,omitempty"`
 }
 
 func (response *SearchResp) Marshal() ([]byte, error) {
@@ -2,13 +2,18 @@
 // SPDX-License-Identifier: Elastic-2.0
 package model
 
+import "slices"
+
 type SelectCommand struct {
 	IsDistinct bool // true <=> query is SELECT DISTINCT
 
 	Columns     []Expr        // Columns to select
 	FromClause  Expr          // usually just "tableName", or databaseName."tableName". Sometimes a subquery e.g. (SELECT ...)
+	ArrayJoin   []Expr        // "ARRAY JOIN ...", ClickHouse: one row for every element of these array columns. Optional.
+	PreWhere    Expr          // "PREWHERE ...", ClickHouse filter applied before reading other columns. Optional.
 	WhereClause Expr          // "WHERE ..." until next clause like GROUP BY/ORDER BY, etc.
 	GroupBy     []Expr        // if not empty, we do GROUP BY GroupBy...
+	Having      Expr          // "HAVING ...", filter applied after grouping. Optional.
 	OrderBy     []OrderByExpr // if not empty, we do ORDER BY OrderBy...
 
 	Limit       int // LIMIT clause, noLimit (0) means no limit
@@ -40,6 +45,11 @@ func (c SelectCommand) String() string {
 	return AsString(c)
 }
 
+// StringWithOptions is like String, but renders the query according to `options`
+func (c SelectCommand) StringWithOptions(options RenderOptions) string {
+	return AsStringWithOptions(c, options)
+}
+
 func (c *SelectCommand) IsWildcard() bool {
 	for _, col := range c.Columns {
 		if col == NewWildcardExpr {
@@ -53,10 +63,13 @@ func (c *SelectCommand) IsWildcard() bool {
 // only returns Order By columns, which are "tableColumn ASC/DESC",
 // won't return complex ones, like e.g. toInt(int_field / 5).
 // but it was like that before the refactor
+// Each field is returned once, even if we sort by it multiple times (e.g. `field IS NULL, field`)
 func (c *SelectCommand) OrderByFieldNames() (fieldNames []string) {
 	for _, expr := range c.OrderBy {
 		for _, colRefs := range GetUsedColumns(expr) {
-			fieldNames = append(fieldNames, colRefs.ColumnName)
+			if !slices.Contains(fieldNames, colRefs.ColumnName) {
+				fieldNames = append(fieldNames, colRefs.ColumnName)
+			}
 		}
 	}
 	return fieldNames
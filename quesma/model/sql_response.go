@@ -0,0 +1,14 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package model
+
+// SqlResponse is a response of Elasticsearch SQL API (_sql) in json format
+type SqlResponse struct {
+	Columns []SqlColumn `json:"columns"`
+	Rows    [][]any     `json:"rows"`
+}
+
+type SqlColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // Elasticsearch SQL type, e.g. keyword, long, datetime
+}
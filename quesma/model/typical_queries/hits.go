@@ -7,8 +7,13 @@ import (
 	"fmt"
 	"quesma/clickhouse"
 	"quesma/elasticsearch"
+	"quesma/index"
 	"quesma/logger"
 	"quesma/model"
+	"quesma/util"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -25,20 +30,101 @@ type Hits struct {
 	highlighter    *model.Highlighter
 	sortFieldNames []string
 	addSource      bool // true <=> we add hit.Source field to the response
+	addFields      bool // true <=> we add hit.Fields field to the response
 	addScore       bool // true <=> we add hit.Score field to the response (whose value is always 1)
-	addVersion     bool // true <=> we add hit.Version field to the response (whose value is always 1)
+	addVersion     bool // true <=> we add hit.Version field to the response (1, unless the table has a version field)
+	// sourceIncludes/sourceExcludes filter fields of hit.Source, like "_source": {"includes": [...], "excludes": [...]}.
+	// Empty includes means all fields. Patterns may contain '*'.
+	sourceIncludes []string
+	sourceExcludes []string
+	// docValueFormats are formats of hit.Fields values from "docvalue_fields", e.g. "epoch_millis", by field name
+	docValueFormats map[string]string
+	// versionColumn is the column of the table's version field, which backs hit.Version. "" <=> it's always 1
+	versionColumn string
+	// runtimeFields are computed by the query ("runtime_mappings"), so they're only in hit.Fields, not in hit.Source
+	runtimeFields []string
+	// innerHitsName, if not empty, makes hits collapsed by collapseField have up to innerHitsSize inner hits under this name.
+	// Rows are then both the collapsed hits and their inner hits, with their positions from model.InnerHitsRowNumberColumnName
+	innerHitsName string
+	innerHitsSize int
+	collapseField string
+	// trackTotalHits is `track_total_hits` threshold: hits.total is accurate up to it. < 0 <=> no threshold
+	trackTotalHits int
 }
 
 func NewHits(ctx context.Context, table *clickhouse.Table, highlighter *model.Highlighter,
-	sortFieldNames []string, addSource, addScore, addVersion bool) Hits {
+	sortFieldNames []string, addSource, addFields, addScore, addVersion bool) Hits {
 
 	return Hits{ctx: ctx, table: table, highlighter: highlighter, sortFieldNames: sortFieldNames,
-		addSource: addSource, addScore: addScore, addVersion: addVersion}
+		addSource: addSource, addFields: addFields, addScore: addScore, addVersion: addVersion, trackTotalHits: model.TrackTotalHitsTrue}
+}
+
+// SetTrackTotalHits makes hits.total accurate up to `trackTotalHits` (from "track_total_hits"), and capped at it with
+// relation "gte" if there are more hits, like in Elastic. model.TrackTotalHitsTrue/False mean no threshold.
+func (query *Hits) SetTrackTotalHits(trackTotalHits int) {
+	query.trackTotalHits = trackTotalHits
+}
+
+// MakeTotal returns hits.total for `count` hits, returned by a query with `limit` (0 <=> no limit).
+// If the limit is reached, there may be more hits, so the relation is "gte" then.
+func (query Hits) MakeTotal(count, limit int) *model.Total {
+	if query.trackTotalHits >= 0 && count > query.trackTotalHits {
+		return &model.Total{Value: query.trackTotalHits, Relation: "gte"}
+	}
+	relation := "eq"
+	if limit != 0 && count == limit {
+		relation = "gte"
+	}
+	return &model.Total{Value: count, Relation: relation}
+}
+
+// SetSourceFilter makes hits' _source contain only fields matching any of `includes` (all fields, if empty),
+// and none of `excludes`.
+func (query *Hits) SetSourceFilter(includes, excludes []string) {
+	query.sourceIncludes = includes
+	query.sourceExcludes = excludes
+}
+
+// SetDocValueFormats makes hit.Fields values of these fields formatted, like "docvalue_fields": [{"field": ..., "format": ...}]
+func (query *Hits) SetDocValueFormats(formats map[string]string) {
+	query.docValueFormats = formats
+}
+
+// SetVersionColumn makes hit.Version derived from this column, the table's version field resolved by the schema
+func (query *Hits) SetVersionColumn(column string) {
+	query.versionColumn = column
+}
+
+// SetRuntimeFields makes these fields (from "runtime_mappings") returned only in hit.Fields
+func (query *Hits) SetRuntimeFields(names []string) {
+	query.runtimeFields = names
+}
+
+// SetInnerHits makes hits, collapsed by `collapseField`, have up to `size` inner hits named `name` ("collapse.inner_hits")
+func (query *Hits) SetInnerHits(collapseField, name string, size int) {
+	query.collapseField = collapseField
+	query.innerHitsName = name
+	query.innerHitsSize = size
+}
+
+// HasInnerHits returns true <=> hits are built from all rows together, as rows of inner hits are mixed with collapsed ones
+func (query Hits) HasInnerHits() bool {
+	return query.innerHitsName != ""
+}
+
+// RowsPerHit returns how many rows there are at most per a returned hit: a collapsed hit comes with rows of its inner hits
+func (query Hits) RowsPerHit() int {
+	if query.HasInnerHits() {
+		return query.innerHitsSize + 1
+	}
+	return 1
 }
 
 const (
 	defaultScore   = 1 // if we add "score" field, it's always 1
-	defaultVersion = 1 // if we  add "version" field, it's always 1
+	defaultVersion = 1 // if we add "version" field, it's 1, unless the table has a version field
+	// legacyDocumentType is `_type` of hits for old clients, the only type Elasticsearch 7 had
+	legacyDocumentType = "_doc"
 )
 
 func (query Hits) IsBucketAggregation() bool {
@@ -46,38 +132,20 @@ func (query Hits) IsBucketAggregation() bool {
 }
 
 func (query Hits) TranslateSqlResponseToJson(rows []model.QueryResultRow, level int) []model.JsonMap {
-	hits := make([]model.SearchHit, 0, len(rows))
-	for i, row := range rows {
-		hit := model.NewSearchHit(query.table.Name)
-		if query.addScore {
-			hit.Score = defaultScore
-		}
-		if query.addVersion {
-			hit.Version = defaultVersion
-		}
-		if query.addSource {
-			hit.Source = []byte(rows[i].String(query.ctx))
-		}
-		query.addAndHighlightHit(&hit, &row)
-
-		hit.ID = query.computeIdForDocument(hit, strconv.Itoa(i+1))
-		for _, fieldName := range query.sortFieldNames {
-			if val, ok := hit.Fields[fieldName]; ok {
-				hit.Sort = append(hit.Sort, elasticsearch.FormatSortValue(val[0]))
-			} else {
-				logger.WarnWithCtx(query.ctx).Msgf("field %s not found in fields", fieldName)
-			}
+	var hits []model.SearchHit
+	if query.HasInnerHits() {
+		hits = query.makeCollapsedHits(rows)
+	} else {
+		hits = make([]model.SearchHit, 0, len(rows))
+		for i, row := range rows {
+			hits = append(hits, query.MakeHit(i, row))
 		}
-		hits = append(hits, hit)
 	}
 
 	return []model.JsonMap{{
 		"hits": model.SearchHits{
-			Total: &model.Total{
-				Value:    len(rows),
-				Relation: "eq", // TODO fix in next PR
-			},
-			Hits: hits,
+			Total: query.MakeTotal(len(rows), 0),
+			Hits:  hits,
 		},
 		"shards": model.ResponseShards{
 			Total:      1,
@@ -87,24 +155,185 @@ func (query Hits) TranslateSqlResponseToJson(rows []model.QueryResultRow, level
 	}}
 }
 
+// MakeHit returns the hit for `row`, which is the `rowNr`-th row of the result (needed for pseudo-unique ids).
+// It allows building hits one by one, without having all rows in memory.
+func (query Hits) MakeHit(rowNr int, row model.QueryResultRow) model.SearchHit {
+	index := query.table.Name
+	if row.Index != "" { // rows can come from different tables, if index pattern resolved to many
+		index = row.Index
+	}
+	hit := model.NewSearchHit(index)
+	if query.table.LegacyTypes {
+		hit.Type = legacyDocumentType
+	}
+	if query.addScore {
+		hit.Score = defaultScore
+	}
+	if query.addVersion {
+		hit.Version = query.version(row)
+	}
+	if query.addSource {
+		sourceRow := query.filterSource(row)
+		hit.Source = []byte(sourceRow.String(query.ctx))
+	}
+	query.addAndHighlightHit(&hit, &row)
+
+	hit.ID = query.computeIdForDocument(hit, strconv.Itoa(rowNr+1))
+	for _, fieldName := range query.sortFieldNames {
+		if val, ok := hit.Fields[fieldName]; ok {
+			hit.Sort = append(hit.Sort, elasticsearch.FormatSortValue(val[0]))
+		} else {
+			logger.WarnWithCtx(query.ctx).Msgf("field %s not found in fields", fieldName)
+		}
+	}
+	// after sort values, as they're computed from unformatted fields
+	for fieldName, format := range query.docValueFormats {
+		if values, ok := hit.Fields[fieldName]; ok {
+			hit.Fields[fieldName] = query.formatDocValues(values, format)
+		}
+	}
+	if !query.addFields {
+		hit.Fields = nil
+	}
+	return hit
+}
+
+// makeCollapsedHits returns collapsed hits (rows with "row_number" 1, in order of rows), each of them with inner hits:
+// rows of its group with "inner_hits_row_number" up to inner hits' size, ordered by it.
+func (query Hits) makeCollapsedHits(rows []model.QueryResultRow) []model.SearchHit {
+	type innerHit struct {
+		position int64
+		hit      model.SearchHit
+	}
+	type group struct {
+		innerHits []innerHit
+		total     int64
+	}
+	groups := make(map[string]*group)
+	var collapsedRows []model.QueryResultRow
+	var collapsedKeys []any
+	for i, row := range rows {
+		var key any
+		var rowNumber, innerRowNumber, total int64
+		hitRow := model.QueryResultRow{Index: row.Index, Cols: make([]model.QueryResultCol, 0, len(row.Cols))}
+		for _, col := range row.Cols {
+			switch col.ColName {
+			case model.RowNumberColumnName:
+				rowNumber, _ = util.ExtractInt64Maybe(col.Value)
+			case model.InnerHitsRowNumberColumnName:
+				innerRowNumber, _ = util.ExtractInt64Maybe(col.Value)
+			case model.InnerHitsTotalColumnName:
+				total, _ = util.ExtractInt64Maybe(col.Value)
+			case model.InnerHitsCollapseKeyColumnName:
+				key = col.ExtractValue(query.ctx)
+			default:
+				hitRow.Cols = append(hitRow.Cols, col)
+			}
+		}
+		groupKey := fmt.Sprintf("%v", key)
+		if _, exists := groups[groupKey]; !exists {
+			groups[groupKey] = &group{total: total}
+		}
+		if rowNumber == 1 {
+			collapsedRows = append(collapsedRows, hitRow)
+			collapsedKeys = append(collapsedKeys, key)
+		}
+		if innerRowNumber <= int64(query.innerHitsSize) {
+			groups[groupKey].innerHits = append(groups[groupKey].innerHits, innerHit{position: innerRowNumber, hit: query.MakeHit(i, hitRow)})
+		}
+	}
+
+	hits := make([]model.SearchHit, 0, len(collapsedRows))
+	for i, row := range collapsedRows {
+		group := groups[fmt.Sprintf("%v", collapsedKeys[i])]
+		sort.SliceStable(group.innerHits, func(a, b int) bool { return group.innerHits[a].position < group.innerHits[b].position })
+		innerHits := make([]model.SearchHit, 0, len(group.innerHits))
+		for _, innerHit := range group.innerHits {
+			innerHits = append(innerHits, innerHit.hit)
+		}
+		hit := query.MakeHit(i, row)
+		// like in Elastic, collapsed hits have the collapse field in fields
+		if hit.Fields == nil {
+			hit.Fields = make(map[string][]interface{})
+		}
+		hit.Fields[query.collapseField] = []interface{}{collapsedKeys[i]}
+		hit.InnerHits = map[string]model.InnerHits{query.innerHitsName: {Hits: model.SearchHits{
+			Total: &model.Total{Value: int(group.total), Relation: "eq"},
+			Hits:  innerHits,
+		}}}
+		hits = append(hits, hit)
+	}
+	return hits
+}
+
+// filterSource returns `row` with only these columns, which should be in hit's _source
+func (query Hits) filterSource(row model.QueryResultRow) model.QueryResultRow {
+	if len(query.sourceIncludes) == 0 && len(query.sourceExcludes) == 0 && len(query.runtimeFields) == 0 {
+		return row
+	}
+	filtered := model.QueryResultRow{Index: row.Index, Cols: make([]model.QueryResultCol, 0, len(row.Cols))}
+	for _, col := range row.Cols {
+		included := len(query.sourceIncludes) == 0 || sourceFieldMatchesAny(col.ColName, query.sourceIncludes)
+		if included && !sourceFieldMatchesAny(col.ColName, query.sourceExcludes) && !slices.Contains(query.runtimeFields, col.ColName) {
+			filtered.Cols = append(filtered.Cols, col)
+		}
+	}
+	return filtered
+}
+
+// sourceFieldMatchesAny returns true if `fieldName` matches any of `patterns` (which may contain '*'),
+// or is a subfield of a matching object, e.g. "user.name" matches "user".
+func sourceFieldMatchesAny(fieldName string, patterns []string) bool {
+	for _, pattern := range patterns {
+		patternRegexp := index.TableNamePatternRegexp(pattern)
+		if patternRegexp.MatchString(fieldName) {
+			return true
+		}
+		for i, char := range fieldName {
+			if char == '.' && patternRegexp.MatchString(fieldName[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (query Hits) addAndHighlightHit(hit *model.SearchHit, resultRow *model.QueryResultRow) {
 	for _, col := range resultRow.Cols {
 		if col.Value == nil {
 			continue // We don't return empty value
 		}
 		columnName := col.ColName
-		hit.Fields[columnName] = []interface{}{col.Value}
+		values := []interface{}{col.Value}
+		if query.table.IsArray(columnName) {
+			values = arrayElements(col.Value)
+			if len(values) == 0 {
+				continue // empty arrays aren't returned either
+			}
+		}
+		hit.Fields[columnName] = values
+		if query.hasIgnoredValue(columnName, values) {
+			hit.Ignored = append(hit.Ignored, columnName)
+		}
 		if query.highlighter.ShouldHighlight(columnName) {
 			// check if we have a string here and if so, highlight it
-			switch valueAsString := col.Value.(type) {
-			case string:
-				hit.Highlight[columnName] = query.highlighter.HighlightValue(columnName, valueAsString)
-			case *string:
-				if valueAsString != nil {
-					hit.Highlight[columnName] = query.highlighter.HighlightValue(columnName, *valueAsString)
+			highlight := func(value string) {
+				hit.Highlight[columnName] = append(hit.Highlight[columnName], query.highlighter.HighlightValue(columnName, value)...)
+				if hit.Highlight[columnName] == nil {
+					hit.Highlight[columnName] = []string{}
+				}
+			}
+			for _, value := range values {
+				switch valueAsString := value.(type) {
+				case string:
+					highlight(valueAsString)
+				case *string:
+					if valueAsString != nil {
+						highlight(*valueAsString)
+					}
+				default:
+					logger.WarnWithCtx(query.ctx).Msgf("unknown type for hit highlighting: %T, value: %v", value, value)
 				}
-			default:
-				logger.WarnWithCtx(query.ctx).Msgf("unknown type for hit highlighting: %T, value: %v", col.Value, col.Value)
 			}
 		}
 	}
@@ -117,6 +346,106 @@ func (query Hits) addAndHighlightHit(hit *model.SearchHit, resultRow *model.Quer
 	}
 }
 
+// hasIgnoredValue returns true <=> any of `values` is longer than `ignore_above` of `columnName`
+func (query Hits) hasIgnoredValue(columnName string, values []interface{}) bool {
+	for _, value := range values {
+		switch valueTyped := value.(type) {
+		case string:
+			if query.table.IsIgnoredValue(columnName, valueTyped) {
+				return true
+			}
+		case *string:
+			if valueTyped != nil && query.table.IsIgnoredValue(columnName, *valueTyped) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// arrayElements returns elements of an Array(...) column's value, which Elastic returns as separate values in `fields`.
+// Null elements are skipped, just like null columns.
+func arrayElements(value any) []interface{} {
+	array := reflect.ValueOf(value)
+	if array.Kind() != reflect.Slice && array.Kind() != reflect.Array {
+		return []interface{}{value}
+	}
+	elements := make([]interface{}, 0, array.Len())
+	for i := 0; i < array.Len(); i++ {
+		element := array.Index(i)
+		if (element.Kind() == reflect.Pointer || element.Kind() == reflect.Interface) && element.IsNil() {
+			continue
+		}
+		elements = append(elements, element.Interface())
+	}
+	return elements
+}
+
+// formatDocValues formats `values` with a "docvalue_fields" format. Only date formats are supported,
+// other values are returned unchanged.
+func (query Hits) formatDocValues(values []interface{}, format string) []interface{} {
+	formatted := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		var timestamp time.Time
+		switch valueTyped := value.(type) {
+		case time.Time:
+			timestamp = valueTyped
+		case *time.Time:
+			if valueTyped == nil {
+				continue // NULL, we don't return empty values
+			}
+			timestamp = *valueTyped
+		default:
+			formatted = append(formatted, value)
+			continue
+		}
+		switch format {
+		case "epoch_millis":
+			formatted = append(formatted, strconv.FormatInt(timestamp.UnixMilli(), 10))
+		case "epoch_second":
+			formatted = append(formatted, strconv.FormatInt(timestamp.Unix(), 10))
+		case "date_time", "strict_date_time", "date_optional_time", "strict_date_optional_time":
+			formatted = append(formatted, timestamp.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+		case "date", "strict_date":
+			formatted = append(formatted, timestamp.UTC().Format(time.DateOnly))
+		case "use_field_mapping":
+			formatted = append(formatted, value)
+		default:
+			logger.WarnWithCtx(query.ctx).Msgf("unsupported docvalue_fields format: %s, field value: %v. Not formatting", format, value)
+			formatted = append(formatted, value)
+		}
+	}
+	return formatted
+}
+
+// version returns synthetic _version of `row`: epoch millis of its version column (a last-modified timestamp),
+// so it grows when the document is updated. It's the field's value itself, if it's a number.
+func (query Hits) version(row model.QueryResultRow) int {
+	if query.versionColumn == "" {
+		return defaultVersion
+	}
+	for _, col := range row.Cols {
+		if col.ColName != query.versionColumn {
+			continue
+		}
+		if value := reflect.ValueOf(col.Value); !value.IsValid() || (value.Kind() == reflect.Pointer && value.IsNil()) {
+			return defaultVersion
+		}
+		switch value := col.Value.(type) {
+		case time.Time:
+			return int(value.UnixMilli())
+		case *time.Time:
+			return int(value.UnixMilli())
+		default:
+			if number, ok := util.ExtractInt64Maybe(value); ok {
+				return int(number)
+			}
+			logger.WarnWithCtx(query.ctx).Msgf("version field %s has unsupported value: %v (type %T)", col.ColName, col.Value, col.Value)
+		}
+	}
+	return defaultVersion
+}
+
 func (query Hits) computeIdForDocument(doc model.SearchHit, defaultID string) string {
 	tsFieldName, err := query.table.GetTimestampFieldName()
 	if err != nil {
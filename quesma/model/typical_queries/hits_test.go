@@ -0,0 +1,20 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package typical_queries
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFormatDocValues(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	var nullTimestamp *time.Time
+	query := Hits{ctx: context.Background()}
+
+	assert.Equal(t, []interface{}{"1705314600000", "1705314600000", "not a date"},
+		query.formatDocValues([]interface{}{timestamp, &timestamp, "not a date"}, "epoch_millis"))
+	assert.Equal(t, []interface{}{"2024-01-15"}, query.formatDocValues([]interface{}{nullTimestamp, timestamp}, "date"))
+}
@@ -2,6 +2,8 @@
 // SPDX-License-Identifier: Elastic-2.0
 package model
 
+import "slices"
+
 // TODO OKAY THIS NEEDS TO BE FIXED FOR THE NEW WHERE STATEMENT
 type usedColumns struct{}
 
@@ -119,5 +121,13 @@ func (v *usedColumns) VisitParenExpr(p ParenExpr) interface{} {
 }
 
 func (v *usedColumns) VisitLambdaExpr(e LambdaExpr) interface{} {
-	return NewLambdaExpr(e.Args, e.Body.Accept(v).(Expr))
+	res := make([]ColumnRef, 0)
+	if bodyColumns, ok := e.Body.Accept(v).([]ColumnRef); ok {
+		for _, column := range bodyColumns {
+			if !slices.Contains(e.Args, column.ColumnName) { // lambda's arguments aren't columns
+				res = append(res, column)
+			}
+		}
+	}
+	return res
 }
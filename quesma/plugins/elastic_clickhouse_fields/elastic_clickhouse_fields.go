@@ -152,6 +152,19 @@ func (v *exprColumnNameReplaceVisitor) VisitWindowFunction(f model.WindowFunctio
 
 func (v *exprColumnNameReplaceVisitor) VisitSelectCommand(query model.SelectCommand) interface{} {
 
+	// subquery, e.g. (SELECT ...)
+	if query.FromClause != nil {
+		query.FromClause = query.FromClause.Accept(v).(model.Expr)
+	}
+
+	for i, expr := range query.ArrayJoin {
+		query.ArrayJoin[i] = expr.Accept(v).(model.Expr)
+	}
+
+	if query.PreWhere != nil {
+		query.PreWhere = query.PreWhere.Accept(v).(model.Expr)
+	}
+
 	if query.WhereClause != nil {
 		query.WhereClause = query.WhereClause.Accept(v).(model.Expr)
 	}
@@ -160,6 +173,10 @@ func (v *exprColumnNameReplaceVisitor) VisitSelectCommand(query model.SelectComm
 		query.GroupBy[i] = group.Accept(v).(model.Expr)
 	}
 
+	if query.Having != nil {
+		query.Having = query.Having.Accept(v).(model.Expr)
+	}
+
 	for i, column := range query.Columns {
 		query.Columns[i] = column.Accept(v).(model.Expr)
 	}
@@ -201,11 +218,12 @@ func (t *queryTransformer) Transform(queries []*model.Query) ([]*model.Query, er
 //
 
 type ingestTransformer struct {
-	separator string
+	separator       string
+	collisionPolicy string
 }
 
 func (t *ingestTransformer) Transform(document types.JSON) (types.JSON, error) {
-	return jsonprocessor.FlattenMap(document, t.separator), nil
+	return jsonprocessor.FlattenMapWithCollisionPolicy(document, t.separator, t.collisionPolicy)
 }
 
 //
@@ -230,7 +248,7 @@ func (p *Dot2DoubleColons) matches(table string) bool {
 
 func (p *Dot2DoubleColons) ApplyIngestTransformers(table string, cfg config.QuesmaConfiguration, transformers []plugins.IngestTransformer) []plugins.IngestTransformer {
 	if p.matches(table) {
-		transformers = append(transformers, &ingestTransformer{separator: doubleColons})
+		transformers = append(transformers, &ingestTransformer{separator: doubleColons, collisionPolicy: cfg.GetFlattenCollisionPolicy()})
 	}
 	return transformers
 }
@@ -269,7 +287,7 @@ func (*Dot2DoubleColons2Dot) IngestTransformer() plugins.IngestTransformer {
 
 func (p *Dot2DoubleColons2Dot) ApplyIngestTransformers(table string, cfg config.QuesmaConfiguration, transformers []plugins.IngestTransformer) []plugins.IngestTransformer {
 	if p.matches(table) {
-		transformers = append(transformers, &ingestTransformer{separator: doubleColons})
+		transformers = append(transformers, &ingestTransformer{separator: doubleColons, collisionPolicy: cfg.GetFlattenCollisionPolicy()})
 	}
 	return transformers
 }
@@ -312,7 +330,7 @@ func (p *Dot2DoubleUnderscores2Dot) matches(table string) bool {
 
 func (p *Dot2DoubleUnderscores2Dot) ApplyIngestTransformers(table string, cfg config.QuesmaConfiguration, transformers []plugins.IngestTransformer) []plugins.IngestTransformer {
 	if p.matches(table) {
-		transformers = append(transformers, &ingestTransformer{separator: doubleColons})
+		transformers = append(transformers, &ingestTransformer{separator: doubleColons, collisionPolicy: cfg.GetFlattenCollisionPolicy()})
 	}
 	return transformers
 }
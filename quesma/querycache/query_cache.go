@@ -0,0 +1,134 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package querycache
+
+import (
+	"container/list"
+	"quesma/model"
+	"quesma/quesma/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTTL     = 30 * time.Second
+	defaultMaxSize = 1000
+)
+
+// QueryCache is an LRU cache of results of SQL queries, keyed by table name and the whole SQL.
+// It's useful e.g. for Kibana dashboards with auto-refresh, which send the same queries over and over again.
+type QueryCache struct {
+	mutex         sync.Mutex
+	ttl           time.Duration
+	maxSize       int
+	cacheRelative bool
+	entries       map[string]*list.Element
+	lru           *list.List // front = most recently used
+	hits          atomic.Int64
+	misses        atomic.Int64
+	now           func() time.Time // can be overridden in tests
+}
+
+type cacheEntry struct {
+	key   string
+	rows  []model.QueryResultRow
+	added time.Time
+}
+
+type Stats struct {
+	Hits   int64
+	Misses int64
+	Size   int
+}
+
+func NewQueryCache(cfg config.QueryCacheConfiguration) *QueryCache {
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return &QueryCache{ttl: ttl, maxSize: maxSize, cacheRelative: cfg.CacheRelative,
+		entries: make(map[string]*list.Element), lru: list.New(), now: time.Now}
+}
+
+// IsCacheable returns false for queries, whose results depend on the time they're run at (unless we're configured to cache them anyway)
+func (c *QueryCache) IsCacheable(sql string) bool {
+	return c.cacheRelative || !strings.Contains(strings.ToLower(sql), "now()")
+}
+
+// Get returns a copy of cached rows, so callers can freely modify them
+func (c *QueryCache) Get(tableName, sql string) (rows []model.QueryResultRow, found bool) {
+	key := cacheKey(tableName, sql)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, found := c.entries[key]
+	if found {
+		entry := element.Value.(*cacheEntry)
+		if c.now().Sub(entry.added) > c.ttl {
+			c.removeElement(element)
+			found = false
+		} else {
+			c.lru.MoveToFront(element)
+			rows = copyRows(entry.rows)
+		}
+	}
+
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return rows, found
+}
+
+func (c *QueryCache) Put(tableName, sql string, rows []model.QueryResultRow) {
+	key := cacheKey(tableName, sql)
+	entry := &cacheEntry{key: key, rows: copyRows(rows), added: c.now()}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, found := c.entries[key]; found {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *QueryCache) Stats() Stats {
+	c.mutex.Lock()
+	size := c.lru.Len()
+	c.mutex.Unlock()
+	return Stats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: size}
+}
+
+func (c *QueryCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+func cacheKey(tableName, sql string) string {
+	return tableName + "\x00" + sql
+}
+
+func copyRows(rows []model.QueryResultRow) []model.QueryResultRow {
+	if rows == nil {
+		return nil
+	}
+	copied := make([]model.QueryResultRow, len(rows))
+	for i := range rows {
+		copied[i] = rows[i].Copy()
+	}
+	return copied
+}
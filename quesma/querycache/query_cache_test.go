@@ -0,0 +1,85 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package querycache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"quesma/model"
+	"quesma/quesma/config"
+	"testing"
+	"time"
+)
+
+const sql = `SELECT "message" FROM "logs" LIMIT 10`
+
+var rows = []model.QueryResultRow{{Cols: []model.QueryResultCol{model.NewQueryResultCol("message", "hello")}}}
+
+func TestQueryCacheHitAndMiss(t *testing.T) {
+	cache := NewQueryCache(config.QueryCacheConfiguration{Enabled: true})
+
+	_, found := cache.Get("logs", sql)
+	assert.False(t, found)
+
+	cache.Put("logs", sql, rows)
+	cached, found := cache.Get("logs", sql)
+	assert.True(t, found)
+	assert.Equal(t, rows, cached)
+
+	_, found = cache.Get("other_table", sql)
+	assert.False(t, found)
+
+	assert.Equal(t, Stats{Hits: 1, Misses: 2, Size: 1}, cache.Stats())
+}
+
+func TestQueryCacheReturnsCopy(t *testing.T) {
+	cache := NewQueryCache(config.QueryCacheConfiguration{Enabled: true})
+	cache.Put("logs", sql, rows)
+
+	cached, _ := cache.Get("logs", sql)
+	cached[0].Cols[0].Value = "modified"
+
+	cached, _ = cache.Get("logs", sql)
+	assert.Equal(t, "hello", cached[0].Cols[0].Value)
+}
+
+func TestQueryCacheExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewQueryCache(config.QueryCacheConfiguration{Enabled: true, TTL: time.Minute})
+	cache.now = func() time.Time { return now }
+
+	cache.Put("logs", sql, rows)
+	now = now.Add(59 * time.Second)
+	_, found := cache.Get("logs", sql)
+	assert.True(t, found)
+
+	now = now.Add(2 * time.Second)
+	_, found = cache.Get("logs", sql)
+	assert.False(t, found)
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Size: 0}, cache.Stats())
+}
+
+func TestQueryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewQueryCache(config.QueryCacheConfiguration{Enabled: true, MaxSize: 2})
+	cache.Put("logs", "sql1", rows)
+	cache.Put("logs", "sql2", rows)
+	_, _ = cache.Get("logs", "sql1")
+	cache.Put("logs", "sql3", rows)
+
+	_, found := cache.Get("logs", "sql2")
+	assert.False(t, found)
+	_, found = cache.Get("logs", "sql1")
+	assert.True(t, found)
+	_, found = cache.Get("logs", "sql3")
+	assert.True(t, found)
+}
+
+func TestQueryCacheIsCacheable(t *testing.T) {
+	relativeSql := `SELECT count() FROM "logs" WHERE "@timestamp">=now()-INTERVAL 15 minute`
+
+	cache := NewQueryCache(config.QueryCacheConfiguration{Enabled: true})
+	assert.True(t, cache.IsCacheable(sql))
+	assert.False(t, cache.IsCacheable(relativeSql))
+
+	cache = NewQueryCache(config.QueryCacheConfiguration{Enabled: true, CacheRelative: true})
+	assert.True(t, cache.IsCacheable(relativeSql))
+}
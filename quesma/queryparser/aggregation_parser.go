@@ -4,12 +4,14 @@ package queryparser
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"quesma/clickhouse"
 	"quesma/logger"
 	"quesma/model"
 	"quesma/model/bucket_aggregations"
 	"quesma/model/metrics_aggregations"
+	"quesma/quesma/errors"
 
 	"quesma/quesma/types"
 	"quesma/util"
@@ -94,6 +96,9 @@ func isColumnExist(columns []model.Expr, columnName string) bool {
 // updateInnerQueryColumns adds columns that exists in where clause and are missing
 // in select clause
 func updateInnerQueryColumns(query model.SelectCommand, whereClause model.Expr) model.SelectCommand {
+	if whereClause == nil {
+		return query
+	}
 	whereClauseVisitor := WhereClauseColumnVisitor{ExprVisitor: model.NoOpVisitor{}}
 	whereClause.Accept(&whereClauseVisitor)
 	for _, columnName := range whereClauseVisitor.ColumnNames {
@@ -139,10 +144,56 @@ func (b *aggrQueryBuilder) buildCountAggregation(metadata model.JsonMap) *model.
 func (b *aggrQueryBuilder) buildBucketAggregation(metadata model.JsonMap) *model.Query {
 	query := b.buildAggregationCommon(metadata)
 
+	if terms, ok := query.Type.(bucket_aggregations.Terms); ok && terms.IsSignificant() {
+		addSignificantTermsCounts(query)
+		return query
+	}
 	query.SelectCommand.Columns = append(query.SelectCommand.Columns, model.NewCountFunc())
+	if histogram, ok := query.Type.(bucket_aggregations.VariableWidthHistogram); ok {
+		query.SelectCommand.Columns = append(query.SelectCommand.Columns, histogram.StatsColumns()...)
+	}
+	if _, ok := query.Type.(bucket_aggregations.Terms); ok && query.SelectCommand.Limit > 0 {
+		// window functions are computed before LIMIT, so we also know how many documents are in terms we don't return
+		totalDocCount := model.NewWindowFunction("sum", []model.Expr{model.NewCountFunc()}, nil, model.OrderByExpr{})
+		query.SelectCommand.Columns = append(query.SelectCommand.Columns, model.NewAliasedExpr(totalDocCount, bucket_aggregations.TotalDocCountColumnName))
+	}
 	return query
 }
 
+// addSignificantTermsCounts makes significant_terms count every term both in the foreground (documents matching the query)
+// and in the background (the whole table), together with sizes of both sets and the term's score.
+// We don't filter with WHERE at all, foreground is counted with countIf. If terms are limited to top `size`,
+// they're ordered by score, so that the database also chooses the most significant ones.
+func addSignificantTermsCounts(query *model.Query) {
+	foregroundCount := model.NewCountFunc()
+	if query.SelectCommand.WhereClause != nil {
+		foregroundCount = model.NewFunction("countIf", query.SelectCommand.WhereClause)
+		query.SelectCommand.WhereClause = nil
+	}
+	parentGroupBy := query.SelectCommand.GroupBy[:len(query.SelectCommand.GroupBy)-1]
+	supersetSize := model.NewWindowFunction("sum", []model.Expr{model.NewCountFunc()}, parentGroupBy, model.OrderByExpr{})
+	subsetSize := model.NewWindowFunction("sum", []model.Expr{foregroundCount}, parentGroupBy, model.OrderByExpr{})
+	score := jlhScore(foregroundCount, subsetSize, model.NewCountFunc(), supersetSize)
+	query.SelectCommand.Columns = append(query.SelectCommand.Columns,
+		model.NewCountFunc(), supersetSize, subsetSize, foregroundCount, score)
+	for i, orderBy := range query.SelectCommand.OrderBy {
+		if model.AsString(orderBy) == model.AsString(model.NewSortByCountColumn(orderBy.Direction)) {
+			query.SelectCommand.OrderBy[i] = model.NewOrderByExpr([]model.Expr{score}, orderBy.Direction)
+		}
+	}
+}
+
+// jlhScore returns the default significance heuristic of Elastic's significant_terms.
+// It's (foreground% - background%) * (foreground% / background%), and 0 if the term isn't more frequent in the foreground
+// (then the product isn't positive).
+func jlhScore(foregroundCount, subsetSize, backgroundCount, supersetSize model.Expr) model.Expr {
+	foregroundPercentage := model.NewFunction("divide", foregroundCount, subsetSize)
+	backgroundPercentage := model.NewFunction("divide", backgroundCount, supersetSize)
+	return model.NewFunction("greatest", model.NewFunction("multiply",
+		model.NewFunction("minus", foregroundPercentage, backgroundPercentage),
+		model.NewFunction("divide", foregroundPercentage, backgroundPercentage)), model.NewLiteral(0))
+}
+
 func (b *aggrQueryBuilder) buildMetricsAggregation(metricsAggr metricsAggregation, metadata model.JsonMap) *model.Query {
 	getFirstExpression := func() model.Expr {
 		if len(metricsAggr.Fields) > 0 {
@@ -170,6 +221,12 @@ func (b *aggrQueryBuilder) buildMetricsAggregation(metricsAggr metricsAggregatio
 			))
 
 		}
+	case "boxplot":
+		expr := getFirstExpression()
+		query.SelectCommand.Columns = append(query.SelectCommand.Columns,
+			model.NewFunction("minOrNull", expr),
+			model.NewFunction("maxOrNull", expr),
+			model.MultiFunctionExpr{Name: "quantiles", Args: []model.Expr{model.NewLiteral(metrics_aggregations.BoxplotQuartiles), expr}})
 	case "cardinality":
 		query.SelectCommand.Columns = append(query.SelectCommand.Columns, model.NewCountFunc(model.NewDistinctExpr(getFirstExpression())))
 
@@ -211,23 +268,35 @@ func (b *aggrQueryBuilder) buildMetricsAggregation(metricsAggr metricsAggregatio
 		// This appending of `metricsAggr.SortBy` and having it duplicated in SELECT block
 		// is a way to pass value we're sorting by to the query.SelectCommand.result. In the future we might add SQL aliasing support, e.g. SELECT x AS 'sort_by' FROM ...
 		if len(b.Query.SelectCommand.GroupBy) > 0 {
+			// We number rows in each bucket by the sort field, and take `size` first ones.
+			// We group by row number too, so each row is a separate group, and ordFunc (over a single value) just returns it.
 			var ordFunc string
 			switch metricsAggr.Order {
-			case "asc":
-				ordFunc = `maxOrNull`
 			case "desc":
 				ordFunc = `minOrNull`
+			default:
+				ordFunc = `maxOrNull`
 			}
 
-			innerFields := append(metricsAggr.Fields, model.NewColumnRef(metricsAggr.SortBy))
+			innerFields := slices.Clone(metricsAggr.Fields)
+			if metricsAggr.sortByExists() {
+				innerFields = append(innerFields, model.NewColumnRef(metricsAggr.SortBy))
+			}
 			for _, field := range innerFields {
 				fieldName, _ := strconv.Unquote(model.AsString(field))
 				query.SelectCommand.Columns = append(query.SelectCommand.Columns,
 					model.NewAliasedExpr(model.NewFunction(ordFunc, field), fmt.Sprintf("windowed_%s", fieldName)))
 			}
 
-			innerFieldsAsSelect := make([]model.Expr, len(innerFields))
-			copy(innerFieldsAsSelect, innerFields)
+			innerFieldsAsSelect := slices.Clone(innerFields)
+			// outer query groups by parent buckets, so inner query needs to return columns they're computed from
+			for _, groupBy := range b.Query.SelectCommand.GroupBy {
+				for _, column := range model.GetUsedColumns(groupBy) {
+					if !isColumnExist(innerFieldsAsSelect, column.ColumnName) {
+						innerFieldsAsSelect = append(innerFieldsAsSelect, column)
+					}
+				}
+			}
 			query.SelectCommand.FromClause = query.NewSelectExprWithRowNumber(
 				innerFieldsAsSelect, b.Query.SelectCommand.GroupBy, b.whereBuilder.WhereClause,
 				metricsAggr.SortBy, strings.ToLower(metricsAggr.Order) == "desc",
@@ -244,6 +313,8 @@ func (b *aggrQueryBuilder) buildMetricsAggregation(metricsAggr metricsAggregatio
 
 			query.SelectCommand.WhereClause = model.And([]model.Expr{query.SelectCommand.WhereClause,
 				model.NewInfixExpr(model.NewColumnRef(model.RowNumberColumnName), "<=", model.NewLiteral(strconv.Itoa(metricsAggr.Size)))})
+			query.SelectCommand.GroupBy = append(query.SelectCommand.GroupBy, model.NewColumnRef(model.RowNumberColumnName))
+			query.SelectCommand.OrderBy = append(query.SelectCommand.OrderBy, model.NewSortColumn(model.RowNumberColumnName, model.AscOrder))
 		} else {
 			innerFieldsAsSelect := make([]model.Expr, len(metricsAggr.Fields))
 			copy(innerFieldsAsSelect, metricsAggr.Fields)
@@ -331,6 +402,8 @@ func (b *aggrQueryBuilder) buildMetricsAggregation(metricsAggr metricsAggregatio
 		query.Type = metrics_aggregations.NewExtendedStats(b.ctx, metricsAggr.sigma)
 	case "cardinality":
 		query.Type = metrics_aggregations.NewCardinality(b.ctx)
+	case "boxplot":
+		query.Type = metrics_aggregations.NewBoxplot(b.ctx)
 	case "quantile":
 		query.Type = metrics_aggregations.NewQuantile(b.ctx, metricsAggr.Keyed, metricsAggr.FieldType)
 	case "top_hits":
@@ -367,8 +440,7 @@ func (cw *ClickhouseQueryTranslator) ParseAggregationJson(body types.JSON) ([]*m
 
 	if aggsRaw, ok := queryAsMap["aggs"]; ok {
 		if aggs, okType := aggsRaw.(QueryMap); okType {
-			err := cw.parseAggregationNames(&currentAggr, aggs, &aggregations)
-			if err != nil {
+			if err := cw.parseAggregationNames(&currentAggr, aggs, &aggregations); err != nil {
 				return nil, err
 			}
 		} else {
@@ -394,15 +466,23 @@ func (cw *ClickhouseQueryTranslator) ParseAggregationJson(body types.JSON) ([]*m
 // Notice that on 0, 2, ..., level of nesting we have "aggs" key or aggregation type.
 // On 1, 3, ... level of nesting we have names of aggregations, which can be any arbitrary strings.
 // This function is called on those 1, 3, ... levels, and parses and saves those aggregation names.
+//
+// If some aggregation fails to parse (e.g. it's unsupported), we skip only it (with all its subaggregations),
+// so results of all other aggregations are still returned, and this one is just absent in the response.
 
-func (cw *ClickhouseQueryTranslator) parseAggregationNames(currentAggr *aggrQueryBuilder, aggs QueryMap, resultQueries *[]*model.Query) (err error) {
+// Aggregations we can't translate are skipped, only invalid ones (see invalidAggregationError) or with too many buckets fail the whole request.
+func (cw *ClickhouseQueryTranslator) parseAggregationNames(currentAggr *aggrQueryBuilder, aggs QueryMap, resultQueries *[]*model.Query) error {
 	for aggrName, aggrDict := range aggs {
 		aggregators := currentAggr.Aggregators
 		currentAggr.Aggregators = append(aggregators, model.NewAggregator(aggrName))
 		if subAggregation, ok := aggrDict.(QueryMap); ok {
-			err = cw.parseAggregation(currentAggr, subAggregation, resultQueries)
-			if err != nil {
-				return err
+			resultQueriesNrBefore := len(*resultQueries)
+			if err := cw.parseAggregation(currentAggr, subAggregation, resultQueries); err != nil {
+				if errors.Is(err, quesma_errors.ErrCouldNotParseRequest()) || errors.Is(err, quesma_errors.ErrTooManyBuckets()) {
+					return err
+				}
+				logger.WarnWithCtx(cw.Ctx).Err(err).Msgf("skipping aggregation %s", aggrName)
+				*resultQueries = (*resultQueries)[:resultQueriesNrBefore]
 			}
 		} else {
 			logger.ErrorWithCtxAndReason(cw.Ctx, logger.ReasonUnsupportedQuery("unexpected_type")).
@@ -413,6 +493,12 @@ func (cw *ClickhouseQueryTranslator) parseAggregationNames(currentAggr *aggrQuer
 	return nil
 }
 
+// invalidAggregationError returns error of an aggregation, which Elastic rejects too (e.g. with invalid parameters),
+// so the request fails with 400, instead of skipping the aggregation.
+func invalidAggregationError(format string, args ...any) error {
+	return fmt.Errorf("%w: %v", quesma_errors.ErrCouldNotParseRequest(), fmt.Errorf(format, args...))
+}
+
 // Builds aggregations recursively. Seems to be working on all examples so far,
 // even though it's a pretty simple algorithm.
 // When making changes, look at the order in which we parse fields, it is very important for correctness.
@@ -465,7 +551,8 @@ func (cw *ClickhouseQueryTranslator) parseAggregation(prevAggr *aggrQueryBuilder
 
 	// 3. Now process filter(s) first, because they apply to everything else on the same level or below.
 	// Also filter introduces count to current level.
-	if filterRaw, ok := queryMap["filter"]; ok {
+	filterRaw, isFilter := queryMap["filter"]
+	if isFilter {
 		if filter, ok := filterRaw.(QueryMap); ok {
 			currentAggr.Type = metrics_aggregations.NewCount(cw.Ctx)
 			currentAggr.whereBuilder = model.CombineWheres(cw.Ctx, currentAggr.whereBuilder, cw.parseQueryMap(filter))
@@ -489,10 +576,27 @@ func (cw *ClickhouseQueryTranslator) parseAggregation(prevAggr *aggrQueryBuilder
 		}
 	}
 
+	// Nothing recognized on this level => the whole branch is unsupported, we don't descend into its subaggregations.
+	if !isPipelineAggregation && !isFilter && !bucketAggrPresent {
+		unsupportedTypes := make([]string, 0, len(queryMap))
+		for k, v := range queryMap {
+			if k != "aggs" {
+				logger.ErrorWithCtxAndReason(cw.Ctx, logger.ReasonUnsupportedQuery(k)).
+					Msgf("unexpected type of subaggregation: (%v: %v), value type: %T. Skipping", k, v, v)
+				unsupportedTypes = append(unsupportedTypes, k)
+			}
+		}
+		if len(unsupportedTypes) > 0 {
+			return fmt.Errorf("unsupported aggregation type(s): %v", unsupportedTypes)
+		}
+	}
+
 	// process "range" with subaggregations
 	Range, isRange := currentAggr.Type.(bucket_aggregations.Range)
 	if isRange {
-		cw.processRangeAggregation(&currentAggr, Range, queryMap, resultQueries, metadata)
+		if err := cw.processRangeAggregation(&currentAggr, Range, queryMap, resultQueries, metadata); err != nil {
+			return err
+		}
 	}
 
 	// TODO what happens if there's all: filters, range, and subaggregations at current level?
@@ -502,13 +606,14 @@ func (cw *ClickhouseQueryTranslator) parseAggregation(prevAggr *aggrQueryBuilder
 
 	filters, isFilters := currentAggr.Type.(bucket_aggregations.Filters)
 	if isFilters {
-		cw.processFiltersAggregation(&currentAggr, filters, queryMap, resultQueries)
+		if err := cw.processFiltersAggregation(&currentAggr, filters, queryMap, resultQueries); err != nil {
+			return err
+		}
 	}
 
 	aggsHandledSeparately := isRange || isFilters
 	if aggs, ok := queryMap["aggs"]; ok && !aggsHandledSeparately {
-		err = cw.parseAggregationNames(&currentAggr, aggs.(QueryMap), resultQueries)
-		if err != nil {
+		if err := cw.parseAggregationNames(&currentAggr, aggs.(QueryMap), resultQueries); err != nil {
 			return err
 		}
 	}
@@ -537,7 +642,7 @@ func (cw *ClickhouseQueryTranslator) tryMetricsAggregation(queryMap QueryMap) (m
 	// full list: https://www.elastic.co/guide/en/elasticsearch/reference/current/search-Aggregations-metrics.html
 	// shouldn't be hard to handle others, if necessary
 
-	metricsAggregations := []string{"sum", "avg", "min", "max", "cardinality", "value_count", "stats", "geo_centroid"}
+	metricsAggregations := []string{"sum", "avg", "min", "max", "cardinality", "value_count", "stats", "geo_centroid", "boxplot"}
 	for k, v := range queryMap {
 		if slices.Contains(metricsAggregations, k) {
 			field, isFromScript := cw.parseFieldFieldMaybeScript(v, k)
@@ -705,18 +810,35 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 			interval = 1.0
 			logger.ErrorWithCtx(cw.Ctx).Msgf("unexpected type of interval: %T, value: %v", intervalTyped, intervalTyped)
 		}
+		if interval <= 0 {
+			return false, 0, invalidAggregationError("histogram interval must be positive, got %v", interval)
+		}
+		offset := cw.parseFloatField(histogram, "offset", 0)
 		minDocCount := cw.parseMinDocCount(histogram)
-		currentAggr.Type = bucket_aggregations.NewHistogram(cw.Ctx, interval, minDocCount)
+		format, _ := histogram["format"].(string)
+		histogramType := bucket_aggregations.NewHistogram(cw.Ctx, interval, offset, minDocCount, format, cw.parseHistogramExtendedBounds(histogram))
+		if err := histogramType.CheckExtendedBounds(); err != nil {
+			return false, 0, err
+		}
+		currentAggr.Type = histogramType
 
 		field, _ := cw.parseFieldFieldMaybeScript(histogram, "histogram")
 		var col model.Expr
-		if interval != 1.0 {
-			// col as string is: fmt.Sprintf("floor(%s / %f) * %f", fieldNameProperlyQuoted, interval, interval)
+		if interval != 1.0 || offset != 0 {
+			// col as string is: fmt.Sprintf("floor((%s - %f) / %f) * %f + %f", fieldNameProperlyQuoted, offset, interval, interval, offset),
+			// without offset if it's 0
+			shiftedField := field
+			if offset != 0 {
+				shiftedField = model.NewParenExpr(model.NewInfixExpr(field, "-", model.NewLiteral(offset)))
+			}
 			col = model.NewInfixExpr(
-				model.NewFunction("floor", model.NewInfixExpr(field, "/", model.NewLiteral(interval))),
+				model.NewFunction("floor", model.NewInfixExpr(shiftedField, "/", model.NewLiteral(interval))),
 				"*",
 				model.NewLiteral(interval),
 			)
+			if offset != 0 {
+				col = model.NewInfixExpr(col, "+", model.NewLiteral(offset))
+			}
 		} else {
 			col = field
 		}
@@ -728,13 +850,45 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 		delete(queryMap, "histogram")
 		return success, 1, nil
 	}
+	if variableWidthHistogramRaw, ok := queryMap["variable_width_histogram"]; ok {
+		variableWidthHistogram, ok := variableWidthHistogramRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("variable_width_histogram is not a map, but %T, value: %v", variableWidthHistogramRaw, variableWidthHistogramRaw)
+		}
+		buckets := cw.parseIntField(variableWidthHistogram, "buckets", variableWidthHistogramDefaultBuckets)
+		if buckets < variableWidthHistogramMinBuckets {
+			return false, 0, invalidAggregationError("variable_width_histogram buckets must be at least %d, got %d", variableWidthHistogramMinBuckets, buckets)
+		}
+		// `shard_size` and `initial_buffer` only tune Elasticsearch's clustering, we split at quantiles instead
+		field, _ := cw.parseFieldFieldMaybeScript(variableWidthHistogram, "variable_width_histogram")
+		histogram := bucket_aggregations.NewVariableWidthHistogram(cw.Ctx, field, buckets)
+		currentAggr.Type = histogram
+
+		// Like diversified_sampler: FROM (SELECT *, quantiles(...)(field) OVER (PARTITION BY parent buckets) AS edges
+		// FROM table WHERE ... AND field IS NOT NULL), as documents without the field aren't in any bucket.
+		whereClause := model.CombineWheres(cw.Ctx, currentAggr.whereBuilder,
+			model.NewSimpleQuery(model.NewInfixExpr(field, "IS", model.NewLiteral("NOT NULL")), true)).WhereClause
+		edges := model.NewAliasedExpr(histogram.Edges(currentAggr.SelectCommand.GroupBy), bucket_aggregations.VariableWidthHistogramEdgesColumnName)
+		currentAggr.SelectCommand.FromClause = *model.NewSelectCommand([]model.Expr{model.NewWildcardExpr, edges}, nil, nil,
+			currentAggr.SelectCommand.FromClause, whereClause, 0, 0, false)
+		currentAggr.whereBuilder = model.NewSimpleQuery(nil, true)
+		bucketIndex := histogram.BucketIndex(model.NewColumnRef(bucket_aggregations.VariableWidthHistogramEdgesColumnName))
+
+		currentAggr.SelectCommand.Columns = append(currentAggr.SelectCommand.Columns, bucketIndex)
+		currentAggr.SelectCommand.GroupBy = append(currentAggr.SelectCommand.GroupBy, bucketIndex)
+		currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, model.NewOrderByExprWithoutOrder(bucketIndex))
+
+		delete(queryMap, "variable_width_histogram")
+		return success, 1, nil
+	}
 	if dateHistogramRaw, ok := queryMap["date_histogram"]; ok {
 		dateHistogram, ok := dateHistogramRaw.(QueryMap)
 		if !ok {
 			logger.WarnWithCtx(cw.Ctx).Msgf("date_histogram is not a map, but %T, value: %v", dateHistogramRaw, dateHistogramRaw)
 		}
 		minDocCount := cw.parseMinDocCount(dateHistogram)
-		currentAggr.Type = bucket_aggregations.NewDateHistogram(cw.Ctx, minDocCount, cw.extractInterval(dateHistogram))
+		interval, isCalendarInterval := cw.extractInterval(dateHistogram)
+		currentAggr.Type = bucket_aggregations.NewDateHistogram(cw.Ctx, minDocCount, interval, isCalendarInterval)
 		histogramPartOfQuery := cw.createHistogramPartOfQuery(dateHistogram)
 
 		currentAggr.SelectCommand.Columns = append(currentAggr.SelectCommand.Columns, histogramPartOfQuery)
@@ -746,38 +900,23 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 	}
 	for _, termsType := range []string{"terms", "significant_terms"} {
 		if terms, ok := queryMap[termsType]; ok {
-			currentAggr.Type = bucket_aggregations.NewTerms(cw.Ctx, termsType == "significant_terms")
+			termsMap, _ := terms.(QueryMap)
+			format, _ := termsMap["format"].(string)
+			currentAggr.Type = bucket_aggregations.NewTerms(cw.Ctx, termsType == "significant_terms", format)
 
 			isEmptyGroupBy := len(currentAggr.SelectCommand.GroupBy) == 0
 
-			// Parse 'missing' parameter. It can be any type.
-			var missingPlaceholder any
-			if m, ok := terms.(QueryMap); ok {
-				if m["missing"] != nil {
-					missingPlaceholder = m["missing"]
-				}
-			}
-
 			fieldExpression := cw.parseFieldField(terms, termsType)
-
-			// apply missing placeholder if it is set
-			if missingPlaceholder != nil {
-				var value model.LiteralExpr
-
-				// Maybe we should check the input type against the schema?
-				// Right now we quote if it's a string.
-				switch val := missingPlaceholder.(type) {
-				case string:
-					value = model.NewLiteral("'" + val + "'")
-				default:
-					value = model.NewLiteral(missingPlaceholder)
-				}
-
-				fieldExpression = model.NewFunction("COALESCE", fieldExpression, value)
+			// documents without the field are grouped in a bucket with `missing` key, if it's set. It can be any type.
+			if missing := termsMap["missing"]; missing != nil && fieldExpression != nil {
+				fieldExpression = cw.termsFieldWithMissing(fieldExpression, missing)
 			}
 
 			currentAggr.SelectCommand.GroupBy = append(currentAggr.SelectCommand.GroupBy, fieldExpression)
 			currentAggr.SelectCommand.Columns = append(currentAggr.SelectCommand.Columns, fieldExpression)
+			if termsMap, ok := terms.(QueryMap); ok {
+				currentAggr.GroupByStrategy = cw.parseTermsExecutionHint(termsMap)
+			}
 
 			orderByAdded := false
 			size := 10
@@ -827,17 +966,12 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 			logger.WarnWithCtx(cw.Ctx).Msgf("multi_terms is not a map, but %T, value: %v", multiTermsRaw, multiTermsRaw)
 		}
 
-		orderByAdded := false
 		isEmptyGroupBy := len(currentAggr.SelectCommand.GroupBy) == 0
 		const defaultSize = 10
 		size := cw.parseIntField(multiTerms, "size", defaultSize)
-		if _, exists := queryMap["aggs"]; isEmptyGroupBy && !exists { // we can do limit only it terms are not nested
-			currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, model.NewSortByCountColumn(model.DescOrder))
-			currentAggr.SelectCommand.Limit = size
-			orderByAdded = true
-		}
 
 		var fieldsNr int
+		var columns []model.Expr
 		if termsRaw, exists := multiTerms["terms"]; exists {
 			terms, ok := termsRaw.([]any)
 			if !ok {
@@ -846,16 +980,37 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 			fieldsNr = len(terms)
 			for _, term := range terms {
 				column := cw.parseFieldField(term, "multi_terms")
+				columns = append(columns, column)
 				currentAggr.SelectCommand.Columns = append(currentAggr.SelectCommand.Columns, column)
 				currentAggr.SelectCommand.GroupBy = append(currentAggr.SelectCommand.GroupBy, column)
-				if !orderByAdded {
-					currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, model.NewOrderByExprWithoutOrder(column))
-				}
 			}
 		} else {
 			logger.WarnWithCtx(cw.Ctx).Msg("no terms in multi_terms")
 		}
 
+		if _, exists := queryMap["aggs"]; isEmptyGroupBy && !exists { // we can do limit only it terms are not nested
+			currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, cw.parseMultiTermsOrder(multiTerms, columns, model.NewCountFunc())...)
+			currentAggr.SelectCommand.Limit = size
+		} else {
+			orderedByKey := false
+			if _, exists := multiTerms["order"]; exists {
+				// Subaggregations' queries inherit this ORDER BY, but group by more columns, so we order by count of this level's
+				// buckets, not of their own groups. Keys break ties, so that their rows stay in the same order as the buckets.
+				groupBy := slices.Clone(currentAggr.SelectCommand.GroupBy)
+				bucketCount := model.NewWindowFunction("sum", []model.Expr{model.NewCountFunc()}, groupBy, model.OrderByExpr{})
+				orderBy := cw.parseMultiTermsOrder(multiTerms, columns, bucketCount)
+				currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, orderBy...)
+				orderedByKey = slices.ContainsFunc(orderBy, func(expr model.OrderByExpr) bool {
+					return len(columns) > 0 && model.AsString(expr.Exprs[0]) == model.AsString(columns[0])
+				})
+			}
+			if !orderedByKey {
+				for _, column := range columns {
+					currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, model.NewOrderByExprWithoutOrder(column))
+				}
+			}
+		}
+
 		currentAggr.Type = bucket_aggregations.NewMultiTerms(cw.Ctx, fieldsNr)
 		if len(currentAggr.Aggregators) > 0 {
 			currentAggr.Aggregators[len(currentAggr.Aggregators)-1].SplitOverHowManyFields = fieldsNr
@@ -917,14 +1072,11 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 		if !ok {
 			logger.WarnWithCtx(cw.Ctx).Msgf("geotile_grid is not a map, but %T, value: %v", geoTileGridRaw, geoTileGridRaw)
 		}
-		var precision float64
-		precisionRaw, ok := geoTileGrid["precision"]
-		if ok {
-			switch cutValueTyped := precisionRaw.(type) {
-			case float64:
-				precision = cutValueTyped
-			}
+		precisionInt, err := cw.parseGeoGridPrecision(geoTileGrid, "geotile_grid", geoTileGridDefaultPrecision, geoTileGridMinPrecision, geoTileGridMaxPrecision)
+		if err != nil {
+			return false, 0, err
 		}
+		precision := float64(precisionInt)
 		field := cw.parseFieldField(geoTileGrid, "geotile_grid")
 		currentAggr.Type = bucket_aggregations.NewGeoTileGrid(cw.Ctx)
 
@@ -982,19 +1134,169 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 		delete(queryMap, "geotile_grid")
 		return success, 3, err
 	}
-	if _, ok := queryMap["sampler"]; ok {
+	if rareTermsRaw, ok := queryMap["rare_terms"]; ok {
+		rareTerms, ok := rareTermsRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("rare_terms is not a map, but %T, value: %v", rareTermsRaw, rareTermsRaw)
+		}
+		maxDocCount := cw.parseIntField(rareTerms, "max_doc_count", rareTermsDefaultMaxDocCount)
+		if maxDocCount < rareTermsMinMaxDocCount || maxDocCount > rareTermsMaxMaxDocCount {
+			return false, 0, fmt.Errorf("rare_terms max_doc_count must be between %d and %d, got %d",
+				rareTermsMinMaxDocCount, rareTermsMaxMaxDocCount, maxDocCount)
+		}
+		field := cw.parseFieldField(rareTerms, "rare_terms")
+		currentAggr.Type = bucket_aggregations.NewRareTerms(cw.Ctx)
+
+		isEmptyGroupBy := len(currentAggr.SelectCommand.GroupBy) == 0
+		currentAggr.SelectCommand.Columns = append(currentAggr.SelectCommand.Columns, field)
+		currentAggr.SelectCommand.GroupBy = append(currentAggr.SelectCommand.GroupBy, field)
+
+		isRare := model.NewInfixExpr(model.NewCountFunc(), "<=", model.NewLiteral(maxDocCount))
+		if _, hasSubaggregations := queryMap["aggs"]; !hasSubaggregations {
+			currentAggr.SelectCommand.Having = isRare
+			if isEmptyGroupBy {
+				currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, model.NewSortByCountColumn(model.AscOrder))
+			}
+		} else {
+			// HAVING would filter groups of subaggregations, not ours, so subaggregations only see rare terms
+			// via: (parent group by fields..., field) IN (SELECT ... GROUP BY ... HAVING count()<=maxDocCount)
+			groupBy := slices.Clone(currentAggr.SelectCommand.GroupBy)
+			rareTermsSelect := model.NewSelectCommand(groupBy, groupBy, nil, currentAggr.SelectCommand.FromClause,
+				currentAggr.whereBuilder.WhereClause, 0, 0, false)
+			rareTermsSelect.Having = isRare
+			var groupByKey model.Expr = field
+			if len(groupBy) > 1 {
+				groupByKey = model.NewFunction("tuple", groupBy...)
+			}
+			currentAggr.whereBuilder = model.CombineWheres(cw.Ctx, currentAggr.whereBuilder,
+				model.NewSimpleQuery(model.NewInfixExpr(groupByKey, "IN", model.NewParenExpr(*rareTermsSelect)), true))
+		}
+		currentAggr.SelectCommand.OrderBy = append(currentAggr.SelectCommand.OrderBy, model.NewOrderByExprWithoutOrder(field))
+
+		delete(queryMap, "rare_terms")
+		return success, 1, nil
+	}
+	if geohashGridRaw, ok := queryMap["geohash_grid"]; ok {
+		geohashGrid, ok := geohashGridRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("geohash_grid is not a map, but %T, value: %v", geohashGridRaw, geohashGridRaw)
+		}
+		precision, err := cw.parseGeoGridPrecision(geohashGrid, "geohash_grid", geohashGridDefaultPrecision, geohashGridMinPrecision, geohashGridMaxPrecision)
+		if err != nil {
+			return false, 0, err
+		}
+		field := cw.parseFieldField(geohashGrid, "geohash_grid")
+		currentAggr.Type = bucket_aggregations.NewGeohashGrid(cw.Ctx)
+
+		// geohashEncode(toFloat64("Location::lon"), toFloat64("Location::lat"), precision) is the cell (bucket key)
+		// TODO columns names should be created according to the schema
+		fieldName := strings.Trim(model.AsString(field), "\"")
+		cell := model.NewFunction("geohashEncode",
+			model.NewFunction("toFloat64", model.NewColumnRef(fieldName+"::lon")),
+			model.NewFunction("toFloat64", model.NewColumnRef(fieldName+"::lat")),
+			model.NewLiteral(precision))
+
+		currentAggr.SelectCommand.Columns = append(currentAggr.SelectCommand.Columns, cell)
+		currentAggr.SelectCommand.GroupBy = append(currentAggr.SelectCommand.GroupBy, cell)
+
+		delete(queryMap, "geohash_grid")
+		return success, 1, nil
+	}
+	if samplerRaw, ok := queryMap["sampler"]; ok {
+		sampler, ok := samplerRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("sampler is not a map, but %T, value: %v", samplerRaw, samplerRaw)
+		}
 		currentAggr.Type = metrics_aggregations.NewCount(cw.Ctx)
+		// all subaggregations are computed over (at most) shard_size documents
+		currentAggr.SelectCommand.SampleLimit = cw.parseIntField(sampler, "shard_size", samplerDefaultShardSize)
 		delete(queryMap, "sampler")
 		return
 	}
-	// Let's treat random_sampler just like sampler for now, until we add `LIMIT` logic to sampler.
-	// Random sampler doesn't have `size` field, but `probability`, so logic in the final version should be different.
-	// So far I've only observed its "probability" field to be 1.0, so it's not really important.
-	if _, ok := queryMap["random_sampler"]; ok {
+	if missingRaw, ok := queryMap["missing"]; ok {
+		field, ok := cw.missingAggregationField(missingRaw)
+		if !ok {
+			return false, 0, nil
+		}
+		// single bucket of documents without the field, so the same as a filter with `must_not: exists`
+		currentAggr.Type = metrics_aggregations.NewCount(cw.Ctx)
+		notExists := QueryMap{"bool": QueryMap{"must_not": QueryMap{"exists": QueryMap{"field": field}}}}
+		currentAggr.whereBuilder = model.CombineWheres(cw.Ctx, currentAggr.whereBuilder, cw.parseQueryMap(notExists))
+		delete(queryMap, "missing")
+		return
+	}
+	if diversifiedSamplerRaw, ok := queryMap["diversified_sampler"]; ok {
+		diversifiedSampler, ok := diversifiedSamplerRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("diversified_sampler is not a map, but %T, value: %v", diversifiedSamplerRaw, diversifiedSamplerRaw)
+		}
 		currentAggr.Type = metrics_aggregations.NewCount(cw.Ctx)
+		field := cw.parseFieldField(diversifiedSampler, "diversified_sampler")
+		maxDocsPerValue := cw.parseIntField(diversifiedSampler, "max_docs_per_value", diversifiedSamplerDefaultMaxDocsPerValue)
+
+		// Like sampler, but with at most max_docs_per_value documents having the same value of field:
+		// FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY field) AS row_nr FROM table WHERE ...) WHERE row_nr <= max_docs_per_value
+		rowNumber := model.NewAliasedExpr(model.NewWindowFunction("ROW_NUMBER", nil, []model.Expr{field}, model.OrderByExpr{}),
+			diversifiedSamplerRowNumberColumnName)
+		currentAggr.SelectCommand.FromClause = *model.NewSelectCommand([]model.Expr{model.NewWildcardExpr, rowNumber}, nil, nil,
+			currentAggr.SelectCommand.FromClause, currentAggr.whereBuilder.WhereClause, 0, 0, false)
+		currentAggr.whereBuilder = model.NewSimpleQuery(model.NewInfixExpr(
+			model.NewColumnRef(diversifiedSamplerRowNumberColumnName), "<=", model.NewLiteral(strconv.Itoa(maxDocsPerValue))), true)
+		currentAggr.SelectCommand.SampleLimit = cw.parseIntField(diversifiedSampler, "shard_size", samplerDefaultShardSize)
+		delete(queryMap, "diversified_sampler")
+		return
+	}
+	if randomSamplerRaw, ok := queryMap["random_sampler"]; ok {
+		randomSampler, ok := randomSamplerRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("random_sampler is not a map, but %T, value: %v", randomSamplerRaw, randomSamplerRaw)
+		}
+		probability := 1.0
+		if probabilityRaw, exists := randomSampler["probability"]; exists {
+			probability, ok = probabilityRaw.(float64)
+			if !ok || probability <= 0 || probability > 1 {
+				return false, 0, invalidAggregationError("random_sampler probability must be a number in (0, 1], got: %v", probabilityRaw)
+			}
+		}
+		currentAggr.Type = bucket_aggregations.NewRandomSampler(cw.Ctx, probability, randomSampler["seed"], cw.Table.SamplingErrorEstimate)
+		if probability < 1 {
+			if currentAggr.SampleProbability > 0 { // nested random_sampler
+				probability *= currentAggr.SampleProbability
+			}
+			currentAggr.SampleProbability = probability
+			cw.sampleDocuments(currentAggr, probability)
+		}
 		delete(queryMap, "random_sampler")
 		return
 	}
+	if nestedRaw, ok := queryMap["nested"]; ok {
+		nested, ok := nestedRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("nested is not a map, but %T, value: %v", nestedRaw, nestedRaw)
+		}
+		path, ok := nested["path"].(string)
+		if !ok || path == "" {
+			return false, 0, fmt.Errorf("nested aggregation needs a path, got: %v", nestedRaw)
+		}
+		// nested documents are flattened into array columns of their parent, so nested is a count of their elements:
+		// FROM (SELECT * FROM table WHERE ...) ARRAY JOIN nested columns. Parent's filters apply to whole documents,
+		// and subaggregations' (which can refer to fields relative to the path) to nested ones.
+		var nestedFields []string
+		if aggs, ok := queryMap["aggs"].(QueryMap); ok {
+			nestedFields = cw.rewriteNestedAggregationFields(path, aggs)
+		}
+		currentAggr.Type = metrics_aggregations.NewCount(cw.Ctx)
+		if arrayJoin := cw.nestedArrayJoin(path, nestedFields); len(arrayJoin) > 0 {
+			if currentAggr.whereBuilder.WhereClause != nil {
+				currentAggr.SelectCommand.FromClause = *model.NewSelectCommand([]model.Expr{model.NewWildcardExpr}, nil, nil,
+					currentAggr.SelectCommand.FromClause, currentAggr.whereBuilder.WhereClause, 0, 0, false)
+				currentAggr.whereBuilder = model.NewSimpleQuery(nil, true)
+			}
+			currentAggr.SelectCommand.ArrayJoin = arrayJoin
+		}
+		delete(queryMap, "nested")
+		return
+	}
 	if boolRaw, ok := queryMap["bool"]; ok {
 		if Bool, ok := boolRaw.(QueryMap); ok {
 			currentAggr.whereBuilder = model.CombineWheres(cw.Ctx, currentAggr.whereBuilder, cw.parseBool(Bool))
@@ -1012,6 +1314,171 @@ func (cw *ClickhouseQueryTranslator) tryBucketAggregation(currentAggr *aggrQuery
 	return
 }
 
+const (
+	geoTileGridDefaultPrecision = 7
+	geoTileGridMinPrecision     = 0
+	geoTileGridMaxPrecision     = 29
+	geohashGridDefaultPrecision = 5
+	geohashGridMinPrecision     = 1
+	geohashGridMaxPrecision     = 12
+
+	variableWidthHistogramDefaultBuckets = 10
+	variableWidthHistogramMinBuckets     = 2 // we need at least 1 quantile to split at
+
+	rareTermsDefaultMaxDocCount = 1
+	rareTermsMinMaxDocCount     = 1
+	rareTermsMaxMaxDocCount     = 100
+
+	samplerDefaultShardSize                  = 100
+	diversifiedSamplerDefaultMaxDocsPerValue = 1
+	diversifiedSamplerRowNumberColumnName    = "diversified_row_number"
+)
+
+// parseGeoGridPrecision returns 'precision' of geotile_grid/geohash_grid aggregation, or an error if it's out of [minPrecision, maxPrecision] bounds.
+// Geohash precision given as distance (e.g. "1km") isn't supported.
+func (cw *ClickhouseQueryTranslator) parseGeoGridPrecision(grid QueryMap, aggrType string, defaultPrecision, minPrecision, maxPrecision int) (int, error) {
+	precisionRaw, exists := grid["precision"]
+	if !exists {
+		return defaultPrecision, nil
+	}
+	var precision int
+	switch precisionTyped := precisionRaw.(type) {
+	case float64:
+		if precisionTyped != float64(int(precisionTyped)) {
+			return 0, fmt.Errorf("%s precision is not an integer: %v", aggrType, precisionTyped)
+		}
+		precision = int(precisionTyped)
+	case string:
+		var err error
+		if precision, err = strconv.Atoi(precisionTyped); err != nil {
+			return 0, fmt.Errorf("unsupported %s precision: %s", aggrType, precisionTyped)
+		}
+	default:
+		return 0, fmt.Errorf("%s precision is not a number, but %T, value: %v", aggrType, precisionRaw, precisionRaw)
+	}
+	if precision < minPrecision || precision > maxPrecision {
+		return 0, fmt.Errorf("%s precision must be between %d and %d, got %d", aggrType, minPrecision, maxPrecision, precision)
+	}
+	return precision, nil
+}
+
+// parseTermsExecutionHint chooses how to GROUP BY terms' field, based on its 'execution_hint'.
+// We also accept 'collect_mode' (breadth_first/depth_first), but ignore it: it's about the order
+// of computing subaggregations in Elastic, and we compute each of them in a separate query anyway.
+func (cw *ClickhouseQueryTranslator) parseTermsExecutionHint(terms QueryMap) model.GroupByStrategy {
+	if collectMode, exists := terms["collect_mode"]; exists && collectMode != "breadth_first" && collectMode != "depth_first" {
+		logger.WarnWithCtx(cw.Ctx).Msgf("unknown collect_mode: %v, ignoring", collectMode)
+	}
+	hint, exists := terms["execution_hint"]
+	if !exists {
+		return model.GroupByDefault
+	}
+	switch hint {
+	case "map":
+		return model.GroupByHash
+	case "global_ordinals":
+		return model.GroupBySorted
+	}
+	logger.WarnWithCtx(cw.Ctx).Msgf("unknown execution_hint: %v, ignoring", hint)
+	return model.GroupByDefault
+}
+
+// termsFieldWithMissing returns `field` of terms aggregation with `missing` value for documents without it.
+// Like in Elastic, for numeric fields it's a number, also if it's given as a string. If it's not a number at all,
+// we group by the field as a string, as ClickHouse can't COALESCE numbers and strings.
+func (cw *ClickhouseQueryTranslator) termsFieldWithMissing(field model.Expr, missing any) model.Expr {
+	isNumericField := false
+	if column, ok := field.(model.ColumnRef); ok {
+		isNumericField = cw.Table.IsNumeric(column.ColumnName)
+	}
+	var value model.Expr
+	switch missingTyped := missing.(type) {
+	case float64:
+		value = model.NewLiteral(strconv.FormatFloat(missingTyped, 'f', -1, 64))
+	case string:
+		if number, err := strconv.ParseFloat(missingTyped, 64); err == nil && isNumericField {
+			value = model.NewLiteral(strconv.FormatFloat(number, 'f', -1, 64))
+		} else {
+			if isNumericField {
+				logger.WarnWithCtx(cw.Ctx).Msgf("missing value '%s' of terms aggregation on numeric field %s isn't a number", missingTyped, model.AsString(field))
+				field = model.NewFunction("toString", field)
+			}
+			value = model.NewLiteral("'" + strings.ReplaceAll(missingTyped, "'", `\'`) + "'")
+		}
+	default:
+		value = model.NewLiteral(missingTyped)
+	}
+	return model.NewFunction("COALESCE", field, value)
+}
+
+// missingAggregationField returns the field of `missing` aggregation
+func (cw *ClickhouseQueryTranslator) missingAggregationField(missingRaw any) (string, bool) {
+	missing, ok := missingRaw.(QueryMap)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("missing is not a map, but %T, value: %v", missingRaw, missingRaw)
+		return "", false
+	}
+	field, ok := missing["field"].(string)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no field or invalid field in missing aggregation: %v", missing)
+		return "", false
+	}
+	return field, true
+}
+
+// parseMultiTermsOrder returns ORDER BY for multi_terms' 'order' parameter, which is either a single {key: direction} map,
+// or an array of them. Keys can be "_count" (ordering by `count`) or "_key" (which means all `keyColumns`). Ordering by
+// subaggregations isn't supported, we order by count descending (Elastic's default) then.
+func (cw *ClickhouseQueryTranslator) parseMultiTermsOrder(multiTerms QueryMap, keyColumns []model.Expr, count model.Expr) []model.OrderByExpr {
+	defaultOrder := []model.OrderByExpr{model.NewOrderByExpr([]model.Expr{count}, model.DescOrder)}
+	orderRaw, exists := multiTerms["order"]
+	if !exists {
+		return defaultOrder
+	}
+	var orders []any
+	switch orderTyped := orderRaw.(type) {
+	case QueryMap:
+		orders = []any{orderTyped}
+	case []any:
+		orders = orderTyped
+	default:
+		logger.WarnWithCtx(cw.Ctx).Msgf("multi_terms order is not a map nor an array, but %T, value: %v. Using default", orderRaw, orderRaw)
+		return defaultOrder
+	}
+
+	var result []model.OrderByExpr
+	for _, orderRaw := range orders {
+		order, ok := orderRaw.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("multi_terms order is not a map, but %T, value: %v. Using default", orderRaw, orderRaw)
+			return defaultOrder
+		}
+		for key, directionRaw := range order {
+			direction := model.DescOrder
+			if directionStr, ok := directionRaw.(string); ok && strings.ToLower(directionStr) == "asc" {
+				direction = model.AscOrder
+			} else if !ok || strings.ToLower(directionStr) != "desc" {
+				logger.WarnWithCtx(cw.Ctx).Msgf("unexpected multi_terms order direction: %v. Using desc", directionRaw)
+			}
+			switch key {
+			case "_count":
+				result = append(result, model.NewOrderByExpr([]model.Expr{count}, direction))
+			case "_key":
+				for _, column := range keyColumns {
+					result = append(result, model.NewOrderByExpr([]model.Expr{column}, direction))
+				}
+			default:
+				logger.WarnWithCtx(cw.Ctx).Msgf("multi_terms ordering by %s is not supported. Using default", key)
+				return defaultOrder
+			}
+		}
+	}
+	if len(result) == 0 {
+		return defaultOrder
+	}
+	return result
+}
+
 // parseFieldField returns field 'field' from shouldBeMap, which should be a string. Logs some warnings in case of errors, and returns "" then
 func (cw *ClickhouseQueryTranslator) parseFieldField(shouldBeMap any, aggregationType string) model.Expr {
 	Map, ok := shouldBeMap.(QueryMap)
@@ -1041,6 +1508,36 @@ func (cw *ClickhouseQueryTranslator) parseIntField(queryMap QueryMap, fieldName
 	return defaultValue
 }
 
+func (cw *ClickhouseQueryTranslator) parseFloatField(queryMap QueryMap, fieldName string, defaultValue float64) float64 {
+	if valueRaw, exists := queryMap[fieldName]; exists {
+		if asFloat, ok := valueRaw.(float64); ok {
+			return asFloat
+		}
+		logger.WarnWithCtx(cw.Ctx).Msgf("%s is not an float64, but %T, value: %v. Using default", fieldName, valueRaw, valueRaw)
+	}
+	return defaultValue
+}
+
+// parseHistogramExtendedBounds returns histogram's extended_bounds, or nil if there are none (or they're invalid)
+func (cw *ClickhouseQueryTranslator) parseHistogramExtendedBounds(histogram QueryMap) *bucket_aggregations.HistogramBounds {
+	extendedBoundsRaw, exists := histogram["extended_bounds"]
+	if !exists {
+		return nil
+	}
+	extendedBounds, ok := extendedBoundsRaw.(QueryMap)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("extended_bounds is not a map, but %T, value: %v. Skipping", extendedBoundsRaw, extendedBoundsRaw)
+		return nil
+	}
+	minBound, minOk := extendedBounds["min"].(float64)
+	maxBound, maxOk := extendedBounds["max"].(float64)
+	if !minOk || !maxOk || minBound > maxBound {
+		logger.WarnWithCtx(cw.Ctx).Msgf("invalid extended_bounds: %v. Skipping", extendedBounds)
+		return nil
+	}
+	return &bucket_aggregations.HistogramBounds{Min: minBound, Max: maxBound}
+}
+
 // parseFieldFieldMaybeScript is basically almost a copy of parseFieldField above, but it also handles a basic script, if "field" is missing.
 func (cw *ClickhouseQueryTranslator) parseFieldFieldMaybeScript(shouldBeMap any, aggregationType string) (field model.Expr, isFromScript bool) {
 	Map, ok := shouldBeMap.(QueryMap)
@@ -10,8 +10,12 @@ import (
 	"quesma/clickhouse"
 	"quesma/concurrent"
 	"quesma/model"
+	"quesma/model/bucket_aggregations"
+	"quesma/model/metrics_aggregations"
+	"quesma/model/pipeline_aggregations"
 	"quesma/queryparser/query_util"
 	"quesma/quesma/config"
+	"quesma/quesma/errors"
 	"quesma/quesma/types"
 	"quesma/schema"
 	"quesma/testdata"
@@ -496,8 +500,8 @@ var aggregationTests = []struct {
 				`FROM ` + tableNameQuoted + ` ` +
 				`WHERE "taxful_total_price" > '250') ` +
 				`WHERE ("taxful_total_price" > '250' AND "row_number"<=10) ` +
-				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000) ` +
-				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000)`,
+				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ASC`,
 			`SELECT toInt64(toUnixTimestamp64Milli("order_date") / 43200000), ` +
 				`maxOrNull("taxful_total_price") AS "windowed_taxful_total_price", maxOrNull("order_date") AS "windowed_order_date" ` +
 				`FROM (SELECT "taxful_total_price", "order_date", ROW_NUMBER() OVER ` +
@@ -506,8 +510,8 @@ var aggregationTests = []struct {
 				`FROM ` + tableNameQuoted + ` ` +
 				`WHERE "taxful_total_price" > '250') ` +
 				`WHERE ("taxful_total_price" > '250' AND "row_number"<=10) ` +
-				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000) ` +
-				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000)`,
+				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ASC`,
 			`SELECT toInt64(toUnixTimestamp64Milli("order_date") / 43200000), count() ` +
 				`FROM ` + tableNameQuoted + ` ` +
 				`WHERE "taxful_total_price" > '250' ` +
@@ -537,7 +541,7 @@ var aggregationTests = []struct {
 				"size": 0
 			}`,
 		[]string{
-			`SELECT "OriginCityName", count() FROM ` + tableNameQuoted + ` GROUP BY "OriginCityName" ORDER BY count() DESC LIMIT 10`,
+			`SELECT "OriginCityName", count(), sum(count()) OVER () AS "total_doc_count" FROM ` + tableNameQuoted + ` GROUP BY "OriginCityName" ORDER BY count() DESC LIMIT 10`,
 			`SELECT count(DISTINCT "OriginCityName") FROM ` + tableNameQuoted,
 		},
 	},
@@ -561,10 +565,10 @@ var aggregationTests = []struct {
 				  "size": 0
 			}`,
 		[]string{
-			`SELECT floor("bytes"/1782.000000)*1782.000000, count() FROM ` + tableNameQuoted + ` ` +
+			`SELECT floor("bytes"/1782.000000)*1782.000000, count() FROM (SELECT "bytes" FROM ` + tableNameQuoted + ` LIMIT 5000) ` +
 				`GROUP BY floor("bytes"/1782.000000)*1782.000000 ` +
 				`ORDER BY floor("bytes"/1782.000000)*1782.000000`,
-			`SELECT count() FROM ` + tableNameQuoted,
+			`SELECT count() FROM (SELECT 1 FROM ` + tableNameQuoted + ` LIMIT 5000)`,
 		},
 	},
 }
@@ -616,6 +620,1042 @@ func TestAggregationParser(t *testing.T) {
 	}
 }
 
+// TestAggregationParserPartialResults checks that unsupported aggregations are skipped (with all their subaggregations),
+// but the supported ones, even on the same level, are still parsed.
+func TestAggregationParserPartialResults(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp":  {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"host":        {Name: "host", Type: clickhouse.NewBaseType("String")},
+			"bytes_gauge": {Name: "bytes_gauge", Type: clickhouse.NewBaseType("UInt64")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	body, parseErr := types.ParseJSON(`{
+		"size": 0,
+		"aggs": {
+			"hosts": {
+				"terms": {"field": "host"},
+				"aggs": {
+					"avg_bytes": {"avg": {"field": "bytes_gauge"}},
+					"distances": {
+						"geo_distance": {"field": "location", "origin": "52.37, 4.89", "ranges": [{"to": 100}]},
+						"aggs": {"max_bytes": {"max": {"field": "bytes_gauge"}}}
+					}
+				}
+			},
+			"bad_dates": {
+				"date_range": {"field": "@timestamp", "ranges": [{"from": "not a date"}]},
+				"aggs": {"min_bytes": {"min": {"field": "bytes_gauge"}}}
+			},
+			"total_bytes": {"sum": {"field": "bytes_gauge"}}
+		}
+	}`)
+	assert.NoError(t, parseErr)
+	aggregations, err := cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+
+	var aggregatorPaths []string
+	for _, aggregation := range aggregations {
+		names := make([]string, 0, len(aggregation.Aggregators))
+		for _, aggregator := range aggregation.Aggregators {
+			names = append(names, aggregator.Name)
+		}
+		aggregatorPaths = append(aggregatorPaths, strings.Join(names, ">"))
+	}
+	assert.ElementsMatch(t, []string{"hosts", "hosts>avg_bytes", "total_bytes"}, aggregatorPaths)
+}
+
+func TestAggregationParserGeoGrids(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"location": {Name: "location", Type: clickhouse.NewBaseType("Point")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	geohashSql := func(precision string) string {
+		return `SELECT geohashEncode(toFloat64("location::lon"),toFloat64("location::lat"),` + precision + `), count() ` +
+			`FROM ` + tableNameQuoted + ` GROUP BY geohashEncode(toFloat64("location::lon"),toFloat64("location::lat"),` + precision + `)`
+	}
+	tests := []struct {
+		name        string
+		aggregation string
+		wantSql     string // empty if aggregation should be skipped
+	}{
+		{"geohash_grid, precision 3", `{"geohash_grid": {"field": "location", "precision": 3}}`, geohashSql("3")},
+		{"geohash_grid, precision 8", `{"geohash_grid": {"field": "location", "precision": 8}}`, geohashSql("8")},
+		{"geohash_grid, precision as string", `{"geohash_grid": {"field": "location", "precision": "8"}}`, geohashSql("8")},
+		{"geohash_grid, default precision", `{"geohash_grid": {"field": "location"}}`, geohashSql("5")},
+		{"geohash_grid, precision too big", `{"geohash_grid": {"field": "location", "precision": 13}}`, ""},
+		{"geohash_grid, precision too small", `{"geohash_grid": {"field": "location", "precision": 0}}`, ""},
+		{"geohash_grid, precision as distance", `{"geohash_grid": {"field": "location", "precision": "1km"}}`, ""},
+		{"geotile_grid, precision 30", `{"geotile_grid": {"field": "location", "precision": 30}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"grid": ` + tt.aggregation + `}}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			if tt.wantSql == "" {
+				assert.Empty(t, aggregations)
+				return
+			}
+			assert.Len(t, aggregations, 1)
+			util.AssertSqlEqual(t, tt.wantSql, aggregations[0].SelectCommand.String())
+		})
+	}
+
+	for _, precision := range []int{0, 29} {
+		body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"grid": {"geotile_grid": {"field": "location", "precision": ` + strconv.Itoa(precision) + `}}}}`)
+		assert.NoError(t, parseErr)
+		aggregations, err := cw.ParseAggregationJson(body)
+		assert.NoError(t, err)
+		assert.Len(t, aggregations, 1)
+		assert.Contains(t, aggregations[0].SelectCommand.String(), strconv.Itoa(precision)+".000000 AS \"zoom\"")
+	}
+}
+
+func TestAggregationParserSignificantTerms(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"status":  {Name: "status", Type: clickhouse.NewBaseType("String")},
+			"service": {Name: "service", Type: clickhouse.NewBaseType("String")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	body, parseErr := types.ParseJSON(`{
+		"size": 0,
+		"query": {"term": {"service": "checkout"}},
+		"aggs": {"statuses": {"significant_terms": {"field": "status", "size": 2}}}
+	}`)
+	assert.NoError(t, parseErr)
+	aggregations, err := cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+	assert.Len(t, aggregations, 1)
+	foregroundCount := `countIf("service"='checkout')`
+	score := testdata.SignificantTermsScore(foregroundCount, `sum(`+foregroundCount+`) OVER ()`, `count()`, `sum(count()) OVER ()`)
+	util.AssertSqlEqual(t, `SELECT "status", count(), sum(count()) OVER (), sum(`+foregroundCount+`) OVER (), `+foregroundCount+`, `+score+` `+
+		`FROM `+tableNameQuoted+` GROUP BY "status" ORDER BY `+score+` DESC LIMIT 2`, aggregations[0].SelectCommand.String())
+
+	// The database returns terms by score. 'not_found' doesn't occur in checkout at all, so it's not returned.
+	row := func(status string, backgroundCount, foregroundCount uint64, score float64) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol("status", status),
+			model.NewQueryResultCol("count()", backgroundCount),
+			model.NewQueryResultCol("superset_size", uint64(10000)),
+			model.NewQueryResultCol("subset_size", uint64(100)),
+			model.NewQueryResultCol("doc_count", foregroundCount),
+			model.NewQueryResultCol("score", score),
+		}}
+	}
+	rows := aggregations[0].Type.PostprocessResults([]model.QueryResultRow{row("timeout", 100, 30, 8.7), row("not_found", 400, 0, 0)})
+	response := cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{rows})
+	buckets := response["statuses"].(model.JsonMap)["buckets"].([]model.JsonMap)
+	if assert.Len(t, buckets, 1) {
+		assert.Equal(t, "timeout", buckets[0]["key"])
+		assert.Equal(t, int64(100), buckets[0]["bg_count"])
+		assert.Equal(t, int64(30), buckets[0]["doc_count"])
+		assert.Equal(t, 8.7, buckets[0]["score"])
+	}
+}
+
+func TestAggregationParserMultiTerms(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"service": {Name: "service", Type: clickhouse.NewBaseType("String")},
+			"status":  {Name: "status", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	const sqlPrefix = `SELECT "service", "status", count() FROM ` + tableNameQuoted + ` GROUP BY "service", "status" `
+	tests := []struct {
+		name    string
+		order   string // empty if no 'order'
+		wantSql string
+	}{
+		{"default order", ``, sqlPrefix + `ORDER BY count() DESC LIMIT 3`},
+		{"count ascending", `"order": {"_count": "asc"},`, sqlPrefix + `ORDER BY count() ASC LIMIT 3`},
+		{"key descending", `"order": {"_key": "desc"},`, sqlPrefix + `ORDER BY "service" DESC, "status" DESC LIMIT 3`},
+		{"multiple orders", `"order": [{"_count": "desc"}, {"_key": "asc"}],`, sqlPrefix + `ORDER BY count() DESC, "service" ASC, "status" ASC LIMIT 3`},
+		{"order by subaggregation isn't supported", `"order": {"1": "asc"},`, sqlPrefix + `ORDER BY count() DESC LIMIT 3`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"breakdown": {"multi_terms": {` + tt.order +
+				`"size": 3, "terms": [{"field": "service"}, {"field": "status"}]}}}}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			assert.Len(t, aggregations, 1)
+			util.AssertSqlEqual(t, tt.wantSql, aggregations[0].SelectCommand.String())
+		})
+	}
+
+	nestedTests := []struct {
+		name    string
+		order   string
+		wantSql string
+	}{
+		{"nested, default order", ``,
+			`SELECT "service", "service", "status", count() FROM ` + tableNameQuoted + ` GROUP BY "service", "service", "status" ` +
+				`ORDER BY "service", "service", "status"`},
+		{"nested, count ascending", `"order": {"_count": "asc"},`,
+			`SELECT "service", "service", "status", count() FROM ` + tableNameQuoted + ` GROUP BY "service", "service", "status" ` +
+				`ORDER BY "service", sum(count()) OVER (PARTITION BY "service", "service", "status") ASC, "service", "status"`},
+		{"nested, key descending", `"order": {"_key": "desc"},`,
+			`SELECT "service", "service", "status", count() FROM ` + tableNameQuoted + ` GROUP BY "service", "service", "status" ` +
+				`ORDER BY "service", "service" DESC, "status" DESC`},
+	}
+	for _, tt := range nestedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"by_service": {"terms": {"field": "service"}, "aggs": {"breakdown": {"multi_terms": {` +
+				tt.order + `"terms": [{"field": "service"}, {"field": "status"}]}}}}}}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			if assert.Len(t, aggregations, 2) {
+				multiTermsQuery := aggregations[0] // the query of the terms aggregation groups by one column
+				if len(aggregations[1].SelectCommand.GroupBy) > len(multiTermsQuery.SelectCommand.GroupBy) {
+					multiTermsQuery = aggregations[1]
+				}
+				util.AssertSqlEqual(t, tt.wantSql, multiTermsQuery.SelectCommand.String())
+			}
+		})
+	}
+
+	body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"breakdown": {"multi_terms": {"terms": [{"field": "service"}, {"field": "status"}]}}}}`)
+	assert.NoError(t, parseErr)
+	aggregations, err := cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+	assert.Len(t, aggregations, 1)
+	rows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("service", "checkout"), model.NewQueryResultCol("status", int64(200)), model.NewQueryResultCol("count()", uint64(50))}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("service", "checkout"), model.NewQueryResultCol("status", int64(500)), model.NewQueryResultCol("count()", uint64(7))}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("service", "cart"), model.NewQueryResultCol("status", int64(200)), model.NewQueryResultCol("count()", uint64(3))}},
+	}
+	response := cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{rows})
+	buckets := response["breakdown"].(model.JsonMap)["buckets"].([]model.JsonMap)
+	assert.Len(t, buckets, 3)
+	for i, want := range []struct {
+		key         []any
+		keyAsString string
+		docCount    int64
+	}{
+		{[]any{"checkout", int64(200)}, "checkout|200", 50},
+		{[]any{"checkout", int64(500)}, "checkout|500", 7},
+		{[]any{"cart", int64(200)}, "cart|200", 3},
+	} {
+		assert.Equal(t, want.key, buckets[i]["key"])
+		assert.Equal(t, want.keyAsString, buckets[i]["key_as_string"])
+		assert.Equal(t, want.docCount, buckets[i]["doc_count"])
+	}
+}
+
+func TestAggregationParserRareTerms(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"genre": {Name: "genre", Type: clickhouse.NewBaseType("String")},
+			"price": {Name: "price", Type: clickhouse.NewBaseType("Float64")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	tests := []struct {
+		name     string
+		aggs     string
+		wantSqls []string
+	}{
+		{
+			"default max_doc_count",
+			`{"genres": {"rare_terms": {"field": "genre"}}}`,
+			[]string{`SELECT "genre", count() FROM ` + tableNameQuoted + ` GROUP BY "genre" HAVING count()<=1 ORDER BY count() ASC, "genre"`},
+		},
+		{
+			"custom max_doc_count",
+			`{"genres": {"rare_terms": {"field": "genre", "max_doc_count": 3}}}`,
+			[]string{`SELECT "genre", count() FROM ` + tableNameQuoted + ` GROUP BY "genre" HAVING count()<=3 ORDER BY count() ASC, "genre"`},
+		},
+		{
+			"with subaggregation",
+			`{"genres": {"rare_terms": {"field": "genre", "max_doc_count": 2}, "aggs": {"avg_price": {"avg": {"field": "price"}}}}}`,
+			[]string{
+				`SELECT "genre", avgOrNull("price") FROM ` + tableNameQuoted + ` WHERE "genre" IN (SELECT "genre" FROM ` + tableNameQuoted +
+					` GROUP BY "genre" HAVING count()<=2) GROUP BY "genre" ORDER BY "genre"`,
+				`SELECT "genre", count() FROM ` + tableNameQuoted + ` WHERE "genre" IN (SELECT "genre" FROM ` + tableNameQuoted +
+					` GROUP BY "genre" HAVING count()<=2) GROUP BY "genre" ORDER BY "genre"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": ` + tt.aggs + `}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			assert.Len(t, aggregations, len(tt.wantSqls))
+			for i, wantSql := range tt.wantSqls {
+				util.AssertSqlEqual(t, wantSql, aggregations[i].SelectCommand.String())
+			}
+		})
+	}
+
+	for _, maxDocCount := range []string{"0", "101"} {
+		body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"genres": {"rare_terms": {"field": "genre", "max_doc_count": ` + maxDocCount + `}}}}`)
+		assert.NoError(t, parseErr)
+		aggregations, err := cw.ParseAggregationJson(body)
+		assert.NoError(t, err)
+		assert.Empty(t, aggregations, maxDocCount)
+	}
+
+	body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"genres": {"rare_terms": {"field": "genre"}}}}`)
+	assert.NoError(t, parseErr)
+	aggregations, err := cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+	assert.Len(t, aggregations, 1)
+	rows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("genre", "swing"), model.NewQueryResultCol("count()", uint64(1))}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("genre", "zydeco"), model.NewQueryResultCol("count()", uint64(1))}},
+	}
+	response := cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{rows})
+	buckets := response["genres"].(model.JsonMap)["buckets"].([]model.JsonMap)
+	assert.Len(t, buckets, 2)
+	for i, wantKey := range []string{"swing", "zydeco"} {
+		assert.Equal(t, wantKey, buckets[i]["key"])
+		assert.Equal(t, int64(1), buckets[i]["doc_count"])
+	}
+}
+
+func TestAggregationParserMissing(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"genre": {Name: "genre", Type: clickhouse.NewBaseType("String")},
+			"price": {Name: "price", Type: clickhouse.BaseType{Name: "Float64", Nullable: true}},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	tests := []struct {
+		name     string
+		aggs     string
+		wantSqls []string
+	}{
+		{
+			"terms, missing string",
+			`{"genres": {"terms": {"field": "genre", "missing": "N/A"}}}`,
+			[]string{`SELECT COALESCE("genre",'N/A'), count(), sum(count()) OVER () AS "total_doc_count" FROM ` + tableNameQuoted + ` GROUP BY COALESCE("genre",'N/A') ORDER BY count() DESC LIMIT 10`},
+		},
+		{
+			"terms, missing string with a quote",
+			`{"genres": {"terms": {"field": "genre", "missing": "don't know"}}}`,
+			[]string{`SELECT COALESCE("genre",'don\'t know'), count(), sum(count()) OVER () AS "total_doc_count" FROM ` + tableNameQuoted + ` GROUP BY COALESCE("genre",'don\'t know') ORDER BY count() DESC LIMIT 10`},
+		},
+		{
+			"terms, missing number",
+			`{"prices": {"terms": {"field": "price", "missing": 1000000}}}`,
+			[]string{`SELECT COALESCE("price",1000000), count(), sum(count()) OVER () AS "total_doc_count" FROM ` + tableNameQuoted + ` GROUP BY COALESCE("price",1000000) ORDER BY count() DESC LIMIT 10`},
+		},
+		{
+			"terms, missing number as a string",
+			`{"prices": {"terms": {"field": "price", "missing": "0"}}}`,
+			[]string{`SELECT COALESCE("price",0), count(), sum(count()) OVER () AS "total_doc_count" FROM ` + tableNameQuoted + ` GROUP BY COALESCE("price",0) ORDER BY count() DESC LIMIT 10`},
+		},
+		{
+			"terms, missing string on numeric field",
+			`{"prices": {"terms": {"field": "price", "missing": "N/A"}}}`,
+			[]string{`SELECT COALESCE(toString("price"),'N/A'), count(), sum(count()) OVER () AS "total_doc_count" FROM ` + tableNameQuoted + ` GROUP BY COALESCE(toString("price"),'N/A') ORDER BY count() DESC LIMIT 10`},
+		},
+		{
+			"missing aggregation",
+			`{"no_genre": {"missing": {"field": "genre"}}}`,
+			[]string{`SELECT count() FROM ` + tableNameQuoted + ` WHERE NOT ("genre" IS NOT NULL)`},
+		},
+		{
+			"missing aggregation with subaggregation",
+			`{"no_genre": {"missing": {"field": "genre"}, "aggs": {"avg_price": {"avg": {"field": "price"}}}}}`,
+			[]string{
+				`SELECT avgOrNull("price") FROM ` + tableNameQuoted + ` WHERE NOT ("genre" IS NOT NULL)`,
+				`SELECT count() FROM ` + tableNameQuoted + ` WHERE NOT ("genre" IS NOT NULL)`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": ` + tt.aggs + `}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			assert.Len(t, aggregations, len(tt.wantSqls))
+			for i, wantSql := range tt.wantSqls {
+				util.AssertSqlEqual(t, wantSql, aggregations[i].SelectCommand.String())
+			}
+		})
+	}
+
+	// documents without genre are in a bucket with the `missing` key
+	body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"genres": {"terms": {"field": "genre", "missing": "N/A"}}}}`)
+	assert.NoError(t, parseErr)
+	aggregations, err := cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+	assert.Len(t, aggregations, 1)
+	rows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol(`COALESCE("genre",'N/A')`, "rock"), model.NewQueryResultCol("count()", uint64(5))}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol(`COALESCE("genre",'N/A')`, "N/A"), model.NewQueryResultCol("count()", uint64(2))}},
+	}
+	response := cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{rows})
+	buckets := response["genres"].(model.JsonMap)["buckets"].([]model.JsonMap)
+	if assert.Len(t, buckets, 2) {
+		assert.Equal(t, "N/A", buckets[1]["key"])
+		assert.Equal(t, int64(2), buckets[1]["doc_count"])
+	}
+
+	// missing aggregation is a single bucket
+	body, parseErr = types.ParseJSON(`{"size": 0, "aggs": {"no_genre": {"missing": {"field": "genre"}}}}`)
+	assert.NoError(t, parseErr)
+	aggregations, err = cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+	assert.Len(t, aggregations, 1)
+	rows = []model.QueryResultRow{{Cols: []model.QueryResultCol{model.NewQueryResultCol("count()", uint64(3))}}}
+	response = cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{rows})
+	assert.Equal(t, model.JsonMap{"doc_count": int64(3)}, response["no_genre"])
+}
+
+func TestAggregationParserTermsSumOtherDocCount(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"genre": {Name: "genre", Type: clickhouse.NewBaseType("String")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"genres": {"terms": {"field": "genre", "size": 2}}}}`)
+	assert.NoError(t, parseErr)
+	aggregations, err := cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+	if !assert.Len(t, aggregations, 1) {
+		return
+	}
+	util.AssertSqlEqual(t, `SELECT "genre", count(), sum(count()) OVER () AS "total_doc_count" FROM `+tableNameQuoted+
+		` GROUP BY "genre" ORDER BY count() DESC LIMIT 2`, aggregations[0].SelectCommand.String())
+
+	// size truncates 3 genres with 10 documents to the top 2
+	rows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("genre", "rock"), model.NewQueryResultCol("count()", uint64(5)),
+			model.NewQueryResultCol("total_doc_count", uint64(10))}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("genre", "jazz"), model.NewQueryResultCol("count()", uint64(3)),
+			model.NewQueryResultCol("total_doc_count", uint64(10))}},
+	}
+	response := cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{rows})
+	genres := response["genres"].(model.JsonMap)
+	assert.Equal(t, int64(2), genres["sum_other_doc_count"])
+	assert.Equal(t, 0, genres["doc_count_error_upper_bound"])
+	buckets := genres["buckets"].([]model.JsonMap)
+	if assert.Len(t, buckets, 2) {
+		assert.Equal(t, "rock", buckets[0]["key"])
+		assert.Equal(t, int64(5), buckets[0]["doc_count"])
+		assert.Equal(t, "jazz", buckets[1]["key"])
+		assert.Equal(t, int64(3), buckets[1]["doc_count"])
+	}
+}
+
+func TestAggregationParserRandomSampler(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"price": {Name: "price", Type: clickhouse.NewBaseType("Float64")},
+		},
+		Name:                  tableName,
+		Config:                clickhouse.NewDefaultCHConfig(),
+		SamplingErrorEstimate: true,
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"sample": {"random_sampler": {"probability": 0.1, "seed": 42}, "aggs": {
+		"avg_price": {"avg": {"field": "price"}},
+		"max_price": {"max": {"field": "price"}},
+		"price_count": {"value_count": {"field": "price"}},
+		"price_stats": {"stats": {"field": "price"}},
+		"total_price": {"sum": {"field": "price"}}}}}}`)
+	assert.NoError(t, parseErr)
+	aggregations, err := cw.ParseAggregationJson(body)
+	assert.NoError(t, err)
+
+	// results of the sample, each query gets rows for its type
+	resultSets := make([][]model.QueryResultRow, len(aggregations))
+	for i, query := range aggregations {
+		assert.Contains(t, query.SelectCommand.String(), "cityHash64(*)<1844674407370955264") // 0.1 * 2^64
+		assert.Equal(t, 0.1, query.SampleProbability)
+		var cols []model.QueryResultCol
+		switch query.Type.(type) {
+		case metrics_aggregations.Avg:
+			cols = []model.QueryResultCol{model.NewQueryResultCol("avgOrNull(price)", 2.5)}
+		case metrics_aggregations.Max:
+			cols = []model.QueryResultCol{model.NewQueryResultCol("maxOrNull(price)", 9.0)}
+		case metrics_aggregations.Stats:
+			cols = []model.QueryResultCol{model.NewQueryResultCol("count(price)", uint64(40)),
+				model.NewQueryResultCol("minOrNull(price)", 1.0), model.NewQueryResultCol("maxOrNull(price)", 9.0),
+				model.NewQueryResultCol("avgOrNull(price)", 2.5), model.NewQueryResultCol("sumOrNull(price)", 100.0)}
+		case metrics_aggregations.Sum:
+			cols = []model.QueryResultCol{model.NewQueryResultCol("sumOrNull(price)", 100.0)}
+		default: // value_count and random_sampler's doc_count
+			cols = []model.QueryResultCol{model.NewQueryResultCol("count()", uint64(40))}
+		}
+		resultSets[i] = []model.QueryResultRow{{Cols: cols}}
+	}
+
+	response := cw.MakeAggregationPartOfResponse(aggregations, resultSets)
+	sample := response["sample"].(model.JsonMap)
+	assert.Equal(t, int64(400), sample["doc_count"])
+	assert.Equal(t, 0.1, sample["probability"])
+	assert.Equal(t, 42.0, sample["seed"])
+	assert.InDelta(t, 60.0, sample["doc_count_error_estimate"], 1e-9) // sqrt(400 * 0.9 / 0.1)
+	// counts and sums are scaled, other metrics aren't
+	assert.Equal(t, 2.5, sample["avg_price"].(model.JsonMap)["value"])
+	assert.Equal(t, 9.0, sample["max_price"].(model.JsonMap)["value"])
+	assert.EqualValues(t, 400, sample["price_count"].(model.JsonMap)["value"])
+	assert.InDelta(t, 1000.0, sample["total_price"].(model.JsonMap)["value"], 1e-9)
+	stats := sample["price_stats"].(model.JsonMap)
+	assert.EqualValues(t, 400, stats["count"])
+	assert.Equal(t, 1.0, stats["min"])
+	assert.Equal(t, 9.0, stats["max"])
+	assert.Equal(t, 2.5, stats["avg"])
+	assert.InDelta(t, 1000.0, stats["sum"], 1e-9)
+	// rows from the DB (which may be cached) are kept as they were
+	for _, rows := range resultSets {
+		if rows[0].Cols[0].ColName == "count()" {
+			assert.Equal(t, uint64(40), rows[0].Cols[0].Value)
+		}
+	}
+
+	// invalid probability => the request fails with 400
+	for _, probability := range []string{"1.5", "0", "-0.1", `"abc"`} {
+		_, err = cw.ParseAggregationJson(types.MustJSON(`{"aggs": {"sample": {"random_sampler": {"probability": ` + probability + `}}}}`))
+		assert.ErrorIs(t, err, quesma_errors.ErrCouldNotParseRequest(), probability)
+	}
+
+	// with SAMPLE BY, ClickHouse samples the table. Nested random_samplers sample with the product of probabilities.
+	table.CreateTableQuery = `CREATE TABLE ` + tableName + ` (price Float64) ENGINE = MergeTree ORDER BY intHash32(price) SAMPLE BY intHash32(price)`
+	aggregations, err = cw.ParseAggregationJson(types.MustJSON(`{"size": 0, "aggs": {"sample": {"random_sampler": {"probability": 0.5}, "aggs": {
+		"inner": {"random_sampler": {"probability": 0.2}, "aggs": {"total_price": {"sum": {"field": "price"}}}}}}}}`))
+	assert.NoError(t, err)
+	if assert.Len(t, aggregations, 3) {
+		util.AssertSqlEqual(t, `SELECT sumOrNull("price") FROM `+tableNameQuoted+` SAMPLE 0.1`, aggregations[0].SelectCommand.String())
+		assert.Equal(t, 0.1, aggregations[0].SampleProbability)
+		util.AssertSqlEqual(t, `SELECT count() FROM `+tableNameQuoted+` SAMPLE 0.1`, aggregations[1].SelectCommand.String())
+		util.AssertSqlEqual(t, `SELECT count() FROM `+tableNameQuoted+` SAMPLE 0.5`, aggregations[2].SelectCommand.String())
+	}
+}
+
+func TestScaleSampledResults(t *testing.T) {
+	ctx := context.Background()
+	col := func(value any) model.QueryResultCol { return model.NewQueryResultCol("col", value) }
+	dateRange := bucket_aggregations.NewDateRange(ctx, "@timestamp", "", []bucket_aggregations.DateTimeInterval{
+		bucket_aggregations.NewDateTimeInterval("now()-1d", bucket_aggregations.UnboundedInterval),
+		bucket_aggregations.NewDateTimeInterval(bucket_aggregations.UnboundedInterval, "now()-1d"),
+	}, 4)
+	tests := []struct {
+		name      string
+		queryType model.QueryType
+		row       []model.QueryResultCol
+		want      []model.QueryResultCol
+	}{
+		{"filters", bucket_aggregations.NewFiltersEmpty(ctx), []model.QueryResultCol{col(uint64(3))}, []model.QueryResultCol{col(uint64(30))}},
+		{"rare_terms", bucket_aggregations.NewRareTerms(ctx), []model.QueryResultCol{col("a"), col(uint64(2))}, []model.QueryResultCol{col("a"), col(uint64(20))}},
+		{"date_range", dateRange, // count, from, count, to, count()
+			[]model.QueryResultCol{col(uint64(1)), col(int64(1700000000)), col(uint64(2)), col(int64(1700000000)), col(uint64(3))},
+			[]model.QueryResultCol{col(uint64(10)), col(int64(1700000000)), col(uint64(20)), col(int64(1700000000)), col(uint64(30))}},
+		{"extended_stats", metrics_aggregations.NewExtendedStats(ctx, 2), // count, min, max, avg, sum, sum_of_squares, variances, std deviations
+			[]model.QueryResultCol{col(uint64(4)), col(1.0), col(3.0), col(2.0), col(8.0), col(18.0), col(0.5), col(0.6), col(0.7), col(0.8)},
+			[]model.QueryResultCol{col(uint64(40)), col(1.0), col(3.0), col(2.0), col(80.0), col(180.0), col(0.5), col(0.6), col(0.7), col(0.8)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &model.Query{Type: tt.queryType, SampleProbability: 0.1}
+			scaled := scaleSampledResults([]*model.Query{query}, [][]model.QueryResultRow{{{Cols: tt.row}}})
+			assert.Equal(t, tt.want, scaled[0][0].Cols)
+		})
+	}
+}
+
+func TestAggregationParserNested(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"name":           {Name: "name", Type: clickhouse.NewBaseType("String")},
+			"products.price": {Name: "products.price", Type: clickhouse.CompoundType{Name: "Array", BaseType: clickhouse.NewBaseType("Float64")}},
+			"products.name":  {Name: "products.name", Type: clickhouse.CompoundType{Name: "Array", BaseType: clickhouse.NewBaseType("String")}},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			tableName: {
+				Fields: map[schema.FieldName]schema.Field{
+					"name":           {PropertyName: "name", InternalPropertyName: "name", Type: schema.TypeKeyword},
+					"products.price": {PropertyName: "products.price", InternalPropertyName: "products.price", Type: schema.TypeFloat.AsArray()},
+					"products.name":  {PropertyName: "products.name", InternalPropertyName: "products.name", Type: schema.TypeKeyword.AsArray()},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name            string
+		aggregationJson string
+		translatedSqls  []string
+	}{
+		{
+			"avg of a field with the path",
+			`{"aggs": {"products": {"nested": {"path": "products"}, "aggs": {"avg_price": {"avg": {"field": "products.price"}}}}}}`,
+			[]string{
+				`SELECT count() FROM ` + tableNameQuoted + ` ARRAY JOIN "products.price"`,
+				`SELECT avgOrNull("products.price") FROM ` + tableNameQuoted + ` ARRAY JOIN "products.price"`,
+			},
+		},
+		{
+			"avg of a field relative to the path",
+			`{"aggs": {"products": {"nested": {"path": "products"}, "aggs": {"avg_price": {"avg": {"field": "price"}}}}}}`,
+			[]string{
+				`SELECT count() FROM ` + tableNameQuoted + ` ARRAY JOIN "products.price"`,
+				`SELECT avgOrNull("products.price") FROM ` + tableNameQuoted + ` ARRAY JOIN "products.price"`,
+			},
+		},
+		{
+			"terms with avg",
+			`{"aggs": {"products": {"nested": {"path": "products"}, "aggs": {
+				"product_names": {"terms": {"field": "products.name"}, "aggs": {"avg_price": {"avg": {"field": "price"}}}}}}}}`,
+			[]string{
+				`SELECT count() FROM ` + tableNameQuoted + ` ARRAY JOIN "products.name", "products.price"`,
+				`SELECT "products.name", avgOrNull("products.price") FROM ` + tableNameQuoted + ` ARRAY JOIN "products.name", "products.price" GROUP BY "products.name" ORDER BY "products.name"`,
+				`SELECT "products.name", count() FROM ` + tableNameQuoted + ` ARRAY JOIN "products.name", "products.price" GROUP BY "products.name" ORDER BY "products.name"`,
+			},
+		},
+		{
+			"parent document field is kept, doc_count counts nested documents",
+			`{"aggs": {"products": {"nested": {"path": "products"}, "aggs": {"names": {"cardinality": {"field": "name"}}}}}}`,
+			[]string{
+				`SELECT count() FROM ` + tableNameQuoted + ` ARRAY JOIN "products.name"`,
+				`SELECT count(DISTINCT "name") FROM ` + tableNameQuoted + ` ARRAY JOIN "products.name"`,
+			},
+		},
+		{
+			"query filters parent documents, subaggregation's filter nested ones",
+			`{"query": {"term": {"name": "quesma"}}, "aggs": {"products": {"nested": {"path": "products"}, "aggs": {
+				"cheap": {"filter": {"range": {"price": {"lt": 10}}}}}}}}`,
+			[]string{
+				`SELECT count() FROM (SELECT * FROM ` + tableNameQuoted + ` WHERE "name"='quesma') ARRAY JOIN "products.price"`,
+				`SELECT count() FROM (SELECT * FROM ` + tableNameQuoted + ` WHERE "name"='quesma') ARRAY JOIN "products.price" WHERE "products.price"<10`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.aggregationJson)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			assert.Len(t, aggregations, len(tt.translatedSqls))
+			for _, aggregation := range aggregations {
+				util.AssertContainsSqlEqual(t, tt.translatedSqls, aggregation.SelectCommand.String())
+			}
+		})
+	}
+}
+
+func TestAggregationParserSamplers(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"author": {Name: "author", Type: clickhouse.NewBaseType("String")},
+			"tags":   {Name: "tags", Type: clickhouse.NewBaseType("String")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	const subaggregation = `"aggs": {"keywords": {"terms": {"field": "tags"}}}`
+	diversifiedFrom := func(maxDocsPerValue, shardSize string) string {
+		return `FROM (SELECT "tags" FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "author") AS "diversified_row_number" ` +
+			`FROM ` + tableNameQuoted + ` WHERE "tags"='elasticsearch') ` +
+			`WHERE "diversified_row_number"<=` + maxDocsPerValue + ` LIMIT ` + shardSize + `)`
+	}
+	tests := []struct {
+		name     string
+		sampler  string
+		wantSqls []string
+	}{
+		{
+			"sampler",
+			`"sampler": {"shard_size": 200}`,
+			[]string{
+				`SELECT "tags", count(), sum(count()) OVER () AS "total_doc_count" FROM (SELECT "tags" FROM ` + tableNameQuoted + ` WHERE "tags"='elasticsearch' LIMIT 200) GROUP BY "tags" ORDER BY count() DESC LIMIT 10`,
+				`SELECT count() FROM (SELECT 1 FROM ` + tableNameQuoted + ` WHERE "tags"='elasticsearch' LIMIT 200)`,
+			},
+		},
+		{
+			"sampler, default shard_size",
+			`"sampler": {}`,
+			[]string{
+				`SELECT "tags", count(), sum(count()) OVER () AS "total_doc_count" FROM (SELECT "tags" FROM ` + tableNameQuoted + ` WHERE "tags"='elasticsearch' LIMIT 100) GROUP BY "tags" ORDER BY count() DESC LIMIT 10`,
+				`SELECT count() FROM (SELECT 1 FROM ` + tableNameQuoted + ` WHERE "tags"='elasticsearch' LIMIT 100)`,
+			},
+		},
+		{
+			"diversified_sampler",
+			`"diversified_sampler": {"field": "author", "shard_size": 200, "max_docs_per_value": 3}`,
+			[]string{
+				`SELECT "tags", count(), sum(count()) OVER () AS "total_doc_count" ` + diversifiedFrom("3", "200") + ` GROUP BY "tags" ORDER BY count() DESC LIMIT 10`,
+				`SELECT count() ` + strings.Replace(diversifiedFrom("3", "200"), `SELECT "tags" FROM (`, `SELECT 1 FROM (`, 1),
+			},
+		},
+		{
+			"diversified_sampler, default shard_size and max_docs_per_value",
+			`"diversified_sampler": {"field": "author"}`,
+			[]string{
+				`SELECT "tags", count(), sum(count()) OVER () AS "total_doc_count" ` + diversifiedFrom("1", "100") + ` GROUP BY "tags" ORDER BY count() DESC LIMIT 10`,
+				`SELECT count() ` + strings.Replace(diversifiedFrom("1", "100"), `SELECT "tags" FROM (`, `SELECT 1 FROM (`, 1),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "query": {"term": {"tags": "elasticsearch"}}, ` +
+				`"aggs": {"sample": {` + tt.sampler + `, ` + subaggregation + `}}}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			assert.Len(t, aggregations, len(tt.wantSqls))
+			for i, wantSql := range tt.wantSqls {
+				util.AssertSqlEqual(t, wantSql, aggregations[i].SelectCommand.String())
+			}
+		})
+	}
+}
+
+func TestAggregationParserTopMetrics(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"host":       {Name: "host", Type: clickhouse.NewBaseType("String")},
+			"price":      {Name: "price", Type: clickhouse.NewBaseType("Float64")},
+			"quantity":   {Name: "quantity", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	t.Run("single metric", func(t *testing.T) {
+		body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"latest": {"top_metrics": {
+			"metrics": {"field": "price"}, "sort": {"@timestamp": {"order": "desc"}}, "size": 3}}}}`)
+		assert.NoError(t, parseErr)
+		aggregations, err := cw.ParseAggregationJson(body)
+		assert.NoError(t, err)
+		if !assert.Len(t, aggregations, 1) {
+			return
+		}
+		util.AssertSqlEqual(t, `SELECT "price", "@timestamp" FROM `+tableNameQuoted+` ORDER BY "@timestamp" DESC LIMIT 3`,
+			aggregations[0].SelectCommand.String())
+
+		rows := []model.QueryResultRow{
+			{Cols: []model.QueryResultCol{model.NewQueryResultCol("price", 10.5), model.NewQueryResultCol("@timestamp", "2024-05-03")}},
+			{Cols: []model.QueryResultCol{model.NewQueryResultCol("price", 7.0), model.NewQueryResultCol("@timestamp", "2024-05-02")}},
+		}
+		response := cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{rows})
+		assert.Equal(t, model.JsonMap{"latest": model.JsonMap{"top": []any{
+			model.JsonMap{"metrics": model.JsonMap{"price": 10.5}, "sort": []any{"2024-05-03"}},
+			model.JsonMap{"metrics": model.JsonMap{"price": 7.0}, "sort": []any{"2024-05-02"}},
+		}}}, response)
+	})
+
+	t.Run("multiple metrics in terms buckets", func(t *testing.T) {
+		body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"hosts": {"terms": {"field": "host"}, "aggs": {"latest": {"top_metrics": {
+			"metrics": [{"field": "price"}, {"field": "quantity"}], "sort": [{"@timestamp": "asc"}], "size": 2}}}}}}`)
+		assert.NoError(t, parseErr)
+		aggregations, err := cw.ParseAggregationJson(body)
+		assert.NoError(t, err)
+		if !assert.Len(t, aggregations, 2) {
+			return
+		}
+		util.AssertSqlEqual(t, `SELECT "host", maxOrNull("price") AS "windowed_price", maxOrNull("quantity") AS "windowed_quantity", `+
+			`maxOrNull("@timestamp") AS "windowed_@timestamp" `+
+			`FROM (SELECT "price", "quantity", "@timestamp", "host", ROW_NUMBER() OVER (PARTITION BY "host" ORDER BY "@timestamp" ASC) AS "row_number" `+
+			`FROM `+tableNameQuoted+`) `+
+			`WHERE "row_number"<=2 `+
+			`GROUP BY "host", "row_number" `+
+			`ORDER BY "host", "row_number" ASC`,
+			aggregations[0].SelectCommand.String())
+
+		topMetricsRows := []model.QueryResultRow{
+			{Cols: []model.QueryResultCol{model.NewQueryResultCol("host", "a"), model.NewQueryResultCol("windowed_price", 1.5),
+				model.NewQueryResultCol("windowed_quantity", int64(3)), model.NewQueryResultCol("windowed_@timestamp", "2024-05-01")}},
+			{Cols: []model.QueryResultCol{model.NewQueryResultCol("host", "a"), model.NewQueryResultCol("windowed_price", 2.5),
+				model.NewQueryResultCol("windowed_quantity", int64(1)), model.NewQueryResultCol("windowed_@timestamp", "2024-05-02")}},
+			{Cols: []model.QueryResultCol{model.NewQueryResultCol("host", "b"), model.NewQueryResultCol("windowed_price", 4.0),
+				model.NewQueryResultCol("windowed_quantity", int64(2)), model.NewQueryResultCol("windowed_@timestamp", "2024-05-03")}},
+		}
+		termsRows := []model.QueryResultRow{
+			{Cols: []model.QueryResultCol{model.NewQueryResultCol("host", "a"), model.NewQueryResultCol("count()", uint64(2))}},
+			{Cols: []model.QueryResultCol{model.NewQueryResultCol("host", "b"), model.NewQueryResultCol("count()", uint64(1))}},
+		}
+		response := cw.MakeAggregationPartOfResponse(aggregations, [][]model.QueryResultRow{topMetricsRows, termsRows})
+		buckets := response["hosts"].(model.JsonMap)["buckets"].([]model.JsonMap)
+		if assert.Len(t, buckets, 2) {
+			assert.Equal(t, []any{
+				model.JsonMap{"metrics": model.JsonMap{"price": 1.5, "quantity": int64(3)}, "sort": []any{"2024-05-01"}},
+				model.JsonMap{"metrics": model.JsonMap{"price": 2.5, "quantity": int64(1)}, "sort": []any{"2024-05-02"}},
+			}, buckets[0]["latest"].(model.JsonMap)["top"])
+			assert.Equal(t, []any{
+				model.JsonMap{"metrics": model.JsonMap{"price": 4.0, "quantity": int64(2)}, "sort": []any{"2024-05-03"}},
+			}, buckets[1]["latest"].(model.JsonMap)["top"])
+		}
+	})
+}
+
+func TestAggregationParserTermsExecutionHint(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"host":    {Name: "host", Type: clickhouse.NewBaseType("LowCardinality(String)")},
+			"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	tests := []struct {
+		name         string
+		field        string
+		hints        string
+		wantStrategy model.GroupByStrategy
+	}{
+		{"no hints", "host", ``, model.GroupByDefault},
+		{"map", "host", `, "execution_hint": "map"`, model.GroupByHash},
+		{"global_ordinals", "host", `, "execution_hint": "global_ordinals", "collect_mode": "breadth_first"`, model.GroupBySorted},
+		{"unknown hints", "host", `, "execution_hint": "fastest", "collect_mode": "random"`, model.GroupByDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"2": {"terms": {"field": "` + tt.field + `"` + tt.hints + `}}}}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			if assert.Len(t, aggregations, 1) {
+				assert.Equal(t, tt.wantStrategy, aggregations[0].GroupByStrategy)
+				// hints never change the query itself
+				util.AssertSqlEqual(t, `SELECT "`+tt.field+`", count(), sum(count()) OVER () AS "total_doc_count" FROM `+tableNameQuoted+` GROUP BY "`+tt.field+`" ORDER BY count() DESC LIMIT 10`,
+					aggregations[0].SelectCommand.String())
+			}
+		})
+	}
+}
+
+func TestAggregationParserDateHistogramCalendarInterval(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	tests := []struct {
+		name        string
+		interval    string
+		wantGroupBy string
+	}{
+		{"calendar month", `"calendar_interval": "1M"`, `toInt64(toUnixTimestamp(toStartOfMonth("@timestamp")))`},
+		{"calendar month, unit name", `"calendar_interval": "month"`, `toInt64(toUnixTimestamp(toStartOfMonth("@timestamp")))`},
+		{"calendar quarter", `"calendar_interval": "quarter"`, `toInt64(toUnixTimestamp(toStartOfQuarter("@timestamp")))`},
+		{"calendar year", `"calendar_interval": "1y"`, `toInt64(toUnixTimestamp(toStartOfYear("@timestamp")))`},
+		{"calendar week", `"calendar_interval": "1w"`, `toInt64(toUnixTimestamp(toStartOfWeek("@timestamp",1)))`},
+		{"calendar day is fixed", `"calendar_interval": "1d"`, `toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000)`},
+		{"fixed", `"fixed_interval": "30d"`, `toInt64(toUnixTimestamp64Milli("@timestamp") / 2592000000)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"2": {"date_histogram": {"field": "@timestamp", ` + tt.interval + `}}}}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			if assert.Len(t, aggregations, 1) {
+				util.AssertSqlEqual(t, `SELECT `+tt.wantGroupBy+`, count() FROM `+tableNameQuoted+` GROUP BY `+tt.wantGroupBy+` ORDER BY `+tt.wantGroupBy,
+					aggregations[0].SelectCommand.String())
+			}
+		})
+	}
+}
+
+func TestAggregationParserHistogramOffset(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"price": {Name: "price", Type: clickhouse.NewBaseType("Float64")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	tests := []struct {
+		name        string
+		histogram   string
+		wantGroupBy string
+	}{
+		{"no offset", `"interval": 10`, `floor("price"/10.000000)*10.000000`},
+		{"offset", `"interval": 10, "offset": 2.5`, `floor(("price"-2.500000)/10.000000)*10.000000+2.500000`},
+		{"offset, interval 1", `"interval": 1, "offset": 0.5`, `floor(("price"-0.500000)/1.000000)*1.000000+0.500000`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"2": {"histogram": {"field": "price", "min_doc_count": 0, ` + tt.histogram + `}}}}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			if assert.Len(t, aggregations, 1) {
+				util.AssertSqlEqual(t, `SELECT `+tt.wantGroupBy+`, count() FROM `+tableNameQuoted+` GROUP BY `+tt.wantGroupBy+` ORDER BY `+tt.wantGroupBy,
+					aggregations[0].SelectCommand.String())
+			}
+		})
+	}
+
+	invalid := []struct {
+		histogram string
+		wantErr   error
+	}{
+		{`"interval": 0`, quesma_errors.ErrCouldNotParseRequest()},
+		{`"interval": -5`, quesma_errors.ErrCouldNotParseRequest()},
+		{`"interval": "abc"`, quesma_errors.ErrCouldNotParseRequest()},
+		{`"interval": 1, "extended_bounds": {"min": 0, "max": 100000}`, quesma_errors.ErrTooManyBuckets()},
+	}
+	for _, tt := range invalid {
+		body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"2": {"histogram": {"field": "price", "min_doc_count": 0, ` + tt.histogram + `}}}}`)
+		assert.NoError(t, parseErr)
+		_, err := cw.ParseAggregationJson(body)
+		assert.ErrorIs(t, err, tt.wantErr, tt.histogram)
+	}
+}
+
+func TestAggregationParserVariableWidthHistogram(t *testing.T) {
+	table := clickhouse.Table{
+		Cols: map[string]*clickhouse.Column{
+			"bytes": {Name: "bytes", Type: clickhouse.NewBaseType("Int64")},
+			"host":  {Name: "host", Type: clickhouse.NewBaseType("String")},
+		},
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+	}
+	lm := clickhouse.NewLogManager(concurrent.NewMapWith(tableName, &table), config.QuesmaConfiguration{})
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	const bucketIndex = `arrayCount((edge) -> "edge"<="bytes","variable_width_histogram_edges")`
+	tests := []struct {
+		name    string
+		aggs    string
+		wantSql string
+	}{
+		{
+			name: "4 buckets",
+			aggs: `{"2": {"variable_width_histogram": {"field": "bytes", "buckets": 4}}}`,
+			wantSql: `SELECT ` + bucketIndex + `, count(), minOrNull("bytes"), maxOrNull("bytes"), avgOrNull("bytes") ` +
+				`FROM (SELECT *, quantiles(0.250000, 0.500000, 0.750000)("bytes") OVER () AS "variable_width_histogram_edges" ` +
+				`FROM ` + tableNameQuoted + ` WHERE ("host"='a' AND "bytes" IS NOT NULL)) ` +
+				`GROUP BY ` + bucketIndex + ` ORDER BY ` + bucketIndex,
+		},
+		{
+			name: "3 buckets in terms, edges computed separately in each parent bucket",
+			aggs: `{"hosts": {"terms": {"field": "host"}, "aggs": {"2": {"variable_width_histogram": {"field": "bytes", "buckets": 3}}}}}`,
+			wantSql: `SELECT "host", ` + bucketIndex + `, count(), minOrNull("bytes"), maxOrNull("bytes"), avgOrNull("bytes") ` +
+				`FROM (SELECT *, quantiles(0.333333, 0.666667)("bytes") OVER (PARTITION BY "host") AS "variable_width_histogram_edges" ` +
+				`FROM ` + tableNameQuoted + ` WHERE ("host"='a' AND "bytes" IS NOT NULL)) ` +
+				`GROUP BY "host", ` + bucketIndex + ` ORDER BY "host", ` + bucketIndex,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"size": 0, "query": {"term": {"host": "a"}}, "aggs": ` + tt.aggs + `}`)
+			assert.NoError(t, parseErr)
+			aggregations, err := cw.ParseAggregationJson(body)
+			assert.NoError(t, err)
+			i := slices.IndexFunc(aggregations, func(query *model.Query) bool {
+				_, ok := query.Type.(bucket_aggregations.VariableWidthHistogram)
+				return ok
+			})
+			if assert.NotEqual(t, -1, i) {
+				util.AssertSqlEqual(t, tt.wantSql, aggregations[i].SelectCommand.String())
+			}
+		})
+	}
+
+	for _, buckets := range []string{"1", "0", "-3"} {
+		body, parseErr := types.ParseJSON(`{"size": 0, "aggs": {"2": {"variable_width_histogram": {"field": "bytes", "buckets": ` + buckets + `}}}}`)
+		assert.NoError(t, parseErr)
+		_, err := cw.ParseAggregationJson(body)
+		assert.ErrorIs(t, err, quesma_errors.ErrCouldNotParseRequest(), buckets)
+	}
+}
+
+func TestAggregationParserSerialDiffLag(t *testing.T) {
+	cw := ClickhouseQueryTranslator{Ctx: context.Background()}
+	tests := []struct {
+		name    string
+		lag     string
+		wantLag int // 0 <=> invalid, aggregation not parsed
+	}{
+		{"default lag", ``, 1},
+		{"lag=7", `, "lag": 7`, 7},
+		{"lag=0", `, "lag": 0`, 0},
+		{"negative lag", `, "lag": -2`, 0},
+		{"non-integer lag", `, "lag": 1.5`, 0},
+		{"string lag", `, "lag": "2"`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"serial_diff": {"buckets_path": "1-metric"` + tt.lag + `}}`)
+			assert.NoError(t, parseErr)
+			queryMap := QueryMap(body)
+			aggregationType, success := cw.parsePipelineAggregations(queryMap)
+			assert.Equal(t, tt.wantLag != 0, success)
+			if tt.wantLag != 0 {
+				assert.Equal(t, pipeline_aggregations.NewSerialDiff(cw.Ctx, "1-metric", tt.wantLag), aggregationType)
+				assert.NotContains(t, queryMap, "serial_diff")
+			}
+		})
+	}
+}
+
 // Used in tests to make processing `aggregations` in a deterministic way
 func sortAggregations(aggregations []*model.Query) {
 	slices.SortFunc(aggregations, func(a, b *model.Query) int {
@@ -22,6 +22,24 @@ func BadRequestParseError(err error) []byte {
 	return serialized
 }
 
+func IndexNotFoundError(err error) []byte {
+	serialized, _ := json.Marshal(DashboardErrorResponse{
+		Error: Error{
+			RootCause: []RootCause{
+				{
+					Type:   "index_not_found_exception",
+					Reason: err.Error(),
+				},
+			},
+			Type:   "index_not_found_exception",
+			Reason: err.Error(),
+		},
+		Status: 404,
+	},
+	)
+	return serialized
+}
+
 func InternalQuesmaError(msg string) []byte {
 	serialized, _ := json.Marshal(DashboardErrorResponse{
 		Error: Error{
@@ -40,6 +58,24 @@ func InternalQuesmaError(msg string) []byte {
 	return serialized
 }
 
+func ScrollNotFoundError(err error) []byte {
+	serialized, _ := json.Marshal(DashboardErrorResponse{
+		Error: Error{
+			RootCause: []RootCause{
+				{
+					Type:   "search_context_missing_exception",
+					Reason: err.Error(),
+				},
+			},
+			Type:   "search_phase_execution_exception",
+			Reason: "all shards failed",
+		},
+		Status: 404,
+	},
+	)
+	return serialized
+}
+
 type (
 	DashboardErrorResponse struct {
 		Error  `json:"error"`
@@ -59,3 +95,57 @@ type (
 		Col    *int   `json:"col,omitempty"`
 	}
 )
+
+func PointInTimeNotFoundError(err error) []byte {
+	serialized, _ := json.Marshal(DashboardErrorResponse{
+		Error: Error{
+			RootCause: []RootCause{
+				{
+					Type:   "search_context_missing_exception",
+					Reason: err.Error(),
+				},
+			},
+			Type:   "search_phase_execution_exception",
+			Reason: "all shards failed",
+		},
+		Status: 404,
+	},
+	)
+	return serialized
+}
+
+func ResultWindowTooLargeError(err error) []byte {
+	serialized, _ := json.Marshal(DashboardErrorResponse{
+		Error: Error{
+			RootCause: []RootCause{
+				{
+					Type:   "illegal_argument_exception",
+					Reason: err.Error(),
+				},
+			},
+			Type:   "search_phase_execution_exception",
+			Reason: "all shards failed",
+		},
+		Status: 400,
+	},
+	)
+	return serialized
+}
+
+func TooManyBucketsError(err error) []byte {
+	serialized, _ := json.Marshal(DashboardErrorResponse{
+		Error: Error{
+			RootCause: []RootCause{
+				{
+					Type:   "too_many_buckets_exception",
+					Reason: err.Error(),
+				},
+			},
+			Type:   "search_phase_execution_exception",
+			Reason: "all shards failed",
+		},
+		Status: 400,
+	},
+	)
+	return serialized
+}
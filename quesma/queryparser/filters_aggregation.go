@@ -7,6 +7,8 @@ import (
 	"quesma/logger"
 	"quesma/model"
 	"quesma/model/bucket_aggregations"
+	"slices"
+	"strconv"
 )
 
 func (cw *ClickhouseQueryTranslator) parseFilters(queryMap QueryMap) (success bool, filtersAggr bucket_aggregations.Filters) {
@@ -27,28 +29,92 @@ func (cw *ClickhouseQueryTranslator) parseFilters(queryMap QueryMap) (success bo
 		logger.WarnWithCtx(cw.Ctx).Msgf("filters is not a map, but %T, value: %v. Skipping filters.", filtersRaw, filtersRaw)
 		return
 	}
-	nestedMap, ok := nested.(QueryMap)
-	if !ok {
-		logger.WarnWithCtx(cw.Ctx).Msgf("filters is not a map, but %T, value: %v. Skipping filters.", nested, nested)
+
+	var filters []bucket_aggregations.Filter
+	anonymous := false
+	switch nestedTyped := nested.(type) {
+	case QueryMap:
+		// Elastic returns named filters sorted by their names
+		names := make([]string, 0, len(nestedTyped))
+		for name := range nestedTyped {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		filters = make([]bucket_aggregations.Filter, 0, len(nestedTyped))
+		for _, name := range names {
+			filterMap, ok := nestedTyped[name].(QueryMap)
+			if !ok {
+				logger.WarnWithCtx(cw.Ctx).Msgf("filter is not a map, but %T, value: %v. Skipping.", nestedTyped[name], nestedTyped[name])
+				continue
+			}
+			filters = append(filters, bucket_aggregations.NewFilter(name, cw.parseQueryMap(filterMap)))
+		}
+	case []any:
+		// anonymous filters, we name them by their position
+		anonymous = true
+		filters = make([]bucket_aggregations.Filter, 0, len(nestedTyped))
+		for i, filter := range nestedTyped {
+			filterMap, ok := filter.(QueryMap)
+			if !ok {
+				logger.WarnWithCtx(cw.Ctx).Msgf("filter is not a map, but %T, value: %v. Skipping.", filter, filter)
+				continue
+			}
+			filters = append(filters, bucket_aggregations.NewFilter(strconv.Itoa(i), cw.parseQueryMap(filterMap)))
+		}
+	default:
+		logger.WarnWithCtx(cw.Ctx).Msgf("filters is not a map nor an array, but %T, value: %v. Skipping filters.", nested, nested)
 		return
 	}
 
-	filters := make([]bucket_aggregations.Filter, 0, len(nestedMap))
-	for name, filter := range nestedMap {
-		filterMap, ok := filter.(QueryMap)
-		if !ok {
-			logger.WarnWithCtx(cw.Ctx).Msgf("filter is not a map, but %T, value: %v. Skipping.", filter, filter)
-			continue
+	keyed := true
+	if keyedRaw, exists := filtersMap["keyed"]; exists {
+		if keyed, ok = keyedRaw.(bool); !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("keyed is not a bool, but %T, value: %v. Using default: true.", keyedRaw, keyedRaw)
+			keyed = true
 		}
-		filters = append(filters, bucket_aggregations.NewFilter(name, cw.parseQueryMap(filterMap)))
 	}
-	return true, bucket_aggregations.NewFilters(cw.Ctx, filters)
+
+	// other_bucket_key implies other_bucket: true, same as in Elastic
+	otherBucket := false
+	if otherBucketRaw, exists := filtersMap["other_bucket"]; exists {
+		if otherBucket, ok = otherBucketRaw.(bool); !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("other_bucket is not a bool, but %T, value: %v. Using default: false.", otherBucketRaw, otherBucketRaw)
+			otherBucket = false
+		}
+	}
+	otherBucketKey := bucket_aggregations.DefaultOtherBucketKey
+	if otherBucketKeyRaw, exists := filtersMap["other_bucket_key"]; exists {
+		if otherBucketKey, ok = otherBucketKeyRaw.(string); ok {
+			otherBucket = true
+		} else {
+			logger.WarnWithCtx(cw.Ctx).Msgf("other_bucket_key is not a string, but %T, value: %v. Using default: %s.",
+				otherBucketKeyRaw, otherBucketKeyRaw, bucket_aggregations.DefaultOtherBucketKey)
+			otherBucketKey = bucket_aggregations.DefaultOtherBucketKey
+		}
+	}
+	if otherBucket {
+		if anonymous {
+			otherBucketKey = strconv.Itoa(len(filters))
+		}
+		filters = append(filters, bucket_aggregations.NewOtherBucketFilter(otherBucketKey, filters))
+	}
+
+	return true, bucket_aggregations.NewFilters(cw.Ctx, filters, keyed, anonymous)
 }
 
 func (cw *ClickhouseQueryTranslator) processFiltersAggregation(aggrBuilder *aggrQueryBuilder,
 	aggr bucket_aggregations.Filters, queryMap QueryMap, resultAccumulator *[]*model.Query) error {
 	whereBeforeNesting := aggrBuilder.whereBuilder
-	aggrBuilder.Aggregators[len(aggrBuilder.Aggregators)-1].Filters = true
+	filtersAggregator := &aggrBuilder.Aggregators[len(aggrBuilder.Aggregators)-1]
+	filtersAggregator.Filters = true
+	filtersAggregator.Keyed = aggr.Keyed
+	if !aggr.Keyed {
+		filtersAggregator.FilterNames = make([]string, 0, len(aggr.Filters))
+		for _, filter := range aggr.Filters {
+			filtersAggregator.FilterNames = append(filtersAggregator.FilterNames, filter.Name)
+		}
+		filtersAggregator.AnonymousFilters = aggr.Anonymous
+	}
 	for _, filter := range aggr.Filters {
 		// newBuilder := aggrBuilder.clone()
 		// newBuilder.Type = bucket_aggregations.NewFilters(cw.Ctx, []bucket_aggregations.Filter{filter})
@@ -61,9 +127,7 @@ func (cw *ClickhouseQueryTranslator) processFiltersAggregation(aggrBuilder *aggr
 		if aggs, ok := queryMap["aggs"].(QueryMap); ok {
 			aggsCopy, errAggs := deepcopy.Anything(aggs)
 			if errAggs == nil {
-				//err := cw.parseAggregationNames(newBuilder, aggsCopy.(QueryMap), resultAccumulator)
-				err := cw.parseAggregationNames(aggrBuilder, aggsCopy.(QueryMap), resultAccumulator)
-				if err != nil {
+				if err := cw.parseAggregationNames(aggrBuilder, aggsCopy.(QueryMap), resultAccumulator); err != nil {
 					return err
 				}
 			} else {
@@ -0,0 +1,117 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package queryparser
+
+import (
+	"quesma/model"
+	"quesma/schema"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// nestedFieldKeyedQueries are queries, which have field names as keys, e.g. {"term": {"field": "value"}}
+var nestedFieldKeyedQueries = []string{"term", "terms", "range", "match", "match_phrase", "prefix", "wildcard", "regexp"}
+
+// rewriteNestedAggregationFields makes fields in subaggregations of `nested` aggregation with `path` refer to
+// flattened columns of nested documents. We store nested documents flattened into dotted columns (e.g. `products.price`),
+// so the `nested` context is only needed to resolve fields relative to `path` (e.g. `price`) as `path.price`.
+// Fields, which already have the path prefix, exist as they are (e.g. parent document fields), or don't exist under
+// the path, are kept as they are.
+// Returns the fields of nested documents (with the path prefix) referenced in subaggregations.
+func (cw *ClickhouseQueryTranslator) rewriteNestedAggregationFields(path string, aggs QueryMap) (nestedFields []string) {
+	prefix := nestedPathPrefix(path)
+	rewriteField := func(field string) string {
+		field = cw.nestedFieldName(prefix, field)
+		if strings.HasPrefix(field, prefix) {
+			nestedFields = append(nestedFields, field)
+		}
+		return field
+	}
+	var rewrite func(node any)
+	rewrite = func(node any) {
+		switch nodeTyped := node.(type) {
+		case QueryMap:
+			for key, value := range nodeTyped {
+				if field, ok := value.(string); ok && key == "field" {
+					nodeTyped[key] = rewriteField(field)
+				} else if fieldsQuery, ok := value.(QueryMap); ok && slices.Contains(nestedFieldKeyedQueries, key) && fieldsQuery["field"] == nil {
+					// e.g. {"range": {"price": {"lt": 10}}} in a filter subaggregation, but not {"range": {"field": "price", ...}} aggregation
+					rewritten := make(QueryMap, len(fieldsQuery))
+					for field, fieldQuery := range fieldsQuery {
+						rewritten[rewriteField(field)] = fieldQuery
+					}
+					nodeTyped[key] = rewritten
+				} else {
+					rewrite(value)
+				}
+			}
+		case []any:
+			for _, element := range nodeTyped {
+				rewrite(element)
+			}
+		}
+	}
+	rewrite(aggs)
+	return nestedFields
+}
+
+// nestedArrayJoin returns array columns of nested documents with `path` to ARRAY JOIN, so that there's one row for
+// every nested document, not for every parent document: those of `nestedFields`, or, if none of them is an array
+// (e.g. only doc_count is needed), the first array column under the path.
+// We don't join all of them, as ARRAY JOIN of multiple arrays needs them to be of equal sizes.
+func (cw *ClickhouseQueryTranslator) nestedArrayJoin(path string, nestedFields []string) (arrayJoin []model.Expr) {
+	columns := make(map[string]struct{})
+	for _, field := range nestedFields {
+		if column := cw.ResolveField(cw.Ctx, field); cw.Table.IsArray(column) {
+			columns[column] = struct{}{}
+		}
+	}
+	if len(columns) == 0 && cw.SchemaRegistry != nil {
+		prefix := nestedPathPrefix(path)
+		if schemaInstance, exists := cw.SchemaRegistry.FindSchema(schema.TableName(cw.Table.Name)); exists {
+			var candidates []string
+			for fieldName, field := range schemaInstance.Fields {
+				if column := field.InternalPropertyName.AsString(); strings.HasPrefix(fieldName.AsString(), prefix) && cw.Table.IsArray(column) {
+					candidates = append(candidates, column)
+				}
+			}
+			if len(candidates) > 0 {
+				sort.Strings(candidates)
+				columns[candidates[0]] = struct{}{}
+			}
+		}
+	}
+
+	sortedColumns := make([]string, 0, len(columns))
+	for column := range columns {
+		sortedColumns = append(sortedColumns, column)
+	}
+	sort.Strings(sortedColumns)
+	for _, column := range sortedColumns {
+		arrayJoin = append(arrayJoin, model.NewColumnRef(column))
+	}
+	return arrayJoin
+}
+
+func nestedPathPrefix(path string) string {
+	return strings.TrimSuffix(path, ".") + "."
+}
+
+// nestedFieldName returns `field` of a nested document with `prefix` (path with a trailing dot) as a flattened field name
+func (cw *ClickhouseQueryTranslator) nestedFieldName(prefix, field string) string {
+	if strings.HasPrefix(field, prefix) || cw.SchemaRegistry == nil {
+		return field
+	}
+	schemaInstance, exists := cw.SchemaRegistry.FindSchema(schema.TableName(cw.Table.Name))
+	if !exists {
+		return field
+	}
+	if _, ok := schemaInstance.ResolveField(field); ok {
+		return field
+	}
+	if _, ok := schemaInstance.ResolveField(prefix + field); ok {
+		return prefix + field
+	}
+	return field
+}
@@ -5,7 +5,11 @@ package queryparser
 import (
 	"quesma/logger"
 	"quesma/model"
+	"quesma/model/bucket_aggregations"
 	"quesma/model/pipeline_aggregations"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // CAUTION: maybe "return" everywhere isn't corrent, as maybe there can be multiple pipeline aggregations at one level.
@@ -24,7 +28,7 @@ func (cw *ClickhouseQueryTranslator) parsePipelineAggregations(queryMap QueryMap
 		return
 	}
 	if aggregationType, success = cw.parseSerialDiff(queryMap); success {
-		delete(queryMap, "derivative")
+		delete(queryMap, "serial_diff")
 		return
 	}
 	if aggregationType, success = cw.parseAverageBucket(queryMap); success {
@@ -67,7 +71,35 @@ func (cw *ClickhouseQueryTranslator) parseDerivative(queryMap QueryMap) (aggrega
 	if !ok {
 		return
 	}
-	return pipeline_aggregations.NewDerivative(cw.Ctx, bucketsPath), true
+
+	// unit (optional)
+	var unit time.Duration
+	if unitRaw, exists := derivativeRaw.(QueryMap)["unit"]; exists {
+		if unitAsString, ok := unitRaw.(string); ok {
+			if unit, ok = parseDerivativeUnit(unitAsString); !ok {
+				logger.WarnWithCtx(cw.Ctx).Msgf("unsupported derivative unit: %s. Not normalizing", unitAsString)
+			}
+		} else {
+			logger.WarnWithCtx(cw.Ctx).Msgf("unit is not a string, but %T, value: %v. Not normalizing", unitRaw, unitRaw)
+		}
+	}
+	return pipeline_aggregations.NewDerivative(cw.Ctx, bucketsPath, unit), true
+}
+
+// parseDerivativeUnit parses derivative's "unit", e.g. "second", "1m", "10s".
+// Calendar units of variable length (month, quarter, year) aren't supported.
+func parseDerivativeUnit(unit string) (duration time.Duration, ok bool) {
+	unitNames := map[string]string{"second": "1s", "minute": "1m", "hour": "1h", "day": "1d", "week": "1w"}
+	if shortUnit, isName := unitNames[unit]; isName {
+		unit = shortUnit
+	}
+	unitDurations := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unitDuration := range unitDurations {
+		if multiplier, err := strconv.Atoi(strings.TrimSuffix(unit, suffix)); err == nil && strings.HasSuffix(unit, suffix) && multiplier > 0 {
+			return time.Duration(multiplier) * unitDuration, true
+		}
+	}
+	return 0, false
 }
 
 func (cw *ClickhouseQueryTranslator) parseAverageBucket(queryMap QueryMap) (aggregationType model.QueryType, success bool) {
@@ -141,12 +173,17 @@ func (cw *ClickhouseQueryTranslator) parseSerialDiff(queryMap QueryMap) (aggrega
 	if !exists {
 		return pipeline_aggregations.NewSerialDiff(cw.Ctx, bucketsPath, defaultLag), true
 	}
-	if lag, ok := lagRaw.(float64); ok {
-		return pipeline_aggregations.NewSerialDiff(cw.Ctx, bucketsPath, int(lag)), true
+	lag, ok := lagRaw.(float64)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("lag is not a float64, but %T, value: %v", lagRaw, lagRaw)
+		return
 	}
-
-	logger.WarnWithCtx(cw.Ctx).Msgf("lag is not a float64, but %T, value: %v", lagRaw, lagRaw)
-	return
+	// like in Elastic, lag must be a positive integer
+	if lag < 1 || lag != float64(int(lag)) {
+		logger.WarnWithCtx(cw.Ctx).Msgf("lag must be a positive integer, but is %v. Skipping this aggregation", lag)
+		return
+	}
+	return pipeline_aggregations.NewSerialDiff(cw.Ctx, bucketsPath, int(lag)), true
 }
 
 func (cw *ClickhouseQueryTranslator) parseBucketScriptBasic(queryMap QueryMap) (aggregationType model.QueryType, success bool) {
@@ -241,6 +278,15 @@ func (b *aggrQueryBuilder) finishBuildingAggregationPipeline(aggregationType mod
 		}
 	case pipeline_aggregations.Derivative:
 		query.NoDBQuery = true
+		if aggrType.Unit != 0 {
+			// b.Type is still the type of the parent bucket aggregation
+			if dateHistogram, ok := b.Type.(bucket_aggregations.DateHistogram); ok && dateHistogram.IntervalAsDuration() != 0 {
+				aggrType.BucketInterval = dateHistogram.IntervalAsDuration()
+				query.Type = aggrType
+			} else {
+				logger.WarnWithCtx(b.ctx).Msgf("derivative with unit, but parent isn't a date_histogram with fixed interval (%v). Not normalizing", b.Type)
+			}
+		}
 		if aggrType.IsCount {
 			query.SelectCommand.Columns = append(query.SelectCommand.Columns, model.NewCountFunc())
 			if len(query.Aggregators) < 2 {
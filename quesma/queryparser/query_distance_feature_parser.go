@@ -0,0 +1,94 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package queryparser
+
+import (
+	"quesma/clickhouse"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/schema"
+	"strconv"
+)
+
+// parseDistanceFeature translates `distance_feature` query. It boosts documents nearer to `origin`, but it doesn't
+// filter any, and as we don't compute relevance scores, it only orders hits by distance to `origin` (ascending):
+//   - abs(dateDiff('millisecond',origin,field)) for date fields,
+//   - abs(field-origin) for numeric fields,
+//   - greatCircleDistance(field::lon,field::lat,originLon,originLat) for geo points.
+//
+// `pivot` only scales the score, so it doesn't change the order, and we ignore it.
+func (cw *ClickhouseQueryTranslator) parseDistanceFeature(queryMap QueryMap) model.SimpleQuery {
+	fieldName, ok := queryMap["field"].(string)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no field or invalid field in distance_feature query: %v", queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+	origin, ok := queryMap["origin"]
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no origin in distance_feature query: %v", queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+	field := cw.ResolveField(cw.Ctx, fieldName)
+
+	var distance model.Expr
+	if cw.isGeoPoint(fieldName) {
+		lon, lat, ok := parseGeoPoint(origin)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid origin %v in distance_feature query: %v", origin, queryMap)
+			return model.NewSimpleQuery(nil, false)
+		}
+		// TODO suffixes ::lat, ::lon are hardcoded for now
+		distance = model.NewFunction("greatCircleDistance", model.NewColumnRef(field+"::lon"), model.NewColumnRef(field+"::lat"),
+			model.NewLiteral(lon), model.NewLiteral(lat))
+	} else if cw.Table.GetDateTimeType(cw.Ctx, field) != clickhouse.Invalid {
+		originDate, ok := cw.distanceFeatureDateOrigin(field, origin)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid origin %v in distance_feature query: %v", origin, queryMap)
+			return model.NewSimpleQuery(nil, false)
+		}
+		dateDiff := model.NewFunction("dateDiff", model.NewLiteral("'millisecond'"), originDate, model.NewColumnRef(field))
+		distance = model.NewFunction("abs", dateDiff)
+	} else if originNumber, ok := origin.(float64); ok {
+		difference := model.NewInfixExpr(model.NewColumnRef(field), "-", model.NewLiteral(strconv.FormatFloat(originNumber, 'f', -1, 64)))
+		distance = model.NewFunction("abs", difference)
+	} else {
+		logger.WarnWithCtx(cw.Ctx).Msgf("invalid origin %v for field %s in distance_feature query: %v", origin, field, queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+
+	query := model.NewSimpleQuery(nil, true)
+	query.OrderBy = []model.OrderByExpr{model.NewOrderByExpr([]model.Expr{distance}, model.AscOrder)}
+	return query
+}
+
+// distanceFeatureDateOrigin returns `origin` of distance_feature query on date `field`: a date or a date math expression, like now-1d
+func (cw *ClickhouseQueryTranslator) distanceFeatureDateOrigin(field string, origin any) (model.Expr, bool) {
+	originStr, ok := origin.(string)
+	if !ok {
+		return nil, false
+	}
+	if isDateMathExpression(originStr) {
+		sql, err := cw.parseDateMathExpression(originStr)
+		if err != nil {
+			return nil, false
+		}
+		return model.NewLiteral(sql), true
+	}
+	_, timeFormatFuncName := cw.parseDateTimeString(cw.Table, field, originStr)
+	if timeFormatFuncName == "" {
+		return nil, false
+	}
+	return model.NewFunction(timeFormatFuncName, model.NewLiteral("'"+originStr+"'")), true
+}
+
+func (cw *ClickhouseQueryTranslator) isGeoPoint(fieldName string) bool {
+	if cw.SchemaRegistry == nil {
+		return false
+	}
+	schemaInstance, exists := cw.SchemaRegistry.FindSchema(schema.TableName(cw.Table.Name))
+	if !exists {
+		return false
+	}
+	field, ok := schemaInstance.Fields[schema.FieldName(fieldName)]
+	return ok && field.Type.Equal(schema.TypePoint)
+}
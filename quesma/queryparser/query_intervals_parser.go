@@ -0,0 +1,170 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package queryparser
+
+import (
+	"quesma/logger"
+	"quesma/model"
+	"strconv"
+	"strings"
+)
+
+// parseIntervals translates `intervals` query approximately, as we don't index term positions:
+//   - `match` requires all its terms (in order, if `ordered`),
+//   - `all_of` requires all its intervals (in order, if `ordered` and they're all plain phrases),
+//   - `any_of` requires any of its intervals.
+//
+// Order is checked with positionCaseInsensitive: each phrase must occur after the end of the previous one.
+// `max_gaps` is only respected, when it's 0: then consecutive phrases must be separated by a single space.
+func (cw *ClickhouseQueryTranslator) parseIntervals(queryMap QueryMap) model.SimpleQuery {
+	if len(queryMap) != 1 {
+		logger.WarnWithCtx(cw.Ctx).Msgf("we expect only 1 field in intervals query, got: %d. value: %v", len(queryMap), queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+	for fieldName, rule := range queryMap {
+		ruleAsMap, ok := rule.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid intervals rule type: %T, value: %v", rule, rule)
+			return model.NewSimpleQuery(nil, false)
+		}
+		stmt, ok := cw.parseIntervalsRule(cw.ResolveField(cw.Ctx, fieldName), ruleAsMap)
+		return model.NewSimpleQuery(stmt, ok)
+	}
+
+	// unreachable unless something really weird happens
+	logger.ErrorWithCtx(cw.Ctx).Msg("theoretically unreachable code")
+	return model.NewSimpleQuery(nil, false)
+}
+
+func (cw *ClickhouseQueryTranslator) parseIntervalsRule(field string, rule QueryMap) (model.Expr, bool) {
+	if len(rule) != 1 {
+		logger.WarnWithCtx(cw.Ctx).Msgf("we expect only 1 intervals rule, got: %d. value: %v", len(rule), rule)
+		return nil, false
+	}
+	for ruleType, params := range rule {
+		paramsAsMap, ok := params.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid %s intervals rule type: %T, value: %v", ruleType, params, params)
+			return nil, false
+		}
+		switch ruleType {
+		case "match":
+			phrases, ok := cw.intervalsMatchPhrases(paramsAsMap)
+			if !ok {
+				return nil, false
+			}
+			return cw.intervalsPhrases(field, phrases, paramsAsMap), true
+		case "all_of", "any_of":
+			return cw.parseIntervalsCombination(field, ruleType, paramsAsMap)
+		default:
+			logger.WarnWithCtxAndReason(cw.Ctx, logger.ReasonUnsupportedQuery("intervals "+ruleType)).
+				Msgf("unsupported intervals rule: %s, value: %v", ruleType, params)
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// parseIntervalsCombination translates `all_of` and `any_of` rules
+func (cw *ClickhouseQueryTranslator) parseIntervalsCombination(field, ruleType string, params QueryMap) (model.Expr, bool) {
+	intervals, ok := params["intervals"].([]interface{})
+	if !ok || len(intervals) == 0 {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no intervals in %s intervals rule: %v", ruleType, params)
+		return nil, false
+	}
+	rules := make([]QueryMap, 0, len(intervals))
+	for _, interval := range intervals {
+		intervalAsMap, ok := interval.(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid interval type: %T, value: %v", interval, interval)
+			return nil, false
+		}
+		rules = append(rules, intervalAsMap)
+	}
+
+	if ordered, _ := params["ordered"].(bool); ruleType == "all_of" && ordered {
+		// order of matches, which are plain phrases, can be checked all together
+		var phrases []string
+		for _, rule := range rules {
+			match, isMatch := rule["match"].(QueryMap)
+			if !isMatch || len(rule) != 1 || !isIntervalsPhrase(match) {
+				phrases = nil
+				break
+			}
+			matchPhrases, ok := cw.intervalsMatchPhrases(match)
+			if !ok {
+				return nil, false
+			}
+			phrases = append(phrases, matchPhrases...)
+		}
+		if phrases != nil {
+			return cw.intervalsPhrases(field, phrases, params), true
+		}
+		logger.WarnWithCtx(cw.Ctx).Msgf("ordered all_of intervals rule with not only phrases, not checking order: %v", params)
+	}
+
+	stmts := make([]model.Expr, 0, len(rules))
+	for _, rule := range rules {
+		stmt, ok := cw.parseIntervalsRule(field, rule)
+		if !ok {
+			return nil, false
+		}
+		stmts = append(stmts, stmt)
+	}
+	if ruleType == "any_of" {
+		return model.Or(stmts), true
+	}
+	return model.And(stmts), true
+}
+
+// intervalsMatchPhrases returns what must occur for `match` intervals rule: its whole query if it's a phrase,
+// or its terms otherwise
+func (cw *ClickhouseQueryTranslator) intervalsMatchPhrases(match QueryMap) ([]string, bool) {
+	query, ok := match["query"].(string)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no query or invalid query in match intervals rule: %v", match)
+		return nil, false
+	}
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		logger.WarnWithCtx(cw.Ctx).Msgf("empty query in match intervals rule: %v", match)
+		return nil, false
+	}
+	if isIntervalsPhrase(match) {
+		return []string{strings.Join(terms, " ")}, true
+	}
+	return terms, true
+}
+
+// isIntervalsPhrase returns true <=> `match` intervals rule matches its terms next to each other, in order
+func isIntervalsPhrase(match QueryMap) bool {
+	query, _ := match["query"].(string)
+	ordered, _ := match["ordered"].(bool)
+	maxGaps, hasMaxGaps := match["max_gaps"].(float64)
+	return len(strings.Fields(query)) == 1 || (ordered && hasMaxGaps && maxGaps == 0)
+}
+
+// intervalsPhrases returns a condition, that all `phrases` occur in `field`. If params say `ordered`, they must occur
+// in this order, and with `max_gaps` 0, also one right after another.
+func (cw *ClickhouseQueryTranslator) intervalsPhrases(field string, phrases []string, params QueryMap) model.Expr {
+	ordered, _ := params["ordered"].(bool)
+	if maxGaps, ok := params["max_gaps"].(float64); ok && maxGaps == 0 && ordered {
+		phrases = []string{strings.Join(phrases, " ")}
+	}
+
+	column := model.NewColumnRef(field)
+	stmts := make([]model.Expr, 0, len(phrases)+1)
+	for _, phrase := range phrases {
+		stmts = append(stmts, model.NewInfixExpr(column, "iLIKE", model.NewLiteral("'%"+phrase+"%'")))
+	}
+	if ordered && len(phrases) > 1 {
+		// position of the 1st phrase, then of the 2nd one after the end of the 1st one, ...
+		var position model.Expr = model.NewFunction("positionCaseInsensitive", column, model.NewLiteral("'"+phrases[0]+"'"))
+		for i := 1; i < len(phrases); i++ {
+			after := model.NewInfixExpr(position, "+", model.NewLiteral(strconv.Itoa(len(phrases[i-1]))))
+			position = model.NewFunction("positionCaseInsensitive", column, model.NewLiteral("'"+phrases[i]+"'"), after)
+		}
+		stmts = append(stmts, model.NewInfixExpr(position, ">", model.NewLiteral("0")))
+	}
+	return model.And(stmts)
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"quesma/clickhouse"
+	"quesma/index"
 	"quesma/logger"
 	"quesma/model"
 	"quesma/model/typical_queries"
@@ -16,6 +17,7 @@ import (
 	"quesma/quesma/types"
 	"quesma/schema"
 	"quesma/util"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -57,33 +59,78 @@ func (cw *ClickhouseQueryTranslator) ParseQuery(body types.JSON) ([]*model.Query
 		aggregationQueries, err := cw.ParseAggregationJson(body)
 		if err != nil {
 			logger.WarnWithCtx(cw.Ctx).Msgf("error parsing aggregation: %v", err)
+			return nil, false, err
 		}
-		if aggregationQueries != nil {
-			queries = append(queries, aggregationQueries...)
-		}
+		queries = append(queries, aggregationQueries...)
 	}
 	if listQuery := cw.buildListQueryIfNeeded(simpleQuery, queryInfo, highlighter); listQuery != nil {
 		queries = append(queries, listQuery)
 	}
 
-	return queries, true, err
+	return queries, true, nil
 }
 
 func (cw *ClickhouseQueryTranslator) buildListQueryIfNeeded(
 	simpleQuery *model.SimpleQuery, queryInfo model.SearchQueryInfo, highlighter model.Highlighter) *model.Query {
 	var fullQuery *model.Query
+	if queryInfo.SearchAfter != nil {
+		// search_after pages through hits only, total and aggregations are still over all matching rows
+		hitsQuery := *simpleQuery
+		hitsQuery.WhereClause = model.And([]model.Expr{simpleQuery.WhereClause, queryInfo.SearchAfter})
+		simpleQuery = &hitsQuery
+	}
 	switch queryInfo.Typ {
 	case model.ListByField:
 		// queryInfo = (ListByField, fieldName, 0, LIMIT)
 		fullQuery = cw.BuildNRowsQuery(queryInfo.FieldName, simpleQuery, queryInfo.I2)
+		// message field is always returned, even if another field is requested
+		if messageField := cw.Table.MessageField; messageField != "" && messageField != queryInfo.FieldName && cw.Table.HasColumn(cw.Ctx, messageField) {
+			fullQuery.SelectCommand.Columns = append(fullQuery.SelectCommand.Columns, model.NewColumnRef(messageField))
+		}
 	case model.ListAllFields:
 		fullQuery = cw.BuildNRowsQuery("*", simpleQuery, queryInfo.I2)
 	default:
 	}
+	addSource, addFields := !queryInfo.SourceDisabled, true
+	if fullQuery != nil && queryInfo.StoredFields != nil && !slices.Contains(queryInfo.StoredFields, "*") {
+		// like in Elastic, stored_fields disable _source, unless it's requested explicitly
+		addSource = queryInfo.SourceRequested
+		columns := cw.storedFieldsColumns(queryInfo.StoredFields)
+		// docvalue_fields are returned regardless of stored_fields
+		for _, docValueField := range queryInfo.DocValueFields {
+			column := model.NewColumnRef(docValueField.Field)
+			if !slices.Contains(columns, model.Expr(column)) {
+				columns = append(columns, column)
+			}
+		}
+		if len(columns) > 0 {
+			fullQuery.SelectCommand.Columns = columns
+		} else {
+			addFields = false
+		}
+	}
+	if fullQuery != nil {
+		for _, runtimeField := range queryInfo.RuntimeFields {
+			fullQuery.SelectCommand.Columns = append(fullQuery.SelectCommand.Columns, model.NewAliasedExpr(runtimeField.Expr, runtimeField.Name))
+		}
+	}
+	if fullQuery != nil && queryInfo.CollapseField != "" {
+		collapseHits(fullQuery, queryInfo.CollapseField, queryInfo.CollapseInnerHits)
+	}
 	if fullQuery != nil {
 		highlighter.SetTokensToHighlight(fullQuery.SelectCommand)
 		// TODO: pass right arguments
-		queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, fullQuery.SelectCommand.OrderByFieldNames(), true, false, false)
+		queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, fullQuery.SelectCommand.OrderByFieldNames(), addSource, addFields, false, queryInfo.VersionRequested)
+		queryType.SetSourceFilter(queryInfo.SourceIncludes, queryInfo.SourceExcludes)
+		queryType.SetDocValueFormats(docValueFormats(queryInfo.DocValueFields))
+		queryType.SetRuntimeFields(runtimeFieldNames(queryInfo.RuntimeFields))
+		if cw.Table.VersionField != "" {
+			queryType.SetVersionColumn(cw.ResolveField(cw.Ctx, cw.Table.VersionField))
+		}
+		queryType.SetTrackTotalHits(queryInfo.TrackTotalHits)
+		if queryInfo.CollapseField != "" && queryInfo.CollapseInnerHits != nil {
+			queryType.SetInnerHits(queryInfo.CollapseField, queryInfo.CollapseInnerHits.Name, queryInfo.CollapseInnerHits.Size)
+		}
 		fullQuery.Type = &queryType
 		fullQuery.Highlighter = highlighter
 	}
@@ -91,6 +138,32 @@ func (cw *ClickhouseQueryTranslator) buildListQueryIfNeeded(
 	return fullQuery
 }
 
+// parseCollapseInnerHits parses "inner_hits" of "collapse" by `field`. Only a single inner_hits object is supported,
+// returns nil (no inner hits), if it's in a different format.
+func (cw *ClickhouseQueryTranslator) parseCollapseInnerHits(innerHitsRaw any, field string) *model.CollapseInnerHits {
+	innerHits, ok := innerHitsRaw.(QueryMap)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("unsupported collapse inner_hits format: %v (type: %T). Skipping inner hits", innerHitsRaw, innerHitsRaw)
+		return nil
+	}
+	const defaultInnerHitsSize = 3 // like in Elastic
+	result := &model.CollapseInnerHits{Name: field, Size: defaultInnerHitsSize}
+	if name, ok := innerHits["name"].(string); ok {
+		result.Name = name
+	}
+	if sizeRaw, exists := innerHits["size"]; exists {
+		if size, ok := sizeRaw.(float64); ok && size >= 0 {
+			result.Size = int(size)
+		} else {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid collapse inner_hits size: %v (type: %T). Using default (%d)", sizeRaw, sizeRaw, defaultInnerHitsSize)
+		}
+	}
+	if sort, exists := innerHits["sort"]; exists {
+		result.OrderBy = cw.parseSortFields(sort)
+	}
+	return result
+}
+
 func (cw *ClickhouseQueryTranslator) buildCountQueryIfNeeded(simpleQuery *model.SimpleQuery, queryInfo model.SearchQueryInfo) *model.Query {
 	if queryInfo.TrackTotalHits == model.TrackTotalHitsFalse {
 		return nil
@@ -131,12 +204,21 @@ func (cw *ClickhouseQueryTranslator) parseQueryInternal(body types.JSON) (*model
 	if queryPart, ok := queryAsMap["query"]; ok {
 		parsedQuery = cw.parseQueryMap(queryPart.(QueryMap))
 	} else {
-		parsedQuery = model.NewSimpleQuery(nil, true)
+		parsedQuery = cw.defaultQuery()
 	}
+	runtimeFields := cw.parseRuntimeMappings(queryAsMap)
+	parsedQuery.WhereClause = withRuntimeFields(parsedQuery.WhereClause, runtimeFields)
 
+	var sortFields []model.OrderByExpr
 	if sortPart, ok := queryAsMap["sort"]; ok {
-		parsedQuery.OrderBy = cw.parseSortFields(sortPart)
+		sortFields = cw.parseSortFields(sortPart)
 	}
+	var searchAfter model.Expr
+	if searchAfterRaw, ok := queryAsMap["search_after"]; ok {
+		searchAfter = cw.parseSearchAfter(searchAfterRaw, sortFields)
+	}
+	// query's own ordering (by relevance, e.g. distance_feature's) only breaks ties of the explicit sort
+	parsedQuery.OrderBy = append(sortFields, parsedQuery.OrderBy...)
 	const defaultSize = 10
 	size := defaultSize
 	if sizeRaw, ok := queryAsMap["size"]; ok {
@@ -159,19 +241,224 @@ func (cw *ClickhouseQueryTranslator) parseQueryInternal(body types.JSON) (*model
 			}
 		case float64:
 			trackTotalHits = int(trackTotalHitsTyped)
+		case string: // some clients send e.g. "true" or "100"
+			// not strconv.ParseBool, as it accepts "1" or "0", which are thresholds here
+			if trackTotalHitsTyped == "true" {
+				trackTotalHits = model.TrackTotalHitsTrue
+			} else if trackTotalHitsTyped == "false" {
+				trackTotalHits = model.TrackTotalHitsFalse
+			} else if asInt, err := strconv.Atoi(trackTotalHitsTyped); err == nil {
+				trackTotalHits = asInt
+			} else {
+				logger.WarnWithCtx(cw.Ctx).Msgf("unknown track_total_hits format, track_total_hits value: %v type: %T. Using default (%d)",
+					trackTotalHitsRaw, trackTotalHitsRaw, defaultTrackTotalHits)
+			}
 		default:
 			logger.WarnWithCtx(cw.Ctx).Msgf("unknown track_total_hits format, track_total_hits value: %v type: %T. Using default (%d)",
 				trackTotalHitsRaw, trackTotalHitsRaw, defaultTrackTotalHits)
 		}
 	}
 
+	var collapseField string
+	var collapseInnerHits *model.CollapseInnerHits
+	if collapse, ok := queryAsMap["collapse"].(QueryMap); ok {
+		if field, ok := collapse["field"].(string); ok {
+			collapseField = cw.ResolveField(cw.Ctx, field)
+			if innerHits, exists := collapse["inner_hits"]; exists {
+				collapseInnerHits = cw.parseCollapseInnerHits(innerHits, field)
+			}
+		} else {
+			logger.WarnWithCtx(cw.Ctx).Msgf("unknown collapse format, collapse value: %v. Not collapsing", collapse)
+		}
+	}
+
+	storedFields := cw.parseStoredFields(queryAsMap)
+	sourceFlag, isSourceFlag := queryAsMap["_source"].(bool)
+	sourceIncludes, sourceExcludes := cw.parseSourceFilter(queryAsMap)
+	docValueFields := cw.parseDocValueFields(queryAsMap)
+	versionRequested, _ := queryAsMap["version"].(bool)
+
 	queryInfo := cw.tryProcessSearchMetadata(queryAsMap)
 	queryInfo.Size = size
 	queryInfo.TrackTotalHits = trackTotalHits
+	queryInfo.CollapseField = collapseField
+	queryInfo.CollapseInnerHits = collapseInnerHits
+	queryInfo.StoredFields = storedFields
+	queryInfo.SourceRequested = isSourceFlag && sourceFlag
+	queryInfo.SourceDisabled = isSourceFlag && !sourceFlag
+	queryInfo.SourceIncludes, queryInfo.SourceExcludes = sourceIncludes, sourceExcludes
+	queryInfo.DocValueFields = docValueFields
+	queryInfo.VersionRequested = versionRequested
+	queryInfo.RuntimeFields = runtimeFields
+	queryInfo.SearchAfter = searchAfter
 
 	return &parsedQuery, queryInfo, highlighter, nil
 }
 
+// defaultQuery returns the query used when a search has none: table's configured default query, or match-all
+func (cw *ClickhouseQueryTranslator) defaultQuery() model.SimpleQuery {
+	if cw.Table.DefaultQuery == "" {
+		return model.NewSimpleQuery(nil, true)
+	}
+	defaultQuery, err := types.ParseJSON(cw.Table.DefaultQuery)
+	if err != nil {
+		logger.ErrorWithCtx(cw.Ctx).Msgf("invalid default query of table %s: %v. Using match-all", cw.Table.Name, err)
+		return model.NewSimpleQuery(nil, true)
+	}
+	return cw.parseQueryMap(QueryMap(defaultQuery))
+}
+
+// parseStoredFields returns fields requested in `stored_fields`, which can be a single field or a list of them.
+// Returns nil if there's no `stored_fields`.
+func (cw *ClickhouseQueryTranslator) parseStoredFields(queryMap QueryMap) []string {
+	storedFieldsRaw, ok := queryMap["stored_fields"]
+	if !ok {
+		return nil
+	}
+	return cw.parseFieldList(storedFieldsRaw, "stored_fields")
+}
+
+// parseSourceFilter returns fields to include in/exclude from _source of hits. `_source` can be a single field,
+// a list of them, or an object with `includes` and `excludes` (each a single field or a list).
+// `_source_includes`/`_source_excludes` URL params are defaults: includes/excludes present and non-empty in the body override them.
+func (cw *ClickhouseQueryTranslator) parseSourceFilter(queryMap QueryMap) (includes, excludes []string) {
+	includes, excludes = cw.SourceIncludesURLParam, cw.SourceExcludesURLParam
+	switch source := queryMap["_source"].(type) {
+	case bool:
+		if !source {
+			return nil, nil // no _source at all, nothing to filter
+		}
+	case string, []any:
+		includes = cw.parseFieldList(source, "_source")
+	case QueryMap:
+		if includesRaw, ok := source["includes"]; ok {
+			if fields := cw.parseFieldList(includesRaw, "_source.includes"); len(fields) > 0 {
+				includes = fields
+			}
+		}
+		if excludesRaw, ok := source["excludes"]; ok {
+			if fields := cw.parseFieldList(excludesRaw, "_source.excludes"); len(fields) > 0 {
+				excludes = fields
+			}
+		}
+	}
+	return includes, excludes
+}
+
+// parseFieldList parses a single field or a list of fields. Returns nil for other types.
+func (cw *ClickhouseQueryTranslator) parseFieldList(fieldsRaw any, paramName string) []string {
+	fields := make([]string, 0)
+	switch fieldsTyped := fieldsRaw.(type) {
+	case string:
+		fields = append(fields, fieldsTyped)
+	case []any:
+		for _, field := range fieldsTyped {
+			if fieldAsString, ok := field.(string); ok {
+				fields = append(fields, fieldAsString)
+			} else {
+				logger.WarnWithCtx(cw.Ctx).Msgf("invalid %s field type: %T, value: %v. Expected string. Skipping", paramName, field, field)
+			}
+		}
+	default:
+		logger.WarnWithCtx(cw.Ctx).Msgf("unknown %s format, value: %v type: %T. Ignoring", paramName, fieldsRaw, fieldsRaw)
+		return nil
+	}
+	return fields
+}
+
+// parseDocValueFields returns fields requested in `docvalue_fields`. Each entry is a field name (possibly with wildcards,
+// e.g. "*.keyword") or an object with `field` and optional `format`. Like in Elastic, wildcards are expanded
+// to all matching fields of the schema, and each of them gets the entry's format. Fields not in the table are skipped.
+func (cw *ClickhouseQueryTranslator) parseDocValueFields(queryMap QueryMap) []model.DocValueField {
+	docValueFieldsRaw, ok := queryMap["docvalue_fields"].([]any)
+	if !ok {
+		if docValueFieldsRaw, exists := queryMap["docvalue_fields"]; exists {
+			logger.WarnWithCtx(cw.Ctx).Msgf("unknown docvalue_fields format, value: %v type: %T. Ignoring", docValueFieldsRaw, docValueFieldsRaw)
+		}
+		return nil
+	}
+
+	var docValueFields []model.DocValueField
+	for _, entry := range docValueFieldsRaw {
+		var field, format string
+		switch entryTyped := entry.(type) {
+		case string:
+			field = entryTyped
+		case QueryMap:
+			field, _ = entryTyped["field"].(string)
+			format, _ = entryTyped["format"].(string)
+		}
+		if field == "" {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid docvalue_fields entry: %v type: %T. Skipping", entry, entry)
+			continue
+		}
+		for _, resolvedField := range cw.expandFieldPattern(field) {
+			if !cw.Table.HasColumn(cw.Ctx, resolvedField) {
+				logger.DebugWithCtx(cw.Ctx).Msgf("docvalue field %s not found in table %s. Skipping", resolvedField, cw.Table.Name)
+				continue
+			}
+			if !slices.ContainsFunc(docValueFields, func(f model.DocValueField) bool { return f.Field == resolvedField }) {
+				docValueFields = append(docValueFields, model.DocValueField{Field: resolvedField, Format: format})
+			}
+		}
+	}
+	return docValueFields
+}
+
+// expandFieldPattern returns internal names of all schema fields matching `pattern` (sorted by name),
+// or just the resolved `pattern`, if it has no wildcards. Like in field_caps, text fields also have a
+// `.keyword` subfield, and keyword ones a `.text` subfield, so e.g. "*.keyword" matches all text fields.
+func (cw *ClickhouseQueryTranslator) expandFieldPattern(pattern string) []string {
+	if !strings.Contains(pattern, "*") {
+		return []string{cw.ResolveField(cw.Ctx, pattern)}
+	}
+	schemaInstance, exists := cw.SchemaRegistry.FindSchema(schema.TableName(cw.Table.Name))
+	if !exists {
+		logger.WarnWithCtx(cw.Ctx).Msgf("schema for table %s not found, can't expand field pattern %s", cw.Table.Name, pattern)
+		return nil
+	}
+	patternRegexp := index.TableNamePatternRegexp(pattern)
+	var fields []string
+	for _, field := range schemaInstance.Fields {
+		names := []string{field.PropertyName.AsString()}
+		for _, multiField := range schema.MultiFields(field) {
+			names = append(names, multiField.PropertyName.AsString())
+		}
+		if slices.ContainsFunc(names, patternRegexp.MatchString) {
+			fields = append(fields, field.InternalPropertyName.AsString())
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// docValueFormats returns formats of `docValueFields`, which have one, by field name
+func docValueFormats(docValueFields []model.DocValueField) map[string]string {
+	formats := make(map[string]string)
+	for _, docValueField := range docValueFields {
+		if docValueField.Format != "" {
+			formats[docValueField.Field] = docValueField.Format
+		}
+	}
+	return formats
+}
+
+// storedFieldsColumns returns columns for `storedFields`, skipping ones not in the table. Empty for StoredFieldsNone.
+func (cw *ClickhouseQueryTranslator) storedFieldsColumns(storedFields []string) []model.Expr {
+	columns := make([]model.Expr, 0, len(storedFields))
+	for _, field := range storedFields {
+		if field == model.StoredFieldsNone {
+			return nil
+		}
+		if resolvedField := cw.ResolveField(cw.Ctx, field); cw.Table.HasColumn(cw.Ctx, resolvedField) {
+			columns = append(columns, model.NewColumnRef(resolvedField))
+		} else {
+			logger.DebugWithCtx(cw.Ctx).Msgf("stored field %s not found in table %s. Skipping", field, cw.Table.Name)
+		}
+	}
+	return columns
+}
+
 func (cw *ClickhouseQueryTranslator) ParseHighlighter(queryMap QueryMap) model.Highlighter {
 
 	highlight, ok := queryMap["highlight"].(QueryMap)
@@ -202,6 +489,16 @@ func (cw *ClickhouseQueryTranslator) ParseHighlighter(queryMap QueryMap) model.H
 		}
 	}
 
+	if highlightQueryRaw, ok := highlight["highlight_query"]; ok {
+		if highlightQuery, ok := highlightQueryRaw.(QueryMap); ok {
+			if parsed := cw.parseQueryMap(highlightQuery); parsed.CanParse {
+				highlighter.Query = parsed.WhereClause
+			}
+		} else {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid highlight_query type: %T, value: %v. Skipping", highlightQueryRaw, highlightQueryRaw)
+		}
+	}
+
 	// TODO parse other fields:
 	// - fields
 	// - fragment_size
@@ -231,7 +528,7 @@ func (cw *ClickhouseQueryTranslator) ParseQueryAsyncSearch(queryAsJson string) (
 	}
 
 	if sort, ok := queryAsMap["sort"]; ok {
-		parsedQuery.OrderBy = cw.parseSortFields(sort)
+		parsedQuery.OrderBy = append(cw.parseSortFields(sort), parsedQuery.OrderBy...)
 	}
 	queryInfo := cw.tryProcessSearchMetadata(queryAsMap)
 
@@ -252,6 +549,11 @@ func (cw *ClickhouseQueryTranslator) parseMetadata(queryMap QueryMap) QueryMap {
 	return queryMetadata
 }
 
+// ParseFilter translates an Elasticsearch query (what's normally under 'query' key of the request) into a WHERE clause
+func (cw *ClickhouseQueryTranslator) ParseFilter(query QueryMap) model.SimpleQuery {
+	return cw.parseQueryMap(query)
+}
+
 func (cw *ClickhouseQueryTranslator) ParseAutocomplete(indexFilter *QueryMap, fieldName string, prefix *string, caseIns bool) model.SimpleQuery {
 	fieldName = cw.ResolveField(cw.Ctx, fieldName)
 	canParse := true
@@ -284,12 +586,16 @@ func (cw *ClickhouseQueryTranslator) parseQueryMap(queryMap QueryMap) model.Simp
 		"match_all":           cw.parseMatchAll,
 		"match":               func(qm QueryMap) model.SimpleQuery { return cw.parseMatch(qm, false) },
 		"multi_match":         cw.parseMultiMatch,
+		"combined_fields":     cw.parseCombinedFields,
+		"intervals":           cw.parseIntervals,
 		"bool":                cw.parseBool,
 		"term":                cw.parseTerm,
 		"terms":               cw.parseTerms,
 		"query":               cw.parseQueryMap,
 		"prefix":              cw.parsePrefix,
 		"nested":              cw.parseNested,
+		"has_child":           cw.parseHasChild,
+		"has_parent":          cw.parseHasParent,
 		"match_phrase":        func(qm QueryMap) model.SimpleQuery { return cw.parseMatch(qm, true) },
 		"range":               cw.parseRange,
 		"exists":              cw.parseExists,
@@ -300,6 +606,8 @@ func (cw *ClickhouseQueryTranslator) parseQueryMap(queryMap QueryMap) model.Simp
 		"simple_query_string": cw.parseQueryString,
 		"regexp":              cw.parseRegexp,
 		"geo_bounding_box":    cw.parseGeoBoundingBox,
+		"geo_polygon":         cw.parseGeoPolygon,
+		"distance_feature":    cw.parseDistanceFeature,
 	}
 	for k, v := range queryMap {
 		if f, ok := parseMap[k]; ok {
@@ -325,14 +633,32 @@ func (cw *ClickhouseQueryTranslator) parseQueryMap(queryMap QueryMap) model.Simp
 	return model.NewSimpleQuery(nil, false)
 }
 
-// `constant_score` query is just a wrapper for filter query which returns constant relevance score, which we ignore anyway
+// parseConstantScore parses `constant_score`: it matches documents of its `filter`, all with the same score, so `boost`
+// (which only changes the score) is ignored. Like older Elastic versions, we also accept the wrapped query given as `query`,
+// or directly, e.g. {"constant_score": {"term": {...}, "boost": 2}}. Ordering of the wrapped query (e.g. distance_feature) is kept.
 func (cw *ClickhouseQueryTranslator) parseConstantScore(queryMap QueryMap) model.SimpleQuery {
-	if _, ok := queryMap["filter"]; ok {
-		return cw.parseBool(queryMap)
+	var wrapped any
+	if filter, ok := queryMap["filter"]; ok {
+		wrapped = filter
+	} else if query, ok := queryMap["query"]; ok {
+		wrapped = query
 	} else {
-		logger.Error().Msgf("parsing error: `constant_score` needs to wrap `filter` query")
-		return model.NewSimpleQuery(nil, false)
+		wrappedMap := make(QueryMap)
+		for key, value := range queryMap {
+			if key != "boost" && key != "_name" {
+				wrappedMap[key] = value
+			}
+		}
+		if len(wrappedMap) != 1 {
+			logger.ErrorWithCtx(cw.Ctx).Msgf("parsing error: `constant_score` needs to wrap `filter` query, got: %v", queryMap)
+			return model.NewSimpleQuery(nil, false)
+		}
+		wrapped = wrappedMap
 	}
+	stmts, orderBy, canParse := cw.iterateListOrDictAndParse(wrapped)
+	query := model.NewSimpleQuery(model.And(stmts), canParse)
+	query.OrderBy = orderBy
+	return query
 }
 
 func (cw *ClickhouseQueryTranslator) parseIds(queryMap QueryMap) model.SimpleQuery {
@@ -403,13 +729,14 @@ func (cw *ClickhouseQueryTranslator) parseIds(queryMap QueryMap) model.SimpleQue
 }
 
 // Parses each model.SimpleQuery separately, returns list of translated SQLs
-func (cw *ClickhouseQueryTranslator) parseQueryMapArray(queryMaps []interface{}) (stmts []model.Expr, canParse bool) {
+func (cw *ClickhouseQueryTranslator) parseQueryMapArray(queryMaps []interface{}) (stmts []model.Expr, orderBy []model.OrderByExpr, canParse bool) {
 	stmts = make([]model.Expr, len(queryMaps))
 	canParse = true
 	for i, v := range queryMaps {
 		if vAsMap, ok := v.(QueryMap); ok {
 			query := cw.parseQueryMap(vAsMap)
 			stmts[i] = query.WhereClause
+			orderBy = append(orderBy, query.OrderBy...)
 			if !query.CanParse {
 				canParse = false
 			}
@@ -418,30 +745,35 @@ func (cw *ClickhouseQueryTranslator) parseQueryMapArray(queryMaps []interface{})
 			canParse = false
 		}
 	}
-	return stmts, canParse
+	return stmts, orderBy, canParse
 }
 
-func (cw *ClickhouseQueryTranslator) iterateListOrDictAndParse(queryMaps interface{}) (stmts []model.Expr, canParse bool) {
+// iterateListOrDictAndParse returns WHERE statements and ORDER BY expressions (of e.g. distance_feature) of all queries
+func (cw *ClickhouseQueryTranslator) iterateListOrDictAndParse(queryMaps interface{}) (stmts []model.Expr, orderBy []model.OrderByExpr, canParse bool) {
 	switch queryMapsTyped := queryMaps.(type) {
 	case []interface{}:
 		return cw.parseQueryMapArray(queryMapsTyped)
 	case QueryMap:
 		simpleQuery := cw.parseQueryMap(queryMapsTyped)
-		return []model.Expr{simpleQuery.WhereClause}, simpleQuery.CanParse
+		return []model.Expr{simpleQuery.WhereClause}, simpleQuery.OrderBy, simpleQuery.CanParse
 	default:
 		logger.WarnWithCtx(cw.Ctx).Msgf("Invalid query type: %T, value: %v", queryMapsTyped, queryMapsTyped)
-		return []model.Expr{}, false
+		return []model.Expr{}, nil, false
 	}
 }
 
 // TODO: minimum_should_match parameter. Now only ints supported and >1 changed into 1
 func (cw *ClickhouseQueryTranslator) parseBool(queryMap QueryMap) model.SimpleQuery {
 	var andStmts []model.Expr
-	canParse := true // will stay true only if all subqueries can be parsed
+	var orderBy []model.OrderByExpr // ordering by relevance, so only of scoring clauses: must and should
+	canParse := true                // will stay true only if all subqueries can be parsed
 	for _, andPhrase := range []string{"must", "filter"} {
 		if queries, ok := queryMap[andPhrase]; ok {
-			newAndStmts, canParseThis := cw.iterateListOrDictAndParse(queries)
+			newAndStmts, newOrderBy, canParseThis := cw.iterateListOrDictAndParse(queries)
 			andStmts = append(andStmts, newAndStmts...)
+			if andPhrase == "must" {
+				orderBy = append(orderBy, newOrderBy...)
+			}
 			canParse = canParse && canParseThis
 		}
 	}
@@ -462,19 +794,23 @@ func (cw *ClickhouseQueryTranslator) parseBool(queryMap QueryMap) model.SimpleQu
 		logger.WarnWithCtx(cw.Ctx).Msgf("minimum_should_match > 1 not supported, changed to 1")
 		minimumShouldMatch = 1
 	}
-	if queries, ok := queryMap["should"]; ok && minimumShouldMatch == 1 {
-		orSqls, canParseThis := cw.iterateListOrDictAndParse(queries)
-		orSql := model.Or(orSqls)
-		canParse = canParse && canParseThis
-		if len(andStmts) == 0 {
-			sql = orSql
-		} else if orSql != nil {
-			sql = model.And([]model.Expr{sql, orSql})
+	if queries, ok := queryMap["should"]; ok {
+		orSqls, newOrderBy, canParseThis := cw.iterateListOrDictAndParse(queries)
+		orderBy = append(orderBy, newOrderBy...)
+		// with minimum_should_match 0, should clauses don't filter, they only contribute to relevance
+		if minimumShouldMatch == 1 {
+			orSql := model.Or(orSqls)
+			canParse = canParse && canParseThis
+			if len(andStmts) == 0 {
+				sql = orSql
+			} else if orSql != nil {
+				sql = model.And([]model.Expr{sql, orSql})
+			}
 		}
 	}
 
 	if queries, ok := queryMap["must_not"]; ok {
-		sqlNots, canParseThis := cw.iterateListOrDictAndParse(queries)
+		sqlNots, _, canParseThis := cw.iterateListOrDictAndParse(queries)
 		canParse = canParse && canParseThis
 		if len(sqlNots) > 0 {
 			for i, stmt := range sqlNots {
@@ -486,7 +822,9 @@ func (cw *ClickhouseQueryTranslator) parseBool(queryMap QueryMap) model.SimpleQu
 			sql = model.And([]model.Expr{sql, orSql})
 		}
 	}
-	return model.NewSimpleQuery(sql, canParse)
+	query := model.NewSimpleQuery(sql, canParse)
+	query.OrderBy = orderBy
+	return query
 }
 
 func (cw *ClickhouseQueryTranslator) parseTerm(queryMap QueryMap) model.SimpleQuery {
@@ -498,14 +836,77 @@ func (cw *ClickhouseQueryTranslator) parseTerm(queryMap QueryMap) model.SimpleQu
 				whereClause = model.NewInfixExpr(model.NewLiteral("0"), "=", model.NewLiteral("0 /* "+k+"="+sprint(v)+" */"))
 				return model.NewSimpleQuery(whereClause, true)
 			}
-			whereClause = model.NewInfixExpr(model.NewColumnRef(k), "=", model.NewLiteral(sprint(v)))
-			return model.NewSimpleQuery(whereClause, true)
+			return model.NewSimpleQuery(cw.termEquals(k, v), true)
 		}
 	}
 	logger.WarnWithCtx(cw.Ctx).Msgf("we expect only 1 term, got: %d. value: %v", len(queryMap), queryMap)
 	return model.NewSimpleQuery(nil, false)
 }
 
+// termEquals returns `field = value`. It's `lower(field) = lower(value)` for string values of fields configured
+// as case-insensitive, or if the term itself asks for it with `case_insensitive`.
+// Values longer than field's `ignore_above` aren't indexed by Elasticsearch, so they never match.
+func (cw *ClickhouseQueryTranslator) termEquals(field string, value any) model.Expr {
+	caseInsensitive := cw.Table.IsCaseInsensitiveField(field)
+	rawValue := value
+	if valueMap, ok := value.(QueryMap); ok {
+		if caseInsensitiveParam, ok := valueMap["case_insensitive"].(bool); ok {
+			caseInsensitive = caseInsensitiveParam
+		}
+		rawValue = valueMap["value"]
+	}
+	if cw.isBoolean(field) {
+		if boolean, ok := normalizeBoolean(rawValue); ok {
+			return model.NewInfixExpr(model.NewColumnRef(field), "=", model.NewLiteral(boolean))
+		}
+	}
+	if valueAsString, isString := rawValue.(string); isString && cw.Table.IsIgnoredValue(field, valueAsString) {
+		return model.NewLiteral("false")
+	}
+	if _, isString := rawValue.(string); caseInsensitive && isString {
+		return model.NewInfixExpr(model.NewFunction("lower", model.NewColumnRef(field)), "=",
+			model.NewFunction("lower", model.NewLiteral(sprint(rawValue))))
+	}
+	return model.NewInfixExpr(model.NewColumnRef(field), "=", model.NewLiteral(sprint(value)))
+}
+
+// booleanValues are representations of booleans, which Elasticsearch accepts for boolean fields
+var booleanValues = map[string]string{
+	"true": "1", "on": "1", "yes": "1", "1": "1",
+	"false": "0", "off": "0", "no": "0", "0": "0",
+}
+
+// normalizeBoolean returns `value` of a boolean field as ClickHouse 1 or 0, ok=false if it doesn't represent a boolean
+func normalizeBoolean(value any) (boolean string, ok bool) {
+	switch valueTyped := value.(type) {
+	case bool:
+		if valueTyped {
+			return "1", true
+		}
+		return "0", true
+	case string:
+		boolean, ok = booleanValues[strings.ToLower(strings.TrimSpace(valueTyped))]
+		return boolean, ok
+	case float64:
+		boolean, ok = booleanValues[strconv.FormatFloat(valueTyped, 'f', -1, 64)]
+		return boolean, ok
+	}
+	return "", false
+}
+
+// isBoolean returns true <=> `fieldName` is a boolean field in the schema
+func (cw *ClickhouseQueryTranslator) isBoolean(fieldName string) bool {
+	if cw.SchemaRegistry == nil {
+		return false
+	}
+	schemaInstance, exists := cw.SchemaRegistry.FindSchema(schema.TableName(cw.Table.Name))
+	if !exists {
+		return false
+	}
+	field, ok := schemaInstance.ResolveField(fieldName)
+	return ok && field.Type.Equal(schema.TypeBoolean)
+}
+
 // TODO remove optional parameters like boost
 func (cw *ClickhouseQueryTranslator) parseTerms(queryMap QueryMap) model.SimpleQuery {
 	if len(queryMap) != 1 {
@@ -524,16 +925,38 @@ func (cw *ClickhouseQueryTranslator) parseTerms(queryMap QueryMap) model.SimpleQ
 			logger.WarnWithCtx(cw.Ctx).Msgf("invalid terms type: %T, value: %v", v, v)
 			return model.NewSimpleQuery(nil, false)
 		}
+		// values over `ignore_above` never match, like in termEquals
+		vAsArray = slices.DeleteFunc(slices.Clone(vAsArray), func(v any) bool {
+			valueAsString, isString := v.(string)
+			return isString && cw.Table.IsIgnoredValue(k, valueAsString)
+		})
+		if len(vAsArray) == 0 {
+			return model.NewSimpleQuery(model.NewLiteral("false"), true)
+		}
 		if len(vAsArray) == 1 {
-			simpleStatement := model.NewInfixExpr(model.NewColumnRef(k), "=", model.NewLiteral(sprint(vAsArray[0])))
-			return model.NewSimpleQuery(simpleStatement, true)
+			return model.NewSimpleQuery(cw.termEquals(k, vAsArray[0]), true)
 		}
+		isBoolean := cw.isBoolean(k)
+		caseInsensitive := cw.Table.IsCaseInsensitiveField(k) && !isBoolean
 		values := make([]string, len(vAsArray))
 		for i, v := range vAsArray {
 			values[i] = sprint(v)
+			if boolean, ok := normalizeBoolean(v); isBoolean && ok {
+				values[i] = boolean
+			}
+			if _, isString := v.(string); !isString {
+				caseInsensitive = false
+			}
+		}
+		var column model.Expr = model.NewColumnRef(k)
+		if caseInsensitive {
+			column = model.NewFunction("lower", column)
+			for i := range values {
+				values[i] = fmt.Sprintf("lower(%s)", values[i])
+			}
 		}
 		combinedValues := "(" + strings.Join(values, ",") + ")"
-		compoundStatement := model.NewInfixExpr(model.NewColumnRef(k), "IN", model.NewLiteral(combinedValues))
+		compoundStatement := model.NewInfixExpr(column, "IN", model.NewLiteral(combinedValues))
 		return model.NewSimpleQuery(compoundStatement, true)
 	}
 
@@ -611,7 +1034,7 @@ func (cw *ClickhouseQueryTranslator) parseMultiMatch(queryMap QueryMap) model.Si
 			return model.NewSimpleQuery(nil, false)
 		}
 	} else {
-		fields = cw.Table.GetFulltextFields()
+		fields = cw.Table.GetDefaultSearchFields()
 	}
 	alwaysFalseStmt := model.NewLiteral("false")
 	if len(fields) == 0 {
@@ -628,33 +1051,171 @@ func (cw *ClickhouseQueryTranslator) parseMultiMatch(queryMap QueryMap) model.Si
 		logger.WarnWithCtx(cw.Ctx).Msgf("invalid query type: %T, value: %v", query, query)
 		return model.NewSimpleQuery(alwaysFalseStmt, false)
 	}
-	var subQueries []string
-	wereDone := false
-	// 2 cases:
-	// a) "type" == "phrase" -> we need to match full string
-	if matchType, ok := queryMap["type"]; ok {
-		if matchTypeAsString, ok := matchType.(string); ok && matchTypeAsString == "phrase" {
-			wereDone = true
-			subQueries = []string{queryAsString}
+	matchType := "best_fields"
+	if matchTypeRaw, ok := queryMap["type"]; ok {
+		if matchTypeAsString, ok := matchTypeRaw.(string); ok {
+			matchType = matchTypeAsString
+		} else {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid multi_match type: %T, value: %v. Using best_fields", matchTypeRaw, matchTypeRaw)
 		}
 	}
-	// b) "type" == "best_fields" (or other - we treat it as default) -> we need to match any of the words
-	if !wereDone {
-		subQueries = strings.Split(queryAsString, " ")
-	}
 
-	sqls := make([]model.Expr, len(fields)*len(subQueries))
-	i := 0
-	for _, field := range fields {
-		for _, subQ := range subQueries {
-			simpleStat := model.NewInfixExpr(model.NewColumnRef(field), "iLIKE", model.NewLiteral("'%"+subQ+"%'"))
-			sqls[i] = simpleStat
-			i++
+	iLike := func(field, pattern string) model.Expr {
+		return model.NewInfixExpr(model.NewColumnRef(field), "iLIKE", model.NewLiteral("'"+pattern+"'"))
+	}
+	var sqls []model.Expr
+	switch matchType {
+	case "phrase":
+		// the full string needs to match in any of the fields
+		for _, field := range fields {
+			sqls = append(sqls, iLike(field, "%"+queryAsString+"%"))
+		}
+	case "phrase_prefix":
+		// like phrase, but its last word may be just a prefix, so the phrase needs to start at a word start
+		for _, field := range fields {
+			sqls = append(sqls, iLike(field, queryAsString+"%"), iLike(field, "% "+queryAsString+"%"))
+		}
+	default:
+		// "best_fields", "most_fields" (or other - we treat it as default): any of the words needs to match in any of the fields.
+		// They differ only in scoring, which we don't do. With "operator": "and" each word needs to match, in any of the fields.
+		// "cross_fields" treats fields like one big field, where each word needs to match, unless "operator" is "or".
+		words := strings.Fields(queryAsString)
+		if len(words) == 0 {
+			return model.NewSimpleQuery(alwaysFalseStmt, true) // like in Elasticsearch, no words match no documents
 		}
+		allWords, ok := cw.multiMatchRequiresAllWords(queryMap, len(words), matchType == "cross_fields")
+		if !ok {
+			return model.NewSimpleQuery(nil, false)
+		}
+		if !allWords {
+			for _, field := range fields {
+				for _, word := range words {
+					sqls = append(sqls, iLike(field, "%"+word+"%"))
+				}
+			}
+			break
+		}
+		wordStatements := make([]model.Expr, 0, len(words))
+		for _, word := range words {
+			fieldStatements := make([]model.Expr, 0, len(fields))
+			for _, field := range fields {
+				fieldStatements = append(fieldStatements, iLike(field, "%"+word+"%"))
+			}
+			wordStatements = append(wordStatements, model.Or(fieldStatements))
+		}
+		return model.NewSimpleQuery(model.And(wordStatements), true)
 	}
 	return model.NewSimpleQuery(model.Or(sqls), true)
 }
 
+// multiMatchRequiresAllWords returns true <=> all `wordsNr` words of multi_match query need to match, false <=> any of them.
+// It's decided by "operator" ("and" or "or"), and "minimum_should_match", which (like in bool) we support only
+// as 1, or all words: other values are clamped, all words for >= wordsNr, 1 otherwise. ok=false if parameters are invalid.
+func (cw *ClickhouseQueryTranslator) multiMatchRequiresAllWords(queryMap QueryMap, wordsNr int, allWordsByDefault bool) (allWords, ok bool) {
+	allWords = allWordsByDefault
+	if operatorRaw, exists := queryMap["operator"]; exists {
+		operator, _ := operatorRaw.(string)
+		switch strings.ToLower(operator) {
+		case "and":
+			allWords = true
+		case "or":
+			allWords = false
+		default:
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid operator in multi_match query: %v (%T)", operatorRaw, operatorRaw)
+			return false, false
+		}
+	}
+	if allWords {
+		return true, true // minimum_should_match applies only to "or"
+	}
+	minimumShouldMatchRaw, exists := queryMap["minimum_should_match"]
+	if !exists {
+		return false, true
+	}
+	var minimumShouldMatch int
+	switch minimumShouldMatchTyped := minimumShouldMatchRaw.(type) {
+	case float64:
+		minimumShouldMatch = int(minimumShouldMatchTyped)
+	case string:
+		var err error
+		if percent, isPercent := strings.CutSuffix(minimumShouldMatchTyped, "%"); isPercent {
+			var percentAsInt int
+			percentAsInt, err = strconv.Atoi(percent)
+			minimumShouldMatch = wordsNr * percentAsInt / 100
+		} else {
+			minimumShouldMatch, err = strconv.Atoi(minimumShouldMatchTyped)
+		}
+		if err != nil {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid minimum_should_match in multi_match query: %v", minimumShouldMatchRaw)
+			return false, false
+		}
+	default:
+		logger.WarnWithCtx(cw.Ctx).Msgf("invalid minimum_should_match type: %T, value: %v", minimumShouldMatchRaw, minimumShouldMatchRaw)
+		return false, false
+	}
+	if minimumShouldMatch < 0 { // negative: how many words may be missing
+		minimumShouldMatch += wordsNr
+	}
+	if minimumShouldMatch >= wordsNr {
+		return true, true
+	}
+	if minimumShouldMatch > 1 {
+		logger.WarnWithCtx(cw.Ctx).Msgf("minimum_should_match %d of %d words not supported, changed to 1", minimumShouldMatch, wordsNr)
+	}
+	return false, true
+}
+
+// parseCombinedFields translates `combined_fields`, which matches query's terms against fields as if they were one field.
+// Each term matches, if it's in any of the fields. With "operator": "and" all terms must match, with "or" (default) any of them.
+// Field boosts (e.g. "title^2") are ignored, as we don't score hits.
+func (cw *ClickhouseQueryTranslator) parseCombinedFields(queryMap QueryMap) model.SimpleQuery {
+	fieldsAsArray, ok := queryMap["fields"].([]interface{})
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no fields or invalid fields in combined_fields query: %v", queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+	fieldsWithoutBoosts := make([]interface{}, 0, len(fieldsAsArray))
+	for _, field := range fieldsAsArray {
+		if fieldAsString, ok := field.(string); ok {
+			field, _, _ = strings.Cut(fieldAsString, "^")
+		}
+		fieldsWithoutBoosts = append(fieldsWithoutBoosts, field)
+	}
+	fields := cw.extractFields(fieldsWithoutBoosts)
+
+	query, ok := queryMap["query"].(string)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no query or invalid query in combined_fields query: %v", queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+	operator := "or"
+	if operatorRaw, ok := queryMap["operator"]; ok {
+		operatorAsString, ok := operatorRaw.(string)
+		if !ok || (strings.ToLower(operatorAsString) != "or" && strings.ToLower(operatorAsString) != "and") {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid operator in combined_fields query: %v", operatorRaw)
+			return model.NewSimpleQuery(nil, false)
+		}
+		operator = strings.ToLower(operatorAsString)
+	}
+
+	terms := strings.Fields(query)
+	if len(fields) == 0 || len(terms) == 0 {
+		return model.NewSimpleQuery(model.NewLiteral("false"), true)
+	}
+	termStatements := make([]model.Expr, 0, len(terms))
+	for _, term := range terms {
+		fieldStatements := make([]model.Expr, 0, len(fields))
+		for _, field := range fields {
+			fieldStatements = append(fieldStatements, model.NewInfixExpr(model.NewColumnRef(field), "iLIKE", model.NewLiteral("'%"+term+"%'")))
+		}
+		termStatements = append(termStatements, model.Or(fieldStatements))
+	}
+	if operator == "and" {
+		return model.NewSimpleQuery(model.And(termStatements), true)
+	}
+	return model.NewSimpleQuery(model.Or(termStatements), true)
+}
+
 // prefix works only on strings
 func (cw *ClickhouseQueryTranslator) parsePrefix(queryMap QueryMap) model.SimpleQuery {
 	if len(queryMap) != 1 {
@@ -663,19 +1224,27 @@ func (cw *ClickhouseQueryTranslator) parsePrefix(queryMap QueryMap) model.Simple
 	}
 
 	for fieldName, v := range queryMap {
-		fieldName = cw.ResolveField(cw.Ctx, fieldName)
+		var token string
 		switch vCasted := v.(type) {
 		case string:
-			simpleStat := model.NewInfixExpr(model.NewColumnRef(fieldName), "iLIKE", model.NewLiteral("'"+vCasted+"%'"))
-			return model.NewSimpleQuery(simpleStat, true)
+			token = vCasted
 		case QueryMap:
-			token := vCasted["value"].(string)
-			simpleStat := model.NewInfixExpr(model.NewColumnRef(fieldName), "iLIKE", model.NewLiteral("'"+token+"%'"))
-			return model.NewSimpleQuery(simpleStat, true)
+			// `rewrite`, `boost` and `case_insensitive` are ignored: we match case-insensitively anyway, and don't score
+			var ok bool
+			if token, ok = vCasted["value"].(string); !ok {
+				logger.WarnWithCtx(cw.Ctx).Msgf("no value or invalid value in prefix query: %v", queryMap)
+				return model.NewSimpleQuery(nil, false)
+			}
 		default:
 			logger.WarnWithCtx(cw.Ctx).Msgf("unsupported prefix type: %T, value: %v", v, v)
 			return model.NewSimpleQuery(nil, false)
 		}
+		if fieldName == "_id" {
+			return cw.parseIdPattern("prefix", token)
+		}
+		fieldName = cw.ResolveField(cw.Ctx, fieldName)
+		simpleStat := model.NewInfixExpr(model.NewColumnRef(fieldName), "iLIKE", model.NewLiteral("'"+token+"%'"))
+		return model.NewSimpleQuery(simpleStat, true)
 	}
 
 	// unreachable unless something really weird happens
@@ -693,10 +1262,13 @@ func (cw *ClickhouseQueryTranslator) parseWildcard(queryMap QueryMap) model.Simp
 	}
 
 	for fieldName, v := range queryMap {
-		fieldName = cw.ResolveField(cw.Ctx, fieldName)
 		if vAsMap, ok := v.(QueryMap); ok {
 			if value, ok := vAsMap["value"]; ok {
 				if valueAsString, ok := value.(string); ok {
+					if fieldName == "_id" {
+						return cw.parseIdPattern("wildcard", valueAsString)
+					}
+					fieldName = cw.ResolveField(cw.Ctx, fieldName)
 					whereStatement := model.NewInfixExpr(model.NewColumnRef(fieldName), "iLIKE", model.NewLiteral("'"+strings.ReplaceAll(valueAsString, "*", "%")+"'"))
 					return model.NewSimpleQuery(whereStatement, true)
 				} else {
@@ -718,6 +1290,16 @@ func (cw *ClickhouseQueryTranslator) parseWildcard(queryMap QueryMap) model.Simp
 	return model.NewSimpleQuery(nil, false)
 }
 
+// parseIdPattern parses prefix or wildcard (`queryType`) query on _id. It's computed from the timestamp, not stored,
+// so matching its part makes no sense. We only support a wildcard without '*' and '?', which is the whole _id, like in ids query.
+func (cw *ClickhouseQueryTranslator) parseIdPattern(queryType, pattern string) model.SimpleQuery {
+	if queryType == "wildcard" && !strings.ContainsAny(pattern, "*?") {
+		return cw.parseIds(QueryMap{"values": []interface{}{pattern}})
+	}
+	logger.WarnWithCtx(cw.Ctx).Msgf("unsupported %s query on _id: %s. _id is computed from the timestamp, so only whole ids can be matched", queryType, pattern)
+	return model.NewSimpleQuery(nil, false)
+}
+
 // This one is really complicated (https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-query-string-query.html)
 // `query` uses Lucene language, we don't support 100% of it, but most.
 func (cw *ClickhouseQueryTranslator) parseQueryString(queryMap QueryMap) model.SimpleQuery {
@@ -725,7 +1307,7 @@ func (cw *ClickhouseQueryTranslator) parseQueryString(queryMap QueryMap) model.S
 	if fieldsRaw, ok := queryMap["fields"]; ok {
 		fields = cw.extractFields(fieldsRaw.([]interface{}))
 	} else {
-		fields = cw.Table.GetFulltextFields()
+		fields = cw.Table.GetDefaultSearchFields()
 	}
 
 	query := queryMap["query"].(string) // query: (Required, string)
@@ -749,6 +1331,99 @@ func (cw *ClickhouseQueryTranslator) parseNested(queryMap QueryMap) model.Simple
 	return model.NewSimpleQuery(nil, false)
 }
 
+// parseHasChild translates `has_child` to parent documents, which have a child matching the query:
+// relation = parent AND id IN (SELECT parent_id FROM table WHERE relation = child AND query).
+// It needs join configured for the index.
+func (cw *ClickhouseQueryTranslator) parseHasChild(queryMap QueryMap) model.SimpleQuery {
+	join := cw.Table.Join
+	if join == nil {
+		logger.WarnWithCtxAndReason(cw.Ctx, logger.ReasonUnsupportedQuery("has_child")).Msgf("has_child query, but no join configured for table %s", cw.Table.Name)
+		return model.NewSimpleQuery(nil, false)
+	}
+	childType, ok := queryMap["type"].(string)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no type in has_child query: %v", queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+	parentType, ok := join.ParentOf(childType)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("has_child type %s isn't a child relation in table %s", childType, cw.Table.Name)
+		return model.NewSimpleQuery(nil, false)
+	}
+
+	childrenWhere, canParse := cw.parseJoinInnerQuery(queryMap, "has_child", childType)
+	if !canParse {
+		return model.NewSimpleQuery(nil, false)
+	}
+	return model.NewSimpleQuery(model.And([]model.Expr{
+		cw.relationEquals(parentType),
+		cw.joinSubquery(join.IdField, join.ParentField, childrenWhere),
+	}), true)
+}
+
+// parseHasParent translates `has_parent` to child documents, whose parent matches the query:
+// relation IN (children) AND parent_id IN (SELECT id FROM table WHERE relation = parent AND query).
+// It needs join configured for the index.
+func (cw *ClickhouseQueryTranslator) parseHasParent(queryMap QueryMap) model.SimpleQuery {
+	join := cw.Table.Join
+	if join == nil {
+		logger.WarnWithCtxAndReason(cw.Ctx, logger.ReasonUnsupportedQuery("has_parent")).Msgf("has_parent query, but no join configured for table %s", cw.Table.Name)
+		return model.NewSimpleQuery(nil, false)
+	}
+	parentType, ok := queryMap["parent_type"].(string)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no parent_type in has_parent query: %v", queryMap)
+		return model.NewSimpleQuery(nil, false)
+	}
+	childTypes := join.ChildrenOf(parentType)
+	if len(childTypes) == 0 {
+		logger.WarnWithCtx(cw.Ctx).Msgf("has_parent parent_type %s isn't a parent relation in table %s", parentType, cw.Table.Name)
+		return model.NewSimpleQuery(nil, false)
+	}
+
+	parentsWhere, canParse := cw.parseJoinInnerQuery(queryMap, "has_parent", parentType)
+	if !canParse {
+		return model.NewSimpleQuery(nil, false)
+	}
+	isChild := cw.relationEquals(childTypes[0])
+	if len(childTypes) > 1 {
+		values := make([]string, 0, len(childTypes))
+		for _, childType := range childTypes {
+			values = append(values, sprint(childType))
+		}
+		isChild = model.NewInfixExpr(model.NewColumnRef(join.RelationField), "IN", model.NewLiteral("("+strings.Join(values, ",")+")"))
+	}
+	return model.NewSimpleQuery(model.And([]model.Expr{
+		isChild,
+		cw.joinSubquery(join.ParentField, join.IdField, parentsWhere),
+	}), true)
+}
+
+// parseJoinInnerQuery returns WHERE of `relation` documents matching the inner query of `has_child`/`has_parent`
+func (cw *ClickhouseQueryTranslator) parseJoinInnerQuery(queryMap QueryMap, queryType, relation string) (model.Expr, bool) {
+	innerQuery, ok := queryMap["query"].(QueryMap)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("no query in %s query: %v", queryType, queryMap)
+		return nil, false
+	}
+	inner := cw.parseQueryMap(innerQuery)
+	if !inner.CanParse {
+		return nil, false
+	}
+	return model.And([]model.Expr{cw.relationEquals(relation), inner.WhereClause}), true
+}
+
+func (cw *ClickhouseQueryTranslator) relationEquals(relation string) model.Expr {
+	return model.NewInfixExpr(model.NewColumnRef(cw.Table.Join.RelationField), "=", model.NewLiteral(sprint(relation)))
+}
+
+// joinSubquery returns: field IN (SELECT selectedField FROM table WHERE where)
+func (cw *ClickhouseQueryTranslator) joinSubquery(field, selectedField string, where model.Expr) model.Expr {
+	subquery := model.NewSelectCommand([]model.Expr{model.NewColumnRef(selectedField)}, nil, nil,
+		model.NewTableRef(cw.Table.FullTableName()), where, 0, 0, false)
+	return model.NewInfixExpr(model.NewColumnRef(field), "IN", model.NewParenExpr(*subquery))
+}
+
 func (cw *ClickhouseQueryTranslator) parseDateMathExpression(expr string) (string, error) {
 	expr = strings.ReplaceAll(expr, "'", "")
 
@@ -789,10 +1464,15 @@ func (cw *ClickhouseQueryTranslator) parseRange(queryMap QueryMap) model.SimpleQ
 			logger.WarnWithCtx(cw.Ctx).Msgf("invalid range type: %T, value: %v", v, v)
 			continue
 		}
-		isDatetimeInDefaultFormat := true // in 99% requests, format is "strict_date_optional_time", which we can parse with time.Parse(time.RFC3339Nano, ..)
-		if format, ok := v.(QueryMap)["format"]; ok && format == "epoch_millis" {
-			isDatetimeInDefaultFormat = false
-		}
+		// in 99% requests, format is "strict_date_optional_time", which we can parse with time.Parse(time.RFC3339Nano, ..).
+		// Format can also be a few formats separated with ||, e.g. "yyyy-MM-dd||epoch_millis"
+		format, _ := v.(QueryMap)["format"].(string)
+		formats := strings.Split(format, "||")
+		isEpochMillis, isEpochSecond := slices.Contains(formats, "epoch_millis"), slices.Contains(formats, "epoch_second")
+		// explicit date pattern (e.g. yyyy-MM-dd), so a date doesn't have to be in ISO 8601 format
+		hasDatePattern := slices.ContainsFunc(formats, func(format string) bool {
+			return format != "" && format != "epoch_millis" && format != "epoch_second"
+		})
 
 		keysSorted := util.MapKeysSorted(v.(QueryMap))
 		for _, op := range keysSorted {
@@ -803,16 +1483,19 @@ func (cw *ClickhouseQueryTranslator) parseRange(queryMap QueryMap) model.SimpleQ
 			vToPrint := sprint(v)
 			valueToCompare = model.NewLiteral(vToPrint)
 			finalLHS = model.NewColumnRef(field)
-			if !isDatetimeInDefaultFormat {
+			epochValue, isEpochValue := rangeEpochValue(v)
+			if isEpochMillis && isEpochValue {
 				timeFormatFuncName = "toUnixTimestamp64Milli"
 				finalLHS = model.NewFunction(timeFormatFuncName, model.NewColumnRef(field))
+			} else if isEpochSecond && isEpochValue {
+				valueToCompare = model.NewFunction("toDateTime", model.NewLiteral(epochValue))
 			} else {
 				switch fieldType {
 				case clickhouse.DateTime64, clickhouse.DateTime:
 					if dateTime, ok := v.(string); ok {
 						// if it's a date, we need to parse it to Clickhouse's DateTime format
 						// how to check if it does not contain date math expression?
-						if _, err := iso8601.ParseString(dateTime); err == nil {
+						if _, err := iso8601.ParseString(dateTime); err == nil || (hasDatePattern && !isDateMathExpression(dateTime)) {
 							_, timeFormatFuncName = cw.parseDateTimeString(cw.Table, field, dateTime)
 							// TODO Investigate the quotation below
 							valueToCompare = model.NewFunction(timeFormatFuncName, model.NewLiteral(fmt.Sprintf("'%s'", dateTime)))
@@ -843,6 +1526,13 @@ func (cw *ClickhouseQueryTranslator) parseRange(queryMap QueryMap) model.SimpleQ
 						}
 						valueToCompare = model.NewLiteral(vToPrint)
 					}
+					if scale, isDecimal := cw.Table.GetDecimalScale(field); isDecimal && v != nil {
+						// compare with a Decimal, not a Float64, so the comparison is exact. Its scale is the column's,
+						// or the literal's, if it has more digits, so it isn't truncated (0.125 isn't 0.12)
+						literal, literalScale := decimalLiteral(strings.Trim(vToPrint, "'"))
+						valueToCompare = model.NewFunction("toDecimal128", model.NewLiteral("'"+literal+"'"),
+							model.NewLiteral(strconv.Itoa(max(scale, literalScale))))
+					}
 				default:
 					logger.WarnWithCtx(cw.Ctx).Msgf("invalid DateTime type for field: %s, parsed dateTime value: %s", field, vToPrint)
 				}
@@ -867,6 +1557,10 @@ func (cw *ClickhouseQueryTranslator) parseRange(queryMap QueryMap) model.SimpleQ
 				logger.WarnWithCtx(cw.Ctx).Msgf("invalid range operator: %s", op)
 			}
 		}
+		if len(stmts) == 0 {
+			logger.WarnWithCtx(cw.Ctx).Msgf("no comparison operators (gte, lte, gt, lt) in range: %v", queryMap)
+			return model.NewSimpleQuery(nil, false)
+		}
 		return model.NewSimpleQueryWithFieldName(model.And(stmts), true, field)
 	}
 
@@ -875,6 +1569,37 @@ func (cw *ClickhouseQueryTranslator) parseRange(queryMap QueryMap) model.SimpleQ
 	return model.NewSimpleQuery(nil, false)
 }
 
+// decimalLiteral returns number `literal` in plain notation (e.g. "1e-3" as "0.001"), and its scale: number of its fractional digits
+func decimalLiteral(literal string) (string, int) {
+	if strings.ContainsAny(literal, "eE") {
+		if asFloat, err := strconv.ParseFloat(literal, 64); err == nil {
+			literal = strconv.FormatFloat(asFloat, 'f', -1, 64)
+		}
+	}
+	if _, fraction, hasFraction := strings.Cut(literal, "."); hasFraction {
+		return literal, len(fraction)
+	}
+	return literal, 0
+}
+
+// rangeEpochValue returns range's bound `value` as a number (of seconds or milliseconds since epoch), false if it's not a number
+func rangeEpochValue(value any) (string, bool) {
+	switch valueTyped := value.(type) {
+	case float64:
+		return strconv.FormatFloat(valueTyped, 'f', -1, 64), true
+	case string:
+		if _, err := strconv.ParseFloat(valueTyped, 64); err == nil {
+			return valueTyped, true
+		}
+	}
+	return "", false
+}
+
+// isDateMathExpression returns true <=> `dateTime` is a date math expression, like now-1d/d or 2024-01-01||+1M
+func isDateMathExpression(dateTime string) bool {
+	return strings.HasPrefix(dateTime, "now") || strings.Contains(dateTime, "||")
+}
+
 // parseDateTimeString returns string used to parse DateTime in Clickhouse (depends on column type)
 
 func (cw *ClickhouseQueryTranslator) parseDateTimeString(table *clickhouse.Table, field, dateTime string) (string, string) {
@@ -911,7 +1636,7 @@ func (cw *ClickhouseQueryTranslator) parseExists(queryMap QueryMap) model.Simple
 			sql = model.NewInfixExpr(model.NewColumnRef(fieldName), "IS", model.NewLiteral("NOT NULL"))
 		case clickhouse.ExistsAndIsArray:
 			sql = model.NewInfixExpr(model.NewNestedProperty(
-				model.NewColumnRef(fieldNameQuoted),
+				model.NewColumnRef(fieldName),
 				model.NewLiteral("size0"),
 			), "=", model.NewLiteral("0"))
 		case clickhouse.NotExists:
@@ -942,8 +1667,10 @@ func (cw *ClickhouseQueryTranslator) parseExists(queryMap QueryMap) model.Simple
 }
 
 // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-regexp-query.html
-// We don't look at any parameter other than "value" (which is required, and is a regex pattern)
-// We log warning if any other parameter arrives
+// Elastic's regexps are anchored (match the whole value), so we anchor them too:
+// really simple patterns become LIKE (which always matches the whole value), others REGEXP '^(?:pattern)$'.
+// Matching is case-insensitive with `case_insensitive: true`, or CASE_INSENSITIVE in `flags`.
+// Other `flags` (optional operators, like COMPLEMENT or INTERVAL) aren't supported, such patterns are matched as they are.
 func (cw *ClickhouseQueryTranslator) parseRegexp(queryMap QueryMap) (result model.SimpleQuery) {
 	if len(queryMap) != 1 {
 		logger.WarnWithCtx(cw.Ctx).Msgf("we expect only 1 regexp, got: %d. value: %v", len(queryMap), queryMap)
@@ -952,13 +1679,12 @@ func (cw *ClickhouseQueryTranslator) parseRegexp(queryMap QueryMap) (result mode
 
 	// really simple == (out of all special characters, only . and .* may be present)
 	isPatternReallySimple := func(pattern string) bool {
-		// any special characters excluding . and * not allowed. Also (not the most important check) * can't be first character.
-		if strings.ContainsAny(pattern, `?+|{}[]()"\`) || (len(pattern) > 0 && pattern[0] == '*') {
-			return false
-		}
-		// .* allowed, but [any other char]* - not
-		for i, char := range pattern[1:] {
-			if char == '*' && pattern[i] != '.' {
+		for i, char := range pattern {
+			// .* allowed, but [any other char]* - not
+			if char == '*' && (i == 0 || pattern[i-1] != '.') {
+				return false
+			}
+			if strings.ContainsRune(`?+|{}[]()"\#@&<>~`, char) {
 				return false
 			}
 		}
@@ -982,17 +1708,38 @@ func (cw *ClickhouseQueryTranslator) parseRegexp(queryMap QueryMap) (result mode
 			return
 		}
 
-		if len(parameters) > 1 {
-			logger.WarnWithCtx(cw.Ctx).Msgf("unsupported regexp parameters: %v", parameters)
+		caseInsensitive := false
+		for name, value := range parameters {
+			switch name {
+			case "value", "max_determinized_states", "rewrite", "boost", "_name":
+				// ignored, as we don't score and don't build automatons
+			case "case_insensitive":
+				caseInsensitive, _ = value.(bool)
+			case "flags":
+				flags, _ := value.(string)
+				if slices.Contains(strings.Split(flags, "|"), "CASE_INSENSITIVE") {
+					caseInsensitive = true
+				}
+			default:
+				logger.WarnWithCtx(cw.Ctx).Msgf("unsupported regexp parameter: %s, value: %v", name, value)
+			}
 		}
 
 		var funcName string
 		if isPatternReallySimple(pattern) {
 			pattern = strings.ReplaceAll(pattern, "_", `\_`)
+			pattern = strings.ReplaceAll(pattern, "%", `\%`)
 			pattern = strings.ReplaceAll(pattern, ".*", "%")
 			pattern = strings.ReplaceAll(pattern, ".", "_")
 			funcName = "LIKE"
+			if caseInsensitive {
+				funcName = "iLIKE"
+			}
 		} else { // this Clickhouse function is much slower, so we use it only for complex regexps
+			pattern = "^(?:" + pattern + ")$"
+			if caseInsensitive {
+				pattern = "(?i)" + pattern
+			}
 			funcName = "REGEXP"
 		}
 		return model.NewSimpleQuery(
@@ -1104,7 +1851,6 @@ func (cw *ClickhouseQueryTranslator) isItFacetsRequest(queryMap QueryMap) (model
 		logger.WarnWithCtx(cw.Ctx).Msgf("invalid field type: %T, value: %v. Expected string", fieldNameRaw, fieldNameRaw)
 		return model.NewSearchQueryInfoNormal(), false
 	}
-	fieldName = strings.TrimSuffix(fieldName, ".keyword")
 	fieldName = cw.ResolveField(cw.Ctx, fieldName)
 
 	secondNestingMap, ok := queryMap["sampler"].(QueryMap)
@@ -1203,31 +1949,33 @@ func (cw *ClickhouseQueryTranslator) isItListRequest(queryMap QueryMap) (model.S
 	}
 }
 
-func (cw *ClickhouseQueryTranslator) extractInterval(queryMap QueryMap) string {
+// extractInterval returns fixed_interval or calendar_interval of a (date_)histogram, and whether it's the calendar one
+func (cw *ClickhouseQueryTranslator) extractInterval(queryMap QueryMap) (interval string, isCalendarInterval bool) {
 	const defaultInterval = "30s"
 	if fixedInterval, exists := queryMap["fixed_interval"]; exists {
 		if asString, ok := fixedInterval.(string); ok {
-			return asString
+			return asString, false
 		} else {
 			logger.WarnWithCtx(cw.Ctx).Msgf("unexpected type of interval: %T, value: %v. Returning default", fixedInterval, fixedInterval)
-			return defaultInterval
+			return defaultInterval, false
 		}
 	}
 	if calendarInterval, exists := queryMap["calendar_interval"]; exists {
 		if asString, ok := calendarInterval.(string); ok {
-			return asString
+			return asString, true
 		} else {
 			logger.WarnWithCtx(cw.Ctx).Msgf("unexpected type of interval: %T, value: %v. Returning default", calendarInterval, calendarInterval)
-			return defaultInterval
+			return defaultInterval, false
 		}
 	}
 
 	logger.WarnWithCtx(cw.Ctx).Msgf("extractInterval: no interval found, returning default: %s", defaultInterval)
-	return defaultInterval
+	return defaultInterval, false
 }
 
 // parseSortFields parses sort fields from the query
-// We're skipping ELK internal fields, like "_doc", "_id", etc. (we only accept field starting with "_" if it exists in our table)
+// We're skipping ELK internal fields, like "_doc", "_id", etc. (we only accept field starting with "_" if it exists in our table).
+// That includes "_score": we don't compute relevance, so sorting by it is a no-op, and the other sort keys still apply.
 func (cw *ClickhouseQueryTranslator) parseSortFields(sortMaps any) (sortColumns []model.OrderByExpr) {
 	sortColumns = make([]model.OrderByExpr, 0)
 	switch sortMaps := sortMaps.(type) {
@@ -1241,6 +1989,10 @@ func (cw *ClickhouseQueryTranslator) parseSortFields(sortMaps any) (sortColumns
 
 			// sortMap has only 1 key, so we can just iterate over it
 			for k, v := range sortMap {
+				if k == seqNoField && len(cw.Table.SeqNoFields) > 0 {
+					sortColumns = append(sortColumns, cw.seqNoSortColumns(v)...)
+					continue
+				}
 				if strings.HasPrefix(k, "_") && cw.Table.GetFieldInfo(cw.Ctx, cw.ResolveField(cw.Ctx, k)) == clickhouse.NotExists {
 					// we're skipping ELK internal fields, like "_doc", "_id", etc.
 					continue
@@ -1248,22 +2000,33 @@ func (cw *ClickhouseQueryTranslator) parseSortFields(sortMaps any) (sortColumns
 				fieldName := cw.ResolveField(cw.Ctx, k)
 				switch v := v.(type) {
 				case QueryMap:
+					if _, ok := v["unmapped_type"]; ok && cw.Table.GetFieldInfo(cw.Ctx, fieldName) == clickhouse.NotExists {
+						// like in Elastic, with unmapped_type sorting by a field we don't have is fine, it's just a no-op
+						logger.DebugWithCtx(cw.Ctx).Msgf("skipping sort by unmapped field %s", fieldName)
+						continue
+					}
+					orderAsString := "asc"
 					if order, ok := v["order"]; ok {
-						if orderAsString, ok := order.(string); ok {
-							if col, err := createSortColumn(fieldName, orderAsString); err == nil {
-								sortColumns = append(sortColumns, col)
-							} else {
-								logger.WarnWithCtx(cw.Ctx).Msg(err.Error())
-							}
-						} else {
+						if orderAsString, ok = order.(string); !ok {
 							logger.WarnWithCtx(cw.Ctx).Msgf("unexpected order type: %T, value: %v. Skipping", order, order)
+							continue
+						}
+					}
+					var missing string
+					if missingRaw, ok := v["missing"]; ok {
+						if missing, _ = missingRaw.(string); missing != missingFirst && missing != missingLast {
+							logger.WarnWithCtx(cw.Ctx).Msgf("unsupported missing value: %v for field %s. Using default", missingRaw, fieldName)
+							missing = ""
 						}
+					}
+					if cols, err := createSortColumn(fieldName, orderAsString, missing); err == nil {
+						sortColumns = append(sortColumns, cols...)
 					} else {
-						sortColumns = append(sortColumns, model.NewSortColumn(fieldName, model.AscOrder))
+						logger.WarnWithCtx(cw.Ctx).Msg(err.Error())
 					}
 				case string:
-					if col, err := createSortColumn(fieldName, v); err == nil {
-						sortColumns = append(sortColumns, col)
+					if cols, err := createSortColumn(fieldName, v, ""); err == nil {
+						sortColumns = append(sortColumns, cols...)
 					} else {
 						logger.WarnWithCtx(cw.Ctx).Msg(err.Error())
 					}
@@ -1275,13 +2038,17 @@ func (cw *ClickhouseQueryTranslator) parseSortFields(sortMaps any) (sortColumns
 		return sortColumns
 	case map[string]interface{}:
 		for fieldName, fieldValue := range sortMaps {
+			if fieldName == seqNoField && len(cw.Table.SeqNoFields) > 0 {
+				sortColumns = append(sortColumns, cw.seqNoSortColumns(fieldValue)...)
+				continue
+			}
 			if strings.HasPrefix(fieldName, "_") && cw.Table.GetFieldInfo(cw.Ctx, cw.ResolveField(cw.Ctx, fieldName)) == clickhouse.NotExists {
 				// TODO Elastic internal fields will need to be supported in the future
 				continue
 			}
 			if fieldValue, ok := fieldValue.(string); ok {
-				if col, err := createSortColumn(fieldName, fieldValue); err == nil {
-					sortColumns = append(sortColumns, col)
+				if cols, err := createSortColumn(fieldName, fieldValue, ""); err == nil {
+					sortColumns = append(sortColumns, cols...)
 				} else {
 					logger.WarnWithCtx(cw.Ctx).Msg(err.Error())
 				}
@@ -1296,8 +2063,8 @@ func (cw *ClickhouseQueryTranslator) parseSortFields(sortMaps any) (sortColumns
 				// TODO Elastic internal fields will need to be supported in the future
 				continue
 			}
-			if col, err := createSortColumn(fieldName, fieldValue); err == nil {
-				sortColumns = append(sortColumns, col)
+			if cols, err := createSortColumn(fieldName, fieldValue, ""); err == nil {
+				sortColumns = append(sortColumns, cols...)
 			} else {
 				logger.WarnWithCtx(cw.Ctx).Msg(err.Error())
 			}
@@ -1310,15 +2077,111 @@ func (cw *ClickhouseQueryTranslator) parseSortFields(sortMaps any) (sortColumns
 	}
 }
 
-func createSortColumn(fieldName, ordering string) (model.OrderByExpr, error) {
+// seqNoField is Elastic's sequence number. We don't have it, so it's backed by a monotonic key configured per index
+// (e.g. timestamp + a tiebreaker), which is enough for incremental readers sorting by it with `search_after`.
+const seqNoField = "_seq_no"
+
+// seqNoSortColumns returns sort columns of the monotonic key backing `_seq_no`, all in the requested order
+func (cw *ClickhouseQueryTranslator) seqNoSortColumns(sortValue any) []model.OrderByExpr {
+	ordering := "asc"
+	switch sortValue := sortValue.(type) {
+	case string:
+		ordering = sortValue
+	case QueryMap:
+		if order, ok := sortValue["order"].(string); ok {
+			ordering = order
+		}
+	}
+	sortColumns := make([]model.OrderByExpr, 0, len(cw.Table.SeqNoFields))
+	for _, fieldName := range cw.Table.SeqNoFields {
+		cols, err := createSortColumn(cw.ResolveField(cw.Ctx, fieldName), ordering, "")
+		if err != nil {
+			logger.WarnWithCtx(cw.Ctx).Msg(err.Error())
+			return nil
+		}
+		sortColumns = append(sortColumns, cols...)
+	}
+	return sortColumns
+}
+
+// parseSearchAfter returns condition selecting rows strictly after `searchAfter` values (in `orderBy` order),
+// nil if it can't be expressed. Values are the `sort` values of the last hit of the previous page.
+// We only support sorting in one direction, so the condition is a single tuple comparison.
+func (cw *ClickhouseQueryTranslator) parseSearchAfter(searchAfter any, orderBy []model.OrderByExpr) model.Expr {
+	values, ok := searchAfter.([]any)
+	if !ok {
+		logger.WarnWithCtx(cw.Ctx).Msgf("invalid search_after type: %T, value: %v", searchAfter, searchAfter)
+		return nil
+	}
+	if len(values) != len(orderBy) || len(values) == 0 {
+		logger.WarnWithCtx(cw.Ctx).Msgf("search_after %v doesn't match sort (%d fields). Skipping", values, len(orderBy))
+		return nil
+	}
+
+	columns := make([]model.Expr, 0, len(orderBy))
+	literals := make([]model.Expr, 0, len(values))
+	for i, orderByExpr := range orderBy {
+		if orderByExpr.Direction != orderBy[0].Direction {
+			logger.WarnWithCtx(cw.Ctx).Msgf("search_after with mixed sort directions is not supported. Skipping")
+			return nil
+		}
+		if len(orderByExpr.Exprs) != 1 {
+			logger.WarnWithCtx(cw.Ctx).Msgf("search_after with sort by expression %v is not supported. Skipping", orderByExpr.Exprs)
+			return nil
+		}
+		column, ok := orderByExpr.Exprs[0].(model.ColumnRef)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("search_after with sort by expression %v is not supported. Skipping", orderByExpr.Exprs[0])
+			return nil
+		}
+		columns = append(columns, column)
+
+		switch cw.Table.GetDateTimeType(cw.Ctx, column.ColumnName) {
+		case clickhouse.DateTime64, clickhouse.DateTime:
+			// timestamps are returned in `sort` as millis, see elasticsearch.FormatSortValue
+			if millis, ok := values[i].(float64); ok {
+				literals = append(literals, model.NewFunction("fromUnixTimestamp64Milli", model.NewLiteral(int64(millis))))
+				continue
+			}
+		}
+		literals = append(literals, model.NewLiteral(sprint(values[i])))
+	}
+
+	op := ">"
+	if orderBy[0].Direction == model.DescOrder {
+		op = "<"
+	}
+	return model.NewInfixExpr(model.NewFunction("tuple", columns...), op, model.NewFunction("tuple", literals...))
+}
+
+// values of sort's `missing`, saying where documents without the field go
+const (
+	missingFirst = "_first"
+	missingLast  = "_last"
+)
+
+// createSortColumn returns ORDER BY expressions for sorting by `fieldName`.
+// If `missing` is set, rows with NULLs are put first/last by sorting by `fieldName IS NULL` before.
+func createSortColumn(fieldName, ordering, missing string) ([]model.OrderByExpr, error) {
+	var column model.OrderByExpr
 	ordering = strings.ToLower(ordering)
 	switch ordering {
 	case "asc":
-		return model.NewSortColumn(fieldName, model.AscOrder), nil
+		column = model.NewSortColumn(fieldName, model.AscOrder)
 	case "desc":
-		return model.NewSortColumn(fieldName, model.DescOrder), nil
+		column = model.NewSortColumn(fieldName, model.DescOrder)
+	default:
+		return nil, fmt.Errorf("unexpected order value: [%s] for field [%s] Skipping", ordering, fieldName)
+	}
+
+	isNull := model.NewInfixExpr(model.NewColumnRef(fieldName), "IS", model.NewLiteral("NULL"))
+	switch missing {
+	case missingFirst:
+		return []model.OrderByExpr{model.NewOrderByExpr([]model.Expr{isNull}, model.DescOrder), column}, nil
+	case missingLast:
+		return []model.OrderByExpr{model.NewOrderByExpr([]model.Expr{isNull}, model.AscOrder), column}, nil
 	default:
-		return model.OrderByExpr{}, fmt.Errorf("unexpected order value: [%s] for field [%s] Skipping", ordering, fieldName)
+		return []model.OrderByExpr{column}, nil
 	}
 }
 
@@ -1341,7 +2204,7 @@ func (cw *ClickhouseQueryTranslator) ResolveField(ctx context.Context, fieldName
 		field = fieldName
 		return
 	}
-	if resolvedField, ok := schemaInstance.ResolveField(fieldName); ok {
+	if resolvedField, ok := schemaInstance.ResolveFieldWithMultiFields(fieldName); ok {
 		field = resolvedField.InternalPropertyName.AsString()
 	} else {
 		// fallback to original field name
@@ -1431,3 +2294,69 @@ func (cw *ClickhouseQueryTranslator) parseGeoBoundingBox(queryMap QueryMap) mode
 	}
 	return model.NewSimpleQuery(model.And(stmts), true)
 }
+
+// parseGeoPolygon generates an abstract GEO_POLYGON("Location", lon1, lat1, lon2, lat2, ...) function,
+// which is lowered later (in schema transformations) to a specific Clickhouse function.
+// Points can be given as {"lat": .., "lon": ..} objects, [lon, lat] arrays or "lat,lon" strings.
+func (cw *ClickhouseQueryTranslator) parseGeoPolygon(queryMap QueryMap) model.SimpleQuery {
+	for field, v := range queryMap {
+		fieldMap, ok := v.(QueryMap)
+		if !ok {
+			// e.g. "validation_method" or "_name" params
+			continue
+		}
+		points, ok := fieldMap["points"].([]interface{})
+		if !ok || len(points) < 3 {
+			logger.WarnWithCtx(cw.Ctx).Msgf("geo_polygon query needs at least 3 points: %v", queryMap)
+			return model.NewSimpleQuery(nil, false)
+		}
+		args := []model.Expr{model.NewColumnRef(cw.ResolveField(cw.Ctx, field))}
+		for _, point := range points {
+			lon, lat, ok := parseGeoPoint(point)
+			if !ok {
+				logger.WarnWithCtx(cw.Ctx).Msgf("invalid point %v in geo_polygon query: %v", point, queryMap)
+				return model.NewSimpleQuery(nil, false)
+			}
+			args = append(args, model.NewLiteral(lon), model.NewLiteral(lat))
+		}
+		return model.NewSimpleQuery(model.NewFunction("GEO_POLYGON", args...), true)
+	}
+	logger.WarnWithCtx(cw.Ctx).Msgf("no field in geo_polygon query: %v", queryMap)
+	return model.NewSimpleQuery(nil, false)
+}
+
+// parseGeoPoint returns longitude and latitude of a point in any of Elastic's geo_point formats.
+// Coordinates are validated to be numbers, as they end up in SQL as they are.
+func parseGeoPoint(point any) (lon, lat string, ok bool) {
+	var lonValue, latValue any
+	switch pointTyped := point.(type) {
+	case QueryMap:
+		lonValue, latValue = pointTyped["lon"], pointTyped["lat"]
+	case []interface{}:
+		if len(pointTyped) != 2 {
+			return "", "", false
+		}
+		lonValue, latValue = pointTyped[0], pointTyped[1]
+	case string:
+		latStr, lonStr, found := strings.Cut(pointTyped, ",")
+		if !found {
+			return "", "", false
+		}
+		lonValue, latValue = strings.TrimSpace(lonStr), strings.TrimSpace(latStr)
+	}
+	lon, lonOk := geoCoordinateAsString(lonValue)
+	lat, latOk := geoCoordinateAsString(latValue)
+	return lon, lat, lonOk && latOk
+}
+
+func geoCoordinateAsString(coordinate any) (string, bool) {
+	switch coordinateTyped := coordinate.(type) {
+	case float64:
+		return strconv.FormatFloat(coordinateTyped, 'f', -1, 64), true
+	case string:
+		if _, err := strconv.ParseFloat(coordinateTyped, 64); err == nil {
+			return coordinateTyped, true
+		}
+	}
+	return "", false
+}
@@ -13,7 +13,9 @@ import (
 	"quesma/schema"
 	"quesma/telemetry"
 	"quesma/testdata"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -164,6 +166,867 @@ func TestQueryParserNoFullTextFields(t *testing.T) {
 	}
 }
 
+func TestQueryParserFullTextStringFieldsByDefault(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs-without-fulltext-fields",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"body":       {Name: "body", Type: clickhouse.NewBaseType("String")},
+			"level":      {Name: "level", Type: clickhouse.NewBaseType("LowCardinality(String)")},
+			"bytes":      {Name: "bytes", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created:                       true,
+		FullTextStringFieldsByDefault: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs-without-fulltext-fields": {
+				Fields: map[schema.FieldName]schema.Field{
+					"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+					"body":       {PropertyName: "body", InternalPropertyName: "body", Type: schema.TypeText},
+					"level":      {PropertyName: "level", InternalPropertyName: "level", Type: schema.TypeKeyword},
+					"bytes":      {PropertyName: "bytes", InternalPropertyName: "bytes", Type: schema.TypeLong},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"query_string without fields", `{"query": {"query_string": {"query": "error"}}}`, `("body" = 'error' OR "level" = 'error')`},
+		{"multi_match without fields", `{"query": {"multi_match": {"query": "error"}}}`, `("body" iLIKE '%error%' OR "level" iLIKE '%error%')`},
+		{"multi_match with all fields", `{"query": {"multi_match": {"query": "error", "fields": ["*"]}}}`, `("body" iLIKE '%error%' OR "level" iLIKE '%error%')`},
+		{"query_string with explicit fields", `{"query": {"query_string": {"query": "error", "fields": ["body"]}}}`, `"body" = 'error'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+}
+
+func TestQueryParserHighlightQuery(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs-with-highlight",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"message":    {Name: "message", Type: clickhouse.NewBaseType("String"), IsFullTextMatch: true},
+			"level":      {Name: "level", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs-with-highlight": {
+				Fields: map[schema.FieldName]schema.Field{
+					"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+					"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+					"level":      {PropertyName: "level", InternalPropertyName: "level", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	const highlight = `"pre_tags": ["<em>"], "post_tags": ["</em>"]`
+	tests := []struct {
+		name       string
+		query      string
+		wantTokens map[string]model.Tokens
+	}{
+		{
+			"tokens from the search query",
+			`{"query": {"match": {"message": "failed"}}, "highlight": {` + highlight + `}}`,
+			map[string]model.Tokens{"message": {"failed": {}}},
+		},
+		{
+			"tokens from highlight_query, not the search query",
+			`{"query": {"term": {"level": "error"}}, "highlight": {` + highlight + `,
+				"highlight_query": {"bool": {"should": [{"match": {"message": "failed"}}, {"match": {"message": "timeout"}}]}}}}`,
+			map[string]model.Tokens{"message": {"failed": {}, "timeout": {}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			listQuery := queries[len(queries)-1]
+			if _, isHits := listQuery.Type.(*typical_queries.Hits); !assert.True(t, isHits) {
+				return
+			}
+			assert.Equal(t, tt.wantTokens, listQuery.Highlighter.Tokens)
+		})
+	}
+}
+
+func TestQueryParserCaseInsensitiveTerm(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"host": {Name: "host", Type: clickhouse.NewBaseType("String")},
+			"user": {Name: "user", Type: clickhouse.NewBaseType("String")},
+			"code": {Name: "code", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created:               true,
+		CaseInsensitiveFields: []string{"host", "code"},
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"host": {PropertyName: "host", InternalPropertyName: "host", Type: schema.TypeKeyword},
+					"user": {PropertyName: "user", InternalPropertyName: "user", Type: schema.TypeKeyword},
+					"code": {PropertyName: "code", InternalPropertyName: "code", Type: schema.TypeLong},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"case-sensitive field", `{"query": {"term": {"user": "Alice"}}}`, `"user"='Alice'`},
+		{"case-insensitive field", `{"query": {"term": {"host": "Web-01"}}}`, `lower("host")=lower('Web-01')`},
+		{"case-insensitive field, value object", `{"query": {"term": {"host": {"value": "Web-01"}}}}`, `lower("host")=lower('Web-01')`},
+		{"case-insensitive field, non-string value", `{"query": {"term": {"code": 500}}}`, `"code"=500`},
+		{"case_insensitive in the term", `{"query": {"term": {"user": {"value": "Alice", "case_insensitive": true}}}}`, `lower("user")=lower('Alice')`},
+		{"case_insensitive false in the term", `{"query": {"term": {"host": {"value": "Web-01", "case_insensitive": false}}}}`, `"host"='Web-01'`},
+		{"terms, case-sensitive field", `{"query": {"terms": {"user": ["Alice", "Bob"]}}}`, `"user" IN ('Alice','Bob')`},
+		{"terms, case-insensitive field", `{"query": {"terms": {"host": ["Web-01", "Web-02"]}}}`, `lower("host") IN (lower('Web-01'),lower('Web-02'))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+}
+
+func TestQueryParserBooleanTerm(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"active": {Name: "active", Type: clickhouse.NewBaseType("Bool")},
+			"user":   {Name: "user", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"active": {PropertyName: "active", InternalPropertyName: "active", Type: schema.TypeBoolean},
+					"user":   {PropertyName: "user", InternalPropertyName: "user", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		value     string
+		wantWhere string
+	}{
+		{`true`, `"active"=1`},
+		{`false`, `"active"=0`},
+		{`"true"`, `"active"=1`},
+		{`"false"`, `"active"=0`},
+		{`"TRUE"`, `"active"=1`},
+		{`1`, `"active"=1`},
+		{`0`, `"active"=0`},
+		{`"1"`, `"active"=1`},
+		{`"0"`, `"active"=0`},
+		{`"on"`, `"active"=1`},
+		{`"off"`, `"active"=0`},
+		{`"yes"`, `"active"=1`},
+		{`"no"`, `"active"=0`},
+		{`{"value": "yes"}`, `"active"=1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"query": {"term": {"active": ` + tt.value + `}}}`)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	otherTests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"terms", `{"query": {"terms": {"active": ["on", false]}}}`, `"active" IN (1,0)`},
+		{"not a boolean field", `{"query": {"term": {"user": "yes"}}}`, `"user"='yes'`},
+	}
+	for _, tt := range otherTests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+}
+
+func TestQueryParserIgnoreAbove(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"host": {Name: "host", Type: clickhouse.NewBaseType("String")},
+			"user": {Name: "user", Type: clickhouse.NewBaseType("String")},
+		},
+		Created:     true,
+		IgnoreAbove: map[string]int{"host": 8},
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"host": {PropertyName: "host", InternalPropertyName: "host", Type: schema.TypeKeyword},
+					"user": {PropertyName: "user", InternalPropertyName: "user", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"value within ignore_above", `{"query": {"term": {"host": "web-01"}}}`, `"host"='web-01'`},
+		{"value over ignore_above", `{"query": {"term": {"host": "web-01.example.com"}}}`, `false`},
+		{"value over ignore_above, value object", `{"query": {"term": {"host": {"value": "web-01.example.com"}}}}`, `false`},
+		{"field without ignore_above", `{"query": {"term": {"user": "alice.smith.long"}}}`, `"user"='alice.smith.long'`},
+		{"terms, some values over ignore_above", `{"query": {"terms": {"host": ["web-01", "web-01.example.com", "web-02"]}}}`, `"host" IN ('web-01','web-02')`},
+		{"terms, all values over ignore_above", `{"query": {"terms": {"host": ["web-01.example.com", "web-02.example.com"]}}}`, `false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+}
+
+func TestQueryParserCombinedFields(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"title": {Name: "title", Type: clickhouse.NewBaseType("String")},
+			"body":  {Name: "body", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"title": {PropertyName: "title", InternalPropertyName: "title", Type: schema.TypeText},
+					"body":  {PropertyName: "body", InternalPropertyName: "body", Type: schema.TypeText},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"operator or (default)", `{"query": {"combined_fields": {"query": "database systems", "fields": ["title", "body"]}}}`,
+			`(("title" iLIKE '%database%' OR "body" iLIKE '%database%') OR ("title" iLIKE '%systems%' OR "body" iLIKE '%systems%'))`},
+		{"operator and", `{"query": {"combined_fields": {"query": "database systems", "fields": ["title", "body"], "operator": "and"}}}`,
+			`(("title" iLIKE '%database%' OR "body" iLIKE '%database%') AND ("title" iLIKE '%systems%' OR "body" iLIKE '%systems%'))`},
+		{"boosted field, single term", `{"query": {"combined_fields": {"query": "database", "fields": ["title^2", "body"], "operator": "AND"}}}`,
+			`("title" iLIKE '%database%' OR "body" iLIKE '%database%')`},
+		{"empty query", `{"query": {"combined_fields": {"query": " ", "fields": ["title", "body"]}}}`, `false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	body, parseErr := types.ParseJSON(`{"query": {"combined_fields": {"query": "database", "fields": ["title"], "operator": "xor"}}}`)
+	assert.NoError(t, parseErr)
+	_, canParse, _ := cw.ParseQuery(body)
+	assert.False(t, canParse)
+}
+
+func TestQueryParserMultiMatchTypes(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"title": {Name: "title", Type: clickhouse.NewBaseType("String")},
+			"body":  {Name: "body", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"title": {PropertyName: "title", InternalPropertyName: "title", Type: schema.TypeText},
+					"body":  {PropertyName: "body", InternalPropertyName: "body", Type: schema.TypeText},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	multiMatch := func(matchType string) string {
+		typePart := ""
+		if matchType != "" {
+			typePart = `, "type": "` + matchType + `"`
+		}
+		return `{"query": {"multi_match": {"query": "quick bro", "fields": ["title", "body"]` + typePart + `}}}`
+	}
+	const anyWordInAnyField = `((("title" iLIKE '%quick%' OR "title" iLIKE '%bro%') OR "body" iLIKE '%quick%') OR "body" iLIKE '%bro%')`
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"no type (best_fields)", multiMatch(""), anyWordInAnyField},
+		{"best_fields", multiMatch("best_fields"), anyWordInAnyField},
+		{"most_fields", multiMatch("most_fields"), anyWordInAnyField},
+		{"cross_fields", multiMatch("cross_fields"),
+			`(("title" iLIKE '%quick%' OR "body" iLIKE '%quick%') AND ("title" iLIKE '%bro%' OR "body" iLIKE '%bro%'))`},
+		{"cross_fields, operator or", `{"query": {"multi_match": {"query": "quick bro", "fields": ["title", "body"], "type": "cross_fields", "operator": "or"}}}`,
+			anyWordInAnyField},
+		{"phrase", multiMatch("phrase"), `("title" iLIKE '%quick bro%' OR "body" iLIKE '%quick bro%')`},
+		{"phrase_prefix", multiMatch("phrase_prefix"),
+			`((("title" iLIKE 'quick bro%' OR "title" iLIKE '% quick bro%') OR "body" iLIKE 'quick bro%') OR "body" iLIKE '% quick bro%')`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+}
+
+func TestQueryParserMultiMatchOperator(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"title": {Name: "title", Type: clickhouse.NewBaseType("String")},
+			"body":  {Name: "body", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"title": {PropertyName: "title", InternalPropertyName: "title", Type: schema.TypeText},
+					"body":  {PropertyName: "body", InternalPropertyName: "body", Type: schema.TypeText},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	multiMatch := func(params string) string {
+		return `{"query": {"multi_match": {"query": "database systems", "fields": ["title", "body"]` + params + `}}}`
+	}
+	const anyWord = `((("title" iLIKE '%database%' OR "title" iLIKE '%systems%') OR "body" iLIKE '%database%') OR "body" iLIKE '%systems%')`
+	const allWords = `(("title" iLIKE '%database%' OR "body" iLIKE '%database%') AND ("title" iLIKE '%systems%' OR "body" iLIKE '%systems%'))`
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"operator or (default)", multiMatch(``), anyWord},
+		{"operator or", multiMatch(`, "operator": "or"`), anyWord},
+		{"operator and", multiMatch(`, "operator": "AND", "tie_breaker": 0.3`), allWords},
+		{"operator and, most_fields", multiMatch(`, "operator": "and", "type": "most_fields"`), allWords},
+		{"minimum_should_match 1", multiMatch(`, "minimum_should_match": 1`), anyWord},
+		{"minimum_should_match all words", multiMatch(`, "minimum_should_match": "2"`), allWords},
+		{"minimum_should_match clamped to all words", multiMatch(`, "minimum_should_match": 5`), allWords},
+		{"minimum_should_match 100%", multiMatch(`, "minimum_should_match": "100%"`), allWords},
+		{"minimum_should_match 50%", multiMatch(`, "minimum_should_match": "50%"`), anyWord},
+		{"minimum_should_match -1", multiMatch(`, "minimum_should_match": -1`), anyWord},
+		{"empty query", `{"query": {"multi_match": {"query": " ", "fields": ["title", "body"]}}}`, `false`},
+		{"empty query, cross_fields", `{"query": {"multi_match": {"query": "", "fields": ["title", "body"], "type": "cross_fields"}}}`, `false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	for _, invalid := range []string{`, "operator": "xor"`, `, "minimum_should_match": "many"`} {
+		body, parseErr := types.ParseJSON(multiMatch(invalid))
+		assert.NoError(t, parseErr)
+		_, canParse, _ := cw.ParseQuery(body)
+		assert.False(t, canParse, invalid)
+	}
+}
+
+func TestQueryParserIntervals(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"my_text": {Name: "my_text", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"my_text": {PropertyName: "my_text", InternalPropertyName: "my_text", Type: schema.TypeText},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"ordered all_of two phrases",
+			`{"query": {"intervals": {"my_text": {"all_of": {"ordered": true, "intervals": [
+				{"match": {"query": "my favorite food", "max_gaps": 0, "ordered": true}},
+				{"match": {"query": "hot water", "max_gaps": 0, "ordered": true}}
+			]}}}}}`,
+			`(("my_text" iLIKE '%my favorite food%' AND "my_text" iLIKE '%hot water%') AND ` +
+				`positionCaseInsensitive("my_text",'hot water',positionCaseInsensitive("my_text",'my favorite food')+16)>0)`},
+		{"ordered all_of two phrases, max_gaps 0",
+			`{"query": {"intervals": {"my_text": {"all_of": {"ordered": true, "max_gaps": 0, "intervals": [
+				{"match": {"query": "my favorite food", "max_gaps": 0, "ordered": true}},
+				{"match": {"query": "hot"}}
+			]}}}}}`,
+			`"my_text" iLIKE '%my favorite food hot%'`},
+		{"unordered all_of",
+			`{"query": {"intervals": {"my_text": {"all_of": {"intervals": [
+				{"match": {"query": "hot"}},
+				{"match": {"query": "water"}}
+			]}}}}}`,
+			`("my_text" iLIKE '%hot%' AND "my_text" iLIKE '%water%')`},
+		{"ordered match",
+			`{"query": {"intervals": {"my_text": {"match": {"query": "hot water", "ordered": true}}}}}`,
+			`(("my_text" iLIKE '%hot%' AND "my_text" iLIKE '%water%') AND ` +
+				`positionCaseInsensitive("my_text",'water',positionCaseInsensitive("my_text",'hot')+3)>0)`},
+		{"ordered all_of with any_of, order not checked",
+			`{"query": {"intervals": {"my_text": {"all_of": {"ordered": true, "intervals": [
+				{"match": {"query": "my favorite food", "max_gaps": 0, "ordered": true}},
+				{"any_of": {"intervals": [{"match": {"query": "hot water"}}, {"match": {"query": "porridge"}}]}}
+			]}}}}}`,
+			`("my_text" iLIKE '%my favorite food%' AND (("my_text" iLIKE '%hot%' AND "my_text" iLIKE '%water%') OR "my_text" iLIKE '%porridge%'))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	body, parseErr := types.ParseJSON(`{"query": {"intervals": {"my_text": {"fuzzy": {"term": "watr"}}}}}`)
+	assert.NoError(t, parseErr)
+	_, canParse, _ := cw.ParseQuery(body)
+	assert.False(t, canParse)
+}
+
+func TestQueryParserHasChildHasParent(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "qa",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"id":                    {Name: "id", Type: clickhouse.NewBaseType("String")},
+			"text":                  {Name: "text", Type: clickhouse.NewBaseType("String")},
+			"my_join_field::name":   {Name: "my_join_field::name", Type: clickhouse.NewBaseType("LowCardinality(String)")},
+			"my_join_field::parent": {Name: "my_join_field::parent", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+		Join: &config.JoinConfiguration{
+			RelationField: "my_join_field::name",
+			ParentField:   "my_join_field::parent",
+			IdField:       "id",
+			Relations:     map[string][]string{"question": {"answer", "comment"}},
+		},
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"qa": {
+				Fields: map[schema.FieldName]schema.Field{
+					"id":                    {PropertyName: "id", InternalPropertyName: "id", Type: schema.TypeKeyword},
+					"text":                  {PropertyName: "text", InternalPropertyName: "text", Type: schema.TypeText},
+					"my_join_field::name":   {PropertyName: "my_join_field.name", InternalPropertyName: "my_join_field::name", Type: schema.TypeKeyword},
+					"my_join_field::parent": {PropertyName: "my_join_field.parent", InternalPropertyName: "my_join_field::parent", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"has_child", `{"query": {"has_child": {"type": "answer", "query": {"term": {"text": "yes"}}}}}`,
+			`("my_join_field::name"='question' AND "id" IN (SELECT "my_join_field::parent" FROM "qa" ` +
+				`WHERE ("my_join_field::name"='answer' AND "text"='yes')))`},
+		{"has_child, match_all", `{"query": {"has_child": {"type": "comment", "query": {"match_all": {}}}}}`,
+			`("my_join_field::name"='question' AND "id" IN (SELECT "my_join_field::parent" FROM "qa" ` +
+				`WHERE "my_join_field::name"='comment'))`},
+		{"has_parent", `{"query": {"has_parent": {"parent_type": "question", "query": {"term": {"text": "why"}}}}}`,
+			`("my_join_field::name" IN ('answer','comment') AND "my_join_field::parent" IN (SELECT "id" FROM "qa" ` +
+				`WHERE ("my_join_field::name"='question' AND "text"='why')))`},
+		{"has_child in bool", `{"query": {"bool": {"filter": [{"term": {"text": "why"}}, {"has_child": {"type": "answer", "query": {"match_all": {}}}}]}}}`,
+			`("text"='why' AND ("my_join_field::name"='question' AND "id" IN (SELECT "my_join_field::parent" FROM "qa" ` +
+				`WHERE "my_join_field::name"='answer')))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	for _, query := range []string{
+		`{"query": {"has_child": {"type": "question", "query": {"match_all": {}}}}}`,       // not a child relation
+		`{"query": {"has_parent": {"parent_type": "answer", "query": {"match_all": {}}}}}`, // not a parent relation
+		`{"query": {"has_child": {"type": "answer"}}}`,
+	} {
+		body, parseErr := types.ParseJSON(query)
+		assert.NoError(t, parseErr)
+		_, canParse, _ := cw.ParseQuery(body)
+		assert.False(t, canParse, query)
+	}
+
+	tableWithoutJoin := table
+	tableWithoutJoin.Join = nil
+	cwWithoutJoin := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &tableWithoutJoin, Ctx: context.Background(), SchemaRegistry: s}
+	body, parseErr := types.ParseJSON(`{"query": {"has_child": {"type": "answer", "query": {"match_all": {}}}}}`)
+	assert.NoError(t, parseErr)
+	_, canParse, _ := cwWithoutJoin.ParseQuery(body)
+	assert.False(t, canParse)
+}
+
+func TestQueryParserGeoPolygon(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"location": {Name: "location", Type: clickhouse.NewBaseType("Point")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"location": {PropertyName: "location", InternalPropertyName: "location", Type: schema.TypePoint},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	const triangleWhere = `GEO_POLYGON("location",-70,40,-80,30,-90,20.5)`
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"triangle, lat/lon objects",
+			`{"query": {"geo_polygon": {"location": {"points": [{"lat": 40, "lon": -70}, {"lat": 30, "lon": -80}, {"lat": 20.5, "lon": -90}]}}}}`,
+			triangleWhere},
+		{"triangle, [lon, lat] arrays",
+			`{"query": {"geo_polygon": {"location": {"points": [[-70, 40], [-80, 30], [-90, 20.5]]}}}}`,
+			triangleWhere},
+		{"triangle, 'lat,lon' strings, in filter",
+			`{"query": {"bool": {"filter": {"geo_polygon": {"location": {"points": ["40,-70", "30, -80", "20.5,-90"]}, "validation_method": "STRICT"}}}}}`,
+			triangleWhere},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	for _, invalidQuery := range []string{
+		`{"query": {"geo_polygon": {"location": {"points": [[-70, 40], [-80, 30]]}}}}`,
+		`{"query": {"geo_polygon": {"location": {"points": [[-70, 40], [-80, 30], ["-90; DROP TABLE logs", 20]]}}}}`,
+	} {
+		body, parseErr := types.ParseJSON(invalidQuery)
+		assert.NoError(t, parseErr)
+		_, canParse, _ := cw.ParseQuery(body)
+		assert.False(t, canParse, invalidQuery)
+	}
+}
+
+func TestQueryParserCollapse(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"user.id":    {Name: "user.id", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+					"user.id":    {PropertyName: "user.id", InternalPropertyName: "user.id", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantSql string
+	}{
+		{
+			"collapse with sort",
+			`{"collapse": {"field": "user.id"}, "sort": [{"@timestamp": {"order": "desc"}}], "size": 5, "track_total_hits": false}`,
+			`SELECT * FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "@timestamp" DESC) AS "row_number" FROM "logs") ` +
+				`WHERE "row_number"=1 ORDER BY "@timestamp" DESC LIMIT 5`,
+		},
+		{
+			"collapse with filter, without sort",
+			`{"collapse": {"field": "user.id"}, "query": {"term": {"user.id": "kimchy"}}, "size": 5, "track_total_hits": false}`,
+			`SELECT * FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "user.id") AS "row_number" FROM "logs" WHERE "user.id"='kimchy') ` +
+				`WHERE "row_number"=1 LIMIT 5`,
+		},
+		{
+			"collapse with inner_hits",
+			`{"collapse": {"field": "user.id", "inner_hits": {"name": "most_recent", "size": 2, "sort": [{"@timestamp": "asc"}]}}, ` +
+				`"sort": [{"@timestamp": {"order": "desc"}}], "size": 5, "track_total_hits": false}`,
+			`SELECT *, "row_number", "inner_hits_row_number", "inner_hits_total", "user.id" AS "inner_hits_collapse_key" ` +
+				`FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "@timestamp" DESC) AS "row_number", ` +
+				`ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "@timestamp" ASC) AS "inner_hits_row_number", ` +
+				`count() OVER (PARTITION BY "user.id") AS "inner_hits_total" FROM "logs" ` +
+				`WHERE "user.id" IN (SELECT "user.id" FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "@timestamp" DESC) AS "row_number" FROM "logs") ` +
+				`WHERE "row_number"=1 ORDER BY "@timestamp" DESC LIMIT 5)) ` +
+				`WHERE ("row_number"=1 OR "inner_hits_row_number"<=2) ORDER BY "@timestamp" DESC LIMIT 15`,
+		},
+		{
+			"collapse with inner_hits, many sort fields",
+			`{"collapse": {"field": "user.id", "inner_hits": {"name": "top", "size": 1, "sort": [{"a": "desc"}, {"b": "asc"}]}}, ` +
+				`"sort": [{"@timestamp": {"order": "desc"}}, {"a": "asc"}], "size": 5, "track_total_hits": false}`,
+			`SELECT *, "row_number", "inner_hits_row_number", "inner_hits_total", "user.id" AS "inner_hits_collapse_key" ` +
+				`FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "@timestamp" DESC, "a" ASC) AS "row_number", ` +
+				`ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "a" DESC, "b" ASC) AS "inner_hits_row_number", ` +
+				`count() OVER (PARTITION BY "user.id") AS "inner_hits_total" FROM "logs" ` +
+				`WHERE "user.id" IN (SELECT "user.id" FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "@timestamp" DESC, "a" ASC) AS "row_number" FROM "logs") ` +
+				`WHERE "row_number"=1 ORDER BY "@timestamp" DESC, "a" ASC LIMIT 5)) ` +
+				`WHERE ("row_number"=1 OR "inner_hits_row_number"<=1) ORDER BY "@timestamp" DESC, "a" ASC LIMIT 10`,
+		},
+		{
+			"no collapse",
+			`{"sort": [{"@timestamp": {"order": "desc"}}], "size": 5, "track_total_hits": false}`,
+			`SELECT * FROM "logs" ORDER BY "@timestamp" DESC LIMIT 5`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.Len(t, queries, 1)
+			assert.Equal(t, tt.wantSql, queries[0].SelectCommand.String())
+		})
+	}
+}
+
+func TestQueryParserRuntimeFields(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"a":       {Name: "a", Type: clickhouse.NewBaseType("Int64")},
+			"b":       {Name: "b", Type: clickhouse.NewBaseType("Int64")},
+			"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"a":       {PropertyName: "a", InternalPropertyName: "a", Type: schema.TypeLong},
+					"b":       {PropertyName: "b", InternalPropertyName: "b", Type: schema.TypeLong},
+					"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantSql string
+	}{
+		{
+			"a + b",
+			`{"runtime_mappings": {"total": {"type": "long", "script": {"source": "emit(doc['a'].value + doc['b'].value);"}}}, ` +
+				`"size": 5, "track_total_hits": false}`,
+			`SELECT *, ("a"+"b") AS "total" FROM "logs" LIMIT 5`,
+		},
+		{
+			"arithmetic, referenced in query",
+			`{"runtime_mappings": {"ratio": {"type": "double", "script": "emit((doc['a'].value - 1) / -doc[\"b\"].value * 2.5)"}}, ` +
+				`"query": {"range": {"ratio": {"gte": 10}}}, "size": 5, "track_total_hits": false}`,
+			`SELECT *, (("a"-1)/negate("b")*2.5) AS "ratio" FROM "logs" WHERE (("a"-1)/negate("b")*2.5)>=10 LIMIT 5`,
+		},
+		{
+			"unsupported script skipped",
+			`{"runtime_mappings": {"hour_of_day": {"type": "long", "script": {"source": "emit(doc['timestamp'].value.getHour());"}}}, ` +
+				`"size": 5, "track_total_hits": false}`,
+			`SELECT * FROM "logs" LIMIT 5`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.Len(t, queries, 1)
+			assert.Equal(t, tt.wantSql, queries[0].SelectCommand.String())
+		})
+	}
+
+	t.Run("runtime field in hits", func(t *testing.T) {
+		body := types.MustJSON(`{"runtime_mappings": {"total": {"type": "long", "script": {"source": "emit(doc['a'].value + doc['b'].value)"}}}, "track_total_hits": false}`)
+		queries, _, err := cw.ParseQuery(body)
+		assert.NoError(t, err)
+		rows := []model.QueryResultRow{{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol("a", int64(1)), model.NewQueryResultCol("b", int64(2)),
+			model.NewQueryResultCol("message", "m"), model.NewQueryResultCol("total", int64(3)),
+		}}}
+		response := cw.MakeSearchResponse(queries, [][]model.QueryResultRow{rows})
+		if assert.Len(t, response.Hits.Hits, 1) {
+			hit := response.Hits.Hits[0]
+			assert.Equal(t, []any{int64(3)}, hit.Fields["total"])
+			source, err := types.ParseJSON(string(hit.Source))
+			assert.NoError(t, err)
+			assert.NotContains(t, source, "total")
+			assert.Contains(t, source, "a")
+		}
+	})
+}
+
 // TODO this test gives wrong results??
 func TestQueryParserNoAttrsConfig(t *testing.T) {
 	tableName := "logs-generic-default"
@@ -228,6 +1091,65 @@ func TestQueryParserNoAttrsConfig(t *testing.T) {
 	}
 }
 
+func TestQueryParserMessageField(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs-with-message",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"body":       {Name: "body", Type: clickhouse.NewBaseType("String"), IsFullTextMatch: true},
+			"content":    {Name: "content", Type: clickhouse.NewBaseType("String"), IsFullTextMatch: true},
+		},
+		Created:      true,
+		MessageField: "body",
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs-with-message": {
+				Fields: map[schema.FieldName]schema.Field{
+					"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+					"body":       {PropertyName: "body", InternalPropertyName: "body", Type: schema.TypeText},
+					"content":    {PropertyName: "content", InternalPropertyName: "content", Type: schema.TypeText},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"query_string without fields", `{"query": {"query_string": {"query": "error"}}}`, `"body" = 'error'`},
+		{"multi_match without fields", `{"query": {"multi_match": {"query": "error"}}}`, `"body" iLIKE '%error%'`},
+		{"query_string with explicit fields", `{"query": {"query_string": {"query": "error", "fields": ["content"]}}}`, `"content" = 'error'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	t.Run("message field always returned in hits", func(t *testing.T) {
+		body, parseErr := types.ParseJSON(`{"fields": [{"field": "@timestamp"}], "size": 10, "track_total_hits": false}`)
+		assert.NoError(t, parseErr)
+		queries, canParse, errQuery := cw.ParseQuery(body)
+		assert.NoError(t, errQuery)
+		assert.True(t, canParse)
+		assert.Len(t, queries, 1)
+		assert.Equal(t, []model.Expr{model.NewColumnRef("@timestamp"), model.NewColumnRef("body")}, queries[0].SelectCommand.Columns)
+	})
+}
+
 // TODO this will be updated in the next PR
 var tests = []string{
 	`{
@@ -461,6 +1383,39 @@ func Test_parseSortFields(t *testing.T) {
 			sortMap:     []any{},
 			sortColumns: []model.OrderByExpr{},
 		},
+		{
+			name: "_score",
+			sortMap: []any{
+				QueryMap{"_score": "desc"},
+				QueryMap{"@timestamp": "desc"},
+			},
+			sortColumns: []model.OrderByExpr{model.NewSortColumn("@timestamp", model.DescOrder)},
+		},
+		{
+			name: "missing _first and _last",
+			sortMap: []any{
+				QueryMap{"service.name": QueryMap{"order": "asc", "missing": "_first"}},
+				QueryMap{"no_order_field": QueryMap{"order": "desc", "missing": "_last"}},
+			},
+			sortColumns: []model.OrderByExpr{
+				model.NewOrderByExpr([]model.Expr{model.NewInfixExpr(model.NewColumnRef("service.name"), "IS", model.NewLiteral("NULL"))}, model.DescOrder),
+				model.NewSortColumn("service.name", model.AscOrder),
+				model.NewOrderByExpr([]model.Expr{model.NewInfixExpr(model.NewColumnRef("no_order_field"), "IS", model.NewLiteral("NULL"))}, model.AscOrder),
+				model.NewSortColumn("no_order_field", model.DescOrder),
+			},
+		},
+		{
+			name: "unmapped field",
+			sortMap: []any{
+				QueryMap{"not_in_table": QueryMap{"order": "desc", "unmapped_type": "long"}}, // skipped, as it's not in the table
+				QueryMap{"not_in_table_either": QueryMap{"order": "desc"}},                   // without unmapped_type, we still sort by it
+				QueryMap{"@timestamp": QueryMap{"order": "desc", "unmapped_type": "boolean"}},
+			},
+			sortColumns: []model.OrderByExpr{
+				model.NewSortColumn("not_in_table_either", model.DescOrder),
+				model.NewSortColumn("@timestamp", model.DescOrder),
+			},
+		},
 		{
 			name: "map[string]string",
 			sortMap: map[string]string{
@@ -498,3 +1453,401 @@ func Test_parseSortFields(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryParserDistanceFeature(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"name":            {Name: "name", Type: clickhouse.NewBaseType("String")},
+			"production_date": {Name: "production_date", Type: clickhouse.NewBaseType("DateTime64")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"name":            {PropertyName: "name", InternalPropertyName: "name", Type: schema.TypeText},
+					"production_date": {PropertyName: "production_date", InternalPropertyName: "production_date", Type: schema.TypeDate},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantWhere   string
+		wantOrderBy string
+	}{
+		{"date origin",
+			`{"query": {"distance_feature": {"field": "production_date", "pivot": "7d", "origin": "2024-06-01T00:00:00Z"}}}`,
+			``,
+			`abs(dateDiff('millisecond',parseDateTime64BestEffort('2024-06-01T00:00:00Z'),"production_date")) ASC`},
+		{"in bool should, with explicit sort",
+			`{"query": {"bool": {
+				"must": {"match": {"name": "chocolate"}},
+				"should": {"distance_feature": {"field": "production_date", "pivot": "7d", "origin": "2024-06-01T00:00:00Z"}}
+			}}, "sort": [{"name": "desc"}]}`,
+			`"name" iLIKE '%chocolate%'`,
+			`"name" DESC, abs(dateDiff('millisecond',parseDateTime64BestEffort('2024-06-01T00:00:00Z'),"production_date")) ASC`},
+		{"wrapped in constant_score and nested",
+			`{"query": {"constant_score": {"filter": {"nested": {"path": "p", "query": {
+				"distance_feature": {"field": "production_date", "pivot": "7d", "origin": "2024-06-01T00:00:00Z"}}}}}}}`,
+			``,
+			`abs(dateDiff('millisecond',parseDateTime64BestEffort('2024-06-01T00:00:00Z'),"production_date")) ASC`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			listQuery := queries[len(queries)-1]
+			assert.Equal(t, tt.wantWhere, model.AsString(listQuery.SelectCommand.WhereClause))
+			orderBy := make([]string, 0, len(listQuery.SelectCommand.OrderBy))
+			for _, expr := range listQuery.SelectCommand.OrderBy {
+				orderBy = append(orderBy, model.AsString(expr))
+			}
+			assert.Equal(t, tt.wantOrderBy, strings.Join(orderBy, ", "))
+		})
+	}
+}
+
+func TestQueryParserPrefix(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"user_id": {Name: "user_id", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"user_id": {PropertyName: "user_id", InternalPropertyName: "user_id", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"string", `{"query": {"prefix": {"user_id": "ki"}}}`, `"user_id" iLIKE 'ki%'`},
+		{"case_insensitive", `{"query": {"prefix": {"user_id": {"value": "Ki", "case_insensitive": true}}}}`, `"user_id" iLIKE 'Ki%'`},
+		{"rewrite and boost", `{"query": {"prefix": {"user_id": {"value": "ki", "rewrite": "constant_score", "boost": 2.0}}}}`,
+			`"user_id" iLIKE 'ki%'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	for _, query := range []string{
+		`{"query": {"prefix": {"user_id": {"case_insensitive": true}}}}`,
+		`{"query": {"prefix": {"user_id": {"value": 5}}}}`,
+	} {
+		body, parseErr := types.ParseJSON(query)
+		assert.NoError(t, parseErr)
+		_, canParse, _ := cw.ParseQuery(body)
+		assert.False(t, canParse, query)
+	}
+}
+
+func TestQueryParserPatternOnId(t *testing.T) {
+	timestampField := "@timestamp"
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+		},
+		TimestampColumn: &timestampField,
+		Created:         true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	// _id of a document with @timestamp 2024-01-01 10:00:00
+	const id = "323032342d30312d30312031303a30303a3030202b3030303020555443q1"
+	body, parseErr := types.ParseJSON(`{"query": {"wildcard": {"_id": {"value": "` + id + `"}}}}`)
+	assert.NoError(t, parseErr)
+	queries, canParse, err := cw.ParseQuery(body)
+	assert.NoError(t, err)
+	assert.True(t, canParse)
+	if assert.True(t, len(queries) > 0) {
+		assert.Equal(t, `"@timestamp" = toDateTime64('2024-01-01 10:00:00',3)`, model.AsString(queries[0].SelectCommand.WhereClause))
+	}
+
+	// parts of _id can't be matched
+	for _, query := range []string{
+		`{"query": {"wildcard": {"_id": {"value": "abc*"}}}}`,
+		`{"query": {"wildcard": {"_id": {"value": "a?c"}}}}`,
+		`{"query": {"prefix": {"_id": "abc"}}}`,
+		`{"query": {"prefix": {"_id": {"value": "abc"}}}}`,
+	} {
+		body, parseErr := types.ParseJSON(query)
+		assert.NoError(t, parseErr)
+		_, canParse, _ := cw.ParseQuery(body)
+		assert.False(t, canParse, query)
+	}
+}
+
+func TestQueryParserConstantScore(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"host":   {Name: "host", Type: clickhouse.NewBaseType("String")},
+			"status": {Name: "status", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"host":   {PropertyName: "host", InternalPropertyName: "host", Type: schema.TypeKeyword},
+					"status": {PropertyName: "status", InternalPropertyName: "status", Type: schema.TypeLong},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"filter", `{"query": {"constant_score": {"filter": {"term": {"host": "web-01"}}}}}`, `"host"='web-01'`},
+		{"filter and boost", `{"query": {"constant_score": {"filter": {"term": {"host": "web-01"}}, "boost": 1.2}}}`, `"host"='web-01'`},
+		{"filter list", `{"query": {"constant_score": {"filter": [{"term": {"host": "web-01"}}, {"range": {"status": {"gte": 500}}}]}}}`,
+			`("host"='web-01' AND "status">=500)`},
+		{"query", `{"query": {"constant_score": {"query": {"term": {"host": "web-01"}}, "boost": 2}}}`, `"host"='web-01'`},
+		{"wrapped directly, with boost", `{"query": {"constant_score": {"range": {"status": {"gte": 500}}, "boost": 2, "_name": "errors"}}}`,
+			`"status">=500`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	for _, query := range []string{
+		`{"query": {"constant_score": {"boost": 2}}}`,
+		`{"query": {"constant_score": {"term": {"host": "web-01"}, "range": {"status": {"gte": 500}}}}}`,
+	} {
+		body, parseErr := types.ParseJSON(query)
+		assert.NoError(t, parseErr)
+		_, canParse, _ := cw.ParseQuery(body)
+		assert.False(t, canParse, query)
+	}
+}
+
+func TestQueryParserRegexp(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"host": {Name: "host", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"host": {PropertyName: "host", InternalPropertyName: "host", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"simple", `{"query": {"regexp": {"host": {"value": "web.*"}}}}`, `"host" LIKE 'web%'`},
+		{"simple, with %", `{"query": {"regexp": {"host": {"value": "100%.*"}}}}`, `"host" LIKE '100\%%'`},
+		{"simple, case_insensitive", `{"query": {"regexp": {"host": {"value": "web.*", "case_insensitive": true}}}}`, `"host" iLIKE 'web%'`},
+		{"complex", `{"query": {"regexp": {"host": {"value": "web-[0-9]+"}}}}`, `"host" REGEXP '^(?:web-[0-9]+)$'`},
+		{"complex, CASE_INSENSITIVE flag", `{"query": {"regexp": {"host": {"value": "web-[0-9]+", "flags": "ALL|CASE_INSENSITIVE"}}}}`,
+			`"host" REGEXP '(?i)^(?:web-[0-9]+)$'`},
+		{"complex, ignored params", `{"query": {"regexp": {"host": {"value": "a|b", "max_determinized_states": 10000, "rewrite": "constant_score"}}}}`,
+			`"host" REGEXP '^(?:a|b)$'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	// ClickHouse's match uses RE2, like Go. Unanchored pattern would match any value containing it, unlike Elastic.
+	unanchored, anchored := regexp.MustCompile(`web-[0-9]+`), regexp.MustCompile(`^(?:web-[0-9]+)$`)
+	for _, host := range []string{"old-web-1", "web-1.local"} {
+		assert.True(t, unanchored.MatchString(host), host)
+		assert.False(t, anchored.MatchString(host), host)
+	}
+	assert.True(t, anchored.MatchString("web-12"))
+	// alternatives are anchored as a whole
+	assert.False(t, regexp.MustCompile(`^(?:a|b)$`).MatchString("ab"))
+	assert.True(t, regexp.MustCompile(`^a|b$`).MatchString("ab"))
+}
+
+func TestQueryParserTrackTotalHits(t *testing.T) {
+	table := clickhouse.Table{Name: "logs", Config: clickhouse.NewDefaultCHConfig(), Cols: map[string]*clickhouse.Column{}, Created: true}
+	cw := ClickhouseQueryTranslator{Table: &table, Ctx: context.Background(), SchemaRegistry: staticRegistry{}}
+
+	tests := []struct {
+		trackTotalHits string
+		want           int
+	}{
+		{`true`, model.TrackTotalHitsTrue},
+		{`false`, model.TrackTotalHitsFalse},
+		{`100`, 100},
+		{`"true"`, model.TrackTotalHitsTrue},
+		{`"false"`, model.TrackTotalHitsFalse},
+		{`"100"`, 100},
+		{`"1"`, 1},
+		{`"0"`, 0},
+		{`"TRUE"`, 10000}, // default
+		{`"many"`, 10000}, // default
+	}
+	for _, tt := range tests {
+		t.Run(tt.trackTotalHits, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(`{"track_total_hits": ` + tt.trackTotalHits + `}`)
+			assert.NoError(t, parseErr)
+			_, queryInfo, _, err := cw.parseQueryInternal(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, queryInfo.TrackTotalHits)
+		})
+	}
+}
+
+func TestQueryParserRangeFormats(t *testing.T) {
+	table := clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+		},
+		Created: true,
+	}
+	lm := clickhouse.NewEmptyLogManager(config.QuesmaConfiguration{}, nil, telemetry.NewPhoneHomeEmptyAgent(), clickhouse.NewTableDiscovery(config.QuesmaConfiguration{}, nil))
+	lm.AddTableIfDoesntExist(&table)
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs": {
+				Fields: map[schema.FieldName]schema.Field{
+					"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+				},
+			},
+		},
+	}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: lm, Table: &table, Ctx: context.Background(), SchemaRegistry: s}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+	}{
+		{"epoch_second",
+			`{"query": {"range": {"@timestamp": {"format": "epoch_second", "gte": 1710171234, "lt": "1710172134"}}}}`,
+			`("@timestamp">=toDateTime(1710171234) AND "@timestamp"<toDateTime(1710172134))`},
+		{"epoch_millis",
+			`{"query": {"range": {"@timestamp": {"format": "epoch_millis", "gte": 1710171234276}}}}`,
+			`toUnixTimestamp64Milli("@timestamp")>=1.710171234276e+12`},
+		{"yyyy-MM-dd",
+			`{"query": {"range": {"@timestamp": {"format": "yyyy-MM-dd", "gte": "2024-05-16", "lte": "2024-05-17"}}}}`,
+			`("@timestamp">=parseDateTime64BestEffort('2024-05-16') AND "@timestamp"<=parseDateTime64BestEffort('2024-05-17'))`},
+		{"yyyy/MM/dd, not ISO 8601",
+			`{"query": {"range": {"@timestamp": {"format": "yyyy/MM/dd", "gte": "2024/05/16"}}}}`,
+			`"@timestamp">=parseDateTime64BestEffort('2024/05/16')`},
+		{"date pattern or epoch_millis, with a date",
+			`{"query": {"range": {"@timestamp": {"format": "yyyy/MM/dd||epoch_millis", "gte": "2024/05/16"}}}}`,
+			`"@timestamp">=parseDateTime64BestEffort('2024/05/16')`},
+		{"single bound",
+			`{"query": {"range": {"@timestamp": {"format": "strict_date_optional_time", "gte": "2024-05-16T00:00:00Z"}}}}`,
+			`"@timestamp">=parseDateTime64BestEffort('2024-05-16T00:00:00Z')`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, parseErr := types.ParseJSON(tt.query)
+			assert.NoError(t, parseErr)
+			queries, canParse, errQuery := cw.ParseQuery(body)
+			assert.NoError(t, errQuery)
+			assert.True(t, canParse)
+			assert.True(t, len(queries) > 0)
+			assert.Equal(t, tt.wantWhere, model.AsString(queries[0].SelectCommand.WhereClause))
+		})
+	}
+
+	// only format, without any bound, is malformed
+	body, parseErr := types.ParseJSON(`{"query": {"range": {"@timestamp": {"format": "strict_date_optional_time"}}}}`)
+	assert.NoError(t, parseErr)
+	_, canParse, _ := cw.ParseQuery(body)
+	assert.False(t, canParse)
+}
+
+func TestDecimalLiteral(t *testing.T) {
+	tests := []struct {
+		literal     string
+		wantLiteral string
+		wantScale   int
+	}{
+		{"10", "10", 0},
+		{"10.5", "10.5", 1},
+		{"0.125", "0.125", 3},
+		{"-3.14159", "-3.14159", 5},
+		{"1e-3", "0.001", 3},
+		{"2.5E2", "250", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.literal, func(t *testing.T) {
+			literal, scale := decimalLiteral(tt.literal)
+			assert.Equal(t, tt.wantLiteral, literal)
+			assert.Equal(t, tt.wantScale, scale)
+		})
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"quesma/queryprocessor"
 	"quesma/schema"
 	"quesma/util"
+	"slices"
+	"strconv"
 )
 
 const facetsSampleSize = 20000
@@ -28,6 +30,10 @@ type ClickhouseQueryTranslator struct {
 
 	DateMathRenderer string // "clickhouse_interval" or "literal"  if not set, we use "clickhouse_interval"
 	SchemaRegistry   schema.Registry
+
+	// `_source_includes`/`_source_excludes` URL params of the search, defaults for `_source` of its body
+	SourceIncludesURLParam []string
+	SourceExcludesURLParam []string
 }
 
 var completionStatusOK = func() *int { value := 200; return &value }()
@@ -160,7 +166,9 @@ func (cw *ClickhouseQueryTranslator) makeResponseAggregationRecursive(query *mod
 	// Then in the tree (in each node) I'd remember where I am at the moment (e.g. here I'm in "sampler",
 	// so I don't need buckets). It'd enable some custom handling for another weird types of requests.
 
-	if query.Aggregators[aggregatorsLevel].Filters {
+	if query.Aggregators[aggregatorsLevel].Filters && !query.Aggregators[aggregatorsLevel].Keyed {
+		subResult["buckets"] = cw.makeFiltersBucketsArray(query.Aggregators[aggregatorsLevel], bucketsReturnMap[0])
+	} else if query.Aggregators[aggregatorsLevel].Filters {
 		subResult["buckets"] = bucketsReturnMap[0]
 	} else if query.Aggregators[aggregatorsLevel].Keyed {
 		subResult["buckets"] = bucketsReturnMap[0]
@@ -169,6 +177,11 @@ func (cw *ClickhouseQueryTranslator) makeResponseAggregationRecursive(query *mod
 	} else {
 		subResult["buckets"] = bucketsReturnMap
 	}
+	if terms, ok := query.Type.(bucket_aggregations.Terms); ok && !terms.IsSignificant() && aggregatorsLevel == len(query.Aggregators)-1 {
+		// ClickHouse counts exactly, there are no shards
+		subResult["doc_count_error_upper_bound"] = 0
+		subResult["sum_other_doc_count"] = terms.SumOtherDocCount(ResultSet)
+	}
 
 	_ = cw.addMetadataIfNeeded(query, subResult, aggregatorsLevel)
 
@@ -176,6 +189,29 @@ func (cw *ClickhouseQueryTranslator) makeResponseAggregationRecursive(query *mod
 	return []model.JsonMap{result}
 }
 
+// makeFiltersBucketsArray returns buckets of a filters aggregation with `keyed: false`.
+// Every query of the aggregation fills only its own filter's bucket, others are left empty, so that merging
+// responses of all the queries (arrays are merged element by element) produces the whole array.
+func (cw *ClickhouseQueryTranslator) makeFiltersBucketsArray(aggregator model.Aggregator, bucketsByFilterName model.JsonMap) []model.JsonMap {
+	buckets := make([]model.JsonMap, len(aggregator.FilterNames))
+	for i := range buckets {
+		buckets[i] = model.JsonMap{}
+	}
+	for filterName, bucketRaw := range bucketsByFilterName {
+		i := slices.Index(aggregator.FilterNames, filterName)
+		bucket, ok := bucketRaw.(model.JsonMap)
+		if i == -1 || !ok {
+			logger.ErrorWithCtx(cw.Ctx).Msgf("unexpected bucket %s: %v in filters aggregation %s", filterName, bucketRaw, aggregator.Name)
+			continue
+		}
+		if !aggregator.AnonymousFilters {
+			bucket["key"] = filterName
+		}
+		buckets[i] = bucket
+	}
+	return buckets
+}
+
 // addMetadataIfNeeded adds metadata to the `result` dictionary, if needed.
 func (cw *ClickhouseQueryTranslator) addMetadataIfNeeded(query *model.Query, result model.JsonMap, aggregatorsLevel int) (added bool) {
 	if query.Metadata == nil {
@@ -198,6 +234,7 @@ func (cw *ClickhouseQueryTranslator) MakeAggregationPartOfResponse(queries []*mo
 	if len(queries) == 0 {
 		return aggregations
 	}
+	ResultSets = scaleSampledResults(queries, ResultSets)
 	cw.postprocessPipelineAggregations(queries, ResultSets)
 	for i, query := range queries {
 		if i >= len(ResultSets) || query_util.IsNonAggregationQuery(query) {
@@ -247,19 +284,19 @@ func (cw *ClickhouseQueryTranslator) makeTotalCount(queries []*model.Query, resu
 	// a) we have count query -> we're done
 	// b) we have hits or facets -> we're done
 	// c) we don't have above: we return len(biggest resultset(all aggregations))
+	// If the count query failed, b) is just a lower bound (e.g. a page of hits), so its relation is "gte".
 	totalCount := -1
 	relationCount := "eq"
+	countFailed := false
 	for i, query := range queries {
 		if query.Type != nil {
 			if _, isCount := query.Type.(typical_queries.Count); isCount {
 				if len(results[i]) > 0 && len(results[i][0].Cols) > 0 {
-					switch v := results[i][0].Cols[0].Value.(type) {
-					case uint64:
-						totalCount = int(v)
-					case int64:
-						totalCount = int(v)
-					default:
-						logger.ErrorWithCtx(cw.Ctx).Msgf("failed extracting Count value SQL query result [%v]. Setting to 0", results[i])
+					if count, ok := util.ExtractInt64Maybe(results[i][0].Cols[0].Value); ok {
+						totalCount = int(count)
+					} else {
+						logger.ErrorWithCtx(cw.Ctx).Msgf("failed extracting Count value SQL query result [%v]", results[i])
+						countFailed = true
 					}
 					// if we have sample limit, we need to check if we hit it. If so, return there could be more results
 					if query.SelectCommand.SampleLimit != 0 && totalCount == query.SelectCommand.SampleLimit {
@@ -267,6 +304,7 @@ func (cw *ClickhouseQueryTranslator) makeTotalCount(queries []*model.Query, resu
 					}
 				} else {
 					logger.ErrorWithCtx(cw.Ctx).Msgf("no results for Count value SQL query result [%v]", results[i])
+					countFailed = true
 				}
 				continue
 			}
@@ -317,15 +355,10 @@ func (cw *ClickhouseQueryTranslator) makeTotalCount(queries []*model.Query, resu
 	}
 
 	for i, query := range queries {
-		if _, hasHits := query.Type.(*typical_queries.Hits); hasHits {
-			totalCount = len(results[i])
-			relation := "eq"
-			if query.SelectCommand.Limit != 0 && totalCount == query.SelectCommand.Limit {
-				relation = "gte"
-			}
-			total = &model.Total{
-				Value:    totalCount,
-				Relation: relation,
+		if hitsType, hasHits := query.Type.(*typical_queries.Hits); hasHits {
+			total = hitsType.MakeTotal(len(results[i]), query.SelectCommand.Limit)
+			if countFailed {
+				total.Relation = "gte"
 			}
 			return
 		}
@@ -427,6 +460,66 @@ func (cw *ClickhouseQueryTranslator) BuildNRowsQuery(fieldName string, query *mo
 	return query_util.BuildHitsQuery(cw.Ctx, cw.Table.FullTableName(), fieldName, query, limit)
 }
 
+// collapseHits makes hits `query` return only the top hit (by its ORDER BY) per distinct value of `field`,
+// like Elastic's `collapse`. It becomes:
+// SELECT columns FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY field ORDER BY ...) AS "row_number" FROM table WHERE ...)
+// WHERE "row_number"=1 ORDER BY ... LIMIT ...
+// With `innerHits`, it also returns top innerHits.Size hits (by inner hits' ORDER BY) of every returned group,
+// with their position in the group, the group size and its collapse key:
+// SELECT columns, "row_number", "inner_hits_row_number", "inner_hits_total", field AS "inner_hits_collapse_key"
+// FROM (SELECT *, ROW_NUMBER() OVER (...) AS "row_number", ROW_NUMBER() OVER (PARTITION BY field ORDER BY inner ...)
+// AS "inner_hits_row_number", count() OVER (PARTITION BY field) AS "inner_hits_total" FROM table WHERE ... AND field IN (collapsed query))
+// WHERE ("row_number"=1 OR "inner_hits_row_number"<=size) ORDER BY ... LIMIT limit*(size+1)
+func collapseHits(query *model.Query, field string, innerHits *model.CollapseInnerHits) {
+	partitionBy := []model.Expr{model.NewColumnRef(field)}
+	rowNumber := model.NewAliasedExpr(model.NewWindowFunction("ROW_NUMBER", nil, partitionBy, windowOrderBy(query.SelectCommand.OrderBy)),
+		model.RowNumberColumnName)
+	innerQuery := model.NewSelectCommand([]model.Expr{model.NewWildcardExpr, rowNumber}, nil, nil,
+		query.SelectCommand.FromClause, query.SelectCommand.WhereClause, 0, 0, false)
+
+	query.SelectCommand.FromClause = *innerQuery
+	query.SelectCommand.WhereClause = model.NewInfixExpr(model.NewColumnRef(model.RowNumberColumnName), "=", model.NewLiteral("1"))
+	if innerHits == nil {
+		return
+	}
+
+	groupsQuery := query.SelectCommand
+	groupsQuery.Columns = []model.Expr{model.NewColumnRef(field)}
+
+	innerRowNumber := model.NewAliasedExpr(model.NewWindowFunction("ROW_NUMBER", nil, partitionBy, windowOrderBy(innerHits.OrderBy)),
+		model.InnerHitsRowNumberColumnName)
+	groupTotal := model.NewAliasedExpr(model.NewWindowFunction("count", nil, partitionBy, model.OrderByExpr{}), model.InnerHitsTotalColumnName)
+	innerQuery.Columns = append(innerQuery.Columns, innerRowNumber, groupTotal)
+	innerQuery.WhereClause = model.And([]model.Expr{innerQuery.WhereClause,
+		model.NewInfixExpr(model.NewColumnRef(field), "IN", model.NewParenExpr(groupsQuery))})
+
+	query.SelectCommand.FromClause = *innerQuery
+	query.SelectCommand.WhereClause = model.Or([]model.Expr{query.SelectCommand.WhereClause,
+		model.NewInfixExpr(model.NewColumnRef(model.InnerHitsRowNumberColumnName), "<=", model.NewLiteral(strconv.Itoa(innerHits.Size)))})
+	// hits are built from these, regardless of the columns requested (e.g. with stored_fields or _source filtering)
+	query.SelectCommand.Columns = append(query.SelectCommand.Columns, model.NewColumnRef(model.RowNumberColumnName),
+		model.NewColumnRef(model.InnerHitsRowNumberColumnName), model.NewColumnRef(model.InnerHitsTotalColumnName),
+		model.NewAliasedExpr(model.NewColumnRef(field), model.InnerHitsCollapseKeyColumnName))
+	if query.SelectCommand.Limit > 0 {
+		query.SelectCommand.Limit *= innerHits.Size + 1 // every group's top hit and its inner hits
+	}
+}
+
+// windowOrderBy returns `orderBy` as a single expression, as a window function has one ORDER BY, e.g. "a" DESC, "b" ASC
+func windowOrderBy(orderBy []model.OrderByExpr) model.OrderByExpr {
+	switch len(orderBy) {
+	case 0:
+		return model.OrderByExpr{}
+	case 1:
+		return orderBy[0]
+	}
+	exprs := make([]model.Expr, 0, len(orderBy))
+	for _, expr := range orderBy {
+		exprs = append(exprs, expr)
+	}
+	return model.NewOrderByExpr(exprs, model.DefaultOrder)
+}
+
 func (cw *ClickhouseQueryTranslator) BuildAutocompleteQuery(fieldName string, whereClause model.Expr, limit int) *model.Query {
 	return &model.Query{
 		SelectCommand: *model.NewSelectCommand(
@@ -493,7 +586,12 @@ func (cw *ClickhouseQueryTranslator) BuildFacetsQuery(fieldName string, simpleQu
 func (cw *ClickhouseQueryTranslator) createHistogramPartOfQuery(queryMap QueryMap) model.Expr {
 	const defaultDateTimeType = clickhouse.DateTime64
 	field := cw.parseFieldField(queryMap, "histogram")
-	interval, err := kibana.ParseInterval(cw.extractInterval(queryMap))
+	intervalRaw, isCalendarInterval := cw.extractInterval(queryMap)
+	if calendarUnit := bucket_aggregations.ParseCalendarUnit(intervalRaw); isCalendarInterval && calendarUnit != bucket_aggregations.NoCalendarUnit {
+		// buckets of varying length, so we can't divide by the interval. Key is the bucket's start, in seconds.
+		return model.NewFunction("toInt64", model.NewFunction("toUnixTimestamp", calendarUnit.StartOf(field)))
+	}
+	interval, err := kibana.ParseInterval(intervalRaw)
 	if err != nil {
 		logger.ErrorWithCtx(cw.Ctx).Msg(err.Error())
 	}
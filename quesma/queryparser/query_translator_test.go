@@ -14,11 +14,13 @@ import (
 	"quesma/model/typical_queries"
 	"quesma/queryparser/query_util"
 	"quesma/quesma/config"
+	"quesma/quesma/types"
 	"quesma/schema"
 	"quesma/util"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 )
 
 const (
@@ -188,7 +190,7 @@ func TestMakeResponseSearchQuery(t *testing.T) {
 				&model.SimpleQuery{FieldName: "*"}, model.WeNeedUnlimitedCount,
 			)
 			highlighter := NewEmptyHighlighter()
-			queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, hitQuery.SelectCommand.OrderByFieldNames(), true, false, false)
+			queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, hitQuery.SelectCommand.OrderByFieldNames(), true, true, false, false)
 			hitQuery.Type = &queryType
 			ourResponseRaw := cw.MakeSearchResponse(
 				[]*model.Query{hitQuery},
@@ -206,6 +208,158 @@ func TestMakeResponseSearchQuery(t *testing.T) {
 	}
 }
 
+func TestMakeResponseSearchQueryArrayColumn(t *testing.T) {
+	table := &clickhouse.Table{Name: "test", Cols: map[string]*clickhouse.Column{
+		"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+		"tags":    {Name: "tags", Type: clickhouse.CompoundType{Name: "Array", BaseType: clickhouse.NewBaseType("String")}},
+	}}
+	cw := ClickhouseQueryTranslator{Table: table, Ctx: context.Background()}
+	rows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("message", "first"), model.NewQueryResultCol("tags", []string{"a", "b", "c"})}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("message", "second"), model.NewQueryResultCol("tags", []string{})}},
+	}
+
+	hitQuery := query_util.BuildHitsQuery(context.Background(), "test", "*", &model.SimpleQuery{FieldName: "*"}, model.WeNeedUnlimitedCount)
+	highlighter := NewEmptyHighlighter()
+	queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, hitQuery.SelectCommand.OrderByFieldNames(), true, true, false, false)
+	hitQuery.Type = &queryType
+	response := cw.MakeSearchResponse([]*model.Query{hitQuery}, [][]model.QueryResultRow{rows})
+
+	if assert.Len(t, response.Hits.Hits, 2) {
+		assert.Equal(t, []interface{}{"a", "b", "c"}, response.Hits.Hits[0].Fields["tags"])
+		assert.Equal(t, []interface{}{"first"}, response.Hits.Hits[0].Fields["message"])
+		assert.NotContains(t, response.Hits.Hits[1].Fields, "tags")
+	}
+}
+
+func TestMakeResponseSearchQueryIgnoredFields(t *testing.T) {
+	table := &clickhouse.Table{Name: "test", IgnoreAbove: map[string]int{"host": 8}, Cols: map[string]*clickhouse.Column{
+		"host": {Name: "host", Type: clickhouse.NewBaseType("String")},
+	}}
+	cw := ClickhouseQueryTranslator{Table: table, Ctx: context.Background()}
+	rows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("host", "web-01")}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("host", "web-01.example.com")}},
+	}
+
+	hitQuery := query_util.BuildHitsQuery(context.Background(), "test", "*", &model.SimpleQuery{FieldName: "*"}, model.WeNeedUnlimitedCount)
+	highlighter := NewEmptyHighlighter()
+	queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, hitQuery.SelectCommand.OrderByFieldNames(), true, true, false, false)
+	hitQuery.Type = &queryType
+	response := cw.MakeSearchResponse([]*model.Query{hitQuery}, [][]model.QueryResultRow{rows})
+
+	if assert.Len(t, response.Hits.Hits, 2) {
+		assert.Empty(t, response.Hits.Hits[0].Ignored)
+		assert.Equal(t, []string{"host"}, response.Hits.Hits[1].Ignored)
+	}
+}
+
+func TestMakeResponseSearchQueryVersion(t *testing.T) {
+	// the version field is stored in a column named differently, so hits' _version is taken from the resolved column
+	table := &clickhouse.Table{Name: "test", VersionField: "meta.updated_at", Config: clickhouse.NewDefaultCHConfig(), Created: true,
+		Cols: map[string]*clickhouse.Column{
+			"message":          {Name: "message", Type: clickhouse.NewBaseType("String")},
+			"meta::updated_at": {Name: "meta::updated_at", Type: clickhouse.NewBaseType("DateTime64")},
+		}}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{"test": {Fields: map[schema.FieldName]schema.Field{
+		"message":         {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+		"meta.updated_at": {PropertyName: "meta.updated_at", InternalPropertyName: "meta::updated_at", Type: schema.TypeTimestamp},
+	}}}}
+	cw := ClickhouseQueryTranslator{ClickhouseLM: clickhouse.NewLogManager(concurrent.NewMapWith("test", table), config.QuesmaConfiguration{}),
+		Table: table, Ctx: context.Background(), SchemaRegistry: s}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	updated := created.Add(90 * time.Second)
+	rows := []model.QueryResultRow{
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("message", "created"), model.NewQueryResultCol("meta::updated_at", created)}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("message", "updated"), model.NewQueryResultCol("meta::updated_at", &updated)}},
+		{Cols: []model.QueryResultCol{model.NewQueryResultCol("message", "never modified"), model.NewQueryResultCol("meta::updated_at", (*time.Time)(nil))}},
+	}
+
+	queries, _, err := cw.ParseQuery(types.MustJSON(`{"version": true, "track_total_hits": false}`))
+	assert.NoError(t, err)
+	var hitsQuery *model.Query
+	for _, query := range queries {
+		if _, isHits := query.Type.(*typical_queries.Hits); isHits {
+			hitsQuery = query
+		}
+	}
+	if !assert.NotNil(t, hitsQuery) {
+		return
+	}
+	response := cw.MakeSearchResponse([]*model.Query{hitsQuery}, [][]model.QueryResultRow{rows})
+	if assert.Len(t, response.Hits.Hits, 3) {
+		assert.Equal(t, int(created.UnixMilli()), response.Hits.Hits[0].Version)
+		assert.Equal(t, int(updated.UnixMilli()), response.Hits.Hits[1].Version)
+		assert.Greater(t, response.Hits.Hits[1].Version, response.Hits.Hits[0].Version)
+		assert.Equal(t, 1, response.Hits.Hits[2].Version)
+	}
+
+	// without a version field, _version is always 1
+	hitQuery := query_util.BuildHitsQuery(context.Background(), "test", "*", &model.SimpleQuery{FieldName: "*"}, model.WeNeedUnlimitedCount)
+	highlighter := NewEmptyHighlighter()
+	queryType := typical_queries.NewHits(cw.Ctx, &clickhouse.Table{Name: "test", Cols: table.Cols}, &highlighter, hitQuery.SelectCommand.OrderByFieldNames(), true, true, false, true)
+	hitQuery.Type = &queryType
+	response = cw.MakeSearchResponse([]*model.Query{hitQuery}, [][]model.QueryResultRow{rows})
+	if assert.Len(t, response.Hits.Hits, 3) {
+		assert.Equal(t, 1, response.Hits.Hits[1].Version)
+	}
+}
+
+func TestMakeResponseSearchQueryCollapseInnerHits(t *testing.T) {
+	table := &clickhouse.Table{Name: "test", Cols: map[string]*clickhouse.Column{
+		"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+		"user":    {Name: "user", Type: clickhouse.NewBaseType("String")},
+	}}
+	cw := ClickhouseQueryTranslator{Table: table, Ctx: context.Background()}
+	// the collapse field isn't among requested columns, it comes as the collapse key
+	row := func(message, user string, rowNumber, innerRowNumber, total uint64) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{
+			model.NewQueryResultCol("message", message),
+			model.NewQueryResultCol(model.RowNumberColumnName, rowNumber),
+			model.NewQueryResultCol(model.InnerHitsRowNumberColumnName, innerRowNumber),
+			model.NewQueryResultCol(model.InnerHitsTotalColumnName, total),
+			model.NewQueryResultCol(model.InnerHitsCollapseKeyColumnName, user),
+		}}
+	}
+	// ordered by the collapse sort, inner hits sort is the opposite one
+	rows := []model.QueryResultRow{
+		row("a3", "alice", 1, 3, 3),
+		row("b2", "bob", 1, 2, 2),
+		row("a2", "alice", 2, 2, 3),
+		row("b1", "bob", 2, 1, 2),
+		row("a1", "alice", 3, 1, 3),
+	}
+
+	hitQuery := query_util.BuildHitsQuery(context.Background(), "test", "*", &model.SimpleQuery{FieldName: "*"}, model.WeNeedUnlimitedCount)
+	highlighter := NewEmptyHighlighter()
+	queryType := typical_queries.NewHits(cw.Ctx, cw.Table, &highlighter, hitQuery.SelectCommand.OrderByFieldNames(), true, true, false, false)
+	queryType.SetInnerHits("user", "oldest", 2)
+	hitQuery.Type = &queryType
+	response := cw.MakeSearchResponse([]*model.Query{hitQuery}, [][]model.QueryResultRow{rows})
+
+	messages := func(hits []model.SearchHit) (result []any) {
+		for _, hit := range hits {
+			result = append(result, hit.Fields["message"][0])
+		}
+		return result
+	}
+	if assert.Len(t, response.Hits.Hits, 2) {
+		assert.Equal(t, []any{"a3", "b2"}, messages(response.Hits.Hits))
+		assert.NotContains(t, response.Hits.Hits[0].Fields, model.RowNumberColumnName)
+		assert.NotContains(t, response.Hits.Hits[0].Fields, model.InnerHitsRowNumberColumnName)
+		assert.NotContains(t, response.Hits.Hits[0].Fields, model.InnerHitsCollapseKeyColumnName)
+		assert.Equal(t, []any{"alice"}, response.Hits.Hits[0].Fields["user"])
+		assert.Equal(t, []any{"bob"}, response.Hits.Hits[1].Fields["user"])
+
+		aliceInnerHits := response.Hits.Hits[0].InnerHits["oldest"].Hits
+		assert.Equal(t, []any{"a1", "a2"}, messages(aliceInnerHits.Hits))
+		assert.Equal(t, 3, aliceInnerHits.Total.Value)
+		bobInnerHits := response.Hits.Hits[1].InnerHits["oldest"].Hits
+		assert.Equal(t, []any{"b1", "b2"}, messages(bobInnerHits.Hits))
+		assert.Equal(t, 2, bobInnerHits.Total.Value)
+	}
+}
+
 func TestMakeResponseAsyncSearchQuery(t *testing.T) {
 	cw := ClickhouseQueryTranslator{Table: &clickhouse.Table{Name: "test"}, Ctx: context.Background()}
 	var args = []struct {
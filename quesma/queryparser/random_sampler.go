@@ -0,0 +1,128 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package queryparser
+
+import (
+	"math"
+	"quesma/model"
+	"quesma/model/bucket_aggregations"
+	"quesma/model/metrics_aggregations"
+	"strconv"
+)
+
+// sampleDocuments restricts `currentAggr` to documents sampled with `probability` (of all documents, so including
+// random_samplers it's nested in). The sample is deterministic, so all queries of the request (e.g. of sibling
+// aggregations) are computed over the same documents: if the table has a SAMPLE BY key, ClickHouse reads only
+// the sample (SAMPLE probability), otherwise we keep documents with a small enough hash of all their columns.
+// Either way, the same documents are sampled for any `seed`.
+func (cw *ClickhouseQueryTranslator) sampleDocuments(currentAggr *aggrQueryBuilder, probability float64) {
+	if from, isTable := currentAggr.SelectCommand.FromClause.(model.TableRef); isTable && cw.Table.HasSamplingKey() {
+		from.Sample = probability
+		currentAggr.SelectCommand.FromClause = from
+		return
+	}
+	// hashes are uniform in [0, 2^64), and probability < 1, so the bound fits in uint64
+	hashBound := uint64(math.Ldexp(probability, 64))
+	sample := model.NewInfixExpr(model.NewFunction("cityHash64", model.NewWildcardExpr), "<", model.NewLiteral(strconv.FormatUint(hashBound, 10)))
+	currentAggr.whereBuilder = model.CombineWheres(cw.Ctx, currentAggr.whereBuilder, model.NewSimpleQuery(sample, true))
+}
+
+// scaleSampledResults scales results of aggregations inside random_sampler by 1/probability, so they estimate values over
+// all documents, not only the sampled ones. Only doc counts and sum-like metrics are scaled, as e.g. avg, min, max or
+// percentiles of a sample already estimate the ones of all documents.
+// Returns new result sets, the original rows aren't modified (they may be e.g. in the query cache).
+func scaleSampledResults(queries []*model.Query, resultSets [][]model.QueryResultRow) [][]model.QueryResultRow {
+	scaled := make([][]model.QueryResultRow, len(resultSets))
+	copy(scaled, resultSets)
+	for i, query := range queries {
+		if i >= len(resultSets) || query.SampleProbability <= 0 || query.SampleProbability >= 1 {
+			continue
+		}
+		scale := 1 / query.SampleProbability
+		rows := make([]model.QueryResultRow, len(resultSets[i]))
+		for j, row := range resultSets[i] {
+			rows[j] = model.QueryResultRow{Index: row.Index, Cols: make([]model.QueryResultCol, len(row.Cols))}
+			copy(rows[j].Cols, row.Cols)
+			for _, colIdx := range sampledColumns(query.Type, row) {
+				if colIdx >= 0 && colIdx < len(row.Cols) {
+					rows[j].Cols[colIdx].Value = scaleValue(row.Cols[colIdx].Value, scale)
+				}
+			}
+		}
+		scaled[i] = rows
+	}
+	return scaled
+}
+
+// sampledColumns returns indexes of columns of `row` (of an aggregation of type `queryType`), which should be scaled
+func sampledColumns(queryType model.QueryType, row model.QueryResultRow) []int {
+	last := len(row.Cols) - 1
+	switch queryType := queryType.(type) {
+	case bucket_aggregations.Terms:
+		if queryType.IsSignificant() {
+			return nil // scores are computed from both counts, and so are already relative
+		}
+		if bucket_aggregations.HasTotalDocCount(row) {
+			return []int{last - 1, last}
+		}
+		return []int{last}
+	case bucket_aggregations.Range:
+		columns := make([]int, 0, len(queryType.Intervals)+1)
+		for i := last - len(queryType.Intervals); i <= last; i++ {
+			columns = append(columns, i)
+		}
+		return columns
+	case bucket_aggregations.DateRange:
+		// count, [from], [to] for each interval, then count()
+		columns := make([]int, 0, len(queryType.Intervals)+1)
+		columnIdx := last - queryType.SelectColumnsNr
+		for _, interval := range queryType.Intervals {
+			columns = append(columns, columnIdx)
+			columnIdx++
+			if interval.Begin != bucket_aggregations.UnboundedInterval {
+				columnIdx++
+			}
+			if interval.End != bucket_aggregations.UnboundedInterval {
+				columnIdx++
+			}
+		}
+		return append(columns, last)
+	case metrics_aggregations.Stats:
+		return []int{last - 4, last} // count, min, max, avg, sum
+	case metrics_aggregations.ExtendedStats:
+		return []int{last - 9, last - 5, last - 4} // count, min, max, avg, sum, sum_of_squares, variances and std deviations
+	case bucket_aggregations.DateHistogram, bucket_aggregations.Histogram, bucket_aggregations.MultiTerms,
+		bucket_aggregations.GeoTileGrid, bucket_aggregations.GeohashGrid, bucket_aggregations.RandomSampler,
+		bucket_aggregations.Filters, bucket_aggregations.RareTerms,
+		metrics_aggregations.Count, metrics_aggregations.Sum, metrics_aggregations.ValueCount:
+		return []int{last}
+	}
+	return nil
+}
+
+// scaleValue multiplies a count or sum from the DB by `scale`. Integers are rounded, so they stay integers.
+func scaleValue(value any, scale float64) any {
+	switch v := value.(type) {
+	case uint64:
+		return uint64(math.Round(float64(v) * scale))
+	case int64:
+		return int64(math.Round(float64(v) * scale))
+	case int:
+		return int(math.Round(float64(v) * scale))
+	case float64:
+		return v * scale
+	case *uint64:
+		if v != nil {
+			return uint64(math.Round(float64(*v) * scale))
+		}
+	case *int64:
+		if v != nil {
+			return int64(math.Round(float64(*v) * scale))
+		}
+	case *float64:
+		if v != nil {
+			return *v * scale
+		}
+	}
+	return value
+}
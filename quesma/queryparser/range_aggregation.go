@@ -55,7 +55,7 @@ func (cw *ClickhouseQueryTranslator) parseRangeAggregation(rangePart QueryMap) b
 }
 
 func (cw *ClickhouseQueryTranslator) processRangeAggregation(currentAggr *aggrQueryBuilder, Range bucket_aggregations.Range,
-	queryCurrentLevel QueryMap, aggregationsAccumulator *[]*model.Query, metadata JsonMap) {
+	queryCurrentLevel QueryMap, aggregationsAccumulator *[]*model.Query, metadata JsonMap) error {
 
 	// build this aggregation
 	for _, interval := range Range.Intervals {
@@ -77,7 +77,7 @@ func (cw *ClickhouseQueryTranslator) processRangeAggregation(currentAggr *aggrQu
 	// build subaggregations
 	aggs, hasAggs := queryCurrentLevel["aggs"].(QueryMap)
 	if !hasAggs {
-		return
+		return nil
 	}
 	// TODO now we run a separate query for each range.
 	// it's much easier to code it this way, but that can, quite easily, be improved.
@@ -93,11 +93,14 @@ func (cw *ClickhouseQueryTranslator) processRangeAggregation(currentAggr *aggrQu
 		aggsCopy, err := deepcopy.Anything(aggs)
 		if err == nil {
 			currentAggr.Type = model.NewUnknownAggregationType(cw.Ctx)
-			cw.parseAggregationNames(currentAggr, aggsCopy.(QueryMap), aggregationsAccumulator)
+			if err = cw.parseAggregationNames(currentAggr, aggsCopy.(QueryMap), aggregationsAccumulator); err != nil {
+				return err
+			}
 		} else {
 			logger.ErrorWithCtx(cw.Ctx).Msgf("deepcopy 'aggs' map error: %v. Skipping current range's interval: %v, aggs: %v", err, interval, aggs)
 		}
 		currentAggr.Aggregators = currentAggr.Aggregators[:len(currentAggr.Aggregators)-1]
 		currentAggr.whereBuilder = whereBeforeNesting
 	}
+	return nil
 }
@@ -0,0 +1,244 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package queryparser
+
+import (
+	"fmt"
+	"quesma/logger"
+	"quesma/model"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// parseRuntimeMappings parses "runtime_mappings": fields computed at query time by Painless scripts.
+// Only a subset of Painless is supported: numeric arithmetic (+, -, *, /, %, parentheses) over numbers
+// and `doc['field'].value`, optionally wrapped in emit(...). Fields with other scripts are skipped.
+func (cw *ClickhouseQueryTranslator) parseRuntimeMappings(queryMap QueryMap) []model.RuntimeField {
+	runtimeMappings, ok := queryMap["runtime_mappings"].(QueryMap)
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(runtimeMappings))
+	for name := range runtimeMappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var runtimeFields []model.RuntimeField
+	for _, name := range names {
+		mapping, ok := runtimeMappings[name].(QueryMap)
+		if !ok {
+			logger.WarnWithCtx(cw.Ctx).Msgf("invalid runtime mapping of %s: %v. Skipping", name, runtimeMappings[name])
+			continue
+		}
+		var source string
+		switch script := mapping["script"].(type) {
+		case string:
+			source = script
+		case QueryMap:
+			source, _ = script["source"].(string)
+		}
+		expr, err := cw.parseRuntimeFieldScript(source)
+		if err != nil {
+			logger.WarnWithCtx(cw.Ctx).Msgf("unsupported script of runtime field %s: %s (%v). Skipping", name, source, err)
+			continue
+		}
+		runtimeFields = append(runtimeFields, model.RuntimeField{Name: name, Expr: expr})
+	}
+	return runtimeFields
+}
+
+// parseRuntimeFieldScript translates `source` of a runtime field script into an expression, e.g.
+// emit(doc['a'].value + doc['b'].value * 2) into ("a"+"b"*2)
+func (cw *ClickhouseQueryTranslator) parseRuntimeFieldScript(source string) (model.Expr, error) {
+	source = strings.TrimSuffix(strings.TrimSpace(source), ";")
+	if strings.HasPrefix(source, "emit(") && strings.HasSuffix(source, ")") {
+		source = source[len("emit(") : len(source)-1]
+	}
+	parser := &runtimeFieldScriptParser{cw: cw, source: source}
+	expr, err := parser.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if parser.skipSpaces(); parser.pos < len(parser.source) {
+		return nil, fmt.Errorf("unexpected '%s' at %d", parser.source[parser.pos:], parser.pos)
+	}
+	if _, isInfix := expr.(model.InfixExpr); isInfix {
+		expr = model.NewParenExpr(expr) // it's used inside other expressions, e.g. in WHERE
+	}
+	return expr, nil
+}
+
+// runtimeFieldScriptParser is a recursive descent parser of:
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/" | "%") unary }
+//	unary   = "-" unary | "(" sum ")" | number | "doc[" quoted field "].value"
+type runtimeFieldScriptParser struct {
+	cw     *ClickhouseQueryTranslator
+	source string
+	pos    int
+}
+
+func (p *runtimeFieldScriptParser) skipSpaces() {
+	for p.pos < len(p.source) && unicode.IsSpace(rune(p.source[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips `token` (after spaces), if it's next. Returns true <=> it was.
+func (p *runtimeFieldScriptParser) consume(token string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.source[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *runtimeFieldScriptParser) parseSum() (model.Expr, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseProduct)
+}
+
+func (p *runtimeFieldScriptParser) parseProduct() (model.Expr, error) {
+	return p.parseBinary([]string{"*", "/", "%"}, p.parseUnary)
+}
+
+func (p *runtimeFieldScriptParser) parseBinary(operators []string, parseOperand func() (model.Expr, error)) (model.Expr, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator := ""
+		for _, op := range operators {
+			if p.consume(op) {
+				operator = op
+				break
+			}
+		}
+		if operator == "" {
+			return left, nil
+		}
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = model.NewInfixExpr(left, operator, right)
+	}
+}
+
+func (p *runtimeFieldScriptParser) parseUnary() (model.Expr, error) {
+	switch {
+	case p.consume("-"):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return model.NewFunction("negate", operand), nil
+	case p.consume("("):
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing ')' at %d", p.pos)
+		}
+		return model.NewParenExpr(expr), nil
+	case p.consume("doc["):
+		return p.parseDocValue()
+	default:
+		return p.parseNumber()
+	}
+}
+
+// parseDocValue parses `'field'].value` (after "doc[") into the field's column
+func (p *runtimeFieldScriptParser) parseDocValue() (model.Expr, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.source) || (p.source[p.pos] != '\'' && p.source[p.pos] != '"') {
+		return nil, fmt.Errorf("expected quoted field name at %d", p.pos)
+	}
+	quote := p.source[p.pos]
+	end := strings.IndexByte(p.source[p.pos+1:], quote)
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated field name at %d", p.pos)
+	}
+	field := p.source[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	if !p.consume("]") || !p.consume(".value") {
+		return nil, fmt.Errorf("expected doc['%s'].value at %d", field, p.pos)
+	}
+	return model.NewColumnRef(p.cw.ResolveField(p.cw.Ctx, field)), nil
+}
+
+func (p *runtimeFieldScriptParser) parseNumber() (model.Expr, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.source) && (unicode.IsDigit(rune(p.source[p.pos])) || p.source[p.pos] == '.') {
+		p.pos++
+	}
+	number := p.source[start:p.pos]
+	if _, err := strconv.ParseFloat(number, 64); err != nil {
+		return nil, fmt.Errorf("expected a number or doc['field'].value at %d", start)
+	}
+	return model.NewLiteral(number), nil
+}
+
+// runtimeFieldsVisitor replaces references to runtime fields in WHERE clauses with their expressions
+type runtimeFieldsVisitor struct {
+	model.NoOpVisitor
+	runtimeFields []model.RuntimeField
+}
+
+func (v *runtimeFieldsVisitor) VisitColumnRef(e model.ColumnRef) interface{} {
+	for _, runtimeField := range v.runtimeFields {
+		if runtimeField.Name == e.ColumnName {
+			return runtimeField.Expr
+		}
+	}
+	return e
+}
+
+func (v *runtimeFieldsVisitor) VisitInfix(e model.InfixExpr) interface{} {
+	return model.NewInfixExpr(e.Left.Accept(v).(model.Expr), e.Op, e.Right.Accept(v).(model.Expr))
+}
+
+func (v *runtimeFieldsVisitor) VisitPrefixExpr(e model.PrefixExpr) interface{} {
+	return model.NewPrefixExpr(e.Op, v.visitAll(e.Args))
+}
+
+func (v *runtimeFieldsVisitor) VisitFunction(e model.FunctionExpr) interface{} {
+	return model.NewFunction(e.Name, v.visitAll(e.Args)...)
+}
+
+func (v *runtimeFieldsVisitor) VisitParenExpr(e model.ParenExpr) interface{} {
+	return model.NewParenExpr(v.visitAll(e.Exprs)...)
+}
+
+func (v *runtimeFieldsVisitor) visitAll(exprs []model.Expr) []model.Expr {
+	result := make([]model.Expr, 0, len(exprs))
+	for _, expr := range exprs {
+		if expr != nil {
+			result = append(result, expr.Accept(v).(model.Expr))
+		}
+	}
+	return result
+}
+
+// withRuntimeFields returns `whereClause` computing runtime fields, which it references
+func withRuntimeFields(whereClause model.Expr, runtimeFields []model.RuntimeField) model.Expr {
+	if whereClause == nil || len(runtimeFields) == 0 {
+		return whereClause
+	}
+	return whereClause.Accept(&runtimeFieldsVisitor{runtimeFields: runtimeFields}).(model.Expr)
+}
+
+func runtimeFieldNames(runtimeFields []model.RuntimeField) []string {
+	names := make([]string, 0, len(runtimeFields))
+	for _, runtimeField := range runtimeFields {
+		names = append(names, runtimeField.Name)
+	}
+	return names
+}
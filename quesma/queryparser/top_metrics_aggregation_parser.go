@@ -24,8 +24,13 @@ func (cw *ClickhouseQueryTranslator) ParseTopMetricsAggregation(queryMap QueryMa
 	}
 	var sortBy, order string
 	if sort, exists := queryMap["sort"]; exists {
+		// sort can be a list with 1 element, e.g. [{"@timestamp": "desc"}]
+		if sortAsList, ok := sort.([]any); ok && len(sortAsList) > 0 {
+			sort = sortAsList[0]
+		}
 		if sortAsQueryMap, ok := sort.(QueryMap); ok {
 			sortBy, order = getFirstKeyValue(cw.Ctx, sortAsQueryMap)
+			sortBy = cw.ResolveField(cw.Ctx, sortBy)
 		} else {
 			logger.WarnWithCtx(cw.Ctx).Msgf("sort field is not a query map, sort: %v", sort)
 		}
@@ -57,6 +62,10 @@ func (cw *ClickhouseQueryTranslator) ParseTopMetricsAggregation(queryMap QueryMa
 
 func getFirstKeyValue(ctx context.Context, queryMap QueryMap) (string, string) {
 	for k, v := range queryMap {
+		// value can be either "desc", or {"order": "desc"}
+		if vAsQueryMap, ok := v.(QueryMap); ok {
+			v = vAsQueryMap["order"]
+		}
 		vAsString, ok := v.(string)
 		if !ok {
 			logger.WarnWithCtx(ctx).Msgf("value is not a string (type: %T). key: %v, value: %v", v, k, v)
@@ -27,7 +27,7 @@ type AsyncQueryIdWithTime struct {
 func (e *AsyncQueriesEvictor) tryEvictAsyncRequests(timeFun func(time.Time) time.Duration) {
 	var ids []AsyncQueryIdWithTime
 	e.AsyncRequestStorage.Range(func(key string, value AsyncRequestResult) bool {
-		if timeFun(value.added) > EvictionInterval {
+		if timeFun(value.added) > e.evictionTime {
 			ids = append(ids, AsyncQueryIdWithTime{id: key, time: value.added})
 		}
 		return true
@@ -37,7 +37,7 @@ func (e *AsyncQueriesEvictor) tryEvictAsyncRequests(timeFun func(time.Time) time
 	}
 	var asyncQueriesContexts []*AsyncQueryContext
 	e.AsyncQueriesContexts.Range(func(key string, value *AsyncQueryContext) bool {
-		if timeFun(value.added) > EvictionInterval {
+		if timeFun(value.added) > e.evictionTime {
 			if value != nil {
 				asyncQueriesContexts = append(asyncQueriesContexts, value)
 			}
@@ -57,16 +57,57 @@ func (e *AsyncQueriesEvictor) tryEvictAsyncRequests(timeFun func(time.Time) time
 	}
 }
 
+// tryEvictPointsInTime evicts points in time, which weren't used for longer than their keep alive
+func (e *AsyncQueriesEvictor) tryEvictPointsInTime(timeFun func(time.Time) time.Duration) {
+	var ids []string
+	e.PointsInTime.Range(func(key string, value PointInTime) bool {
+		if timeFun(value.lastUsed) > value.keepAlive {
+			ids = append(ids, key)
+		}
+		return true
+	})
+	for _, id := range ids {
+		e.PointsInTime.Delete(id)
+	}
+	if len(ids) > 0 {
+		logger.Info().Msgf("Evicted %d points in time : %s", len(ids), strings.Join(ids, ","))
+	}
+}
+
+// tryEvictScrolls evicts scrolls, which weren't continued for longer than their keep alive
+func (e *AsyncQueriesEvictor) tryEvictScrolls(timeFun func(time.Time) time.Duration) {
+	var ids []string
+	e.Scrolls.Range(func(key string, value *Scroll) bool {
+		if !value.mu.TryLock() {
+			return true // a batch of it is running, so it's in use
+		}
+		if timeFun(value.lastUsed) > value.keepAlive {
+			ids = append(ids, key)
+		}
+		value.mu.Unlock()
+		return true
+	})
+	for _, id := range ids {
+		e.Scrolls.Delete(id)
+	}
+	if len(ids) > 0 {
+		logger.Info().Msgf("Evicted %d scrolls : %s", len(ids), strings.Join(ids, ","))
+	}
+}
+
 type AsyncQueriesEvictor struct {
 	ctx                  context.Context
 	cancel               context.CancelFunc
+	evictionTime         time.Duration
 	AsyncRequestStorage  *concurrent.Map[string, AsyncRequestResult]
 	AsyncQueriesContexts *concurrent.Map[string, *AsyncQueryContext]
+	PointsInTime         *concurrent.Map[string, PointInTime]
+	Scrolls              *concurrent.Map[string, *Scroll]
 }
 
-func NewAsyncQueriesEvictor(AsyncRequestStorage *concurrent.Map[string, AsyncRequestResult], AsyncQueriesContexts *concurrent.Map[string, *AsyncQueryContext]) *AsyncQueriesEvictor {
+func NewAsyncQueriesEvictor(evictionTime time.Duration, AsyncRequestStorage *concurrent.Map[string, AsyncRequestResult], AsyncQueriesContexts *concurrent.Map[string, *AsyncQueryContext], PointsInTime *concurrent.Map[string, PointInTime], Scrolls *concurrent.Map[string, *Scroll]) *AsyncQueriesEvictor {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &AsyncQueriesEvictor{ctx: ctx, cancel: cancel, AsyncRequestStorage: AsyncRequestStorage, AsyncQueriesContexts: AsyncQueriesContexts}
+	return &AsyncQueriesEvictor{ctx: ctx, cancel: cancel, evictionTime: evictionTime, AsyncRequestStorage: AsyncRequestStorage, AsyncQueriesContexts: AsyncQueriesContexts, PointsInTime: PointsInTime, Scrolls: Scrolls}
 }
 
 func (e *AsyncQueriesEvictor) asyncQueriesGC() {
@@ -78,6 +119,8 @@ func (e *AsyncQueriesEvictor) asyncQueriesGC() {
 			return
 		case <-time.After(GCInterval):
 			e.tryEvictAsyncRequests(elapsedTime)
+			e.tryEvictPointsInTime(elapsedTime)
+			e.tryEvictScrolls(elapsedTime)
 		}
 	}
 }
@@ -91,17 +134,25 @@ type AsyncQueryTraceLoggerEvictor struct {
 	AsyncQueryTrace *concurrent.Map[string, tracing.TraceCtx]
 	ctx             context.Context
 	cancel          context.CancelFunc
+	done            chan struct{} // closed after pending traces are flushed on stop
 }
 
 func (e *AsyncQueryTraceLoggerEvictor) Start() {
 	e.ctx, e.cancel = context.WithCancel(context.Background())
+	e.done = make(chan struct{})
 
 	go e.FlushHangingAsyncQueryTrace(elapsedTime)
 }
 
-func (e *AsyncQueryTraceLoggerEvictor) Stop() {
+// Stop flushes all pending async query traces, waiting for it at most until `ctx` is done.
+func (e *AsyncQueryTraceLoggerEvictor) Stop(ctx context.Context) {
 	e.cancel()
-	logger.Info().Msg("AsyncQueryTraceLoggerEvictor Stopped")
+	select {
+	case <-e.done:
+		logger.Info().Msg("AsyncQueryTraceLoggerEvictor Stopped")
+	case <-ctx.Done():
+		logger.Warn().Msgf("AsyncQueryTraceLoggerEvictor stopped before flushing all pending traces: %v", ctx.Err())
+	}
 }
 
 func (e *AsyncQueryTraceLoggerEvictor) TryFlushHangingAsyncQueryTrace(timeFun func(time.Time) time.Duration) {
@@ -109,10 +160,7 @@ func (e *AsyncQueryTraceLoggerEvictor) TryFlushHangingAsyncQueryTrace(timeFun fu
 	e.AsyncQueryTrace.Range(func(key string, value tracing.TraceCtx) bool {
 		if timeFun(value.Added) > EvictionInterval {
 			asyncIds = append(asyncIds, key)
-			logger.Error().Msgf("Async query %s was not finished", key)
-			var formattedLines strings.Builder
-			formattedLines.WriteString(tracing.FormatMessages(value.Messages))
-			logger.Info().Msg(formattedLines.String())
+			logUnfinishedAsyncQueryTrace(key, value)
 		}
 		return true
 	})
@@ -124,21 +172,35 @@ func (e *AsyncQueryTraceLoggerEvictor) TryFlushHangingAsyncQueryTrace(timeFun fu
 func (e *AsyncQueryTraceLoggerEvictor) FlushHangingAsyncQueryTrace(timeFun func(time.Time) time.Duration) {
 	go func() {
 		recovery.LogPanic()
+		defer close(e.done)
 		for {
 			select {
 			case <-time.After(GCInterval):
 				e.TryFlushHangingAsyncQueryTrace(timeFun)
 			case <-e.ctx.Done():
 				logger.Debug().Msg("AsyncQueryTraceLoggerEvictor stopped")
-				e.AsyncQueryTrace.Range(func(key string, value tracing.TraceCtx) bool {
-					logger.Error().Msgf("Async query %s was not finished", key)
-					var formattedLines strings.Builder
-					formattedLines.WriteString(tracing.FormatMessages(value.Messages))
-					logger.Info().Msg(formattedLines.String())
-					return true
-				})
+				e.flushAllAsyncQueryTraces()
 				return
 			}
 		}
 	}()
 }
+
+func (e *AsyncQueryTraceLoggerEvictor) flushAllAsyncQueryTraces() {
+	asyncIds := []string{}
+	e.AsyncQueryTrace.Range(func(key string, value tracing.TraceCtx) bool {
+		asyncIds = append(asyncIds, key)
+		logUnfinishedAsyncQueryTrace(key, value)
+		return true
+	})
+	for _, asyncId := range asyncIds {
+		e.AsyncQueryTrace.Delete(asyncId)
+	}
+}
+
+func logUnfinishedAsyncQueryTrace(asyncId string, traceCtx tracing.TraceCtx) {
+	logger.Error().Msgf("Async query %s was not finished", asyncId)
+	var formattedLines strings.Builder
+	formattedLines.WriteString(tracing.FormatMessages(traceCtx.Messages))
+	logger.Info().Msg(formattedLines.String())
+}
@@ -3,14 +3,17 @@
 package quesma
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"quesma/concurrent"
+	"quesma/quesma/config"
+	"quesma/tracing"
 	"testing"
 	"time"
 )
 
 func TestAsyncQueriesEvictorTimePassed(t *testing.T) {
-	evictor := NewAsyncQueriesEvictor(concurrent.NewMap[string, AsyncRequestResult](), concurrent.NewMapWith("1", &AsyncQueryContext{}))
+	evictor := NewAsyncQueriesEvictor(EvictionInterval, concurrent.NewMap[string, AsyncRequestResult](), concurrent.NewMapWith("1", &AsyncQueryContext{}), concurrent.NewMap[string, PointInTime](), concurrent.NewMap[string, *Scroll]())
 	evictor.AsyncRequestStorage.Store("1", AsyncRequestResult{added: time.Now()})
 	evictor.AsyncRequestStorage.Store("2", AsyncRequestResult{added: time.Now()})
 	evictor.AsyncRequestStorage.Store("3", AsyncRequestResult{added: time.Now()})
@@ -22,7 +25,7 @@ func TestAsyncQueriesEvictorTimePassed(t *testing.T) {
 }
 
 func TestAsyncQueriesEvictorStillAlive(t *testing.T) {
-	evictor := NewAsyncQueriesEvictor(concurrent.NewMap[string, AsyncRequestResult](), concurrent.NewMapWith("1", &AsyncQueryContext{}))
+	evictor := NewAsyncQueriesEvictor(EvictionInterval, concurrent.NewMap[string, AsyncRequestResult](), concurrent.NewMapWith("1", &AsyncQueryContext{}), concurrent.NewMap[string, PointInTime](), concurrent.NewMap[string, *Scroll]())
 	evictor.AsyncRequestStorage = concurrent.NewMap[string, AsyncRequestResult]()
 	evictor.AsyncRequestStorage.Store("1", AsyncRequestResult{added: time.Now()})
 	evictor.AsyncRequestStorage.Store("2", AsyncRequestResult{added: time.Now()})
@@ -33,3 +36,60 @@ func TestAsyncQueriesEvictorStillAlive(t *testing.T) {
 
 	assert.Equal(t, 3, evictor.AsyncRequestStorage.Size())
 }
+
+func TestAsyncQueriesEvictorConfiguredEvictionTime(t *testing.T) {
+	evictionTime := config.AsyncSearchConfiguration{EvictionTime: 2 * time.Minute}.GetEvictionTime()
+	tests := []struct {
+		name        string
+		elapsed     time.Duration
+		wantEvicted bool
+	}{
+		{"younger than eviction time", time.Minute, false},
+		{"exactly eviction time", 2 * time.Minute, false},
+		{"just over eviction time", 2*time.Minute + time.Nanosecond, true},
+		{"older than default eviction time", 20 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelled := false
+			evictor := NewAsyncQueriesEvictor(evictionTime, concurrent.NewMap[string, AsyncRequestResult](), concurrent.NewMap[string, *AsyncQueryContext](), concurrent.NewMap[string, PointInTime](), concurrent.NewMap[string, *Scroll]())
+			evictor.AsyncRequestStorage.Store("1", AsyncRequestResult{added: time.Now()})
+			evictor.AsyncQueriesContexts.Store("1", &AsyncQueryContext{id: "1", cancel: func() { cancelled = true }, added: time.Now()})
+			evictor.tryEvictAsyncRequests(func(time.Time) time.Duration {
+				return tt.elapsed
+			})
+
+			_, stored := evictor.AsyncRequestStorage.Load("1")
+			_, hasContext := evictor.AsyncQueriesContexts.Load("1")
+			assert.Equal(t, !tt.wantEvicted, stored)
+			assert.Equal(t, !tt.wantEvicted, hasContext)
+			assert.Equal(t, tt.wantEvicted, cancelled)
+		})
+	}
+}
+
+func TestAsyncSearchConfigurationDefaults(t *testing.T) {
+	cfg := config.AsyncSearchConfiguration{}
+	assert.Equal(t, 15*time.Minute, cfg.GetEvictionTime())
+	assert.Equal(t, 10000, cfg.GetQueriesLimit())
+	assert.Equal(t, 1024*1024*500, cfg.GetQueriesLimitBytes())
+
+	cfg = config.AsyncSearchConfiguration{EvictionTime: time.Hour, QueriesLimit: 5, QueriesLimitBytes: 1024}
+	assert.Equal(t, time.Hour, cfg.GetEvictionTime())
+	assert.Equal(t, 5, cfg.GetQueriesLimit())
+	assert.Equal(t, 1024, cfg.GetQueriesLimitBytes())
+}
+
+func TestAsyncQueryTraceLoggerEvictorFlushesPendingTracesOnStop(t *testing.T) {
+	evictor := AsyncQueryTraceLoggerEvictor{AsyncQueryTrace: concurrent.NewMap[string, tracing.TraceCtx]()}
+	evictor.AsyncQueryTrace.Store("1", tracing.TraceCtx{Messages: []string{"started"}, Added: time.Now()})
+	evictor.AsyncQueryTrace.Store("2", tracing.TraceCtx{Messages: []string{"started", "running"}, Added: time.Now()})
+	evictor.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	evictor.Stop(ctx)
+
+	assert.Equal(t, 0, evictor.AsyncQueryTrace.Size())
+	assert.NoError(t, ctx.Err())
+}
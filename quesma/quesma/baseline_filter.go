@@ -0,0 +1,104 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"quesma/clickhouse"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/queryparser"
+	"quesma/quesma/config"
+	"quesma/quesma/types"
+	"quesma/schema"
+	"sync"
+)
+
+// BaselineFilterPass AND-s index's configured baseline filter (`baselineFilter`) into WHERE of every query to that index.
+// Filters are translated to SQL once per table, on first use.
+type BaselineFilterPass struct {
+	cfg            map[string]config.IndexConfiguration
+	logManager     *clickhouse.LogManager
+	schemaRegistry schema.Registry
+
+	mutex   sync.Mutex
+	filters map[string]model.Expr // table name -> translated filter, nil if there's none (or it's invalid)
+}
+
+func NewBaselineFilterPass(cfg map[string]config.IndexConfiguration, logManager *clickhouse.LogManager, schemaRegistry schema.Registry) *BaselineFilterPass {
+	return &BaselineFilterPass{cfg: cfg, logManager: logManager, schemaRegistry: schemaRegistry, filters: make(map[string]model.Expr)}
+}
+
+func (p *BaselineFilterPass) Transform(queries []*model.Query) ([]*model.Query, error) {
+	for _, query := range queries {
+		if filter := p.filterFor(getFromTable(query.TableName)); filter != nil {
+			addBaselineFilter(&query.SelectCommand, filter)
+		}
+	}
+	return queries, nil
+}
+
+// addBaselineFilter adds `filter` to the innermost query reading directly from the table,
+// so that e.g. we filter before collapsing or sampling, not after.
+func addBaselineFilter(selectCommand *model.SelectCommand, filter model.Expr) {
+	switch from := selectCommand.FromClause.(type) {
+	case model.SelectCommand:
+		addBaselineFilter(&from, filter)
+		selectCommand.FromClause = from
+	case *model.SelectCommand:
+		addBaselineFilter(from, filter)
+	default:
+		selectCommand.WhereClause = model.And([]model.Expr{selectCommand.WhereClause, filter})
+	}
+}
+
+func (p *BaselineFilterPass) filterFor(tableName string) model.Expr {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if filter, cached := p.filters[tableName]; cached {
+		return filter
+	}
+	filter, cacheable := p.translateFilter(tableName)
+	if cacheable {
+		p.filters[tableName] = filter
+	}
+	return filter
+}
+
+// translateFilter returns cacheable = false, if the filter can't be translated yet, because its table hasn't been discovered
+func (p *BaselineFilterPass) translateFilter(tableName string) (filter model.Expr, cacheable bool) {
+	indexConfig, found := p.indexConfigFor(tableName)
+	if !found || indexConfig.BaselineFilter == "" {
+		return nil, true
+	}
+	ctx := context.Background()
+	filterJson, err := types.ParseJSON(indexConfig.BaselineFilter)
+	if err != nil {
+		logger.ErrorWithCtx(ctx).Msgf("invalid baseline filter of index %s: %v", indexConfig.Name, err)
+		return nil, true
+	}
+	table := p.logManager.FindTable(tableName)
+	if table == nil {
+		logger.WarnWithCtx(ctx).Msgf("table %s not found, can't apply its baseline filter", tableName)
+		return nil, false
+	}
+	cw := queryparser.ClickhouseQueryTranslator{ClickhouseLM: p.logManager, Table: table, Ctx: ctx, SchemaRegistry: p.schemaRegistry}
+	parsed := cw.ParseFilter(filterJson)
+	if !parsed.CanParse {
+		logger.ErrorWithCtx(ctx).Msgf("can't translate baseline filter of index %s: %s", indexConfig.Name, indexConfig.BaselineFilter)
+		return nil, true
+	}
+	return parsed.WhereClause, true
+}
+
+func (p *BaselineFilterPass) indexConfigFor(tableName string) (config.IndexConfiguration, bool) {
+	if indexConfig, found := p.cfg[tableName]; found {
+		return indexConfig, true
+	}
+	for _, indexConfig := range p.cfg {
+		if indexConfig.PartitionOf(tableName) {
+			return indexConfig, true
+		}
+	}
+	return config.IndexConfiguration{}, false
+}
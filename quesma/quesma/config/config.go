@@ -3,6 +3,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
 	"github.com/knadh/koanf/parsers/yaml"
@@ -17,6 +18,7 @@ import (
 	"quesma/network"
 	"slices"
 	"strings"
+	"time"
 )
 
 const (
@@ -44,6 +46,142 @@ type QuesmaConfiguration struct {
 	PublicTcpPort              network.Port                  `koanf:"port"`
 	IngestStatistics           bool                          `koanf:"ingestStatistics"`
 	QuesmaInternalTelemetryUrl *Url                          `koanf:"internalTelemetryUrl"`
+	QueryCache                 QueryCacheConfiguration       `koanf:"queryCache"`
+	AsyncSearch                AsyncSearchConfiguration      `koanf:"asyncSearch"`
+	// StreamHitsThreshold > 0 <=> hits of (non-async, non-aggregation) searches with size >= it are streamed
+	// to the client row by row, instead of building the whole response in memory
+	StreamHitsThreshold int `koanf:"streamHitsThreshold"`
+	// MaxListQueryLimit bounds LIMIT of every hits/list query, so a malformed request can't scan the whole table
+	MaxListQueryLimit int `koanf:"maxListQueryLimit"`
+	// MaxParallelQueries bounds how many queries (e.g. per-table queries of a search over multiple tables) run in parallel.
+	// If more would run, queries of a search are run one by one.
+	MaxParallelQueries int `koanf:"maxParallelQueries"`
+	// MaxContentLength is the max size of a request body in bytes, after decompression (like Elasticsearch's `http.max_content_length`).
+	// Larger requests are rejected with 413. 100MB by default.
+	MaxContentLength int `koanf:"maxContentLength"`
+	// PreWhere enables moving cheap, selective filters (timestamp ranges, equality on LowCardinality columns) to ClickHouse PREWHERE
+	PreWhere bool `koanf:"preWhere"`
+	// SequentialConsistency makes searches of all indexes read with ClickHouse `select_sequential_consistency`,
+	// so they see all writes acknowledged before (on replicated tables). It adds latency, so it's disabled by default.
+	// It can also be enabled per index.
+	SequentialConsistency bool `koanf:"sequentialConsistency"`
+	// FlattenCollisionPolicy says what to do, when flattening a document during ingest produces the same field twice,
+	// e.g. for both `a.b` and `a: {b: ...}`. One of "merge", "suffix" (default), "reject".
+	FlattenCollisionPolicy string `koanf:"flattenCollisionPolicy"`
+	// IdentifierQuoting says which column names (and aliases) are quoted in generated SQL:
+	// "always" (default), or "whenNeeded" - only ones with special characters or equal to ClickHouse keywords.
+	IdentifierQuoting string `koanf:"identifierQuoting"`
+	// LegacyTypes makes us compatible with clients from before Elasticsearch removed mapping types:
+	// hits have `_type: _doc`, and typed search paths (`/{index}/{type}/_search`) are accepted. Disabled by default.
+	LegacyTypes bool `koanf:"legacyTypes"`
+}
+
+// QueryCacheConfiguration configures cache of ClickHouse results of search queries. It's disabled by default.
+type QueryCacheConfiguration struct {
+	Enabled bool          `koanf:"enabled"`
+	TTL     time.Duration `koanf:"ttl"`     // how long a result is valid, e.g. "30s"
+	MaxSize int           `koanf:"maxSize"` // max number of cached results, least recently used are evicted first
+	// CacheRelative enables caching of queries with bounds relative to now(), e.g. `now-15m`.
+	// Their results are (slightly) stale before TTL passes, so it's disabled by default.
+	CacheRelative bool `koanf:"cacheRelative"`
+}
+
+const (
+	defaultAsyncEvictionTime      = 15 * time.Minute
+	defaultAsyncQueriesLimit      = 10000
+	defaultAsyncQueriesLimitBytes = 1024 * 1024 * 500 // 500MB
+	defaultAsyncRunningLimit      = 1000
+)
+
+// AsyncSearchConfiguration configures how async search results are kept. Zero values mean defaults.
+type AsyncSearchConfiguration struct {
+	EvictionTime      time.Duration `koanf:"evictionTime"`      // results older than this are evicted, e.g. "15m"
+	QueriesLimit      int           `koanf:"queriesLimit"`      // max number of stored async results
+	QueriesLimitBytes int           `koanf:"queriesLimitBytes"` // max cumulated size of stored async results
+	RunningLimit      int           `koanf:"runningLimit"`      // max number of concurrently running async queries
+	// Table, if set, is a ClickHouse table async results are also persisted to (for EvictionTime), so they survive
+	// restarts, and results, which don't fit into the limits above, aren't lost. It's created, if it doesn't exist.
+	Table string `koanf:"table"`
+}
+
+func (c AsyncSearchConfiguration) GetEvictionTime() time.Duration {
+	if c.EvictionTime <= 0 {
+		return defaultAsyncEvictionTime
+	}
+	return c.EvictionTime
+}
+
+func (c AsyncSearchConfiguration) GetQueriesLimit() int {
+	if c.QueriesLimit <= 0 {
+		return defaultAsyncQueriesLimit
+	}
+	return c.QueriesLimit
+}
+
+func (c AsyncSearchConfiguration) GetQueriesLimitBytes() int {
+	if c.QueriesLimitBytes <= 0 {
+		return defaultAsyncQueriesLimitBytes
+	}
+	return c.QueriesLimitBytes
+}
+
+func (c AsyncSearchConfiguration) GetRunningLimit() int {
+	if c.RunningLimit <= 0 {
+		return defaultAsyncRunningLimit
+	}
+	return c.RunningLimit
+}
+
+const defaultMaxListQueryLimit = 10000
+
+func (c *QuesmaConfiguration) GetMaxListQueryLimit() int {
+	if c.MaxListQueryLimit <= 0 {
+		return defaultMaxListQueryLimit
+	}
+	return c.MaxListQueryLimit
+}
+
+const defaultMaxParallelQueries = 25
+
+func (c *QuesmaConfiguration) GetMaxParallelQueries() int {
+	if c.MaxParallelQueries <= 0 {
+		return defaultMaxParallelQueries
+	}
+	return c.MaxParallelQueries
+}
+
+const defaultMaxContentLength = 100 * 1024 * 1024 // 100MB
+
+func (c *QuesmaConfiguration) GetMaxContentLength() int {
+	if c.MaxContentLength <= 0 {
+		return defaultMaxContentLength
+	}
+	return c.MaxContentLength
+}
+
+const (
+	FlattenCollisionPolicyMerge  = "merge"  // colliding values are merged into an array
+	FlattenCollisionPolicySuffix = "suffix" // colliding fields get a numeric suffix, e.g. `a::b_1`
+	FlattenCollisionPolicyReject = "reject" // documents with colliding fields are rejected
+)
+
+func (c *QuesmaConfiguration) GetFlattenCollisionPolicy() string {
+	if c.FlattenCollisionPolicy == "" {
+		return FlattenCollisionPolicySuffix
+	}
+	return c.FlattenCollisionPolicy
+}
+
+const (
+	IdentifierQuotingAlways     = "always"
+	IdentifierQuotingWhenNeeded = "whenNeeded"
+)
+
+func (c *QuesmaConfiguration) GetIdentifierQuoting() string {
+	if c.IdentifierQuoting == "" {
+		return IdentifierQuotingAlways
+	}
+	return c.IdentifierQuoting
 }
 
 type LoggingConfiguration struct {
@@ -53,6 +191,11 @@ type LoggingConfiguration struct {
 	FileLogging       bool          `koanf:"fileLogging"`
 }
 
+const (
+	ClickHouseProtocolNative = "native"
+	ClickHouseProtocolHttp   = "http"
+)
+
 type RelationalDbConfiguration struct {
 	//ConnectorName string `koanf:"name"`
 	ConnectorType string `koanf:"type"`
@@ -61,6 +204,42 @@ type RelationalDbConfiguration struct {
 	Password      string `koanf:"password"`
 	Database      string `koanf:"database"`
 	AdminUrl      *Url   `koanf:"adminUrl"`
+	// Protocol is "native" (default) or "http", for deployments which expose only the HTTP interface
+	Protocol string `koanf:"protocol"`
+}
+
+func (c *RelationalDbConfiguration) GetProtocol() string {
+	if c.Protocol == "" {
+		return ClickHouseProtocolNative
+	}
+	return c.Protocol
+}
+
+// validateProtocol checks if Url can be used with the chosen protocol.
+// We reject default ports of the other protocol, as it's almost certainly a misconfiguration.
+func (c *RelationalDbConfiguration) validateProtocol(name string) error {
+	protocol := c.GetProtocol()
+	if protocol != ClickHouseProtocolNative && protocol != ClickHouseProtocolHttp {
+		return fmt.Errorf("invalid %s protocol '%s', expected '%s' or '%s'", name, c.Protocol, ClickHouseProtocolNative, ClickHouseProtocolHttp)
+	}
+	if c.Url == nil {
+		return nil
+	}
+	port := c.Url.ToUrl().Port()
+	switch protocol {
+	case ClickHouseProtocolHttp:
+		if c.Url.Scheme != "http" && c.Url.Scheme != "https" {
+			return fmt.Errorf("%s URL %s must have http or https scheme to use the HTTP protocol", name, c.Url.String())
+		}
+		if port == "9000" || port == "9440" {
+			return fmt.Errorf("%s URL %s uses a native protocol port, but the protocol is '%s'", name, c.Url.String(), protocol)
+		}
+	case ClickHouseProtocolNative:
+		if port == "8123" || port == "8443" {
+			return fmt.Errorf("%s URL %s uses an HTTP interface port, but the protocol is '%s'", name, c.Url.String(), protocol)
+		}
+	}
+	return nil
 }
 
 func (c *RelationalDbConfiguration) IsEmpty() bool {
@@ -80,7 +259,7 @@ type FieldAlias struct {
 
 func (c *QuesmaConfiguration) IsFullTextMatchField(indexName, fieldName string) bool {
 	if indexConfig, found := c.IndexConfig[indexName]; found {
-		return indexConfig.HasFullTextField(fieldName)
+		return indexConfig.HasFullTextField(fieldName) || indexConfig.MessageField == fieldName
 	}
 	return false
 }
@@ -92,6 +271,26 @@ func (c *QuesmaConfiguration) AliasFields(indexName string) map[string]FieldAlia
 	return map[string]FieldAlias{}
 }
 
+// ResolveIndexAlias returns the name of the configured index, which has `alias` in its index aliases
+func (c *QuesmaConfiguration) ResolveIndexAlias(alias string) (indexName string, found bool) {
+	for name, indexConfig := range c.IndexConfig {
+		if indexConfig.HasIndexAlias(alias) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ResolvePartitionedIndex returns the name of the logical index, whose physical table is `tableName`
+func (c *QuesmaConfiguration) ResolvePartitionedIndex(tableName string) (indexName string, found bool) {
+	for name, indexConfig := range c.IndexConfig {
+		if indexConfig.PartitionOf(tableName) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
 func MatchName(pattern, name string) bool {
 	return index.TableNamePatternRegexp(pattern).MatchString(name)
 }
@@ -168,6 +367,12 @@ func (c *QuesmaConfiguration) Validate() error {
 	if c.ClickHouse.IsNonEmpty() && c.Hydrolix.IsNonEmpty() {
 		result = multierror.Append(result, fmt.Errorf("only one of ClickHouse and Hydrolix can be configured"))
 	}
+	if err := c.ClickHouse.validateProtocol("clickHouse"); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := c.Hydrolix.validateProtocol("hydrolix"); err != nil {
+		result = multierror.Append(result, err)
+	}
 	if c.Elasticsearch.Url == nil {
 		result = multierror.Append(result, fmt.Errorf("elasticsearch URL is required"))
 	}
@@ -179,6 +384,51 @@ func (c *QuesmaConfiguration) Validate() error {
 		// TODO enable when rolling out schema configuration
 		//result = c.validateDeprecated(indexConfig, result)
 		result = c.validateSchemaConfiguration(indexConfig, result)
+		result = c.validateFieldPlacement(indexConfig, result)
+		if indexConfig.TablePartitions != nil {
+			if err := indexConfig.TablePartitions.validate(indexName); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+		if indexConfig.DeadLetter != nil {
+			if err := indexConfig.DeadLetter.validate(indexName); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+		if indexConfig.Join != nil {
+			if err := indexConfig.Join.validate(indexName); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+		for routing, tables := range indexConfig.Routing {
+			if len(tables) == 0 {
+				result = multierror.Append(result, fmt.Errorf("index %s has routing %s without tables", indexName, routing))
+			}
+		}
+		if indexConfig.MaxResultWindow < 0 {
+			result = multierror.Append(result, fmt.Errorf("index %s has negative maxResultWindow: %d", indexName, indexConfig.MaxResultWindow))
+		}
+		if indexConfig.QueryTimeout < 0 {
+			result = multierror.Append(result, fmt.Errorf("index %s has negative queryTimeout: %s", indexName, indexConfig.QueryTimeout))
+		}
+		if indexConfig.BaselineFilter != "" {
+			var baselineFilter map[string]any
+			if err := json.Unmarshal([]byte(indexConfig.BaselineFilter), &baselineFilter); err != nil {
+				result = multierror.Append(result, fmt.Errorf("index %s has invalid baselineFilter: %v", indexName, err))
+			}
+		}
+		if indexConfig.DefaultQuery != "" {
+			var defaultQuery map[string]any
+			if err := json.Unmarshal([]byte(indexConfig.DefaultQuery), &defaultQuery); err != nil {
+				result = multierror.Append(result, fmt.Errorf("index %s has invalid defaultQuery: %v", indexName, err))
+			}
+		}
+	}
+	if !slices.Contains([]string{FlattenCollisionPolicyMerge, FlattenCollisionPolicySuffix, FlattenCollisionPolicyReject}, c.GetFlattenCollisionPolicy()) {
+		result = multierror.Append(result, fmt.Errorf("invalid flattenCollisionPolicy '%s'", c.FlattenCollisionPolicy))
+	}
+	if !slices.Contains([]string{IdentifierQuotingAlways, IdentifierQuotingWhenNeeded}, c.GetIdentifierQuoting()) {
+		result = multierror.Append(result, fmt.Errorf("invalid identifierQuoting '%s'", c.IdentifierQuoting))
 	}
 	if c.Hydrolix.IsNonEmpty() {
 		// At this moment we share the code between ClickHouse and Hydrolix which use only different names
@@ -207,6 +457,15 @@ func (c *QuesmaConfiguration) validateDeprecated(indexName IndexConfiguration, r
 	return result
 }
 
+func (c *QuesmaConfiguration) validateFieldPlacement(indexConfig IndexConfiguration, result error) error {
+	for _, fieldName := range indexConfig.ColumnFields {
+		if indexConfig.IsOthersField(fieldName) {
+			result = multierror.Append(result, fmt.Errorf("field %s in index %s is configured both as a column and as an others field", fieldName, indexConfig.Name))
+		}
+	}
+	return result
+}
+
 func (c *QuesmaConfiguration) validateIndexName(indexName string, result error) error {
 	if strings.Contains(indexName, "*") || indexName == "_all" {
 		result = multierror.Append(result, fmt.Errorf("wildcard patterns are not allowed in index configuration: %s", indexName))
@@ -264,6 +523,9 @@ func (c *QuesmaConfiguration) String() string {
 	if c.ClickHouse.Database != "" {
 		clickhouseExtra += fmt.Sprintf("\n      ClickHouse database: %s", c.ClickHouse.Database)
 	}
+	if c.ClickHouse.Protocol != "" {
+		clickhouseExtra += fmt.Sprintf("\n      ClickHouse protocol: %s", c.ClickHouse.Protocol)
+	}
 	var connectorString strings.Builder
 	for connName, conn := range c.Connectors {
 		connectorString.WriteString(fmt.Sprintf("\n        - [%s] connector", connName))
@@ -285,6 +547,10 @@ func (c *QuesmaConfiguration) String() string {
 	if c.QuesmaInternalTelemetryUrl != nil {
 		quesmaInternalTelemetryUrl = c.QuesmaInternalTelemetryUrl.String()
 	}
+	queryCache := "disabled"
+	if c.QueryCache.Enabled {
+		queryCache = fmt.Sprintf("enabled, ttl: %v, max size: %d, cache relative: %t", c.QueryCache.TTL, c.QueryCache.MaxSize, c.QueryCache.CacheRelative)
+	}
 	return fmt.Sprintf(`
 Quesma Configuration:
 	Mode: %s
@@ -297,7 +563,18 @@ Quesma Configuration:
 	Log Level: %v
 	Public TCP Port: %d
 	Ingest Statistics: %t,
-	Quesma Telemetry URL: %s`,
+	Quesma Telemetry URL: %s
+	Query Cache: %s
+	Async Search: eviction time: %v, queries limit: %d, queries limit bytes: %d, running limit: %d, table: %s
+	Stream Hits Threshold: %d
+	Max List Query Limit: %d
+	Max Parallel Queries: %d
+	Max Content Length: %d
+	PREWHERE: %t
+	Sequential Consistency: %t
+	Flatten Collision Policy: %s
+	Identifier Quoting: %s
+	Legacy Types: %t`,
 		c.Mode.String(),
 		elasticUrl,
 		elasticsearchExtra,
@@ -311,6 +588,21 @@ Quesma Configuration:
 		c.PublicTcpPort,
 		c.IngestStatistics,
 		quesmaInternalTelemetryUrl,
+		queryCache,
+		c.AsyncSearch.GetEvictionTime(),
+		c.AsyncSearch.GetQueriesLimit(),
+		c.AsyncSearch.GetQueriesLimitBytes(),
+		c.AsyncSearch.GetRunningLimit(),
+		c.AsyncSearch.Table,
+		c.StreamHitsThreshold,
+		c.GetMaxListQueryLimit(),
+		c.GetMaxParallelQueries(),
+		c.GetMaxContentLength(),
+		c.PreWhere,
+		c.SequentialConsistency,
+		c.GetFlattenCollisionPolicy(),
+		c.GetIdentifierQuoting(),
+		c.LegacyTypes,
 	)
 }
 
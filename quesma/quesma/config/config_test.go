@@ -154,6 +154,35 @@ func TestHydrolixConfigurationLandsInClickHouseConfig(t *testing.T) {
 	assert.Equal(t, "dbname", cfg.ClickHouse.Database)
 }
 
+func TestClickHouseProtocolValidation(t *testing.T) {
+	tests := []struct {
+		url      string
+		protocol string
+		wantErr  bool
+	}{
+		{"clickhouse://localhost:9000", "", false},
+		{"clickhouse://localhost:9000", ClickHouseProtocolNative, false},
+		{"clickhouse://localhost:8123", ClickHouseProtocolNative, true},
+		{"http://localhost:8123", ClickHouseProtocolHttp, false},
+		{"https://localhost:8443", ClickHouseProtocolHttp, false},
+		{"http://localhost:9000", ClickHouseProtocolHttp, true},
+		{"clickhouse://localhost:8123", ClickHouseProtocolHttp, true},
+		{"clickhouse://localhost:9000", "grpc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url+" "+tt.protocol, func(t *testing.T) {
+			var chUrl Url
+			assert.NoError(t, chUrl.UnmarshalText([]byte(tt.url)))
+			cfg := RelationalDbConfiguration{Url: &chUrl, Protocol: tt.protocol}
+			if tt.wantErr {
+				assert.Error(t, cfg.validateProtocol("clickHouse"))
+			} else {
+				assert.NoError(t, cfg.validateProtocol("clickHouse"))
+			}
+		})
+	}
+}
+
 func TestMatchName(t *testing.T) {
 	type args struct {
 		indexName        string
@@ -0,0 +1,33 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package config
+
+import "fmt"
+
+// DeadLetterConfiguration makes documents, which we fail to insert (e.g. because of a type mismatch),
+// go to a dead-letter sink together with the error, instead of being dropped. They can be inspected and reprocessed later.
+type DeadLetterConfiguration struct {
+	// Table is a ClickHouse table failed documents are written to. It's created, if it doesn't exist.
+	Table string `koanf:"table"`
+	// File is a path of a file failed documents are appended to (one JSON per line), used instead of Table
+	File string `koanf:"file"`
+	// Retries is how many more times we try to insert a failing document, before it goes to the sink
+	Retries int `koanf:"retries"`
+}
+
+func (c DeadLetterConfiguration) validate(indexName string) error {
+	if (c.Table == "") == (c.File == "") {
+		return fmt.Errorf("index %s must have exactly one of deadLetter table and file", indexName)
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("index %s has negative deadLetter retries: %d", indexName, c.Retries)
+	}
+	return nil
+}
+
+func (c DeadLetterConfiguration) String() string {
+	if c.Table != "" {
+		return fmt.Sprintf("table %s, retries: %d", c.Table, c.Retries)
+	}
+	return fmt.Sprintf("file %s, retries: %d", c.File, c.Retries)
+}
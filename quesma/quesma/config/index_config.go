@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"time"
 )
 
 type IndexConfiguration struct {
@@ -13,6 +14,9 @@ type IndexConfiguration struct {
 	Enabled bool   `koanf:"enabled"`
 	// TODO to be deprecated
 	FullTextFields []string `koanf:"fullTextFields"`
+	// FullTextStringFieldsByDefault makes all String (and LowCardinality(String)) columns fulltext-searchable,
+	// if no FullTextFields are configured. Otherwise, fulltext queries without explicit fields match nothing then.
+	FullTextStringFieldsByDefault bool `koanf:"fullTextStringFieldsByDefault"`
 	// TODO to be deprecated
 	Aliases map[string]FieldAlias `koanf:"aliases"`
 	// TODO to be deprecated
@@ -21,15 +25,134 @@ type IndexConfiguration struct {
 	IgnoredFields map[string]bool `koanf:"ignoredFields"`
 	// TODO to be deprecated
 	TimestampField *string `koanf:"timestampField"`
+	// IndexAliases are alternative index names, which resolve to this index (e.g. when writing with `require_alias`)
+	IndexAliases []string `koanf:"indexAliases"`
+	// ColumnFields are always ingested into dedicated, typed columns. If such column doesn't exist yet, it's created.
+	ColumnFields []string `koanf:"columnFields"`
+	// OthersFields are never ingested into dedicated columns, but always into attributes/others map (if the table has one)
+	OthersFields []string `koanf:"othersFields"`
+	// MessageField is the primary log message field. It's the default field of fulltext queries without explicit
+	// fields (e.g. from the query bar) and it's always returned in hits.
+	MessageField string `koanf:"messageField"`
+	// SeqNoFields is a monotonic key (e.g. timestamp + a tiebreaker), which backs our synthetic `_seq_no`.
+	// Sorting by `_seq_no` sorts by these fields, so it can be used with `search_after` for resumable reads.
+	SeqNoFields []string `koanf:"seqNoFields"`
+	// VersionField is a last-modified timestamp (or a version number) of documents, which backs our synthetic `_version`
+	// of hits, so it changes when a document is updated. Without it, `_version` is always 1.
+	VersionField string `koanf:"versionField"`
+	// IdField is a column, which stores `_id` of documents written by _bulk. It's needed to delete (or update)
+	// documents by their `_id` in _bulk. Without it, _bulk delete/update operations are rejected.
+	IdField string `koanf:"idField"`
+	// CaseInsensitiveFields are keyword fields matched case-insensitively by term queries (like with Elasticsearch's
+	// lowercase normalizer). Other fields are matched case-sensitively.
+	CaseInsensitiveFields []string `koanf:"caseInsensitiveFields"`
+	// IgnoreAbove is a max length of keyword fields' values (like in Elasticsearch's `ignore_above` mapping).
+	// Longer values aren't matched by term queries, and hits list such fields in `_ignored`.
+	IgnoreAbove map[string]int `koanf:"ignoreAbove"`
+	// BaselineFilter is an Elasticsearch query (as JSON), which is always AND-ed with queries to this index,
+	// e.g. `{"bool": {"must_not": {"term": {"level": "debug"}}}}` hides debug logs
+	BaselineFilter string `koanf:"baselineFilter"`
+	// DefaultQuery is an Elasticsearch query (as JSON), used instead of match-all when a search has no `query`,
+	// e.g. `{"range": {"@timestamp": {"gte": "now-15m"}}}`
+	DefaultQuery string `koanf:"defaultQuery"`
+	// RejectUnboundedScans makes us reject searches without any filter (e.g. on huge tables, where they'd scan everything)
+	RejectUnboundedScans bool `koanf:"rejectUnboundedScans"`
+	// SequentialConsistency makes searches read with ClickHouse `select_sequential_consistency`, so on replicated tables
+	// they don't miss documents, which were just ingested. It adds latency, so it's disabled by default.
+	SequentialConsistency bool `koanf:"sequentialConsistency"`
+	// QueryLogComment makes searches send ClickHouse `log_comment` setting with the Quesma request id, index and endpoint,
+	// so their queries can be traced back from `system.query_log` to the requests
+	QueryLogComment bool `koanf:"queryLogComment"`
+	// MaxResultWindow is a max `from + size` of searches (like Elasticsearch's `index.max_result_window` setting),
+	// DefaultMaxResultWindow if not set. Deeper pages can still be requested with `search_after`.
+	MaxResultWindow int `koanf:"maxResultWindow"`
+	// QueryTimeout is a default timeout of searches, e.g. "30s", unless they set `timeout` themselves. Queries still running
+	// then are cancelled, and the search returns results it has so far, with `timed_out: true`. No timeout if not set.
+	QueryTimeout time.Duration `koanf:"queryTimeout"`
+	// SamplingErrorEstimate makes random_sampler aggregations return also `doc_count_error_estimate`: standard error
+	// of their (scaled) doc_count, so dashboards can show that results are approximate
+	SamplingErrorEstimate bool `koanf:"samplingErrorEstimate"`
+	// DeadLetter != nil <=> documents we fail to insert are written to a dead-letter sink, instead of being dropped
+	DeadLetter *DeadLetterConfiguration `koanf:"deadLetter"`
+	// Join != nil <=> the table stores parent/child documents, which can be queried with `has_child` and `has_parent`
+	Join *JoinConfiguration `koanf:"join"`
+	// Routing maps values of search `routing` URL param to tables holding documents with this routing,
+	// e.g. `{"eu": ["logs_eu"], "us": ["logs_us"]}`, so routed searches scan only them. Without it, `routing` is ignored.
+	Routing map[string][]string `koanf:"routing"`
+	// TablePartitions != nil <=> this index is logical, backed by multiple time-partitioned physical tables
+	TablePartitions *TablePartitionsConfiguration `koanf:"tablePartitions"`
 	// this is hidden from the user right now
 	// deprecated
 	SchemaConfiguration *SchemaConfiguration `koanf:"static-schema"`
 }
 
+// DefaultMaxResultWindow is the max `from + size` of searches, unless MaxResultWindow is set (the same as Elasticsearch's)
+const DefaultMaxResultWindow = 10000
+
 func (c IndexConfiguration) HasFullTextField(fieldName string) bool {
 	return slices.Contains(c.FullTextFields, fieldName)
 }
 
+func (c IndexConfiguration) HasIndexAlias(alias string) bool {
+	return slices.Contains(c.IndexAliases, alias)
+}
+
+func (c IndexConfiguration) IsColumnField(fieldName string) bool {
+	return slices.Contains(c.ColumnFields, fieldName)
+}
+
+func (c IndexConfiguration) IsOthersField(fieldName string) bool {
+	return slices.Contains(c.OthersFields, fieldName)
+}
+
+// GeoPointFields returns fields typed geo_point (or point) in the schema configuration, or in mappings
+// if it's not used. They're ingested into two columns: `field::lat` and `field::lon`.
+func (c IndexConfiguration) GeoPointFields() []string {
+	isGeoPoint := func(fieldType string) bool { return fieldType == "geo_point" || fieldType == "point" }
+	var fields []string
+	if c.SchemaConfiguration != nil {
+		for _, field := range c.SchemaConfiguration.Fields {
+			if isGeoPoint(field.Type.AsString()) {
+				fields = append(fields, field.Name.AsString())
+			}
+		}
+	} else {
+		for fieldName, fieldType := range c.TypeMappings {
+			if isGeoPoint(fieldType) {
+				fields = append(fields, fieldName)
+			}
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// PartitionOf returns true <=> `tableName` is one of physical tables of this (logical) index
+func (c IndexConfiguration) PartitionOf(tableName string) bool {
+	if c.TablePartitions == nil {
+		return false
+	}
+	_, _, found := c.TablePartitions.PartitionRange(tableName)
+	return found
+}
+
+// RoutedTables returns tables holding documents with any of `routing` values, false if some value isn't mapped
+// (then we can't restrict the search)
+func (c IndexConfiguration) RoutedTables(routing []string) ([]string, bool) {
+	if len(c.Routing) == 0 || len(routing) == 0 {
+		return nil, false
+	}
+	var tables []string
+	for _, value := range routing {
+		routedTables, ok := c.Routing[value]
+		if !ok {
+			return nil, false
+		}
+		tables = append(tables, routedTables...)
+	}
+	return tables, true
+}
+
 func (c IndexConfiguration) String() string {
 	var extraString string
 	extraString = ""
@@ -59,6 +182,86 @@ func (c IndexConfiguration) String() string {
 		str = fmt.Sprintf("%s, fullTextFields: %s", str, strings.Join(c.FullTextFields, ", "))
 	}
 
+	if c.FullTextStringFieldsByDefault {
+		str = fmt.Sprintf("%s, fullTextStringFieldsByDefault", str)
+	}
+
+	if len(c.IndexAliases) > 0 {
+		str = fmt.Sprintf("%s, indexAliases: %s", str, strings.Join(c.IndexAliases, ", "))
+	}
+
+	if len(c.ColumnFields) > 0 {
+		str = fmt.Sprintf("%s, columnFields: %s", str, strings.Join(c.ColumnFields, ", "))
+	}
+
+	if len(c.OthersFields) > 0 {
+		str = fmt.Sprintf("%s, othersFields: %s", str, strings.Join(c.OthersFields, ", "))
+	}
+
+	if c.MessageField != "" {
+		str = fmt.Sprintf("%s, messageField: %s", str, c.MessageField)
+	}
+
+	if len(c.SeqNoFields) > 0 {
+		str = fmt.Sprintf("%s, seqNoFields: %s", str, strings.Join(c.SeqNoFields, ", "))
+	}
+
+	if c.VersionField != "" {
+		str = fmt.Sprintf("%s, versionField: %s", str, c.VersionField)
+	}
+
+	if c.IdField != "" {
+		str = fmt.Sprintf("%s, idField: %s", str, c.IdField)
+	}
+
+	if c.BaselineFilter != "" {
+		str = fmt.Sprintf("%s, baselineFilter: %s", str, c.BaselineFilter)
+	}
+
+	if c.DefaultQuery != "" {
+		str = fmt.Sprintf("%s, defaultQuery: %s", str, c.DefaultQuery)
+	}
+
+	if c.RejectUnboundedScans {
+		str = fmt.Sprintf("%s, rejectUnboundedScans", str)
+	}
+
+	if c.SequentialConsistency {
+		str = fmt.Sprintf("%s, sequentialConsistency", str)
+	}
+
+	if c.QueryLogComment {
+		str = fmt.Sprintf("%s, queryLogComment", str)
+	}
+
+	if c.MaxResultWindow != 0 {
+		str = fmt.Sprintf("%s, maxResultWindow: %d", str, c.MaxResultWindow)
+	}
+
+	if c.QueryTimeout != 0 {
+		str = fmt.Sprintf("%s, queryTimeout: %s", str, c.QueryTimeout)
+	}
+
+	if c.SamplingErrorEstimate {
+		str = fmt.Sprintf("%s, samplingErrorEstimate", str)
+	}
+
+	if c.DeadLetter != nil {
+		str = fmt.Sprintf("%s, deadLetter: %s", str, c.DeadLetter)
+	}
+
+	if c.Join != nil {
+		str = fmt.Sprintf("%s, join: %s", str, c.Join)
+	}
+
+	if len(c.Routing) > 0 {
+		str = fmt.Sprintf("%s, routing: %v", str, c.Routing)
+	}
+
+	if c.TablePartitions != nil {
+		str = fmt.Sprintf("%s, tablePartitions: %s per %s", str, c.TablePartitions.NameLayout, c.TablePartitions.Period)
+	}
+
 	if c.TimestampField != nil {
 		return fmt.Sprintf("%s, timestampField: %s", str, *c.TimestampField)
 	} else {
@@ -0,0 +1,77 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// JoinConfiguration describes parent/child documents stored in one table (like Elasticsearch's `join` field),
+// which makes `has_child` and `has_parent` queries work. E.g. for questions and their answers:
+//
+//	join:
+//	  relationField: "my_join_field::name"   # "question" or "answer"
+//	  parentField: "my_join_field::parent"   # in answers: id of their question
+//	  idField: "id"
+//	  relations:
+//	    question: ["answer"]
+type JoinConfiguration struct {
+	// RelationField is a column with the relation name of a document
+	RelationField string `koanf:"relationField"`
+	// ParentField is a column with the id of the parent document (set in child documents)
+	ParentField string `koanf:"parentField"`
+	// IdField is a column with the id of a document, which its children refer to in ParentField
+	IdField string `koanf:"idField"`
+	// Relations maps parent relation names to their child relation names, as in Elasticsearch's join mapping
+	Relations map[string][]string `koanf:"relations"`
+}
+
+func (c JoinConfiguration) validate(indexName string) error {
+	if c.RelationField == "" || c.ParentField == "" || c.IdField == "" {
+		return fmt.Errorf("index %s must have join relationField, parentField and idField", indexName)
+	}
+	if len(c.Relations) == 0 {
+		return fmt.Errorf("index %s must have at least one join relation", indexName)
+	}
+	parents := make(map[string]string)
+	for parent, children := range c.Relations {
+		if len(children) == 0 {
+			return fmt.Errorf("index %s has join relation %s without children", indexName, parent)
+		}
+		for _, child := range children {
+			if otherParent, exists := parents[child]; exists {
+				return fmt.Errorf("index %s has join relation %s with multiple parents: %s and %s", indexName, child, otherParent, parent)
+			}
+			parents[child] = parent
+		}
+	}
+	return nil
+}
+
+// ParentOf returns the parent relation name of `child` relation, false if it's not a child relation
+func (c JoinConfiguration) ParentOf(child string) (string, bool) {
+	for parent, children := range c.Relations {
+		if slices.Contains(children, child) {
+			return parent, true
+		}
+	}
+	return "", false
+}
+
+// ChildrenOf returns child relation names of `parent` relation, empty if it's not a parent relation
+func (c JoinConfiguration) ChildrenOf(parent string) []string {
+	return c.Relations[parent]
+}
+
+func (c JoinConfiguration) String() string {
+	relations := make([]string, 0, len(c.Relations))
+	for parent, children := range c.Relations {
+		relations = append(relations, fmt.Sprintf("%s->[%s]", parent, strings.Join(children, ", ")))
+	}
+	sort.Strings(relations)
+	return fmt.Sprintf("relationField: %s, parentField: %s, idField: %s, relations: %s",
+		c.RelationField, c.ParentField, c.IdField, strings.Join(relations, ", "))
+}
@@ -0,0 +1,69 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	PartitionPeriodDay   = "day"
+	PartitionPeriodMonth = "month"
+	PartitionPeriodYear  = "year"
+
+	defaultPartitionTimestampField = "@timestamp"
+)
+
+// TablePartitionsConfiguration makes an index logical: its data lives in multiple physical tables,
+// each one holding one time period, e.g. `events_2024_01`, `events_2024_02`, ...
+type TablePartitionsConfiguration struct {
+	// NameLayout is a Go time layout of physical table names, e.g. "events_2006_01" for monthly tables
+	NameLayout string `koanf:"nameLayout"`
+	// Period is the time period of a single table, one of "day", "month", "year"
+	Period string `koanf:"period"`
+	// Tables optionally lists physical tables explicitly. If empty, all tables matching NameLayout are used.
+	Tables []string `koanf:"tables"`
+	// TimestampField is the field we partition by, "@timestamp" if empty
+	TimestampField string `koanf:"timestampField"`
+}
+
+// PartitionRange returns time range [from, to) of data in physical table `tableName`, found = false if it's not a partition
+func (c TablePartitionsConfiguration) PartitionRange(tableName string) (from, to time.Time, found bool) {
+	if len(c.Tables) > 0 && !slices.Contains(c.Tables, tableName) {
+		return from, to, false
+	}
+	from, err := time.Parse(c.NameLayout, tableName)
+	if err != nil {
+		return from, to, false
+	}
+	switch c.Period {
+	case PartitionPeriodDay:
+		to = from.AddDate(0, 0, 1)
+	case PartitionPeriodMonth:
+		to = from.AddDate(0, 1, 0)
+	case PartitionPeriodYear:
+		to = from.AddDate(1, 0, 0)
+	default:
+		return from, to, false
+	}
+	return from, to, true
+}
+
+func (c TablePartitionsConfiguration) GetTimestampField() string {
+	if c.TimestampField == "" {
+		return defaultPartitionTimestampField
+	}
+	return c.TimestampField
+}
+
+func (c TablePartitionsConfiguration) validate(indexName string) error {
+	if c.NameLayout == "" {
+		return fmt.Errorf("index %s has table partitions without nameLayout", indexName)
+	}
+	if !slices.Contains([]string{PartitionPeriodDay, PartitionPeriodMonth, PartitionPeriodYear}, c.Period) {
+		return fmt.Errorf("index %s has table partitions with invalid period '%s'", indexName, c.Period)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"quesma/logger"
 	"quesma/network"
 	"quesma/quesma/config"
+	"quesma/quesma/gzip"
 	"quesma/quesma/mux"
 	"quesma/quesma/recovery"
 	"quesma/quesma/ui"
@@ -86,7 +87,11 @@ func newDualWriteProxy(schemaLoader clickhouse.TableDiscovery, logManager *click
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		defer recovery.LogPanic()
-		reqBody, err := peekBody(req)
+		reqBody, err := peekBody(req, config.GetMaxContentLength())
+		if errors.Is(err, gzip.ErrTooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error reading request body", http.StatusInternalServerError)
 			return
@@ -111,7 +116,7 @@ func newDualWriteProxy(schemaLoader clickhouse.TableDiscovery, logManager *click
 		indexManagement:     indexManager,
 		logManager:          logManager,
 		publicPort:          config.PublicTcpPort,
-		asyncQueriesEvictor: NewAsyncQueriesEvictor(queryRunner.AsyncRequestStorage, queryRunner.AsyncQueriesContexts),
+		asyncQueriesEvictor: NewAsyncQueriesEvictor(config.AsyncSearch.GetEvictionTime(), queryRunner.AsyncRequestStorage, queryRunner.AsyncQueriesContexts, queryRunner.PointsInTime, queryRunner.Scrolls),
 		queryRunner:         queryRunner,
 	}
 }
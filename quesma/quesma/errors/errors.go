@@ -7,6 +7,10 @@ import "errors"
 var (
 	errIndexNotExists       = errors.New("table does not exist")
 	errCouldNotParseRequest = errors.New("parse exception")
+	errPointInTimeNotFound  = errors.New("point in time not found")
+	errScrollNotFound       = errors.New("scroll not found")
+	errResultWindowTooLarge = errors.New("result window is too large")
+	errTooManyBuckets       = errors.New("too many buckets")
 )
 
 func ErrIndexNotExists() error {
@@ -16,3 +20,19 @@ func ErrIndexNotExists() error {
 func ErrCouldNotParseRequest() error {
 	return errCouldNotParseRequest
 }
+
+func ErrPointInTimeNotFound() error {
+	return errPointInTimeNotFound
+}
+
+func ErrScrollNotFound() error {
+	return errScrollNotFound
+}
+
+func ErrResultWindowTooLarge() error {
+	return errResultWindowTooLarge
+}
+
+func ErrTooManyBuckets() error {
+	return errTooManyBuckets
+}
@@ -5,7 +5,9 @@ package bulk
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"quesma/clickhouse"
+	"quesma/jsonprocessor"
 	"quesma/logger"
 	"quesma/quesma/config"
 	"quesma/quesma/recovery"
@@ -13,20 +15,145 @@ import (
 	"quesma/stats"
 	"quesma/stats/errorstats"
 	"quesma/telemetry"
+	"slices"
 )
 
 type (
 	WriteResult struct {
 		Operation string
 		Index     string
+		Id        string      // `_id` of the document, "" if it wasn't given
+		Error     *WriteError // nil <=> operation was accepted
+		NotFound  bool        // true <=> delete of a document, which isn't stored
+	}
+	// WriteError is returned for a single operation, which was rejected. It's reported back in the _bulk response.
+	WriteError struct {
+		Status int
+		Type   string
+		Reason string
+	}
+	// WriteParams are parameters of the whole _bulk request, passed in the URL
+	WriteParams struct {
+		RequireAlias bool   // if true, every target of the request must be an index alias
+		OpType       string // "index" or "create". We're append-only, so it's parsed, but doesn't change anything
 	}
 )
 
-func Write(ctx context.Context, defaultIndex *string, bulk types.NDJSON, lm *clickhouse.LogManager,
+// writeBatch are deletes of documents (by their `_id`) followed by inserts. Operations of a single index are written
+// in batches in their _bulk order, so e.g. a document can be deleted and then indexed again.
+// Updated documents are read before the deletes, and inserted along with the other documents.
+type writeBatch struct {
+	idsToDelete       []string
+	deletes           []documentDelete
+	updates           []documentUpdate
+	documentsToInsert []types.JSON
+}
+
+// documentDelete is `delete` operation of document `id`
+type documentDelete struct {
+	id          string
+	resultIndex int // index of the operation in results
+}
+
+// documentUpdate is `update` operation of document `id`: `doc` is merged into the stored document.
+// If there's no stored document, `upsert` is inserted instead, or we report the document missing if there's no `upsert`.
+type documentUpdate struct {
+	id          string
+	doc         types.JSON
+	upsert      types.JSON // nil <=> no upsert
+	resultIndex int        // index of the operation in results
+}
+
+// parseUpdate returns the update of document `id`, if `update` has `doc`. Scripts aren't supported.
+func parseUpdate(id string, update types.JSON) (documentUpdate, bool) {
+	doc, hasDoc := update["doc"].(map[string]any)
+	if !hasDoc {
+		return documentUpdate{}, false
+	}
+	result := documentUpdate{id: id, doc: doc}
+	if upsert, ok := update["upsert"].(map[string]any); ok {
+		result.upsert = upsert
+	} else if docAsUpsert, _ := update["doc_as_upsert"].(bool); docAsUpsert {
+		result.upsert = doc
+	}
+	return result, true
+}
+
+// mergeDocument merges `doc` into `document`, like Elasticsearch does in partial updates: objects are merged
+// recursively, any other value replaces the stored one.
+func mergeDocument(document, doc map[string]any) {
+	for key, value := range doc {
+		if object, isObject := value.(map[string]any); isObject {
+			if storedObject, isStoredObject := document[key].(map[string]any); isStoredObject {
+				mergeDocument(storedObject, object)
+				continue
+			}
+		}
+		document[key] = value
+	}
+}
+
+func ParseWriteParams(params url.Values) WriteParams {
+	return WriteParams{
+		RequireAlias: params.Get("require_alias") == "true",
+		OpType:       params.Get("op_type"),
+	}
+}
+
+// resolveTargetIndex returns name of the index we write to. If `requireAlias` is true, `target` must be
+// one of configured index aliases, otherwise we return an error, just like Elasticsearch does.
+func resolveTargetIndex(cfg config.QuesmaConfiguration, target string, requireAlias bool) (string, *WriteError) {
+	if indexName, isAlias := cfg.ResolveIndexAlias(target); isAlias {
+		return indexName, nil
+	}
+	if requireAlias {
+		return target, &WriteError{
+			Status: 404,
+			Type:   "aliases_not_found_exception",
+			Reason: fmt.Sprintf("[require_alias] request flag is [true] and [%s] is not an alias", target),
+		}
+	}
+	return target, nil
+}
+
+func Write(ctx context.Context, defaultIndex *string, bulk types.NDJSON, params WriteParams, lm *clickhouse.LogManager,
 	cfg config.QuesmaConfiguration, phoneHomeAgent telemetry.PhoneHomeAgent) (results []WriteResult) {
 	defer recovery.LogPanic()
 
-	indicesWithDocumentsToInsert := make(map[string][]types.JSON, len(bulk))
+	batchesPerIndex := make(map[string][]writeBatch, len(bulk))
+	addToBatch := func(index, idToDelete string, documentToInsert types.JSON, update *documentUpdate, deletion *documentDelete) {
+		batches := batchesPerIndex[index]
+		if len(batches) == 0 || (idToDelete != "" && batches[len(batches)-1].mayInsert(idToDelete)) {
+			batches = append(batches, writeBatch{})
+		}
+		last := &batches[len(batches)-1]
+		if idToDelete != "" {
+			last.idsToDelete = append(last.idsToDelete, idToDelete)
+		}
+		if documentToInsert != nil {
+			last.documentsToInsert = append(last.documentsToInsert, documentToInsert)
+		}
+		if update != nil {
+			last.updates = append(last.updates, *update)
+		}
+		if deletion != nil {
+			last.deletes = append(last.deletes, *deletion)
+		}
+		batchesPerIndex[index] = batches
+	}
+
+	geoPointsPerIndex := make(map[string]*jsonprocessor.RewriteGeoPoints)
+	geoPointsOf := func(index string) *jsonprocessor.RewriteGeoPoints {
+		if _, found := geoPointsPerIndex[index]; !found {
+			geoPointsPerIndex[index] = &jsonprocessor.RewriteGeoPoints{Fields: cfg.IndexConfig[index].GeoPointFields()}
+		}
+		return geoPointsPerIndex[index]
+	}
+
+	if params.OpType != "" {
+		// ClickHouse tables are append-only, so `op_type=create` (fail if document exists) can't be enforced
+		logger.DebugWithCtx(ctx).Msgf("op_type=%s in _bulk is ignored, documents are always appended", params.OpType)
+	}
 
 	err := bulk.BulkForEach(func(op types.BulkOperation, document types.JSON) {
 
@@ -43,6 +170,17 @@ func Write(ctx context.Context, defaultIndex *string, bulk types.NDJSON, lm *cli
 			}
 		}
 
+		requireAlias := params.RequireAlias
+		if opRequireAlias, isSet := op.GetRequireAlias(); isSet {
+			requireAlias = opRequireAlias
+		}
+		index, writeErr := resolveTargetIndex(cfg, index, requireAlias)
+		if writeErr != nil {
+			logger.WarnWithCtx(ctx).Msgf("rejecting '%s' operation in _bulk: %s", operation, writeErr.Reason)
+			results = append(results, WriteResult{Operation: operation, Index: index, Error: writeErr})
+			return
+		}
+
 		indexConfig, found := cfg.IndexConfig[index]
 		if !found {
 			logger.Debug().Msgf("index '%s' is not configured, skipping", index)
@@ -53,20 +191,49 @@ func Write(ctx context.Context, defaultIndex *string, bulk types.NDJSON, lm *cli
 			return
 		}
 
+		id := op.GetId()
 		switch operation {
 		case "create", "index":
-			results = append(results, WriteResult{operation, index})
-			indicesWithDocumentsToInsert[index] = append(indicesWithDocumentsToInsert[index], document)
-		case "update":
-
-			errorstats.GlobalErrorStatistics.RecordKnownError("_bulk update is not supported", nil,
-				"We do not support 'update' in _bulk")
-			logger.Debug().Msg("Not supporting 'update' _bulk.")
-
-		case "delete":
-			errorstats.GlobalErrorStatistics.RecordKnownError("_bulk delete is not supported", nil,
-				"We do not support 'delete' in _bulk")
-			logger.Debug().Msg("Not supporting 'delete' _bulk.")
+			if indexConfig.IdField != "" && id != "" {
+				if _, exists := document[indexConfig.IdField]; !exists {
+					document[indexConfig.IdField] = id
+				}
+			}
+			if err := geoPointsOf(index).Validate(document); err != nil {
+				logger.WarnWithCtx(ctx).Msgf("rejecting '%s' operation in _bulk: %v", operation, err)
+				results = append(results, WriteResult{Operation: operation, Index: index, Id: id, Error: mapperParsingError(err)})
+				return
+			}
+			results = append(results, WriteResult{Operation: operation, Index: index, Id: id})
+			addToBatch(index, "", document, nil, nil)
+		case "update", "delete":
+			if writeErr := validateDeleteOrUpdate(index, id, indexConfig); writeErr != nil {
+				logger.WarnWithCtx(ctx).Msgf("rejecting '%s' operation in _bulk: %s", operation, writeErr.Reason)
+				results = append(results, WriteResult{Operation: operation, Index: index, Id: id, Error: writeErr})
+				return
+			}
+			var update *documentUpdate
+			var deletion *documentDelete
+			if operation == "delete" {
+				deletion = &documentDelete{id: id, resultIndex: len(results)}
+			} else {
+				parsed, ok := parseUpdate(id, document)
+				if !ok {
+					errorstats.GlobalErrorStatistics.RecordKnownError("_bulk update without doc is not supported", nil,
+						"We support only 'doc' (and 'upsert') in _bulk update, not scripts")
+					writeErr := &WriteError{Status: 400, Type: "action_request_validation_exception",
+						Reason: "Validation Failed: 1: only update with doc is supported;"}
+					results = append(results, WriteResult{Operation: operation, Index: index, Id: id, Error: writeErr})
+					return
+				}
+				if parsed.upsert != nil {
+					parsed.upsert[indexConfig.IdField] = id
+				}
+				parsed.resultIndex = len(results)
+				update = &parsed
+			}
+			results = append(results, WriteResult{Operation: operation, Index: index, Id: id})
+			addToBatch(index, id, nil, update, deletion)
 
 		default:
 			errorstats.GlobalErrorStatistics.RecordUnknownError(nil,
@@ -81,15 +248,126 @@ func Write(ctx context.Context, defaultIndex *string, bulk types.NDJSON, lm *cli
 		return
 	}
 
-	for indexName, documents := range indicesWithDocumentsToInsert {
-		phoneHomeAgent.IngestCounters().Add(indexName, int64(len(documents)))
-
+	for indexName, batches := range batchesPerIndex {
+		idField := cfg.IndexConfig[indexName].IdField
 		config.RunConfigured(ctx, cfg, indexName, make(types.JSON), func() error {
-			for _, document := range documents {
-				stats.GlobalStatistics.Process(cfg, indexName, document, clickhouse.NestedSeparator)
+			for _, batch := range batches {
+				if len(batch.updates) > 0 {
+					updatedDocuments, rejectedIds, err := applyUpdates(ctx, lm, indexName, idField, geoPointsOf(indexName), batch.updates, results)
+					if err != nil {
+						return err
+					}
+					// rejected updates leave their stored documents as they are
+					for _, id := range rejectedIds {
+						if i := slices.Index(batch.idsToDelete, id); i >= 0 {
+							batch.idsToDelete = slices.Delete(batch.idsToDelete, i, i+1)
+						}
+					}
+					batch.documentsToInsert = append(batch.documentsToInsert, updatedDocuments...)
+				}
+				if len(batch.deletes) > 0 {
+					if err := findDeletedDocuments(ctx, lm, indexName, idField, batch.deletes, results); err != nil {
+						return err
+					}
+				}
+				if len(batch.idsToDelete) > 0 {
+					if err := lm.DeleteDocuments(ctx, indexName, idField, batch.idsToDelete); err != nil {
+						return err
+					}
+				}
+				if len(batch.documentsToInsert) > 0 {
+					phoneHomeAgent.IngestCounters().Add(indexName, int64(len(batch.documentsToInsert)))
+					for _, document := range batch.documentsToInsert {
+						stats.GlobalStatistics.Process(cfg, indexName, document, clickhouse.NestedSeparator)
+					}
+					if err := lm.ProcessInsertQuery(ctx, indexName, batch.documentsToInsert); err != nil {
+						return err
+					}
+				}
 			}
-			return lm.ProcessInsertQuery(ctx, indexName, documents)
+			return nil
 		})
 	}
 	return results
 }
+
+// mayInsert returns true, if the batch may insert document `id`, so deleting it has to wait for the next batch
+func (batch writeBatch) mayInsert(id string) bool {
+	if len(batch.documentsToInsert) > 0 {
+		return true
+	}
+	return slices.ContainsFunc(batch.updates, func(update documentUpdate) bool { return update.id == id })
+}
+
+// applyUpdates returns updated documents, which replace the stored ones. Updates of missing documents
+// without upsert, and of documents which can't be ingested after the update, are reported in their `results`
+// and returned in `rejectedIds`, so their stored documents (if any) aren't deleted.
+func applyUpdates(ctx context.Context, lm *clickhouse.LogManager, indexName, idField string, geoPoints *jsonprocessor.RewriteGeoPoints,
+	updates []documentUpdate, results []WriteResult) (documents []types.JSON, rejectedIds []string, err error) {
+
+	ids := make([]string, 0, len(updates))
+	for _, update := range updates {
+		ids = append(ids, update.id)
+	}
+	storedDocuments, err := lm.LoadDocuments(ctx, indexName, idField, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	documents = make([]types.JSON, 0, len(updates))
+	for _, update := range updates {
+		var document types.JSON
+		if stored, found := storedDocuments[update.id]; found {
+			mergeDocument(stored, update.doc)
+			document = stored
+		} else if update.upsert != nil {
+			document = update.upsert
+		} else {
+			results[update.resultIndex].Error = &WriteError{Status: 404, Type: "document_missing_exception",
+				Reason: fmt.Sprintf("[%s]: document missing", update.id)}
+			rejectedIds = append(rejectedIds, update.id)
+			continue
+		}
+		if err := geoPoints.Validate(document); err != nil {
+			results[update.resultIndex].Error = mapperParsingError(err)
+			rejectedIds = append(rejectedIds, update.id)
+			continue
+		}
+		documents = append(documents, document)
+	}
+	return documents, rejectedIds, nil
+}
+
+// findDeletedDocuments reports deletes of documents, which aren't stored, as not found in their `results`
+func findDeletedDocuments(ctx context.Context, lm *clickhouse.LogManager, indexName, idField string, deletes []documentDelete, results []WriteResult) error {
+	ids := make([]string, 0, len(deletes))
+	for _, deletion := range deletes {
+		ids = append(ids, deletion.id)
+	}
+	storedIds, err := lm.FindDocumentIds(ctx, indexName, idField, ids)
+	if err != nil {
+		return err
+	}
+	for _, deletion := range deletes {
+		if !storedIds[deletion.id] {
+			results[deletion.resultIndex].NotFound = true
+		}
+	}
+	return nil
+}
+
+// mapperParsingError is returned for a document, which can't be ingested, e.g. with an invalid geo_point
+func mapperParsingError(err error) *WriteError {
+	return &WriteError{Status: 400, Type: "mapper_parsing_exception", Reason: err.Error()}
+}
+
+// validateDeleteOrUpdate returns an error, if a document of `index` can't be deleted or updated (by its `id`)
+func validateDeleteOrUpdate(index, id string, indexConfig config.IndexConfiguration) *WriteError {
+	if id == "" {
+		return &WriteError{Status: 400, Type: "action_request_validation_exception", Reason: "Validation Failed: 1: id is missing;"}
+	}
+	if indexConfig.IdField == "" {
+		return &WriteError{Status: 400, Type: "illegal_argument_exception",
+			Reason: fmt.Sprintf("index [%s] has no idField configured, so its documents can't be found by _id", index)}
+	}
+	return nil
+}
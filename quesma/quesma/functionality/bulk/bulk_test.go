@@ -0,0 +1,265 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package bulk
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"quesma/clickhouse"
+	"quesma/concurrent"
+	"quesma/quesma/config"
+	"quesma/quesma/types"
+	"quesma/telemetry"
+	"quesma/util"
+	"testing"
+)
+
+func TestParseWriteParams(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected WriteParams
+	}{
+		{"", WriteParams{}},
+		{"require_alias=true", WriteParams{RequireAlias: true}},
+		{"require_alias=false", WriteParams{RequireAlias: false}},
+		{"op_type=create", WriteParams{OpType: "create"}},
+		{"require_alias=true&op_type=index", WriteParams{RequireAlias: true, OpType: "index"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, ParseWriteParams(values))
+		})
+	}
+}
+
+func TestResolveTargetIndex(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"logs": {Name: "logs", Enabled: true, IndexAliases: []string{"logs-alias"}},
+	}}
+
+	index, err := resolveTargetIndex(cfg, "logs-alias", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "logs", index)
+
+	index, err = resolveTargetIndex(cfg, "logs-alias", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "logs", index)
+
+	index, err = resolveTargetIndex(cfg, "logs", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "logs", index)
+
+	_, err = resolveTargetIndex(cfg, "logs", true)
+	assert.NotNil(t, err)
+	assert.Equal(t, 404, err.Status)
+	assert.Equal(t, "aliases_not_found_exception", err.Type)
+	assert.Equal(t, "[require_alias] request flag is [true] and [logs] is not an alias", err.Reason)
+}
+
+func TestWriteRequireAlias(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"logs": {Name: "logs", Enabled: false, IndexAliases: []string{"logs-alias"}},
+	}}
+	bulk, err := types.ParseNDJSON(`{"index":{"_index":"logs"}}
+{"message":"rejected"}
+{"create":{"_index":"logs","require_alias":false}}
+{"message":"accepted, but index is disabled"}
+{"index":{"_index":"logs-alias"}}
+{"message":"accepted, but index is disabled"}
+`)
+	assert.NoError(t, err)
+
+	results := Write(context.Background(), nil, bulk, WriteParams{RequireAlias: true}, nil, cfg, nil)
+
+	assert.Len(t, results, 1)
+	assert.Equal(t, "index", results[0].Operation)
+	assert.Equal(t, "logs", results[0].Index)
+	assert.NotNil(t, results[0].Error)
+	assert.Equal(t, "aliases_not_found_exception", results[0].Error.Type)
+}
+
+func TestWriteOpTypeIsIgnored(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{}}
+	bulk, err := types.ParseNDJSON(`{"index":{"_index":"not-configured"}}
+{"message":"skipped"}
+`)
+	assert.NoError(t, err)
+
+	results := Write(context.Background(), nil, bulk, WriteParams{OpType: "create"}, nil, cfg, nil)
+	assert.Empty(t, results)
+}
+
+func TestWriteIndexAndDelete(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"logs":       {Name: "logs", Enabled: true, IdField: "doc_id"},
+		"no-id-logs": {Name: "no-id-logs", Enabled: true},
+	}}
+	table := &clickhouse.Table{
+		Name:   "logs",
+		Config: clickhouse.NewNoTimestampOnlyStringAttrCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"doc_id":     {Name: "doc_id", Type: clickhouse.NewBaseType("String")},
+			"message":    {Name: "message", Type: clickhouse.NewBaseType("String")},
+			"host::name": {Name: "host::name", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	bulk, err := types.ParseNDJSON(`{"index":{"_index":"logs","_id":"1"}}
+{"message":"first"}
+{"create":{"_index":"logs","_id":"2"}}
+{"message":"second"}
+{"delete":{"_index":"logs","_id":"1"}}
+{"delete":{"_index":"logs","_id":"5"}}
+{"update":{"_index":"logs","_id":"2"}}
+{"doc":{"message":"second, updated"}}
+{"update":{"_index":"logs","_id":"3"}}
+{"doc":{"message":"third"},"upsert":{"message":"third, upserted"}}
+{"update":{"_index":"logs","_id":"4"}}
+{"doc":{"message":"fourth"}}
+{"delete":{"_index":"no-id-logs","_id":"3"}}
+{"delete":{"_index":"logs"}}
+`)
+	assert.NoError(t, err)
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith("logs", table))
+	mock.ExpectExec(`INSERT INTO "logs" FORMAT JSONEachRow {"doc_id":"1","message":"first"}, {"doc_id":"2","message":"second"}`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT "doc_id", "host::name", "message" FROM "logs" WHERE "doc_id" IN \('2', '3', '4'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"doc_id", "host::name", "message"}).AddRow("2", "stored host", "second"))
+	mock.ExpectQuery(`SELECT "doc_id" FROM "logs" WHERE "doc_id" IN \('1', '5'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"doc_id"}).AddRow("1"))
+	// missing document 4 isn't updated, so it isn't deleted either
+	mock.ExpectExec(`DELETE FROM "logs" WHERE "doc_id" IN \('1', '5', '2', '3'\)`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO "logs" FORMAT JSONEachRow {"doc_id":"2","host::name":"stored host","message":"second, updated"}, {"doc_id":"3","message":"third, upserted"}`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	results := Write(context.Background(), nil, bulk, WriteParams{}, lm, cfg, telemetry.NewPhoneHomeEmptyAgent())
+
+	if assert.Len(t, results, 9) {
+		for i, expected := range []WriteResult{
+			{Operation: "index", Index: "logs", Id: "1"},
+			{Operation: "create", Index: "logs", Id: "2"},
+			{Operation: "delete", Index: "logs", Id: "1"},
+			{Operation: "delete", Index: "logs", Id: "5", NotFound: true},
+			{Operation: "update", Index: "logs", Id: "2"},
+			{Operation: "update", Index: "logs", Id: "3"},
+		} {
+			assert.Equal(t, expected, results[i])
+		}
+		if assert.NotNil(t, results[6].Error) {
+			assert.Equal(t, "document_missing_exception", results[6].Error.Type)
+			assert.Equal(t, 404, results[6].Error.Status)
+		}
+		assert.Equal(t, "delete", results[7].Operation)
+		if assert.NotNil(t, results[7].Error) {
+			assert.Equal(t, "illegal_argument_exception", results[7].Error.Type)
+		}
+		if assert.NotNil(t, results[8].Error) {
+			assert.Equal(t, "action_request_validation_exception", results[8].Error.Type)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestWriteUpdateWithNumericId(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"events": {Name: "events", Enabled: true, IdField: "event_id"},
+	}}
+	table := &clickhouse.Table{
+		Name:   "events",
+		Config: clickhouse.NewNoTimestampOnlyStringAttrCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"event_id": {Name: "event_id", Type: clickhouse.NewBaseType("Int64")},
+			"message":  {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	bulk, err := types.ParseNDJSON(`{"update":{"_index":"events","_id":"7"}}
+{"doc":{"message":"updated"}}
+`)
+	assert.NoError(t, err)
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith("events", table))
+	mock.ExpectQuery(`SELECT "event_id", "message" FROM "events" WHERE "event_id" IN \('7'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"event_id", "message"}).AddRow(int64(7), "stored"))
+	mock.ExpectExec(`DELETE FROM "events" WHERE "event_id" IN \('7'\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "events" FORMAT JSONEachRow {"event_id":7,"message":"updated"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	results := Write(context.Background(), nil, bulk, WriteParams{}, lm, cfg, telemetry.NewPhoneHomeEmptyAgent())
+
+	assert.Equal(t, []WriteResult{{Operation: "update", Index: "events", Id: "7"}}, results)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestWriteRejectsInvalidGeoPoint(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"places": {Name: "places", Enabled: true, IdField: "doc_id", TypeMappings: map[string]string{"location": "geo_point"}},
+	}}
+	table := &clickhouse.Table{
+		Name:   "places",
+		Config: clickhouse.NewNoTimestampOnlyStringAttrCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"doc_id":        {Name: "doc_id", Type: clickhouse.NewBaseType("String")},
+			"location::lat": {Name: "location::lat", Type: clickhouse.NewBaseType("Float64")},
+			"location::lon": {Name: "location::lon", Type: clickhouse.NewBaseType("Float64")},
+		},
+		Created: true,
+	}
+	bulk, err := types.ParseNDJSON(`{"index":{"_index":"places","_id":"1"}}
+{}
+{"index":{"_index":"places","_id":"2"}}
+{"location":"not-a-geohash!"}
+{"update":{"_index":"places","_id":"3"}}
+{"doc":{"location":"91,0"}}
+`)
+	assert.NoError(t, err)
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith("places", table))
+	mock.ExpectExec(`INSERT INTO "places" FORMAT JSONEachRow {"doc_id":"1"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT "doc_id", "location::lat", "location::lon" FROM "places" WHERE "doc_id" IN \('3'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"doc_id", "location::lat", "location::lon"}).AddRow("3", 10.0, 20.0))
+	// the stored document 3 isn't deleted, as its update is rejected
+
+	results := Write(context.Background(), nil, bulk, WriteParams{}, lm, cfg, telemetry.NewPhoneHomeEmptyAgent())
+
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, WriteResult{Operation: "index", Index: "places", Id: "1"}, results[0])
+		for _, result := range results[1:] {
+			if assert.NotNil(t, result.Error, result.Id) {
+				assert.Equal(t, 400, result.Error.Status)
+				assert.Equal(t, "mapper_parsing_exception", result.Error.Type)
+				assert.Contains(t, result.Error.Reason, "failed to parse field [location] of type [geo_point]")
+			}
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestMergeDocument(t *testing.T) {
+	document := types.JSON{"message": "stored", "host": map[string]any{"name": "a", "ip": "127.0.0.1"}, "level": "info"}
+	mergeDocument(document, types.JSON{"message": "updated", "host": map[string]any{"name": "b"}, "tags": []any{"x"}})
+	assert.Equal(t, types.JSON{
+		"message": "updated",
+		"host":    map[string]any{"name": "b", "ip": "127.0.0.1"},
+		"level":   "info",
+		"tags":    []any{"x"},
+	}, document)
+}
@@ -0,0 +1,76 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package cluster_health
+
+import (
+	"context"
+	"encoding/json"
+	"quesma/clickhouse"
+	"quesma/end_user_errors"
+	"quesma/health"
+	"quesma/quesma/config"
+	"time"
+)
+
+// clickhousePingTimeout bounds the ClickHouse ping, so that a hanging connection makes the status red instead of blocking
+const clickhousePingTimeout = 5 * time.Second
+
+const (
+	statusGreen  = "green"
+	statusYellow = "yellow"
+	statusRed    = "red"
+)
+
+type clusterHealthResponse struct {
+	ClusterName       string                   `json:"cluster_name"`
+	Status            string                   `json:"status"`
+	TimedOut          bool                     `json:"timed_out"`
+	NumberOfNodes     int                      `json:"number_of_nodes"`
+	NumberOfDataNodes int                      `json:"number_of_data_nodes"`
+	Backends          map[string]backendHealth `json:"quesma_backends"`
+}
+
+type backendHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Tables  *int   `json:"discovered_tables,omitempty"` // only for ClickHouse
+}
+
+// HandleClusterHealth handles _cluster/health request: it pings ClickHouse and (if we read from or write to it)
+// Elasticsearch with `elasticChecker`. The status is red, if ClickHouse is down (or Elasticsearch, when it's
+// the only backend), and yellow if just Elasticsearch is down, as searches of ClickHouse tables still work then.
+func HandleClusterHealth(ctx context.Context, cfg config.QuesmaConfiguration, lm *clickhouse.LogManager, elasticChecker health.Checker) ([]byte, error) {
+	response := clusterHealthResponse{ClusterName: "quesma", Status: statusGreen, NumberOfNodes: 1, NumberOfDataNodes: 1,
+		Backends: make(map[string]backendHealth)}
+
+	usesClickhouse := cfg.WritesToClickhouse() || cfg.ReadsFromClickhouse()
+	if usesClickhouse {
+		clickhouseHealth := backendHealth{Status: statusGreen}
+		pingCtx, cancel := context.WithTimeout(ctx, clickhousePingTimeout)
+		err := lm.PingContext(pingCtx)
+		cancel()
+		if err != nil {
+			clickhouseHealth = backendHealth{Status: statusRed, Message: end_user_errors.GuessClickhouseErrorType(err).Reason()}
+			response.Status = statusRed
+		}
+		tables, _ := lm.GetTableDefinitions()
+		tablesCount := tables.Size()
+		clickhouseHealth.Tables = &tablesCount
+		response.Backends["clickhouse"] = clickhouseHealth
+	}
+
+	if elasticChecker != nil && (cfg.WritesToElasticsearch() || cfg.ReadsFromElasticsearch()) {
+		elasticHealth := backendHealth{Status: statusGreen}
+		if status := elasticChecker.CheckHealth(); status.Status != statusGreen {
+			elasticHealth = backendHealth{Status: statusRed, Message: status.Message}
+			if !usesClickhouse {
+				response.Status = statusRed
+			} else if response.Status == statusGreen {
+				response.Status = statusYellow
+			}
+		}
+		response.Backends["elasticsearch"] = elasticHealth
+	}
+
+	return json.Marshal(response)
+}
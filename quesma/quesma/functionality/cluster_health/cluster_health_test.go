@@ -0,0 +1,91 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package cluster_health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/concurrent"
+	"quesma/health"
+	"quesma/quesma/config"
+	"testing"
+	"time"
+)
+
+type staticChecker struct {
+	status health.Status
+}
+
+func (c staticChecker) CheckHealth() health.Status {
+	return c.status
+}
+
+func TestHandleClusterHealth(t *testing.T) {
+	elasticUp := staticChecker{health.NewStatus("green", "Healthy", "")}
+	elasticDown := staticChecker{health.NewStatus("red", "Ping failed", "connection refused")}
+	clickhouseOnly := config.QuesmaConfiguration{Mode: config.ClickHouse}
+	dualWrite := config.QuesmaConfiguration{Mode: config.DualWriteQueryClickhouse}
+
+	tests := []struct {
+		name              string
+		cfg               config.QuesmaConfiguration
+		clickhouseDown    bool
+		clickhouseHangs   bool
+		elasticChecker    health.Checker
+		wantStatus        string
+		wantClickhouse    string
+		wantElasticsearch string // "" <=> not checked
+	}{
+		{name: "clickhouse up", cfg: clickhouseOnly, elasticChecker: elasticUp, wantStatus: "green", wantClickhouse: "green"},
+		{name: "clickhouse down", cfg: clickhouseOnly, clickhouseDown: true, elasticChecker: elasticUp, wantStatus: "red", wantClickhouse: "red"},
+		{name: "clickhouse hangs", cfg: clickhouseOnly, clickhouseDown: true, clickhouseHangs: true, elasticChecker: elasticUp, wantStatus: "red", wantClickhouse: "red"},
+		{name: "both up", cfg: dualWrite, elasticChecker: elasticUp, wantStatus: "green", wantClickhouse: "green", wantElasticsearch: "green"},
+		{name: "elasticsearch down", cfg: dualWrite, elasticChecker: elasticDown, wantStatus: "yellow", wantClickhouse: "green", wantElasticsearch: "red"},
+		{name: "both down", cfg: dualWrite, clickhouseDown: true, elasticChecker: elasticDown, wantStatus: "red", wantClickhouse: "red", wantElasticsearch: "red"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			assert.NoError(t, err)
+			defer db.Close()
+			ctx := context.Background()
+			if tt.clickhouseHangs {
+				mock.ExpectPing().WillDelayFor(time.Minute)
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+				defer cancel()
+			} else if tt.clickhouseDown {
+				mock.ExpectPing().WillReturnError(errors.New("dial tcp: connection refused"))
+			} else {
+				mock.ExpectPing()
+			}
+			table := &clickhouse.Table{Name: "logs", Config: clickhouse.NewDefaultCHConfig(), Created: true}
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith("logs", table))
+
+			responseBody, err := HandleClusterHealth(ctx, tt.cfg, lm, tt.elasticChecker)
+			assert.NoError(t, err)
+			var response clusterHealthResponse
+			assert.NoError(t, json.Unmarshal(responseBody, &response))
+
+			assert.Equal(t, "quesma", response.ClusterName)
+			assert.Equal(t, tt.wantStatus, response.Status)
+			assert.Equal(t, tt.wantClickhouse, response.Backends["clickhouse"].Status)
+			if assert.NotNil(t, response.Backends["clickhouse"].Tables) {
+				assert.Equal(t, 1, *response.Backends["clickhouse"].Tables)
+			}
+			if tt.clickhouseDown {
+				assert.NotEmpty(t, response.Backends["clickhouse"].Message)
+			}
+			elasticsearch, checked := response.Backends["elasticsearch"]
+			assert.Equal(t, tt.wantElasticsearch != "", checked)
+			assert.Equal(t, tt.wantElasticsearch, elasticsearch.Status)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
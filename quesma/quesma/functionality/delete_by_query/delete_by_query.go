@@ -0,0 +1,184 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package delete_by_query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"quesma/clickhouse"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/plugins"
+	"quesma/plugins/registry"
+	"quesma/queryparser"
+	"quesma/quesma/config"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"quesma/schema"
+	"quesma/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AllowFullDeleteParam is the URL param, which must be "true" to delete by a query matching all documents
+const AllowFullDeleteParam = "allow_full_delete"
+
+type deleteByQueryResponse struct {
+	Took                 int64   `json:"took"`
+	TimedOut             bool    `json:"timed_out"`
+	Total                int64   `json:"total"`
+	Deleted              int64   `json:"deleted"`
+	Batches              int     `json:"batches"`
+	VersionConflicts     int     `json:"version_conflicts"`
+	Noops                int     `json:"noops"`
+	ThrottledMillis      int     `json:"throttled_millis"`
+	RequestsPerSecond    float64 `json:"requests_per_second"`
+	ThrottledUntilMillis int     `json:"throttled_until_millis"`
+	Failures             []any   `json:"failures"`
+}
+
+// tableDelete is a delete from a single table matching the index pattern
+type tableDelete struct {
+	table       *clickhouse.Table
+	countQuery  *model.Query // of the documents to delete
+	whereClause model.Expr
+}
+
+// HandleDeleteByQuery handles _delete_by_query request: deletes documents matching its `query` from every table
+// (of the indexes enabled in the config) matching `indexPattern`. A query matching all documents (missing, or e.g. match_all)
+// is rejected, unless `allowFullDelete` is true, so a whole table isn't deleted by accident.
+// The query is transformed by `transformer` (same as searches, e.g. with baseline filters), and then plugins.
+func HandleDeleteByQuery(ctx context.Context, indexPattern string, body types.JSON, allowFullDelete bool, cfg config.QuesmaConfiguration,
+	schemaRegistry schema.Registry, lm *clickhouse.LogManager, transformer plugins.QueryTransformer) ([]byte, error) {
+	startTime := time.Now()
+	query := queryparser.QueryMap{"match_all": queryparser.QueryMap{}}
+	if queryRaw, exists := body["query"]; exists {
+		var ok bool
+		if query, ok = queryRaw.(map[string]any); !ok {
+			return nil, badRequest(fmt.Errorf("query must be an object, got: %v", queryRaw))
+		}
+	}
+
+	tables, err := lm.ResolveIndexes(ctx, indexPattern)
+	if err != nil {
+		return nil, err
+	}
+	// all queries are translated first, so nothing is deleted, if any of them can't be
+	var deletes []tableDelete
+	for _, tableName := range tables {
+		if indexConfig, exists := cfg.IndexConfig[tableName]; !exists || !indexConfig.Enabled {
+			continue
+		}
+		table := lm.FindTable(tableName)
+		if table == nil {
+			continue
+		}
+		translator := &queryparser.ClickhouseQueryTranslator{ClickhouseLM: lm, Table: table, Ctx: ctx, SchemaRegistry: schemaRegistry}
+		filter := translator.ParseFilter(query)
+		if !filter.CanParse {
+			return nil, badRequest(fmt.Errorf("can't parse query: %v", query))
+		}
+		if isTriviallyTrue(filter.WhereClause) && !allowFullDelete {
+			return nil, badRequest(fmt.Errorf("query matches all documents of %s, set %s=true to delete them all", tableName, AllowFullDeleteParam))
+		}
+		// like queries of _search, e.g. plugins may rewrite field names
+		countQuery := translator.BuildCountQuery(filter.WhereClause, 0)
+		transformed, err := transformer.Transform([]*model.Query{countQuery})
+		if err != nil {
+			return nil, err
+		}
+		if transformed, err = registry.QueryTransformerFor(tableName, cfg).Transform(transformed); err != nil {
+			return nil, err
+		}
+		deletes = append(deletes, tableDelete{table: table, countQuery: transformed[0], whereClause: transformed[0].SelectCommand.WhereClause})
+	}
+	if len(deletes) == 0 {
+		return nil, quesma_errors.ErrIndexNotExists()
+	}
+
+	var deleted int64
+	for _, tableDelete := range deletes {
+		matching, err := countMatching(ctx, lm, tableDelete)
+		if err != nil {
+			return nil, err
+		}
+		if matching == 0 {
+			continue
+		}
+		logger.InfoWithCtx(ctx).Msgf("deleting %d documents of %s by query", matching, tableDelete.table.Name)
+		if err = lm.DeleteWhere(ctx, tableDelete.table.Name, tableDelete.whereClause); err != nil {
+			return nil, err
+		}
+		deleted += matching
+	}
+
+	return json.Marshal(deleteByQueryResponse{
+		Took:              time.Since(startTime).Milliseconds(),
+		Total:             deleted,
+		Deleted:           deleted,
+		Batches:           1,
+		RequestsPerSecond: -1,
+		Failures:          []any{},
+	})
+}
+
+// isTriviallyTrue returns true <=> `whereClause` matches all documents regardless of their values,
+// e.g. it's nil (no query, match_all, empty bool) or `true`, `NOT false`, `true AND true`
+func isTriviallyTrue(whereClause model.Expr) bool {
+	switch expr := whereClause.(type) {
+	case nil:
+		return true
+	case model.LiteralExpr:
+		return isBoolLiteral(expr, true)
+	case model.StringExpr:
+		return strings.EqualFold(expr.Value, "true")
+	case model.ParenExpr:
+		return len(expr.Exprs) == 1 && isTriviallyTrue(expr.Exprs[0])
+	case model.PrefixExpr:
+		if strings.EqualFold(expr.Op, "NOT") && len(expr.Args) == 1 {
+			literal, ok := expr.Args[0].(model.LiteralExpr)
+			return ok && isBoolLiteral(literal, false)
+		}
+	case model.InfixExpr:
+		switch strings.ToUpper(expr.Op) {
+		case "AND":
+			return isTriviallyTrue(expr.Left) && isTriviallyTrue(expr.Right)
+		case "OR":
+			return isTriviallyTrue(expr.Left) || isTriviallyTrue(expr.Right)
+		}
+	}
+	return false
+}
+
+func isBoolLiteral(literal model.LiteralExpr, value bool) bool {
+	switch v := literal.Value.(type) {
+	case bool:
+		return v == value
+	case string:
+		return strings.EqualFold(v, strconv.FormatBool(value))
+	}
+	return false
+}
+
+// countMatching returns the number of documents, which the delete will delete
+func countMatching(ctx context.Context, lm *clickhouse.LogManager, tableDelete tableDelete) (int64, error) {
+	rows, err := lm.ProcessQuery(ctx, tableDelete.table, tableDelete.countQuery)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 || len(rows[0].Cols) == 0 {
+		return 0, errors.New("count query returned no rows")
+	}
+	count, ok := util.ExtractInt64Maybe(rows[0].Cols[0].Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count value: %v (type: %T)", rows[0].Cols[0].Value, rows[0].Cols[0].Value)
+	}
+	return count, nil
+}
+
+func badRequest(err error) error {
+	return fmt.Errorf("%w: %v", quesma_errors.ErrCouldNotParseRequest(), err)
+}
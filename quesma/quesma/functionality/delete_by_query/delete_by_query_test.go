@@ -0,0 +1,166 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package delete_by_query
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/concurrent"
+	"quesma/model"
+	"quesma/quesma/config"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"quesma/schema"
+	"quesma/util"
+	"regexp"
+	"testing"
+)
+
+type staticRegistry struct {
+	tables map[schema.TableName]schema.Schema
+}
+
+func (e staticRegistry) AllSchemas() map[schema.TableName]schema.Schema {
+	return e.tables
+}
+
+func (e staticRegistry) FindSchema(name schema.TableName) (schema.Schema, bool) {
+	s, found := e.tables[name]
+	return s, found
+}
+
+const tableName = "logs-generic-default"
+
+func newTestLogManager(t *testing.T) (*clickhouse.LogManager, sqlmock.Sqlmock, func() error) {
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"host":   {Name: "host", Type: clickhouse.NewBaseType("LowCardinality(String)")},
+			"status": {Name: "status", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created: true,
+	}
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	return clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table)), mock, db.Close
+}
+
+var (
+	testConfig = config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	testSchema = staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"host":   {PropertyName: "host", InternalPropertyName: "host", Type: schema.TypeKeyword},
+		"status": {PropertyName: "status", InternalPropertyName: "status", Type: schema.TypeLong},
+	}}}}
+)
+
+// noTransformations is a transformer, which leaves queries as they are
+type noTransformations struct{}
+
+func (noTransformations) Transform(queries []*model.Query) ([]*model.Query, error) {
+	return queries, nil
+}
+
+// baselineFilter is a transformer, which narrows all queries with `condition`, like baseline filters of searches
+type baselineFilter struct {
+	condition model.Expr
+}
+
+func (b baselineFilter) Transform(queries []*model.Query) ([]*model.Query, error) {
+	for _, query := range queries {
+		query.SelectCommand.WhereClause = model.And([]model.Expr{query.SelectCommand.WhereClause, b.condition})
+	}
+	return queries, nil
+}
+
+func TestHandleDeleteByQuery(t *testing.T) {
+	lm, mock, closeDb := newTestLogManager(t)
+	defer closeDb()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count() FROM "logs-generic-default" WHERE (("host"='web-01' AND "status">=500) AND "host"!='internal')`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(3)))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "logs-generic-default" WHERE (("host"='web-01' AND "status">=500) AND "host"!='internal')`)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	body := types.MustJSON(`{"query": {"bool": {"filter": [{"term": {"host": "web-01"}}, {"range": {"status": {"gte": 500}}}]}}}`)
+	transformer := baselineFilter{condition: model.NewInfixExpr(model.NewColumnRef("host"), "!=", model.NewLiteral("'internal'"))}
+	response, err := HandleDeleteByQuery(context.Background(), "logs-*", body, false, testConfig, testSchema, lm, transformer)
+	assert.NoError(t, err)
+	responseJson, err := types.ParseJSON(string(response))
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, responseJson["deleted"])
+	assert.Equal(t, 3.0, responseJson["total"])
+	assert.Equal(t, []any{}, responseJson["failures"])
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestHandleDeleteByQueryMatchAll(t *testing.T) {
+	for _, body := range []string{`{}`, `{"query": {"match_all": {}}}`, `{"query": {"bool": {}}}`,
+		`{"query": {"bool": {"filter": [{"match_all": {}}]}}}`, `{"query": {"constant_score": {"filter": {"match_all": {}}}}}`} {
+		t.Run(body, func(t *testing.T) {
+			lm, mock, closeDb := newTestLogManager(t)
+			defer closeDb()
+
+			_, err := HandleDeleteByQuery(context.Background(), tableName, types.MustJSON(body), false, testConfig, testSchema, lm, noTransformations{})
+			assert.True(t, errors.Is(err, quesma_errors.ErrCouldNotParseRequest()))
+
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT count() FROM "logs-generic-default"`)).
+				WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(10)))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "logs-generic-default" WHERE true`)).
+				WillReturnResult(sqlmock.NewResult(0, 10))
+			_, err = HandleDeleteByQuery(context.Background(), tableName, types.MustJSON(body), true, testConfig, testSchema, lm, noTransformations{})
+			assert.NoError(t, err)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestHandleDeleteByQueryNothingMatching(t *testing.T) {
+	lm, mock, closeDb := newTestLogManager(t)
+	defer closeDb()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count() FROM "logs-generic-default" WHERE "status"=404`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(0)))
+	response, err := HandleDeleteByQuery(context.Background(), tableName, types.MustJSON(`{"query": {"term": {"status": 404}}}`),
+		false, testConfig, testSchema, lm, noTransformations{})
+	assert.NoError(t, err)
+	responseJson, err := types.ParseJSON(string(response))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, responseJson["deleted"])
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+
+	_, err = HandleDeleteByQuery(context.Background(), "other", types.MustJSON(`{"query": {"term": {"status": 404}}}`),
+		false, testConfig, testSchema, lm, noTransformations{})
+	assert.True(t, errors.Is(err, quesma_errors.ErrIndexNotExists()))
+}
+
+func TestIsTriviallyTrue(t *testing.T) {
+	status := model.NewInfixExpr(model.NewColumnRef("status"), "=", model.NewLiteral(404))
+	tests := []struct {
+		name        string
+		whereClause model.Expr
+		want        bool
+	}{
+		{"nil", nil, true},
+		{"true", model.NewLiteral("true"), true},
+		{"NOT false", model.NewPrefixExpr("NOT", []model.Expr{model.NewLiteral(false)}), true},
+		{"true AND (true)", model.NewInfixExpr(model.NewLiteral(true), "AND", model.NewParenExpr(model.NewLiteral("TRUE"))), true},
+		{"condition OR true", model.NewInfixExpr(status, "OR", model.NewLiteral("true")), true},
+		{"condition AND true", model.NewInfixExpr(status, "AND", model.NewLiteral("true")), false},
+		{"false", model.NewLiteral("false"), false},
+		{"condition", status, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isTriviallyTrue(tt.whereClause))
+		})
+	}
+}
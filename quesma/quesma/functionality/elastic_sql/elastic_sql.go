@@ -0,0 +1,156 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package elastic_sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"quesma/clickhouse"
+	"quesma/elasticsearch/elasticsearch_field_types"
+	"quesma/model"
+	"quesma/quesma/config"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"quesma/schema"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFetchSize = 1000 // as in Elasticsearch
+	datetimeType     = "datetime"
+)
+
+// Request is a parsed Elasticsearch SQL API (_sql) request
+type Request struct {
+	Query     *Query
+	FetchSize int // how many rows we return, if the query has no LIMIT
+}
+
+// ParseRequest checks _sql request is one we support (json format, no cursors, filters or parameters) and parses its query
+func ParseRequest(body types.JSON, format string) (Request, error) {
+	if format != "" && format != "json" {
+		return Request{}, badRequest(fmt.Errorf("format %s is not supported, only json is", format))
+	}
+	for _, unsupportedParam := range []string{"cursor", "filter", "params"} {
+		if _, exists := body[unsupportedParam]; exists {
+			return Request{}, badRequest(fmt.Errorf("%s is not supported", unsupportedParam))
+		}
+	}
+	queryText, ok := body["query"].(string)
+	if !ok {
+		return Request{}, badRequest(errors.New("query is missing or isn't a string"))
+	}
+	fetchSize := defaultFetchSize
+	if fetchSizeRaw, ok := body["fetch_size"].(float64); ok && fetchSizeRaw > 0 {
+		fetchSize = int(fetchSizeRaw)
+	}
+
+	query, err := ParseQuery(queryText)
+	if err != nil {
+		return Request{}, badRequest(err)
+	}
+	return Request{Query: query, FetchSize: fetchSize}, nil
+}
+
+// Translate returns Clickhouse SQL of the request's query, reading from `from` instead of the index
+func (request Request) Translate(from string, tableSchema schema.Schema) (string, error) {
+	resolveField := func(fieldName string) (string, bool) {
+		field, exists := tableSchema.ResolveField(fieldName)
+		return field.InternalPropertyName.AsString(), exists
+	}
+	translated, err := request.Query.Translate(from, resolveField, request.FetchSize)
+	if err != nil {
+		return "", badRequest(err)
+	}
+	return translated, nil
+}
+
+// ResolveTable returns the only table (of the indexes enabled in the config) matching `indexPattern`
+func ResolveTable(ctx context.Context, cfg config.QuesmaConfiguration, lm *clickhouse.LogManager, indexPattern string) (string, error) {
+	tables, err := lm.ResolveIndexes(ctx, indexPattern)
+	if err != nil {
+		return "", err
+	}
+	enabledTables := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if indexConfig, exists := cfg.IndexConfig[table]; exists && indexConfig.Enabled {
+			enabledTables = append(enabledTables, table)
+		}
+	}
+	switch len(enabledTables) {
+	case 0:
+		return "", quesma_errors.ErrIndexNotExists()
+	case 1:
+		return enabledTables[0], nil
+	default:
+		return "", badRequest(fmt.Errorf("index %s matches multiple tables: %s, querying multiple tables is not supported",
+			indexPattern, strings.Join(enabledTables, ", ")))
+	}
+}
+
+// MakeResponse returns _sql response with the query result. Columns, which are fields of `tableSchema`
+// (e.g. from SELECT *), are named as fields, not as Clickhouse columns.
+func MakeResponse(columns []clickhouse.RawQueryColumn, rows []model.QueryResultRow, tableSchema schema.Schema) model.SqlResponse {
+	response := model.SqlResponse{Columns: make([]model.SqlColumn, 0, len(columns)), Rows: make([][]any, 0, len(rows))}
+	for _, column := range columns {
+		name := column.Name
+		if field, isField := tableSchema.ResolveFieldByInternalName(name); isField {
+			name = field.PropertyName.AsString()
+		}
+		response.Columns = append(response.Columns, model.SqlColumn{Name: name, Type: asSqlType(column.Type)})
+	}
+	for _, row := range rows {
+		values := make([]any, 0, len(row.Cols))
+		for _, col := range row.Cols {
+			values = append(values, sqlValue(col.Value))
+		}
+		response.Rows = append(response.Rows, values)
+	}
+	return response
+}
+
+// asSqlType returns Elasticsearch SQL type of a Clickhouse type
+func asSqlType(clickhouseType string) string {
+	for _, wrapper := range []string{"Nullable(", "LowCardinality("} {
+		if strings.HasPrefix(clickhouseType, wrapper) && strings.HasSuffix(clickhouseType, ")") {
+			clickhouseType = clickhouseType[len(wrapper) : len(clickhouseType)-1]
+		}
+	}
+	switch {
+	case clickhouseType == "Bool":
+		return elasticsearch_field_types.FieldTypeBoolean
+	case clickhouseType == "Int8", clickhouseType == "Int16", clickhouseType == "Int32", clickhouseType == "UInt8", clickhouseType == "UInt16":
+		return elasticsearch_field_types.FieldTypeInteger
+	case strings.HasPrefix(clickhouseType, "Int"), strings.HasPrefix(clickhouseType, "UInt"):
+		return elasticsearch_field_types.FieldTypeLong
+	case clickhouseType == "Float32":
+		return elasticsearch_field_types.FieldTypeFloat
+	case strings.HasPrefix(clickhouseType, "Float"), strings.HasPrefix(clickhouseType, "Decimal"):
+		return elasticsearch_field_types.FieldTypeDouble
+	case strings.HasPrefix(clickhouseType, "Date"):
+		return datetimeType
+	default:
+		// strings, and also other types we return as they are
+		return elasticsearch_field_types.FieldTypeKeyword
+	}
+}
+
+// sqlValue formats dates as Elasticsearch SQL does, other values are returned as they come from Clickhouse
+func sqlValue(value any) any {
+	switch valueTyped := value.(type) {
+	case time.Time:
+		return valueTyped.UTC().Format("2006-01-02T15:04:05.000Z")
+	case *time.Time:
+		if valueTyped == nil {
+			return nil
+		}
+		return valueTyped.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	return value
+}
+
+func badRequest(err error) error {
+	return fmt.Errorf("%w: %v", quesma_errors.ErrCouldNotParseRequest(), err)
+}
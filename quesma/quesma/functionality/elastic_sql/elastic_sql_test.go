@@ -0,0 +1,91 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package elastic_sql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	resolveField := func(fieldName string) (string, bool) {
+		switch fieldName {
+		case "host.name":
+			return "host::name", true
+		case "status", "bytes", "message":
+			return fieldName, true
+		}
+		return "", false
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`SELECT * FROM logs`, `SELECT * FROM "logs_table" LIMIT 1000`},
+		{`select "host.name", COUNT(*) AS c FROM "logs-*" WHERE status >= 400 GROUP BY "host.name" LIMIT 5;`,
+			`select "host::name" AS "host.name", COUNT(*) AS c FROM "logs_table" WHERE "status" >= 400 GROUP BY "host::name" LIMIT 5`},
+		{"SELECT UCASE(host.name), AVG(bytes)\n  FROM logs-2024.*  -- comment\n  GROUP BY 1 ORDER BY 2 DESC",
+			`SELECT upper("host::name") AS "UCASE(host.name)", AVG("bytes") AS "AVG(bytes)" FROM "logs_table" GROUP BY 1 ORDER BY 2 DESC LIMIT 1000`},
+		{`SELECT message FROM logs WHERE message = 'it''s' AND status IS NOT NULL`,
+			`SELECT "message" FROM "logs_table" WHERE "message" = 'it''s' AND "status" IS NOT NULL LIMIT 1000`},
+		{`SELECT DISTINCT status AS s FROM logs ORDER BY s DESC`, `SELECT DISTINCT "status" AS s FROM "logs_table" ORDER BY s DESC LIMIT 1000`},
+		{`SELECT IIF(status >= 400, 'error', 'ok') AS level FROM logs WHERE CAST(bytes AS INT) > 0`,
+			`SELECT if("status" >= 400, 'error', 'ok') AS level FROM "logs_table" WHERE CAST("bytes" AS INT) > 0 LIMIT 1000`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := ParseQuery(tt.query)
+			assert.NoError(t, err)
+			translated, err := query.Translate(`"logs_table"`, resolveField, defaultFetchSize)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, translated)
+		})
+	}
+}
+
+func TestTranslateUnknownColumn(t *testing.T) {
+	resolveField := func(fieldName string) (string, bool) {
+		return fieldName, fieldName == "status"
+	}
+	for _, queryText := range []string{`SELECT password FROM logs`, `SELECT status FROM logs WHERE currentUser IS NULL`} {
+		t.Run(queryText, func(t *testing.T) {
+			query, err := ParseQuery(queryText)
+			assert.NoError(t, err)
+			_, err = query.Translate(`"logs"`, resolveField, defaultFetchSize)
+			assert.ErrorContains(t, err, "unknown column")
+		})
+	}
+}
+
+func TestParseQueryUnsupported(t *testing.T) {
+	tests := []struct {
+		query         string
+		expectedError string
+	}{
+		{`SHOW TABLES`, "only SELECT queries are supported"},
+		{`SELECT 1`, "FROM is required"},
+		{`SELECT a FROM logs JOIN other ON a = b`, "JOIN is not supported"},
+		{`SELECT a FROM logs WHERE a IN (SELECT b FROM other)`, "subqueries are not supported"},
+		{`SELECT a FROM logs, other`, "querying multiple indexes is not supported"},
+		{`SELECT l.a FROM logs l`, "index aliases are not supported"},
+		{`SELECT a FROM logs LIMIT 10 WHERE a = 1`, "unexpected WHERE after LIMIT"},
+		{`SELECT a FROM logs LIMIT a`, "LIMIT must be a single non-negative integer"},
+		{`SELECT a FROM logs WHERE MATCH(a, 'text')`, "function MATCH is not supported"},
+		{`SELECT a FROM logs WHERE hostName() = 'a'`, "function HOSTNAME is not supported"},
+		{`SELECT file('/etc/passwd') FROM logs`, "function FILE is not supported"},
+		{`SELECT a FROM logs; DROP TABLE logs`, "multiple statements are not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseQuery(tt.query)
+			assert.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestIndexPatternOf(t *testing.T) {
+	assert.Equal(t, "logs-*", IndexPatternOf(`SELECT COUNT(*) FROM "logs-*" WHERE a = 1`))
+	assert.Equal(t, "logs-generic-default", IndexPatternOf(`SELECT a FROM logs-generic-default JOIN other ON a = b`))
+	assert.Equal(t, "", IndexPatternOf(`SHOW TABLES`))
+}
@@ -0,0 +1,453 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package elastic_sql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DataDog/go-sqllexer"
+	"quesma/model"
+	"strconv"
+	"strings"
+)
+
+// Query is an Elasticsearch SQL query, which we checked we're able to translate to Clickhouse.
+// We support a single SELECT from one index, with optional WHERE, GROUP BY, ORDER BY and LIMIT clauses.
+type Query struct {
+	IndexPattern string // index (or index pattern) from FROM
+	tokens       []sqllexer.Token
+	// tokens[indexStart:indexEnd] are the index in FROM, which we replace with the resolved table
+	indexStart, indexEnd int
+	hasLimit             bool
+	selectItems          []tokenRange // expressions in SELECT
+	aliases              map[string]bool
+}
+
+// tokenRange is [start, end) range of query's tokens
+type tokenRange struct {
+	start, end int
+}
+
+type clause int
+
+const (
+	clauseSelect clause = iota
+	clauseFrom
+	clauseWhere
+	clauseGroupBy
+	clauseOrderBy
+	clauseLimit
+)
+
+var clauseNames = []string{"SELECT", "FROM", "WHERE", "GROUP BY", "ORDER BY", "LIMIT"}
+
+// unsupportedKeywords are constructs of Elasticsearch SQL (or Clickhouse SQL) we don't translate (yet)
+var unsupportedKeywords = map[string]bool{
+	"JOIN": true, "UNION": true, "INTERSECT": true, "EXCEPT": true, "HAVING": true, "PIVOT": true, "OFFSET": true,
+	"WITH": true, "INTO": true, "SETTINGS": true, "FORMAT": true, "PREWHERE": true, "FINAL": true, "SAMPLE": true,
+}
+
+// sqlKeywords are identifiers, which we pass to Clickhouse as they are. Any other identifier must be a field
+// of the index, or an alias defined in the query.
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "DISTINCT": true, "ALL": true, "FROM": true, "WHERE": true, "GROUP": true, "ORDER": true, "BY": true,
+	"LIMIT": true, "AS": true, "AND": true, "OR": true, "NOT": true, "IS": true, "NULL": true, "IN": true, "BETWEEN": true,
+	"LIKE": true, "ILIKE": true, "ASC": true, "DESC": true, "NULLS": true, "FIRST": true, "LAST": true, "TRUE": true,
+	"FALSE": true, "CASE": true, "WHEN": true, "THEN": true, "ELSE": true, "END": true, "INTERVAL": true,
+	// types in CAST(... AS type), which Clickhouse also has
+	"INT": true, "INTEGER": true, "BIGINT": true, "SMALLINT": true, "TINYINT": true, "FLOAT": true, "DOUBLE": true,
+	"REAL": true, "BOOLEAN": true, "BOOL": true, "DATE": true, "DATETIME": true, "TEXT": true, "VARCHAR": true,
+	// units of INTERVAL
+	"YEAR": true, "QUARTER": true, "MONTH": true, "WEEK": true, "DAY": true, "HOUR": true, "MINUTE": true, "SECOND": true,
+}
+
+// sameNameFunctions are Elasticsearch SQL functions, which Clickhouse has with the same name and arguments
+// (case-insensitively), so we pass them as they are
+var sameNameFunctions = map[string]bool{
+	"AVG": true, "COUNT": true, "MAX": true, "MIN": true, "SUM": true, "STDDEV_POP": true, "STDDEV_SAMP": true,
+	"VAR_POP": true, "VAR_SAMP": true,
+	"ABS": true, "CEIL": true, "EXP": true, "FLOOR": true, "LOG": true, "LOG10": true, "POWER": true, "ROUND": true,
+	"SIGN": true, "SQRT": true, "CBRT": true, "PI": true, "SIN": true, "COS": true, "TAN": true, "ASIN": true,
+	"ACOS": true, "ATAN": true, "ATAN2": true, "DEGREES": true, "RADIANS": true, "MOD": true,
+	"ASCII": true, "CONCAT": true, "LEFT": true, "RIGHT": true, "LTRIM": true, "RTRIM": true, "TRIM": true,
+	"SUBSTRING": true, "REPEAT": true, "SPACE": true,
+	"COALESCE": true, "GREATEST": true, "LEAST": true, "CAST": true, "DATE_TRUNC": true,
+}
+
+// clickhouseFunctions are Elasticsearch SQL functions, which have a different name in Clickhouse.
+// Along with sameNameFunctions, they are all functions we support: any other one (e.g. MATCH, which has
+// no Clickhouse equivalent, or a Clickhouse function like hostName) is rejected.
+var clickhouseFunctions = map[string]string{
+	"LCASE":             "lower",
+	"UCASE":             "upper",
+	"LENGTH":            "lengthUTF8",
+	"CHAR_LENGTH":       "lengthUTF8",
+	"STARTS_WITH":       "startsWith",
+	"OCTET_LENGTH":      "length",
+	"REPLACE":           "replaceAll",
+	"IIF":               "if",
+	"IFNULL":            "ifNull",
+	"ISNULL":            "ifNull",
+	"NVL":               "ifNull",
+	"NULLIF":            "nullIf",
+	"CEILING":           "ceil",
+	"TRUNCATE":          "trunc",
+	"RANDOM":            "rand",
+	"NOW":               "now",
+	"CURRENT_TIMESTAMP": "now",
+	"CURDATE":           "today",
+	"YEAR":              "toYear",
+	"QUARTER":           "toQuarter",
+	"MONTH":             "toMonth",
+	"DAY":               "toDayOfMonth",
+	"DAY_OF_MONTH":      "toDayOfMonth",
+	"DAY_OF_YEAR":       "toDayOfYear",
+	"HOUR":              "toHour",
+	"MINUTE":            "toMinute",
+	"SECOND":            "toSecond",
+}
+
+// ParseQuery tokenizes Elasticsearch SQL query and checks its structure. Returns an error describing the first
+// unsupported construct, if there's any.
+func ParseQuery(queryText string) (*Query, error) {
+	tokens := significantTokens(queryText)
+	if len(tokens) == 0 || !isKeyword(tokens[0], "SELECT") {
+		return nil, errors.New("only SELECT queries are supported")
+	}
+
+	query := &Query{tokens: tokens}
+	clauseStarts := map[clause]int{clauseSelect: 0}
+	currentClause := clauseSelect
+	depth := 0
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token.Type {
+		case sqllexer.PUNCTUATION:
+			switch token.Value {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			case ";":
+				return nil, errors.New("multiple statements are not supported")
+			}
+			continue
+		case sqllexer.FUNCTION:
+			// keywords followed by "(" are tokenized as functions too
+			if name := strings.ToUpper(token.Value); !isSupportedFunction(name) && !sqlKeywords[name] && !unsupportedKeywords[name] {
+				return nil, fmt.Errorf("function %s is not supported", name)
+			}
+		case sqllexer.IDENT:
+		case sqllexer.ERROR, sqllexer.INCOMPLETE_STRING, sqllexer.UNKNOWN, sqllexer.BIND_PARAMETER, sqllexer.POSITIONAL_PARAMETER,
+			sqllexer.SYSTEM_VARIABLE, sqllexer.DOLLAR_QUOTED_STRING, sqllexer.DOLLAR_QUOTED_FUNCTION:
+			return nil, fmt.Errorf("unsupported token: '%s'", token.Value)
+		default:
+			continue
+		}
+
+		keyword := strings.ToUpper(token.Value)
+		if keyword == "SELECT" {
+			return nil, errors.New("subqueries are not supported")
+		}
+		if unsupportedKeywords[keyword] {
+			return nil, fmt.Errorf("%s is not supported", keyword)
+		}
+		if depth > 0 {
+			continue
+		}
+		nextClause, isClause := clauseStartingAt(tokens, i)
+		if !isClause {
+			continue
+		}
+		if nextClause <= currentClause {
+			return nil, fmt.Errorf("unexpected %s after %s", clauseNames[nextClause], clauseNames[currentClause])
+		}
+		clauseStarts[nextClause] = i
+		currentClause = nextClause
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+
+	fromStart, hasFrom := clauseStarts[clauseFrom]
+	if !hasFrom {
+		return nil, errors.New("FROM is required")
+	}
+	fromEnd := len(tokens)
+	for c := clauseFrom + 1; c <= clauseLimit; c++ {
+		if start, exists := clauseStarts[c]; exists {
+			fromEnd = start
+			break
+		}
+	}
+	query.indexStart, query.indexEnd = trimSpaces(tokens, fromStart+1, fromEnd)
+	query.selectItems = splitSelectItems(tokens, 1, fromStart)
+	query.aliases = make(map[string]bool)
+	for i, token := range tokens {
+		if isKeyword(token, "AS") {
+			if next, _ := trimSpaces(tokens, i+1, len(tokens)); next < len(tokens) {
+				query.aliases[identifierName(tokens[next])] = true
+			}
+		}
+	}
+	var err error
+	if query.IndexPattern, err = parseIndexPattern(tokens[query.indexStart:query.indexEnd]); err != nil {
+		return nil, err
+	}
+
+	if limitStart, hasLimit := clauseStarts[clauseLimit]; hasLimit {
+		start, end := trimSpaces(tokens, limitStart+1, len(tokens))
+		if end-start != 1 || tokens[start].Type != sqllexer.NUMBER {
+			return nil, errors.New("LIMIT must be a single non-negative integer")
+		}
+		if limit, err := strconv.Atoi(tokens[start].Value); err != nil || limit < 0 {
+			return nil, errors.New("LIMIT must be a single non-negative integer")
+		}
+		query.hasLimit = true
+	}
+	return query, nil
+}
+
+// IndexPatternOf returns index from FROM of the query, also if we don't support the query (then we return an error,
+// if it's about our index, instead of passing it to Elasticsearch). Returns "" if there's no FROM.
+func IndexPatternOf(queryText string) string {
+	tokens := significantTokens(queryText)
+	for i, token := range tokens {
+		if !isKeyword(token, "FROM") {
+			continue
+		}
+		start, _ := trimSpaces(tokens, i+1, len(tokens))
+		end := start
+		for end < len(tokens) && tokens[end].Type != sqllexer.WS && !(tokens[end].Type == sqllexer.PUNCTUATION && tokens[end].Value != ".") {
+			end++
+		}
+		indexPattern, _ := parseIndexPattern(tokens[start:end])
+		return indexPattern
+	}
+	return ""
+}
+
+// Translate returns Clickhouse SQL for the query: index in FROM is replaced with `from` (a table or a subquery),
+// field names with columns, and functions with their Clickhouse equivalents. Identifiers, which are neither
+// fields (`resolveField` returns false for them), aliases nor keywords, are an error. Selected expressions are
+// aliased with their Elasticsearch SQL names, so columns of the result are named as in Elasticsearch.
+// We don't support cursors, so without LIMIT we return only the first `fetchSize` rows.
+func (query *Query) Translate(from string, resolveField func(fieldName string) (column string, isField bool), fetchSize int) (string, error) {
+	aliasAfter := make(map[int]string) // token index -> alias of the select item ending with it
+	for _, item := range query.selectItems {
+		if alias, needed := query.columnAlias(item, resolveField); needed {
+			aliasAfter[item.end-1] = alias
+		}
+	}
+
+	var sb strings.Builder
+	for i, token := range query.tokens {
+		if i == query.indexStart {
+			sb.WriteString(from)
+		}
+		if i >= query.indexStart && i < query.indexEnd {
+			continue
+		}
+		switch token.Type {
+		case sqllexer.FUNCTION:
+			name := strings.ToUpper(token.Value)
+			if function, exists := clickhouseFunctions[name]; exists {
+				sb.WriteString(function)
+			} else if sameNameFunctions[name] || sqlKeywords[name] {
+				sb.WriteString(token.Value)
+			} else {
+				return "", fmt.Errorf("function %s is not supported", name)
+			}
+		case sqllexer.IDENT, sqllexer.QUOTED_IDENT:
+			name := identifierName(token)
+			if column, isField := resolveField(name); isField {
+				sb.WriteString(model.QuoteIdentifier(column))
+			} else if query.aliases[name] || (token.Type == sqllexer.IDENT && sqlKeywords[strings.ToUpper(name)]) {
+				sb.WriteString(token.Value)
+			} else {
+				return "", fmt.Errorf("unknown column [%s]", name)
+			}
+		default:
+			sb.WriteString(token.Value)
+		}
+		if alias, exists := aliasAfter[i]; exists {
+			sb.WriteString(" AS ")
+			sb.WriteString(model.QuoteIdentifier(alias))
+		}
+	}
+	if !query.hasLimit {
+		sb.WriteString(" LIMIT ")
+		sb.WriteString(strconv.Itoa(fetchSize))
+	}
+	return sb.String(), nil
+}
+
+// columnAlias returns name of the result column of select `item`, as Elasticsearch SQL names it: the field for
+// a single field, the expression as written otherwise. It isn't needed for `*` and items with an alias already,
+// and for fields named as their columns.
+func (query *Query) columnAlias(item tokenRange, resolveField func(fieldName string) (column string, isField bool)) (string, bool) {
+	tokens := query.tokens[item.start:item.end]
+	depth := 0
+	for _, token := range tokens {
+		switch {
+		case token.Type == sqllexer.PUNCTUATION && token.Value == "(":
+			depth++
+		case token.Type == sqllexer.PUNCTUATION && token.Value == ")":
+			depth--
+		case depth == 0 && isKeyword(token, "AS"):
+			return "", false
+		}
+	}
+	if len(tokens) == 1 {
+		switch tokens[0].Type {
+		case sqllexer.WILDCARD:
+			return "", false
+		case sqllexer.IDENT, sqllexer.QUOTED_IDENT:
+			name := identifierName(tokens[0])
+			column, isField := resolveField(name)
+			return name, isField && column != name
+		}
+	}
+	var name strings.Builder
+	for _, token := range tokens {
+		name.WriteString(token.Value)
+	}
+	return name.String(), true
+}
+
+// splitSelectItems returns expressions of SELECT clause, which are tokens[start:end]
+func splitSelectItems(tokens []sqllexer.Token, start, end int) []tokenRange {
+	start, end = trimSpaces(tokens, start, end)
+	if start < end && (isKeyword(tokens[start], "DISTINCT") || isKeyword(tokens[start], "ALL")) {
+		start, end = trimSpaces(tokens, start+1, end)
+	}
+	items := make([]tokenRange, 0)
+	depth, itemStart := 0, start
+	for i := start; i <= end; i++ {
+		if i < end && tokens[i].Type == sqllexer.PUNCTUATION {
+			switch tokens[i].Value {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+		}
+		if i == end || (depth == 0 && tokens[i].Type == sqllexer.PUNCTUATION && tokens[i].Value == ",") {
+			if itemStart, itemEnd := trimSpaces(tokens, itemStart, i); itemStart < itemEnd {
+				items = append(items, tokenRange{start: itemStart, end: itemEnd})
+			}
+			itemStart = i + 1
+		}
+	}
+	return items
+}
+
+// significantTokens returns tokens of the query, without comments, trailing semicolon and whitespace.
+// Every whitespace is replaced by a single space.
+func significantTokens(queryText string) []sqllexer.Token {
+	tokens := make([]sqllexer.Token, 0)
+	for _, token := range sqllexer.New(queryText).ScanAll() {
+		switch token.Type {
+		case sqllexer.COMMENT, sqllexer.MULTILINE_COMMENT:
+			token = sqllexer.Token{Type: sqllexer.WS, Value: " "}
+		case sqllexer.WS:
+			token.Value = " "
+		}
+		if token.Type == sqllexer.WS && (len(tokens) == 0 || tokens[len(tokens)-1].Type == sqllexer.WS) {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	for len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		if last.Type != sqllexer.WS && !(last.Type == sqllexer.PUNCTUATION && last.Value == ";") {
+			break
+		}
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
+
+// clauseStartingAt returns clause, whose keyword is tokens[i] (e.g. GROUP BY for GROUP), if there's one
+func clauseStartingAt(tokens []sqllexer.Token, i int) (clause, bool) {
+	switch strings.ToUpper(tokens[i].Value) {
+	case "FROM":
+		return clauseFrom, true
+	case "WHERE":
+		return clauseWhere, true
+	case "LIMIT":
+		return clauseLimit, true
+	case "GROUP", "ORDER":
+		next, _ := trimSpaces(tokens, i+1, len(tokens))
+		if next < len(tokens) && isKeyword(tokens[next], "BY") {
+			if isKeyword(tokens[i], "GROUP") {
+				return clauseGroupBy, true
+			}
+			return clauseOrderBy, true
+		}
+	}
+	return clauseSelect, false
+}
+
+// parseIndexPattern returns index from FROM, e.g. logs-* or "logs-*"
+func parseIndexPattern(tokens []sqllexer.Token) (string, error) {
+	if len(tokens) == 0 {
+		return "", errors.New("missing index in FROM")
+	}
+	var pattern strings.Builder
+	for _, token := range tokens {
+		switch {
+		case token.Type == sqllexer.PUNCTUATION && token.Value == ",":
+			return "", errors.New("querying multiple indexes is not supported")
+		case token.Type == sqllexer.WS:
+			return "", errors.New("index aliases are not supported")
+		case token.Type == sqllexer.QUOTED_IDENT:
+			pattern.WriteString(unquoteIdentifier(token.Value))
+		case token.Type == sqllexer.IDENT || token.Type == sqllexer.NUMBER || token.Type == sqllexer.WILDCARD ||
+			token.Type == sqllexer.OPERATOR && token.Value == "-" || token.Type == sqllexer.PUNCTUATION && token.Value == ".":
+			pattern.WriteString(token.Value)
+		default:
+			return "", fmt.Errorf("unsupported index in FROM: '%s'", token.Value)
+		}
+	}
+	return pattern.String(), nil
+}
+
+// trimSpaces returns [start, end) range of tokens[start:end] without leading and trailing whitespace
+func trimSpaces(tokens []sqllexer.Token, start, end int) (int, int) {
+	for start < end && tokens[start].Type == sqllexer.WS {
+		start++
+	}
+	for end > start && tokens[end-1].Type == sqllexer.WS {
+		end--
+	}
+	return start, end
+}
+
+// isSupportedFunction checks if Elasticsearch SQL function `name` (upper case) has a Clickhouse equivalent
+func isSupportedFunction(name string) bool {
+	_, renamed := clickhouseFunctions[name]
+	return renamed || sameNameFunctions[name]
+}
+
+// isKeyword checks if token is `keyword`. Keywords followed by "(", e.g. WHERE(...), are tokenized as functions.
+func isKeyword(token sqllexer.Token, keyword string) bool {
+	return (token.Type == sqllexer.IDENT || token.Type == sqllexer.FUNCTION) && strings.EqualFold(token.Value, keyword)
+}
+
+// identifierName returns name of the identifier, unquoted if it's quoted
+func identifierName(token sqllexer.Token) string {
+	if token.Type == sqllexer.QUOTED_IDENT {
+		return unquoteIdentifier(token.Value)
+	}
+	return token.Value
+}
+
+// unquoteIdentifier returns identifier from "identifier" (or `identifier`)
+func unquoteIdentifier(quoted string) string {
+	if len(quoted) < 2 {
+		return quoted
+	}
+	quote := quoted[:1]
+	return strings.ReplaceAll(quoted[1:len(quoted)-1], quote+quote, quote)
+}
@@ -66,11 +66,12 @@ func handleFieldCapsIndex(cfg config.QuesmaConfiguration, schemaRegistry schema.
 			}
 			for fieldName, field := range fieldsWithAliases {
 				addFieldCapabilityFromSchemaRegistry(fields, fieldName.AsString(), field.Type, resolvedIndex)
-				switch field.Type.Name {
-				case "text":
-					addFieldCapabilityFromSchemaRegistry(fields, fmt.Sprintf("%s.keyword", fieldName.AsString()), schema.TypeKeyword, resolvedIndex)
-				case "keyword":
-					addFieldCapabilityFromSchemaRegistry(fields, fmt.Sprintf("%s.text", fieldName.AsString()), schema.TypeText, resolvedIndex)
+				field.PropertyName = fieldName // aliases have multi-fields named after them
+				for _, multiField := range schema.MultiFields(field) {
+					// a real field with the multi-field's name takes precedence
+					if _, exists := fieldsWithAliases[multiField.PropertyName]; !exists {
+						addFieldCapabilityFromSchemaRegistry(fields, multiField.PropertyName.AsString(), multiField.Type, resolvedIndex)
+					}
 				}
 			}
 			transformer := registry.FieldCapsTransformerFor(resolvedIndex, cfg)
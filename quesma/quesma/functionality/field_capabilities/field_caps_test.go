@@ -167,6 +167,35 @@ func TestFieldCapsWithAliases(t *testing.T) {
 	assert.Empty(t, difference2)
 }
 
+func TestFieldCapsMultiFields(t *testing.T) {
+	resp, err := handleFieldCapsIndex(config.QuesmaConfiguration{
+		IndexConfig: map[string]config.IndexConfiguration{"logs-generic-default": {Name: "logs-generic-default", Enabled: true}},
+	}, staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			"logs-generic-default": {
+				Fields: map[schema.FieldName]schema.Field{
+					"message":   {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+					"code":      {PropertyName: "code", InternalPropertyName: "code", Type: schema.TypeKeyword},
+					"code.text": {PropertyName: "code.text", InternalPropertyName: "code.text", Type: schema.TypeKeyword},
+				},
+			},
+		},
+	}, []string{"logs-generic-default"})
+	assert.NoError(t, err)
+	var response model.FieldCapsResponse
+	assert.NoError(t, json.Unmarshal(resp, &response))
+
+	// message has no backing message.keyword field, so it's the multi-field
+	assert.Contains(t, response.Fields, "message.keyword")
+	if assert.Contains(t, response.Fields["message.keyword"], "keyword") {
+		assert.True(t, response.Fields["message.keyword"]["keyword"].Aggregatable)
+	}
+	// code.text is a real (keyword) field, not the text multi-field of code
+	assert.Len(t, response.Fields["code.text"], 1)
+	assert.Contains(t, response.Fields["code.text"], "keyword")
+	assert.Contains(t, response.Fields, "code.text.text")
+}
+
 func TestFieldCapsMultipleIndexes(t *testing.T) {
 	tableMap := clickhouse.NewTableMap()
 	tableMap.Store("logs-1", &clickhouse.Table{
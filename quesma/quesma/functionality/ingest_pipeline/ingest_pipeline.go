@@ -0,0 +1,122 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package ingest_pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"quesma/plugins"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"time"
+)
+
+// Defaults of sample documents' metadata, same as in Elasticsearch
+const (
+	defaultIndex = "_index"
+	defaultId    = "_id"
+)
+
+type simulateResponse struct {
+	Docs []simulatedDoc `json:"docs"`
+}
+
+type simulatedDoc struct {
+	Doc   *simulatedDocContent `json:"doc,omitempty"`
+	Error *simulateError       `json:"error,omitempty"` // if a processor failed
+}
+
+type simulatedDocContent struct {
+	Index  string        `json:"_index"`
+	Id     string        `json:"_id"`
+	Source types.JSON    `json:"_source"`
+	Ingest ingestContent `json:"_ingest"`
+}
+
+type ingestContent struct {
+	Timestamp string `json:"timestamp"`
+}
+
+type simulateError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// HandleSimulate handles _ingest/pipeline/_simulate request: it runs processors of the request's `pipeline`
+// over its sample `docs`, and returns the transformed documents. We don't store pipelines, so only pipelines
+// defined in the request are supported, and just a subset of processors: set, rename, remove and convert.
+func HandleSimulate(body types.JSON) ([]byte, error) {
+	pipelineRaw, ok := body["pipeline"].(map[string]interface{})
+	if !ok {
+		return nil, badRequest(errors.New("[pipeline] required property is missing"))
+	}
+	pipeline, err := parsePipeline(pipelineRaw)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	docs, ok := body["docs"].([]interface{})
+	if !ok {
+		return nil, badRequest(errors.New("[docs] required property is missing"))
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	response := simulateResponse{Docs: make([]simulatedDoc, 0, len(docs))}
+	for i, docRaw := range docs {
+		doc, ok := docRaw.(map[string]interface{})
+		if !ok {
+			return nil, badRequest(fmt.Errorf("doc %d is not an object, but: %v", i, docRaw))
+		}
+		source, ok := doc["_source"].(map[string]interface{})
+		if !ok {
+			return nil, badRequest(fmt.Errorf("[_source] required property is missing in doc %d", i))
+		}
+		index, id := defaultIndex, defaultId
+		if indexRaw, ok := doc["_index"].(string); ok {
+			index = indexRaw
+		}
+		if idRaw, ok := doc["_id"].(string); ok {
+			id = idRaw
+		}
+
+		transformed, err := pipeline.Transform(types.JSON(source).Clone())
+		if err != nil {
+			response.Docs = append(response.Docs, simulatedDoc{Error: &simulateError{Type: "illegal_argument_exception", Reason: err.Error()}})
+			continue
+		}
+		response.Docs = append(response.Docs, simulatedDoc{Doc: &simulatedDocContent{
+			Index: index, Id: id, Source: transformed, Ingest: ingestContent{Timestamp: timestamp}}})
+	}
+	return json.Marshal(response)
+}
+
+// parsePipeline parses pipeline's `processors`: [{"set": {...}}, {"rename": {...}}, ...]
+func parsePipeline(pipeline types.JSON) (plugins.IngestTransformerPipeline, error) {
+	processorsRaw, ok := pipeline["processors"].([]interface{})
+	if !ok {
+		return nil, errors.New("[processors] required property is missing")
+	}
+	processors := make(plugins.IngestTransformerPipeline, 0, len(processorsRaw))
+	for _, processorRaw := range processorsRaw {
+		processor, ok := processorRaw.(map[string]interface{})
+		if !ok || len(processor) != 1 {
+			return nil, fmt.Errorf("processor must be an object with a single processor type, got: %v", processorRaw)
+		}
+		for processorType, paramsRaw := range processor {
+			params, ok := paramsRaw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s processor's config is not an object, but: %v", processorType, paramsRaw)
+			}
+			transformer, err := newProcessor(processorType, params)
+			if err != nil {
+				return nil, err
+			}
+			processors = append(processors, transformer)
+		}
+	}
+	return processors, nil
+}
+
+func badRequest(err error) error {
+	return fmt.Errorf("%w: %v", quesma_errors.ErrCouldNotParseRequest(), err)
+}
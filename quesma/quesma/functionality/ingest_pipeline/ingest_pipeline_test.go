@@ -0,0 +1,139 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package ingest_pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"testing"
+)
+
+func TestProcessors(t *testing.T) {
+	tests := []struct {
+		name       string
+		processor  string
+		source     string
+		wantSource string // "" <=> processor fails
+	}{
+		{"set", `{"set": {"field": "env", "value": "prod"}}`, `{"env": "dev"}`, `{"env": "prod"}`},
+		{"set nested", `{"set": {"field": "host.name", "value": "a"}}`, `{"host": {"ip": "1.2.3.4"}}`, `{"host": {"ip": "1.2.3.4", "name": "a"}}`},
+		{"set without override", `{"set": {"field": "env", "value": "prod", "override": false}}`, `{"env": "dev"}`, `{"env": "dev"}`},
+		{"set without override, missing field", `{"set": {"field": "env", "value": "prod", "override": false}}`, `{}`, `{"env": "prod"}`},
+		{"set under non-object", `{"set": {"field": "host.name", "value": "a"}}`, `{"host": "h"}`, ``},
+
+		{"rename", `{"rename": {"field": "msg", "target_field": "message"}}`, `{"msg": "hi"}`, `{"message": "hi"}`},
+		{"rename nested", `{"rename": {"field": "a.b", "target_field": "c.d"}}`, `{"a": {"b": 1, "x": 2}}`, `{"a": {"x": 2}, "c": {"d": 1}}`},
+		{"rename missing field", `{"rename": {"field": "msg", "target_field": "message"}}`, `{}`, ``},
+		{"rename missing field, ignore_missing", `{"rename": {"field": "msg", "target_field": "message", "ignore_missing": true}}`, `{"a": 1}`, `{"a": 1}`},
+		{"rename to existing field", `{"rename": {"field": "msg", "target_field": "message"}}`, `{"msg": "hi", "message": "x"}`, ``},
+
+		{"remove", `{"remove": {"field": "secret"}}`, `{"secret": "s", "a": 1}`, `{"a": 1}`},
+		{"remove many", `{"remove": {"field": ["secret", "user.password"]}}`, `{"secret": "s", "user": {"password": "p", "name": "n"}}`, `{"user": {"name": "n"}}`},
+		{"remove missing field", `{"remove": {"field": "secret"}}`, `{"a": 1}`, ``},
+		{"remove missing field, ignore_missing", `{"remove": {"field": "secret", "ignore_missing": true}}`, `{"a": 1}`, `{"a": 1}`},
+
+		{"convert to integer", `{"convert": {"field": "code", "type": "integer"}}`, `{"code": " 404"}`, `{"code": 404}`},
+		{"convert to integer, not an integer", `{"convert": {"field": "code", "type": "integer"}}`, `{"code": "4.5"}`, ``},
+		{"convert to double", `{"convert": {"field": "price", "type": "double"}}`, `{"price": "3.25"}`, `{"price": 3.25}`},
+		{"convert to boolean", `{"convert": {"field": "ok", "type": "boolean"}}`, `{"ok": "TRUE"}`, `{"ok": true}`},
+		{"convert to boolean, not a boolean", `{"convert": {"field": "ok", "type": "boolean"}}`, `{"ok": "yes"}`, ``},
+		{"convert to string", `{"convert": {"field": "code", "type": "string"}}`, `{"code": 404}`, `{"code": "404"}`},
+		{"convert array", `{"convert": {"field": "codes", "type": "long"}}`, `{"codes": ["1", "2"]}`, `{"codes": [1, 2]}`},
+		{"convert auto", `{"convert": {"field": "values", "type": "auto"}}`, `{"values": ["1", "1.5", "false", "x"]}`, `{"values": [1, 1.5, false, "x"]}`},
+		{"convert to target_field", `{"convert": {"field": "code", "type": "integer", "target_field": "code_int"}}`, `{"code": "7"}`, `{"code": "7", "code_int": 7}`},
+		{"convert missing field", `{"convert": {"field": "code", "type": "integer"}}`, `{}`, ``},
+		{"convert missing field, ignore_missing", `{"convert": {"field": "code", "type": "integer", "ignore_missing": true}}`, `{}`, `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := types.MustJSON(`{"pipeline": {"processors": [` + tt.processor + `]}, "docs": [{"_source": ` + tt.source + `}]}`)
+			responseBody, err := HandleSimulate(body)
+			assert.NoError(t, err)
+
+			var response struct {
+				Docs []struct {
+					Doc *struct {
+						Source map[string]interface{} `json:"_source"`
+					} `json:"doc"`
+					Error *struct {
+						Reason string `json:"reason"`
+					} `json:"error"`
+				} `json:"docs"`
+			}
+			assert.NoError(t, json.Unmarshal(responseBody, &response))
+			if !assert.Len(t, response.Docs, 1) {
+				return
+			}
+			if tt.wantSource == "" {
+				assert.Nil(t, response.Docs[0].Doc)
+				if assert.NotNil(t, response.Docs[0].Error) {
+					assert.NotEmpty(t, response.Docs[0].Error.Reason)
+				}
+			} else if assert.NotNil(t, response.Docs[0].Doc) {
+				assert.Equal(t, map[string]interface{}(types.MustJSON(tt.wantSource)), response.Docs[0].Doc.Source)
+			}
+		})
+	}
+}
+
+func TestHandleSimulate(t *testing.T) {
+	body := types.MustJSON(`{
+		"pipeline": {
+			"description": "rename, then convert",
+			"processors": [
+				{"rename": {"field": "status", "target_field": "http.status"}},
+				{"convert": {"field": "http.status", "type": "integer"}},
+				{"set": {"field": "processed", "value": true}}
+			]
+		},
+		"docs": [
+			{"_index": "logs", "_id": "1", "_source": {"status": "200"}},
+			{"_source": {"status": "unknown"}}
+		]
+	}`)
+	responseBody, err := HandleSimulate(body)
+	assert.NoError(t, err)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(responseBody, &response))
+	docs := response["docs"].([]interface{})
+	assert.Len(t, docs, 2)
+	doc := docs[0].(map[string]interface{})["doc"].(map[string]interface{})
+	assert.Equal(t, "logs", doc["_index"])
+	assert.Equal(t, "1", doc["_id"])
+	assert.Equal(t, map[string]interface{}{"http": map[string]interface{}{"status": 200.0}, "processed": true}, doc["_source"])
+	assert.NotEmpty(t, doc["_ingest"].(map[string]interface{})["timestamp"])
+	assert.Equal(t, "unable to convert [unknown] to integer", docs[1].(map[string]interface{})["error"].(map[string]interface{})["reason"])
+
+	// sample docs aren't changed
+	assert.Equal(t, "200", body["docs"].([]interface{})[0].(map[string]interface{})["_source"].(map[string]interface{})["status"])
+}
+
+func TestHandleSimulateInvalidRequest(t *testing.T) {
+	for _, body := range []string{
+		`{"docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"set": {"field": "a", "value": 1}}]}}`,
+		`{"pipeline": {"processors": [{"grok": {"field": "message", "patterns": ["%{IP:ip}"]}}]}, "docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"convert": {"field": "a", "type": "date"}}]}, "docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"rename": {"field": "a"}}]}, "docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"set": {"field": "a", "value": 1}}]}, "docs": [{"_id": "no source"}]}`,
+		`{"pipeline": {"processors": [{"set": {"field": "a", "value": 1, "if": "ctx.b != null"}}]}, "docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"set": {"field": "a", "copy_from": "b"}}]}, "docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"rename": {"field": "a", "target_field": "b", "ignore_failure": true}}]}, "docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"remove": {"field": "a", "on_failure": [{"set": {"field": "error", "value": 1}}]}}]}, "docs": [{"_source": {}}]}`,
+		`{"pipeline": {"processors": [{"convert": {"field": "a", "type": "long", "tag": "t"}}]}, "docs": [{"_source": {}}]}`,
+	} {
+		_, err := HandleSimulate(types.MustJSON(body))
+		assert.True(t, errors.Is(err, quesma_errors.ErrCouldNotParseRequest()), body)
+	}
+
+	_, err := HandleSimulate(types.MustJSON(`{"pipeline": {"processors": [{"lowercase": {"target_field": "a"}}]}, "docs": [{"_source": {}}]}`))
+	assert.ErrorContains(t, err, "processor type [lowercase] is not supported")
+	_, err = HandleSimulate(types.MustJSON(`{"pipeline": {"processors": [{"set": {"field": "a", "value": 1, "if": "ctx.b != null"}}]}, "docs": [{"_source": {}}]}`))
+	assert.ErrorContains(t, err, "parameter [if] of set processor is not supported")
+	_, err = HandleSimulate(types.MustJSON(`{"pipeline": {"processors": [{"set": {"field": "a", "value": 1, "description": "documentation only"}}]}, "docs": [{"_source": {}}]}`))
+	assert.NoError(t, err)
+}
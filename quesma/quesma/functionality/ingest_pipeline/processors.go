@@ -0,0 +1,303 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package ingest_pipeline
+
+import (
+	"fmt"
+	"math"
+	"quesma/plugins"
+	"quesma/quesma/types"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// processorParams are parameters of processors we support. Others (e.g. common `if`, `on_failure` or `ignore_failure`)
+// change what a processor does, so we reject them, instead of simulating something the real pipeline wouldn't do.
+var processorParams = map[string][]string{
+	"set":     {"field", "value", "override"},
+	"rename":  {"field", "target_field", "ignore_missing"},
+	"remove":  {"field", "ignore_missing"},
+	"convert": {"field", "type", "target_field", "ignore_missing"},
+}
+
+// newProcessor returns ingest processor `processorType` configured with `params`, e.g. set with {"field": "a", "value": 1}
+func newProcessor(processorType string, params types.JSON) (plugins.IngestTransformer, error) {
+	supportedParams, supported := processorParams[processorType]
+	if !supported {
+		return nil, fmt.Errorf("processor type [%s] is not supported, only set, rename, remove and convert are", processorType)
+	}
+	for param := range params {
+		if param != "description" && !slices.Contains(supportedParams, param) {
+			return nil, fmt.Errorf("parameter [%s] of %s processor is not supported", param, processorType)
+		}
+	}
+
+	field, ok := params["field"].(string)
+	if !ok && processorType != "remove" {
+		return nil, fmt.Errorf("[field] required property is missing in %s processor", processorType)
+	}
+	ignoreMissing, _ := params["ignore_missing"].(bool)
+
+	switch processorType {
+	case "set":
+		value, exists := params["value"]
+		if !exists {
+			return nil, fmt.Errorf("[value] required property is missing in set processor")
+		}
+		override := true
+		if overrideRaw, ok := params["override"].(bool); ok {
+			override = overrideRaw
+		}
+		return &setProcessor{field: field, value: value, override: override}, nil
+	case "rename":
+		targetField, ok := params["target_field"].(string)
+		if !ok {
+			return nil, fmt.Errorf("[target_field] required property is missing in rename processor")
+		}
+		return &renameProcessor{field: field, targetField: targetField, ignoreMissing: ignoreMissing}, nil
+	case "remove":
+		var fields []string
+		switch fieldRaw := params["field"].(type) {
+		case string:
+			fields = []string{fieldRaw}
+		case []interface{}:
+			for _, fieldInArray := range fieldRaw {
+				fieldAsString, ok := fieldInArray.(string)
+				if !ok {
+					return nil, fmt.Errorf("fields of remove processor must be strings, got: %v", fieldInArray)
+				}
+				fields = append(fields, fieldAsString)
+			}
+		default:
+			return nil, fmt.Errorf("[field] required property is missing in remove processor")
+		}
+		return &removeProcessor{fields: fields, ignoreMissing: ignoreMissing}, nil
+	case "convert":
+		targetType, ok := params["type"].(string)
+		if !ok {
+			return nil, fmt.Errorf("[type] required property is missing in convert processor")
+		}
+		if !slices.Contains(convertTypes, targetType) {
+			return nil, fmt.Errorf("type [%s] not supported, cannot convert field", targetType)
+		}
+		targetField := field
+		if targetFieldRaw, ok := params["target_field"].(string); ok {
+			targetField = targetFieldRaw
+		}
+		return &convertProcessor{field: field, targetField: targetField, targetType: targetType, ignoreMissing: ignoreMissing}, nil
+	default: // checked above already
+		return nil, fmt.Errorf("processor type [%s] is not supported", processorType)
+	}
+}
+
+// setProcessor sets `field` to `value`. If `override` is false, it doesn't change fields, which already have a non-null value.
+type setProcessor struct {
+	field    string
+	value    any
+	override bool
+}
+
+func (p *setProcessor) Transform(document types.JSON) (types.JSON, error) {
+	if !p.override {
+		if value, exists := getField(document, p.field); exists && value != nil {
+			return document, nil
+		}
+	}
+	return document, setField(document, p.field, p.value)
+}
+
+// renameProcessor moves `field` to `targetField`, which mustn't exist yet
+type renameProcessor struct {
+	field         string
+	targetField   string
+	ignoreMissing bool
+}
+
+func (p *renameProcessor) Transform(document types.JSON) (types.JSON, error) {
+	value, exists := getField(document, p.field)
+	if !exists {
+		if p.ignoreMissing {
+			return document, nil
+		}
+		return document, fmt.Errorf("field [%s] doesn't exist", p.field)
+	}
+	if _, exists = getField(document, p.targetField); exists {
+		return document, fmt.Errorf("field [%s] already exists", p.targetField)
+	}
+	removeField(document, p.field)
+	return document, setField(document, p.targetField, value)
+}
+
+type removeProcessor struct {
+	fields        []string
+	ignoreMissing bool
+}
+
+func (p *removeProcessor) Transform(document types.JSON) (types.JSON, error) {
+	for _, field := range p.fields {
+		if !removeField(document, field) && !p.ignoreMissing {
+			return document, fmt.Errorf("field [%s] doesn't exist", field)
+		}
+	}
+	return document, nil
+}
+
+// convertProcessor converts `field` (or each of its values, if it's an array) to `targetType` and stores it in `targetField`.
+// Types are: integer, long, float, double, boolean, string, and auto (which converts strings to the first type they look like).
+type convertProcessor struct {
+	field         string
+	targetField   string
+	targetType    string
+	ignoreMissing bool
+}
+
+func (p *convertProcessor) Transform(document types.JSON) (types.JSON, error) {
+	value, exists := getField(document, p.field)
+	if !exists || value == nil {
+		if p.ignoreMissing {
+			return document, nil
+		}
+		return document, fmt.Errorf("field [%s] doesn't exist", p.field)
+	}
+	var converted any
+	if values, isArray := value.([]interface{}); isArray {
+		convertedValues := make([]interface{}, 0, len(values))
+		for _, valueInArray := range values {
+			convertedValue, err := convertValue(valueInArray, p.targetType)
+			if err != nil {
+				return document, err
+			}
+			convertedValues = append(convertedValues, convertedValue)
+		}
+		converted = convertedValues
+	} else {
+		var err error
+		if converted, err = convertValue(value, p.targetType); err != nil {
+			return document, err
+		}
+	}
+	return document, setField(document, p.targetField, converted)
+}
+
+var convertTypes = []string{"integer", "long", "float", "double", "boolean", "string", "auto"}
+
+func convertValue(value any, targetType string) (any, error) {
+	unableToConvert := fmt.Errorf("unable to convert [%v] to %s", value, targetType)
+	switch targetType {
+	case "integer", "long":
+		switch valueTyped := value.(type) {
+		case string:
+			asInt, err := strconv.ParseInt(strings.TrimSpace(valueTyped), 10, 64)
+			if err != nil {
+				return nil, unableToConvert
+			}
+			return asInt, nil
+		case float64:
+			if valueTyped != math.Trunc(valueTyped) {
+				return nil, unableToConvert
+			}
+			return int64(valueTyped), nil
+		case int64:
+			return valueTyped, nil
+		}
+	case "float", "double":
+		switch valueTyped := value.(type) {
+		case string:
+			asFloat, err := strconv.ParseFloat(strings.TrimSpace(valueTyped), 64)
+			if err != nil {
+				return nil, unableToConvert
+			}
+			return asFloat, nil
+		case float64:
+			return valueTyped, nil
+		case int64:
+			return float64(valueTyped), nil
+		}
+	case "boolean":
+		switch valueTyped := value.(type) {
+		case string:
+			switch strings.ToLower(strings.TrimSpace(valueTyped)) {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+		case bool:
+			return valueTyped, nil
+		}
+	case "string":
+		switch valueTyped := value.(type) {
+		case string:
+			return valueTyped, nil
+		case float64:
+			return strconv.FormatFloat(valueTyped, 'f', -1, 64), nil
+		default:
+			return fmt.Sprintf("%v", value), nil
+		}
+	case "auto":
+		valueAsString, isString := value.(string)
+		if !isString {
+			return value, nil
+		}
+		for _, autoType := range []string{"long", "double", "boolean"} {
+			if converted, err := convertValue(valueAsString, autoType); err == nil {
+				return converted, nil
+			}
+		}
+		return value, nil
+	}
+	return nil, unableToConvert
+}
+
+// getField returns value of `field`, which can be a path to a nested object's field, e.g. "user.name"
+func getField(document types.JSON, field string) (any, bool) {
+	if value, exists := document[field]; exists {
+		return value, true
+	}
+	parent, child, isNested := strings.Cut(field, ".")
+	if !isNested {
+		return nil, false
+	}
+	nested, ok := document[parent].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return getField(nested, child)
+}
+
+// setField sets value of `field`, creating its parent objects if needed, e.g. "user.name" in {"user": {"name": value}}
+func setField(document types.JSON, field string, value any) error {
+	parent, child, isNested := strings.Cut(field, ".")
+	if !isNested {
+		document[field] = value
+		return nil
+	}
+	switch nested := document[parent].(type) {
+	case nil:
+		nestedDocument := make(types.JSON)
+		document[parent] = map[string]interface{}(nestedDocument)
+		return setField(nestedDocument, child, value)
+	case map[string]interface{}:
+		return setField(nested, child, value)
+	default:
+		return fmt.Errorf("cannot set [%s], as [%s] isn't an object, but: %v", field, parent, nested)
+	}
+}
+
+// removeField removes `field` (maybe of a nested object), returns false if it doesn't exist
+func removeField(document types.JSON, field string) bool {
+	if _, exists := document[field]; exists {
+		delete(document, field)
+		return true
+	}
+	parent, child, isNested := strings.Cut(field, ".")
+	if !isNested {
+		return false
+	}
+	nested, ok := document[parent].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return removeField(nested, child)
+}
@@ -4,7 +4,10 @@ package gzip
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -22,16 +25,59 @@ func Zip(content []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func UnZip(gzippedData []byte) ([]byte, error) {
+// ErrTooLarge is returned, when content is larger than the given max size (e.g. after decompression)
+var ErrTooLarge = errors.New("content too large")
+
+// UnZip decompresses gzipped data. maxSize > 0 bounds the size of decompressed data, so a small, highly compressed
+// body can't take all the memory: ErrTooLarge is returned, if it's exceeded.
+func UnZip(gzippedData []byte, maxSize int) ([]byte, error) {
 	reader := bytes.NewReader(gzippedData)
 	gzipReader, err := gzip.NewReader(reader)
 	if err != nil {
 		return nil, err
 	}
 	defer gzipReader.Close()
-	return io.ReadAll(gzipReader)
+	return ReadAllLimited(gzipReader, maxSize)
+}
+
+// Inflate decompresses `deflate` content-coding, which is zlib format. Some clients send raw deflate data instead,
+// so it's accepted as well. maxSize works like in UnZip.
+func Inflate(deflatedData []byte, maxSize int) ([]byte, error) {
+	zlibReader, err := zlib.NewReader(bytes.NewReader(deflatedData))
+	if err != nil {
+		flateReader := flate.NewReader(bytes.NewReader(deflatedData))
+		defer flateReader.Close()
+		return ReadAllLimited(flateReader, maxSize)
+	}
+	defer zlibReader.Close()
+	return ReadAllLimited(zlibReader, maxSize)
+}
+
+// ReadAllLimited reads all of `reader`, returning ErrTooLarge if it has more than maxSize bytes. maxSize <= 0 <=> no limit
+func ReadAllLimited(reader io.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(reader)
+	}
+	content, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return content, nil
 }
 
 func IsGzipped(elkResponse *http.Response) bool {
 	return strings.Contains(elkResponse.Header.Get("Content-Encoding"), "gzip")
 }
+
+// ZipStream gzips everything `write` writes, directly into `w`.
+// If `write` fails, the stream isn't closed, so `w` doesn't get a valid gzip of a partial body.
+func ZipStream(w io.Writer, write func(w io.Writer) error) error {
+	gz := gzip.NewWriter(w)
+	if err := write(gz); err != nil {
+		return err
+	}
+	return gz.Close()
+}
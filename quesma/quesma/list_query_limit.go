@@ -0,0 +1,31 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"quesma/logger"
+	"quesma/model"
+	"quesma/model/typical_queries"
+)
+
+// ListQueryLimitPass makes sure every hits (list) query has a bounded LIMIT: the lesser of the requested size and maxLimit.
+// Without it a missing or huge size could end up as a full table scan.
+// Collapsed hits with inner hits take many rows per hit, so their LIMIT is bounded by maxLimit hits, not rows.
+type ListQueryLimitPass struct {
+	maxLimit int
+}
+
+func (p *ListQueryLimitPass) Transform(queries []*model.Query) ([]*model.Query, error) {
+	for _, query := range queries {
+		hits, isHits := query.Type.(*typical_queries.Hits)
+		if !isHits {
+			continue
+		}
+		maxLimit := p.maxLimit * hits.RowsPerHit()
+		if limit := query.SelectCommand.Limit; limit <= 0 || limit > maxLimit {
+			logger.Warn().Msgf("list query LIMIT %d out of bounds, setting it to %d", limit, maxLimit)
+			query.SelectCommand.Limit = maxLimit
+		}
+	}
+	return queries, nil
+}
@@ -0,0 +1,58 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/model"
+	"quesma/model/typical_queries"
+	"testing"
+)
+
+func TestListQueryLimitPass(t *testing.T) {
+	table := &clickhouse.Table{Name: "logs"}
+	newQuery := func(limit int, isHits bool) *model.Query {
+		query := &model.Query{
+			SelectCommand: *model.NewSelectCommand([]model.Expr{model.NewWildcardExpr}, nil, nil, model.NewTableRef("logs"), nil, limit, 0, false),
+		}
+		if isHits {
+			hits := typical_queries.NewHits(context.Background(), table, nil, nil, true, true, false, false)
+			query.Type = &hits
+		} else {
+			query.Type = typical_queries.NewCount(context.Background())
+		}
+		return query
+	}
+	withInnerHits := func(query *model.Query, size int) *model.Query {
+		query.Type.(*typical_queries.Hits).SetInnerHits("user", "inner", size)
+		return query
+	}
+	tests := []struct {
+		name      string
+		query     *model.Query
+		wantLimit int
+	}{
+		{"unbounded list query", newQuery(0, true), 500},
+		{"list query over the max", newQuery(100000, true), 500},
+		{"list query under the max", newQuery(10, true), 10},
+		{"list query at the max", newQuery(500, true), 500},
+		{"not a list query", newQuery(0, false), 0},
+		{"collapsed hits with 3 inner hits, under the max", withInnerHits(newQuery(400*4, true), 3), 400 * 4},
+		{"collapsed hits with 3 inner hits, over the max", withInnerHits(newQuery(600*4, true), 3), 500 * 4},
+		{"unbounded collapsed hits with 3 inner hits", withInnerHits(newQuery(0, true), 3), 500 * 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pass := &ListQueryLimitPass{maxLimit: 500}
+			queries, err := pass.Transform([]*model.Query{tt.query})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLimit, queries[0].SelectCommand.Limit)
+		})
+	}
+
+	unbounded := newQuery(0, true)
+	_, _ = (&ListQueryLimitPass{maxLimit: 500}).Transform([]*model.Query{unbounded})
+	assert.Equal(t, `SELECT * FROM logs LIMIT 500`, unbounded.SelectCommand.String())
+}
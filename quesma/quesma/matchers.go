@@ -6,6 +6,7 @@ import (
 	"quesma/elasticsearch"
 	"quesma/logger"
 	"quesma/quesma/config"
+	"quesma/quesma/functionality/elastic_sql"
 	"quesma/quesma/mux"
 	"quesma/quesma/types"
 	"strings"
@@ -21,14 +22,95 @@ func matchedAgainstAsyncId() mux.RequestMatcher {
 	})
 }
 
+// matchedAgainstPointInTime matches searches, which either aren't in a point in time, or are in one opened by Quesma
+func matchedAgainstPointInTime() mux.RequestMatcher {
+	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
+		body, ok := req.ParsedBody.(types.JSON)
+		if !ok {
+			return true
+		}
+		if id := pointInTimeFromSearch(body); id != "" && !isQuesmaPointInTime(id) {
+			logger.Debug().Msgf("point in time %s is forwarded to Elasticsearch", id)
+			return false
+		}
+		return true
+	})
+}
+
+// matchedAgainstPointInTimeId matches closing of points in time opened by Quesma
+func matchedAgainstPointInTimeId() mux.RequestMatcher {
+	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
+		body, ok := req.ParsedBody.(types.JSON)
+		if !ok {
+			return false
+		}
+		id, _ := body[pointInTimeIdKey].(string)
+		return isQuesmaPointInTime(id)
+	})
+}
+
+// matchedAgainstScroll matches searches, which start a scroll (with `scroll` URL param)
+func matchedAgainstScroll() mux.RequestMatcher {
+	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
+		return req.QueryParams.Get(scrollKey) != ""
+	})
+}
+
+// matchedAgainstScrollId matches continuations and clearing of scrolls started by Quesma
+func matchedAgainstScrollId() mux.RequestMatcher {
+	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
+		body, _ := req.ParsedBody.(types.JSON)
+		ids := scrollIdsFromRequest(body, req.QueryParams.Get(scrollIdKey))
+		if len(ids) == 0 {
+			return false
+		}
+		for _, id := range ids {
+			if !isQuesmaScroll(id) {
+				logger.Debug().Msgf("scroll %s is forwarded to Elasticsearch", id)
+				return false
+			}
+		}
+		return true
+	})
+}
+
 func matchedAgainstBulkBody(configuration config.QuesmaConfiguration) mux.RequestMatcher {
 	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
-		for idx, s := range strings.Split(req.Body, "\n") {
-			if idx%2 == 0 && len(s) > 0 {
-				indexConfig, found := configuration.IndexConfig[extractIndexName(s)]
-				if !found || !indexConfig.Enabled {
-					return false
-				}
+		expectOperation := true
+		for _, s := range strings.Split(req.Body, "\n") {
+			if len(s) == 0 {
+				continue
+			}
+			if !expectOperation { // document line
+				expectOperation = true
+				continue
+			}
+			// `delete` has no document line, the next line is an operation again
+			expectOperation = deleteOperationPattern.MatchString(s)
+
+			indexName := extractIndexName(s)
+			if resolved, isAlias := configuration.ResolveIndexAlias(indexName); isAlias {
+				indexName = resolved
+			}
+			indexConfig, found := configuration.IndexConfig[indexName]
+			if !found || !indexConfig.Enabled {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// matchedAgainstMultiSearchBody matches _msearch requests, whose every search is of an index pattern enabled in the config
+func matchedAgainstMultiSearchBody(configuration config.QuesmaConfiguration) mux.RequestMatcher {
+	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
+		body, ok := req.ParsedBody.(types.NDJSON)
+		if !ok || len(body) == 0 {
+			return false
+		}
+		for i := 0; i < len(body); i += 2 {
+			if !matchesIndexPattern(configuration, multiSearchIndexPattern(body[i], req.Params["index"])) {
+				return false
 			}
 		}
 		return true
@@ -37,45 +119,62 @@ func matchedAgainstBulkBody(configuration config.QuesmaConfiguration) mux.Reques
 
 func matchedAgainstPattern(configuration config.QuesmaConfiguration) mux.RequestMatcher {
 	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
-		indexPattern := elasticsearch.NormalizePattern(req.Params["index"])
-		if elasticsearch.IsInternalIndex(indexPattern) {
-			logger.Debug().Msgf("index %s is an internal Elasticsearch index, skipping", indexPattern)
+		return matchesIndexPattern(configuration, req.Params["index"])
+	})
+}
+
+// matchedAgainstSQLQuery matches Elasticsearch SQL queries, whose FROM is an index enabled in the config
+func matchedAgainstSQLQuery(configuration config.QuesmaConfiguration) mux.RequestMatcher {
+	return mux.RequestMatcherFunc(func(req *mux.Request) bool {
+		body, ok := req.ParsedBody.(types.JSON)
+		if !ok {
 			return false
 		}
+		queryText, _ := body["query"].(string)
+		indexPattern := elastic_sql.IndexPatternOf(queryText)
+		return indexPattern != "" && matchesIndexPattern(configuration, indexPattern)
+	})
+}
 
-		indexPatterns := strings.Split(indexPattern, ",")
+func matchesIndexPattern(configuration config.QuesmaConfiguration, requestedIndex string) bool {
+	indexPattern := elasticsearch.NormalizePattern(requestedIndex)
+	if elasticsearch.IsInternalIndex(indexPattern) {
+		logger.Debug().Msgf("index %s is an internal Elasticsearch index, skipping", indexPattern)
+		return false
+	}
 
-		if elasticsearch.IsIndexPattern(indexPattern) {
-			for _, pattern := range indexPatterns {
-				if elasticsearch.IsInternalIndex(pattern) {
-					logger.Debug().Msgf("index %s is an internal Elasticsearch index, skipping", indexPattern)
-					return false
-				}
+	indexPatterns := strings.Split(indexPattern, ",")
+
+	if elasticsearch.IsIndexPattern(indexPattern) {
+		for _, pattern := range indexPatterns {
+			if elasticsearch.IsInternalIndex(pattern) {
+				logger.Debug().Msgf("index %s is an internal Elasticsearch index, skipping", indexPattern)
+				return false
 			}
+		}
 
-			for _, pattern := range indexPatterns {
-				for _, indexName := range configuration.IndexConfig {
-					if config.MatchName(elasticsearch.NormalizePattern(pattern), indexName.Name) {
-						if configuration.IndexConfig[indexName.Name].Enabled {
-							return true
-						}
+		for _, pattern := range indexPatterns {
+			for _, indexName := range configuration.IndexConfig {
+				if config.MatchName(elasticsearch.NormalizePattern(pattern), indexName.Name) {
+					if configuration.IndexConfig[indexName.Name].Enabled {
+						return true
 					}
 				}
 			}
-			return false
-		} else {
-			for _, index := range configuration.IndexConfig {
-				pattern := elasticsearch.NormalizePattern(indexPattern)
-				if config.MatchName(pattern, index.Name) {
-					if indexConfig, exists := configuration.IndexConfig[index.Name]; exists {
-						return indexConfig.Enabled
-					}
+		}
+		return false
+	} else {
+		for _, index := range configuration.IndexConfig {
+			pattern := elasticsearch.NormalizePattern(indexPattern)
+			if config.MatchName(pattern, index.Name) {
+				if indexConfig, exists := configuration.IndexConfig[index.Name]; exists {
+					return indexConfig.Enabled
 				}
 			}
-			logger.Debug().Msgf("no index found for pattern %s", indexPattern)
-			return false
 		}
-	})
+		logger.Debug().Msgf("no index found for pattern %s", indexPattern)
+		return false
+	}
 }
 
 // Returns false if the body contains a Kibana internal search.
@@ -128,7 +227,7 @@ func matchAgainstKibanaInternal() mux.RequestMatcher {
 			return hasJsonKeyRec(node)
 		}
 
-		q := query["query"].(map[string]interface{})
+		q, _ := query["query"].(map[string]interface{})
 
 		// 1. https://www.elastic.co/guide/en/security/current/alert-schema.html
 		// 2. migrationVersion
@@ -4,6 +4,7 @@ package quesma
 
 import (
 	"github.com/stretchr/testify/assert"
+	"quesma/quesma/config"
 	"quesma/quesma/mux"
 	"quesma/quesma/types"
 	"testing"
@@ -400,3 +401,29 @@ func TestMatchAgainstKibanaAlerts(t *testing.T) {
 	}
 
 }
+
+func TestMatchedAgainstSQLQuery(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"logs":     {Name: "logs", Enabled: true},
+		"disabled": {Name: "disabled", Enabled: false},
+	}}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected bool
+	}{
+		{"our index", `{"query": "SELECT COUNT(*) FROM logs"}`, true},
+		{"our index pattern", `{"query": "SELECT COUNT(*) FROM \"lo*\" GROUP BY a"}`, true},
+		{"unsupported query on our index", `{"query": "SELECT a FROM logs JOIN other ON a = b"}`, true},
+		{"disabled index", `{"query": "SELECT COUNT(*) FROM disabled"}`, false},
+		{"no FROM", `{"query": "SHOW TABLES"}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			req := &mux.Request{Body: test.body, ParsedBody: types.ParseRequestBody(test.body)}
+			assert.Equal(tt, test.expected, matchedAgainstSQLQuery(cfg).Matches(req))
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"quesma/logger"
+	"quesma/queryparser"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"strings"
+	"time"
+)
+
+// handleMultiSearch runs every search of `_msearch` NDJSON `body`: pairs of a header (with the searched index) and a search body.
+// `defaultIndexPattern` (from the URL) is searched, if the header has no index.
+// Failure of one search isn't an error, it's reported in its own response, like in Elastic.
+func (q *QueryRunner) handleMultiSearch(ctx context.Context, defaultIndexPattern string, body types.NDJSON) ([]byte, error) {
+	startTime := time.Now()
+	if len(body)%2 != 0 {
+		return nil, fmt.Errorf("%w: msearch body must consist of header and body pairs, got %d lines",
+			quesma_errors.ErrCouldNotParseRequest(), len(body))
+	}
+
+	responses := make([]json.RawMessage, 0, len(body)/2)
+	for i := 0; i+1 < len(body); i += 2 {
+		indexPattern := multiSearchIndexPattern(body[i], defaultIndexPattern)
+		var responseBody []byte
+		var err error
+		if indexPattern == "" {
+			err = fmt.Errorf("%w: no index in msearch header %d", quesma_errors.ErrCouldNotParseRequest(), i/2)
+		} else {
+			responseBody, err = q.handleSearch(ctx, indexPattern, body[i+1])
+		}
+		if err != nil {
+			logger.WarnWithCtx(ctx).Msgf("msearch: search %d of index %s failed: %v", i/2, indexPattern, err)
+			responses = append(responses, multiSearchErrorResponse(err))
+		} else {
+			responses = append(responses, multiSearchOkResponse(ctx, responseBody))
+		}
+	}
+
+	return json.Marshal(struct {
+		Took      int64             `json:"took"`
+		Responses []json.RawMessage `json:"responses"`
+	}{Took: time.Since(startTime).Milliseconds(), Responses: responses})
+}
+
+// multiSearchIndexPattern returns index pattern of a single search from its msearch `header`: "index" as a string
+// or a list of indexes, or `defaultIndexPattern`, if there's none
+func multiSearchIndexPattern(header types.JSON, defaultIndexPattern string) string {
+	switch index := header["index"].(type) {
+	case string:
+		return index
+	case []any:
+		indexes := make([]string, 0, len(index))
+		for _, indexName := range index {
+			if asString, ok := indexName.(string); ok {
+				indexes = append(indexes, asString)
+			}
+		}
+		if len(indexes) > 0 {
+			return strings.Join(indexes, ",")
+		}
+	}
+	return defaultIndexPattern
+}
+
+// multiSearchOkResponse is the search response with "status": 200, as every msearch response has its status
+func multiSearchOkResponse(ctx context.Context, responseBody []byte) json.RawMessage {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		logger.ErrorWithCtx(ctx).Msgf("msearch: can't parse search response: %v", err)
+		return queryparser.InternalQuesmaError(err.Error())
+	}
+	response["status"] = json.RawMessage(fmt.Sprint(httpOk))
+	serialized, _ := json.Marshal(response)
+	return serialized
+}
+
+// multiSearchErrorResponse is the response of a failed search, with "error" and "status" like the error response of _search
+func multiSearchErrorResponse(err error) json.RawMessage {
+	switch {
+	case errors.Is(err, quesma_errors.ErrIndexNotExists()):
+		return queryparser.IndexNotFoundError(err)
+	case errors.Is(err, quesma_errors.ErrCouldNotParseRequest()):
+		return queryparser.BadRequestParseError(err)
+	case errors.Is(err, quesma_errors.ErrResultWindowTooLarge()):
+		return queryparser.ResultWindowTooLargeError(err)
+	default:
+		return queryparser.InternalQuesmaError(err.Error())
+	}
+}
@@ -5,6 +5,7 @@ package mux
 import (
 	"context"
 	"github.com/ucarion/urlpath"
+	"io"
 	"net/http"
 	"net/url"
 	"quesma/logger"
@@ -26,6 +27,8 @@ type (
 		Body       string
 		Meta       map[string]string
 		StatusCode int
+		// BodyWriter != nil <=> body is streamed by BodyWriter instead of being sent from Body
+		BodyWriter func(w io.Writer) error
 	}
 
 	Request struct {
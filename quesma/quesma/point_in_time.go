@@ -0,0 +1,132 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"quesma/elasticsearch"
+	"quesma/kibana"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	quesmaPitIdPrefix       = "quesma_pit_id_"
+	defaultPitKeepAlive     = time.Minute
+	pointInTimeBodyKey      = "pit"
+	pointInTimeIdKey        = "id"
+	pointInTimeKeepAliveKey = "keep_alive"
+)
+
+var pointInTimeId atomic.Int64
+
+// PointInTime pins tables, which an index pattern resolved to when the PIT was opened.
+// We don't have snapshots, so that's all what consecutive searches (e.g. paging with search_after) need to be consistent.
+type PointInTime struct {
+	indexPattern string
+	tables       []string
+	keepAlive    time.Duration
+	lastUsed     time.Time
+}
+
+type openPointInTimeResponse struct {
+	Id     string               `json:"id"`
+	Shards model.ResponseShards `json:"_shards"`
+}
+
+type closePointInTimeResponse struct {
+	Succeeded bool `json:"succeeded"`
+	NumFreed  int  `json:"num_freed"`
+}
+
+func generatePointInTimeId() string {
+	return quesmaPitIdPrefix + strconv.FormatInt(pointInTimeId.Add(1), 10)
+}
+
+func parseKeepAlive(keepAlive string) time.Duration {
+	if keepAlive == "" {
+		return defaultPitKeepAlive
+	}
+	duration, err := kibana.ParseInterval(keepAlive)
+	if err != nil || duration <= 0 {
+		logger.Warn().Msgf("invalid keep_alive value: %s, using default (%v)", keepAlive, defaultPitKeepAlive)
+		return defaultPitKeepAlive
+	}
+	return duration
+}
+
+func (q *QueryRunner) handleOpenPointInTime(ctx context.Context, indexPattern, keepAlive string) ([]byte, error) {
+	sources, _, sourcesClickhouse := ResolveSources(indexPattern, q.cfg, q.im)
+	if sources != sourceClickhouse {
+		logger.WarnWithCtx(ctx).Msgf("can't open point in time, index pattern [%s] resolved to %s", indexPattern, sources)
+		return nil, quesma_errors.ErrIndexNotExists()
+	}
+	if elasticsearch.IsIndexPattern(indexPattern) {
+		sourcesClickhouse = q.removeNotExistingTables(sourcesClickhouse)
+	}
+
+	id := generatePointInTimeId()
+	q.PointsInTime.Store(id, PointInTime{indexPattern: indexPattern, tables: sourcesClickhouse,
+		keepAlive: parseKeepAlive(keepAlive), lastUsed: time.Now()})
+	logger.InfoWithCtx(ctx).Msgf("point in time %s opened for [%s], tables: %v", id, indexPattern, sourcesClickhouse)
+
+	return json.Marshal(openPointInTimeResponse{Id: id, Shards: model.ResponseShards{Total: 1, Successful: 1}})
+}
+
+// handleClosePointInTime returns found = false if there was no such point in time (e.g. it expired already)
+func (q *QueryRunner) handleClosePointInTime(ctx context.Context, body types.JSON) (responseBody []byte, found bool, err error) {
+	id, ok := body[pointInTimeIdKey].(string)
+	if !ok {
+		return nil, false, fmt.Errorf("invalid point in time id: %v", body[pointInTimeIdKey])
+	}
+	_, found = q.PointsInTime.Load(id)
+	q.PointsInTime.Delete(id)
+
+	response := closePointInTimeResponse{Succeeded: true}
+	if found {
+		response.NumFreed = 1
+		logger.InfoWithCtx(ctx).Msgf("point in time %s closed", id)
+	}
+	responseBody, err = json.Marshal(response)
+	return responseBody, found, err
+}
+
+// pointInTimeFromSearch returns id of point in time, the search `body` is run in, "" if there's none
+func pointInTimeFromSearch(body types.JSON) string {
+	if pit, ok := body[pointInTimeBodyKey].(map[string]any); ok {
+		if id, ok := pit[pointInTimeIdKey].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// isQuesmaPointInTime returns true <=> point in time `id` was opened by Quesma (otherwise it's Elasticsearch's or none at all)
+func isQuesmaPointInTime(id string) bool {
+	return strings.HasPrefix(id, quesmaPitIdPrefix)
+}
+
+// usePointInTime returns pinned point in time `id` and extends its keep alive, as a search in it is starting
+func (q *QueryRunner) usePointInTime(ctx context.Context, id string, body types.JSON) (PointInTime, error) {
+	pit, found := q.PointsInTime.Load(id)
+	if !found {
+		logger.WarnWithCtx(ctx).Msgf("point in time %s not found", id)
+		return pit, quesma_errors.ErrPointInTimeNotFound()
+	}
+	if keepAlive, ok := body[pointInTimeBodyKey].(map[string]any)[pointInTimeKeepAliveKey].(string); ok {
+		pit.keepAlive = parseKeepAlive(keepAlive)
+	}
+	pit.lastUsed = time.Now()
+	q.PointsInTime.Store(id, pit)
+	pit.tables = slices.Clone(pit.tables)
+	return pit, nil
+}
@@ -0,0 +1,98 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/concurrent"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/quesma/config"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"quesma/quesma/ui"
+	"quesma/schema"
+	"quesma/telemetry"
+	"quesma/util"
+	"testing"
+	"time"
+)
+
+func TestPointInTimeOpenSearchClose(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:    tableName,
+		Config:  clickhouse.NewDefaultCHConfig(),
+		Cols:    map[string]*clickhouse.Column{"message": {Name: "message", Type: clickhouse.NewBaseType("String")}},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+
+	// open
+	responseBody, err := queryRunner.handleOpenPointInTime(ctx, tableName, "5m")
+	assert.NoError(t, err)
+	var openResponse openPointInTimeResponse
+	assert.NoError(t, json.Unmarshal(responseBody, &openResponse))
+	assert.Contains(t, openResponse.Id, quesmaPitIdPrefix)
+	pit, found := queryRunner.PointsInTime.Load(openResponse.Id)
+	assert.True(t, found)
+	assert.Equal(t, []string{tableName}, pit.tables)
+	assert.Equal(t, 5*time.Minute, pit.keepAlive)
+
+	// search with pit, without index in the path, goes to the pinned table
+	mock.ExpectQuery(`SELECT "message" FROM "logs"`).WillReturnRows(sqlmock.NewRows([]string{"message"}).AddRow("hello"))
+	searchBody := types.MustJSON(fmt.Sprintf(`{"pit": {"id": "%s", "keep_alive": "10m"}, "size": 10, "track_total_hits": false}`, openResponse.Id))
+	responseBody, err = queryRunner.handleSearch(ctx, "*", searchBody)
+	assert.NoError(t, err)
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(responseBody, &searchResponse))
+	assert.Len(t, searchResponse.Hits.Hits, 1)
+	if assert.NotNil(t, searchResponse.PitID) {
+		assert.Equal(t, openResponse.Id, *searchResponse.PitID)
+	}
+	pit, _ = queryRunner.PointsInTime.Load(openResponse.Id)
+	assert.Equal(t, 10*time.Minute, pit.keepAlive)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// close
+	responseBody, found, err = queryRunner.handleClosePointInTime(ctx, types.MustJSON(fmt.Sprintf(`{"id": "%s"}`, openResponse.Id)))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.JSONEq(t, `{"succeeded": true, "num_freed": 1}`, string(responseBody))
+
+	// closed pit can't be used anymore
+	_, err = queryRunner.handleSearch(ctx, "*", searchBody)
+	assert.ErrorIs(t, err, quesma_errors.ErrPointInTimeNotFound())
+	responseBody, found, err = queryRunner.handleClosePointInTime(ctx, types.MustJSON(fmt.Sprintf(`{"id": "%s"}`, openResponse.Id)))
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.JSONEq(t, `{"succeeded": true, "num_freed": 0}`, string(responseBody))
+}
+
+func TestPointInTimeEviction(t *testing.T) {
+	evictor := NewAsyncQueriesEvictor(EvictionInterval, concurrent.NewMap[string, AsyncRequestResult](),
+		concurrent.NewMap[string, *AsyncQueryContext](), concurrent.NewMap[string, PointInTime](), concurrent.NewMap[string, *Scroll]())
+	evictor.PointsInTime.Store("short", PointInTime{keepAlive: time.Minute, lastUsed: time.Now()})
+	evictor.PointsInTime.Store("long", PointInTime{keepAlive: time.Hour, lastUsed: time.Now()})
+	evictor.tryEvictPointsInTime(func(time.Time) time.Duration {
+		return 2 * time.Minute
+	})
+
+	_, found := evictor.PointsInTime.Load("short")
+	assert.False(t, found)
+	_, found = evictor.PointsInTime.Load("long")
+	assert.True(t, found)
+}
@@ -0,0 +1,70 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"quesma/clickhouse"
+	"quesma/model"
+	"strings"
+)
+
+// PreWherePass moves cheap and selective predicates from WHERE to PREWHERE, so ClickHouse reads other columns
+// only for rows passing them. Moved are only top-level AND conjuncts, which are either ranges on DateTime columns,
+// or equalities on LowCardinality columns.
+type PreWherePass struct {
+	logManager *clickhouse.LogManager
+}
+
+func (p *PreWherePass) Transform(queries []*model.Query) ([]*model.Query, error) {
+	ctx := context.Background()
+	for _, query := range queries {
+		selectCommand := &query.SelectCommand
+		if _, isTable := selectCommand.FromClause.(model.TableRef); !isTable || selectCommand.WhereClause == nil || selectCommand.PreWhere != nil {
+			continue
+		}
+		table := p.logManager.FindTable(getFromTable(query.TableName))
+		if table == nil {
+			continue
+		}
+
+		var preWhere, where []model.Expr
+		for _, conjunct := range splitAnd(selectCommand.WhereClause) {
+			if isPreWhereCandidate(ctx, table, conjunct) {
+				preWhere = append(preWhere, conjunct)
+			} else {
+				where = append(where, conjunct)
+			}
+		}
+		selectCommand.PreWhere = model.And(preWhere)
+		selectCommand.WhereClause = model.And(where)
+	}
+	return queries, nil
+}
+
+// splitAnd returns conjuncts of `expr`, [expr] if it's not an AND
+func splitAnd(expr model.Expr) []model.Expr {
+	if infix, ok := expr.(model.InfixExpr); ok && strings.ToUpper(infix.Op) == "AND" {
+		return append(splitAnd(infix.Left), splitAnd(infix.Right)...)
+	}
+	return []model.Expr{expr}
+}
+
+func isPreWhereCandidate(ctx context.Context, table *clickhouse.Table, expr model.Expr) bool {
+	infix, ok := expr.(model.InfixExpr)
+	if !ok {
+		return false
+	}
+	column, ok := infix.Left.(model.ColumnRef)
+	if !ok {
+		return false
+	}
+	switch infix.Op {
+	case ">=", ">", "<=", "<":
+		return table.GetDateTimeType(ctx, column.ColumnName) != clickhouse.Invalid
+	case "=":
+		col, found := table.Cols[column.ColumnName]
+		return found && strings.HasPrefix(col.Type.String(), "LowCardinality")
+	}
+	return false
+}
@@ -88,6 +88,60 @@ func responseFromQuesma(ctx context.Context, unzipped []byte, w http.ResponseWri
 	}
 }
 
+// streamResponseFromQuesma is like responseFromQuesma, but the body is written by quesmaResponse.BodyWriter as it's produced.
+// Headers are sent with the first byte of the body, so if BodyWriter fails before writing anything, its error is returned
+// and a normal error response can be sent instead. Once the body has been partially sent, the connection is closed on error,
+// so the client can't take the truncated body for a complete response.
+func streamResponseFromQuesma(ctx context.Context, w http.ResponseWriter, elkResponse *http.Response, quesmaResponse *mux.Result, zip bool) error {
+	id := ctx.Value(tracing.RequestIdCtxKey).(string)
+	logger.Debug().Str(logger.RID, id).Msg("streaming response from Quesma")
+
+	body := &headersOnFirstWrite{w: w, writeHeaders: func() {
+		for key, value := range quesmaResponse.Meta {
+			w.Header().Set(key, value)
+		}
+		if zip {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Set(quesmaSourceHeader, quesmaSourceClickhouse)
+		w.WriteHeader(quesmaResponse.StatusCode)
+	}}
+	var err error
+	if zip {
+		err = gzip.ZipStream(body, quesmaResponse.BodyWriter)
+	} else {
+		err = quesmaResponse.BodyWriter(body)
+	}
+	if err != nil {
+		if body.writeHeaders != nil {
+			return err
+		}
+		logger.ErrorWithCtx(ctx).Msgf("Error streaming response, closing connection: %v", err)
+		if conn, _, hijackErr := http.NewResponseController(w).Hijack(); hijackErr == nil {
+			_ = conn.Close()
+		}
+		return nil
+	}
+	if elkResponse != nil {
+		LogMissingEsHeaders(elkResponse.Header, w.Header(), id)
+	}
+	return nil
+}
+
+// headersOnFirstWrite calls writeHeaders just before the first write to w
+type headersOnFirstWrite struct {
+	w            io.Writer
+	writeHeaders func() // nil <=> already called
+}
+
+func (h *headersOnFirstWrite) Write(p []byte) (int, error) {
+	if h.writeHeaders != nil {
+		h.writeHeaders()
+		h.writeHeaders = nil
+	}
+	return h.w.Write(p)
+}
+
 func sendElkResponseToQuesmaConsole(ctx context.Context, elkResponse elasticResult, console *ui.QuesmaManagementConsole) {
 	reader := elkResponse.response.Body
 	body, err := io.ReadAll(reader)
@@ -99,7 +153,7 @@ func sendElkResponseToQuesmaConsole(ctx context.Context, elkResponse elasticResu
 	elkResponse.response.Body = io.NopCloser(bytes.NewBuffer(body))
 
 	if gzip.IsGzipped(elkResponse.response) {
-		body, err = gzip.UnZip(body)
+		body, err = gzip.UnZip(body, 0) // responses of Elasticsearch aren't limited
 		if err != nil {
 			logger.ErrorWithCtx(ctx).Msgf("Error unzipping: %v", err)
 		}
@@ -201,18 +255,27 @@ func (r *router) reroute(ctx context.Context, w http.ResponseWriter, req *http.R
 
 		zip := strings.Contains(req.Header.Get("Accept-Encoding"), "gzip")
 
-		if err == nil {
-			logger.Debug().Ctx(ctx).Msg("responding from quesma")
-			unzipped := []byte{}
-			if quesmaResponse != nil {
-				unzipped = []byte(quesmaResponse.Body)
-			}
-			if len(unzipped) == 0 {
-				logger.WarnWithCtx(ctx).Msg("empty response from Clickhouse")
-			}
+		streamed := err == nil && quesmaResponse != nil && quesmaResponse.BodyWriter != nil
+		if streamed {
+			logger.Debug().Ctx(ctx).Msg("streaming response from quesma")
 			addProductAndContentHeaders(req.Header, w.Header())
+			err = streamResponseFromQuesma(ctx, w, elkResponse, quesmaResponse, zip)
+		}
 
-			responseFromQuesma(ctx, unzipped, w, elkResponse, quesmaResponse, zip)
+		if err == nil {
+			if !streamed {
+				logger.Debug().Ctx(ctx).Msg("responding from quesma")
+				unzipped := []byte{}
+				if quesmaResponse != nil {
+					unzipped = []byte(quesmaResponse.Body)
+				}
+				if len(unzipped) == 0 {
+					logger.WarnWithCtx(ctx).Msg("empty response from Clickhouse")
+				}
+				addProductAndContentHeaders(req.Header, w.Header())
+
+				responseFromQuesma(ctx, unzipped, w, elkResponse, quesmaResponse, zip)
+			}
 
 		} else {
 
@@ -355,29 +418,56 @@ func recordRequestToElastic(path string, qmc *ui.QuesmaManagementConsole, reques
 	return response
 }
 
-func peekBody(r *http.Request) ([]byte, error) {
-	reqBody, err := io.ReadAll(r.Body)
+// peekBody reads (and decompresses) the request body, which can be read again later. Bodies larger than
+// maxContentLength (also after decompression) aren't read, gzip.ErrTooLarge is returned instead.
+func peekBody(r *http.Request, maxContentLength int) ([]byte, error) {
+	reqBody, err := gzip.ReadAllLimited(r.Body, maxContentLength)
+	if errors.Is(err, gzip.ErrTooLarge) {
+		logger.WarnWithCtx(r.Context()).Msgf("request body larger than %d bytes", maxContentLength)
+		return nil, err
+	}
 	if err != nil {
 		logger.ErrorWithCtxAndReason(r.Context(), "incomplete request").
 			Msgf("Error reading request body: %v", err)
 		return nil, err
 	}
 
-	switch r.Header.Get("Content-Encoding") {
-	case "":
+	contentEncoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch contentEncoding {
+	case "", "identity":
 		// No compression, leaving reqBody as-is
-	case "gzip":
-		reqBody, err = gzip.UnZip(reqBody)
+	case "gzip", "x-gzip":
+		reqBody, err = gzip.UnZip(reqBody, maxContentLength)
+		if errors.Is(err, gzip.ErrTooLarge) {
+			logger.WarnWithCtx(r.Context()).Msgf("decompressed request body larger than %d bytes", maxContentLength)
+			return nil, err
+		}
 		if err != nil {
 			logger.ErrorWithCtxAndReason(r.Context(), "invalid gzip body").
 				Msgf("Error decompressing gzip body: %v", err)
 			return nil, err
 		}
+	case "deflate":
+		reqBody, err = gzip.Inflate(reqBody, maxContentLength)
+		if errors.Is(err, gzip.ErrTooLarge) {
+			logger.WarnWithCtx(r.Context()).Msgf("decompressed request body larger than %d bytes", maxContentLength)
+			return nil, err
+		}
+		if err != nil {
+			logger.ErrorWithCtxAndReason(r.Context(), "invalid deflate body").
+				Msgf("Error decompressing deflate body: %v", err)
+			return nil, err
+		}
 	default:
 		logger.ErrorWithCtxAndReason(r.Context(), "unsupported Content-Encoding type").
-			Msgf("Unsupported Content-Encoding type: %v", err)
+			Msgf("Unsupported Content-Encoding type: %v", contentEncoding)
 		return nil, errors.New("unsupported Content-Encoding type")
 	}
+	if contentEncoding != "" {
+		// the body is decompressed now, also when it's forwarded to Elasticsearch
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = int64(len(reqBody))
+	}
 
 	r.Body = io.NopCloser(bytes.NewBuffer(reqBody))
 	return reqBody, nil
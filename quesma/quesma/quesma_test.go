@@ -3,12 +3,19 @@
 package quesma
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"quesma/logger"
 	"quesma/quesma/config"
+	"quesma/quesma/gzip"
+	"quesma/quesma/types"
 	"quesma/telemetry"
 	"testing"
 	"time"
@@ -55,3 +62,79 @@ func waitForHealthyQuesma(t *testing.T) {
 		}
 	}
 }
+
+func TestPeekBodyDecompressesRequestBody(t *testing.T) {
+	const searchBody = `{"query": {"match": {"message": "error"}}, "size": 10}`
+	deflate := func(newWriter func(w io.Writer) io.WriteCloser) []byte {
+		var b bytes.Buffer
+		w := newWriter(&b)
+		_, err := w.Write([]byte(searchBody))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		return b.Bytes()
+	}
+	gzipped, err := gzip.Zip([]byte(searchBody))
+	assert.NoError(t, err)
+	tests := []struct {
+		contentEncoding string
+		body            []byte
+	}{
+		{"", []byte(searchBody)},
+		{"gzip", gzipped},
+		{"GZIP", gzipped},
+		{"deflate", deflate(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"deflate", deflate(func(w io.Writer) io.WriteCloser { // raw deflate, without zlib header
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentEncoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/logs/_search", bytes.NewReader(tt.body))
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+			reqBody, err := peekBody(req, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, types.ParseRequestBody(searchBody), types.ParseRequestBody(string(reqBody)))
+
+			// the request is forwarded to Elasticsearch decompressed
+			assert.Empty(t, req.Header.Get("Content-Encoding"))
+			forwardedBody, err := io.ReadAll(req.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, searchBody, string(forwardedBody))
+		})
+	}
+}
+
+func TestPeekBodyInvalidContentEncoding(t *testing.T) {
+	for _, contentEncoding := range []string{"gzip", "deflate", "br"} {
+		req := httptest.NewRequest(http.MethodPost, "/logs/_search", bytes.NewReader([]byte(`{"size": 10}`)))
+		req.Header.Set("Content-Encoding", contentEncoding)
+		_, err := peekBody(req, 0)
+		assert.Error(t, err, contentEncoding)
+	}
+}
+
+func TestPeekBodyTooLarge(t *testing.T) {
+	const maxContentLength = 1000
+	largeBody := bytes.Repeat([]byte(" "), 100*maxContentLength) // compresses well below maxContentLength
+	gzipped, err := gzip.Zip(largeBody)
+	assert.NoError(t, err)
+	assert.Less(t, len(gzipped), maxContentLength)
+
+	for _, body := range [][]byte{largeBody, gzipped} {
+		req := httptest.NewRequest(http.MethodPost, "/logs/_search", bytes.NewReader(body))
+		if len(body) < maxContentLength {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		_, err := peekBody(req, maxContentLength)
+		assert.ErrorIs(t, err, gzip.ErrTooLarge)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/logs/_search", bytes.NewReader(gzipped))
+	req.Header.Set("Content-Encoding", "gzip")
+	reqBody, err := peekBody(req, len(largeBody))
+	assert.NoError(t, err)
+	assert.Equal(t, largeBody, reqBody)
+}
@@ -0,0 +1,39 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"net/url"
+	"quesma/logger"
+	"strconv"
+)
+
+// requestCacheURLParam returns `request_cache` URL param of the search, nil if it isn't set (then the query cache config decides)
+func requestCacheURLParam(queryParams url.Values) *bool {
+	value := queryParams.Get("request_cache")
+	if value == "" {
+		return nil
+	}
+	requestCache, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warn().Msgf("can't parse request_cache value: %s", value)
+		return nil
+	}
+	return &requestCache
+}
+
+// useQueryCache says if results of `sql` should be read from and stored in the query cache:
+//   - request_cache=false bypasses the cache,
+//   - request_cache=true caches even queries relative to now(), whose results can be stale then,
+//   - otherwise only queries, which the cache considers cacheable, are cached.
+//
+// Nothing is cached, when the query cache is disabled, whatever request_cache says.
+func (q *QueryRunner) useQueryCache(requestCache *bool, sql string) bool {
+	if q.queryCache == nil {
+		return false
+	}
+	if requestCache != nil {
+		return *requestCache
+	}
+	return q.queryCache.IsCacheable(sql)
+}
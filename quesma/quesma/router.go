@@ -6,15 +6,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"quesma/clickhouse"
 	"quesma/elasticsearch"
+	"quesma/health"
 	"quesma/logger"
 	"quesma/queryparser"
 	"quesma/quesma/config"
 	"quesma/quesma/errors"
 	"quesma/quesma/functionality/bulk"
+	"quesma/quesma/functionality/cluster_health"
+	"quesma/quesma/functionality/delete_by_query"
 	"quesma/quesma/functionality/doc"
 	"quesma/quesma/functionality/field_capabilities"
+	"quesma/quesma/functionality/ingest_pipeline"
 	"quesma/quesma/functionality/terms_enum"
 	"quesma/quesma/mux"
 	"quesma/quesma/routes"
@@ -41,8 +46,35 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 	and := mux.And
 
 	router := mux.NewPathRouter()
-	router.Register(routes.ClusterHealthPath, method("GET"), func(_ context.Context, req *mux.Request) (*mux.Result, error) {
-		return elasticsearchQueryResult(`{"cluster_name": "quesma"}`, httpOk), nil
+	var elasticChecker health.Checker
+	if cfg.Elasticsearch.Url != nil {
+		elasticChecker = health.NewElasticHealthChecker(cfg)
+	}
+	router.Register(routes.ClusterHealthPath, method("GET"), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		responseBody, err := cluster_health.HandleClusterHealth(ctx, cfg, lm, elasticChecker)
+		if err != nil {
+			return nil, err
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	})
+
+	router.Register(routes.IngestSimulatePath, method("GET", "POST"), func(_ context.Context, req *mux.Request) (*mux.Result, error) {
+		body, err := types.ExpectJSON(req.ParsedBody)
+		if err != nil {
+			return nil, err
+		}
+
+		responseBody, err := ingest_pipeline.HandleSimulate(body)
+		if err != nil {
+			if errors.Is(err, quesma_errors.ErrCouldNotParseRequest()) {
+				return &mux.Result{
+					Body:       string(queryparser.BadRequestParseError(err)),
+					StatusCode: 400,
+				}, nil
+			}
+			return nil, err
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
 	})
 
 	router.Register(routes.BulkPath, and(method("POST"), matchedAgainstBulkBody(cfg)), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
@@ -52,7 +84,7 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 			return nil, err
 		}
 
-		results := bulk.Write(ctx, nil, body, lm, cfg, phoneHomeAgent)
+		results := bulk.Write(ctx, nil, body, bulk.ParseWriteParams(req.QueryParams), lm, cfg, phoneHomeAgent)
 		return bulkInsertResult(results), nil
 	})
 
@@ -79,10 +111,33 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 			return nil, err
 		}
 
-		results := bulk.Write(ctx, &index, body, lm, cfg, phoneHomeAgent)
+		results := bulk.Write(ctx, &index, body, bulk.ParseWriteParams(req.QueryParams), lm, cfg, phoneHomeAgent)
 		return bulkInsertResult(results), nil
 	})
 
+	router.Register(routes.DeleteByQueryPath, and(method("POST"), matchedAgainstPattern(cfg)), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		body, err := types.ExpectJSON(req.ParsedBody)
+		if err != nil {
+			return nil, err
+		}
+
+		allowFullDelete := req.QueryParams.Get(delete_by_query.AllowFullDeleteParam) == "true"
+		responseBody, err := delete_by_query.HandleDeleteByQuery(ctx, req.Params["index"], body, allowFullDelete, cfg, sr, lm, &queryRunner.transformationPipeline)
+		if err != nil {
+			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
+				return &mux.Result{StatusCode: 404}, nil
+			} else if errors.Is(err, quesma_errors.ErrCouldNotParseRequest()) {
+				return &mux.Result{
+					Body:       string(queryparser.BadRequestParseError(err)),
+					StatusCode: 400,
+				}, nil
+			} else {
+				return nil, err
+			}
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	})
+
 	router.Register(routes.ResolveIndexPath, method("GET"), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
 		pattern := elasticsearch.NormalizePattern(req.Params["index"])
 		if elasticsearch.IsIndexPattern(pattern) {
@@ -172,7 +227,41 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 		}
 	})
 
-	router.Register(routes.GlobalSearchPath, and(method("GET", "POST"), matchAgainstKibanaInternal()), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+	// registered before the other searches, so they don't match searches starting a scroll
+	scrollSearchHandler := func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		body, err := types.ExpectJSON(req.ParsedBody)
+		if err != nil {
+			return nil, err
+		}
+		indexPattern := req.Params["index"]
+		if indexPattern == "" {
+			indexPattern = "*"
+		}
+
+		responseBody, err := queryRunner.handleScrollSearch(ctx, indexPattern, body, req.QueryParams.Get(scrollKey), parseSearchParams(req.QueryParams))
+		if err != nil {
+			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
+				return &mux.Result{StatusCode: 404}, nil
+			} else if errors.Is(err, quesma_errors.ErrCouldNotParseRequest()) {
+				return &mux.Result{
+					Body:       string(queryparser.BadRequestParseError(err)),
+					StatusCode: 400,
+				}, nil
+			} else if errors.Is(err, quesma_errors.ErrResultWindowTooLarge()) {
+				return &mux.Result{
+					Body:       string(queryparser.ResultWindowTooLargeError(err)),
+					StatusCode: 400,
+				}, nil
+			} else {
+				return nil, err
+			}
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	}
+	router.Register(routes.GlobalSearchPath, and(method("GET", "POST"), matchedAgainstScroll(), matchAgainstKibanaInternal()), scrollSearchHandler)
+	router.Register(routes.IndexSearchPath, and(method("GET", "POST"), matchedAgainstPattern(cfg), matchedAgainstScroll()), scrollSearchHandler)
+
+	router.Register(routes.GlobalSearchPath, and(method("GET", "POST"), matchedAgainstPointInTime(), matchAgainstKibanaInternal()), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
 
 		body, err := types.ExpectJSON(req.ParsedBody)
 		if err != nil {
@@ -180,25 +269,43 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 		}
 
 		// TODO we should pass JSON here instead of []byte
-		responseBody, err := queryRunner.handleSearch(ctx, "*", body)
+		responseBody, writeResponse, err := queryRunner.handleSearchStreamed(ctx, "*", body, parseSearchParams(req.QueryParams))
 		if err != nil {
 			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
 				return &mux.Result{StatusCode: 404}, nil
+			} else if errors.Is(err, quesma_errors.ErrPointInTimeNotFound()) {
+				return &mux.Result{
+					Body:       string(queryparser.PointInTimeNotFoundError(err)),
+					StatusCode: 404,
+				}, nil
+			} else if errors.Is(err, quesma_errors.ErrResultWindowTooLarge()) {
+				return &mux.Result{
+					Body:       string(queryparser.ResultWindowTooLargeError(err)),
+					StatusCode: 400,
+				}, nil
+			} else if errors.Is(err, quesma_errors.ErrTooManyBuckets()) {
+				return &mux.Result{
+					Body:       string(queryparser.TooManyBucketsError(err)),
+					StatusCode: 400,
+				}, nil
 			} else {
 				return nil, err
 			}
 		}
+		if writeResponse != nil {
+			return elasticsearchStreamedQueryResult(writeResponse, httpOk), nil
+		}
 		return elasticsearchQueryResult(string(responseBody), httpOk), nil
 	})
 
-	router.Register(routes.IndexSearchPath, and(method("GET", "POST"), matchedAgainstPattern(cfg)), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+	indexSearchHandler := func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
 
 		body, err := types.ExpectJSON(req.ParsedBody)
 		if err != nil {
 			return nil, err
 		}
 
-		responseBody, err := queryRunner.handleSearch(ctx, req.Params["index"], body)
+		responseBody, writeResponse, err := queryRunner.handleSearchStreamed(ctx, req.Params["index"], body, parseSearchParams(req.QueryParams))
 		if err != nil {
 			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
 				return &mux.Result{StatusCode: 404}, nil
@@ -207,12 +314,79 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 					Body:       string(queryparser.BadRequestParseError(err)),
 					StatusCode: 400,
 				}, nil
+			} else if errors.Is(err, quesma_errors.ErrResultWindowTooLarge()) {
+				return &mux.Result{
+					Body:       string(queryparser.ResultWindowTooLargeError(err)),
+					StatusCode: 400,
+				}, nil
+			} else if errors.Is(err, quesma_errors.ErrTooManyBuckets()) {
+				return &mux.Result{
+					Body:       string(queryparser.TooManyBucketsError(err)),
+					StatusCode: 400,
+				}, nil
 			} else {
 				return nil, err
 			}
 		}
+		if writeResponse != nil {
+			return elasticsearchStreamedQueryResult(writeResponse, httpOk), nil
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	}
+	router.Register(routes.IndexSearchPath, and(method("GET", "POST"), matchedAgainstPattern(cfg)), indexSearchHandler)
+	if cfg.LegacyTypes {
+		// clients from before mapping types removal search `/{index}/{type}/_search`, the type doesn't matter
+		router.Register(routes.IndexTypedSearchPath, and(method("GET", "POST"), matchedAgainstPattern(cfg)), indexSearchHandler)
+	}
+
+	multiSearchHandler := func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		body, err := types.ExpectNDJSON(req.ParsedBody)
+		if err != nil {
+			return nil, err
+		}
+
+		responseBody, err := queryRunner.handleMultiSearch(ctx, req.Params["index"], body)
+		if err != nil {
+			if errors.Is(err, quesma_errors.ErrCouldNotParseRequest()) {
+				return &mux.Result{
+					Body:       string(queryparser.BadRequestParseError(err)),
+					StatusCode: 400,
+				}, nil
+			}
+			return nil, err
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	}
+	router.Register(routes.MultiSearchPath, and(method("GET", "POST"), matchedAgainstMultiSearchBody(cfg)), multiSearchHandler)
+	router.Register(routes.IndexMultiSearchPath, and(method("GET", "POST"), matchedAgainstMultiSearchBody(cfg)), multiSearchHandler)
+
+	router.Register(routes.IndexPitPath, and(method("POST"), matchedAgainstPattern(cfg)), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		responseBody, err := queryRunner.handleOpenPointInTime(ctx, req.Params["index"], req.QueryParams.Get("keep_alive"))
+		if err != nil {
+			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
+				return &mux.Result{StatusCode: 404}, nil
+			} else {
+				return nil, err
+			}
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	})
+
+	router.Register(routes.PitPath, and(method("DELETE"), matchedAgainstPointInTimeId()), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		body, err := types.ExpectJSON(req.ParsedBody)
+		if err != nil {
+			return nil, err
+		}
+		responseBody, found, err := queryRunner.handleClosePointInTime(ctx, body)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return elasticsearchQueryResult(string(responseBody), 404), nil
+		}
 		return elasticsearchQueryResult(string(responseBody), httpOk), nil
 	})
+
 	router.Register(routes.IndexAsyncSearchPath, and(method("POST"), matchedAgainstPattern(cfg)), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
 		waitForResultsMs := 1000 // Defaults to 1 second as in docs
 		if v, ok := req.Params["wait_for_completion_timeout"]; ok {
@@ -234,7 +408,7 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 			return nil, err
 		}
 
-		responseBody, err := queryRunner.handleAsyncSearch(ctx, req.Params["index"], body, waitForResultsMs, keepOnCompletion)
+		responseBody, err := queryRunner.handleAsyncSearch(ctx, req.Params["index"], body, waitForResultsMs, keepOnCompletion, parseSearchParams(req.QueryParams))
 		if err != nil {
 			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
 				return &mux.Result{StatusCode: 404}, nil
@@ -243,6 +417,16 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 					Body:       string(queryparser.BadRequestParseError(err)),
 					StatusCode: 400,
 				}, nil
+			} else if errors.Is(err, quesma_errors.ErrResultWindowTooLarge()) {
+				return &mux.Result{
+					Body:       string(queryparser.ResultWindowTooLargeError(err)),
+					StatusCode: 400,
+				}, nil
+			} else if errors.Is(err, quesma_errors.ErrTooManyBuckets()) {
+				return &mux.Result{
+					Body:       string(queryparser.TooManyBucketsError(err)),
+					StatusCode: 400,
+				}, nil
 			} else {
 				return nil, err
 			}
@@ -267,6 +451,41 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 		return elasticsearchQueryResult(string(responseBody), httpOk), nil
 	})
 
+	router.Register(routes.ScrollPath, and(method("GET", "POST"), matchedAgainstScrollId()), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		body, _ := req.ParsedBody.(types.JSON) // scroll id and keep alive can be URL params as well
+		keepAlive, _ := body[scrollKey].(string)
+		if keepAlive == "" {
+			keepAlive = req.QueryParams.Get(scrollKey)
+		}
+		ids := scrollIdsFromRequest(body, req.QueryParams.Get(scrollIdKey))
+		responseBody, err := queryRunner.handleScroll(ctx, ids[0], keepAlive)
+		if err != nil {
+			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
+				return &mux.Result{StatusCode: 404}, nil
+			} else if errors.Is(err, quesma_errors.ErrScrollNotFound()) {
+				return &mux.Result{
+					Body:       string(queryparser.ScrollNotFoundError(err)),
+					StatusCode: 404,
+				}, nil
+			} else {
+				return nil, err
+			}
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	})
+
+	router.Register(routes.ScrollPath, and(method("DELETE"), matchedAgainstScrollId()), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		body, _ := req.ParsedBody.(types.JSON) // scroll ids can be a URL param as well
+		responseBody, found, err := queryRunner.handleClearScroll(ctx, scrollIdsFromRequest(body, req.QueryParams.Get(scrollIdKey)))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return elasticsearchQueryResult(string(responseBody), 404), nil
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	})
+
 	router.Register(routes.FieldCapsPath, and(method("GET", "POST"), matchedAgainstPattern(cfg)), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
 
 		responseBody, err := field_capabilities.HandleFieldCaps(ctx, cfg, sr, req.Params["index"], lm)
@@ -323,6 +542,28 @@ func configureRouter(cfg config.QuesmaConfiguration, sr schema.Registry, lm *cli
 
 	router.Register(routes.EQLSearch, and(method("GET", "POST"), matchedAgainstPattern(cfg)), eqlHandler)
 
+	router.Register(routes.SQLPath, and(method("GET", "POST"), matchedAgainstSQLQuery(cfg)), func(ctx context.Context, req *mux.Request) (*mux.Result, error) {
+		body, err := types.ExpectJSON(req.ParsedBody)
+		if err != nil {
+			return nil, err
+		}
+
+		responseBody, err := queryRunner.handleSQL(ctx, body, req.QueryParams.Get("format"))
+		if err != nil {
+			if errors.Is(quesma_errors.ErrIndexNotExists(), err) {
+				return &mux.Result{StatusCode: 404}, nil
+			} else if errors.Is(err, quesma_errors.ErrCouldNotParseRequest()) {
+				return &mux.Result{
+					Body:       string(queryparser.BadRequestParseError(err)),
+					StatusCode: 400,
+				}, nil
+			} else {
+				return nil, err
+			}
+		}
+		return elasticsearchQueryResult(string(responseBody), httpOk), nil
+	})
+
 	return router
 }
 
@@ -380,9 +621,21 @@ func elasticsearchQueryResult(body string, statusCode int) *mux.Result {
 	}, StatusCode: statusCode}
 }
 
+func elasticsearchStreamedQueryResult(writeBody func(w io.Writer) error, statusCode int) *mux.Result {
+	result := elasticsearchQueryResult("", statusCode)
+	result.BodyWriter = writeBody
+	return result
+}
+
 func bulkInsertResult(ops []bulk.WriteResult) *mux.Result {
+	errors := false
+	for _, op := range ops {
+		if op.Error != nil {
+			errors = true
+		}
+	}
 	body, err := json.Marshal(bulkResponse{
-		Errors: false,
+		Errors: errors,
 		Items:  toBulkItems(ops),
 		Took:   42,
 	})
@@ -433,10 +686,25 @@ func indexDocResult(index string, statusCode int) *mux.Result {
 	return elasticsearchInsertResult(string(body), statusCode)
 }
 
-func bulkSingleResult(opName string, index string) any {
+func bulkSingleResult(op bulk.WriteResult) any {
+	id := op.Id
+	if id == "" {
+		id = "fakeId"
+	}
+	result, status := "created", 201
+	switch op.Operation {
+	case "update":
+		result, status = "updated", httpOk
+	case "delete":
+		if op.NotFound {
+			result, status = "not_found", 404
+		} else {
+			result, status = "deleted", httpOk
+		}
+	}
 	response := bulkSingleResponse{
-		ID:          "fakeId",
-		Index:       index,
+		ID:          id,
+		Index:       op.Index,
 		PrimaryTerm: 1,
 		SeqNo:       0,
 		Shards: shardsResponse{
@@ -445,19 +713,36 @@ func bulkSingleResult(opName string, index string) any {
 			Total:      1,
 		},
 		Version: 0,
-		Result:  "created",
-		Status:  201,
+		Result:  result,
+		Status:  status,
 	}
-	if opName == "create" {
+	if op.Error != nil {
+		response = bulkSingleResponse{
+			ID:     op.Id,
+			Index:  op.Index,
+			Status: op.Error.Status,
+			Error:  &bulkErrorResponse{Type: op.Error.Type, Reason: op.Error.Reason},
+		}
+	}
+	switch op.Operation {
+	case "create":
 		return struct {
 			Create bulkSingleResponse `json:"create"`
 		}{Create: response}
-	} else if opName == "index" {
+	case "index":
 		return struct {
 			Index bulkSingleResponse `json:"index"`
 		}{Index: response}
-	} else {
-		panic("unsupported operation name: " + opName)
+	case "update":
+		return struct {
+			Update bulkSingleResponse `json:"update"`
+		}{Update: response}
+	case "delete":
+		return struct {
+			Delete bulkSingleResponse `json:"delete"`
+		}{Delete: response}
+	default:
+		panic("unsupported operation name: " + op.Operation)
 	}
 }
 
@@ -472,14 +757,19 @@ type (
 		Result      string         `json:"result"`
 	}
 	bulkSingleResponse struct {
-		ID          string         `json:"_id"`
-		Index       string         `json:"_index"`
-		PrimaryTerm int            `json:"_primary_term"`
-		SeqNo       int            `json:"_seq_no"`
-		Shards      shardsResponse `json:"_shards"`
-		Version     int            `json:"_version"`
-		Result      string         `json:"result"`
-		Status      int            `json:"status"`
+		ID          string             `json:"_id,omitempty"`
+		Index       string             `json:"_index"`
+		PrimaryTerm int                `json:"_primary_term,omitempty"`
+		SeqNo       int                `json:"_seq_no"`
+		Shards      shardsResponse     `json:"_shards"`
+		Version     int                `json:"_version"`
+		Result      string             `json:"result,omitempty"`
+		Status      int                `json:"status"`
+		Error       *bulkErrorResponse `json:"error,omitempty"`
+	}
+	bulkErrorResponse struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
 	}
 	bulkResponse struct {
 		Errors bool  `json:"errors"`
@@ -496,13 +786,16 @@ type (
 func toBulkItems(ops []bulk.WriteResult) []any {
 	var items []any
 	for _, op := range ops {
-		items = append(items, bulkSingleResult(op.Operation, op.Index))
+		items = append(items, bulkSingleResult(op))
 	}
 	return items
 }
 
 var indexNamePattern = regexp.MustCompile(`"_index"\s*:\s*"([^"]+)"`)
 
+// deleteOperationPattern matches `delete` operation line of _bulk
+var deleteOperationPattern = regexp.MustCompile(`^\s*\{\s*"delete"\s*:`)
+
 func extractIndexName(input string) string {
 	results := indexNamePattern.FindStringSubmatch(input)
 
@@ -172,6 +172,26 @@ func Test_matchedAgainstBulkBody(t *testing.T) {
 			config: indexConfig("logs-generic-default", true),
 			want:   false,
 		},
+		{
+			name:   "delete without document line",
+			body:   `{"delete":{"_index":"logs-generic-default","_id":"1"}}` + "\n" + `{"create":{"_index":"logs-generic-default"}}` + "\n{}\n",
+			config: indexConfig("logs-generic-default", true),
+			want:   true,
+		},
+		{
+			name:   "delete without document line, some tables not present",
+			body:   `{"delete":{"_index":"logs-generic-default","_id":"1"}}` + "\n" + `{"create":{"_index":"non-existent"}}` + "\n{}\n",
+			config: indexConfig("logs-generic-default", true),
+			want:   false,
+		},
+		{
+			name: "single index alias, config present",
+			body: `{"create":{"_index":"logs-alias"}}`,
+			config: config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+				"logs-generic-default": {Name: "logs-generic-default", Enabled: true, IndexAliases: []string{"logs-alias"}},
+			}},
+			want: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -181,3 +201,26 @@ func Test_matchedAgainstBulkBody(t *testing.T) {
 		})
 	}
 }
+
+func Test_typedSearchPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		legacyTypes bool
+		want        bool
+	}{
+		{name: "legacyTypes off", legacyTypes: false, want: false},
+		{name: "legacyTypes on", legacyTypes: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := indexConfig("logs-generic-default", true)
+			cfg.LegacyTypes = tt.legacyTypes
+			router := configureRouter(cfg, nil, nil, nil, nil, nil)
+
+			_, found := router.Matches(&mux.Request{Method: "POST", Path: "/logs-generic-default/_doc/_search"})
+			assert.Equal(t, tt.want, found)
+			_, found = router.Matches(&mux.Request{Method: "POST", Path: "/logs-generic-default/_search"})
+			assert.True(t, found)
+		})
+	}
+}
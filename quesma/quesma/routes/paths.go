@@ -8,17 +8,26 @@ import (
 
 const (
 	GlobalSearchPath     = "/_search"
+	ScrollPath           = "/_search/scroll"
 	IndexSearchPath      = "/:index/_search"
+	IndexTypedSearchPath = "/:index/:type/_search"
+	MultiSearchPath      = "/_msearch"
+	IndexMultiSearchPath = "/:index/_msearch"
 	IndexAsyncSearchPath = "/:index/_async_search"
 	IndexCountPath       = "/:index/_count"
+	IndexPitPath         = "/:index/_pit"
+	PitPath              = "/_pit"
 	IndexDocPath         = "/:index/_doc"
 	IndexRefreshPath     = "/:index/_refresh"
 	IndexBulkPath        = "/:index/_bulk"
+	DeleteByQueryPath    = "/:index/_delete_by_query"
 	FieldCapsPath        = "/:index/_field_caps"
 	TermsEnumPath        = "/:index/_terms_enum"
 	EQLSearch            = "/:index/_eql/search"
+	SQLPath              = "/_sql"
 	ResolveIndexPath     = "/_resolve/index/:index"
 	ClusterHealthPath    = "/_cluster/health"
+	IngestSimulatePath   = "/_ingest/pipeline/_simulate"
 	BulkPath             = "/_bulk"
 	AsyncSearchIdPrefix  = "/_async_search/"
 	AsyncSearchIdPath    = "/_async_search/:id"
@@ -27,11 +36,14 @@ const (
 
 var notQueryPaths = []string{
 	"_bulk",
+	"_delete_by_query",
 	"_doc",
 	"_field_caps",
 	"_health",
+	"_ingest",
 	"_resolve",
 	"_refresh",
+	"_pit",
 }
 
 func IsNotQueryPath(path string) bool {
@@ -0,0 +1,34 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"net/url"
+	"quesma/logger"
+	"quesma/util"
+)
+
+// routingURLParam returns routing values (`routing` URL param is a comma-separated list of them) of the search,
+// empty if it isn't routed
+func routingURLParam(queryParams url.Values) []string {
+	return splitURLParam(queryParams.Get("routing"))
+}
+
+// resolveRoutedTables replaces indexes in `sources`, which map the search routing to tables in config, with these tables,
+// so we don't scan the others at all. Indexes without (this) routing mapping are kept as they are.
+func (q *QueryRunner) resolveRoutedTables(ctx context.Context, sources []string, routing []string) []string {
+	if len(routing) == 0 {
+		return sources
+	}
+	result := make([]string, 0, len(sources))
+	for _, source := range sources {
+		if tables, ok := q.cfg.IndexConfig[source].RoutedTables(routing); ok {
+			logger.DebugWithCtx(ctx).Msgf("index %s, routing %v resolved to tables %v", source, routing, tables)
+			result = append(result, tables...)
+		} else {
+			result = append(result, source)
+		}
+	}
+	return util.Distinct(result)
+}
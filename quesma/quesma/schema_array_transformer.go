@@ -4,10 +4,10 @@ package quesma
 
 import (
 	"fmt"
-	"quesma/clickhouse"
 	"quesma/logger"
 	"quesma/model"
 	"quesma/schema"
+	"slices"
 	"strings"
 )
 
@@ -20,11 +20,9 @@ import (
 
 type ArrayTypeVisitor struct {
 	tableName string
-	table     *clickhouse.Table
 
 	// deps
 	schemaRegistry schema.Registry
-	logManager     *clickhouse.LogManager
 	schema         schema.Schema
 }
 
@@ -38,21 +36,14 @@ func (v *ArrayTypeVisitor) visitChildren(args []model.Expr) []model.Expr {
 	return newArgs
 }
 
-func (v *ArrayTypeVisitor) dbColumnType(fieldName string) string {
-
-	//
-	// This is a HACK to get the column database type from the schema
-	//
-	//
-	fieldName = strings.TrimSuffix(fieldName, ".keyword")
-
-	tableColumnName := strings.ReplaceAll(fieldName, ".", "::")
-	col, ok := v.table.Cols[tableColumnName]
-	if ok {
-		return col.Type.String()
+// arrayField returns the schema field of `fieldName`, if it's an array
+func (v *ArrayTypeVisitor) arrayField(fieldName string) (schema.Field, bool) {
+	field, found := v.schema.ResolveFieldWithMultiFields(fieldName)
+	if !found {
+		// inferred schema fields are named like columns
+		field, found = v.schema.ResolveFieldWithMultiFields(strings.ReplaceAll(fieldName, ".", "::"))
 	}
-
-	return ""
+	return field, found && field.Type.IsArray
 }
 
 func (v *ArrayTypeVisitor) VisitLiteral(e model.LiteralExpr) interface{} { return e }
@@ -60,15 +51,13 @@ func (v *ArrayTypeVisitor) VisitInfix(e model.InfixExpr) interface{} {
 
 	column, ok := e.Left.(model.ColumnRef)
 	if ok {
-		dbType := v.dbColumnType(column.ColumnName)
-
-		if strings.HasPrefix(dbType, "Array") {
+		if field, isArray := v.arrayField(column.ColumnName); isArray {
 
 			op := strings.ToUpper(e.Op)
 
 			switch {
 
-			case (op == "ILIKE" || op == "LIKE") && dbType == "Array(String)":
+			case (op == "ILIKE" || op == "LIKE") && field.Type.Equal(schema.TypeKeyword):
 
 				variableName := "x"
 				lambda := model.NewLambdaExpr([]string{variableName}, model.NewInfixExpr(model.NewLiteral(variableName), op, e.Right.Accept(v).(model.Expr)))
@@ -78,7 +67,7 @@ func (v *ArrayTypeVisitor) VisitInfix(e model.InfixExpr) interface{} {
 				return model.NewFunction("has", e.Left, e.Right.Accept(v).(model.Expr))
 
 			default:
-				logger.Warn().Msgf("Unhandled array infix operation  %s, column %v (%v)", e.Op, column.ColumnName, dbType)
+				logger.Warn().Msgf("Unhandled array infix operation  %s, column %v (array of %v)", e.Op, column.ColumnName, field.Type)
 			}
 		}
 	}
@@ -102,18 +91,17 @@ func (v *ArrayTypeVisitor) VisitFunction(e model.FunctionExpr) interface{} {
 		arg := e.Args[0]
 		column, ok := arg.(model.ColumnRef)
 		if ok {
-			dbType := v.dbColumnType(column.ColumnName)
-			if strings.HasPrefix(dbType, "Array") {
+			if field, isArray := v.arrayField(column.ColumnName); isArray {
 				switch {
 
-				case e.Name == "sumOrNull" && dbType == "Array(Int64)":
+				case e.Name == "sumOrNull" && field.Type.Equal(schema.TypeLong):
 					fnName := model.LiteralExpr{Value: fmt.Sprintf("'%s'", e.Name)}
 					wrapped := model.NewFunction("arrayReduce", fnName, column)
 					wrapped = model.NewFunction(e.Name, wrapped)
 					return wrapped
 
 				default:
-					logger.Warn().Msgf("Unhandled array function %s, column %v (%v)", e.Name, column.ColumnName, dbType)
+					logger.Warn().Msgf("Unhandled array function %s, column %v (array of %v)", e.Name, column.ColumnName, field.Type)
 
 				}
 			}
@@ -160,7 +148,7 @@ func (v *ArrayTypeVisitor) VisitDistinctExpr(e model.DistinctExpr) interface{} {
 	return model.NewDistinctExpr(e.Expr.Accept(v).(model.Expr))
 }
 func (v *ArrayTypeVisitor) VisitTableRef(e model.TableRef) interface{} {
-	return model.NewTableRef(e.Name)
+	return e
 }
 func (v *ArrayTypeVisitor) VisitAliasedExpr(e model.AliasedExpr) interface{} {
 	return model.NewAliasedExpr(e.Expr.Accept(v).(model.Expr), e.Alias)
@@ -185,14 +173,14 @@ func (v *ArrayTypeVisitor) VisitSelectCommand(e model.SelectCommand) interface{}
 	}
 	v.schema = sch
 
-	table := v.logManager.FindTable(v.tableName)
-	if table == nil {
-		logger.Error().Msgf("Table %s not found", v.tableName)
-		return e
+	// after ARRAY JOIN (e.g. nested aggregation) array columns hold single elements, so only the FROM may need a transformation
+	if len(e.ArrayJoin) > 0 {
+		if e.FromClause != nil {
+			e.FromClause = e.FromClause.Accept(v).(model.Expr)
+		}
+		return &e
 	}
 
-	v.table = table
-
 	// check if the query has array columns
 
 	var allColumns []model.ColumnRef
@@ -205,8 +193,7 @@ func (v *ArrayTypeVisitor) VisitSelectCommand(e model.SelectCommand) interface{}
 
 	hasArrayColumn := false
 	for _, col := range allColumns {
-		dbType := v.dbColumnType(col.ColumnName)
-		if strings.HasPrefix(dbType, "Array") {
+		if _, isArray := v.arrayField(col.ColumnName); isArray {
 			hasArrayColumn = true
 			break
 		}
@@ -238,8 +225,11 @@ func (v *ArrayTypeVisitor) VisitSelectCommand(e model.SelectCommand) interface{}
 		whereClause = e.WhereClause.Accept(v).(model.Expr)
 	}
 
-	return model.NewSelectCommand(columns, groupBy, e.OrderBy,
+	selectCommand := model.NewSelectCommand(columns, groupBy, e.OrderBy,
 		fromClause, whereClause, e.Limit, e.SampleLimit, e.IsDistinct)
+	selectCommand.Having = e.Having
+	selectCommand.ArrayJoin = e.ArrayJoin
+	return selectCommand
 
 }
 
@@ -254,3 +244,68 @@ func (v *ArrayTypeVisitor) VisitParenExpr(p model.ParenExpr) interface{} {
 func (v *ArrayTypeVisitor) VisitLambdaExpr(e model.LambdaExpr) interface{} {
 	return model.NewLambdaExpr(e.Args, e.Body.Accept(v).(model.Expr))
 }
+
+// applyArrayGroupByTransformation makes GROUP BY over an array column (e.g. terms aggregation over tags)
+// group by its elements, not by whole arrays. It uses ARRAY JOIN, but only over a subquery, which already applied WHERE,
+// so that filters still see whole arrays (and not their elements), e.g.
+//
+//	SELECT "tags", count() FROM "logs" WHERE has("tags",'a') GROUP BY "tags"
+//
+// becomes
+//
+//	SELECT "tags", count() FROM (SELECT "tags" FROM "logs" WHERE has("tags",'a')) ARRAY JOIN "tags" GROUP BY "tags"
+//
+// The subquery selects only columns used by the main query.
+func (s *SchemaCheckPass) applyArrayGroupByTransformation(query *model.Query) (*model.Query, error) {
+	selectCommand := &query.SelectCommand
+	if len(selectCommand.GroupBy) == 0 || len(selectCommand.ArrayJoin) > 0 {
+		return query, nil
+	}
+	if s.schemaRegistry == nil {
+		return query, nil
+	}
+	sch, exists := s.schemaRegistry.FindSchema(schema.TableName(getFromTable(query.TableName)))
+	if !exists {
+		return query, nil
+	}
+	visitor := &ArrayTypeVisitor{schema: sch}
+
+	var arrayColumns []model.Expr
+	for _, expr := range selectCommand.GroupBy {
+		if col, ok := expr.(model.ColumnRef); ok {
+			if _, isArray := visitor.arrayField(col.ColumnName); isArray {
+				arrayColumns = append(arrayColumns, col)
+			}
+		}
+	}
+	if len(arrayColumns) == 0 {
+		return query, nil
+	}
+	if _, isTable := selectCommand.FromClause.(model.TableRef); !isTable {
+		logger.Warn().Msgf("GROUP BY array column(s) %v over a subquery is not supported: %s", arrayColumns, selectCommand.String())
+		return query, nil
+	}
+
+	exprs := append(slices.Clone(selectCommand.Columns), selectCommand.GroupBy...)
+	for _, orderBy := range selectCommand.OrderBy {
+		exprs = append(exprs, orderBy.Exprs...)
+	}
+	var usedColumns []model.Expr
+	usedColumnNames := make(map[string]struct{})
+	for _, expr := range exprs {
+		for _, col := range model.GetUsedColumns(expr) {
+			if _, alreadyUsed := usedColumnNames[col.ColumnName]; !alreadyUsed {
+				usedColumnNames[col.ColumnName] = struct{}{}
+				usedColumns = append(usedColumns, col)
+			}
+		}
+	}
+
+	// SampleLimit limits the number of documents, so it goes to the subquery, before ARRAY JOIN
+	subquery := model.NewSelectCommand(usedColumns, nil, nil, selectCommand.FromClause, selectCommand.WhereClause, selectCommand.SampleLimit, 0, false)
+	selectCommand.FromClause = *subquery
+	selectCommand.ArrayJoin = arrayColumns
+	selectCommand.WhereClause = nil
+	selectCommand.SampleLimit = 0
+	return query, nil
+}
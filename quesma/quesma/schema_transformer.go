@@ -46,8 +46,11 @@ func (v *BoolLiteralVisitor) VisitSelectCommand(e model.SelectCommand) interface
 		fromClause = e.FromClause.Accept(v).(model.Expr)
 	}
 
-	return model.NewSelectCommand(e.Columns, e.GroupBy, e.OrderBy,
+	selectCommand := model.NewSelectCommand(e.Columns, e.GroupBy, e.OrderBy,
 		fromClause, whereClause, e.Limit, e.SampleLimit, e.IsDistinct)
+	selectCommand.Having = e.Having
+	selectCommand.ArrayJoin = e.ArrayJoin
+	return selectCommand
 }
 
 func (s *SchemaCheckPass) applyBooleanLiteralLowering(query *model.Query) (*model.Query, error) {
@@ -108,6 +111,10 @@ func (v *WhereVisitor) VisitInfix(e model.InfixExpr) interface{} {
 	}
 
 	field, found := dataScheme.ResolveField(lhsValue)
+	if !found {
+		// fields of inferred schemas are named like columns, without quotes
+		field, found = dataScheme.ResolveField(strings.Trim(lhsValue, `"`))
+	}
 	if !found {
 		logger.Error().Msgf("Field %s not found in schema for table %s, should never happen here", lhsValue, v.tableName)
 	}
@@ -184,8 +191,11 @@ func (v *WhereVisitor) VisitSelectCommand(e model.SelectCommand) interface{} {
 		fromClause = e.FromClause.Accept(v).(model.Expr)
 	}
 
-	return model.NewSelectCommand(e.Columns, e.GroupBy, e.OrderBy,
+	selectCommand := model.NewSelectCommand(e.Columns, e.GroupBy, e.OrderBy,
 		fromClause, whereClause, e.Limit, e.SampleLimit, e.IsDistinct)
+	selectCommand.Having = e.Having
+	selectCommand.ArrayJoin = e.ArrayJoin
+	return selectCommand
 }
 
 type SchemaCheckPass struct {
@@ -230,7 +240,7 @@ type GeoIpVisitor struct {
 }
 
 func (v *GeoIpVisitor) VisitTableRef(e model.TableRef) interface{} {
-	return model.NewTableRef(e.Name)
+	return e
 }
 
 func (v *GeoIpVisitor) VisitSelectCommand(e model.SelectCommand) interface{} {
@@ -282,8 +292,11 @@ func (v *GeoIpVisitor) VisitSelectCommand(e model.SelectCommand) interface{} {
 		fromClause = e.FromClause.Accept(v).(model.Expr)
 	}
 
-	return model.NewSelectCommand(columns, groupBy, e.OrderBy,
+	selectCommand := model.NewSelectCommand(columns, groupBy, e.OrderBy,
 		fromClause, e.WhereClause, e.Limit, e.SampleLimit, e.IsDistinct)
+	selectCommand.Having = e.Having
+	selectCommand.ArrayJoin = e.ArrayJoin
+	return selectCommand
 }
 
 func (s *SchemaCheckPass) applyGeoTransformations(query *model.Query) (*model.Query, error) {
@@ -297,10 +310,96 @@ func (s *SchemaCheckPass) applyGeoTransformations(query *model.Query) (*model.Qu
 	return query, nil
 }
 
+// GeoPolygonVisitor lowers abstract GEO_POLYGON("Location", lon1, lat1, lon2, lat2, ...) function
+// (generated by geo_polygon query) to Clickhouse
+// pointInPolygon(tuple("Location::lon","Location::lat"),[(lon1,lat1),(lon2,lat2),...])
+type GeoPolygonVisitor struct {
+	model.ExprVisitor
+	schemaInstance schema.Schema
+}
+
+func (v *GeoPolygonVisitor) VisitInfix(e model.InfixExpr) interface{} {
+	return model.NewInfixExpr(e.Left.Accept(v).(model.Expr), e.Op, e.Right.Accept(v).(model.Expr))
+}
+
+func (v *GeoPolygonVisitor) VisitPrefixExpr(e model.PrefixExpr) interface{} {
+	args := make([]model.Expr, 0, len(e.Args))
+	for _, arg := range e.Args {
+		args = append(args, arg.Accept(v).(model.Expr))
+	}
+	return model.NewPrefixExpr(e.Op, args)
+}
+
+func (v *GeoPolygonVisitor) VisitParenExpr(e model.ParenExpr) interface{} {
+	exprs := make([]model.Expr, 0, len(e.Exprs))
+	for _, expr := range e.Exprs {
+		exprs = append(exprs, expr.Accept(v).(model.Expr))
+	}
+	return model.NewParenExpr(exprs...)
+}
+
+func (v *GeoPolygonVisitor) VisitFunction(e model.FunctionExpr) interface{} {
+	const geoPolygonPrimitive = "GEO_POLYGON"
+	const pointInPolygonPrimitive = "pointInPolygon"
+	if e.Name != geoPolygonPrimitive {
+		args := make([]model.Expr, 0, len(e.Args))
+		for _, arg := range e.Args {
+			args = append(args, arg.Accept(v).(model.Expr))
+		}
+		return model.NewFunction(e.Name, args...)
+	}
+
+	col, ok := e.Args[0].(model.ColumnRef)
+	if !ok || len(e.Args)%2 != 1 {
+		logger.Error().Msgf("invalid arguments of %s: %v", geoPolygonPrimitive, e.Args)
+		return e
+	}
+	if v.schemaInstance.Fields[schema.FieldName(col.ColumnName)].Type.Name != schema.TypePoint.Name {
+		logger.Warn().Msgf("geo_polygon on field %s, which is not a geo point", col.ColumnName)
+		return e
+	}
+	points := make([]string, 0, len(e.Args)/2)
+	for i := 1; i < len(e.Args); i += 2 {
+		points = append(points, "("+model.AsString(e.Args[i])+","+model.AsString(e.Args[i+1])+")")
+	}
+	// TODO suffixes ::lat, ::lon are hardcoded for now
+	point := model.NewFunction("tuple", model.NewColumnRef(col.ColumnName+"::lon"), model.NewColumnRef(col.ColumnName+"::lat"))
+	return model.NewFunction(pointInPolygonPrimitive, point, model.NewLiteral("["+strings.Join(points, ",")+"]"))
+}
+
+func (v *GeoPolygonVisitor) VisitSelectCommand(e model.SelectCommand) interface{} {
+	if e.WhereClause != nil {
+		e.WhereClause = e.WhereClause.Accept(v).(model.Expr)
+	}
+	if e.FromClause != nil {
+		e.FromClause = e.FromClause.Accept(v).(model.Expr)
+	}
+	return &e
+}
+
+func (s *SchemaCheckPass) applyGeoPolygonTransformations(query *model.Query) (*model.Query, error) {
+	if s.schemaRegistry == nil {
+		logger.Error().Msg("Schema registry is not set")
+		return query, nil
+	}
+	fromTable := getFromTable(query.TableName)
+	schemaInstance, exists := s.schemaRegistry.FindSchema(schema.TableName(fromTable))
+	if !exists {
+		return query, nil
+	}
+
+	visitor := &GeoPolygonVisitor{ExprVisitor: model.NoOpVisitor{}, schemaInstance: schemaInstance}
+	expr := query.SelectCommand.Accept(visitor)
+	if _, ok := expr.(*model.SelectCommand); ok {
+		query.SelectCommand = *expr.(*model.SelectCommand)
+	}
+	return query, nil
+}
+
 func (s *SchemaCheckPass) applyArrayTransformations(query *model.Query) (*model.Query, error) {
 	fromTable := getFromTable(query.TableName)
 
-	visitor := &ArrayTypeVisitor{tableName: fromTable, schemaRegistry: s.schemaRegistry, logManager: s.logManager}
+	visitor := &ArrayTypeVisitor{tableName: fromTable, schemaRegistry: s.schemaRegistry}
 	expr := query.SelectCommand.Accept(visitor)
 	if _, ok := expr.(*model.SelectCommand); ok {
 		query.SelectCommand = *expr.(*model.SelectCommand)
@@ -318,7 +417,9 @@ func (s *SchemaCheckPass) Transform(queries []*model.Query) ([]*model.Query, err
 			{TransformationName: "BooleanLiteralTransformation", Transformation: s.applyBooleanLiteralLowering},
 			{TransformationName: "IpTransformation", Transformation: s.applyIpTransformations},
 			{TransformationName: "GeoTransformation", Transformation: s.applyGeoTransformations},
+			{TransformationName: "GeoPolygonTransformation", Transformation: s.applyGeoPolygonTransformations},
 			{TransformationName: "ArrayTransformation", Transformation: s.applyArrayTransformations},
+			{TransformationName: "ArrayGroupByTransformation", Transformation: s.applyArrayGroupByTransformation},
 		}
 		for _, transformation := range transformationChain {
 			inputQuery := query.SelectCommand.String()
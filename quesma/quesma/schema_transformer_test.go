@@ -254,3 +254,113 @@ func Test_ipRangeTransform(t *testing.T) {
 		assert.Equal(t, expectedQueries[k].SelectCommand.String(), resultQueries[0].SelectCommand.String())
 	}
 }
+
+func Test_ipRangeTransformInferredSchema(t *testing.T) {
+	indexConfig := map[string]config.IndexConfiguration{
+		"logs": {Name: "logs", Enabled: true},
+	}
+	tableDiscovery :=
+		fixedTableProvider{tables: map[string]schema.Table{
+			"logs": {Columns: map[string]schema.Column{
+				"client_ip": {Name: "client_ip", Type: "IPv4"},
+				"server_ip": {Name: "server_ip", Type: "IPv6"},
+				"message":   {Name: "message", Type: "String"},
+			}},
+		}}
+	s := schema.NewSchemaRegistry(tableDiscovery, config.QuesmaConfiguration{IndexConfig: indexConfig}, clickhouse.SchemaTypeAdapter{})
+	transform := &SchemaCheckPass{cfg: indexConfig, schemaRegistry: s, logManager: clickhouse.NewLogManagerEmpty()}
+
+	tests := []struct {
+		name     string
+		where    model.Expr
+		expected string
+	}{
+		{
+			name:     "IPv4 column",
+			where:    model.NewInfixExpr(model.NewColumnRef("client_ip"), "=", model.NewLiteral("'111.42.223.209/16'")),
+			expected: `SELECT * FROM logs WHERE isIPAddressInRange(CAST(client_ip,'String'),'111.42.223.209/16')`,
+		},
+		{
+			name:     "IPv6 column, quoted",
+			where:    model.NewInfixExpr(model.NewLiteral(strconv.Quote("server_ip")), "iLIKE", model.NewLiteral("'%2001:db8::/32%'")),
+			expected: `SELECT * FROM logs WHERE isIPAddressInRange(CAST("server_ip",'String'),'2001:db8::/32')`,
+		},
+		{
+			name:     "not an ip column",
+			where:    model.NewInfixExpr(model.NewColumnRef("message"), "=", model.NewLiteral("'a/b'")),
+			expected: `SELECT * FROM logs WHERE "message"='a/b'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &model.Query{
+				TableName: "logs",
+				SelectCommand: model.SelectCommand{
+					FromClause:  model.NewTableRef("logs"),
+					Columns:     []model.Expr{model.NewWildcardExpr},
+					WhereClause: tt.where,
+				},
+			}
+			resultQueries, err := transform.Transform([]*model.Query{query})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, resultQueries[0].SelectCommand.String())
+		})
+	}
+}
+
+func Test_geoPolygonTransform(t *testing.T) {
+	indexConfig := map[string]config.IndexConfiguration{
+		"kibana_sample_data_flights": {
+			Name:         "kibana_sample_data_flights",
+			Enabled:      true,
+			TypeMappings: map[string]string{"DestLocation": "geo_point"},
+		},
+	}
+	cfg := config.QuesmaConfiguration{
+		IndexConfig: indexConfig,
+	}
+	tableDiscovery :=
+		fixedTableProvider{tables: map[string]schema.Table{
+			"kibana_sample_data_flights": {Columns: map[string]schema.Column{
+				"DestLocation": {Name: "DestLocation", Type: "geo_point"},
+				"Carrier":      {Name: "Carrier", Type: "keyword"},
+			}},
+		}}
+	s := schema.NewSchemaRegistry(tableDiscovery, cfg, clickhouse.SchemaTypeAdapter{})
+	transform := &SchemaCheckPass{cfg: indexConfig, schemaRegistry: s, logManager: clickhouse.NewLogManagerEmpty()}
+
+	triangle := func(field string) model.Expr {
+		return model.NewFunction("GEO_POLYGON", model.NewColumnRef(field),
+			model.NewLiteral("-70"), model.NewLiteral("40"),
+			model.NewLiteral("-80"), model.NewLiteral("30"),
+			model.NewLiteral("-90"), model.NewLiteral("20"))
+	}
+	tests := []struct {
+		name      string
+		where     model.Expr
+		wantWhere string
+	}{
+		{"triangle", triangle("DestLocation"),
+			`pointInPolygon(tuple("DestLocation::lon","DestLocation::lat"),[(-70,40),(-80,30),(-90,20)])`},
+		{"triangle inside AND and NOT",
+			model.And([]model.Expr{model.NewInfixExpr(model.NewColumnRef("Carrier"), "=", model.NewLiteral("'Kibana Airlines'")),
+				model.NewPrefixExpr("NOT", []model.Expr{triangle("DestLocation")})}),
+			`("Carrier"='Kibana Airlines' AND NOT (pointInPolygon(tuple("DestLocation::lon","DestLocation::lat"),[(-70,40),(-80,30),(-90,20)])))`},
+		{"not a geo point", triangle("Carrier"), `GEO_POLYGON("Carrier",-70,40,-80,30,-90,20)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &model.Query{
+				TableName: "kibana_sample_data_flights",
+				SelectCommand: model.SelectCommand{
+					FromClause:  model.NewTableRef("kibana_sample_data_flights"),
+					Columns:     []model.Expr{model.NewWildcardExpr},
+					WhereClause: tt.where,
+				},
+			}
+			resultQueries, err := transform.Transform([]*model.Query{query})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantWhere, model.AsString(resultQueries[0].SelectCommand.WhereClause))
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"quesma/kibana"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	quesmaScrollIdPrefix   = "quesma_scroll_id_"
+	scrollKey              = "scroll"
+	scrollIdKey            = "scroll_id"
+	defaultScrollKeepAlive = 5 * time.Minute
+)
+
+var scrollId atomic.Int64
+
+// Scroll is a server-side cursor of a search, which is read in consecutive batches (e.g. by export tools like elasticdump).
+// We don't have snapshots, so it's the search and a checkpoint: sort values of the last hit returned,
+// which the next batch continues after, like with `search_after`.
+type Scroll struct {
+	indexPattern string
+	body         types.JSON // of the search, without `search_after`
+	params       searchParams
+	searchAfter  []any // nil <=> no batch returned any hits yet
+	keepAlive    time.Duration
+	lastUsed     time.Time
+	mu           sync.Mutex // held while a batch is running, so batches don't share a checkpoint
+}
+
+type clearScrollResponse struct {
+	Succeeded bool `json:"succeeded"`
+	NumFreed  int  `json:"num_freed"`
+}
+
+func parseScrollKeepAlive(keepAlive string) time.Duration {
+	duration, err := kibana.ParseInterval(keepAlive)
+	if err != nil || duration <= 0 {
+		logger.Warn().Msgf("invalid scroll keep alive value: %s, using default (%v)", keepAlive, defaultScrollKeepAlive)
+		return defaultScrollKeepAlive
+	}
+	return duration
+}
+
+func generateScrollId() string {
+	return quesmaScrollIdPrefix + strconv.FormatInt(scrollId.Add(1), 10)
+}
+
+// isQuesmaScroll returns true <=> scroll `id` was started by Quesma (otherwise it's Elasticsearch's)
+func isQuesmaScroll(id string) bool {
+	return strings.HasPrefix(id, quesmaScrollIdPrefix)
+}
+
+// scrollIdsFromRequest returns ids of scrolls in `body` of a scroll request (`scroll_id` is a single id or a list of them),
+// or in its `scroll_id` URL param, if there are none in the body
+func scrollIdsFromRequest(body types.JSON, scrollIdParam string) []string {
+	var ids []string
+	switch scrollIds := body[scrollIdKey].(type) {
+	case string:
+		ids = append(ids, scrollIds)
+	case []any:
+		for _, id := range scrollIds {
+			if id, ok := id.(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 && scrollIdParam != "" {
+		ids = append(ids, splitURLParam(scrollIdParam)...)
+	}
+	return ids
+}
+
+// handleScrollSearch starts a scroll of the search and returns its first batch.
+// Batches continue after the last hit of the previous one, so a search without sort is sorted by `_seq_no`,
+// the monotonic key of the index (like Elasticsearch's scrolls are in `_doc` order by default).
+func (q *QueryRunner) handleScrollSearch(ctx context.Context, indexPattern string, body types.JSON, keepAlive string, params searchParams) ([]byte, error) {
+	body = maps.Clone(body)
+	if _, ok := body["sort"]; !ok {
+		// without `_seq_no` there's no checkpoint to continue after, so we refuse the scroll instead of returning one, which can't be continued
+		for _, tableName := range q.scrollTables(indexPattern, body) {
+			if table := q.logManager.FindTable(tableName); table != nil && len(table.SeqNoFields) == 0 {
+				return nil, fmt.Errorf("%w: scroll of [%s] requires sort, table [%s] has no seqNoFields configured", quesma_errors.ErrCouldNotParseRequest(), indexPattern, tableName)
+			}
+		}
+		body["sort"] = []any{map[string]any{"_seq_no": "asc"}}
+	}
+	id := generateScrollId()
+	scroll := &Scroll{indexPattern: indexPattern, body: body, params: params,
+		keepAlive: parseScrollKeepAlive(keepAlive), lastUsed: time.Now()}
+	scroll.mu.Lock()
+	defer scroll.mu.Unlock()
+	q.Scrolls.Store(id, scroll)
+	logger.InfoWithCtx(ctx).Msgf("scroll %s started for [%s]", id, indexPattern)
+
+	responseBody, err := q.handleSearchCommon(ctx, indexPattern, body, nil, nil, &id, QueryLanguageDefault, params)
+	if err != nil {
+		q.Scrolls.Delete(id)
+	}
+	return responseBody, err
+}
+
+// scrollTables returns tables, which a scroll of the search in `indexPattern` reads from
+func (q *QueryRunner) scrollTables(indexPattern string, body types.JSON) []string {
+	if id := pointInTimeFromSearch(body); isQuesmaPointInTime(id) {
+		pit, _ := q.PointsInTime.Load(id)
+		return pit.tables
+	}
+	if sources, _, sourcesClickhouse := ResolveSources(indexPattern, q.cfg, q.im); sources == sourceClickhouse {
+		return sourcesClickhouse
+	}
+	return nil
+}
+
+// handleScroll returns the next batch of scroll `id` and extends its keep alive to `keepAlive`, if it's set.
+// Batches of the same scroll are serialized, so each one continues after the checkpoint of the previous one.
+func (q *QueryRunner) handleScroll(ctx context.Context, id, keepAlive string) ([]byte, error) {
+	scroll, found := q.Scrolls.Load(id)
+	if found {
+		scroll.mu.Lock()
+		defer scroll.mu.Unlock()
+		// it could have been cleared or ended, while we were waiting for the previous batch
+		current, stillFound := q.Scrolls.Load(id)
+		found = stillFound && current == scroll
+	}
+	if !found {
+		logger.WarnWithCtx(ctx).Msgf("scroll %s not found", id)
+		return nil, fmt.Errorf("%w: no search context found for id [%s]", quesma_errors.ErrScrollNotFound(), id)
+	}
+	if keepAlive != "" {
+		scroll.keepAlive = parseScrollKeepAlive(keepAlive)
+	}
+	scroll.lastUsed = time.Now()
+
+	// batches page through hits only, aggregations are returned with the first one
+	body := maps.Clone(scroll.body)
+	delete(body, "aggs")
+	delete(body, "aggregations")
+	if scroll.searchAfter != nil {
+		body["search_after"] = scroll.searchAfter
+	}
+	return q.handleSearchCommon(ctx, scroll.indexPattern, body, nil, nil, &id, QueryLanguageDefault, scroll.params)
+}
+
+// advanceScroll moves the checkpoint of scroll `id` after `hits` of the batch, which is being returned.
+// Its caller holds the lock of the scroll. Returns false, if the scroll can't be continued (then it's ended).
+func (q *QueryRunner) advanceScroll(ctx context.Context, id string, hits []model.SearchHit) bool {
+	scroll, found := q.Scrolls.Load(id)
+	if !found {
+		return false
+	}
+	if len(hits) == 0 {
+		return true
+	}
+	lastHit := hits[len(hits)-1]
+	if len(lastHit.Sort) == 0 {
+		// nothing to continue after (e.g. custom sort by nothing sortable), so we end the scroll instead of repeating the batch
+		logger.WarnWithCtx(ctx).Msgf("hits of scroll %s have no sort values, scroll can't be continued", id)
+		q.Scrolls.Delete(id)
+		return false
+	}
+	// as if they came in the request body, like `search_after` does
+	var searchAfter []any
+	sortAsJson, err := json.Marshal(lastHit.Sort)
+	if err == nil {
+		err = json.Unmarshal(sortAsJson, &searchAfter)
+	}
+	if err != nil {
+		logger.ErrorWithCtx(ctx).Msgf("invalid sort values %v of scroll %s: %v", lastHit.Sort, id, err)
+		q.Scrolls.Delete(id)
+		return false
+	}
+	scroll.searchAfter = searchAfter
+	return true
+}
+
+// handleClearScroll returns found = false if there was none of these scrolls (e.g. they expired already)
+func (q *QueryRunner) handleClearScroll(ctx context.Context, ids []string) (responseBody []byte, found bool, err error) {
+	response := clearScrollResponse{Succeeded: true}
+	for _, id := range ids {
+		if _, found := q.Scrolls.Load(id); found {
+			q.Scrolls.Delete(id)
+			response.NumFreed++
+			logger.InfoWithCtx(ctx).Msgf("scroll %s cleared", id)
+		}
+	}
+	responseBody, err = json.Marshal(response)
+	return responseBody, response.NumFreed > 0, err
+}
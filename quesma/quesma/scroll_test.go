@@ -0,0 +1,146 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/concurrent"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/quesma/config"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"quesma/quesma/ui"
+	"quesma/schema"
+	"quesma/telemetry"
+	"quesma/util"
+	"testing"
+	"time"
+)
+
+func TestScrollInTwoBatches(t *testing.T) {
+	const tableName = "events"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableName: {Name: tableName, Enabled: true, SeqNoFields: []string{"@timestamp", "id"}},
+	}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"id":         {Name: "id", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created:     true,
+		SeqNoFields: []string{"@timestamp", "id"},
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"id":         {PropertyName: "id", InternalPropertyName: "id", Type: schema.TypeLong},
+	}}}}
+	ts1 := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+	ts2 := time.Date(2024, 1, 1, 0, 0, 2, 0, time.UTC)
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+
+	// first batch, the search without sort is sorted by `_seq_no`
+	mock.ExpectQuery(`SELECT "@timestamp", "id" FROM "events" ORDER BY "@timestamp" ASC, "id" ASC LIMIT 2`).
+		WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "id"}).AddRow(ts1, int64(1)).AddRow(ts2, int64(2)))
+	body := types.MustJSON(`{"size": 2, "track_total_hits": false}`)
+	responseBody, err := queryRunner.handleScrollSearch(ctx, tableName, body, "1m", defaultSearchParams)
+	assert.NoError(t, err)
+	assert.Equal(t, types.MustJSON(`{"size": 2, "track_total_hits": false}`), body)
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(responseBody, &searchResponse))
+	assert.Len(t, searchResponse.Hits.Hits, 2)
+	if !assert.NotNil(t, searchResponse.ScrollID) {
+		return
+	}
+	id := *searchResponse.ScrollID
+	assert.True(t, isQuesmaScroll(id))
+	scroll, found := queryRunner.Scrolls.Load(id)
+	assert.True(t, found)
+	assert.Equal(t, time.Minute, scroll.keepAlive)
+
+	// second batch continues after the last hit of the first one
+	mock.ExpectQuery(`SELECT "@timestamp", "id" FROM "events" WHERE tuple\("@timestamp","id"\)>tuple\(fromUnixTimestamp64Milli\(1704067202000\),2\) ORDER BY "@timestamp" ASC, "id" ASC LIMIT 2`).
+		WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "id"}).AddRow(ts2, int64(3)))
+	responseBody, err = queryRunner.handleScroll(ctx, id, "5m")
+	assert.NoError(t, err)
+	searchResponse = model.SearchResp{}
+	assert.NoError(t, json.Unmarshal(responseBody, &searchResponse))
+	if assert.Len(t, searchResponse.Hits.Hits, 1) {
+		assert.Equal(t, []any{float64(1704067202000), float64(3)}, searchResponse.Hits.Hits[0].Sort)
+	}
+	if assert.NotNil(t, searchResponse.ScrollID) {
+		assert.Equal(t, id, *searchResponse.ScrollID)
+	}
+	scroll, _ = queryRunner.Scrolls.Load(id)
+	assert.Equal(t, 5*time.Minute, scroll.keepAlive)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// clear
+	responseBody, found, err = queryRunner.handleClearScroll(ctx, scrollIdsFromRequest(types.MustJSON(`{"scroll_id": ["`+id+`"]}`), ""))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.JSONEq(t, `{"succeeded": true, "num_freed": 1}`, string(responseBody))
+
+	// cleared scroll can't be continued anymore
+	_, err = queryRunner.handleScroll(ctx, id, "")
+	assert.ErrorIs(t, err, quesma_errors.ErrScrollNotFound())
+	responseBody, found, err = queryRunner.handleClearScroll(ctx, []string{id})
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.JSONEq(t, `{"succeeded": true, "num_freed": 0}`, string(responseBody))
+}
+
+func TestScrollWithoutSeqNoFieldsIsRejected(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableName: {Name: tableName, Enabled: true},
+	}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+
+	// there's no `_seq_no` to continue batches after, so no query is run and no scroll is started
+	_, err := queryRunner.handleScrollSearch(ctx, tableName, types.MustJSON(`{"size": 2}`), "1m", defaultSearchParams)
+	assert.ErrorIs(t, err, quesma_errors.ErrCouldNotParseRequest())
+	assert.Equal(t, 0, queryRunner.Scrolls.Size())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScrollEviction(t *testing.T) {
+	evictor := NewAsyncQueriesEvictor(EvictionInterval, concurrent.NewMap[string, AsyncRequestResult](),
+		concurrent.NewMap[string, *AsyncQueryContext](), concurrent.NewMap[string, PointInTime](), concurrent.NewMap[string, *Scroll]())
+	evictor.Scrolls.Store("short", &Scroll{keepAlive: time.Minute, lastUsed: time.Now()})
+	evictor.Scrolls.Store("long", &Scroll{keepAlive: time.Hour, lastUsed: time.Now()})
+	evictor.tryEvictScrolls(func(time.Time) time.Duration {
+		return 2 * time.Minute
+	})
+
+	_, found := evictor.Scrolls.Load("short")
+	assert.False(t, found)
+	_, found = evictor.Scrolls.Load("long")
+	assert.True(t, found)
+}
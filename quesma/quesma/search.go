@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"quesma/clickhouse"
 	"quesma/concurrent"
 	"quesma/elasticsearch"
@@ -14,6 +15,7 @@ import (
 	"quesma/model"
 	"quesma/plugins"
 	"quesma/plugins/registry"
+	"quesma/querycache"
 	"quesma/queryparser"
 	"quesma/queryparser/query_util"
 	"quesma/quesma/config"
@@ -31,11 +33,6 @@ import (
 	"time"
 )
 
-const (
-	asyncQueriesLimit      = 10000
-	asyncQueriesLimitBytes = 1024 * 1024 * 500 // 500MB
-)
-
 var asyncRequestId atomic.Int64
 
 type AsyncRequestResult struct {
@@ -57,6 +54,9 @@ type QueryRunner struct {
 	cancel                  context.CancelFunc
 	AsyncRequestStorage     *concurrent.Map[string, AsyncRequestResult]
 	AsyncQueriesContexts    *concurrent.Map[string, *AsyncQueryContext]
+	PointsInTime            *concurrent.Map[string, PointInTime]
+	Scrolls                 *concurrent.Map[string, *Scroll]
+	asyncSearchTableCreated atomic.Bool // the async search results table (if configured) has been created
 	logManager              *clickhouse.LogManager
 	cfg                     config.QuesmaConfiguration
 	im                      elasticsearch.IndexManagement
@@ -69,20 +69,55 @@ type QueryRunner struct {
 	currentParallelQueryJobs atomic.Int64
 	transformationPipeline   TransformationPipeline
 	schemaRegistry           schema.Registry
+	queryCache               *querycache.QueryCache // nil <=> cache is disabled
+
+	// metrics exposed by the management console
+	searchesCount      atomic.Int64
+	parseFailuresCount atomic.Int64
+	searchDuration     *ui.LatencyHistogram
 }
 
 func NewQueryRunner(lm *clickhouse.LogManager, cfg config.QuesmaConfiguration, im elasticsearch.IndexManagement, qmc *ui.QuesmaManagementConsole, schemaRegistry schema.Registry) *QueryRunner {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &QueryRunner{logManager: lm, cfg: cfg, im: im, quesmaManagementConsole: qmc,
+	var queryCache *querycache.QueryCache
+	if cfg.QueryCache.Enabled {
+		queryCache = querycache.NewQueryCache(cfg.QueryCache)
+		if qmc != nil {
+			qmc.SetQueryCacheStatsProvider(queryCache)
+		}
+	}
+
+	transformers := []plugins.QueryTransformer{
+		NewBaselineFilterPass(cfg.IndexConfig, lm, schemaRegistry),
+		&SchemaCheckPass{cfg: cfg.IndexConfig, schemaRegistry: schemaRegistry, logManager: lm}, // this can be a part of another plugin
+		&ListQueryLimitPass{maxLimit: cfg.GetMaxListQueryLimit()},
+	}
+	if cfg.PreWhere {
+		transformers = append(transformers, &PreWherePass{logManager: lm})
+	}
+
+	queryRunner := &QueryRunner{logManager: lm, cfg: cfg, im: im, quesmaManagementConsole: qmc,
 		executionCtx: ctx, cancel: cancel, AsyncRequestStorage: concurrent.NewMap[string, AsyncRequestResult](),
 		AsyncQueriesContexts: concurrent.NewMap[string, *AsyncQueryContext](),
+		PointsInTime:         concurrent.NewMap[string, PointInTime](),
+		Scrolls:              concurrent.NewMap[string, *Scroll](),
 		transformationPipeline: TransformationPipeline{
-			transformers: []plugins.QueryTransformer{
-				&SchemaCheckPass{cfg: cfg.IndexConfig, schemaRegistry: schemaRegistry, logManager: lm}, // this can be a part of another plugin
-			},
-		}, schemaRegistry: schemaRegistry}
+			transformers: transformers,
+		}, schemaRegistry: schemaRegistry, queryCache: queryCache, searchDuration: ui.NewLatencyHistogram()}
+	if qmc != nil {
+		qmc.SetQueryMetricsProvider(queryRunner)
+	}
+	return queryRunner
+}
 
+func (q *QueryRunner) QueryMetrics() ui.QueryMetrics {
+	return ui.QueryMetrics{
+		Searches:          q.searchesCount.Load(),
+		ParseFailures:     q.parseFailuresCount.Load(),
+		InFlightQueryJobs: q.currentParallelQueryJobs.Load(),
+		SearchDuration:    q.searchDuration.Snapshot(),
+	}
 }
 
 func NewAsyncQueryContext(ctx context.Context, cancel context.CancelFunc, id string) *AsyncQueryContext {
@@ -111,16 +146,65 @@ func (q *QueryRunner) handleCount(ctx context.Context, indexPattern string) (int
 	}
 }
 
+const defaultAllowPartialSearchResults = true
+
+// searchParams are URL params of the search request, which we support. We don't pass them in the body, so they don't
+// leak into anything derived from it: async search results, what the UI shows, etc.
+type searchParams struct {
+	allowPartialSearchResults bool
+	routing                   []string // empty <=> search isn't routed
+	requestCache              *bool    // nil <=> not set (then the query cache config decides)
+	sourceIncludes            []string // `_source_includes`, defaults for `_source` of the body
+	sourceExcludes            []string // `_source_excludes`, defaults for `_source` of the body
+}
+
+var defaultSearchParams = searchParams{allowPartialSearchResults: defaultAllowPartialSearchResults}
+
+func parseSearchParams(queryParams url.Values) searchParams {
+	return searchParams{
+		allowPartialSearchResults: allowPartialSearchResults(queryParams),
+		routing:                   routingURLParam(queryParams),
+		requestCache:              requestCacheURLParam(queryParams),
+		sourceIncludes:            splitURLParam(queryParams.Get("_source_includes")),
+		sourceExcludes:            splitURLParam(queryParams.Get("_source_excludes")),
+	}
+}
+
+// allowPartialSearchResults parses `allow_partial_search_results` URL param of the search request
+func allowPartialSearchResults(queryParams url.Values) bool {
+	value := queryParams.Get("allow_partial_search_results")
+	if value == "" {
+		return defaultAllowPartialSearchResults
+	}
+	allow, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warn().Msgf("can't parse allow_partial_search_results value: %s", value)
+		return defaultAllowPartialSearchResults
+	}
+	return allow
+}
+
+// splitURLParam splits a comma-separated list URL param, nil if it's empty
+func splitURLParam(param string) []string {
+	var values []string
+	for _, value := range strings.Split(param, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func (q *QueryRunner) handleSearch(ctx context.Context, indexPattern string, body types.JSON) ([]byte, error) {
-	return q.handleSearchCommon(ctx, indexPattern, body, nil, QueryLanguageDefault)
+	return q.handleSearchCommon(ctx, indexPattern, body, nil, nil, nil, QueryLanguageDefault, defaultSearchParams)
 }
 
 func (q *QueryRunner) handleEQLSearch(ctx context.Context, indexPattern string, body types.JSON) ([]byte, error) {
-	return q.handleSearchCommon(ctx, indexPattern, body, nil, QueryLanguageEQL)
+	return q.handleSearchCommon(ctx, indexPattern, body, nil, nil, nil, QueryLanguageEQL, defaultSearchParams)
 }
 
 func (q *QueryRunner) handleAsyncSearch(ctx context.Context, indexPattern string, body types.JSON,
-	waitForResultsMs int, keepOnCompletion bool) ([]byte, error) {
+	waitForResultsMs int, keepOnCompletion bool, params searchParams) ([]byte, error) {
 	async := AsyncQuery{
 		asyncRequestIdStr: generateAsyncRequestId(),
 		waitForResultsMs:  waitForResultsMs,
@@ -129,7 +213,7 @@ func (q *QueryRunner) handleAsyncSearch(ctx context.Context, indexPattern string
 	}
 	ctx = context.WithValue(ctx, tracing.AsyncIdCtxKey, async.asyncRequestIdStr)
 	logger.InfoWithCtx(ctx).Msgf("async search request id: %s started", async.asyncRequestIdStr)
-	return q.handleSearchCommon(ctx, indexPattern, body, &async, QueryLanguageDefault)
+	return q.handleSearchCommon(ctx, indexPattern, body, &async, nil, nil, QueryLanguageDefault, params)
 }
 
 type AsyncSearchWithError struct {
@@ -145,8 +229,24 @@ type AsyncQuery struct {
 	startTime         time.Time
 }
 
-func (q *QueryRunner) handleSearchCommon(ctx context.Context, indexPattern string, body types.JSON, optAsync *AsyncQuery, queryLanguage QueryLanguage) ([]byte, error) {
-	sources, sourcesElastic, sourcesClickhouse := ResolveSources(indexPattern, q.cfg, q.im)
+// handleSearchCommon runs the search. If params.allowPartialSearchResults is true, failure of some (but not all) of its SQL queries
+// isn't an error: we return results of the other queries, with failures reported in `_shards`.
+func (q *QueryRunner) handleSearchCommon(ctx context.Context, indexPattern string, body types.JSON, optAsync *AsyncQuery, optStream *streamedSearch, optScrollId *string,
+	queryLanguage QueryLanguage, params searchParams) ([]byte, error) {
+	var sources string
+	var sourcesElastic, sourcesClickhouse []string
+	var pitId *string
+	if id := pointInTimeFromSearch(body); isQuesmaPointInTime(id) {
+		pit, err := q.usePointInTime(ctx, id, body)
+		if err != nil {
+			return nil, err
+		}
+		pitId = &id
+		indexPattern = pit.indexPattern
+		sources, sourcesClickhouse = sourceClickhouse, pit.tables
+	} else {
+		sources, sourcesElastic, sourcesClickhouse = ResolveSources(indexPattern, q.cfg, q.im)
+	}
 
 	switch sources {
 	case sourceBoth:
@@ -173,6 +273,8 @@ func (q *QueryRunner) handleSearchCommon(ctx context.Context, indexPattern strin
 		}
 	case sourceClickhouse:
 		logger.Debug().Msgf("index pattern [%s] resolved to clickhouse tables: [%s]", indexPattern, sourcesClickhouse)
+		sourcesClickhouse = q.resolveRoutedTables(ctx, sourcesClickhouse, params.routing)
+		sourcesClickhouse = q.resolvePartitionedTables(ctx, sourcesClickhouse, body)
 		if elasticsearch.IsIndexPattern(indexPattern) {
 			sourcesClickhouse = q.removeNotExistingTables(sourcesClickhouse)
 		}
@@ -182,7 +284,8 @@ func (q *QueryRunner) handleSearchCommon(ctx context.Context, indexPattern strin
 	logger.Debug().Msgf("resolved sources for index pattern %s -> %s", indexPattern, sources)
 
 	if len(sourcesClickhouse) == 0 {
-		if elasticsearch.IsIndexPattern(indexPattern) {
+		// for time-partitioned index it just means no table overlaps with the query's time range
+		if elasticsearch.IsIndexPattern(indexPattern) || q.cfg.IndexConfig[indexPattern].TablePartitions != nil {
 			if optAsync != nil {
 				return queryparser.EmptyAsyncSearchResponse(optAsync.asyncRequestIdStr, false, 200)
 			} else {
@@ -192,9 +295,6 @@ func (q *QueryRunner) handleSearchCommon(ctx context.Context, indexPattern strin
 			logger.WarnWithCtx(ctx).Msgf("could not resolve any table name for [%s]", indexPattern)
 			return nil, quesma_errors.ErrIndexNotExists()
 		}
-	} else if len(sourcesClickhouse) > 1 { // async search never worked for multiple indexes, TODO fix
-		logger.WarnWithCtx(ctx).Msgf("requires union of multiple tables [%s], not yet supported, picking just one", indexPattern)
-		sourcesClickhouse = sourcesClickhouse[1:2]
 	}
 
 	var responseBody []byte
@@ -213,70 +313,35 @@ func (q *QueryRunner) handleSearchCommon(ctx context.Context, indexPattern strin
 		return nil, err
 	}
 
+	searches := make([]tableSearch, 0, len(sourcesClickhouse))
 	for _, resolvedTableName := range sourcesClickhouse {
 		var err error
-		doneCh := make(chan AsyncSearchWithError, 1)
 
 		table, _ := tables.Load(resolvedTableName)
 		if table == nil {
 			return []byte{}, end_user_errors.ErrNoSuchTable.New(fmt.Errorf("can't load %s table", resolvedTableName)).Details("Table: %s", resolvedTableName)
 		}
 
-		queryTranslator := NewQueryTranslator(ctx, queryLanguage, table, q.logManager, q.DateMathRenderer, q.schemaRegistry)
+		if err := checkResultWindow(body, table); err != nil {
+			return []byte{}, err
+		}
+
+		queryTranslator := NewQueryTranslator(ctx, queryLanguage, table, q.logManager, q.DateMathRenderer, q.schemaRegistry, params)
 
 		queries, canParse, err := queryTranslator.ParseQuery(body)
-		if err != nil {
-			logger.ErrorWithCtx(ctx).Msgf("parsing error: %v", err)
+		if errors.Is(err, quesma_errors.ErrCouldNotParseRequest()) || errors.Is(err, quesma_errors.ErrTooManyBuckets()) {
+			return []byte{}, err
 		}
-		queries, err = q.transformationPipeline.Transform(queries)
 		if err != nil {
-			logger.ErrorWithCtx(ctx).Msgf("error transforming queries: %v", err)
+			logger.ErrorWithCtx(ctx).Msgf("parsing error: %v", err)
 		}
-
-		queries, err = registry.QueryTransformerFor(table.Name, q.cfg).Transform(queries)
+		queries, err = q.transformQueries(table, queries)
 		if err != nil {
 			logger.ErrorWithCtx(ctx).Msgf("error transforming queries: %v", err)
 		}
 
-		if canParse {
-			if len(queries) > 0 && query_util.IsNonAggregationQuery(queries[0]) {
-				if properties := q.findNonexistingProperties(queries[0], table, queryTranslator); len(properties) > 0 {
-					logger.DebugWithCtx(ctx).Msgf("properties %s not found in table %s", properties, table.Name)
-					if elasticsearch.IsIndexPattern(indexPattern) {
-						return queryparser.EmptySearchResponse(ctx), nil
-					} else {
-						return nil, fmt.Errorf("properties %s not found in table %s", properties, table.Name)
-					}
-				}
-			}
-			go func() {
-				defer recovery.LogAndHandlePanic(ctx, func(err error) {
-					doneCh <- AsyncSearchWithError{err: err}
-				})
-
-				translatedQueryBody, results, err := q.searchWorker(ctx, queries, table, doneCh, optAsync)
-				if err != nil {
-					doneCh <- AsyncSearchWithError{err: err}
-					return
-				}
-
-				if len(results) == 0 {
-					logger.ErrorWithCtx(ctx).Msgf("no hits, sqls: %s", translatedQueryBody)
-					doneCh <- AsyncSearchWithError{translatedQueryBody: translatedQueryBody, err: errors.New("no hits")}
-					return
-				}
-
-				results, err = q.postProcessResults(table, results)
-				if err != nil {
-					doneCh <- AsyncSearchWithError{translatedQueryBody: translatedQueryBody, err: err}
-				}
-
-				searchResponse := queryTranslator.MakeSearchResponse(queries, results)
-
-				doneCh <- AsyncSearchWithError{response: searchResponse, translatedQueryBody: translatedQueryBody, err: err}
-			}()
-
-		} else {
+		if !canParse {
+			q.parseFailuresCount.Add(1)
 			queriesBody := ""
 			for _, query := range queries {
 				queriesBody += query.SelectCommand.String() + "\n"
@@ -288,40 +353,219 @@ func (q *QueryRunner) handleSearchCommon(ctx context.Context, indexPattern strin
 			return responseBody, errors.New(string(responseBody))
 		}
 
-		if optAsync == nil {
-			bodyAsBytes, _ := body.Bytes()
-			response := <-doneCh
-			if response.err != nil {
-				err = response.err
-				if len(queries) > 0 {
-					logger.ErrorWithCtx(ctx).Msgf("error making response: %v, queries[0]: %+v", err, queries[0])
+		if table.RejectUnboundedScans && hasUnboundedScan(queries) {
+			return []byte{}, end_user_errors.ErrUnboundedScan.New(fmt.Errorf("search without any filter on table %s", table.Name)).Details("Table: %s", table.Name)
+		}
+
+		if len(queries) > 0 && query_util.IsNonAggregationQuery(queries[0]) {
+			if properties := q.findNonexistingProperties(queries[0], table, queryTranslator); len(properties) > 0 {
+				logger.DebugWithCtx(ctx).Msgf("properties %s not found in table %s", properties, table.Name)
+				if len(sourcesClickhouse) > 1 {
+					continue // other tables can still have these properties
+				}
+				if elasticsearch.IsIndexPattern(indexPattern) {
+					return queryparser.EmptySearchResponse(ctx), nil
 				} else {
-					logger.ErrorWithCtx(ctx).Msgf("error making response: %v, queries empty", err)
+					return nil, fmt.Errorf("properties %s not found in table %s", properties, table.Name)
 				}
+			}
+		}
+
+		searches = append(searches, tableSearch{table: table, queryTranslator: queryTranslator, queries: queries, requestCache: params.requestCache,
+			timeout: searchTimeout(ctx, body, table)})
+	}
+
+	if len(searches) == 0 {
+		return queryparser.EmptySearchResponse(ctx), nil
+	}
+	if len(searches) > 1 && !canMergeResultsFromTables(searches[0].queries) {
+		logger.WarnWithCtx(ctx).Msgf("requires union of aggregations from multiple tables [%s], not supported for these aggregations, picking just one", indexPattern)
+		searches = searches[:1]
+	}
+	queries := searches[0].queries
+
+	if optStream != nil && optAsync == nil && len(searches) == 1 && q.canStreamHits(searches[0]) {
+		bodyAsBytes, _ := body.Bytes()
+		optStream.writeResponse, err = q.prepareStreamedSearch(ctx, searches[0], pitId, id, path, bodyAsBytes, startTime)
+		return nil, err
+	}
+
+	doneCh := make(chan AsyncSearchWithError, 1)
+	go func() {
+		defer recovery.LogAndHandlePanic(ctx, func(err error) {
+			doneCh <- AsyncSearchWithError{err: err}
+		})
+
+		translatedQueryBody, resultsPerTable, failures, timedOut, err := q.searchWorker(ctx, searches, doneCh, optAsync, params.allowPartialSearchResults)
+		if err != nil {
+			doneCh <- AsyncSearchWithError{err: err}
+			return
+		}
+
+		if len(resultsPerTable) == 0 || len(resultsPerTable[0]) == 0 {
+			logger.ErrorWithCtx(ctx).Msgf("no hits, sqls: %s", translatedQueryBody)
+			doneCh <- AsyncSearchWithError{translatedQueryBody: translatedQueryBody, err: errors.New("no hits")}
+			return
+		}
+
+		for i, search := range searches {
+			resultsPerTable[i], err = q.postProcessResults(search.table, resultsPerTable[i])
+			if err != nil {
+				doneCh <- AsyncSearchWithError{translatedQueryBody: translatedQueryBody, err: err}
+				return
+			}
+		}
+
+		results := resultsPerTable[0]
+		if len(resultsPerTable) > 1 {
+			results, err = mergeResultsFromTables(searches, resultsPerTable)
+			if err != nil {
+				doneCh <- AsyncSearchWithError{translatedQueryBody: translatedQueryBody, err: err}
+				return
+			}
+		}
+		searchResponse := searches[0].queryTranslator.MakeSearchResponse(queries, results)
+		searchResponse.PitID = pitId
+		searchResponse.Timeout = timedOut
+		searchResponse.Shards = searchShards(len(searches), failures)
+		if optScrollId != nil && q.advanceScroll(ctx, *optScrollId, searchResponse.Hits.Hits) {
+			searchResponse.ScrollID = optScrollId
+		}
+
+		doneCh <- AsyncSearchWithError{response: searchResponse, translatedQueryBody: translatedQueryBody, err: err}
+	}()
+
+	if optAsync == nil {
+		bodyAsBytes, _ := body.Bytes()
+		response := <-doneCh
+		if response.err != nil {
+			err = response.err
+			if len(queries) > 0 {
+				logger.ErrorWithCtx(ctx).Msgf("error making response: %v, queries[0]: %+v", err, queries[0])
 			} else {
-				responseBody, err = response.response.Marshal()
+				logger.ErrorWithCtx(ctx).Msgf("error making response: %v, queries empty", err)
 			}
-			pushSecondaryInfo(q.quesmaManagementConsole, id, path, bodyAsBytes, response.translatedQueryBody, responseBody, startTime)
-			return responseBody, err
 		} else {
-			select {
-			case <-time.After(time.Duration(optAsync.waitForResultsMs) * time.Millisecond):
-				go func() { // Async search takes longer. Return partial results and wait for
-					recovery.LogPanicWithCtx(ctx)
-					res := <-doneCh
-					q.storeAsyncSearch(q.quesmaManagementConsole, id, optAsync.asyncRequestIdStr, optAsync.startTime, path, body, res, true)
-				}()
-				return q.handlePartialAsyncSearch(ctx, optAsync.asyncRequestIdStr)
-			case res := <-doneCh:
-				responseBody, err = q.storeAsyncSearch(q.quesmaManagementConsole, id, optAsync.asyncRequestIdStr, optAsync.startTime, path, body, res,
-					optAsync.keepOnCompletion)
-
-				return responseBody, err
+			responseBody, err = response.response.Marshal()
+		}
+		pushSecondaryInfo(q.quesmaManagementConsole, id, path, bodyAsBytes, response.translatedQueryBody, responseBody, startTime)
+		return responseBody, err
+	} else {
+		select {
+		case <-time.After(time.Duration(optAsync.waitForResultsMs) * time.Millisecond):
+			go func() { // Async search takes longer. Return partial results and wait for
+				recovery.LogPanicWithCtx(ctx)
+				res := <-doneCh
+				q.storeAsyncSearch(q.quesmaManagementConsole, id, optAsync.asyncRequestIdStr, optAsync.startTime, path, body, res, true)
+				q.removeAsyncQueryContext(optAsync.asyncRequestIdStr)
+			}()
+			return q.handlePartialAsyncSearch(ctx, optAsync.asyncRequestIdStr)
+		case res := <-doneCh:
+			responseBody, err = q.storeAsyncSearch(q.quesmaManagementConsole, id, optAsync.asyncRequestIdStr, optAsync.startTime, path, body, res,
+				optAsync.keepOnCompletion)
+			q.removeAsyncQueryContext(optAsync.asyncRequestIdStr)
+
+			return responseBody, err
+		}
+	}
+}
+
+// hasUnboundedScan returns true <=> any of `queries` reads from its table without any filter
+func hasUnboundedScan(queries []*model.Query) bool {
+	for _, query := range queries {
+		if query.NoDBQuery {
+			continue
+		}
+		// the innermost query reads directly from the table
+		selectCommand := &query.SelectCommand
+		for {
+			if from, ok := selectCommand.FromClause.(model.SelectCommand); ok {
+				selectCommand = &from
+			} else if from, ok := selectCommand.FromClause.(*model.SelectCommand); ok {
+				selectCommand = from
+			} else {
+				break
 			}
 		}
+		if selectCommand.WhereClause == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResultWindow returns an error, if a search requests hits beyond the table's max result window
+// (Elasticsearch's `index.max_result_window`). Searches with `search_after` aren't limited, as it's the way to page deeper.
+func checkResultWindow(body types.JSON, table *clickhouse.Table) error {
+	if _, ok := body["search_after"]; ok {
+		return nil
+	}
+	maxResultWindow := table.MaxResultWindow
+	if maxResultWindow <= 0 {
+		maxResultWindow = config.DefaultMaxResultWindow
+	}
+	from, size := 0, model.DefaultSizeListQuery
+	if fromRaw, ok := body["from"].(float64); ok {
+		from = int(fromRaw)
+	}
+	if sizeRaw, ok := body["size"].(float64); ok {
+		size = int(sizeRaw)
+	}
+	if from+size > maxResultWindow {
+		return fmt.Errorf("%w, from + size must be less than or equal to: [%d] but was [%d]. See the scroll api for a more efficient way to request large data sets. This limit can be set by changing the [index.max_result_window] index level setting.",
+			quesma_errors.ErrResultWindowTooLarge(), maxResultWindow, from+size)
+	}
+	return nil
+}
+
+// transformQueries applies all query transformations (ours and plugins') to `queries` of `table`
+func (q *QueryRunner) transformQueries(table *clickhouse.Table, queries []*model.Query) ([]*model.Query, error) {
+	queries, err := q.transformationPipeline.Transform(queries)
+	if err != nil {
+		return queries, err
+	}
+	return registry.QueryTransformerFor(table.Name, q.cfg).Transform(queries)
+}
+
+// searchTimeout returns the timeout of a search: its `timeout` (in Elasticsearch time units, e.g. "10s"),
+// or the table's default, if it isn't set (or is invalid). 0 <=> no timeout.
+func searchTimeout(ctx context.Context, body types.JSON, table *clickhouse.Table) time.Duration {
+	return requestTimeout(ctx, body, "timeout", table)
+}
+
+// requestTimeout returns timeout from `param` of the request body, or the table's default, like searchTimeout
+func requestTimeout(ctx context.Context, body types.JSON, param string, table *clickhouse.Table) time.Duration {
+	timeoutRaw, ok := body[param].(string)
+	if !ok {
+		return table.QueryTimeout
+	}
+	timeout, err := parseTimeValue(timeoutRaw)
+	if err != nil {
+		logger.WarnWithCtx(ctx).Msgf("invalid %s of request: %v, using the default: %s", param, err, table.QueryTimeout)
+		return table.QueryTimeout
 	}
+	return timeout
+}
 
-	return responseBody, nil
+// parseTimeValue parses Elasticsearch time units: "d", "h", "m", "s", "ms", "micros", "nanos", e.g. "1.5s"
+func parseTimeValue(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	// suffixes, which are suffixes of others, go after them
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{{"nanos", time.Nanosecond}, {"micros", time.Microsecond}, {"ms", time.Millisecond}, {"d", 24 * time.Hour},
+		{"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}}
+	for _, u := range units {
+		if number, found := strings.CutSuffix(value, u.suffix); found {
+			amount, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil || amount < 0 {
+				return 0, fmt.Errorf("invalid time value: %s", value)
+			}
+			return time.Duration(amount * float64(u.unit)), nil
+		}
+	}
+	return 0, fmt.Errorf("time value without unit: %s", value)
 }
 
 func (q *QueryRunner) removeNotExistingTables(sourcesClickhouse []string) []string {
@@ -337,7 +581,7 @@ func (q *QueryRunner) storeAsyncSearch(qmc *ui.QuesmaManagementConsole, id, asyn
 	took := time.Since(startTime)
 	if result.err != nil {
 		if keep {
-			q.AsyncRequestStorage.Store(asyncRequestIdStr, AsyncRequestResult{err: result.err, added: time.Now(),
+			q.keepAsyncSearch(asyncRequestIdStr, AsyncRequestResult{err: result.err, added: time.Now(),
 				isCompressed: false})
 		}
 		responseBody, _ = queryparser.EmptyAsyncSearchResponse(asyncRequestIdStr, false, 503)
@@ -373,12 +617,82 @@ func (q *QueryRunner) storeAsyncSearch(qmc *ui.QuesmaManagementConsole, id, asyn
 				isCompressed = true
 			}
 		}
-		q.AsyncRequestStorage.Store(asyncRequestIdStr,
+		q.keepAsyncSearch(asyncRequestIdStr,
 			AsyncRequestResult{responseBody: compressedBody, added: time.Now(), err: err, isCompressed: isCompressed})
 	}
 	return
 }
 
+// keepAsyncSearch stores an async result in memory, and persists it to the async search results table, if it's configured.
+// With the table, results which don't fit into memory limits are only persisted.
+func (q *QueryRunner) keepAsyncSearch(id string, result AsyncRequestResult) {
+	table := q.cfg.AsyncSearch.Table
+	if table == "" || !q.asyncStorageFull() {
+		q.AsyncRequestStorage.Store(id, result)
+	}
+	if table == "" {
+		return
+	}
+	if err := q.ensureAsyncSearchTable(q.executionCtx); err != nil {
+		logger.ErrorWithCtx(q.executionCtx).Msgf("cannot persist async query %s: %v", id, err)
+		return
+	}
+	persisted := clickhouse.AsyncSearchResult{Id: id, Added: result.added, Response: result.responseBody, IsCompressed: result.isCompressed}
+	if result.err != nil {
+		persisted.Error = result.err.Error()
+	}
+	if err := q.logManager.StoreAsyncSearchResult(q.executionCtx, table, persisted); err != nil {
+		logger.ErrorWithCtx(q.executionCtx).Msgf("cannot persist async query %s: %v", id, err)
+	}
+}
+
+// loadPersistedAsyncSearch returns an async result from the async search results table, if it's configured
+func (q *QueryRunner) loadPersistedAsyncSearch(ctx context.Context, id string) (AsyncRequestResult, bool) {
+	table := q.cfg.AsyncSearch.Table
+	if table == "" {
+		return AsyncRequestResult{}, false
+	}
+	if err := q.ensureAsyncSearchTable(ctx); err != nil {
+		logger.ErrorWithCtx(ctx).Msgf("cannot load persisted async query %s: %v", id, err)
+		return AsyncRequestResult{}, false
+	}
+	persisted, found, err := q.logManager.LoadAsyncSearchResult(ctx, table, id, q.cfg.AsyncSearch.GetEvictionTime())
+	if err != nil {
+		logger.ErrorWithCtx(ctx).Msgf("cannot load persisted async query %s: %v", id, err)
+		return AsyncRequestResult{}, false
+	}
+	if !found {
+		return AsyncRequestResult{}, false
+	}
+	result := AsyncRequestResult{responseBody: persisted.Response, added: persisted.Added, isCompressed: persisted.IsCompressed}
+	if persisted.Error != "" {
+		result.err = errors.New(persisted.Error)
+	}
+	return result, true
+}
+
+// forgetAsyncSearch deletes an async result both from memory and from the async search results table
+func (q *QueryRunner) forgetAsyncSearch(ctx context.Context, id string) {
+	q.AsyncRequestStorage.Delete(id)
+	if table := q.cfg.AsyncSearch.Table; table != "" && q.asyncSearchTableCreated.Load() {
+		if err := q.logManager.DeleteAsyncSearchResult(ctx, table, id); err != nil {
+			logger.ErrorWithCtx(ctx).Msgf("cannot delete persisted async query %s: %v", id, err)
+		}
+	}
+}
+
+// ensureAsyncSearchTable creates the async search results table once, its rows expire with the eviction time
+func (q *QueryRunner) ensureAsyncSearchTable(ctx context.Context) error {
+	if q.asyncSearchTableCreated.Load() {
+		return nil
+	}
+	if err := q.logManager.CreateAsyncSearchResultsTable(ctx, q.cfg.AsyncSearch.Table, q.cfg.AsyncSearch.GetEvictionTime()); err != nil {
+		return err
+	}
+	q.asyncSearchTableCreated.Store(true)
+	return nil
+}
+
 func (q *QueryRunner) asyncQueriesCumulatedBodySize() int {
 	size := 0
 	q.AsyncRequestStorage.Range(func(key string, value AsyncRequestResult) bool {
@@ -397,13 +711,18 @@ func (q *QueryRunner) handlePartialAsyncSearch(ctx context.Context, id string) (
 		logger.ErrorWithCtx(ctx).Msgf("non quesma async id: %v", id)
 		return queryparser.EmptyAsyncSearchResponse(id, false, 503)
 	}
-	if result, ok := q.AsyncRequestStorage.Load(id); ok {
+	result, ok := q.AsyncRequestStorage.Load(id)
+	if _, running := q.AsyncQueriesContexts.Load(id); !ok && !running {
+		// the result could have been evicted from memory (or not fit into it), or stored before a restart
+		result, ok = q.loadPersistedAsyncSearch(ctx, id)
+	}
+	if ok {
+		// a persisted result stays in the table, until it expires, so it can be read again
+		q.AsyncRequestStorage.Delete(id)
 		if result.err != nil {
-			q.AsyncRequestStorage.Delete(id)
 			logger.ErrorWithCtx(ctx).Msgf("error processing async query: %v", result.err)
 			return queryparser.EmptyAsyncSearchResponse(id, false, 503)
 		}
-		q.AsyncRequestStorage.Delete(id)
 		// We use zstd to conserve memory, as we have a lot of async queries
 		if result.isCompressed {
 			buf, err := util.Decompress(result.responseBody)
@@ -431,12 +750,14 @@ func (q *QueryRunner) deleteAsyncSeach(id string) ([]byte, error) {
 	if !strings.Contains(id, "quesma_async_search_id_") {
 		return nil, errors.New("invalid quesma async search id : " + id)
 	}
-	q.AsyncRequestStorage.Delete(id)
+	q.forgetAsyncSearch(q.executionCtx, id)
 	return []byte{}, nil
 }
 
 func (q *QueryRunner) reachedQueriesLimit(ctx context.Context, asyncRequestIdStr string, doneCh chan<- AsyncSearchWithError) bool {
-	if q.AsyncRequestStorage.Size() < asyncQueriesLimit && q.asyncQueriesCumulatedBodySize() < asyncQueriesLimitBytes {
+	// results, which don't fit into memory, are still persisted to the table, but running queries are always limited
+	running := q.AsyncQueriesContexts.Size() >= q.cfg.AsyncSearch.GetRunningLimit()
+	if !running && (q.cfg.AsyncSearch.Table != "" || !q.asyncStorageFull()) {
 		return false
 	}
 	err := errors.New("too many async queries")
@@ -445,10 +766,23 @@ func (q *QueryRunner) reachedQueriesLimit(ctx context.Context, asyncRequestIdStr
 	return true
 }
 
+func (q *QueryRunner) asyncStorageFull() bool {
+	return q.AsyncRequestStorage.Size() >= q.cfg.AsyncSearch.GetQueriesLimit() || q.asyncQueriesCumulatedBodySize() >= q.cfg.AsyncSearch.GetQueriesLimitBytes()
+}
+
 func (q *QueryRunner) addAsyncQueryContext(ctx context.Context, cancel context.CancelFunc, asyncRequestIdStr string) {
 	q.AsyncQueriesContexts.Store(asyncRequestIdStr, NewAsyncQueryContext(ctx, cancel, asyncRequestIdStr))
 }
 
+// removeAsyncQueryContext cancels and removes context of a finished async query. Only running queries have a context,
+// so it's removed after the result is stored: then a finished query isn't counted, and its result can be looked up.
+func (q *QueryRunner) removeAsyncQueryContext(asyncRequestIdStr string) {
+	if asyncQueryContext, found := q.AsyncQueriesContexts.Load(asyncRequestIdStr); found {
+		q.AsyncQueriesContexts.Delete(asyncRequestIdStr)
+		asyncQueryContext.cancel()
+	}
+}
+
 // This is a HACK
 // This should be removed when we have a schema resolver working.
 // It ignores queries against data_stream fields. These queries are kibana internal ones.
@@ -524,7 +858,7 @@ func (q *QueryRunner) runQueryJobsParallel(jobs []QueryJob) ([][]model.QueryResu
 }
 
 func (q *QueryRunner) runQueryJobs(jobs []QueryJob) ([][]model.QueryResultRow, error) {
-	const maxParallelQueries = 25 // this is arbitrary value
+	maxParallelQueries := int64(q.cfg.GetMaxParallelQueries())
 
 	numberOfJobs := len(jobs)
 
@@ -553,82 +887,172 @@ func (q *QueryRunner) runQueryJobs(jobs []QueryJob) ([][]model.QueryResultRow, e
 
 }
 
+// tableSearch is a search request translated to queries for one of the tables, which the index pattern resolved to
+type tableSearch struct {
+	table           *clickhouse.Table
+	queryTranslator IQueryTranslator
+	queries         []*model.Query
+	requestCache    *bool         // `request_cache` of the search, nil <=> not set
+	timeout         time.Duration // of the search (its `timeout`, or the index's default), 0 <=> no timeout
+}
+
+// searchShards returns `_shards` of the response of `searches` tables (each of them is a shard), some of them `failed`
+func searchShards(searches int, failed []model.ResponseShardsFailure) model.ResponseShards {
+	return model.ResponseShards{Total: searches, Successful: searches - len(failed), Failed: len(failed), Failures: failed}
+}
+
+// searchWorkerCommon runs queries for all tables at once, so for multiple tables they're run in parallel.
+// hits[i] are results for searches[i].
+// If allowPartialResults is true, failed queries have empty results and are reported in `failures`,
+// and err is returned only if all the queries failed.
+// Queries still running after the timeout of their search are cancelled and have empty results (it's not an error), then timedOut is true.
 func (q *QueryRunner) searchWorkerCommon(
 	ctx context.Context,
-	queries []*model.Query,
-	table *clickhouse.Table) (translatedQueryBody []byte, hits [][]model.QueryResultRow, err error) {
+	searches []tableSearch, allowPartialResults bool) (translatedQueryBody []byte, hits [][][]model.QueryResultRow, failures []model.ResponseShardsFailure, timedOut bool, err error) {
 	sqls := ""
 
-	hits = make([][]model.QueryResultRow, len(queries))
+	hits = make([][][]model.QueryResultRow, len(searches))
 
+	type hitsPosition struct {
+		search, query int
+	}
 	var jobs []QueryJob
-	var jobHitsPosition []int // it keeps the position of the hits array for each job
+	var jobHitsPosition []hitsPosition // it keeps the position of the hits array for each job
+	var jobErrors []error              // errors of failed jobs, only if allowPartialResults
+	var anyTimedOut atomic.Bool
+
+	for searchNr, search := range searches {
+		table := search.table
+		deadline := time.Now().Add(search.timeout)
+		hits[searchNr] = make([][]model.QueryResultRow, len(search.queries))
+		for i, query := range search.queries {
+			if query.NoDBQuery {
+				logger.InfoWithCtx(ctx).Msgf("pipeline query: %+v", query)
+				hits[searchNr][i] = make([]model.QueryResultRow, 0)
+				continue
+			}
 
-	for i, query := range queries {
-		if query.NoDBQuery {
-			logger.InfoWithCtx(ctx).Msgf("pipeline query: %+v", query)
-			hits[i] = make([]model.QueryResultRow, 0)
-			continue
-		}
+			sql := query.SelectCommand.StringWithOptions(q.logManager.RenderOptions())
+			logger.InfoWithCtx(ctx).Msgf("SQL: %s", sql)
+			sqls += sql + "\n"
 
-		sql := query.SelectCommand.String()
-		logger.InfoWithCtx(ctx).Msgf("SQL: %s", sql)
-		sqls += sql + "\n"
+			if q.isInternalKibanaQuery(query) {
+				hits[searchNr][i] = make([]model.QueryResultRow, 0)
+				continue
+			}
 
-		if q.isInternalKibanaQuery(query) {
-			hits[i] = make([]model.QueryResultRow, 0)
-			continue
-		}
+			job := func(ctx context.Context) ([]model.QueryResultRow, error) {
+				if search.timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithDeadline(ctx, deadline)
+					defer cancel()
+				}
+				var err error
+				var rows []model.QueryResultRow
+				var cached bool
+				useCache := q.useQueryCache(search.requestCache, sql)
+				if useCache {
+					rows, cached = q.queryCache.Get(table.Name, sql)
+				}
+				if !cached {
+					rows, err = q.logManager.ProcessQuery(ctx, table, query)
+					if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+						logger.WarnWithCtx(ctx).Msgf("query on %s timed out after %s: %s", table.Name, search.timeout, sql)
+						anyTimedOut.Store(true)
+						return make([]model.QueryResultRow, 0), nil
+					}
+					if err != nil {
+						logger.ErrorWithCtx(ctx).Msg(err.Error())
+						return nil, err
+					}
+					if useCache {
+						q.queryCache.Put(table.Name, sql, rows)
+					}
+				}
 
-		job := func(ctx context.Context) ([]model.QueryResultRow, error) {
-			var err error
-			rows, err := q.logManager.ProcessQuery(ctx, table, query)
+				if query.Type != nil {
+					if rows, err = model.PostprocessResults(query.Type, rows); err != nil {
+						return nil, err
+					}
+				}
 
-			if err != nil {
-				logger.ErrorWithCtx(ctx).Msg(err.Error())
-				return nil, err
+				return rows, nil
 			}
-
-			if query.Type != nil {
-				rows = query.Type.PostprocessResults(rows)
+			if allowPartialResults {
+				jobId := len(jobs)
+				jobErrors = append(jobErrors, nil)
+				strictJob := job
+				job = func(ctx context.Context) ([]model.QueryResultRow, error) {
+					rows, err := strictJob(ctx)
+					// too many buckets fail the whole search, like in Elasticsearch
+					if err != nil && !errors.Is(err, quesma_errors.ErrTooManyBuckets()) {
+						jobErrors[jobId] = err
+						return make([]model.QueryResultRow, 0), nil
+					}
+					return rows, err
+				}
 			}
-
-			return rows, nil
+			jobs = append(jobs, job)
+			jobHitsPosition = append(jobHitsPosition, hitsPosition{search: searchNr, query: i})
 		}
-		jobs = append(jobs, job)
-		jobHitsPosition = append(jobHitsPosition, i)
 	}
+	q.searchesCount.Add(1)
+	jobsStart := time.Now()
 	dbHits, err := q.runQueryJobs(jobs)
+	q.searchDuration.Observe(time.Since(jobsStart))
 	if err != nil {
 		return
 	}
 
+	// every searched table is like a shard: it failed, if any of its queries failed. We report its first error.
+	failedJobs := 0
+	failedSearches := make(map[int]bool)
+	for jobId, jobErr := range jobErrors {
+		if jobErr == nil {
+			continue
+		}
+		failedJobs++
+		if searchNr := jobHitsPosition[jobId].search; !failedSearches[searchNr] {
+			failedSearches[searchNr] = true
+			failures = append(failures, model.ResponseShardsFailure{
+				Shard:  searchNr,
+				Index:  searches[searchNr].table.Name,
+				Reason: model.Reason{Type: "query_shard_exception", Reason: jobErr.Error()},
+			})
+		}
+	}
+	if failedJobs > 0 && failedJobs == len(jobs) {
+		err = jobErrors[0]
+		failures = nil
+		return
+	}
+
 	// fill the hits array with the results in the order of the database queries
-	for jobId := range jobHitsPosition {
-		hitsPosition := jobHitsPosition[jobId]
-		hits[hitsPosition] = dbHits[jobId]
+	for jobId, position := range jobHitsPosition {
+		hits[position.search][position.query] = dbHits[jobId]
 	}
 
 	translatedQueryBody = []byte(sqls)
+	timedOut = anyTimedOut.Load()
 	return
 }
 
 func (q *QueryRunner) searchWorker(ctx context.Context,
-	aggregations []*model.Query,
-	table *clickhouse.Table,
+	searches []tableSearch,
 	doneCh chan<- AsyncSearchWithError,
-	optAsync *AsyncQuery) (translatedQueryBody []byte, resultRows [][]model.QueryResultRow, err error) {
+	optAsync *AsyncQuery, allowPartialResults bool) (translatedQueryBody []byte, resultRows [][][]model.QueryResultRow, failures []model.ResponseShardsFailure, timedOut bool, err error) {
 	if optAsync != nil {
 		if q.reachedQueriesLimit(ctx, optAsync.asyncRequestIdStr, doneCh) {
 			return
 		}
 		// We need different ctx as our cancel is no longer tied to HTTP request, but to overall timeout.
 		dbQueryCtx, dbCancel := context.WithCancel(tracing.NewContextWithRequest(ctx))
+		// the context is removed by removeAsyncQueryContext, once the result is stored
 		q.addAsyncQueryContext(dbQueryCtx, dbCancel, optAsync.asyncRequestIdStr)
 		ctx = dbQueryCtx
 	}
 
-	return q.searchWorkerCommon(ctx, aggregations, table)
+	return q.searchWorkerCommon(ctx, searches, allowPartialResults)
 }
 
 func (q *QueryRunner) Close() {
@@ -652,7 +1076,7 @@ func (q *QueryRunner) findNonexistingProperties(query *model.Query, table *click
 		if ok {
 			property = queryTranslatorValue.ResolveField(q.executionCtx, property)
 		}
-		if property != "*" && !table.HasColumn(q.executionCtx, property) {
+		if property != "*" && !table.HasColumn(q.executionCtx, property) && !slices.Contains(model.InnerHitsComputedColumnNames, property) {
 			results = append(results, property)
 		}
 	}
@@ -0,0 +1,338 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"fmt"
+	"quesma/model"
+	"quesma/model/bucket_aggregations"
+	"quesma/model/metrics_aggregations"
+	"quesma/model/typical_queries"
+	"quesma/util"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// canMergeResultsFromTables returns true <=> we know how to merge results of `queries`, run on multiple tables.
+// It's hits, count, and aggregations, whose buckets/values can be re-reduced from per-table ones:
+// terms and date_histogram (doc counts are summed), and sum, min, max, value_count metrics.
+// Others (e.g. avg, percentiles, cardinality) would need to be recomputed over all tables.
+func canMergeResultsFromTables(queries []*model.Query) bool {
+	for _, query := range queries {
+		switch queryType := query.Type.(type) {
+		case typical_queries.Count, *typical_queries.Hits:
+		case bucket_aggregations.Terms:
+			if queryType.IsSignificant() {
+				return false
+			}
+		case bucket_aggregations.DateHistogram, metrics_aggregations.Count, metrics_aggregations.Sum,
+			metrics_aggregations.Min, metrics_aggregations.Max, metrics_aggregations.ValueCount:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// mergeResultsFromTables merges results of the same search, run on multiple tables (resultsPerTable[i] are results for searches[i]).
+// Results are returned in the order of searches[0].queries, queries of other searches are matched with them by mergeKey,
+// as aggregations can be translated to queries in a different order for each table.
+// It works like UNION ALL: count results are summed, hits are concatenated, sorted by the query's ORDER BY and limited.
+// Aggregation rows are merged by their GROUP BY keys (see mergeAggregationRows).
+// Should only be called if canMergeResultsFromTables(searches[0].queries) is true.
+func mergeResultsFromTables(searches []tableSearch, resultsPerTable [][][]model.QueryResultRow) ([][]model.QueryResultRow, error) {
+	queries := searches[0].queries
+	queryIdxPerTable := make([]map[string]int, len(searches))
+	for tableNr, search := range searches {
+		queryIdxPerTable[tableNr] = make(map[string]int, len(search.queries))
+		for queryIdx, query := range search.queries {
+			queryIdxPerTable[tableNr][mergeKey(query)] = queryIdx
+		}
+	}
+
+	merged := make([][]model.QueryResultRow, len(queries))
+	for i, query := range queries {
+		var rows []model.QueryResultRow
+		var totalDocCount int64 // of terms, over all tables
+		for tableNr, tableResults := range resultsPerTable {
+			if queryIdx, ok := queryIdxPerTable[tableNr][mergeKey(query)]; ok && queryIdx < len(tableResults) {
+				rows = append(rows, tableResults[queryIdx]...)
+				totalDocCount += termsTotalDocCount(tableResults[queryIdx])
+			}
+		}
+
+		switch queryType := query.Type.(type) {
+		case typical_queries.Count:
+			merged[i] = mergeCountRows(rows)
+		case *typical_queries.Hits:
+			sortRows(rows, query.SelectCommand.OrderBy)
+			if limit := query.SelectCommand.Limit; limit > 0 && len(rows) > limit {
+				rows = rows[:limit]
+			}
+			merged[i] = rows
+		default:
+			rows = mergeAggregationRows(rows, len(query.SelectCommand.GroupBy), aggregationReducer(queryType))
+			setTermsTotalDocCount(rows, totalDocCount)
+			sortAggregationRows(query.SelectCommand, rows)
+			if limit := query.SelectCommand.Limit; limit > 0 && len(rows) > limit {
+				rows = rows[:limit]
+			}
+			// e.g. date_histogram adds empty buckets between ones from different tables
+			var err error
+			if merged[i], err = model.PostprocessResults(queryType, rows); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return merged, nil
+}
+
+// termsTotalDocCount returns total doc count of terms `rows` of one table, 0 if they don't have it
+func termsTotalDocCount(rows []model.QueryResultRow) int64 {
+	if len(rows) == 0 || !bucket_aggregations.HasTotalDocCount(rows[0]) {
+		return 0
+	}
+	totalDocCount, _ := util.ExtractInt64Maybe(dereference(rows[0].Cols[len(rows[0].Cols)-1].Value))
+	return totalDocCount
+}
+
+// setTermsTotalDocCount sets total doc count of merged terms `rows`. mergeAggregationRows sums it only for terms,
+// which are in multiple tables, but it must be the same in every row: the sum of totals of all tables.
+func setTermsTotalDocCount(rows []model.QueryResultRow, totalDocCount int64) {
+	for _, row := range rows {
+		if bucket_aggregations.HasTotalDocCount(row) {
+			row.Cols[len(row.Cols)-1].Value = totalDocCount
+		}
+	}
+}
+
+// mergeKey identifies the same query of a search, translated for different tables
+func mergeKey(query *model.Query) string {
+	names := make([]string, 0, len(query.Aggregators))
+	for _, aggregator := range query.Aggregators {
+		names = append(names, aggregator.Name)
+	}
+	return fmt.Sprintf("%T/%s", query.Type, strings.Join(names, "/"))
+}
+
+// aggregationReducer returns how values of an aggregation from different tables are combined
+func aggregationReducer(queryType model.QueryType) func(a, b any) any {
+	switch queryType.(type) {
+	case metrics_aggregations.Min:
+		return func(a, b any) any {
+			if compareValues(a, b) <= 0 {
+				return a
+			}
+			return b
+		}
+	case metrics_aggregations.Max:
+		return func(a, b any) any {
+			if compareValues(a, b) >= 0 {
+				return a
+			}
+			return b
+		}
+	default: // doc counts, sums, value counts
+		return sumValues
+	}
+}
+
+// mergeAggregationRows merges rows [group by keys..., values...] with the same keys (first `keysNr` columns),
+// combining their values with `reduce`. Rows keep the order, in which their keys first appear.
+func mergeAggregationRows(rows []model.QueryResultRow, keysNr int, reduce func(a, b any) any) []model.QueryResultRow {
+	merged := make([]model.QueryResultRow, 0, len(rows))
+	rowIdxByKey := make(map[string]int, len(rows))
+	for _, row := range rows {
+		if len(row.Cols) < keysNr {
+			continue
+		}
+		keyParts := make([]string, 0, keysNr)
+		for _, col := range row.Cols[:keysNr] {
+			keyParts = append(keyParts, fmt.Sprintf("%v", dereference(col.Value)))
+		}
+		key := strings.Join(keyParts, "\x00")
+		rowIdx, exists := rowIdxByKey[key]
+		if !exists {
+			rowIdxByKey[key] = len(merged)
+			merged = append(merged, row.Copy())
+			continue
+		}
+		for colIdx := keysNr; colIdx < len(row.Cols) && colIdx < len(merged[rowIdx].Cols); colIdx++ {
+			mergedValue, value := dereference(merged[rowIdx].Cols[colIdx].Value), dereference(row.Cols[colIdx].Value)
+			switch {
+			case value == nil:
+			case mergedValue == nil:
+				merged[rowIdx].Cols[colIdx].Value = value
+			default:
+				merged[rowIdx].Cols[colIdx].Value = reduce(mergedValue, value)
+			}
+		}
+	}
+	return merged
+}
+
+// sortAggregationRows sorts merged rows by the query's ORDER BY, e.g. terms by count() DESC, or by their keys.
+// ORDER BY expressions are matched with selected columns, ones not selected are skipped.
+func sortAggregationRows(selectCommand model.SelectCommand, rows []model.QueryResultRow) {
+	type sortColumn struct {
+		colIdx    int
+		direction model.OrderByDirection
+	}
+	var sortColumns []sortColumn
+	for _, orderByExpr := range selectCommand.OrderBy {
+		if len(orderByExpr.Exprs) == 0 {
+			continue
+		}
+		orderByAsString := model.AsString(orderByExpr.Exprs[0])
+		for colIdx, column := range selectCommand.Columns {
+			if model.AsString(column) == orderByAsString {
+				sortColumns = append(sortColumns, sortColumn{colIdx: colIdx, direction: orderByExpr.Direction})
+				break
+			}
+		}
+	}
+	slices.SortStableFunc(rows, func(a, b model.QueryResultRow) int {
+		for _, sortCol := range sortColumns {
+			if sortCol.colIdx >= len(a.Cols) || sortCol.colIdx >= len(b.Cols) {
+				continue
+			}
+			aValue, bValue := dereference(a.Cols[sortCol.colIdx].Value), dereference(b.Cols[sortCol.colIdx].Value)
+			switch {
+			case aValue == nil && bValue == nil:
+				continue
+			case aValue == nil:
+				return 1
+			case bValue == nil:
+				return -1
+			}
+			cmp := compareValues(aValue, bValue)
+			if sortCol.direction == model.DescOrder {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	})
+}
+
+// sumValues returns a + b, as int64 if both are integers, as float64 otherwise
+func sumValues(a, b any) any {
+	if aInt, ok := util.ExtractInt64Maybe(a); ok {
+		if bInt, ok := util.ExtractInt64Maybe(b); ok {
+			return aInt + bInt
+		}
+	}
+	aNumber, aOk := util.ExtractNumeric64Maybe(a)
+	bNumber, bOk := util.ExtractNumeric64Maybe(b)
+	if !aOk || !bOk {
+		return a
+	}
+	return aNumber + bNumber
+}
+
+// dereference returns the value behind a pointer (nil for nil pointers), other values as they are
+func dereference(value any) any {
+	reflected := reflect.ValueOf(value)
+	if !reflected.IsValid() {
+		return nil
+	}
+	if reflected.Kind() == reflect.Pointer {
+		if reflected.IsNil() {
+			return nil
+		}
+		return reflected.Elem().Interface()
+	}
+	return value
+}
+
+func mergeCountRows(rows []model.QueryResultRow) []model.QueryResultRow {
+	if len(rows) == 0 {
+		return rows
+	}
+	var sum uint64
+	for _, row := range rows {
+		if len(row.Cols) > 0 {
+			if count, ok := util.ExtractInt64Maybe(row.Cols[0].Value); ok {
+				sum += uint64(count)
+			}
+		}
+	}
+	mergedRow := rows[0].Copy()
+	mergedRow.Cols[0].Value = sum
+	return []model.QueryResultRow{mergedRow}
+}
+
+// sortRows sorts rows by ORDER BY expressions, which are plain columns. Other expressions are skipped.
+// Rows with missing (NULL) values go last, like in Elastic.
+func sortRows(rows []model.QueryResultRow, orderBy []model.OrderByExpr) {
+	if len(orderBy) == 0 {
+		return
+	}
+	slices.SortStableFunc(rows, func(a, b model.QueryResultRow) int {
+		for _, orderByExpr := range orderBy {
+			if len(orderByExpr.Exprs) == 0 {
+				continue
+			}
+			column, ok := orderByExpr.Exprs[0].(model.ColumnRef)
+			if !ok {
+				continue
+			}
+			aValue, bValue := colValue(a, column.ColumnName), colValue(b, column.ColumnName)
+			switch {
+			case aValue == nil && bValue == nil:
+				continue
+			case aValue == nil:
+				return 1
+			case bValue == nil:
+				return -1
+			}
+			cmp := compareValues(aValue, bValue)
+			if orderByExpr.Direction == model.DescOrder {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	})
+}
+
+// colValue returns value of column `colName` of the row, dereferenced (so NULL of a Nullable column is nil)
+func colValue(row model.QueryResultRow, colName string) any {
+	for _, col := range row.Cols {
+		if col.ColName == colName {
+			return dereference(col.Value)
+		}
+	}
+	return nil
+}
+
+func compareValues(a, b any) int {
+	if aTime, ok := a.(time.Time); ok {
+		if bTime, ok := b.(time.Time); ok {
+			return aTime.Compare(bTime)
+		}
+	}
+	if aTime, ok := a.(*time.Time); ok {
+		if bTime, ok := b.(*time.Time); ok && aTime != nil && bTime != nil {
+			return aTime.Compare(*bTime)
+		}
+	}
+	if aNumber, ok := util.ExtractNumeric64Maybe(a); ok {
+		if bNumber, ok := util.ExtractNumeric64Maybe(b); ok {
+			switch {
+			case aNumber < bNumber:
+				return -1
+			case aNumber > bNumber:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
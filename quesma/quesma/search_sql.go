@@ -0,0 +1,76 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"quesma/clickhouse"
+	"quesma/end_user_errors"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/quesma/errors"
+	"quesma/quesma/functionality/elastic_sql"
+	"quesma/quesma/types"
+	"quesma/schema"
+)
+
+// handleSQL runs Elasticsearch SQL API (_sql) query. Like searches, it reads the table through query transformations
+// (e.g. the baseline filter), and it's bound by the table's timeout (or `request_timeout` of the request).
+func (q *QueryRunner) handleSQL(ctx context.Context, body types.JSON, format string) ([]byte, error) {
+	request, err := elastic_sql.ParseRequest(body, format)
+	if err != nil {
+		return nil, err
+	}
+	tableName, err := elastic_sql.ResolveTable(ctx, q.cfg, q.logManager, request.Query.IndexPattern)
+	if err != nil {
+		return nil, err
+	}
+	table := q.logManager.FindTable(tableName)
+	if table == nil {
+		return nil, quesma_errors.ErrIndexNotExists()
+	}
+	from, err := q.sqlSource(table)
+	if err != nil {
+		return nil, err
+	}
+	tableSchema, _ := q.schemaRegistry.FindSchema(schema.TableName(table.Name))
+	translated, err := request.Translate(from, tableSchema)
+	if err != nil {
+		return nil, err
+	}
+	logger.DebugWithCtx(ctx).Msgf("elasticsearch SQL query translated to: %s", translated)
+
+	timeout := requestTimeout(ctx, body, "request_timeout", table)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	columns, rows, err := q.logManager.ProcessRawQuery(ctx, translated)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, end_user_errors.ErrDatabaseQueryError.New(fmt.Errorf("_sql query on %s timed out after %s", table.Name, timeout))
+		}
+		return nil, err
+	}
+	return json.Marshal(elastic_sql.MakeResponse(columns, rows, tableSchema))
+}
+
+// sqlSource returns what _sql query reads instead of its index: the table, or, if query transformations
+// restrict its rows, the table's subquery with them applied
+func (q *QueryRunner) sqlSource(table *clickhouse.Table) (string, error) {
+	tableQuery := model.NewSelectCommand([]model.Expr{model.NewWildcardExpr}, nil, nil,
+		model.NewTableRef(table.FullTableName()), nil, 0, 0, false)
+	plain := tableQuery.StringWithOptions(q.logManager.RenderOptions())
+	queries, err := q.transformQueries(table, []*model.Query{{TableName: table.FullTableName(), SelectCommand: *tableQuery}})
+	if err != nil {
+		return "", err
+	}
+	if source := queries[0].SelectCommand.StringWithOptions(q.logManager.RenderOptions()); source != plain {
+		return "(" + source + ")", nil
+	}
+	return table.FullTableName(), nil
+}
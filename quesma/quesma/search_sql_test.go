@@ -0,0 +1,128 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"quesma/clickhouse"
+	"quesma/concurrent"
+	"quesma/logger"
+	"quesma/quesma/config"
+	"quesma/quesma/errors"
+	"quesma/quesma/types"
+	"quesma/quesma/ui"
+	"quesma/schema"
+	"quesma/telemetry"
+	"quesma/util"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHandleSQL(t *testing.T) {
+	const tableName = "logs-generic-default"
+	newTable := func() *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:   tableName,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"host::name": {Name: "host::name", Type: clickhouse.NewBaseType("LowCardinality(String)")},
+				"bytes":      {Name: "bytes", Type: clickhouse.NewBaseType("Int64")},
+			},
+			Created: true,
+		}
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"host.name": {PropertyName: "host.name", InternalPropertyName: "host::name", Type: schema.TypeKeyword},
+		"bytes":     {PropertyName: "bytes", InternalPropertyName: "bytes", Type: schema.TypeLong},
+	}}}}
+
+	tests := []struct {
+		name             string
+		query            string
+		baselineFilter   string
+		expectedSql      string
+		rows             *sqlmock.Rows
+		expectedResponse string
+	}{
+		{
+			name:  "aggregation",
+			query: `SELECT \"host.name\", COUNT(*) AS \"count\", AVG(bytes) AS avg_bytes FROM \"logs-*\" WHERE bytes > 0 GROUP BY \"host.name\" LIMIT 10`,
+			expectedSql: `SELECT "host::name" AS "host.name", COUNT(*) AS "count", AVG("bytes") AS avg_bytes FROM "logs-generic-default" ` +
+				`WHERE "bytes" > 0 GROUP BY "host::name" LIMIT 10`,
+			rows: sqlmock.NewRowsWithColumnDefinition(
+				sqlmock.NewColumn("host.name").OfType("LowCardinality(String)", ""),
+				sqlmock.NewColumn("count").OfType("UInt64", uint64(0)),
+				sqlmock.NewColumn("avg_bytes").OfType("Nullable(Float64)", float64(0)),
+			).AddRow("a", uint64(3), 10.5).AddRow("b", uint64(1), 7.0),
+			expectedResponse: `{
+				"columns": [
+					{"name": "host.name", "type": "keyword"},
+					{"name": "count", "type": "long"},
+					{"name": "avg_bytes", "type": "double"}
+				],
+				"rows": [["a", 3, 10.5], ["b", 1, 7.0]]
+			}`,
+		},
+		{
+			name:           "baseline filter, all columns",
+			query:          `SELECT * FROM \"logs-generic-default\"`,
+			baselineFilter: `{"term": {"bytes": 5}}`,
+			expectedSql:    `SELECT * FROM (SELECT * FROM "logs-generic-default" WHERE "bytes"=5) LIMIT 1000`,
+			rows: sqlmock.NewRowsWithColumnDefinition(
+				sqlmock.NewColumn("bytes").OfType("Int64", int64(0)),
+				sqlmock.NewColumn("host::name").OfType("LowCardinality(String)", ""),
+			).AddRow(int64(5), "internal"),
+			expectedResponse: `{
+				"columns": [
+					{"name": "bytes", "type": "long"},
+					{"name": "host.name", "type": "keyword"}
+				],
+				"rows": [[5, "internal"]]
+			}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+				tableName: {Name: tableName, Enabled: true, BaselineFilter: tt.baselineFilter},
+			}}
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, newTable()))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			mock.ExpectQuery(regexp.QuoteMeta(tt.expectedSql)).WillReturnRows(tt.rows)
+
+			response, err := queryRunner.handleSQL(ctx, types.MustJSON(`{"query": "`+tt.query+`"}`), "")
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedResponse, string(response))
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+		db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+		defer db.Close()
+		lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, newTable()))
+		managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+		queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+
+		_, err := queryRunner.handleSQL(ctx, types.MustJSON(`{"query": "SELECT bytes FROM \"logs-*\" HAVING bytes > 1"}`), "")
+		assert.True(t, errors.Is(err, quesma_errors.ErrCouldNotParseRequest()))
+		_, err = queryRunner.handleSQL(ctx, types.MustJSON(`{"query": "SELECT password FROM \"logs-*\""}`), "")
+		assert.True(t, errors.Is(err, quesma_errors.ErrCouldNotParseRequest()))
+		_, err = queryRunner.handleSQL(ctx, types.MustJSON(`{"query": "SELECT bytes FROM other"}`), "")
+		assert.True(t, errors.Is(err, quesma_errors.ErrIndexNotExists()))
+
+		mock.ExpectQuery(`SELECT "bytes" FROM "logs-generic-default"`).WillDelayFor(500 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"bytes"}).AddRow(int64(1)))
+		_, err = queryRunner.handleSQL(ctx, types.MustJSON(`{"query": "SELECT bytes FROM \"logs-*\"", "request_timeout": "50ms"}`), "")
+		assert.ErrorContains(t, err, "timed out after 50ms")
+	})
+}
@@ -0,0 +1,185 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"quesma/logger"
+	"quesma/model"
+	"quesma/model/typical_queries"
+	"quesma/quesma/types"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// streamedSearch is filled by handleSearchCommon, if it decides to stream hits to the client
+// instead of building the whole response in memory.
+type streamedSearch struct {
+	writeResponse func(w io.Writer) error
+}
+
+// handleSearchStreamed is like handleSearch, but for large hits responses it returns (nil, writeResponse, nil).
+// Then the response is written by writeResponse, row by row, as rows are read from ClickHouse.
+// writeResponse doesn't write anything before the first row is read, so if it fails before, a normal error response can still be sent.
+func (q *QueryRunner) handleSearchStreamed(ctx context.Context, indexPattern string, body types.JSON, params searchParams) (responseBody []byte, writeResponse func(w io.Writer) error, err error) {
+	var stream streamedSearch
+	responseBody, err = q.handleSearchCommon(ctx, indexPattern, body, nil, &stream, nil, QueryLanguageDefault, params)
+	return responseBody, stream.writeResponse, err
+}
+
+// canStreamHits returns true <=> response to `search` can be streamed: there's a large enough hits query (without inner hits) and a count query only.
+// Without a count query, total would depend on the number of hits, which we know only at the end, after hits are written.
+// Hits that may be cached aren't streamed, as caching needs all of them in memory anyway.
+func (q *QueryRunner) canStreamHits(search tableSearch) bool {
+	if q.cfg.StreamHitsThreshold <= 0 {
+		return false
+	}
+	hasHits, hasCount := false, false
+	for _, query := range search.queries {
+		switch queryType := query.Type.(type) {
+		case *typical_queries.Hits:
+			if hasHits || queryType.HasInnerHits() || query.NoDBQuery || q.isInternalKibanaQuery(query) || query.SelectCommand.Limit < q.cfg.StreamHitsThreshold {
+				return false
+			}
+			if q.useQueryCache(search.requestCache, query.SelectCommand.StringWithOptions(q.logManager.RenderOptions())) {
+				return false
+			}
+			hasHits = true
+		case typical_queries.Count:
+			hasCount = true
+		default:
+			return false
+		}
+	}
+	return hasHits && hasCount
+}
+
+// prepareStreamedSearch runs all queries of `search` but hits, so errors can still be returned normally.
+// Hits query is run by the returned function, which writes the same response as the buffered path would,
+// only with `timed_out` as the last field, as it's known only after all hits are read.
+// Both share the timeout of the search: if it's reached while streaming, hits read so far are returned and `timed_out` is true.
+func (q *QueryRunner) prepareStreamedSearch(ctx context.Context, search tableSearch, pitId *string, id, path string, bodyAsBytes []byte, startTime time.Time) (func(w io.Writer) error, error) {
+	deadline := time.Now().Add(search.timeout)
+	var hitsQuery *model.Query
+	otherQueries := make([]*model.Query, 0, len(search.queries))
+	for _, query := range search.queries {
+		if _, isHits := query.Type.(*typical_queries.Hits); isHits {
+			hitsQuery = query
+		} else {
+			otherQueries = append(otherQueries, query)
+		}
+	}
+	hitsType := hitsQuery.Type.(*typical_queries.Hits)
+
+	// Count is the only other query, so there's nothing to return partially, if it fails
+	translatedQueryBody, resultsPerTable, _, timedOut, err := q.searchWorkerCommon(ctx, []tableSearch{{table: search.table, queryTranslator: search.queryTranslator,
+		queries: otherQueries, requestCache: search.requestCache, timeout: search.timeout}}, false)
+	if err != nil {
+		return nil, err
+	}
+	results, err := q.postProcessResults(search.table, resultsPerTable[0])
+	if err != nil {
+		return nil, err
+	}
+	response := search.queryTranslator.MakeSearchResponse(otherQueries, results)
+	response.PitID = pitId
+	responseWithoutHits, err := response.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var responseFields map[string]json.RawMessage
+	if err = json.Unmarshal(responseWithoutHits, &responseFields); err != nil {
+		return nil, err
+	}
+	var hitsFields map[string]json.RawMessage
+	if err = json.Unmarshal(responseFields["hits"], &hitsFields); err != nil {
+		return nil, err
+	}
+	// {...,"hits":{...,"hits":[ <hits> ]},"timed_out":<timedOut>}
+	beforeHits := []byte{'{'}
+	beforeHits = appendObjectFields(beforeHits, responseFields, "hits", "timed_out")
+	beforeHits = append(beforeHits, `"hits":{`...)
+	beforeHits = appendObjectFields(beforeHits, hitsFields, "hits")
+	beforeHits = append(beforeHits, `"hits":[`...)
+
+	hitsSql := hitsQuery.SelectCommand.StringWithOptions(q.logManager.RenderOptions())
+	translatedQueryBody = append(translatedQueryBody, []byte(hitsSql+"\n")...)
+
+	return func(w io.Writer) error {
+		queryCtx := ctx
+		if search.timeout > 0 {
+			var cancel context.CancelFunc
+			queryCtx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		hitsCount := 0
+		writeHit := func(hit []byte) error {
+			if hitsCount == 0 {
+				if _, err := w.Write(beforeHits); err != nil {
+					return err
+				}
+				_, err := w.Write(hit)
+				return err
+			}
+			_, err := w.Write(append([]byte{','}, hit...))
+			return err
+		}
+		err := q.logManager.ProcessQueryEach(queryCtx, search.table, hitsQuery, func(row model.QueryResultRow) error {
+			rows, err := q.postProcessResults(search.table, [][]model.QueryResultRow{hitsQuery.Type.PostprocessResults([]model.QueryResultRow{row})})
+			if err != nil {
+				return err
+			}
+			for _, row := range rows[0] {
+				hit, err := json.Marshal(hitsType.MakeHit(hitsCount, row))
+				if err != nil {
+					return err
+				}
+				if err = writeHit(hit); err != nil {
+					return err
+				}
+				hitsCount++
+			}
+			return nil
+		})
+		if err != nil && errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+			logger.WarnWithCtx(ctx).Msgf("query on %s timed out after %s: %s", search.table.Name, search.timeout, hitsSql)
+			timedOut, err = true, nil
+		}
+		if err != nil {
+			return err
+		}
+		if hitsCount == 0 {
+			if _, err = w.Write(beforeHits); err != nil {
+				return err
+			}
+		}
+		_, err = w.Write([]byte(`]},"timed_out":` + strconv.FormatBool(timedOut) + "}"))
+		pushSecondaryInfo(q.quesmaManagementConsole, id, path, bodyAsBytes, translatedQueryBody, []byte(fmt.Sprintf("(streamed %d hits)", hitsCount)), startTime)
+		return err
+	}, nil
+}
+
+// appendObjectFields appends `"name":value,` to dst for all fields of a JSON object but `except`, sorted by name
+func appendObjectFields(dst []byte, fields map[string]json.RawMessage, except ...string) []byte {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if !slices.Contains(except, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nameJson, _ := json.Marshal(name)
+		dst = append(dst, nameJson...)
+		dst = append(dst, ':')
+		dst = append(dst, fields[name]...)
+		dst = append(dst, ',')
+	}
+	return dst
+}
@@ -4,17 +4,25 @@ package quesma
 
 import (
 	"context"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/k0kubun/pp"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/maps"
+	"net/url"
 	"quesma/clickhouse"
 	"quesma/concurrent"
+	"quesma/end_user_errors"
 	"quesma/logger"
 	"quesma/model"
 	"quesma/queryparser"
 	"quesma/quesma/config"
+	"quesma/quesma/errors"
+	"quesma/quesma/mux"
 	"quesma/quesma/types"
 	"quesma/quesma/ui"
 	"quesma/schema"
@@ -25,6 +33,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 const defaultAsyncSearchTimeout = 1000
@@ -136,7 +145,7 @@ func TestAsyncSearchHandler(t *testing.T) {
 				mock.ExpectQuery(wantedRegex).WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "host.name"}))
 			}
 			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
-			_, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryJson), defaultAsyncSearchTimeout, true)
+			_, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryJson), defaultAsyncSearchTimeout, true, defaultSearchParams)
 			assert.NoError(t, err)
 
 			if err := mock.ExpectationsWereMet(); err != nil {
@@ -146,6 +155,49 @@ func TestAsyncSearchHandler(t *testing.T) {
 	}
 }
 
+func TestAsyncSearchResultStoredBeforeContextRemoved(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Enabled: true}}}
+	table := concurrent.NewMapWith(tableName, &clickhouse.Table{
+		Name:    tableName,
+		Config:  clickhouse.NewDefaultCHConfig(),
+		Cols:    map[string]*clickhouse.Column{"message": {Name: "message", Type: clickhouse.NewBaseType("String")}},
+		Created: true,
+	})
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, table)
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	mock.ExpectQuery(`SELECT "message" FROM`).WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"message"}).AddRow("hello"))
+
+	// the query outlives wait_for_completion_timeout, so it's finished in the background
+	responseBody, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(`{"size": 1, "track_total_hits": false}`), 0, true, defaultSearchParams)
+	assert.NoError(t, err)
+	var partialResponse struct {
+		Id string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(responseBody, &partialResponse))
+
+	// while its context exists, the query is running. Once it's removed, the result has to be there already.
+	seenRunning := false
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Microsecond) {
+		_, running := queryRunner.AsyncQueriesContexts.Load(partialResponse.Id)
+		_, stored := queryRunner.AsyncRequestStorage.Load(partialResponse.Id)
+		if running {
+			seenRunning = true
+		} else if seenRunning {
+			assert.True(t, stored, "async search finished, but its result isn't stored")
+			break
+		}
+	}
+	assert.True(t, seenRunning)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAsyncSearchHandlerSpecialCharacters(t *testing.T) {
 	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Enabled: true}}}
 	table := clickhouse.Table{
@@ -189,7 +241,7 @@ func TestAsyncSearchHandlerSpecialCharacters(t *testing.T) {
 			}
 
 			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
-			_, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryRequestJson), defaultAsyncSearchTimeout, true)
+			_, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryRequestJson), defaultAsyncSearchTimeout, true, defaultSearchParams)
 			assert.NoError(t, err)
 
 			if err = mock.ExpectationsWereMet(); err != nil {
@@ -327,7 +379,7 @@ func TestAsyncSearchFilter(t *testing.T) {
 				mock.ExpectQuery(testdata.EscapeBrackets(wantedRegex)).WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "host.name"}))
 			}
 			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
-			_, _ = queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryJson), defaultAsyncSearchTimeout, true)
+			_, _ = queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryJson), defaultAsyncSearchTimeout, true, defaultSearchParams)
 			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Fatal("there were unfulfilled expections:", err)
 			}
@@ -419,7 +471,7 @@ func TestHandlingDateTimeFields(t *testing.T) {
 
 		// .AddRow(1000, uint64(10)).AddRow(1001, uint64(20))) // here rows should be added if uint64 were supported
 		queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
-		response, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(query(fieldName)), defaultAsyncSearchTimeout, true)
+		response, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(query(fieldName)), defaultAsyncSearchTimeout, true, defaultSearchParams)
 		assert.NoError(t, err)
 
 		var responseMap model.JsonMap
@@ -494,7 +546,7 @@ func TestNumericFacetsQueries(t *testing.T) {
 				if handlerName == "handleSearch" {
 					response, err = queryRunner.handleSearch(ctx, tableName, types.MustJSON(tt.QueryJson))
 				} else if handlerName == "handleAsyncSearch" {
-					response, err = queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryJson), defaultAsyncSearchTimeout, true)
+					response, err = queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(tt.QueryJson), defaultAsyncSearchTimeout, true, defaultSearchParams)
 				}
 				assert.NoError(t, err)
 
@@ -550,11 +602,22 @@ func TestSearchTrackTotalCount(t *testing.T) {
 	test := func(t *testing.T, handlerName string, testcase testdata.FullSearchTestCase) {
 		db, mock := util.InitSqlMockWithPrettyPrint(t, false)
 		defer db.Close()
-		lm := clickhouse.NewLogManagerWithConnection(db, table)
+		cfg, tables := cfg, table
+		if testcase.LegacyTypes {
+			cfg.LegacyTypes = true
+			baseTable, _ := tables.Load(tableName)
+			legacyTable := *baseTable
+			legacyTable.LegacyTypes = true
+			tables = concurrent.NewMapWith(tableName, &legacyTable)
+		}
+		lm := clickhouse.NewLogManagerWithConnection(db, tables)
 		managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
 
 		for i, sql := range testcase.ExpectedSQLs {
-			rows := sqlmock.NewRows([]string{testcase.ExpectedSQLResults[i][0].Cols[0].ColName})
+			rows := sqlmock.NewRows(nil) // query without results
+			if len(testcase.ExpectedSQLResults[i]) > 0 {
+				rows = sqlmock.NewRows([]string{testcase.ExpectedSQLResults[i][0].Cols[0].ColName})
+			}
 			for _, row := range testcase.ExpectedSQLResults[i] {
 				rows.AddRow(row.Cols[0].Value)
 			}
@@ -569,7 +632,7 @@ func TestSearchTrackTotalCount(t *testing.T) {
 			response, err = queryRunner.handleSearch(ctx, tableName, types.MustJSON(testcase.QueryRequestJson))
 		} else if handlerName == "handleAsyncSearch" {
 			response, err = queryRunner.handleAsyncSearch(
-				ctx, tableName, types.MustJSON(testcase.QueryRequestJson), defaultAsyncSearchTimeout, true)
+				ctx, tableName, types.MustJSON(testcase.QueryRequestJson), defaultAsyncSearchTimeout, true, defaultSearchParams)
 		}
 		assert.NoError(t, err)
 
@@ -611,3 +674,1795 @@ func TestSearchTrackTotalCount(t *testing.T) {
 		}
 	}
 }
+
+func TestSearchMultipleTables(t *testing.T) {
+	const tableA, tableB = "logs-a", "logs-b"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableA: {Name: tableA, Enabled: true},
+		tableB: {Name: tableB, Enabled: true},
+	}}
+	newTable := func(name string) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:   name,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+				"message":    {Name: "message", Type: clickhouse.NewBaseType("String"), IsFullTextMatch: true},
+			},
+			Created: true,
+		}
+	}
+	tables := concurrent.NewMapWith(tableA, newTable(tableA))
+	tables.Store(tableB, newTable(tableB))
+	fields := map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			tableA: {Fields: fields},
+			tableB: {Fields: fields},
+		},
+	}
+	query := `{
+		"query": {"match_all": {}},
+		"size": 3,
+		"sort": [{"@timestamp": {"order": "desc"}}],
+		"track_total_hits": true
+	}`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, tables)
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	mock.ExpectQuery(`SELECT count\(\) FROM "logs-a"`).WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(2)))
+	mock.ExpectQuery(`SELECT count\(\) FROM "logs-b"`).WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(3)))
+	mock.ExpectQuery(`SELECT "@timestamp", "message" FROM "logs-a"`).WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "message"}).
+		AddRow(day(5), "a5").AddRow(day(2), "a2"))
+	mock.ExpectQuery(`SELECT "@timestamp", "message" FROM "logs-b"`).WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "message"}).
+		AddRow(day(4), "b4").AddRow(day(3), "b3").AddRow(day(1), "b1"))
+
+	queryRunner := NewQueryRunner(lm, cfg, NewFixedIndexManagement(), managementConsole, s)
+	response, err := queryRunner.handleSearch(ctx, "logs-*", types.MustJSON(query))
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(response, &searchResponse))
+	assert.Equal(t, 5, searchResponse.Hits.Total.Value)
+	var indexes, messages []string
+	for _, hit := range searchResponse.Hits.Hits {
+		var source map[string]any
+		assert.NoError(t, json.Unmarshal(hit.Source, &source))
+		indexes = append(indexes, hit.Index)
+		messages = append(messages, source["message"].(string))
+	}
+	assert.Equal(t, []string{tableA, tableB, tableB}, indexes)
+	assert.Equal(t, []string{"a5", "b4", "b3"}, messages)
+}
+
+func TestSearchMultipleTablesAggregationPicksOneTable(t *testing.T) {
+	const tableA, tableB = "logs-a", "logs-b"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableA: {Name: tableA, Enabled: true},
+		tableB: {Name: tableB, Enabled: true},
+	}}
+	newTable := func(name string) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:    name,
+			Config:  clickhouse.NewDefaultCHConfig(),
+			Cols:    map[string]*clickhouse.Column{"message": {Name: "message", Type: clickhouse.NewBaseType("String")}},
+			Created: true,
+		}
+	}
+	tables := concurrent.NewMapWith(tableA, newTable(tableA))
+	tables.Store(tableB, newTable(tableB))
+	fields := map[schema.FieldName]schema.Field{
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeKeyword},
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableA: {Fields: fields}, tableB: {Fields: fields}}}
+	query := `{
+		"aggs": {"messages": {"cardinality": {"field": "message"}}},
+		"size": 0,
+		"track_total_hits": false
+	}`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, tables)
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	mock.ExpectQuery(`SELECT .*"message".* FROM "logs-[ab]"`).WillReturnRows(sqlmock.NewRows([]string{"count(DISTINCT message)"}).AddRow(uint64(1)))
+
+	queryRunner := NewQueryRunner(lm, cfg, NewFixedIndexManagement(), managementConsole, s)
+	_, err := queryRunner.handleSearch(ctx, "logs-*", types.MustJSON(query))
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestSearchMultipleTablesTermsAggregationMerged(t *testing.T) {
+	const tableA, tableB = "logs-a", "logs-b"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableA: {Name: tableA, Enabled: true},
+		tableB: {Name: tableB, Enabled: true},
+	}}
+	newTable := func(name string) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:   name,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"host":  {Name: "host", Type: clickhouse.NewBaseType("String")},
+				"bytes": {Name: "bytes", Type: clickhouse.NewBaseType("Int64")},
+			},
+			Created: true,
+		}
+	}
+	tables := concurrent.NewMapWith(tableA, newTable(tableA))
+	tables.Store(tableB, newTable(tableB))
+	fields := map[schema.FieldName]schema.Field{
+		"host":  {PropertyName: "host", InternalPropertyName: "host", Type: schema.TypeKeyword},
+		"bytes": {PropertyName: "bytes", InternalPropertyName: "bytes", Type: schema.TypeLong},
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableA: {Fields: fields}, tableB: {Fields: fields}}}
+	query := `{
+		"aggs": {
+			"hosts": {"terms": {"field": "host", "size": 2}},
+			"max_bytes": {"max": {"field": "bytes"}}
+		},
+		"size": 0,
+		"track_total_hits": false
+	}`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, tables)
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	for table, maxBytes := range map[string]int64{tableA: 100, tableB: 300} {
+		mock.ExpectQuery(`SELECT maxOrNull\("bytes"\) FROM "` + table + `"`).WillReturnRows(sqlmock.NewRows([]string{"maxOrNull(bytes)"}).AddRow(maxBytes))
+	}
+	// "web-2" is in both tables, and it's the most frequent host only together
+	mock.ExpectQuery(`SELECT "host", count\(\), sum\(count\(\)\) OVER \(\) AS "total_doc_count" FROM "logs-a"`).
+		WillReturnRows(sqlmock.NewRows([]string{"host", "count()", "total_doc_count"}).
+			AddRow("web-1", uint64(5), uint64(12)).AddRow("web-2", uint64(4), uint64(12)))
+	mock.ExpectQuery(`SELECT "host", count\(\), sum\(count\(\)\) OVER \(\) AS "total_doc_count" FROM "logs-b"`).
+		WillReturnRows(sqlmock.NewRows([]string{"host", "count()", "total_doc_count"}).
+			AddRow("web-3", uint64(6), uint64(10)).AddRow("web-2", uint64(3), uint64(10)))
+
+	queryRunner := NewQueryRunner(lm, cfg, NewFixedIndexManagement(), managementConsole, s)
+	response, err := queryRunner.handleSearch(ctx, "logs-*", types.MustJSON(query))
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+
+	var searchResponse struct {
+		Aggregations struct {
+			Hosts struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int    `json:"doc_count"`
+				} `json:"buckets"`
+				SumOtherDocCount int `json:"sum_other_doc_count"`
+			} `json:"hosts"`
+			MaxBytes struct {
+				Value float64 `json:"value"`
+			} `json:"max_bytes"`
+		} `json:"aggregations"`
+	}
+	assert.NoError(t, json.Unmarshal(response, &searchResponse))
+	buckets := searchResponse.Aggregations.Hosts.Buckets
+	if assert.Len(t, buckets, 2) {
+		assert.Equal(t, "web-2", buckets[0].Key)
+		assert.Equal(t, 7, buckets[0].DocCount)
+		assert.Equal(t, "web-3", buckets[1].Key)
+		assert.Equal(t, 6, buckets[1].DocCount)
+	}
+	// web-1 isn't returned: 12+10 documents in both tables, 7+6 in returned buckets
+	assert.Equal(t, 9, searchResponse.Aggregations.Hosts.SumOtherDocCount)
+	assert.Equal(t, 300.0, searchResponse.Aggregations.MaxBytes.Value)
+}
+
+func TestSortRowsOfMergedTablesWithNullTimestamps(t *testing.T) {
+	ts1 := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+	ts2 := time.Date(2024, 1, 1, 0, 0, 2, 0, time.UTC)
+	var null *time.Time
+	row := func(id int64, timestamp *time.Time) model.QueryResultRow {
+		return model.QueryResultRow{Cols: []model.QueryResultCol{
+			{ColName: "@timestamp", Value: timestamp}, {ColName: "id", Value: id},
+		}}
+	}
+	// Nullable columns are scanned to typed pointers, NULLs (typed nil pointers) go last
+	rows := []model.QueryResultRow{row(1, null), row(2, &ts2), row(3, &ts1), row(4, null)}
+	sortRows(rows, []model.OrderByExpr{model.NewSortColumn("@timestamp", model.DescOrder)})
+
+	var ids []any
+	for _, row := range rows {
+		ids = append(ids, row.Cols[1].Value)
+	}
+	assert.Equal(t, []any{int64(2), int64(3), int64(1), int64(4)}, ids)
+}
+
+func TestSearchQueryCache(t *testing.T) {
+	cfg := config.QuesmaConfiguration{
+		IndexConfig: map[string]config.IndexConfiguration{tableName: {Enabled: true}},
+		QueryCache:  config.QueryCacheConfiguration{Enabled: true},
+	}
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			tableName: {
+				Fields: map[schema.FieldName]schema.Field{
+					"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+				},
+			},
+		},
+	}
+	query := `{"query": {"match_all": {}}, "size": 10, "track_total_hits": false}`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, table)
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	// only one query to the database, the second search is served from cache
+	mock.ExpectQuery(`SELECT .* FROM "logs-generic-default" LIMIT 10`).WillReturnRows(sqlmock.NewRows([]string{"message"}).AddRow("hello"))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	for range 2 {
+		response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+		assert.NoError(t, err)
+		assert.Contains(t, string(response), "hello")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+	assert.Equal(t, int64(1), queryRunner.queryCache.Stats().Hits)
+	assert.Equal(t, int64(1), queryRunner.queryCache.Stats().Misses)
+}
+
+func TestSearchRequestCache(t *testing.T) {
+	cfg := config.QuesmaConfiguration{
+		IndexConfig: map[string]config.IndexConfiguration{tableName: {Enabled: true}},
+		QueryCache:  config.QueryCacheConfiguration{Enabled: true},
+	}
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			tableName: {
+				Fields: map[schema.FieldName]schema.Field{
+					"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+					"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+				},
+			},
+		},
+	}
+	table := concurrent.NewMapWith(tableName, &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewChTableConfigTimestampStringAttr(),
+		Cols: map[string]*clickhouse.Column{
+			"message":    {Name: "message", Type: clickhouse.NewBaseType("String")},
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+		},
+		Created: true,
+	})
+	const (
+		matchAllQuery = `{"query": {"match_all": {}}, "size": 10, "track_total_hits": false}`
+		relativeQuery = `{"query": {"range": {"@timestamp": {"gte": "now-15m"}}}, "size": 10, "track_total_hits": false}`
+	)
+
+	tests := []struct {
+		name         string
+		query        string
+		requestCache string // URL param
+		wantDbQuery  string
+		wantQueries  int // to the database, out of 2 searches
+		wantHits     int64
+	}{
+		{"default: cached", matchAllQuery, "", `SELECT .* FROM "logs-generic-default" LIMIT 10`, 1, 1},
+		{"request_cache=false: bypasses cache", matchAllQuery, "false", `SELECT .* FROM "logs-generic-default" LIMIT 10`, 2, 0},
+		{"default: relative to now() not cached", relativeQuery, "", `SELECT .* FROM "logs-generic-default" WHERE .*now\(\).* LIMIT 10`, 2, 0},
+		{"request_cache=true: relative to now() cached", relativeQuery, "true", `SELECT .* FROM "logs-generic-default" WHERE .*now\(\).* LIMIT 10`, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, table)
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			for range tt.wantQueries {
+				mock.ExpectQuery(tt.wantDbQuery).WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "message"}).AddRow(time.Now(), "hello"))
+			}
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			queryParams := url.Values{}
+			if tt.requestCache != "" {
+				queryParams.Set("request_cache", tt.requestCache)
+			}
+			for range 2 {
+				response, err := queryRunner.handleSearchCommon(ctx, tableName, types.MustJSON(tt.query), nil, nil, nil, QueryLanguageDefault, parseSearchParams(queryParams))
+				assert.NoError(t, err)
+				assert.Contains(t, string(response), "hello")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+			assert.Equal(t, tt.wantHits, queryRunner.queryCache.Stats().Hits)
+		})
+	}
+}
+
+func TestSearchTimePartitionedIndex(t *testing.T) {
+	const january, february = "events_2024_01", "events_2024_02"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"events": {Name: "events", Enabled: true, TablePartitions: &config.TablePartitionsConfiguration{
+			NameLayout: "events_2006_01", Period: config.PartitionPeriodMonth,
+		}},
+	}}
+	newTable := func(name string) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:   name,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+				"message":    {Name: "message", Type: clickhouse.NewBaseType("String")},
+			},
+			Created: true,
+		}
+	}
+	tables := concurrent.NewMapWith(january, newTable(january))
+	tables.Store(february, newTable(february))
+	fields := map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{january: {Fields: fields}, february: {Fields: fields}}}
+	query := `{
+		"query": {"bool": {"filter": [{"range": {"@timestamp": {"gte": "2024-01-10T00:00:00.000Z", "lte": "2024-01-20T00:00:00.000Z", "format": "strict_date_optional_time"}}}]}},
+		"size": 10,
+		"track_total_hits": false
+	}`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, tables)
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	// only January table is queried
+	mock.ExpectQuery(`SELECT "@timestamp", "message" FROM "events_2024_01"`).WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "message"}).
+		AddRow(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), "hello"))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	response, err := queryRunner.handleSearch(ctx, "events", types.MustJSON(query))
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), "hello")
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestSearchRouting(t *testing.T) {
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		"logs": {Name: "logs", Enabled: true, Routing: map[string][]string{"eu": {"logs_eu"}, "us": {"logs_us"}}},
+	}}
+	newTable := func(name string) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:    name,
+			Config:  clickhouse.NewDefaultCHConfig(),
+			Cols:    map[string]*clickhouse.Column{"message": {Name: "message", Type: clickhouse.NewBaseType("String")}},
+			Created: true,
+		}
+	}
+	fields := map[schema.FieldName]schema.Field{"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText}}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{"logs": {Fields: fields}, "logs_eu": {Fields: fields}, "logs_us": {Fields: fields}}}
+
+	tests := []struct {
+		name          string
+		routing       string
+		expectedSqls  []string
+		expectedTable string
+	}{
+		{"not routed", "", []string{`SELECT "message" FROM "logs" LIMIT 10`}, "logs"},
+		{"routed", "eu", []string{`SELECT "message" FROM "logs_eu" LIMIT 10`}, "logs_eu"},
+		{"routed to multiple tables", "eu,us", []string{`SELECT "message" FROM "logs_eu" LIMIT 10`, `SELECT "message" FROM "logs_us" LIMIT 10`}, ""},
+		{"routing without mapping is ignored", "asia", []string{`SELECT "message" FROM "logs" LIMIT 10`}, "logs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := concurrent.NewMapWith("logs", newTable("logs"))
+			tables.Store("logs_eu", newTable("logs_eu"))
+			tables.Store("logs_us", newTable("logs_us"))
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			mock.MatchExpectationsInOrder(false)
+			lm := clickhouse.NewLogManagerWithConnection(db, tables)
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			for _, expectedSql := range tt.expectedSqls {
+				mock.ExpectQuery(testdata.EscapeBrackets(expectedSql)).WillReturnRows(sqlmock.NewRows([]string{"message"}).AddRow("hello"))
+			}
+
+			queryParams := url.Values{}
+			if tt.routing != "" {
+				queryParams.Set("routing", tt.routing)
+			}
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			response, err := queryRunner.handleSearchCommon(ctx, "logs", types.MustJSON(`{"track_total_hits": false}`), nil, nil, nil, QueryLanguageDefault, parseSearchParams(queryParams))
+			assert.NoError(t, err)
+			if tt.expectedTable != "" {
+				assert.Contains(t, string(response), fmt.Sprintf(`"_index":"%s"`, tt.expectedTable))
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestSearchSeqNoSortWithSearchAfter(t *testing.T) {
+	const tableName = "events"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{
+		tableName: {Name: tableName, Enabled: true, SeqNoFields: []string{"@timestamp", "id"}},
+	}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"id":         {Name: "id", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created:     true,
+		SeqNoFields: []string{"@timestamp", "id"},
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"id":         {PropertyName: "id", InternalPropertyName: "id", Type: schema.TypeLong},
+	}}}}
+	// two documents have the same timestamp, so only the tiebreaker makes pages disjoint
+	ts1 := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+	ts2 := time.Date(2024, 1, 1, 0, 0, 2, 0, time.UTC)
+	type document struct {
+		timestamp time.Time
+		id        int64
+	}
+	pages := [][]document{{{ts1, 1}, {ts2, 2}}, {{ts2, 3}, {ts2, 4}}}
+	expectedSql := []string{
+		`SELECT "@timestamp", "id" FROM "events" ORDER BY "@timestamp" ASC, "id" ASC LIMIT 2`,
+		`SELECT "@timestamp", "id" FROM "events" WHERE tuple("@timestamp","id")>tuple(fromUnixTimestamp64Milli(1704067202000),2) ORDER BY "@timestamp" ASC, "id" ASC LIMIT 2`,
+	}
+
+	// search_after pages through hits only, total counts all of them
+	const expectedCountSql = `SELECT count() FROM "events"`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, true)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+
+	var searchAfter []any
+	seenIds := make(map[string]bool)
+	for i, page := range pages {
+		rows := sqlmock.NewRows([]string{"@timestamp", "id"})
+		for _, doc := range page {
+			rows.AddRow(doc.timestamp, doc.id)
+		}
+		mock.ExpectQuery(testdata.EscapeBrackets(expectedSql[i])).WillReturnRows(rows)
+		mock.ExpectQuery(testdata.EscapeBrackets(expectedCountSql) + "$").WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(4)))
+
+		body := map[string]any{"sort": []any{map[string]any{"_seq_no": "asc"}}, "size": 2, "track_total_hits": true}
+		if searchAfter != nil {
+			body["search_after"] = searchAfter
+		}
+		bodyAsBytes, err := json.Marshal(body)
+		assert.NoError(t, err)
+		responseBody, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(string(bodyAsBytes)))
+		assert.NoError(t, err)
+
+		var response model.SearchResp
+		assert.NoError(t, json.Unmarshal(responseBody, &response))
+		assert.Len(t, response.Hits.Hits, len(page))
+		if assert.NotNil(t, response.Hits.Total) {
+			assert.Equal(t, 4, response.Hits.Total.Value)
+		}
+		for _, hit := range response.Hits.Hits {
+			id := fmt.Sprintf("%v", hit.Fields["id"][0])
+			assert.False(t, seenIds[id], "document %s returned twice", id)
+			seenIds[id] = true
+		}
+		searchAfter = response.Hits.Hits[len(response.Hits.Hits)-1].Sort
+	}
+	assert.Len(t, seenIds, 4)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestSearchStreamedHitsSameAsBuffered(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{
+		IndexConfig:         map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}},
+		StreamHitsThreshold: 10,
+	}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"message":    {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+	query := types.MustJSON(`{"query": {"match_all": {}}, "sort": [{"@timestamp": "desc"}], "size": 50, "track_total_hits": true}`)
+
+	expectQueries := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(123)))
+		hitsRows := sqlmock.NewRows([]string{"@timestamp", "message"})
+		for i := 0; i < 25; i++ {
+			hitsRows.AddRow(time.Date(2024, 1, 1, 0, 0, 25-i, 0, time.UTC), fmt.Sprintf(`message "%d" <b>`, i))
+		}
+		mock.ExpectQuery(`SELECT "@timestamp", "message" FROM "logs"`).WillReturnRows(hitsRows)
+	}
+	newQueryRunner := func(t *testing.T) (*QueryRunner, sqlmock.Sqlmock) {
+		db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+		t.Cleanup(func() { db.Close() })
+		lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+		managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+		return NewQueryRunner(lm, cfg, nil, managementConsole, s), mock
+	}
+
+	queryRunner, mock := newQueryRunner(t)
+	expectQueries(mock)
+	buffered, err := queryRunner.handleSearch(ctx, tableName, query)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	queryRunner, mock = newQueryRunner(t)
+	expectQueries(mock)
+	responseBody, writeResponse, err := queryRunner.handleSearchStreamed(ctx, tableName, query, defaultSearchParams)
+	assert.NoError(t, err)
+	assert.Nil(t, responseBody)
+	if assert.NotNil(t, writeResponse) {
+		var streamed strings.Builder
+		assert.NoError(t, writeResponse(&streamed))
+		assert.JSONEq(t, string(buffered), streamed.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Nothing is written, if the hits query fails before the first row, so a normal error response can be sent instead
+	queryRunner, mock = newQueryRunner(t)
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(123)))
+	mock.ExpectQuery(`SELECT "@timestamp", "message" FROM "logs"`).WillReturnError(errors.New("connection reset"))
+	_, writeResponse, err = queryRunner.handleSearchStreamed(ctx, tableName, query, defaultSearchParams)
+	assert.NoError(t, err)
+	if assert.NotNil(t, writeResponse) {
+		var streamed strings.Builder
+		assert.Error(t, writeResponse(&streamed))
+		assert.Empty(t, streamed.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var response model.SearchResp
+	assert.NoError(t, json.Unmarshal(buffered, &response))
+	assert.Len(t, response.Hits.Hits, 25)
+	assert.Equal(t, 123, response.Hits.Total.Value)
+}
+
+func TestSearchNotStreamed(t *testing.T) {
+	const tableName = "logs"
+	tests := []struct {
+		name       string
+		queryCache config.QueryCacheConfiguration
+		query      string
+		urlParams  url.Values
+	}{
+		{"size below threshold", config.QueryCacheConfiguration{}, `{"size": 10, "track_total_hits": true}`, nil},
+		{"cached", config.QueryCacheConfiguration{Enabled: true, TTL: time.Minute, MaxSize: 10}, `{"size": 100, "track_total_hits": true}`, url.Values{"request_cache": {"true"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.QuesmaConfiguration{
+				IndexConfig:         map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}},
+				StreamHitsThreshold: 100,
+				QueryCache:          tt.queryCache,
+			}
+			table := &clickhouse.Table{
+				Name:    tableName,
+				Config:  clickhouse.NewDefaultCHConfig(),
+				Cols:    map[string]*clickhouse.Column{"message": {Name: "message", Type: clickhouse.NewBaseType("String")}},
+				Created: true,
+			}
+			s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+				"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+			}}}}
+
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			mock.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(1)))
+			mock.ExpectQuery(`SELECT "message" FROM "logs"`).WillReturnRows(sqlmock.NewRows([]string{"message"}).AddRow("hello"))
+
+			responseBody, writeResponse, err := queryRunner.handleSearchStreamed(ctx, tableName, types.MustJSON(tt.query), parseSearchParams(tt.urlParams))
+			assert.NoError(t, err)
+			assert.Nil(t, writeResponse)
+			assert.Contains(t, string(responseBody), "hello")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSearchPreWhere(t *testing.T) {
+	const tableName = "logs"
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"service":    {Name: "service", Type: clickhouse.NewBaseType("LowCardinality(String)")},
+			"message":    {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"service":    {PropertyName: "service", InternalPropertyName: "service", Type: schema.TypeKeyword},
+		"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+	query := `{
+		"query": {"bool": {"filter": [
+			{"range": {"@timestamp": {"gte": "2024-01-10T00:00:00.000Z", "lte": "2024-01-20T00:00:00.000Z", "format": "strict_date_optional_time"}}},
+			{"term": {"service": "api"}},
+			{"match_phrase": {"message": "error"}}
+		]}},
+		"size": 10,
+		"track_total_hits": false
+	}`
+
+	for _, preWhere := range []bool{true, false} {
+		t.Run(fmt.Sprintf("preWhere=%t", preWhere), func(t *testing.T) {
+			cfg := config.QuesmaConfiguration{PreWhere: preWhere, IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+
+			timestampRange := `("@timestamp">=parseDateTime64BestEffort('2024-01-10T00:00:00.000Z') AND "@timestamp"<=parseDateTime64BestEffort('2024-01-20T00:00:00.000Z'))`
+			expectedSql := `SELECT "@timestamp", "message", "service" FROM "logs" WHERE ((` + timestampRange + ` AND "service"='api') AND "message" iLIKE '%error%') LIMIT 10`
+			if preWhere {
+				expectedSql = `SELECT "@timestamp", "message", "service" FROM "logs" PREWHERE (` + timestampRange + ` AND "service"='api') WHERE "message" iLIKE '%error%' LIMIT 10`
+			}
+			mock.ExpectQuery(testdata.EscapeBrackets(expectedSql)).WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "message", "service"}).
+				AddRow(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), "error", "api"))
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			_, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+			assert.NoError(t, err)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestSearchBaselineFilter(t *testing.T) {
+	const tableName = "logs"
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"service": {Name: "service", Type: clickhouse.NewBaseType("String")},
+			"level":   {Name: "level", Type: clickhouse.NewBaseType("String")},
+			"bytes":   {Name: "bytes", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"service": {PropertyName: "service", InternalPropertyName: "service", Type: schema.TypeKeyword},
+		"level":   {PropertyName: "level", InternalPropertyName: "level", Type: schema.TypeKeyword},
+		"bytes":   {PropertyName: "bytes", InternalPropertyName: "bytes", Type: schema.TypeLong},
+	}}}}
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true,
+		BaselineFilter: `{"bool": {"must_not": {"term": {"level": "debug"}}}}`}}}
+
+	tests := []struct {
+		name        string
+		query       string
+		expectedSql string
+	}{
+		{
+			name:        "no query",
+			query:       `{"size": 10, "track_total_hits": false}`,
+			expectedSql: `SELECT "bytes", "level", "service" FROM "logs" WHERE NOT ("level"='debug') LIMIT 10`,
+		},
+		{
+			name:        "query",
+			query:       `{"query": {"term": {"service": "api"}}, "size": 10, "track_total_hits": false}`,
+			expectedSql: `SELECT "bytes", "level", "service" FROM "logs" WHERE ("service"='api' AND NOT ("level"='debug')) LIMIT 10`,
+		},
+		{
+			name:        "aggregation",
+			query:       `{"aggs": {"services": {"terms": {"field": "service"}}}, "size": 0, "track_total_hits": false}`,
+			expectedSql: `SELECT "service", count(), sum(count()) OVER () AS "total_doc_count" FROM "logs" WHERE NOT ("level"='debug') GROUP BY "service" ORDER BY count() DESC LIMIT 10`,
+		},
+		{
+			name:  "variable_width_histogram, bucket edges computed over filtered documents",
+			query: `{"aggs": {"sizes": {"variable_width_histogram": {"field": "bytes", "buckets": 2}}}, "size": 0, "track_total_hits": false}`,
+			expectedSql: `SELECT arrayCount((edge) -> "edge"<="bytes","variable_width_histogram_edges"), count(), minOrNull("bytes"), maxOrNull("bytes"), avgOrNull("bytes") ` +
+				`FROM (SELECT *, quantiles(0.500000)("bytes") OVER () AS "variable_width_histogram_edges" FROM "logs" WHERE ("bytes" IS NOT NULL AND NOT ("level"='debug'))) ` +
+				`GROUP BY arrayCount((edge) -> "edge"<="bytes","variable_width_histogram_edges") ORDER BY arrayCount((edge) -> "edge"<="bytes","variable_width_histogram_edges")`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			mock.ExpectQuery(testdata.EscapeWildcard(testdata.EscapeBrackets(tt.expectedSql))).WillReturnRows(sqlmock.NewRows([]string{"level", "service"}))
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			_, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(tt.query))
+			assert.NoError(t, err)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestBaselineFilterOfTableDiscoveredLater(t *testing.T) {
+	const tableName = "logs"
+	cfg := map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true, BaselineFilter: `{"term": {"level": "debug"}}`}}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"level": {PropertyName: "level", InternalPropertyName: "level", Type: schema.TypeKeyword},
+	}}}}
+	lm := clickhouse.NewLogManagerEmpty()
+	pass := NewBaselineFilterPass(cfg, lm, s)
+
+	assert.Nil(t, pass.filterFor(tableName))
+	lm.AddTableIfDoesntExist(&clickhouse.Table{Name: tableName, Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{"level": {Name: "level", Type: clickhouse.NewBaseType("String")}}})
+	assert.Equal(t, `"level"='debug'`, model.AsString(pass.filterFor(tableName)))
+}
+
+func TestSearchCollapse(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"user.id":    {Name: "user.id", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"user.id":    {PropertyName: "user.id", InternalPropertyName: "user.id", Type: schema.TypeKeyword},
+	}}}}
+	query := `{"collapse": {"field": "user.id"}, "sort": [{"@timestamp": {"order": "desc"}}], "size": 10, "track_total_hits": false}`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	mock.ExpectQuery(testdata.EscapeWildcard(testdata.EscapeBrackets(`SELECT "@timestamp", "user.id" FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY "user.id" ORDER BY "@timestamp" DESC) AS "row_number" FROM "logs") WHERE "row_number"=1 ORDER BY "@timestamp" DESC LIMIT 10`))).
+		WillReturnRows(sqlmock.NewRows([]string{"@timestamp", "user.id"}).
+			AddRow(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), "alice").
+			AddRow(time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC), "bob"))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+	assert.NoError(t, err)
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(response, &searchResponse))
+	var userIds []string
+	for _, hit := range searchResponse.Hits.Hits {
+		var source map[string]any
+		assert.NoError(t, json.Unmarshal(hit.Source, &source))
+		userIds = append(userIds, source["user.id"].(string))
+	}
+	assert.Equal(t, []string{"alice", "bob"}, userIds)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestSearchCollapseInnerHits(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"user.id":    {Name: "user.id", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"user.id":    {PropertyName: "user.id", InternalPropertyName: "user.id", Type: schema.TypeKeyword},
+	}}}}
+	query := `{"collapse": {"field": "user.id", "inner_hits": {"name": "oldest", "size": 1, "sort": [{"@timestamp": "asc"}]}},
+		"sort": [{"@timestamp": {"order": "desc"}}], "size": 10, "track_total_hits": false}`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	// helper columns are selected along with the table columns, and LIMIT covers every hit with its inner hit
+	mock.ExpectQuery(`^SELECT "row_number", "inner_hits_row_number", "inner_hits_total", "user.id" AS "inner_hits_collapse_key", "@timestamp", "user.id" FROM .* LIMIT 20$`).
+		WillReturnRows(sqlmock.NewRows([]string{"row_number", "inner_hits_row_number", "inner_hits_total", "inner_hits_collapse_key", "@timestamp", "user.id"}).
+			AddRow(uint64(1), uint64(2), uint64(2), "alice", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), "alice").
+			AddRow(uint64(1), uint64(1), uint64(1), "bob", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC), "bob").
+			AddRow(uint64(2), uint64(1), uint64(2), "alice", time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC), "alice"))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(response, &searchResponse))
+	if assert.Len(t, searchResponse.Hits.Hits, 2) {
+		alice := searchResponse.Hits.Hits[0]
+		assert.Equal(t, []any{"alice"}, alice.Fields["user.id"])
+		assert.NotContains(t, string(alice.Source), model.InnerHitsCollapseKeyColumnName)
+		oldest := alice.InnerHits["oldest"].Hits
+		assert.Equal(t, 2, oldest.Total.Value)
+		if assert.Len(t, oldest.Hits, 1) {
+			assert.Contains(t, string(oldest.Hits[0].Source), "2024-01-13")
+		}
+		assert.Equal(t, []any{"bob"}, searchResponse.Hits.Hits[1].Fields["user.id"])
+	}
+}
+
+func TestSearchStoredFields(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"message":    {Name: "message", Type: clickhouse.NewBaseType("String")},
+			"user":       {Name: "user", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+		"user":       {PropertyName: "user", InternalPropertyName: "user", Type: schema.TypeKeyword},
+	}}}}
+	timestamp := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		query        string
+		expectedSql  string
+		columns      []string
+		row          []driver.Value
+		wantFields   []string
+		wantSource   bool
+		wantNoFields bool
+	}{
+		{
+			name:        "stored fields list",
+			query:       `{"stored_fields": ["user", "not-existing"], "size": 10, "track_total_hits": false}`,
+			expectedSql: `SELECT "user" FROM "logs" LIMIT 10`,
+			columns:     []string{"user"},
+			row:         []driver.Value{"alice"},
+			wantFields:  []string{"user"},
+		},
+		{
+			name:        "stored fields list with _source",
+			query:       `{"stored_fields": ["user", "message"], "_source": true, "size": 10, "track_total_hits": false}`,
+			expectedSql: `SELECT "user", "message" FROM "logs" LIMIT 10`,
+			columns:     []string{"user", "message"},
+			row:         []driver.Value{"alice", "hello"},
+			wantFields:  []string{"message", "user"},
+			wantSource:  true,
+		},
+		{
+			name:         "stored fields _none_",
+			query:        `{"stored_fields": "_none_", "size": 10, "track_total_hits": false}`,
+			expectedSql:  `SELECT "@timestamp", "message", "user" FROM "logs" LIMIT 10`,
+			columns:      []string{"@timestamp", "message", "user"},
+			row:          []driver.Value{timestamp, "hello", "alice"},
+			wantNoFields: true,
+		},
+		{
+			name:        "all stored fields",
+			query:       `{"stored_fields": ["*"], "size": 10, "track_total_hits": false}`,
+			expectedSql: `SELECT "@timestamp", "message", "user" FROM "logs" LIMIT 10`,
+			columns:     []string{"@timestamp", "message", "user"},
+			row:         []driver.Value{timestamp, "hello", "alice"},
+			wantFields:  []string{"@timestamp", "message", "user"},
+			wantSource:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			mock.ExpectQuery(testdata.EscapeBrackets(tt.expectedSql)).WillReturnRows(sqlmock.NewRows(tt.columns).AddRow(tt.row...))
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(tt.query))
+			assert.NoError(t, err)
+			var searchResponse model.SearchResp
+			assert.NoError(t, json.Unmarshal(response, &searchResponse))
+			if assert.Len(t, searchResponse.Hits.Hits, 1) {
+				hit := searchResponse.Hits.Hits[0]
+				if tt.wantNoFields {
+					assert.Empty(t, hit.Fields)
+				} else {
+					assert.ElementsMatch(t, tt.wantFields, maps.Keys(hit.Fields))
+				}
+				assert.Equal(t, tt.wantSource, len(hit.Source) > 0)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestSearchSourceFiltering(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"message":   {Name: "message", Type: clickhouse.NewBaseType("String")},
+			"user.name": {Name: "user.name", Type: clickhouse.NewBaseType("String")},
+			"user.id":   {Name: "user.id", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"message":   {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+		"user.name": {PropertyName: "user.name", InternalPropertyName: "user.name", Type: schema.TypeKeyword},
+		"user.id":   {PropertyName: "user.id", InternalPropertyName: "user.id", Type: schema.TypeLong},
+	}}}}
+
+	tests := []struct {
+		name       string
+		query      string
+		urlParams  url.Values
+		wantSource []string
+	}{
+		{
+			name:       "no filtering",
+			query:      `{"size": 10, "track_total_hits": false}`,
+			wantSource: []string{"message", "user.id", "user.name"},
+		},
+		{
+			name:       "body includes",
+			query:      `{"_source": ["message"], "size": 10, "track_total_hits": false}`,
+			wantSource: []string{"message"},
+		},
+		{
+			name:       "URL params only",
+			query:      `{"size": 10, "track_total_hits": false}`,
+			urlParams:  url.Values{"_source_includes": {"user"}, "_source_excludes": {"user.id"}},
+			wantSource: []string{"user.name"},
+		},
+		{
+			name:       "URL params with wildcard",
+			query:      `{"size": 10, "track_total_hits": false}`,
+			urlParams:  url.Values{"_source_includes": {"mess*,user.id"}},
+			wantSource: []string{"message", "user.id"},
+		},
+		{
+			name:       "body overrides URL params",
+			query:      `{"_source": {"includes": ["message", "user.*"], "excludes": []}, "size": 10, "track_total_hits": false}`,
+			urlParams:  url.Values{"_source_includes": {"message"}, "_source_excludes": {"user.name"}},
+			wantSource: []string{"message", "user.id"},
+		},
+		{
+			name:       "body includes and URL excludes",
+			query:      `{"_source": "user", "size": 10, "track_total_hits": false}`,
+			urlParams:  url.Values{"_source_excludes": {"user.name"}},
+			wantSource: []string{"user.id"},
+		},
+		{
+			name:       "_source: true and URL params",
+			query:      `{"_source": true, "size": 10, "track_total_hits": false}`,
+			urlParams:  url.Values{"_source_includes": {"user.*"}},
+			wantSource: []string{"user.id", "user.name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			mock.ExpectQuery(testdata.EscapeBrackets(`SELECT "message", "user.id", "user.name" FROM "logs" LIMIT 10`)).
+				WillReturnRows(sqlmock.NewRows([]string{"message", "user.id", "user.name"}).AddRow("hello", int64(7), "alice"))
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			body := types.MustJSON(tt.query)
+			response, err := queryRunner.handleSearchCommon(ctx, tableName, body, nil, nil, nil, QueryLanguageDefault, parseSearchParams(tt.urlParams))
+			assert.NoError(t, err)
+			assert.Equal(t, types.MustJSON(tt.query), body, "URL params mustn't change the request body")
+			var searchResponse model.SearchResp
+			assert.NoError(t, json.Unmarshal(response, &searchResponse))
+			if assert.Len(t, searchResponse.Hits.Hits, 1) {
+				var source map[string]any
+				assert.NoError(t, json.Unmarshal(searchResponse.Hits.Hits[0].Source, &source))
+				assert.ElementsMatch(t, tt.wantSource, maps.Keys(source))
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestAsyncSearchSourceDisabled(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	mock.ExpectQuery(testdata.EscapeBrackets(`SELECT "message" FROM "logs" LIMIT 10`)).
+		WillReturnRows(sqlmock.NewRows([]string{"message"}).AddRow("hello"))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	response, err := queryRunner.handleAsyncSearch(ctx, tableName, types.MustJSON(`{"_source": false, "size": 10, "track_total_hits": false}`),
+		defaultAsyncSearchTimeout, true, defaultSearchParams)
+	assert.NoError(t, err)
+	var asyncResponse model.AsyncSearchEntireResp
+	assert.NoError(t, json.Unmarshal(response, &asyncResponse))
+	if assert.Len(t, asyncResponse.Response.Hits.Hits, 1) {
+		assert.Empty(t, asyncResponse.Response.Hits.Hits[0].Source)
+	}
+	assert.NotContains(t, string(response), `"_source"`)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestSearchDocValueFieldsWildcard(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"@timestamp": {Name: "@timestamp", Type: clickhouse.NewBaseType("DateTime64")},
+			"message":    {Name: "message", Type: clickhouse.NewBaseType("String")},
+			"host":       {Name: "host", Type: clickhouse.NewBaseType("String")},
+			"user":       {Name: "user", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"@timestamp": {PropertyName: "@timestamp", InternalPropertyName: "@timestamp", Type: schema.TypeTimestamp},
+		"message":    {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeKeyword},
+		"host":       {PropertyName: "host", InternalPropertyName: "host", Type: schema.TypeText},
+		"user":       {PropertyName: "user", InternalPropertyName: "user", Type: schema.TypeText},
+	}}}}
+	timestamp := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	mock.ExpectQuery(testdata.EscapeBrackets(`SELECT "message", "host", "user", "@timestamp" FROM "logs" LIMIT 10`)).
+		WillReturnRows(sqlmock.NewRows([]string{"message", "host", "user", "@timestamp"}).AddRow("hello", "host1", "alice", timestamp))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	// host and user are text fields, so only they have .keyword subfields
+	query := `{"stored_fields": ["message"], "docvalue_fields": ["*.keyword", {"field": "@timestamp", "format": "epoch_millis"}], "size": 10, "track_total_hits": false}`
+	response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+	assert.NoError(t, err)
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(response, &searchResponse))
+	if assert.Len(t, searchResponse.Hits.Hits, 1) {
+		fields := searchResponse.Hits.Hits[0].Fields
+		assert.Equal(t, []interface{}{"host1"}, fields["host"])
+		assert.Equal(t, []interface{}{"alice"}, fields["user"])
+		assert.Equal(t, []interface{}{"1705276800000"}, fields["@timestamp"])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestSearchDefaultQuery(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"level":   {PropertyName: "level", InternalPropertyName: "level", Type: schema.TypeKeyword},
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+	newTable := func(defaultQuery string, rejectUnboundedScans bool) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:   tableName,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"level":   {Name: "level", Type: clickhouse.NewBaseType("String")},
+				"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+			},
+			Created:              true,
+			DefaultQuery:         defaultQuery,
+			RejectUnboundedScans: rejectUnboundedScans,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		table        *clickhouse.Table
+		query        string
+		expectedSqls []string
+		wantErr      bool
+	}{
+		{
+			name:  "empty body, default query",
+			table: newTable(`{"term": {"level": "error"}}`, true),
+			query: `{}`,
+			expectedSqls: []string{
+				`SELECT count() FROM (SELECT 1 FROM "logs" WHERE "level"='error' LIMIT 10000)`,
+				`SELECT "level", "message" FROM "logs" WHERE "level"='error' LIMIT 10`,
+			},
+		},
+		{
+			name:         "explicit query overrides default query",
+			table:        newTable(`{"term": {"level": "error"}}`, false),
+			query:        `{"query": {"match_all": {}}, "track_total_hits": false}`,
+			expectedSqls: []string{`SELECT "level", "message" FROM "logs" LIMIT 10`},
+		},
+		{
+			name:    "empty body, unbounded scans rejected",
+			table:   newTable("", true),
+			query:   `{}`,
+			wantErr: true,
+		},
+		{
+			name:         "empty body, unbounded scans allowed",
+			table:        newTable("", false),
+			query:        `{"track_total_hits": false}`,
+			expectedSqls: []string{`SELECT "level", "message" FROM "logs" LIMIT 10`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, tt.table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			for _, expectedSql := range tt.expectedSqls {
+				mock.ExpectQuery(testdata.EscapeBrackets(expectedSql)).WillReturnRows(sqlmock.NewRows([]string{"level", "message"}))
+			}
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			_, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(tt.query))
+			if tt.wantErr {
+				var endUserError *end_user_errors.EndUserError
+				if assert.ErrorAs(t, err, &endUserError) {
+					assert.Equal(t, end_user_errors.ErrUnboundedScan, endUserError.ErrorType())
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestSearchMaxResultWindow(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"level":   {PropertyName: "level", InternalPropertyName: "level", Type: schema.TypeKeyword},
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+	}}}}
+	newTable := func(maxResultWindow int) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:   tableName,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"level":   {Name: "level", Type: clickhouse.NewBaseType("String")},
+				"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+			},
+			Created:         true,
+			MaxResultWindow: maxResultWindow,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		table        *clickhouse.Table
+		query        string
+		expectedSqls []string
+		wantErr      string
+	}{
+		{
+			name:         "at the limit",
+			table:        newTable(100),
+			query:        `{"from": 0, "size": 100, "track_total_hits": false}`,
+			expectedSqls: []string{`SELECT "level", "message" FROM "logs" LIMIT 100`},
+		},
+		{
+			name:    "over the limit",
+			table:   newTable(100),
+			query:   `{"from": 95, "size": 10, "track_total_hits": false}`,
+			wantErr: "from + size must be less than or equal to: [100] but was [105]",
+		},
+		{
+			name:    "over the default limit",
+			table:   newTable(0),
+			query:   `{"size": 10001, "track_total_hits": false}`,
+			wantErr: "from + size must be less than or equal to: [10000] but was [10001]",
+		},
+		{
+			name:         "over the limit, but with search_after",
+			table:        newTable(100),
+			query:        `{"from": 95, "size": 10, "search_after": ["info"], "sort": [{"level": "asc"}], "track_total_hits": false}`,
+			expectedSqls: []string{`SELECT "level", "message" FROM "logs" WHERE tuple("level")>tuple('info') ORDER BY "level" ASC LIMIT 10`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, tt.table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			for _, expectedSql := range tt.expectedSqls {
+				mock.ExpectQuery(testdata.EscapeBrackets(expectedSql)).WillReturnRows(sqlmock.NewRows([]string{"level", "message"}))
+			}
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			_, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(tt.query))
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, quesma_errors.ErrResultWindowTooLarge())
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				assert.NoError(t, err, "there were unfulfilled expections:")
+			}
+		})
+	}
+}
+
+func TestSearchDecimalColumn(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:    tableName,
+		Config:  clickhouse.NewDefaultCHConfig(),
+		Cols:    map[string]*clickhouse.Column{"price": {Name: "price", Type: clickhouse.NewBaseType("Decimal(10, 2)")}},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"price": {PropertyName: "price", InternalPropertyName: "price", Type: schema.TypeFloat},
+	}}}}
+
+	t.Run("range filter", func(t *testing.T) {
+		db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+		defer db.Close()
+		lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+		managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+		mock.ExpectQuery(testdata.EscapeBrackets(`SELECT "price" FROM "logs" WHERE "price">=toDecimal128('10.5',2) LIMIT 10`)).
+			WillReturnRows(sqlmock.NewRowsWithColumnDefinition(sqlmock.NewColumn("price").OfType("Decimal(10, 2)", decimal.Decimal{})).
+				AddRow(decimal.New(1230, -2)))
+
+		queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+		query := `{"query": {"range": {"price": {"gte": 10.5}}}, "size": 10, "track_total_hits": false}`
+		response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+		assert.NoError(t, err)
+		var searchResponse model.SearchResp
+		assert.NoError(t, json.Unmarshal(response, &searchResponse))
+		if assert.Len(t, searchResponse.Hits.Hits, 1) {
+			assert.Contains(t, string(searchResponse.Hits.Hits[0].Source), `"price":12.30`)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			assert.NoError(t, err, "there were unfulfilled expections:")
+		}
+	})
+
+	t.Run("range filter with more digits than the column", func(t *testing.T) {
+		db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+		defer db.Close()
+		lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+		managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+		mock.ExpectQuery(testdata.EscapeBrackets(`SELECT "price" FROM "logs" WHERE "price">toDecimal128('0.125',3) LIMIT 10`)).
+			WillReturnRows(sqlmock.NewRowsWithColumnDefinition(sqlmock.NewColumn("price").OfType("Decimal(10, 2)", decimal.Decimal{})))
+
+		queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+		query := `{"query": {"range": {"price": {"gt": 0.125}}}, "size": 10, "track_total_hits": false}`
+		_, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+		assert.NoError(t, err)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			assert.NoError(t, err, "there were unfulfilled expections:")
+		}
+	})
+
+	t.Run("avg aggregation", func(t *testing.T) {
+		db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+		defer db.Close()
+		lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+		managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+		mock.ExpectQuery(testdata.EscapeBrackets(`SELECT avgOrNull("price") FROM "logs"`)).
+			WillReturnRows(sqlmock.NewRowsWithColumnDefinition(sqlmock.NewColumn(`avgOrNull("price")`).OfType("Nullable(Float64)", float64(0))).
+				AddRow(11.15))
+
+		queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+		query := `{"aggs": {"avg_price": {"avg": {"field": "price"}}}, "size": 0, "track_total_hits": false}`
+		response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(query))
+		assert.NoError(t, err)
+		var responseMap model.JsonMap
+		assert.NoError(t, json.Unmarshal(response, &responseMap))
+		assert.Equal(t, 11.15, responseMap["aggregations"].(model.JsonMap)["avg_price"].(model.JsonMap)["value"])
+		if err := mock.ExpectationsWereMet(); err != nil {
+			assert.NoError(t, err, "there were unfulfilled expections:")
+		}
+	})
+}
+
+func TestSearchAllowPartialSearchResults(t *testing.T) {
+	tableNames := []string{"logs", "logs2"}
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{}}
+	tables := concurrent.NewMap[string, *clickhouse.Table]()
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{}}
+	for _, tableName := range tableNames {
+		cfg.IndexConfig[tableName] = config.IndexConfiguration{Name: tableName, Enabled: true}
+		tables.Store(tableName, &clickhouse.Table{
+			Name:   tableName,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+				"user":    {Name: "user", Type: clickhouse.NewBaseType("String")},
+			},
+			Created: true,
+		})
+		s.tables[schema.TableName(tableName)] = schema.Schema{Fields: map[schema.FieldName]schema.Field{
+			"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+			"user":    {PropertyName: "user", InternalPropertyName: "user", Type: schema.TypeKeyword},
+		}}
+	}
+	const hitsAndAggregation = `{"size": 5, "track_total_hits": false, "aggs": {"by_user": {"terms": {"field": "user"}}}}`
+	const aggregationOnly = `{"size": 0, "track_total_hits": false, "aggs": {"by_user": {"terms": {"field": "user"}}}}`
+	failure := errors.New("simulated failure")
+	hitsRows := sqlmock.NewRows([]string{"message", "user"}).AddRow("hello", "alice")
+	aggregationRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user", "count()", "total_doc_count"}).AddRow("alice", uint64(1), uint64(1))
+	}
+
+	tests := []struct {
+		name                      string
+		indexPattern              string
+		query                     string
+		allowPartialSearchResults bool
+		expectQueries             func(mock sqlmock.Sqlmock)
+		wantErr                   bool
+		wantShards                model.ResponseShards // without failures, which are checked by wantFailedIndexes
+		wantFailedIndexes         []any
+	}{
+		{
+			name: "aggregation fails, hits are returned", indexPattern: "logs", query: hitsAndAggregation, allowPartialSearchResults: true,
+			expectQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`GROUP BY`).WillReturnError(failure)
+				mock.ExpectQuery(`LIMIT 5`).WillReturnRows(hitsRows)
+			},
+			wantShards:        model.ResponseShards{Total: 1, Successful: 0, Failed: 1},
+			wantFailedIndexes: []any{"logs"},
+		},
+		{
+			name: "aggregation fails, partial results not allowed", indexPattern: "logs", query: hitsAndAggregation, allowPartialSearchResults: false,
+			expectQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`GROUP BY`).WillReturnError(failure)
+				mock.ExpectQuery(`LIMIT 5`).WillReturnRows(hitsRows)
+			},
+			wantErr: true,
+		},
+		{
+			name: "all queries fail", indexPattern: "logs", query: aggregationOnly, allowPartialSearchResults: true,
+			expectQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`GROUP BY`).WillReturnError(failure)
+			},
+			wantErr: true,
+		},
+		{
+			name: "one of two tables fails", indexPattern: "logs*", query: aggregationOnly, allowPartialSearchResults: true,
+			expectQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "logs2"`).WillReturnError(failure)
+				mock.ExpectQuery(`FROM "logs" `).WillReturnRows(aggregationRows())
+			},
+			wantShards:        model.ResponseShards{Total: 2, Successful: 1, Failed: 1},
+			wantFailedIndexes: []any{"logs2"},
+		},
+		{
+			name: "all tables succeed", indexPattern: "logs*", query: aggregationOnly, allowPartialSearchResults: true,
+			expectQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "logs2"`).WillReturnRows(aggregationRows())
+				mock.ExpectQuery(`FROM "logs" `).WillReturnRows(aggregationRows())
+			},
+			wantShards: model.ResponseShards{Total: 2, Successful: 2, Failed: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			mock.MatchExpectationsInOrder(false)
+			lm := clickhouse.NewLogManagerWithConnection(db, tables)
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			tt.expectQueries(mock)
+
+			queryRunner := NewQueryRunner(lm, cfg, NewFixedIndexManagement(), managementConsole, s)
+			response, _, err := queryRunner.handleSearchStreamed(ctx, tt.indexPattern, types.MustJSON(tt.query), searchParams{allowPartialSearchResults: tt.allowPartialSearchResults})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			var searchResponse model.SearchResp
+			assert.NoError(t, json.Unmarshal(response, &searchResponse))
+			var failedIndexes []any
+			for _, failure := range searchResponse.Shards.Failures {
+				failedIndexes = append(failedIndexes, failure.Index)
+				assert.Contains(t, failure.Reason.Reason, "simulated failure")
+			}
+			assert.Equal(t, tt.wantFailedIndexes, failedIndexes)
+			searchResponse.Shards.Failures = nil
+			assert.Equal(t, tt.wantShards, searchResponse.Shards)
+		})
+	}
+}
+
+func TestSearchQueryTimeout(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+		"user":    {PropertyName: "user", InternalPropertyName: "user", Type: schema.TypeKeyword},
+	}}}}
+	newTable := func(queryTimeout time.Duration) *clickhouse.Table {
+		return &clickhouse.Table{
+			Name:   tableName,
+			Config: clickhouse.NewDefaultCHConfig(),
+			Cols: map[string]*clickhouse.Column{
+				"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+				"user":    {Name: "user", Type: clickhouse.NewBaseType("String")},
+			},
+			Created:      true,
+			QueryTimeout: queryTimeout,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		table        *clickhouse.Table
+		query        string
+		wantTimedOut bool
+	}{
+		{
+			name:         "timeout of the request",
+			table:        newTable(0),
+			query:        `{"size": 5, "track_total_hits": false, "timeout": "50ms", "aggs": {"by_user": {"terms": {"field": "user"}}}}`,
+			wantTimedOut: true,
+		},
+		{
+			name:         "default timeout of the index",
+			table:        newTable(50 * time.Millisecond),
+			query:        `{"size": 5, "track_total_hits": false, "aggs": {"by_user": {"terms": {"field": "user"}}}}`,
+			wantTimedOut: true,
+		},
+		{
+			name:  "timeout of the request overrides the index's",
+			table: newTable(50 * time.Millisecond),
+			query: `{"size": 5, "track_total_hits": false, "timeout": "1m", "aggs": {"by_user": {"terms": {"field": "user"}}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+			defer db.Close()
+			mock.MatchExpectationsInOrder(false)
+			lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, tt.table))
+			managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+			// aggregation query is slow, hits query is fast
+			mock.ExpectQuery(`GROUP BY`).WillDelayFor(500 * time.Millisecond).
+				WillReturnRows(sqlmock.NewRows([]string{"user", "count()"}).AddRow("alice", uint64(1)))
+			mock.ExpectQuery(`LIMIT 5`).WillReturnRows(sqlmock.NewRows([]string{"message", "user"}).AddRow("hello", "alice"))
+
+			queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+			response, err := queryRunner.handleSearch(ctx, tableName, types.MustJSON(tt.query))
+			assert.NoError(t, err)
+			var searchResponse model.SearchResp
+			assert.NoError(t, json.Unmarshal(response, &searchResponse))
+			assert.Equal(t, tt.wantTimedOut, searchResponse.Timeout)
+			assert.Len(t, searchResponse.Hits.Hits, 1)
+		})
+	}
+}
+
+func TestParseTimeValue(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"10s": 10 * time.Second, "500ms": 500 * time.Millisecond, "1.5m": 90 * time.Second, "2h": 2 * time.Hour,
+		"1d": 24 * time.Hour, "100micros": 100 * time.Microsecond, "7nanos": 7 * time.Nanosecond,
+	} {
+		timeout, err := parseTimeValue(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, timeout, value)
+	}
+	for _, value := range []string{"10", "s", "-1s", "abc"} {
+		_, err := parseTimeValue(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSearchTermsOverArrayColumn(t *testing.T) {
+	const tableName = "logs"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"tags":  {Name: "tags", Type: clickhouse.CompoundType{Name: "Array", BaseType: clickhouse.NewBaseType("String")}},
+			"bytes": {Name: "bytes", Type: clickhouse.NewBaseType("Int64")},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"tags":  {PropertyName: "tags", InternalPropertyName: "tags", Type: schema.TypeKeyword.AsArray()},
+		"bytes": {PropertyName: "bytes", InternalPropertyName: "bytes", Type: schema.TypeLong},
+	}}}}
+	query := types.MustJSON(`{
+		"size": 0,
+		"track_total_hits": false,
+		"query": {"term": {"tags": "web"}},
+		"aggs": {"tag_cloud": {"terms": {"field": "tags"}, "aggs": {"avg_bytes": {"avg": {"field": "bytes"}}}}}
+	}`)
+	const expectedTermsSql = `SELECT "tags", count() FROM (SELECT "tags" FROM "logs" WHERE has("tags",'web')) ARRAY JOIN "tags" ` +
+		`GROUP BY "tags" ORDER BY "tags"`
+	const expectedAvgSql = `SELECT "tags", avgOrNull("bytes") FROM (SELECT "tags", "bytes" FROM "logs" WHERE has("tags",'web')) ARRAY JOIN "tags" ` +
+		`GROUP BY "tags" ORDER BY "tags"`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	mock.ExpectQuery(testdata.EscapeBrackets(expectedTermsSql)).
+		WillReturnRows(sqlmock.NewRows([]string{"tags", "count()"}).AddRow("web", uint64(3)).AddRow("prod", uint64(2)))
+	mock.ExpectQuery(testdata.EscapeBrackets(expectedAvgSql)).
+		WillReturnRows(sqlmock.NewRows([]string{"tags", "avgOrNull(bytes)"}).AddRow("prod", 20.0).AddRow("web", 10.0))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	response, err := queryRunner.handleSearch(ctx, tableName, query)
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(response, &searchResponse))
+	buckets := searchResponse.Aggregations["tag_cloud"].(model.JsonMap)["buckets"].([]any)
+	if assert.Len(t, buckets, 2) {
+		assert.Equal(t, "web", buckets[0].(model.JsonMap)["key"])
+		assert.Equal(t, 3.0, buckets[0].(model.JsonMap)["doc_count"])
+		assert.Equal(t, "prod", buckets[1].(model.JsonMap)["key"])
+		assert.Equal(t, 2.0, buckets[1].(model.JsonMap)["doc_count"])
+	}
+}
+
+func TestSearchNestedAggregation(t *testing.T) {
+	const tableName = "orders"
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewDefaultCHConfig(),
+		Cols: map[string]*clickhouse.Column{
+			"tags":            {Name: "tags", Type: clickhouse.CompoundType{Name: "Array", BaseType: clickhouse.NewBaseType("String")}},
+			"products.name":   {Name: "products.name", Type: clickhouse.CompoundType{Name: "Array", BaseType: clickhouse.NewBaseType("String")}},
+			"products.amount": {Name: "products.amount", Type: clickhouse.CompoundType{Name: "Array", BaseType: clickhouse.NewBaseType("Int64")}},
+		},
+		Created: true,
+	}
+	s := staticRegistry{tables: map[schema.TableName]schema.Schema{tableName: {Fields: map[schema.FieldName]schema.Field{
+		"tags":            {PropertyName: "tags", InternalPropertyName: "tags", Type: schema.TypeKeyword.AsArray()},
+		"products.name":   {PropertyName: "products.name", InternalPropertyName: "products.name", Type: schema.TypeKeyword.AsArray()},
+		"products.amount": {PropertyName: "products.amount", InternalPropertyName: "products.amount", Type: schema.TypeLong.AsArray()},
+	}}}}
+	query := types.MustJSON(`{
+		"size": 0,
+		"track_total_hits": false,
+		"query": {"term": {"tags": "web"}},
+		"aggs": {"products": {"nested": {"path": "products"}, "aggs": {
+			"apples": {"filter": {"term": {"name": "apple"}}, "aggs": {"total": {"sum": {"field": "amount"}}}}}}}
+	}`)
+	// parent documents are filtered as arrays, nested ones (after ARRAY JOIN) as single values
+	const nestedFrom = `FROM (SELECT * FROM "orders" WHERE has("tags",'web')) ARRAY JOIN "products.amount", "products.name"`
+	const expectedNestedCountSql = `SELECT count() ` + nestedFrom
+	const expectedFilterCountSql = `SELECT count() ` + nestedFrom + ` WHERE "products.name"='apple'`
+	const expectedSumSql = `SELECT sumOrNull("products.amount") ` + nestedFrom + ` WHERE "products.name"='apple'`
+
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	mock.ExpectQuery(testdata.EscapeWildcard(testdata.EscapeBrackets(expectedNestedCountSql))).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(5)))
+	mock.ExpectQuery(testdata.EscapeWildcard(testdata.EscapeBrackets(expectedFilterCountSql))).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(2)))
+	mock.ExpectQuery(testdata.EscapeWildcard(testdata.EscapeBrackets(expectedSumSql))).
+		WillReturnRows(sqlmock.NewRows([]string{"sumOrNull(products.amount)"}).AddRow(int64(7)))
+
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	response, err := queryRunner.handleSearch(ctx, tableName, query)
+	assert.NoError(t, err)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+
+	var searchResponse model.SearchResp
+	assert.NoError(t, json.Unmarshal(response, &searchResponse))
+	products := searchResponse.Aggregations["products"].(model.JsonMap)
+	assert.Equal(t, 5.0, products["doc_count"])
+	assert.Equal(t, 2.0, products["apples"].(model.JsonMap)["doc_count"])
+	assert.Equal(t, 7.0, products["apples"].(model.JsonMap)["total"].(model.JsonMap)["value"])
+}
+
+func TestMultiSearch(t *testing.T) {
+	s := staticRegistry{
+		tables: map[schema.TableName]schema.Schema{
+			tableName: {
+				Fields: map[schema.FieldName]schema.Field{
+					"message": {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeText},
+				},
+			},
+		},
+	}
+	cfg := config.QuesmaConfiguration{IndexConfig: map[string]config.IndexConfiguration{tableName: {Name: tableName, Enabled: true}}}
+	table := &clickhouse.Table{
+		Name:   tableName,
+		Config: clickhouse.NewChTableConfigTimestampStringAttr(),
+		Cols: map[string]*clickhouse.Column{
+			"message": {Name: "message", Type: clickhouse.NewBaseType("String")},
+		},
+		Created: true,
+	}
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, table))
+	managementConsole := ui.NewQuesmaManagementConsole(cfg, nil, nil, make(<-chan logger.LogWithLevel, 50000), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	queryRunner := NewQueryRunner(lm, cfg, nil, managementConsole, s)
+	router := configureRouter(cfg, s, lm, managementConsole, telemetry.NewPhoneHomeEmptyAgent(), queryRunner)
+
+	body, err := types.ParseNDJSON(`{"index": "` + tableName + `"}
+{"query": {"match_all": {}}, "size": 10, "track_total_hits": false}
+{}
+{"query": {"match_all": {}}, "size": 5, "track_total_hits": false}
+`)
+	assert.NoError(t, err)
+	unknownIndex := &mux.Request{Method: "POST", Path: "/_msearch", ParsedBody: body}
+	_, found := router.Matches(unknownIndex)
+	assert.False(t, found, "second search has no index, so it's not ours")
+
+	request := &mux.Request{Method: "POST", Path: "/" + tableName + "/_msearch", ParsedBody: body}
+	handler, found := router.Matches(request)
+	assert.True(t, found)
+
+	mock.ExpectQuery(`SELECT "message" FROM "logs-generic-default" LIMIT 10`).
+		WillReturnRows(sqlmock.NewRows([]string{"message"}).AddRow("hello"))
+	mock.ExpectQuery(`SELECT "message" FROM "logs-generic-default" LIMIT 5`).
+		WillReturnError(errors.New("connection refused"))
+	result, err := handler(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, httpOk, result.StatusCode)
+
+	var response struct {
+		Responses []struct {
+			Hits   *model.SearchHits `json:"hits"`
+			Error  map[string]any    `json:"error"`
+			Status int               `json:"status"`
+		} `json:"responses"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(result.Body), &response))
+	if assert.Len(t, response.Responses, 2) {
+		assert.Equal(t, 200, response.Responses[0].Status)
+		assert.Nil(t, response.Responses[0].Error)
+		if assert.NotNil(t, response.Responses[0].Hits) {
+			assert.Len(t, response.Responses[0].Hits.Hits, 1)
+		}
+
+		assert.Equal(t, 500, response.Responses[1].Status)
+		assert.NotEmpty(t, response.Responses[1].Error)
+		assert.Nil(t, response.Responses[1].Hits)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestAsyncSearchFallsBackToPersistedResults(t *testing.T) {
+	db, mock := util.InitSqlMockWithPrettyPrint(t, false)
+	defer db.Close()
+	lm := clickhouse.NewLogManagerWithConnection(db, concurrent.NewMapWith(tableName, &clickhouse.Table{Name: tableName}))
+	cfg := config.QuesmaConfiguration{AsyncSearch: config.AsyncSearchConfiguration{Table: "async_results", QueriesLimit: 1}}
+	queryRunner := NewQueryRunner(lm, cfg, nil, nil, staticRegistry{})
+	ctx := context.Background()
+
+	response := []byte(`{"id":"quesma_async_search_id_2","is_partial":false}`)
+	compressed, err := util.Compress(response)
+	assert.NoError(t, err)
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS "async_results"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "async_results"`).WithArgs("quesma_async_search_id_1", sqlmock.AnyArg(), "first", false, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO "async_results"`).WithArgs("quesma_async_search_id_2", sqlmock.AnyArg(), string(compressed), true, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`SELECT "added", "response", "is_compressed", "error" FROM "async_results"`).
+		WithArgs("quesma_async_search_id_2", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"added", "response", "is_compressed", "error"}).AddRow(time.Now(), string(compressed), true, ""))
+
+	queryRunner.keepAsyncSearch("quesma_async_search_id_1", AsyncRequestResult{responseBody: []byte("first"), added: time.Now()})
+	// memory is full (queries limit is 1), it's only persisted
+	queryRunner.keepAsyncSearch("quesma_async_search_id_2", AsyncRequestResult{responseBody: compressed, added: time.Now(), isCompressed: true})
+	_, inMemory := queryRunner.AsyncRequestStorage.Load("quesma_async_search_id_2")
+	assert.False(t, inMemory)
+
+	responseBody, err := queryRunner.handlePartialAsyncSearch(ctx, "quesma_async_search_id_2")
+	assert.NoError(t, err)
+	assert.Equal(t, response, responseBody)
+
+	// a running query doesn't have a result yet, the table isn't queried
+	queryRunner.addAsyncQueryContext(ctx, func() {}, "quesma_async_search_id_3")
+	responseBody, err = queryRunner.handlePartialAsyncSearch(ctx, "quesma_async_search_id_3")
+	assert.NoError(t, err)
+	assert.Contains(t, string(responseBody), `"is_partial":true`)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		assert.NoError(t, err, "there were unfulfilled expections:")
+	}
+}
+
+func TestAsyncSearchRunningLimit(t *testing.T) {
+	cfg := config.QuesmaConfiguration{AsyncSearch: config.AsyncSearchConfiguration{Table: "async_results", RunningLimit: 2}}
+	queryRunner := NewQueryRunner(clickhouse.NewLogManagerEmpty(), cfg, nil, nil, staticRegistry{})
+	ctx := context.Background()
+
+	doneCh := make(chan AsyncSearchWithError, 1)
+	queryRunner.addAsyncQueryContext(ctx, func() {}, "quesma_async_search_id_1")
+	assert.False(t, queryRunner.reachedQueriesLimit(ctx, "quesma_async_search_id_2", doneCh))
+	queryRunner.addAsyncQueryContext(ctx, func() {}, "quesma_async_search_id_2")
+	// even though results don't have to fit into memory with the table, running queries are limited
+	assert.True(t, queryRunner.reachedQueriesLimit(ctx, "quesma_async_search_id_3", doneCh))
+	assert.Error(t, (<-doneCh).err)
+}
@@ -0,0 +1,99 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"context"
+	"quesma/logger"
+	"quesma/quesma/types"
+	"quesma/util"
+	"slices"
+	"time"
+)
+
+// resolvePartitionedTables replaces logical, time-partitioned indexes in `sources` with their physical tables.
+// Only tables overlapping with the time range of the query are kept, so we don't scan the others at all.
+func (q *QueryRunner) resolvePartitionedTables(ctx context.Context, sources []string, body types.JSON) []string {
+	result := make([]string, 0, len(sources))
+	for _, source := range sources {
+		partitions := q.cfg.IndexConfig[source].TablePartitions
+		if partitions == nil {
+			result = append(result, source)
+			continue
+		}
+
+		tables, err := q.logManager.GetTableDefinitions()
+		if err != nil {
+			logger.WarnWithCtx(ctx).Msgf("can't resolve tables of partitioned index %s: %v", source, err)
+			continue
+		}
+		from, to := queryTimeRange(body["query"], partitions.GetTimestampField())
+		var physicalTables []string
+		for _, tableName := range tables.Keys() {
+			tableFrom, tableTo, isPartition := partitions.PartitionRange(tableName)
+			if !isPartition {
+				continue
+			}
+			if (to.IsZero() || !tableFrom.After(to)) && (from.IsZero() || tableTo.After(from)) {
+				physicalTables = append(physicalTables, tableName)
+			}
+		}
+		slices.Sort(physicalTables)
+		logger.DebugWithCtx(ctx).Msgf("partitioned index %s, time range [%v, %v] resolved to tables %v", source, from, to, physicalTables)
+		result = append(result, physicalTables...)
+	}
+	return util.Distinct(result)
+}
+
+// queryTimeRange returns bounds on `timestampField`, which every document matching `query` satisfies
+// (so only ranges in `filter`/`must` clauses count). Zero time means no bound.
+// It's conservative: bounds we can't parse (e.g. date math) don't narrow the range.
+func queryTimeRange(query any, timestampField string) (from, to time.Time) {
+	var walk func(node any)
+	walk = func(node any) {
+		switch nodeTyped := node.(type) {
+		case []any:
+			for _, child := range nodeTyped {
+				walk(child)
+			}
+		case map[string]any:
+			if rangeQuery, ok := nodeTyped["range"].(map[string]any); ok {
+				if fieldRange, ok := rangeQuery[timestampField].(map[string]any); ok {
+					for _, op := range []string{"gte", "gt"} {
+						if bound, ok := parseTimeBound(fieldRange[op]); ok && (from.IsZero() || bound.After(from)) {
+							from = bound
+						}
+					}
+					for _, op := range []string{"lte", "lt"} {
+						if bound, ok := parseTimeBound(fieldRange[op]); ok && (to.IsZero() || bound.Before(to)) {
+							to = bound
+						}
+					}
+				}
+			}
+			if boolQuery, ok := nodeTyped["bool"].(map[string]any); ok {
+				walk(boolQuery["filter"])
+				walk(boolQuery["must"])
+			}
+		}
+	}
+	walk(query)
+	return from, to
+}
+
+var timeBoundLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseTimeBound parses absolute dates and epoch millis
+func parseTimeBound(bound any) (time.Time, bool) {
+	switch boundTyped := bound.(type) {
+	case string:
+		for _, layout := range timeBoundLayouts {
+			if t, err := time.Parse(layout, boundTyped); err == nil {
+				return t, true
+			}
+		}
+	case float64:
+		return time.UnixMilli(int64(boundTyped)).UTC(), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,36 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package quesma
+
+import (
+	"github.com/stretchr/testify/assert"
+	"quesma/quesma/types"
+	"testing"
+	"time"
+)
+
+func Test_queryTimeRange(t *testing.T) {
+	jan10 := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	jan20 := time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		query    string
+		from, to time.Time
+	}{
+		{"match_all", `{"match_all": {}}`, time.Time{}, time.Time{}},
+		{"top level range", `{"range": {"@timestamp": {"gte": "2024-01-10T00:00:00Z", "lt": "2024-01-20"}}}`, jan10, jan20},
+		{"range in filter", `{"bool": {"filter": [{"match_all": {}}, {"range": {"@timestamp": {"gte": "2024-01-10T00:00:00.000Z"}}}]}}`, jan10, time.Time{}},
+		{"nested bool must", `{"bool": {"must": {"bool": {"filter": {"range": {"@timestamp": {"lte": 1705708800000, "format": "epoch_millis"}}}}}}}`, time.Time{}, jan20},
+		{"range in should doesn't count", `{"bool": {"should": [{"range": {"@timestamp": {"gte": "2024-01-10"}}}]}}`, time.Time{}, time.Time{}},
+		{"other field", `{"range": {"timestamp": {"gte": "2024-01-10"}}}`, time.Time{}, time.Time{}},
+		{"date math is not parsed", `{"range": {"@timestamp": {"gte": "now-15m", "lte": "2024-01-20"}}}`, time.Time{}, jan20},
+		{"two ranges intersect", `{"bool": {"filter": [{"range": {"@timestamp": {"gte": "2024-01-01", "lte": "2024-01-20"}}}, {"range": {"@timestamp": {"gte": "2024-01-10", "lte": "2024-01-31"}}}]}}`, jan10, jan20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := queryTimeRange(map[string]any(types.MustJSON(tt.query)), "@timestamp")
+			assert.True(t, tt.from.Equal(from), "from: expected %v, got %v", tt.from, from)
+			assert.True(t, tt.to.Equal(to), "to: expected %v, got %v", tt.to, to)
+		})
+	}
+}
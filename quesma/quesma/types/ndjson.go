@@ -42,6 +42,8 @@ func ParseNDJSON(body string) (NDJSON, error) {
 type DocumentTarget struct {
 	Index *string `json:"_index"`
 	Id    *string `json:"_id"` // document's target id in Elasticsearch, we ignore it when writing to Clickhouse.
+
+	RequireAlias *bool `json:"require_alias"` // overrides `require_alias` URL parameter for this operation only
 }
 
 type BulkOperation map[string]DocumentTarget
@@ -56,6 +58,26 @@ func (op BulkOperation) GetIndex() string {
 	return ""
 }
 
+// GetRequireAlias returns (value, true) if operation sets `require_alias` explicitly, (false, false) otherwise
+func (op BulkOperation) GetRequireAlias() (requireAlias bool, isSet bool) {
+	for _, target := range op { // this map contains only 1 element though
+		if target.RequireAlias != nil {
+			return *target.RequireAlias, true
+		}
+	}
+	return false, false
+}
+
+// GetId returns `_id` of the operation's document, "" if it's not set
+func (op BulkOperation) GetId() string {
+	for _, target := range op { // this map contains only 1 element though
+		if target.Id != nil {
+			return *target.Id
+		}
+	}
+	return ""
+}
+
 func (op BulkOperation) GetOperation() string {
 	for operation := range op {
 		return operation
@@ -63,11 +85,11 @@ func (op BulkOperation) GetOperation() string {
 	return ""
 }
 
+// BulkForEach calls `f` for every operation of the bulk, with its document. `delete` has no document line, so it gets nil.
 func (n NDJSON) BulkForEach(f func(operation BulkOperation, doc JSON)) error {
 
-	for i := 0; i+1 < len(n); i += 2 {
-		operation := n[i]  // {"create":{"_index":"kibana_sample_data_flights", "_id": 1}}
-		document := n[i+1] // {"FlightNum":"9HY9SWR","DestCountry":"AU","OriginWeather":"Sunny","OriginCityName":"Frankfurt am Main" }
+	for i := 0; i < len(n); {
+		operation := n[i] // {"create":{"_index":"kibana_sample_data_flights", "_id": 1}}
 
 		var operationParsed BulkOperation // operationName (create, index, update, delete) -> DocumentTarget
 
@@ -76,7 +98,18 @@ func (n NDJSON) BulkForEach(f func(operation BulkOperation, doc JSON)) error {
 			return err
 		}
 
+		if operationParsed.GetOperation() == "delete" {
+			f(operationParsed, nil)
+			i++
+			continue
+		}
+		if i+1 >= len(n) {
+			break
+		}
+		document := n[i+1] // {"FlightNum":"9HY9SWR","DestCountry":"AU","OriginWeather":"Sunny","OriginCityName":"Frankfurt am Main" }
+
 		f(operationParsed, document)
+		i += 2
 	}
 
 	return nil
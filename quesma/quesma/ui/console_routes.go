@@ -94,6 +94,11 @@ func (qmc *QuesmaManagementConsole) createRouting() *mux.Router {
 		writer.WriteHeader(200)
 	})
 
+	router.HandleFunc(metricsPath, func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = writer.Write(qmc.generateMetrics())
+	})
+
 	router.HandleFunc("/panel/routing-statistics", func(writer http.ResponseWriter, req *http.Request) {
 		buf := qmc.generateRouterStatistics()
 		_, _ = writer.Write(buf)
@@ -207,6 +207,12 @@ func (qmc *QuesmaManagementConsole) generateDashboardPanel() []byte {
 	buffer.Html(fmt.Sprintf(`<div class="status">Started: %s ago</div>`, secondsToTerseString(duration)))
 	buffer.Html(fmt.Sprintf(`<div class="status">Mode: %s</div>`, qmc.cfg.Mode.String()))
 
+	if qmc.queryCacheStats != nil {
+		cacheStats := qmc.queryCacheStats.Stats()
+		buffer.Html(fmt.Sprintf(`<div class="status">Query cache: %d hits, %d misses, %d entries</div>`,
+			cacheStats.Hits, cacheStats.Misses, cacheStats.Size))
+	}
+
 	if h, errH := host.Info(); errH == nil {
 		buffer.Html(fmt.Sprintf(`<div class="status">Host uptime: %s</div>`, secondsToTerseString(h.Uptime)))
 	}
@@ -5,6 +5,7 @@ package ui
 import (
 	"github.com/rs/zerolog"
 	"quesma/elasticsearch"
+	"quesma/querycache"
 	"quesma/schema"
 	"quesma/telemetry"
 	"quesma/util"
@@ -93,10 +94,18 @@ type (
 		phoneHomeAgent            telemetry.PhoneHomeAgent
 		schemasProvider           SchemasProvider
 		totalUnsupportedQueries   int
+		queryCacheStats           QueryCacheStatsProvider // nil <=> query cache is disabled
+		queryMetrics              QueryMetricsProvider    // nil <=> no query runner
 	}
 	SchemasProvider interface {
 		AllSchemas() map[schema.TableName]schema.Schema
 	}
+	QueryCacheStatsProvider interface {
+		Stats() querycache.Stats
+	}
+	QueryMetricsProvider interface {
+		QueryMetrics() QueryMetrics
+	}
 )
 
 func NewQuesmaManagementConsole(config config.QuesmaConfiguration, logManager *clickhouse.LogManager, indexManager elasticsearch.IndexManagement, logChan <-chan logger.LogWithLevel, phoneHomeAgent telemetry.PhoneHomeAgent, schemasProvider SchemasProvider) *QuesmaManagementConsole {
@@ -120,6 +129,14 @@ func NewQuesmaManagementConsole(config config.QuesmaConfiguration, logManager *c
 	}
 }
 
+func (qmc *QuesmaManagementConsole) SetQueryCacheStatsProvider(provider QueryCacheStatsProvider) {
+	qmc.queryCacheStats = provider
+}
+
+func (qmc *QuesmaManagementConsole) SetQueryMetricsProvider(provider QueryMetricsProvider) {
+	qmc.queryMetrics = provider
+}
+
 func (qmc *QuesmaManagementConsole) PushPrimaryInfo(qdebugInfo *QueryDebugPrimarySource) {
 	qmc.queryDebugPrimarySource <- qdebugInfo
 }
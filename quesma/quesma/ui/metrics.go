@@ -0,0 +1,131 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+// searchDurationBuckets are upper bounds (in seconds) of buckets of search durations
+var searchDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// QueryMetrics are metrics of searches, collected by the query runner
+type QueryMetrics struct {
+	Searches          int64 // searches, which run their queries
+	ParseFailures     int64 // searches, which couldn't be translated to SQL
+	InFlightQueryJobs int64 // queries running in parallel right now
+	SearchDuration    HistogramSnapshot
+}
+
+// LatencyHistogram is a cumulative histogram of durations, like Prometheus' one. It's safe for concurrent use.
+type LatencyHistogram struct {
+	m      sync.Mutex
+	counts []int64 // counts[i] is the number of durations <= searchDurationBuckets[i]
+	count  int64
+	sum    float64
+}
+
+type HistogramSnapshot struct {
+	Buckets []float64 // upper bounds in seconds, ascending
+	Counts  []int64   // Counts[i] is the number of durations <= Buckets[i]
+	Count   int64
+	Sum     float64 // in seconds
+}
+
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{counts: make([]int64, len(searchDurationBuckets))}
+}
+
+func (h *LatencyHistogram) Observe(duration time.Duration) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	seconds := duration.Seconds()
+	for i, bucket := range searchDurationBuckets {
+		if seconds <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (h *LatencyHistogram) Snapshot() HistogramSnapshot {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return HistogramSnapshot{Buckets: searchDurationBuckets, Counts: slices.Clone(h.counts), Count: h.count, Sum: h.sum}
+}
+
+// generateMetrics returns metrics in Prometheus text format
+func (qmc *QuesmaManagementConsole) generateMetrics() []byte {
+	var buffer bytes.Buffer
+	metric := func(name, metricType, help string) {
+		buffer.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType))
+	}
+	value := func(name string, value any) {
+		buffer.WriteString(fmt.Sprintf("%s %v\n", name, value))
+	}
+
+	if qmc.queryMetrics != nil {
+		queryMetrics := qmc.queryMetrics.QueryMetrics()
+		metric("quesma_searches_total", "counter", "Searches, which run their queries.")
+		value("quesma_searches_total", queryMetrics.Searches)
+		metric("quesma_search_parse_failures_total", "counter", "Searches, which couldn't be translated to SQL.")
+		value("quesma_search_parse_failures_total", queryMetrics.ParseFailures)
+		metric("quesma_query_jobs_in_flight", "gauge", "Queries running in parallel right now.")
+		value("quesma_query_jobs_in_flight", queryMetrics.InFlightQueryJobs)
+
+		histogram := queryMetrics.SearchDuration
+		metric("quesma_search_duration_seconds", "histogram", "Duration of running queries of searches.")
+		for i, bucket := range histogram.Buckets {
+			value(fmt.Sprintf(`quesma_search_duration_seconds_bucket{le="%v"}`, bucket), histogram.Counts[i])
+		}
+		value(`quesma_search_duration_seconds_bucket{le="+Inf"}`, histogram.Count)
+		value("quesma_search_duration_seconds_sum", histogram.Sum)
+		value("quesma_search_duration_seconds_count", histogram.Count)
+	}
+
+	if qmc.phoneHomeAgent != nil {
+		clickhouseStats := qmc.phoneHomeAgent.ClickHouseQueryDuration().Aggregate()
+		metric("quesma_clickhouse_query_duration_seconds", "summary", "Duration of ClickHouse queries (quantiles of recent ones).")
+		percentiles := make([]int, 0, len(clickhouseStats.Percentiles))
+		for percentile := range clickhouseStats.Percentiles {
+			if p, err := strconv.Atoi(percentile); err == nil {
+				percentiles = append(percentiles, p)
+			}
+		}
+		slices.Sort(percentiles)
+		for _, p := range percentiles {
+			value(fmt.Sprintf(`quesma_clickhouse_query_duration_seconds{quantile="%v"}`, float64(p)/100),
+				clickhouseStats.Percentiles[strconv.Itoa(p)])
+		}
+		value("quesma_clickhouse_query_duration_seconds_sum", clickhouseStats.Avg*float64(clickhouseStats.Count))
+		value("quesma_clickhouse_query_duration_seconds_count", clickhouseStats.Count)
+		metric("quesma_clickhouse_query_failures_total", "counter", "ClickHouse queries, which failed.")
+		value("quesma_clickhouse_query_failures_total", clickhouseStats.Failed)
+	}
+
+	if qmc.queryCacheStats != nil {
+		cacheStats := qmc.queryCacheStats.Stats()
+		metric("quesma_query_cache_hits_total", "counter", "Searched queries, whose results were in the query cache.")
+		value("quesma_query_cache_hits_total", cacheStats.Hits)
+		metric("quesma_query_cache_misses_total", "counter", "Searched queries, whose results weren't in the query cache.")
+		value("quesma_query_cache_misses_total", cacheStats.Misses)
+		metric("quesma_query_cache_hit_ratio", "gauge", "Ratio of query cache hits to all its lookups.")
+		hitRatio := 0.0
+		if lookups := cacheStats.Hits + cacheStats.Misses; lookups > 0 {
+			hitRatio = float64(cacheStats.Hits) / float64(lookups)
+		}
+		value("quesma_query_cache_hit_ratio", hitRatio)
+		metric("quesma_query_cache_entries", "gauge", "Results in the query cache.")
+		value("quesma_query_cache_entries", cacheStats.Size)
+	}
+
+	return buffer.Bytes()
+}
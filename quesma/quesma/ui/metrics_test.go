@@ -0,0 +1,56 @@
+// Copyright Quesma, licensed under the Elastic License 2.0.
+// SPDX-License-Identifier: Elastic-2.0
+package ui
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"quesma/logger"
+	"quesma/querycache"
+	"quesma/quesma/config"
+	"quesma/telemetry"
+	"testing"
+	"time"
+)
+
+type staticQueryMetrics struct {
+	metrics QueryMetrics
+}
+
+func (s staticQueryMetrics) QueryMetrics() QueryMetrics {
+	return s.metrics
+}
+
+type staticQueryCacheStats struct {
+	stats querycache.Stats
+}
+
+func (s staticQueryCacheStats) Stats() querycache.Stats {
+	return s.stats
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	qmc := NewQuesmaManagementConsole(config.QuesmaConfiguration{}, nil, nil, make(chan logger.LogWithLevel, 5), telemetry.NewPhoneHomeEmptyAgent(), nil)
+	histogram := NewLatencyHistogram()
+	histogram.Observe(20 * time.Millisecond)
+	histogram.Observe(3 * time.Second)
+	qmc.SetQueryMetricsProvider(staticQueryMetrics{QueryMetrics{Searches: 2, ParseFailures: 1, InFlightQueryJobs: 3, SearchDuration: histogram.Snapshot()}})
+	qmc.SetQueryCacheStatsProvider(staticQueryCacheStats{querycache.Stats{Hits: 1, Misses: 3, Size: 2}})
+
+	recorder := httptest.NewRecorder()
+	qmc.createRouting().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	metrics := recorder.Body.String()
+	assert.Contains(t, metrics, "# TYPE quesma_searches_total counter\nquesma_searches_total 2\n")
+	assert.Contains(t, metrics, "quesma_search_parse_failures_total 1\n")
+	assert.Contains(t, metrics, "quesma_query_jobs_in_flight 3\n")
+	assert.Contains(t, metrics, `quesma_search_duration_seconds_bucket{le="0.025"} 1`+"\n")
+	assert.Contains(t, metrics, `quesma_search_duration_seconds_bucket{le="5"} 2`+"\n")
+	assert.Contains(t, metrics, `quesma_search_duration_seconds_bucket{le="+Inf"} 2`+"\n")
+	assert.Contains(t, metrics, "quesma_search_duration_seconds_count 2\n")
+	assert.Contains(t, metrics, "quesma_clickhouse_query_duration_seconds_count 0\n")
+	assert.Contains(t, metrics, "quesma_query_cache_hit_ratio 0.25\n")
+}
@@ -46,6 +46,23 @@ func (s *schemaRegistry) loadSchemas() (map[TableName]Schema, error) {
 		schemas[TableName(indexName)] = Schema{Fields: fields, Aliases: aliases}
 	}
 
+	// physical tables of time-partitioned indexes share configuration of their logical index
+	for tableName := range definitions {
+		if _, alreadyLoaded := schemas[TableName(tableName)]; alreadyLoaded {
+			continue
+		}
+		if indexName, found := s.configuration.ResolvePartitionedIndex(tableName); found {
+			indexConfiguration := s.configuration.IndexConfig[indexName]
+			fields := make(map[FieldName]Field)
+			aliases := make(map[FieldName]FieldName)
+
+			s.populateSchemaFromStaticConfiguration(indexConfiguration, fields)
+			s.populateSchemaFromTableDefinition(definitions, tableName, fields)
+			s.populateAliases(indexConfiguration, fields, aliases)
+			schemas[TableName(tableName)] = Schema{Fields: fields, Aliases: aliases}
+		}
+	}
+
 	return schemas, nil
 }
 
@@ -130,7 +147,13 @@ func (s *schemaRegistry) populateSchemaFromTableDefinition(definitions map[strin
 		logger.Debug().Msgf("loading schema for table %s", indexName)
 
 		for _, column := range tableDefinition.Columns {
-			if _, exists := fields[FieldName(column.Name)]; !exists {
+			if field, exists := fields[FieldName(column.Name)]; exists {
+				// type configured explicitly, but whether it's an array is known only from the data source
+				if quesmaType, found := s.dataSourceTypeAdapter.Convert(column.Type); found && quesmaType.IsArray {
+					field.Type = field.Type.AsArray()
+					fields[FieldName(column.Name)] = field
+				}
+			} else {
 				if quesmaType, found2 := s.dataSourceTypeAdapter.Convert(column.Type); found2 {
 					fields[FieldName(column.Name)] = Field{PropertyName: FieldName(column.Name), InternalPropertyName: FieldName(column.Name), Type: quesmaType}
 				} else {
@@ -49,6 +49,52 @@ func Test_schemaRegistry_FindSchema(t *testing.T) {
 				Aliases: map[schema.FieldName]schema.FieldName{}},
 			exists: true,
 		},
+		{
+			name: "schema inferred, ip columns",
+			cfg: config.QuesmaConfiguration{
+				IndexConfig: map[string]config.IndexConfiguration{
+					"some_table": {Enabled: true},
+				},
+			},
+			tableDiscovery: fixedTableProvider{tables: map[string]schema.Table{
+				"some_table": {Columns: map[string]schema.Column{
+					"message":   {Name: "message", Type: "String"},
+					"client_ip": {Name: "client_ip", Type: "IPv4"},
+					"server_ip": {Name: "server_ip", Type: "IPv6"},
+				}},
+			}},
+			tableName: "some_table",
+			want: schema.Schema{Fields: map[schema.FieldName]schema.Field{
+				"message":   {PropertyName: "message", InternalPropertyName: "message", Type: schema.TypeKeyword},
+				"client_ip": {PropertyName: "client_ip", InternalPropertyName: "client_ip", Type: schema.TypeIp},
+				"server_ip": {PropertyName: "server_ip", InternalPropertyName: "server_ip", Type: schema.TypeIp}},
+				Aliases: map[schema.FieldName]schema.FieldName{}},
+			exists: true,
+		},
+		{
+			name: "schema inferred, array columns",
+			cfg: config.QuesmaConfiguration{
+				IndexConfig: map[string]config.IndexConfiguration{
+					"some_table": {Enabled: true, TypeMappings: map[string]string{"labels": "keyword"}},
+				},
+			},
+			tableDiscovery: fixedTableProvider{tables: map[string]schema.Table{
+				"some_table": {Columns: map[string]schema.Column{
+					"tags":   {Name: "tags", Type: "Array(String)"},
+					"counts": {Name: "counts", Type: "Array(Int64)"},
+					"labels": {Name: "labels", Type: "Array(LowCardinality(String))"},
+					"count":  {Name: "count", Type: "Int64"},
+				}},
+			}},
+			tableName: "some_table",
+			want: schema.Schema{Fields: map[schema.FieldName]schema.Field{
+				"tags":   {PropertyName: "tags", InternalPropertyName: "tags", Type: schema.TypeKeyword.AsArray()},
+				"counts": {PropertyName: "counts", InternalPropertyName: "counts", Type: schema.TypeLong.AsArray()},
+				"labels": {PropertyName: "labels", InternalPropertyName: "labels", Type: schema.TypeKeyword.AsArray()},
+				"count":  {PropertyName: "count", InternalPropertyName: "count", Type: schema.TypeLong}},
+				Aliases: map[schema.FieldName]schema.FieldName{}},
+			exists: true,
+		},
 		{
 			name: "schema inferred, with type mappings (deprecated)",
 			cfg: config.QuesmaConfiguration{
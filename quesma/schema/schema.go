@@ -2,6 +2,15 @@
 // SPDX-License-Identifier: Elastic-2.0
 package schema
 
+import "strings"
+
+// Like in Elastic's default mappings, text fields have a keyword multi-field `field.keyword`,
+// and we also expose keyword fields as text multi-field `field.text`
+const (
+	KeywordMultiFieldSuffix = ".keyword"
+	TextMultiFieldSuffix    = ".text"
+)
+
 type (
 	Schema struct {
 		Fields  map[FieldName]Field
@@ -34,3 +43,39 @@ func (s Schema) ResolveField(fieldName string) (Field, bool) {
 	field, exists := s.Fields[FieldName(fieldName)]
 	return field, exists
 }
+
+// ResolveFieldByInternalName returns the field, which is represented as `internalFieldName` in the data source
+func (s Schema) ResolveFieldByInternalName(internalFieldName string) (Field, bool) {
+	for _, field := range s.Fields {
+		if field.InternalPropertyName.AsString() == internalFieldName {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// ResolveFieldWithMultiFields works like ResolveField, but if there's no `fieldName` field, and it's a multi-field
+// (e.g. `foo.keyword`), it resolves to the field, which it's a multi-field of (`foo`)
+func (s Schema) ResolveFieldWithMultiFields(fieldName string) (Field, bool) {
+	if field, exists := s.ResolveField(fieldName); exists {
+		return field, true
+	}
+	for _, suffix := range []string{KeywordMultiFieldSuffix, TextMultiFieldSuffix} {
+		if parentName, isMultiField := strings.CutSuffix(fieldName, suffix); isMultiField {
+			return s.ResolveField(parentName)
+		}
+	}
+	return Field{}, false
+}
+
+// MultiFields returns multi-fields of `field`: `.keyword` (aggregatable) of text fields, and `.text` (full-text) of keyword ones.
+// They're backed by the same column as the field.
+func MultiFields(field Field) []Field {
+	switch field.Type.Name {
+	case TypeText.Name:
+		return []Field{{PropertyName: field.PropertyName + KeywordMultiFieldSuffix, InternalPropertyName: field.InternalPropertyName, Type: TypeKeyword}}
+	case TypeKeyword.Name:
+		return []Field{{PropertyName: field.PropertyName + TextMultiFieldSuffix, InternalPropertyName: field.InternalPropertyName, Type: TypeText}}
+	}
+	return nil
+}
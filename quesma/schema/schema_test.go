@@ -76,3 +76,37 @@ func TestSchema_ResolveField(t *testing.T) {
 		})
 	}
 }
+
+func TestSchema_ResolveFieldWithMultiFields(t *testing.T) {
+	schema := Schema{
+		Fields: map[FieldName]Field{
+			"message":      {PropertyName: "message", InternalPropertyName: "message", Type: TypeText},
+			"host":         {PropertyName: "host", InternalPropertyName: "host", Type: TypeText},
+			"host.keyword": {PropertyName: "host.keyword", InternalPropertyName: "host::keyword", Type: TypeKeyword},
+		},
+		Aliases: map[FieldName]FieldName{"msg": "message"},
+	}
+	tests := []struct {
+		fieldName     string
+		resolvedField FieldName
+		exists        bool
+	}{
+		{fieldName: "message", resolvedField: "message", exists: true},
+		{fieldName: "message.keyword", resolvedField: "message", exists: true}, // no backing .keyword field
+		{fieldName: "msg.keyword", resolvedField: "message", exists: true},
+		{fieldName: "host.keyword", resolvedField: "host::keyword", exists: true}, // backing .keyword field
+		{fieldName: "host.text", resolvedField: "host", exists: true},
+		{fieldName: "foo.keyword", exists: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fieldName, func(t *testing.T) {
+			got, exists := schema.ResolveFieldWithMultiFields(tt.fieldName)
+			if exists != tt.exists {
+				t.Errorf("ResolveFieldWithMultiFields() exists = %v, want %v", exists, tt.exists)
+			}
+			if got.InternalPropertyName != tt.resolvedField {
+				t.Errorf("ResolveFieldWithMultiFields() got = %v, want %v", got.InternalPropertyName, tt.resolvedField)
+			}
+		})
+	}
+}
@@ -44,10 +44,18 @@ func (t Type) IsFullText() bool {
 	return slices.Contains(t.Properties, FullText)
 }
 
+// AsArray returns the type of arrays of t elements
+func (t Type) AsArray() Type {
+	t.IsArray = true
+	return t
+}
+
 type (
 	Type struct {
 		Name       string
 		Properties []TypeProperty
+		// IsArray is set, if values are arrays of the type, e.g. keyword for Array(String)
+		IsArray bool
 	}
 	TypeProperty string
 )
@@ -11,6 +11,9 @@ import (
 var timestampGroupByClause = model.AsString(clickhouse.TimestampGroupBy(
 	model.NewColumnRef("@timestamp"), clickhouse.DateTime64, 30*time.Second))
 
+// significant_terms' score, if foreground is the same as background
+var matchAllScore = SignificantTermsScore("count()", "sum(count()) OVER ()", "count()", "sum(count()) OVER ()")
+
 func groupBySQL(fieldName string, typ clickhouse.DateTimeType, groupByInterval time.Duration) string {
 	return model.AsString(clickhouse.TimestampGroupBy(model.NewColumnRef(fieldName), typ, groupByInterval))
 }
@@ -763,7 +766,7 @@ var AggregationTests = []AggregationTestCase{
 				`FROM ` + QuotedTableName + ` ` +
 				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-02-02T13:47:16.029Z') ` +
 				`AND "timestamp"<=parseDateTime64BestEffort('2024-02-09T13:47:16.029Z'))`,
-			`SELECT "OriginCityName", count() ` +
+			`SELECT "OriginCityName", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + QuotedTableName + ` ` +
 				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-02-02T13:47:16.029Z') ` +
 				`AND "timestamp"<=parseDateTime64BestEffort('2024-02-09T13:47:16.029Z')) ` +
@@ -2005,8 +2008,8 @@ var AggregationTests = []AggregationTestCase{
 				`"order_date"<=parseDateTime64BestEffort('2024-02-13T09:59:57.034Z')) AND "taxful_total_price" > '250')) ` +
 				`WHERE ((("order_date">=parseDateTime64BestEffort('2024-02-06T09:59:57.034Z') AND ` +
 				`"order_date"<=parseDateTime64BestEffort('2024-02-13T09:59:57.034Z')) AND "taxful_total_price" > '250') AND "row_number"<=10) ` +
-				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000) ` +
-				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000)`,
+				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ASC`,
 			`SELECT toInt64(toUnixTimestamp64Milli("order_date") / 43200000), maxOrNull("taxful_total_price") AS "windowed_taxful_total_price", ` +
 				`maxOrNull("order_date") AS "windowed_order_date" FROM ` +
 				`(SELECT "taxful_total_price", "order_date", ROW_NUMBER() OVER ` +
@@ -2016,8 +2019,8 @@ var AggregationTests = []AggregationTestCase{
 				`"order_date"<=parseDateTime64BestEffort('2024-02-13T09:59:57.034Z')) AND "taxful_total_price" > '250')) ` +
 				`WHERE ((("order_date">=parseDateTime64BestEffort('2024-02-06T09:59:57.034Z') AND ` +
 				`"order_date"<=parseDateTime64BestEffort('2024-02-13T09:59:57.034Z')) AND "taxful_total_price" > '250') AND "row_number"<=10) ` +
-				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000) ` +
-				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000)`,
+				`GROUP BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("order_date") / 43200000), "row_number" ASC`,
 			`SELECT toInt64(toUnixTimestamp64Milli("order_date") / 43200000), count() FROM ` + QuotedTableName + " " +
 				`WHERE (("order_date">=parseDateTime64BestEffort('2024-02-06T09:59:57.034Z') AND ` +
 				`"order_date"<=parseDateTime64BestEffort('2024-02-13T09:59:57.034Z')) AND "taxful_total_price" > '250') ` +
@@ -2773,7 +2776,7 @@ var AggregationTests = []AggregationTestCase{
 				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-02-20T19:13:33.795Z') ` +
 				`AND "timestamp"<=parseDateTime64BestEffort('2024-02-21T04:01:14.920Z')) ` +
 				`LIMIT 5`,
-			`SELECT "message", count() ` +
+			`SELECT "message", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + QuotedTableName + ` ` +
 				`WHERE ("timestamp"<=parseDateTime64BestEffort('2024-02-21T04:01:14.920Z') ` +
 				`AND "timestamp">=parseDateTime64BestEffort('2024-02-20T19:13:33.795Z')) ` +
@@ -3370,13 +3373,13 @@ var AggregationTests = []AggregationTestCase{
 		[]string{
 			`SELECT ` + groupBySQL("@timestamp", clickhouse.DateTime64, 15*time.Second) + `, count() ` +
 				`FROM ` + QuotedTableName + ` ` +
-				`WHERE (toUnixTimestamp64Milli("@timestamp")>=1.709815794995e+12 ` +
-				`AND toUnixTimestamp64Milli("@timestamp")<=1.709816694995e+12) ` +
+				`WHERE ((toUnixTimestamp64Milli("@timestamp")>=1.709815794995e+12 ` +
+				`AND toUnixTimestamp64Milli("@timestamp")<=1.709816694995e+12) AND cityHash64(*)<18446744073709) ` +
 				`GROUP BY ` + groupBySQL("@timestamp", clickhouse.DateTime64, 15*time.Second) + ` ` +
 				`ORDER BY ` + groupBySQL("@timestamp", clickhouse.DateTime64, 15*time.Second),
 			`SELECT count() FROM ` + QuotedTableName + ` ` +
-				`WHERE (toUnixTimestamp64Milli("@timestamp")>=1.709815794995e+12 ` +
-				`AND toUnixTimestamp64Milli("@timestamp")<=1.709816694995e+12)`,
+				`WHERE ((toUnixTimestamp64Milli("@timestamp")>=1.709815794995e+12 ` +
+				`AND toUnixTimestamp64Milli("@timestamp")<=1.709816694995e+12) AND cityHash64(*)<18446744073709)`,
 		},
 	},
 	{ // [20]
@@ -4030,7 +4033,7 @@ var AggregationTests = []AggregationTestCase{
 		},
 	},
 	{ // [23]
-		TestName: "significant terms aggregation: same as terms for now",
+		TestName: "significant terms aggregation: match_all query, so foreground is the same as background and no term is significant",
 		QueryRequestJson: `
 		{
 			"_source": {
@@ -4095,13 +4098,13 @@ var AggregationTests = []AggregationTestCase{
 								"bg_count": 619,
 								"doc_count": 619,
 								"key": "",
-								"score": 619
+								"score": 0
 							},
 							{
 								"bg_count": 206,
 								"doc_count": 206,
 								"key": "zip",
-								"score": 206
+								"score": 0
 							}
 						],
 						"doc_count": 1608
@@ -4122,17 +4125,23 @@ var AggregationTests = []AggregationTestCase{
 		ExpectedResults: [][]model.QueryResultRow{
 			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("hits", uint64(1608))}}},
 			{
-				{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", ""), model.NewQueryResultCol("doc_count", uint64(619))}},
-				{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", "zip"), model.NewQueryResultCol("doc_count", uint64(206))}},
+				{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", ""), model.NewQueryResultCol("bg_count", uint64(619)),
+					model.NewQueryResultCol("superset_size", uint64(1608)), model.NewQueryResultCol("subset_size", uint64(1608)),
+					model.NewQueryResultCol("doc_count", uint64(619)),
+					model.NewQueryResultCol("score", 0.0)}},
+				{Cols: []model.QueryResultCol{model.NewQueryResultCol("key", "zip"), model.NewQueryResultCol("bg_count", uint64(206)),
+					model.NewQueryResultCol("superset_size", uint64(1608)), model.NewQueryResultCol("subset_size", uint64(1608)),
+					model.NewQueryResultCol("doc_count", uint64(206)),
+					model.NewQueryResultCol("score", 0.0)}},
 			},
 		},
 		ExpectedSQLs: []string{
 			`SELECT count() ` +
 				`FROM ` + QuotedTableName,
-			`SELECT "message", count() ` +
+			`SELECT "message", count(), sum(count()) OVER (), sum(count()) OVER (), count(), ` + matchAllScore + ` ` +
 				`FROM ` + QuotedTableName + ` ` +
 				`GROUP BY "message" ` +
-				`ORDER BY count() DESC ` +
+				`ORDER BY ` + matchAllScore + ` DESC ` +
 				`LIMIT 4`,
 		},
 	},
@@ -5412,7 +5421,7 @@ var AggregationTests = []AggregationTestCase{
 			`SELECT toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000), ` +
 				`minOrNull("message") AS "windowed_message", ` +
 				`minOrNull("order_date") AS "windowed_order_date" ` +
-				`FROM (SELECT "message", "order_date", ROW_NUMBER() OVER ` +
+				`FROM (SELECT "message", "order_date", "@timestamp", ROW_NUMBER() OVER ` +
 				`(PARTITION BY toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000) ` +
 				`ORDER BY "order_date" DESC) ` +
 				`AS "row_number" ` +
@@ -5420,8 +5429,8 @@ var AggregationTests = []AggregationTestCase{
 				`WHERE "message" IS NOT NULL) ` +
 				`WHERE ("message" IS NOT NULL ` +
 				`AND "row_number"<=1) ` +
-				`GROUP BY toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000) ` +
-				`ORDER BY toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000)`,
+				`GROUP BY toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000), "row_number" ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000), "row_number" ASC`,
 			`SELECT toInt64(toUnixTimestamp64Milli("@timestamp") / 86400000), ` +
 				"count() " +
 				`FROM ` + QuotedTableName + ` ` +
@@ -6373,4 +6382,255 @@ var AggregationTests = []AggregationTestCase{
 				`LIMIT 1`,
 		},
 	},
+	{ // [40]
+		TestName: "filters with other bucket, keyed (default)",
+		QueryRequestJson: `
+		{
+			"aggs": {
+				"flights": {
+					"filters": {
+						"filters": {
+							"delayed": {
+								"match_phrase": {
+									"FlightDelay": true
+								}
+							},
+							"cancelled": {
+								"match_phrase": {
+									"Cancelled": true
+								}
+							}
+						},
+						"other_bucket_key": "other"
+					}
+				}
+			},
+			"size": 0,
+			"track_total_hits": true
+		}`,
+		ExpectedResponse: `
+		{
+			"took": 0,
+			"timed_out": false,
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"failed": 0,
+				"skipped": 0
+			},
+			"hits": {
+				"total": {
+					"value": 100,
+					"relation": "eq"
+				},
+				"max_score": null,
+				"hits": []
+			},
+			"aggregations": {
+				"flights": {
+					"buckets": {
+						"cancelled": {
+							"doc_count": 10
+						},
+						"delayed": {
+							"doc_count": 30
+						},
+						"other": {
+							"doc_count": 65
+						}
+					}
+				}
+			}
+		}`,
+		ExpectedResults: [][]model.QueryResultRow{
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("hits", uint64(100))}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("doc_count", uint64(10))}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("doc_count", uint64(30))}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("doc_count", uint64(65))}}},
+		},
+		ExpectedSQLs: []string{
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName,
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE "Cancelled"==true`,
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE "FlightDelay"==true`,
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE NOT (("Cancelled"==true OR "FlightDelay"==true))`,
+		},
+	},
+	{ // [41]
+		TestName: "filters with other bucket, keyed: false",
+		QueryRequestJson: `
+		{
+			"aggs": {
+				"flights": {
+					"aggs": {
+						"bytes": {
+							"sum": {
+								"field": "bytes_gauge"
+							}
+						}
+					},
+					"filters": {
+						"filters": {
+							"delayed": {
+								"match_phrase": {
+									"FlightDelay": true
+								}
+							},
+							"cancelled": {
+								"match_phrase": {
+									"Cancelled": true
+								}
+							}
+						},
+						"keyed": false,
+						"other_bucket": true
+					}
+				}
+			},
+			"size": 0,
+			"track_total_hits": true
+		}`,
+		ExpectedResponse: `
+		{
+			"took": 0,
+			"timed_out": false,
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"failed": 0,
+				"skipped": 0
+			},
+			"hits": {
+				"total": {
+					"value": 100,
+					"relation": "eq"
+				},
+				"max_score": null,
+				"hits": []
+			},
+			"aggregations": {
+				"flights": {
+					"buckets": [
+						{
+							"key": "cancelled",
+							"doc_count": 10,
+							"bytes": {
+								"value": 1000.0
+							}
+						},
+						{
+							"key": "delayed",
+							"doc_count": 30,
+							"bytes": {
+								"value": 3000.0
+							}
+						},
+						{
+							"key": "_other_",
+							"doc_count": 65,
+							"bytes": {
+								"value": 6500.0
+							}
+						}
+					]
+				}
+			}
+		}`,
+		ExpectedResults: [][]model.QueryResultRow{
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("hits", uint64(100))}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("value", 6500.0)}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("doc_count", uint64(65))}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("value", 1000.0)}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("doc_count", uint64(10))}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("value", 3000.0)}}},
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("doc_count", uint64(30))}}},
+		},
+		ExpectedSQLs: []string{
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName,
+			`SELECT sumOrNull("bytes_gauge") ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE NOT (("Cancelled"==true OR "FlightDelay"==true))`,
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE NOT (("Cancelled"==true OR "FlightDelay"==true))`,
+			`SELECT sumOrNull("bytes_gauge") ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE "Cancelled"==true`,
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE "Cancelled"==true`,
+			`SELECT sumOrNull("bytes_gauge") ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE "FlightDelay"==true`,
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName + ` ` +
+				`WHERE "FlightDelay"==true`,
+		},
+	},
+	{ // [42]
+		TestName: "boxplot",
+		QueryRequestJson: `
+		{
+			"aggs": {
+				"bytes_boxplot": {
+					"boxplot": {
+						"field": "bytes_gauge"
+					}
+				}
+			},
+			"size": 0,
+			"track_total_hits": true
+		}`,
+		ExpectedResponse: `
+		{
+			"took": 0,
+			"timed_out": false,
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"failed": 0,
+				"skipped": 0
+			},
+			"hits": {
+				"total": {
+					"value": 100,
+					"relation": "eq"
+				},
+				"max_score": null,
+				"hits": []
+			},
+			"aggregations": {
+				"bytes_boxplot": {
+					"min": 1.0,
+					"max": 1000.0,
+					"q1": 25.75,
+					"q2": 50.5,
+					"q3": 75.25,
+					"lower": 1.0,
+					"upper": 149.5
+				}
+			}
+		}`,
+		ExpectedResults: [][]model.QueryResultRow{
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("hits", uint64(100))}}},
+			{{Cols: []model.QueryResultCol{
+				model.NewQueryResultCol(`minOrNull("bytes_gauge")`, uint64(1)),
+				model.NewQueryResultCol(`maxOrNull("bytes_gauge")`, uint64(1000)),
+				model.NewQueryResultCol(`quantiles(0.25, 0.5, 0.75)("bytes_gauge")`, []float64{25.75, 50.5, 75.25}),
+			}}},
+		},
+		ExpectedSQLs: []string{
+			`SELECT count() ` +
+				`FROM ` + QuotedTableName,
+			`SELECT minOrNull("bytes_gauge"), maxOrNull("bytes_gauge"), quantiles(0.25, 0.5, 0.75)("bytes_gauge") ` +
+				`FROM ` + QuotedTableName,
+		},
+	},
 }
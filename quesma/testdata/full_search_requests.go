@@ -107,7 +107,7 @@ var FullSearchRequests = []FullSearchTestCase{
 	},
 	{ // [2]
 		Name: "We can deduct hits count from the rows list, we shouldn't any count(*) request, we should return gte 1",
-		// TODO: Not sure if we should return 1 gte or 2 gte
+		// like Elastic: total is accurate up to track_total_hits, and capped at it, if there are more hits
 		QueryRequestJson: `
 		{
 			"runtime_mappings": {},
@@ -124,7 +124,7 @@ var FullSearchRequests = []FullSearchTestCase{
 			},
 			"hits": {
 				"total": {
-					"value": 2,
+					"value": 1,
 					"relation": "gte"
 				},
 				"max_score": null,
@@ -175,8 +175,8 @@ var FullSearchRequests = []FullSearchTestCase{
 			},
 			"hits": {
 				"total": {
-					"value": 2,
-					"relation": "gte"
+					"value": 1,
+					"relation": "eq"
 				},
 				"max_score": null,
 				"hits": [
@@ -190,23 +190,12 @@ var FullSearchRequests = []FullSearchTestCase{
 						"fields": {
 							"message": ["example"]
 						}
-					},
-					{
-						"_index": "logs-generic-default",
-						"_id": "2",
-						"_score": 0.0,
-						"_source": {
-							"message": "example"
-						},
-						"fields": {
-							"message": ["example"]
-						}
 					}
 				]
 			}
 		}`,
 		ExpectedSQLs:       []string{selectStar(2)},
-		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(2)},
+		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(1)},
 	},
 	{ // [4]
 		Name: "track_total_hits: false",
@@ -350,6 +339,207 @@ var FullSearchRequests = []FullSearchTestCase{
 		ExpectedSQLs:       []string{selectStar(1), selectTotalCnt()},
 		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(1), resultCount(123)},
 	},
+	{ // [7]
+		Name: "track_total_hits: 10, size < count(*) < 10",
+		QueryRequestJson: `
+		{
+			"runtime_mappings": {},
+			"size": 1,
+			"track_total_hits": 10
+		}`,
+		ExpectedResponse: `
+		{
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"skipped": 0,
+				"failed": 0
+			},
+			"hits": {
+				"total": {
+					"value": 5,
+					"relation": "eq"
+				},
+				"max_score": null,
+				"hits": [
+					{
+						"_index": "logs-generic-default",
+						"_id": "1",
+						"_score": 0.0,
+						"_source": {
+							"message": "example"
+						},
+						"fields": {
+							"message": ["example"]
+						}
+					}
+				]
+			}
+		}`,
+		ExpectedSQLs:       []string{selectStar(1), selectCnt(10)},
+		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(1), resultCount(5)},
+	},
+	{ // [8]
+		Name: "track_total_hits: 10, count(*) >= 10",
+		QueryRequestJson: `
+		{
+			"runtime_mappings": {},
+			"size": 1,
+			"track_total_hits": 10
+		}`,
+		ExpectedResponse: `
+		{
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"skipped": 0,
+				"failed": 0
+			},
+			"hits": {
+				"total": {
+					"value": 10,
+					"relation": "gte"
+				},
+				"max_score": null,
+				"hits": [
+					{
+						"_index": "logs-generic-default",
+						"_id": "1",
+						"_score": 0.0,
+						"_source": {
+							"message": "example"
+						},
+						"fields": {
+							"message": ["example"]
+						}
+					}
+				]
+			}
+		}`,
+		ExpectedSQLs:       []string{selectStar(1), selectCnt(10)},
+		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(1), resultCount(10)},
+	},
+	{ // [9]
+		Name: "default track_total_hits, count(*) >= 10000",
+		QueryRequestJson: `
+		{
+			"runtime_mappings": {},
+			"size": 1
+		}`,
+		ExpectedResponse: `
+		{
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"skipped": 0,
+				"failed": 0
+			},
+			"hits": {
+				"total": {
+					"value": 10000,
+					"relation": "gte"
+				},
+				"max_score": null,
+				"hits": [
+					{
+						"_index": "logs-generic-default",
+						"_id": "1",
+						"_score": 0.0,
+						"_source": {
+							"message": "example"
+						},
+						"fields": {
+							"message": ["example"]
+						}
+					}
+				]
+			}
+		}`,
+		ExpectedSQLs:       []string{selectStar(1), selectCnt(10000)},
+		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(1), resultCount(10000)},
+	},
+	{ // [10]
+		Name: "track_total_hits: true, count(*) query returns no rows",
+		QueryRequestJson: `
+		{
+			"runtime_mappings": {},
+			"size": 1,
+			"track_total_hits": true
+		}`,
+		ExpectedResponse: `
+		{
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"skipped": 0,
+				"failed": 0
+			},
+			"hits": {
+				"total": {
+					"value": 1,
+					"relation": "gte"
+				},
+				"max_score": null,
+				"hits": [
+					{
+						"_index": "logs-generic-default",
+						"_id": "1",
+						"_score": 0.0,
+						"_source": {
+							"message": "example"
+						},
+						"fields": {
+							"message": ["example"]
+						}
+					}
+				]
+			}
+		}`,
+		ExpectedSQLs:       []string{selectStar(1), selectTotalCnt()},
+		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(1), {}},
+	},
+	{ // [11]
+		Name: "legacyTypes: hits have _type",
+		QueryRequestJson: `
+		{
+			"runtime_mappings": {},
+			"size": 1,
+			"track_total_hits": false
+		}`,
+		ExpectedResponse: `
+		{
+			"_shards": {
+				"total": 1,
+				"successful": 1,
+				"skipped": 0,
+				"failed": 0
+			},
+			"hits": {
+				"total": {
+					"value": 1,
+					"relation": "gte"
+				},
+				"max_score": null,
+				"hits": [
+					{
+						"_index": "logs-generic-default",
+						"_type": "_doc",
+						"_id": "1",
+						"_score": 0.0,
+						"_source": {
+							"message": "example"
+						},
+						"fields": {
+							"message": ["example"]
+						}
+					}
+				]
+			}
+		}`,
+		ExpectedSQLs:       []string{selectStar(1)},
+		ExpectedSQLResults: [][]model.QueryResultRow{resultSelect(1)},
+		LegacyTypes:        true,
+	},
 
 	// SearchQueryType == ...
 
@@ -230,7 +230,7 @@ var AggregationTests = []testdata.AggregationTestCase{
 				`WHERE ("@timestamp">=parseDateTime64BestEffort('2024-05-27T11:59:56.627Z') ` +
 				`AND "@timestamp"<=parseDateTime64BestEffort('2024-05-27T12:14:56.627Z')) ` +
 				`GROUP BY toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), ` + `"severity", "source" ` +
-				`ORDER BY toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), ` + `"severity", "source"`,
+				`ORDER BY toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), sum(count()) OVER (PARTITION BY toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), "severity", "source") DESC, "severity", "source"`,
 			`SELECT toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), count() ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`WHERE ("@timestamp">=parseDateTime64BestEffort('2024-05-27T11:59:56.627Z') ` +
@@ -397,11 +397,11 @@ var AggregationTests = []testdata.AggregationTestCase{
 			`SELECT "message", "host.name", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), count() ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "message", "host.name", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000) ` +
-				`ORDER BY "message", "host.name", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "message", "host.name") DESC, "message", "host.name", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
 			`SELECT "message", "host.name", count() ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "message", "host.name" ` +
-				`ORDER BY "message", "host.name"`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "message", "host.name") DESC, "message", "host.name"`,
 		},
 	},
 	{ //[2],
@@ -638,19 +638,19 @@ var AggregationTests = []testdata.AggregationTestCase{
 			`SELECT "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), count(DISTINCT "severity") ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000) ` +
-				`ORDER BY "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "severity", "source") DESC, "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
 			`SELECT "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), count() ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000) ` +
-				`ORDER BY "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "severity", "source") DESC, "severity", "source", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
 			`SELECT "severity", "source", count(DISTINCT "severity") ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "severity", "source" ` +
-				`ORDER BY "severity", "source"`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "severity", "source") DESC, "severity", "source"`,
 			`SELECT "severity", "source", count() ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "severity", "source" ` +
-				`ORDER BY "severity", "source"`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "severity", "source") DESC, "severity", "source"`,
 		},
 	},
 	{ // [3]
@@ -841,11 +841,11 @@ var AggregationTests = []testdata.AggregationTestCase{
 			`SELECT "Cancelled", "AvgTicketPrice", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000), count() ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "Cancelled", "AvgTicketPrice", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000) ` +
-				`ORDER BY "Cancelled", "AvgTicketPrice", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "Cancelled", "AvgTicketPrice") DESC, "Cancelled", "AvgTicketPrice", toInt64(toUnixTimestamp64Milli("@timestamp") / 30000)`,
 			`SELECT "Cancelled", "AvgTicketPrice", count() ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "Cancelled", "AvgTicketPrice" ` +
-				`ORDER BY "Cancelled", "AvgTicketPrice"`,
+				`ORDER BY sum(count()) OVER (PARTITION BY "Cancelled", "AvgTicketPrice") DESC, "Cancelled", "AvgTicketPrice"`,
 		},
 	},
 }
@@ -43,4 +43,5 @@ type FullSearchTestCase struct {
 	ExpectedResponse   string
 	ExpectedSQLs       []string
 	ExpectedSQLResults [][]model.QueryResultRow
+	LegacyTypes        bool // run with config's and table's legacyTypes on
 }
@@ -716,20 +716,20 @@ var AggregationTests = []testdata.AggregationTestCase{
 								"value": 1714687096297.0,
 								"value_as_string": "2024-05-02T21:58:16.297Z"
 							},
-							"bg_count": 2570,
+							"bg_count": 12832,
 							"doc_count": 2570,
 							"key": "200",
-							"score": 2570
+							"score": 0.010843301523130379
 						},
 						{
 							"1": {
 								"value": 1714665552949.0,
 								"value_as_string": "2024-05-02T15:59:12.949Z"
 							},
-							"bg_count": 94,
+							"bg_count": 240,
 							"doc_count": 94,
 							"key": "503",
-							"score": 94
+							"score": 0.033017328291888456
 						}
 					]
 				}
@@ -760,11 +760,19 @@ var AggregationTests = []testdata.AggregationTestCase{
 			{
 				{Cols: []model.QueryResultCol{
 					model.NewQueryResultCol("response", "200"),
+					model.NewQueryResultCol(`count()`, 12832),
+					model.NewQueryResultCol(`superset_size`, 14074),
+					model.NewQueryResultCol(`subset_size`, 2786),
 					model.NewQueryResultCol(`doc_count`, 2570),
+					model.NewQueryResultCol("score", 0.010843301523130379),
 				}},
 				{Cols: []model.QueryResultCol{
 					model.NewQueryResultCol("response", "503"),
+					model.NewQueryResultCol(`count()`, 240),
+					model.NewQueryResultCol(`superset_size`, 14074),
+					model.NewQueryResultCol(`subset_size`, 2786),
 					model.NewQueryResultCol(`doc_count`, 94),
+					model.NewQueryResultCol("score", 0.033017328291888456),
 				}},
 			},
 		},
@@ -778,10 +786,11 @@ var AggregationTests = []testdata.AggregationTestCase{
 				`AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:49:59.517Z')) ` +
 				`GROUP BY "response" ` +
 				`ORDER BY "response"`,
-			`SELECT "response", count() ` +
+			`SELECT "response", count(), sum(count()) OVER (), ` +
+				`sum(countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:49:59.517Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:49:59.517Z')))) OVER (), ` +
+				`countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:49:59.517Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:49:59.517Z'))), ` +
+				testdata.SignificantTermsScore(`countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:49:59.517Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:49:59.517Z')))`, `sum(countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:49:59.517Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:49:59.517Z')))) OVER ()`, "count()", "sum(count()) OVER ()") + ` ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
-				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-04-18T00:49:59.517Z') ` +
-				`AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:49:59.517Z')) ` +
 				`GROUP BY "response" ` +
 				`ORDER BY "response"`,
 		},
@@ -875,20 +884,20 @@ var AggregationTests = []testdata.AggregationTestCase{
 								"value": 1713659942912.0,
 								"value_as_string": "2024-04-21T00:39:02.912Z"
 							},
-							"bg_count": 2570,
+							"bg_count": 12832,
 							"doc_count": 2570,
 							"key": "200",
-							"score": 2570
+							"score": 0.010843301523130379
 						},
 						{
 							"1": {
 								"value": 1713670225131.0,
 								"value_as_string": "2024-04-21T03:30:25.131Z"
 							},
-							"bg_count": 94,
+							"bg_count": 240,
 							"doc_count": 94,
 							"key": "503",
-							"score": 94
+							"score": 0.033017328291888456
 						}
 					],
 					"doc_count": 2786
@@ -920,11 +929,19 @@ var AggregationTests = []testdata.AggregationTestCase{
 			{
 				{Cols: []model.QueryResultCol{
 					model.NewQueryResultCol("response", "200"),
+					model.NewQueryResultCol(`count()`, 12832),
+					model.NewQueryResultCol(`superset_size`, 14074),
+					model.NewQueryResultCol(`subset_size`, 2786),
 					model.NewQueryResultCol(`doc_count`, 2570),
+					model.NewQueryResultCol("score", 0.010843301523130379),
 				}},
 				{Cols: []model.QueryResultCol{
 					model.NewQueryResultCol("response", "503"),
+					model.NewQueryResultCol(`count()`, 240),
+					model.NewQueryResultCol(`superset_size`, 14074),
+					model.NewQueryResultCol(`subset_size`, 2786),
 					model.NewQueryResultCol(`doc_count`, 94),
+					model.NewQueryResultCol("score", 0.033017328291888456),
 				}},
 			},
 		},
@@ -938,10 +955,11 @@ var AggregationTests = []testdata.AggregationTestCase{
 				`AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:00.471Z')) ` +
 				`GROUP BY "response" ` +
 				`ORDER BY "response"`,
-			`SELECT "response", count() ` +
+			`SELECT "response", count(), sum(count()) OVER (), ` +
+				`sum(countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:00.471Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:00.471Z')))) OVER (), ` +
+				`countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:00.471Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:00.471Z'))), ` +
+				testdata.SignificantTermsScore(`countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:00.471Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:00.471Z')))`, `sum(countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:00.471Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:00.471Z')))) OVER ()`, "count()", "sum(count()) OVER ()") + ` ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
-				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:00.471Z') ` +
-				`AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:00.471Z')) ` +
 				`GROUP BY "response" ` +
 				`ORDER BY "response"`,
 		},
@@ -1072,10 +1090,10 @@ var AggregationTests = []testdata.AggregationTestCase{
 									}
 								]
 							},
-							"bg_count": 2570,
+							"bg_count": 12832,
 							"doc_count": 2570,
 							"key": "200",
-							"score": 2570
+							"score": 0.010843301523130379
 						}
 					],
 					"doc_count": 2786
@@ -1106,7 +1124,11 @@ var AggregationTests = []testdata.AggregationTestCase{
 			}}},
 			{{Cols: []model.QueryResultCol{
 				model.NewQueryResultCol("response", "200"),
+				model.NewQueryResultCol(`count()`, 12832),
+				model.NewQueryResultCol(`superset_size`, 14074),
+				model.NewQueryResultCol(`subset_size`, 2786),
 				model.NewQueryResultCol(`doc_count`, 2570),
+				model.NewQueryResultCol("score", 0.010843301523130379),
 			}}},
 		},
 		ExpectedSQLs: []string{
@@ -1126,9 +1148,11 @@ var AggregationTests = []testdata.AggregationTestCase{
 				`AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:15.845Z')) ` +
 				`GROUP BY "response" ` +
 				`ORDER BY "response"`,
-			`SELECT "response", count() FROM ` + testdata.QuotedTableName + ` ` +
-				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:15.845Z') ` +
-				`AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:15.845Z')) ` +
+			`SELECT "response", count(), sum(count()) OVER (), ` +
+				`sum(countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:15.845Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:15.845Z')))) OVER (), ` +
+				`countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:15.845Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:15.845Z'))), ` +
+				testdata.SignificantTermsScore(`countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:15.845Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:15.845Z')))`, `sum(countIf(("timestamp">=parseDateTime64BestEffort('2024-04-18T00:51:15.845Z') AND "timestamp"<=parseDateTime64BestEffort('2024-05-03T00:51:15.845Z')))) OVER ()`, "count()", "sum(count()) OVER ()") + ` ` +
+				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "response" ` +
 				`ORDER BY "response"`,
 		},
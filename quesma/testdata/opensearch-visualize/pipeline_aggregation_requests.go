@@ -2888,7 +2888,7 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-05-11T07:40:13.606Z') AND ` +
 				`"timestamp"<=parseDateTime64BestEffort('2024-05-11T22:40:13.606Z'))`,
 			`NoDBQuery`,
-			`SELECT "clientip", count() ` +
+			`SELECT "clientip", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`WHERE ("timestamp"<=parseDateTime64BestEffort('2024-05-11T22:40:13.606Z') ` +
 				`AND "timestamp">=parseDateTime64BestEffort('2024-05-11T07:40:13.606Z')) ` +
@@ -3463,7 +3463,7 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 				`WHERE ("timestamp">=parseDateTime64BestEffort('2024-04-27T21:56:51.264Z') AND ` +
 				`"timestamp"<=parseDateTime64BestEffort('2024-05-12T21:56:51.264Z'))`,
 			`NoDBQuery`,
-			`SELECT "Cancelled", count() ` +
+			`SELECT "Cancelled", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`WHERE ("timestamp"<=parseDateTime64BestEffort('2024-05-12T21:56:51.264Z') ` +
 				`AND "timestamp">=parseDateTime64BestEffort('2024-04-27T21:56:51.264Z')) ` +
@@ -4471,7 +4471,7 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 				`WHERE ("timestamp"<=parseDateTime64BestEffort('2024-05-12T22:16:26.906Z') ` +
 				`AND "timestamp">=parseDateTime64BestEffort('2024-04-27T22:16:26.906Z'))`,
 			`NoDBQuery`,
-			`SELECT "extension", count() ` +
+			`SELECT "extension", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`WHERE ("timestamp"<=parseDateTime64BestEffort('2024-05-12T22:16:26.906Z') ` +
 				`AND "timestamp">=parseDateTime64BestEffort('2024-04-27T22:16:26.906Z')) ` +
@@ -4567,7 +4567,7 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 							"bg_count": 224,
 							"doc_count": 224,
 							"key": "deb",
-							"score": 224
+							"score": 0
 						},
 						{
 							"1-metric": {
@@ -4576,7 +4576,7 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 							"bg_count": 225,
 							"doc_count": 225,
 							"key": "zip",
-							"score": 225
+							"score": 0
 						},
 						{
 							"1-metric": {
@@ -4585,7 +4585,7 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 							"bg_count": 76,
 							"doc_count": 76,
 							"key": "rpm",
-							"score": 76
+							"score": 0
 						}
 					],
 					"doc_count": 1865
@@ -4622,15 +4622,27 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 			{
 				{Cols: []model.QueryResultCol{
 					model.NewQueryResultCol("key", "deb"),
+					model.NewQueryResultCol("bg_count", 224),
+					model.NewQueryResultCol("superset_size", 1865),
+					model.NewQueryResultCol("subset_size", 1865),
 					model.NewQueryResultCol("doc_count", 224),
+					model.NewQueryResultCol("score", 0.0),
 				}},
 				{Cols: []model.QueryResultCol{
 					model.NewQueryResultCol("key", "zip"),
+					model.NewQueryResultCol("bg_count", 225),
+					model.NewQueryResultCol("superset_size", 1865),
+					model.NewQueryResultCol("subset_size", 1865),
 					model.NewQueryResultCol("doc_count", 225),
+					model.NewQueryResultCol("score", 0.0),
 				}},
 				{Cols: []model.QueryResultCol{
 					model.NewQueryResultCol("key", "rpm"),
+					model.NewQueryResultCol("bg_count", 76),
+					model.NewQueryResultCol("superset_size", 1865),
+					model.NewQueryResultCol("subset_size", 1865),
 					model.NewQueryResultCol("doc_count", 76),
+					model.NewQueryResultCol("score", 0.0),
 				}},
 			},
 		},
@@ -4642,7 +4654,8 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "extension" ` +
 				`ORDER BY "extension"`,
-			`SELECT "extension", count() ` +
+			`SELECT "extension", count(), sum(count()) OVER (), sum(count()) OVER (), count(), ` +
+				testdata.SignificantTermsScore("count()", "sum(count()) OVER ()", "count()", "sum(count()) OVER ()") + ` ` +
 				`FROM ` + testdata.QuotedTableName + ` ` +
 				`GROUP BY "extension" ` +
 				`ORDER BY "extension"`,
@@ -5286,4 +5299,327 @@ var PipelineAggregationTests = []testdata.AggregationTestCase{
 				`FROM ` + testdata.QuotedTableName,
 		},
 	},
+	{ // [26]
+		TestName: "avg/min/max/sum_bucket over histogram with a null bucket. Reproduce: Visualize -> Vertical Bar: Metrics: Average/Min/Max/Sum Bucket (Bucket: Histogram, Metric: Max)",
+		QueryRequestJson: `
+		{
+			"aggs": {
+				"1": {
+					"avg_bucket": {
+						"buckets_path": "1-bucket>1-metric"
+					}
+				},
+				"2": {
+					"min_bucket": {
+						"buckets_path": "1-bucket>1-metric"
+					}
+				},
+				"3": {
+					"max_bucket": {
+						"buckets_path": "1-bucket>1-metric"
+					}
+				},
+				"4": {
+					"sum_bucket": {
+						"buckets_path": "1-bucket>1-metric"
+					}
+				},
+				"1-bucket": {
+					"aggs": {
+						"1-metric": {
+							"max": {
+								"field": "memory"
+							}
+						}
+					},
+					"histogram": {
+						"field": "bytes",
+						"interval": 1,
+						"min_doc_count": 1
+					}
+				}
+			},
+			"size": 0,
+			"track_total_hits": true
+		}`,
+		ExpectedResponse: `
+		{
+			"_shards": {
+				"failed": 0,
+				"skipped": 0,
+				"successful": 1,
+				"total": 1
+			},
+			"aggregations": {
+				"1": {
+					"value": 6.0
+				},
+				"2": {
+					"keys": [
+						200.0
+					],
+					"value": 2.0
+				},
+				"3": {
+					"keys": [
+						100.0,
+						300.0
+					],
+					"value": 8.0
+				},
+				"4": {
+					"value": 18.0
+				},
+				"1-bucket": {
+					"buckets": [
+						{
+							"1-metric": {
+								"value": null
+							},
+							"doc_count": 2,
+							"key": 0.0
+						},
+						{
+							"1-metric": {
+								"value": 8.0
+							},
+							"doc_count": 3,
+							"key": 100.0
+						},
+						{
+							"1-metric": {
+								"value": 2.0
+							},
+							"doc_count": 1,
+							"key": 200.0
+						},
+						{
+							"1-metric": {
+								"value": 8.0
+							},
+							"doc_count": 4,
+							"key": 300.0
+						}
+					]
+				}
+			},
+			"hits": {
+				"hits": [],
+				"max_score": null,
+				"total": {
+					"relation": "eq",
+					"value": 10
+				}
+			},
+			"timed_out": false,
+			"took": 3
+		}`,
+		ExpectedResults: [][]model.QueryResultRow{
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("hits", uint64(10))}}},
+			{}, // NoDBQuery
+			{
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 0.0),
+					model.NewQueryResultCol(`maxOrNull("memory")`, nil),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 100.0),
+					model.NewQueryResultCol(`maxOrNull("memory")`, 8.0),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 200.0),
+					model.NewQueryResultCol(`maxOrNull("memory")`, 2.0),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 300.0),
+					model.NewQueryResultCol(`maxOrNull("memory")`, 8.0),
+				}},
+			},
+			{
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 0.0),
+					model.NewQueryResultCol("count()", 2),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 100.0),
+					model.NewQueryResultCol("count()", 3),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 200.0),
+					model.NewQueryResultCol("count()", 1),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol("bytes", 300.0),
+					model.NewQueryResultCol("count()", 4),
+				}},
+			},
+			{}, // NoDBQuery
+			{}, // NoDBQuery
+			{}, // NoDBQuery
+		},
+		ExpectedSQLs: []string{
+			`SELECT count() FROM ` + testdata.QuotedTableName,
+			`NoDBQuery`,
+			`SELECT "bytes", maxOrNull("memory") ` +
+				`FROM ` + testdata.QuotedTableName + ` ` +
+				`GROUP BY "bytes" ` +
+				`ORDER BY "bytes"`,
+			`SELECT "bytes", count() ` +
+				`FROM ` + testdata.QuotedTableName + ` ` +
+				`GROUP BY "bytes" ` +
+				`ORDER BY "bytes"`,
+			`NoDBQuery`,
+			`NoDBQuery`,
+			`NoDBQuery`,
+		},
+	},
+	{ // [27]
+		TestName: "Derivative with and without unit. Reproduce: Visualize -> Vertical Bar: Metrics: Derivative (Aggregation: Sum), Buckets: Date Histogram, and add unit: second",
+		QueryRequestJson: `
+		{
+			"aggs": {
+				"2": {
+					"aggs": {
+						"1": {
+							"derivative": {
+								"buckets_path": "1-metric"
+							}
+						},
+						"3": {
+							"derivative": {
+								"buckets_path": "1-metric",
+								"unit": "second"
+							}
+						},
+						"1-metric": {
+							"sum": {
+								"field": "bytes"
+							}
+						}
+					},
+					"date_histogram": {
+						"field": "timestamp",
+						"fixed_interval": "1m"
+					}
+				}
+			},
+			"size": 0,
+			"track_total_hits": true
+		}`,
+		ExpectedResponse: `
+		{
+			"_shards": {
+				"failed": 0,
+				"skipped": 0,
+				"successful": 1,
+				"total": 1
+			},
+			"aggregations": {
+				"2": {
+					"buckets": [
+						{
+							"1": {
+								"value": null
+							},
+							"3": {
+								"value": null
+							},
+							"1-metric": {
+								"value": 10.0
+							},
+							"doc_count": 1,
+							"key": 1715196000000,
+							"key_as_string": "2024-05-08T19:20:00.000"
+						},
+						{
+							"1": {
+								"value": 60.0
+							},
+							"3": {
+								"value": 60.0,
+								"normalized_value": 1.0
+							},
+							"1-metric": {
+								"value": 70.0
+							},
+							"doc_count": 5,
+							"key": 1715196060000,
+							"key_as_string": "2024-05-08T19:21:00.000"
+						},
+						{
+							"1": {
+								"value": -30.0
+							},
+							"3": {
+								"value": -30.0,
+								"normalized_value": -0.5
+							},
+							"1-metric": {
+								"value": 40.0
+							},
+							"doc_count": 3,
+							"key": 1715196120000,
+							"key_as_string": "2024-05-08T19:22:00.000"
+						}
+					]
+				}
+			},
+			"hits": {
+				"hits": [],
+				"max_score": null,
+				"total": {
+					"relation": "eq",
+					"value": 9
+				}
+			},
+			"timed_out": false,
+			"took": 3
+		}`,
+		ExpectedResults: [][]model.QueryResultRow{
+			{{Cols: []model.QueryResultCol{model.NewQueryResultCol("hits", uint64(9))}}},
+			{}, // NoDBQuery
+			{
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol(`toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`, int64(1715196000000/60000)),
+					model.NewQueryResultCol(`sumOrNull("bytes")`, 10.0),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol(`toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`, int64(1715196060000/60000)),
+					model.NewQueryResultCol(`sumOrNull("bytes")`, 70.0),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol(`toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`, int64(1715196120000/60000)),
+					model.NewQueryResultCol(`sumOrNull("bytes")`, 40.0),
+				}},
+			},
+			{}, // NoDBQuery
+			{
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol(`toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`, int64(1715196000000/60000)),
+					model.NewQueryResultCol("count()", 1),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol(`toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`, int64(1715196060000/60000)),
+					model.NewQueryResultCol("count()", 5),
+				}},
+				{Cols: []model.QueryResultCol{
+					model.NewQueryResultCol(`toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`, int64(1715196120000/60000)),
+					model.NewQueryResultCol("count()", 3),
+				}},
+			},
+		},
+		ExpectedSQLs: []string{
+			`SELECT count() FROM ` + testdata.QuotedTableName,
+			`NoDBQuery`,
+			`SELECT toInt64(toUnixTimestamp64Milli("timestamp") / 60000), sumOrNull("bytes") ` +
+				`FROM ` + testdata.QuotedTableName + ` ` +
+				`GROUP BY toInt64(toUnixTimestamp64Milli("timestamp") / 60000) ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`,
+			`NoDBQuery`,
+			`SELECT toInt64(toUnixTimestamp64Milli("timestamp") / 60000), count() ` +
+				`FROM ` + testdata.QuotedTableName + ` ` +
+				`GROUP BY toInt64(toUnixTimestamp64Milli("timestamp") / 60000) ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("timestamp") / 60000)`,
+		},
+	},
 }
@@ -1581,7 +1581,7 @@ var TestsSearch = []SearchTestCase{
 		//	justSimplestWhere(`("service.name"='admin' AND ("@timestamp">=parseDateTime64BestEffort('2024-01-22T14:34:35.873Z') AND "@timestamp"<=parseDateTime64BestEffort('2024-01-22T14:49:35.873Z')))`),
 		//},
 		[]string{
-			`SELECT "namespace", count() ` +
+			`SELECT "namespace", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + QuotedTableName + ` ` +
 				`WHERE ("service.name"='admin' ` +
 				`AND ("@timestamp".=parseDateTime64BestEffort('2024-01-22T14:..:35.873Z') ` +
@@ -1732,7 +1732,7 @@ var TestsSearch = []SearchTestCase{
 		//	justSimplestWhere(`("message" iLIKE '%user%' AND ("@timestamp">=parseDateTime64BestEffort('2024-01-22T09:26:10.299Z') AND "@timestamp"<=parseDateTime64BestEffort('2024-01-22T09:41:10.299Z')))`),
 		//},
 		[]string{
-			`SELECT "namespace", count() ` +
+			`SELECT "namespace", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + QuotedTableName + ` ` +
 				`WHERE ("message" iLIKE '%user%' ` +
 				`AND ("@timestamp">=parseDateTime64BestEffort('2024-01-22T09:26:10.299Z') ` +
@@ -1813,7 +1813,7 @@ var TestsSearch = []SearchTestCase{
 		//	justSimplestWhere(`(("message" iLIKE '%User logged out%' AND "host.name" iLIKE '%poseidon%') AND ("@timestamp">=parseDateTime64BestEffort('2024-01-29T15:36:36.491Z') AND "@timestamp"<=parseDateTime64BestEffort('2024-01-29T18:11:36.491Z')))`),
 		//},
 		[]string{
-			`SELECT "namespace", count() ` +
+			`SELECT "namespace", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + QuotedTableName + ` ` +
 				`WHERE (("message" iLIKE '%User logged out%' AND "host.name" iLIKE '%poseidon%') ` +
 				`AND ("@timestamp">=parseDateTime64BestEffort('2024-01-29T15:36:36.491Z') ` +
@@ -1891,7 +1891,7 @@ var TestsSearch = []SearchTestCase{
 		//	justSimplestWhere(`("message" iLIKE '%user%' AND ("@timestamp">=parseDateTime64BestEffort('2024-01-22T09:26:10.299Z') AND "@timestamp"<=parseDateTime64BestEffort('2024-01-22T09:41:10.299Z')))`),
 		//},
 		[]string{
-			`SELECT "namespace", count() ` +
+			`SELECT "namespace", count(), sum(count()) OVER () AS "total_doc_count" ` +
 				`FROM ` + QuotedTableName + ` ` +
 				`WHERE ("message" iLIKE '%user%' ` +
 				`AND ("@timestamp">=parseDateTime64BestEffort('2024-01-22T09:26:10.299Z') ` +
@@ -2251,12 +2251,12 @@ var TestsSearch = []SearchTestCase{
 			},
 			"track_total_hits": false
 		}`,
-		[]string{`"field" REGEXP 'a*-abb-all-li.mit.*s-5'`},
+		[]string{`"field" REGEXP '^(?:a*-abb-all-li.mit.*s-5)$'`},
 		model.ListAllFields,
 		[]string{
 			`SELECT "message" ` +
 				`FROM ` + QuotedTableName + ` ` +
-				`WHERE "field" REGEXP 'a*-abb-all-li.mit.*s-5' ` +
+				`WHERE "field" REGEXP '\^(\?:a*-abb-all-li.mit.*s-5)\$' ` +
 				`LIMIT 10`,
 		},
 	},
@@ -2278,12 +2278,12 @@ var TestsSearch = []SearchTestCase{
 			},
 			"track_total_hits": false
 		}`,
-		[]string{`"field" REGEXP 'a?'`},
+		[]string{`"field" REGEXP '^(?:a?)$'`},
 		model.ListAllFields,
 		[]string{
 			`SELECT "message" ` +
 				`FROM ` + QuotedTableName + ` ` +
-				`WHERE "field" REGEXP 'a\?' ` +
+				`WHERE "field" REGEXP '\^(\?:a\?)\$' ` +
 				`LIMIT 10`,
 		},
 	},
@@ -155,8 +155,8 @@ var AggregationTestsWithSpecialCharactersInFieldNames = []AggregationTestCase{
 				`AS "row_number", "message\$\*\%\:\;" FROM ` + QuotedTableName + ` WHERE "message\$\*\%\:\;" IS NOT NULL) ` +
 				`WHERE ("message\$\*\%\:\;" IS NOT NULL ` +
 				`AND "row_number"<=1) ` +
-				`GROUP BY toInt64(toUnixTimestamp64Milli("-@timestamp") / 43200000) ` +
-				`ORDER BY toInt64(toUnixTimestamp64Milli("-@timestamp") / 43200000)`,
+				`GROUP BY toInt64(toUnixTimestamp64Milli("-@timestamp") / 43200000), "row_number" ` +
+				`ORDER BY toInt64(toUnixTimestamp64Milli("-@timestamp") / 43200000), "row_number" ASC`,
 			`SELECT toInt64(toUnixTimestamp64Milli("-@timestamp") / 43200000), count() FROM ` + QuotedTableName + ` ` +
 				`WHERE "message\$\*\%\:\;\" IS NOT NULL ` +
 				`GROUP BY toInt64(toUnixTimestamp64Milli("-@timestamp") / 43200000) ` +
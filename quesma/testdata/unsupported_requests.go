@@ -102,34 +102,6 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 		}`,
 	},
 	{ // [5]
-		TestName:  "bucket aggregation: diversified_sampler",
-		QueryType: "diversified_sampler",
-		QueryRequestJson: `
-		{
-			"query": {
-				"query_string": {
-					"query": "tags:elasticsearch"
-				}
-			},
-			"aggs": {
-				"my_unbiased_sample": {
-					"diversified_sampler": {
-						"shard_size": 200,
-						"field": "author"
-					},
-					"aggs": {
-						"keywords": {
-							"significant_terms": {
-								"field": "tags",
-								"exclude": [ "elasticsearch" ]
-							}
-						}
-					}
-				}
-			}
-		}`,
-	},
-	{ // [6]
 		TestName:  "bucket aggregation: frequent_item_sets",
 		QueryType: "frequent_item_sets",
 		QueryRequestJson: `
@@ -154,7 +126,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [7]
+	{ // [6]
 		TestName:  "bucket aggregation: geo_distance",
 		QueryType: "geo_distance",
 		QueryRequestJson: `
@@ -175,26 +147,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [8]
-		TestName:  "bucket aggregation: geohash_grid",
-		QueryType: "geohash_grid",
-		QueryRequestJson: `
-		{
-			"aggs": {
-				"zoomed-in": {
-					"aggs": {
-						"zoom1": {
-							"geohash_grid": {
-								"field": "location",
-								"precision": 8
-							}
-						}
-					}
-				}
-			}
-		}`,
-	},
-	{ // [9]
+	{ // [7]
 		TestName:  "bucket aggregation: geohex_grid",
 		QueryType: "geohex_grid",
 		QueryRequestJson: `
@@ -213,7 +166,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [11]
+	{ // [9]
 		TestName:  "bucket aggregation: global",
 		QueryType: "global",
 		QueryRequestJson: `
@@ -232,7 +185,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [12]
+	{ // [10]
 		TestName:  "bucket aggregation: ip_prefix",
 		QueryType: "ip_prefix",
 		QueryRequestJson: `
@@ -248,7 +201,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [13]
+	{ // [11]
 		TestName:  "bucket aggregation: ip_range",
 		QueryType: "ip_range",
 		QueryRequestJson: `
@@ -267,45 +220,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [14]
-		TestName:  "bucket aggregation: missing",
-		QueryType: "missing",
-		QueryRequestJson: `
-		{
-			"aggs": {
-				"products_without_a_price": {
-					"missing": { "field": "price" }
-				}
-			}
-		}`,
-	},
-	{ // [16]
-		TestName:  "bucket aggregation: nested",
-		QueryType: "nested",
-		QueryRequestJson: `
-		{
-			"query": {
-				"match": {
-					"name": "led tv"
-				}
-			},
-			"aggs": {
-				"resellers": {
-					"nested": {
-						"path": "resellers"
-					},
-					"aggs": {
-						"min_price": {
-							"min": {
-								"field": "resellers.price"
-							}
-						}
-					}
-				}
-			}
-		}`,
-	},
-	{ // [17]
+	{ // [15]
 		TestName:  "bucket aggregation: parent",
 		QueryType: "parent",
 		QueryRequestJson: `
@@ -335,21 +250,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [18]
-		TestName:  "bucket aggregation: rare_terms",
-		QueryType: "rare_terms",
-		QueryRequestJson: `
-		{
-			"aggs": {
-				"genres": {
-					"rare_terms": {
-						"field": "genre"
-					}
-				}
-			}
-		}`,
-	},
-	{ // [19]
+	{ // [16]
 		TestName:  "bucket aggregation: reverse_nested",
 		QueryType: "reverse_nested",
 		QueryRequestJson: `
@@ -382,7 +283,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [20]
+	{ // [17]
 		TestName:  "bucket aggregation: significant_text",
 		QueryType: "significant_text",
 		QueryRequestJson: `
@@ -404,7 +305,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [21]
+	{ // [18]
 		TestName:  "bucket aggregation: time_series",
 		QueryType: "time_series",
 		QueryRequestJson: `
@@ -416,38 +317,8 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [22]
-		TestName:  "bucket aggregation: variable_width_histogram",
-		QueryType: "variable_width_histogram",
-		QueryRequestJson: `
-		{
-			"aggs": {
-				"prices": {
-					"variable_width_histogram": {
-						"field": "price",
-						"buckets": 2
-					}
-				}
-			}
-		}`,
-	},
 	// metrics:
-	{ // [23]
-		TestName:  "metrics aggregation: boxplot",
-		QueryType: "boxplot",
-		QueryRequestJson: `
-		{
-			"size": 0,
-			"aggs": {
-				"load_time_boxplot": {
-					"boxplot": {
-						"field": "load_time" 
-					}
-				}
-			}
-		}`,
-	},
-	{ // [25]
+	{ // [22]
 		TestName:  "metrics aggregation: geo_bounds",
 		QueryType: "geo_bounds",
 		QueryRequestJson: `
@@ -461,7 +332,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [27]
+	{ // [24]
 		TestName:  "metrics aggregation: geo_line",
 		QueryType: "geo_line",
 		QueryRequestJson: `
@@ -476,7 +347,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [28]
+	{ // [25]
 		TestName:  "metrics aggregation: cartesian_bounds",
 		QueryType: "cartesian_bounds",
 		QueryRequestJson: `
@@ -493,7 +364,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [29]
+	{ // [26]
 		TestName:  "metrics aggregation: cartesian_centroid",
 		QueryType: "cartesian_centroid",
 		QueryRequestJson: `
@@ -507,7 +378,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [30]
+	{ // [27]
 		TestName:  "metrics aggregation: matrix_stats",
 		QueryType: "matrix_stats",
 		QueryRequestJson: `
@@ -521,7 +392,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [31]
+	{ // [28]
 		TestName:  "metrics aggregation: median_absolute_deviation",
 		QueryType: "median_absolute_deviation",
 		QueryRequestJson: `
@@ -541,7 +412,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [32]
+	{ // [29]
 		TestName:  "metrics aggregation: rate",
 		QueryType: "rate",
 		QueryRequestJson: `
@@ -564,7 +435,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [33]
+	{ // [30]
 		TestName:  "metrics aggregation: scripted_metric",
 		QueryType: "scripted_metric",
 		QueryRequestJson: `
@@ -584,7 +455,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [34]
+	{ // [31]
 		TestName:  "metrics aggregation: string_stats",
 		QueryType: "string_stats",
 		QueryRequestJson: `
@@ -594,7 +465,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [35]
+	{ // [32]
 		TestName:  "metrics aggregation: t_test",
 		QueryType: "t_test",
 		QueryRequestJson: `
@@ -611,7 +482,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [36]
+	{ // [33]
 		TestName:  "metrics aggregation: weighted_avg",
 		QueryType: "weighted_avg",
 		QueryRequestJson: `
@@ -633,7 +504,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 	},
 
 	// pipeline:
-	{ // [38]
+	{ // [35]
 		TestName:  "pipeline aggregation: bucket_count_ks_test",
 		QueryType: "bucket_count_ks_test",
 		QueryRequestJson: `
@@ -665,7 +536,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [39]
+	{ // [36]
 		TestName:  "pipeline aggregation: bucket_correlation",
 		QueryType: "bucket_correlation",
 		QueryRequestJson: `
@@ -706,7 +577,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [40]
+	{ // [37]
 		TestName:  "pipeline aggregation: bucket_selector",
 		QueryType: "bucket_selector",
 		QueryRequestJson: `
@@ -737,7 +608,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [41]
+	{ // [38]
 		TestName:  "pipeline aggregation: bucket_sort",
 		QueryType: "bucket_sort",
 		QueryRequestJson: `
@@ -768,7 +639,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [42]
+	{ // [39]
 		TestName:  "pipeline aggregation: change_point",
 		QueryType: "change_point",
 		QueryRequestJson: `
@@ -795,7 +666,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [43]
+	{ // [40]
 		TestName:  "pipeline aggregation: cumulative_cardinality",
 		QueryType: "cumulative_cardinality",
 		QueryRequestJson: `
@@ -823,7 +694,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [46]
+	{ // [43]
 		TestName:  "pipeline aggregation: extended_stats_bucket",
 		QueryType: "extended_stats_bucket",
 		QueryRequestJson: `
@@ -851,7 +722,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [47]
+	{ // [44]
 		TestName:  "pipeline aggregation: inference",
 		QueryType: "inference",
 		QueryRequestJson: `
@@ -926,7 +797,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [50]
+	{ // [47]
 		TestName:  "pipeline aggregation: moving_fn",
 		QueryType: "moving_fn",
 		QueryRequestJson: `
@@ -954,7 +825,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [51]
+	{ // [48]
 		TestName:  "pipeline aggregation: moving_percentiles",
 		QueryType: "moving_percentiles",
 		QueryRequestJson: `
@@ -984,7 +855,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [52]
+	{ // [49]
 		TestName:  "pipeline aggregation: normalize",
 		QueryType: "normalize",
 		QueryRequestJson: `
@@ -1014,7 +885,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [53]
+	{ // [50]
 		TestName:  "pipeline aggregation: percentiles_bucket",
 		QueryType: "percentiles_bucket",
 		QueryRequestJson: `
@@ -1043,7 +914,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [55]
+	{ // [52]
 		TestName:  "pipeline aggregation: stats_bucket",
 		QueryType: "stats_bucket",
 		QueryRequestJson: `
@@ -1072,7 +943,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 		}`,
 	},
 	// random non-existing aggregation:
-	{ // [57]
+	{ // [54]
 		TestName:  "non-existing aggregation: Augustus_Caesar",
 		QueryType: ui.UnrecognizedQueryType,
 		QueryRequestJson: `
@@ -1096,7 +967,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 	},
 
 	// Query DSL Tests:
-	{ // [58]
+	{ // [55]
 		TestName:  "Compound query: boosting",
 		QueryType: "boosting",
 		QueryRequestJson: `
@@ -1118,7 +989,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [60]
+	{ // [57]
 		TestName:  "Compound query: disjunction_max",
 		QueryType: "dis_max",
 		QueryRequestJson: `
@@ -1134,7 +1005,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [61]
+	{ // [58]
 		TestName:  "Compound query: function score",
 		QueryType: "function_score",
 		QueryRequestJson: `
@@ -1149,40 +1020,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [62]
-		TestName:  "Full text queries: intervals",
-		QueryType: "intervals",
-		QueryRequestJson: `
-		{
-			"query": {
-				"intervals" : {
-					"my_text" : {
-						"all_of" : {
-							"ordered" : true,
-							"intervals" : [
-								{
-									"match" : {
-										"query" : "my favorite food",
-										"max_gaps" : 0,
-										"ordered" : true
-									}
-								},
-								{
-									"any_of" : {
-										"intervals" : [
-					  						{ "match" : { "query" : "hot water" } },
-					  						{ "match" : { "query" : "cold porridge" } }
-										]
-									}
-								}
-							]
-						}
-					}
-				}
-			}
-		}`,
-	},
-	{ // [63]
+	{ // [60]
 		TestName:  "Full text queries: match_bool_prefix",
 		QueryType: "match_bool_prefix",
 		QueryRequestJson: `
@@ -1194,7 +1032,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [64]
+	{ // [61]
 		TestName:  "Full text queries: match_phrase_prefix",
 		QueryType: "match_phrase_prefix",
 		QueryRequestJson: `
@@ -1208,21 +1046,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [65]
-		TestName:  "Full text queries: combined fields",
-		QueryType: "combined_fields",
-		QueryRequestJson: `
-		{
-			"query": {
-				"combined_fields" : {
-					"query":      "database systems",
-					"fields":     [ "title", "abstract", "body"],
-					"operator":   "and"
-				}
-			}
-		}`,
-	},
-	{ // [67]
+	{ // [64]
 		TestName:  "Geo queries: Geo-grid",
 		QueryType: "geo_grid",
 		QueryRequestJson: `
@@ -1236,32 +1060,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [68]
-		TestName:  "Geo queries: Geo-polygon",
-		QueryType: "geo_polygon",
-		QueryRequestJson: `
-		{
-			"query": {
-				"bool": {
-					"must": {
-						"match_all": {}
-					},
-					"filter": {
-						"geo_polygon": {
-							"person.location": {
-								"points": [
-									{ "lat": 40, "lon": -70 },
-									{ "lat": 30, "lon": -80 },
-									{ "lat": 20, "lon": -90 }
-								]
-							}
-						}
-					}
-				}
-			}
-		}`,
-	},
-	{ // [69]
+	{ // [65]
 		TestName:  "Geo queries: geoshape",
 		QueryType: "geo_shape",
 		QueryRequestJson: `
@@ -1286,7 +1085,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [70]
+	{ // [66]
 		TestName:  "Shape",
 		QueryType: "shape",
 		QueryRequestJson: `
@@ -1304,7 +1103,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [71]
+	{ // [67]
 		TestName:  "Joining queries: Has child",
 		QueryType: "has_child",
 		QueryRequestJson: `
@@ -1322,7 +1121,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [72]
+	{ // [68]
 		TestName:  "Joining queries: Has parent",
 		QueryType: "has_parent",
 		QueryRequestJson: `
@@ -1341,7 +1140,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [73]
+	{ // [69]
 		TestName:  "Joining queries: Parent id",
 		QueryType: "parent_id",
 		QueryRequestJson: `
@@ -1354,7 +1153,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [74]
+	{ // [70]
 		TestName:  "Span queries: Span containing",
 		QueryType: "span_containing",
 		QueryRequestJson: `
@@ -1378,7 +1177,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [75]
+	{ // [71]
 		TestName:  "Span queries: Span field masking",
 		QueryType: "span_field_masking",
 		QueryRequestJson: `
@@ -1401,7 +1200,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [76]
+	{ // [72]
 		TestName:  "Span queries: Span first",
 		QueryType: "span_first",
 		QueryRequestJson: `
@@ -1416,7 +1215,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [77]
+	{ // [73]
 		TestName:  "Span queries: Span multi-term",
 		QueryType: "span_multi",
 		QueryRequestJson: `
@@ -1430,7 +1229,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [78]
+	{ // [74]
 		TestName:  "Span queries: Span near",
 		QueryType: "span_near",
 		QueryRequestJson: `
@@ -1448,7 +1247,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [79]
+	{ // [75]
 		TestName:  "Span queries: Span not",
 		QueryType: "span_not",
 		QueryRequestJson: `
@@ -1472,7 +1271,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [80]
+	{ // [76]
 		TestName:  "Span queries: Span or",
 		QueryType: "span_or",
 		QueryRequestJson: `
@@ -1488,7 +1287,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [81]
+	{ // [77]
 		TestName:  "Span queries: Span term",
 		QueryType: "span_term",
 		QueryRequestJson: `
@@ -1498,7 +1297,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [82]
+	{ // [78]
 		TestName:  "Span queries: Span within",
 		QueryType: "span_within",
 		QueryRequestJson: `
@@ -1522,31 +1321,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [83]
-		TestName:  "Specialized queries: Distance feature",
-		QueryType: "distance_feature",
-		QueryRequestJson: `
-		{
-			"query": {
-				"bool": {
-					"must": {
-						"match": {
-							"name": "chocolate"
-						}
-					},
-					"should": {
-						"distance_feature": {
-							"field": "production_date",
-							"pivot": "7d",
-							"origin": "now"
-						}
-					},
-					"minimum_should_match": 1
-				}
-			}
-		}`,
-	},
-	{ // [84]
+	{ // [80]
 		TestName:  "Specialized queries: More like this",
 		QueryType: "more_like_this",
 		QueryRequestJson: `
@@ -1571,7 +1346,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [85]
+	{ // [81]
 		TestName:  "Specialized queries: Percolate",
 		QueryType: "percolate",
 		QueryRequestJson: `
@@ -1586,7 +1361,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [86]
+	{ // [82]
 		TestName:  "Specialized queries: Knn",
 		QueryType: "knn",
 		QueryRequestJson: `
@@ -1601,7 +1376,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [87]
+	{ // [83]
 		TestName:  "Specialized queries: Rank feature",
 		QueryType: "rank_feature",
 		QueryRequestJson: `
@@ -1627,7 +1402,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [88]
+	{ // [84]
 		TestName:  "Specialized queries: Script",
 		QueryType: "script",
 		QueryRequestJson: `
@@ -1649,7 +1424,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [89]
+	{ // [85]
 		TestName:  "Specialized queries: Script score",
 		QueryType: "script_score",
 		QueryRequestJson: `
@@ -1666,7 +1441,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [90]
+	{ // [86]
 		TestName:  "Specialized queries: Wrapper",
 		QueryType: "wrapper",
 		QueryRequestJson: `
@@ -1678,7 +1453,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [91]
+	{ // [87]
 		TestName:  "Specialized queries: Pinned query",
 		QueryType: "pinned",
 		QueryRequestJson: `
@@ -1695,7 +1470,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [92]
+	{ // [88]
 		TestName:  "Specialized queries: Rule",
 		QueryType: "rule_query",
 		QueryRequestJson: `
@@ -1715,7 +1490,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [93]
+	{ // [89]
 		TestName:  "Specialized queries: Weighted tokens",
 		QueryType: "weighted_tokens",
 		QueryRequestJson: `
@@ -1734,7 +1509,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	{ // [94]
+	{ // [90]
 		TestName:  "Term-level queries: Fuzzy",
 		QueryType: "fuzzy",
 		QueryRequestJson: `
@@ -1748,7 +1523,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 			}
 		}`,
 	},
-	//{ // [95]
+	//{ // [91]
 	//	The query is partially supported, doesn't blow up,
 	// 	but the response is not as expected due to the nature of the backend (ClickHouse).
 	//	TestName:  "Term-level queries: IDs",
@@ -1762,7 +1537,7 @@ var UnsupportedQueriesTests = []UnsupportedQueryTestCase{
 	//		}
 	//	}`,
 	//},
-	{ // [97]
+	{ // [93]
 		TestName:  "Term-level queries: Terms set",
 		QueryType: "terms_set",
 		QueryRequestJson: `
@@ -54,3 +54,11 @@ func EscapeBrackets(s string) string {
 func EscapeWildcard(s string) string {
 	return strings.ReplaceAll(s, "*", `\*`)
 }
+
+// SignificantTermsScore returns SQL of significant_terms' score (JLH heuristic) of a term, given SQL of its counts
+func SignificantTermsScore(foregroundCount, subsetSize, backgroundCount, supersetSize string) string {
+	foregroundPercentage := "divide(" + foregroundCount + "," + subsetSize + ")"
+	backgroundPercentage := "divide(" + backgroundCount + "," + supersetSize + ")"
+	return "greatest(multiply(minus(" + foregroundPercentage + "," + backgroundPercentage + "),divide(" +
+		foregroundPercentage + "," + backgroundPercentage + ")),0)"
+}
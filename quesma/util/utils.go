@@ -12,6 +12,7 @@ import (
 	"github.com/k0kubun/pp"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"quesma/logger"
 	"reflect"
@@ -659,6 +660,24 @@ func ExtractInt64Maybe(value any) (asInt64 int64, success bool) {
 	return -1, false
 }
 
+// ExtractCount returns count-like `value` (e.g. doc_count) as int64, also if it's a (*)float or json.Number
+// (e.g. a sum of counts or a value after JSON round-tripping), so it's rendered as an integer, never like 1.0E7.
+// Other values (e.g. nil) are returned as they are.
+func ExtractCount(value any) any {
+	if asInt64, success := ExtractInt64Maybe(value); success {
+		return asInt64
+	}
+	if asNumber, ok := value.(json.Number); ok {
+		if asInt64, err := asNumber.Int64(); err == nil {
+			return asInt64
+		}
+	}
+	if asFloat64, success := ExtractFloat64Maybe(value); success {
+		return int64(math.Round(asFloat64))
+	}
+	return value
+}
+
 // ExtractFloat64 returns float64 value behind `value`:
 // * value,  if it's float64/32
 // * *value, if it's *float64/32
@@ -693,6 +712,10 @@ func ExtractFloat64Maybe(value any) (asFloat64 float64, success bool) {
 		return float64(valueTyped), true
 	case *float32:
 		return float64(*valueTyped), true
+	case json.Number: // e.g. Decimal values from ClickHouse
+		if asFloat64, err := valueTyped.Float64(); err == nil {
+			return asFloat64, true
+		}
 	}
 	return -1, false
 }
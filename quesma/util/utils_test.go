@@ -762,3 +762,25 @@ func TestExtractInt64(t *testing.T) {
 		assert.False(t, success)
 	}
 }
+
+func TestExtractCount(t *testing.T) {
+	count := uint64(12345678901)
+	tests := []struct {
+		v    any
+		want any
+	}{
+		{uint64(12345678901), int64(12345678901)},
+		{&count, int64(12345678901)},
+		{float64(10000000), int64(10000000)},
+		{float64(9007199254740993), int64(9007199254740992)}, // float64 has already lost precision
+		{json.Number("9007199254740993"), int64(9007199254740993)},
+		{nil, nil},
+		{"not a count", "not a count"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ExtractCount(tt.v))
+	}
+	marshalled, err := json.Marshal(map[string]any{"doc_count": ExtractCount(float64(12345678901234))})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"doc_count":12345678901234}`, string(marshalled))
+}